@@ -0,0 +1,221 @@
+//go:build js && wasm
+
+// apkeditor-wasm 把签名, 校验和大小分析导出给浏览器中的 JavaScript.
+// 加载后在全局对象上注册 apkEditor, 所有函数都返回 Promise:
+//
+//	apkEditor.sign(apk: Uint8Array, keyPem: Uint8Array|string, certPem: Uint8Array|string) -> Uint8Array
+//	apkEditor.verify(apk: Uint8Array) -> object
+//	apkEditor.inspect(apk: Uint8Array) -> object
+//
+// 构建: make wasm
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"syscall/js"
+
+	"github.com/pzx521521/apk-editor/editor"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+)
+
+func main() {
+	js.Global().Set("apkEditor", js.ValueOf(map[string]any{
+		"sign":    promise(sign),
+		"verify":  promise(verify),
+		"inspect": promise(inspect),
+	}))
+	// 保持运行, 否则导出的函数在 main 返回后失效
+	select {}
+}
+
+// promise 包装 f 为返回 Promise 的 JS 函数. f 在单独的 goroutine 中执行, 不阻塞事件循环.
+func promise(f func(args []js.Value) (any, error)) js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) any {
+		handler := js.FuncOf(func(this js.Value, cb []js.Value) any {
+			resolve, reject := cb[0], cb[1]
+			go func() {
+				v, err := f(args)
+				if err != nil {
+					reject.Invoke(js.Global().Get("Error").New(err.Error()))
+					return
+				}
+				resolve.Invoke(v)
+			}()
+			return nil
+		})
+		p := js.Global().Get("Promise").New(handler)
+		handler.Release()
+		return p
+	})
+}
+
+// bytesArg 读取 Uint8Array 或字符串参数
+func bytesArg(args []js.Value, i int, name string) ([]byte, error) {
+	if i >= len(args) {
+		return nil, errors.New("missing argument " + name)
+	}
+	v := args[i]
+	if v.Type() == js.TypeString {
+		return []byte(v.String()), nil
+	}
+	if !v.InstanceOf(js.Global().Get("Uint8Array")) {
+		return nil, errors.New(name + " must be a Uint8Array")
+	}
+	b := make([]byte, v.Get("length").Int())
+	js.CopyBytesToGo(b, v)
+	return b, nil
+}
+
+// toJS 通过 JSON 把 v 转为普通的 JS 对象
+func toJS(v any) (any, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return js.Global().Get("JSON").Call("parse", string(b)), nil
+}
+
+func sign(args []js.Value) (any, error) {
+	apk, err := bytesArg(args, 0, "apk")
+	if err != nil {
+		return nil, err
+	}
+	key, err := bytesArg(args, 1, "keyPem")
+	if err != nil {
+		return nil, err
+	}
+	cert, err := bytesArg(args, 2, "certPem")
+	if err != nil {
+		return nil, err
+	}
+	// 库的警告输出到浏览器控制台, 而不是 stderr
+	opts := []signv2.Option{signv2.WithLogger(log.New(console{"warn"}, "apkeditor: ", 0))}
+	z, err := signv2.NewApkSignNoCopy(apk, opts...)
+	if err != nil {
+		return nil, err
+	}
+	// SignV2To 不对齐, 未对齐时先对齐, 之后只保留对齐后的副本
+	if errors.Is(z.CheckAlignment(0), signv2.ErrMisaligned) {
+		aligned, err := signv2.Align(apk, 0)
+		if err != nil {
+			return nil, err
+		}
+		if z, err = signv2.NewApkSignNoCopy(aligned, opts...); err != nil {
+			return nil, err
+		}
+	}
+	// 签名结果直接写入 JS 的 Uint8Array, wasm 内存中不再保留第二份完整的 apk.
+	// 密钥类型由 PEM 内容判断
+	out := newJSBuffer(len(apk) + 64<<10)
+	err = z.SignV2To(out, []*signv2.SigningCert{{
+		SigningKey: signv2.SigningKey{KeyBytes: key},
+		CertBytes:  cert,
+	}}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out.bytes(), nil
+}
+
+// jsBuffer 是写入 JS Uint8Array 的 io.Writer, 容量不足时按倍数扩大
+type jsBuffer struct {
+	buf js.Value
+	n   int
+}
+
+func newJSBuffer(size int) *jsBuffer {
+	return &jsBuffer{buf: js.Global().Get("Uint8Array").New(size)}
+}
+
+func (b *jsBuffer) Write(p []byte) (int, error) {
+	if need := b.n + len(p); need > b.buf.Length() {
+		grown := js.Global().Get("Uint8Array").New(max(need, 2*b.buf.Length()))
+		grown.Call("set", b.buf.Call("subarray", 0, b.n))
+		b.buf = grown
+	}
+	js.CopyBytesToJS(b.buf.Call("subarray", b.n, b.n+len(p)), p)
+	b.n += len(p)
+	return len(p), nil
+}
+
+// bytes 返回已写入部分的视图, 不复制
+func (b *jsBuffer) bytes() js.Value {
+	return b.buf.Call("subarray", 0, b.n)
+}
+
+// console 把每次写入作为一行输出到 console 的 method 方法
+type console struct{ method string }
+
+func (c console) Write(p []byte) (int, error) {
+	js.Global().Get("console").Call(c.method, strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+// verification 是 VerificationResult 的 JSON 形式, 错误转为字符串, 证书只保留主题和摘要
+type verification struct {
+	Verified bool     `json:"verified"`
+	Schemes  []scheme `json:"schemes"`
+	Signers  []signer `json:"signers"`
+	Debug    bool     `json:"debug_signed"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+type scheme struct {
+	Scheme   string `json:"scheme"`
+	Present  bool   `json:"present"`
+	Verified bool   `json:"verified"`
+	Error    string `json:"error,omitempty"`
+}
+
+type signer struct {
+	Scheme string   `json:"scheme"`
+	Certs  []string `json:"certs"`
+	SHA256 []string `json:"sha256"`
+}
+
+func verify(args []js.Value) (any, error) {
+	apk, err := bytesArg(args, 0, "apk")
+	if err != nil {
+		return nil, err
+	}
+	z, err := signv2.NewApkSignNoCopy(apk)
+	if err != nil {
+		return nil, err
+	}
+	res := z.VerifyAll(signv2.VerifyOptions{})
+	v := verification{Verified: res.Verified, Debug: res.DebugSigned, Warnings: res.Warnings}
+	for _, s := range res.Schemes {
+		sr := scheme{Scheme: string(s.Scheme), Present: s.Present, Verified: s.Verified}
+		if s.Err != nil {
+			sr.Error = s.Err.Error()
+		}
+		v.Schemes = append(v.Schemes, sr)
+	}
+	for _, s := range res.Signers {
+		sr := signer{Scheme: string(s.Scheme)}
+		for _, c := range s.Certs {
+			sr.Certs = append(sr.Certs, c.Subject.String())
+			sum := sha256.Sum256(c.Raw)
+			sr.SHA256 = append(sr.SHA256, hex.EncodeToString(sum[:]))
+		}
+		v.Signers = append(v.Signers, sr)
+	}
+	return toJS(v)
+}
+
+func inspect(args []js.Value) (any, error) {
+	apk, err := bytesArg(args, 0, "apk")
+	if err != nil {
+		return nil, err
+	}
+	report, err := editor.Analyze(apk)
+	if err != nil {
+		return nil, err
+	}
+	return toJS(report)
+}
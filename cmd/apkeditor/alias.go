@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// aliases maps a user defined command name to the arguments it expands to.
+// They are read from $APKEDITOR_ALIASES or <UserConfigDir>/apkeditor/aliases,
+// one per line:
+//
+//	# 常用的签名命令
+//	rs = sign -key ~/keys/release.pem -cert ~/keys/release.crt
+type aliases map[string][]string
+
+func aliasFile() string {
+	if p := os.Getenv("APKEDITOR_ALIASES"); p != "" {
+		return p
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "apkeditor", "aliases")
+}
+
+func loadAliases() (aliases, error) {
+	a := aliases{}
+	path := aliasFile()
+	if path == "" {
+		return a, nil
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, expansion, ok := strings.Cut(text, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("%s:%d: expected \"name = command args...\"", path, line)
+		}
+		a[name] = expandHome(strings.Fields(expansion))
+	}
+	return a, s.Err()
+}
+
+// expand replaces a leading alias with its definition. Built-in commands
+// cannot be shadowed, and expansions are not expanded again.
+func (a aliases) expand(args []string) []string {
+	for _, c := range commands {
+		if c.name == args[0] {
+			return args
+		}
+	}
+	if exp, ok := a[args[0]]; ok && len(exp) > 0 {
+		return append(append([]string{}, exp...), args[1:]...)
+	}
+	return args
+}
+
+func expandHome(fields []string) []string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return fields
+	}
+	for i, f := range fields {
+		if strings.HasPrefix(f, "~/") {
+			fields[i] = filepath.Join(home, f[2:])
+		}
+	}
+	return fields
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"flag"
+
+	"github.com/pzx521521/apk-editor/editor/signv2"
+)
+
+func alignCommand(fs *flag.FlagSet) func(args []string) error {
+	check := fs.Bool("c", false, "只检查是否已对齐, 不输出")
+	page := fs.Int("page", 16, "未压缩的 .so 按该页大小 (KB) 对齐, 4 或 16")
+	return func(args []string) error {
+		if len(args) < 1 || len(args) > 2 || *check && len(args) != 1 {
+			return errors.New("usage: align [-page 4|16] in.apk|-|URL [out.apk|-|URL] | align -c [-page 4|16] app.apk")
+		}
+		in, err := readInput(args[0])
+		if err != nil {
+			return err
+		}
+		if *check {
+			z, err := signv2.NewApkSignNoCopy(in)
+			if err != nil {
+				return err
+			}
+			if err = z.CheckAlignment(*page << 10); err != nil {
+				return err
+			}
+			infof("%s is aligned\n", args[0])
+			return nil
+		}
+		aligned, err := signv2.Align(in, *page<<10)
+		if err != nil {
+			return err
+		}
+		out := ""
+		if len(args) == 2 {
+			out = args[1]
+		}
+		if err = writeOutput(out, aligned); err != nil {
+			return err
+		}
+		// 对齐改变了偏移, 原有的 v2 及以上签名随签名块一起去掉
+		if z, err := signv2.NewApkSignNoCopy(in); err == nil && z.IsV2Signed {
+			infof("warning: the APK Signing Block was dropped, sign the aligned apk\n")
+		}
+		if out != "" && out != "-" {
+			infof("aligned %s\n", out)
+		}
+		return nil
+	}
+}
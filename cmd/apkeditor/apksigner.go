@@ -0,0 +1,374 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pzx521521/apk-editor/editor"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+)
+
+// apksignerCommand accepts the flag syntax of the SDK's apksigner so existing
+// build scripts can switch binaries. Options of features the editor does not
+// implement are accepted and reported instead of failing the build, except
+// when ignoring them would silently produce a different result.
+func apksignerCommand(fs *flag.FlagSet) func(args []string) error {
+	return func(args []string) error {
+		if len(args) == 0 {
+			return errors.New("usage: apksigner sign|verify|rotate|version [options] apk")
+		}
+		switch args[0] {
+		case "sign":
+			return apksignerSign(args[1:])
+		case "verify":
+			return apksignerVerify(args[1:])
+		case "rotate":
+			return apksignerRotate(args[1:])
+		case "version", "--version":
+			fmt.Println("apkeditor apksigner shim")
+			return nil
+		}
+		return fmt.Errorf("unknown apksigner command %q", args[0])
+	}
+}
+
+// apksignerFlags registers the options apksigner takes as "--name value"
+// pairs. Boolean switches like --v1-signing-enabled take an explicit value
+// there, so they are declared as strings.
+type apksignerFlags struct {
+	fs      *flag.FlagSet
+	in, out *string
+	key     *string
+	cert    *string
+	ks      *string
+	verbose *bool
+	werr    *bool
+	schemes map[string]*string
+}
+
+func newApksignerFlags(name string) *apksignerFlags {
+	fs := flag.NewFlagSet("apksigner "+name, flag.ExitOnError)
+	f := &apksignerFlags{
+		fs:      fs,
+		in:      fs.String("in", "", "输入 apk"),
+		out:     fs.String("out", "", "输出 apk"),
+		key:     fs.String("key", "", "PKCS#8 私钥 (DER 或 PEM)"),
+		cert:    fs.String("cert", "", "X.509 证书 (DER 或 PEM)"),
+		ks:      fs.String("ks", "", "keystore 路径"),
+		verbose: fs.Bool("verbose", false, "输出详细信息"),
+		werr:    fs.Bool("Werr", false, "verify: 有警告时验证失败"),
+		schemes: map[string]*string{},
+	}
+	for _, s := range []string{"v1", "v2", "v3", "v4"} {
+		f.schemes[s] = fs.String(s+"-signing-enabled", "", "是否启用 "+s+" 签名")
+	}
+	fs.String("v4-signature-file", "", "v4 签名文件 (.idsig)")
+	fs.BoolVar(f.verbose, "v", false, "同 --verbose")
+	fs.Bool("print-certs", false, "打印签名证书")
+	fs.Int("min-sdk-version", 0, "sign: 按该 API 级别选择签名方案, 默认读取 manifest; verify: 要求签名在该 API 级别及以上可以验证")
+	fs.Int("max-sdk-version", 0, "verify: 要求签名在该 API 级别及以下可以验证")
+	fs.String("ks-key-alias", "", "keystore 别名")
+	fs.String("ks-pass", "", "keystore 密码 (pass:, env:, file:)")
+	fs.String("key-pass", "", "私钥密码")
+	fs.String("ks-type", "", "忽略, 格式由内容判断")
+	fs.String("alignment-preserved", "", "忽略")
+	fs.String("debuggable-apk-permitted", "", "忽略")
+	return f
+}
+
+func (f *apksignerFlags) parse(args []string) (string, error) {
+	f.fs.Parse(args)
+	in := *f.in
+	if in == "" && f.fs.NArg() == 1 {
+		in = f.fs.Arg(0)
+	}
+	if in == "" {
+		return "", errors.New("missing input apk")
+	}
+	return in, nil
+}
+
+// signingCerts 返回 --ks 或 --key/--cert 给出的密钥
+func (f *apksignerFlags) signingCerts() ([]*signv2.SigningCert, error) {
+	if *f.ks != "" {
+		sc, err := loadKeystore(*f.ks, f.fs.Lookup("ks-pass").Value.String(),
+			f.fs.Lookup("ks-key-alias").Value.String(), f.fs.Lookup("key-pass").Value.String())
+		if err != nil {
+			return nil, err
+		}
+		return []*signv2.SigningCert{sc}, nil
+	}
+	keyBytes, err := readPEMOrDER(*f.key, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	certBytes, err := readPEMOrDER(*f.cert, "CERTIFICATE")
+	if err != nil {
+		return nil, err
+	}
+	return []*signv2.SigningCert{{
+		SigningKey: signv2.SigningKey{KeyBytes: keyBytes},
+		CertBytes:  certBytes,
+	}}, nil
+}
+
+func apksignerSign(args []string) error {
+	f := newApksignerFlags("sign")
+	in, err := f.parse(args)
+	if err != nil {
+		return err
+	}
+	if *f.ks == "" && (*f.key == "" || *f.cert == "") {
+		return errors.New("--ks, or --key and --cert, are required")
+	}
+	enabled := map[signv2.Scheme]bool{}
+	for scheme, v := range f.schemes {
+		if *v == "" {
+			continue
+		}
+		on, err := strconv.ParseBool(*v)
+		if err != nil {
+			return fmt.Errorf("--%s-signing-enabled: %v", scheme, err)
+		}
+		if scheme == "v2" && !on {
+			return errors.New("v2 signing cannot be disabled")
+		}
+		enabled[signv2.Scheme(scheme)] = on
+	}
+	keys, err := f.signingCerts()
+	if err != nil {
+		return err
+	}
+	apk, err := os.ReadFile(in)
+	if err != nil {
+		return err
+	}
+	// 与 apksigner 一致, 未指定的方案按 --min-sdk-version 或 manifest 的 minSdkVersion 选择
+	sdk, err := editor.ReadSDKVersions(apk)
+	if err != nil {
+		return err
+	}
+	if n, _ := strconv.Atoi(f.fs.Lookup("min-sdk-version").Value.String()); n > 0 {
+		sdk.Min = n
+	}
+	auto, err := editor.AutoSchemes(sdk, keys, enabled[signv2.SchemeV4])
+	if err != nil {
+		return err
+	}
+	var schemes []signv2.Scheme
+	for _, s := range []signv2.Scheme{signv2.SchemeV1, signv2.SchemeV2, signv2.SchemeV3, signv2.SchemeV4} {
+		on, ok := enabled[s]
+		if !ok {
+			on = slices.Contains(auto, s)
+		}
+		if on {
+			schemes = append(schemes, s)
+		}
+	}
+	signed, idsig, err := editor.SignSchemes(apk, keys, schemes)
+	if err != nil {
+		return err
+	}
+	out := *f.out
+	if out == "" {
+		// apksigner 未指定 --out 时覆盖输入文件
+		out = in
+	}
+	if err = os.WriteFile(out, signed, 0644); err != nil {
+		return err
+	}
+	if idsig == nil {
+		return nil
+	}
+	// 与 apksigner 一致, 默认写到输出文件旁的 .idsig
+	idsigPath := f.fs.Lookup("v4-signature-file").Value.String()
+	if idsigPath == "" {
+		idsigPath = out + ".idsig"
+	}
+	return os.WriteFile(idsigPath, idsig, 0644)
+}
+
+func apksignerVerify(args []string) error {
+	f := newApksignerFlags("verify")
+	in, err := f.parse(args)
+	if err != nil {
+		return err
+	}
+	printCerts := f.fs.Lookup("print-certs").Value.String() == "true"
+	verbose := *f.verbose
+	apk, err := os.ReadFile(in)
+	if err != nil {
+		return err
+	}
+	z, err := signv2.NewApkSign(apk)
+	if err != nil {
+		return err
+	}
+	var opts signv2.VerifyOptions
+	opts.MinSDK, _ = strconv.Atoi(f.fs.Lookup("min-sdk-version").Value.String())
+	opts.MaxSDK, _ = strconv.Atoi(f.fs.Lookup("max-sdk-version").Value.String())
+	if p := f.fs.Lookup("v4-signature-file").Value.String(); p != "" {
+		if opts.IDSig, err = os.ReadFile(p); err != nil {
+			return err
+		}
+	}
+	res := z.VerifyAll(opts)
+	if !res.Verified {
+		fmt.Println("DOES NOT VERIFY")
+		for _, s := range res.Schemes {
+			if s.Err != nil {
+				fmt.Printf("ERROR: %s: %v\n", s.Scheme, s.Err)
+			}
+		}
+		if res.SDKErr != nil {
+			fmt.Printf("ERROR: %v\n", res.SDKErr)
+		}
+		if len(res.Errors()) == 0 {
+			fmt.Println("ERROR: no verifiable signature")
+		}
+		os.Exit(1)
+	}
+	if verbose {
+		fmt.Println("Verifies")
+		names := map[signv2.Scheme]string{
+			signv2.SchemeV1:  "v1 scheme (JAR signing)",
+			signv2.SchemeV2:  "v2 scheme (APK Signature Scheme v2)",
+			signv2.SchemeV3:  "v3 scheme (APK Signature Scheme v3)",
+			signv2.SchemeV31: "v3.1 scheme (APK Signature Scheme v3.1)",
+			signv2.SchemeV4:  "v4 scheme (APK Signature Scheme v4)",
+		}
+		for _, s := range res.Schemes {
+			fmt.Printf("Verified using %s: %t\n", names[s.Scheme], s.Verified)
+		}
+		for _, b := range res.Blocks {
+			name := b.Name
+			if name == "" {
+				name = "unknown"
+			}
+			fmt.Printf("Signing block entry %#08x (%s): %d bytes\n", b.ID, name, b.Size)
+		}
+	}
+	if st := res.SourceStamp; st != nil {
+		if st.Err != nil {
+			fmt.Printf("WARNING: source stamp: %v\n", st.Err)
+		} else if verbose {
+			fmt.Println("Verified for SourceStamp: true")
+		}
+	}
+	for _, w := range res.Warnings {
+		fmt.Printf("WARNING: %s\n", w)
+	}
+	// 与 apksigner 一致, -Werr 时警告视为错误
+	if *f.werr && (len(res.Warnings) > 0 || res.SourceStamp != nil && res.SourceStamp.Err != nil) {
+		return errors.New("warnings treated as errors (-Werr)")
+	}
+	if !printCerts {
+		return nil
+	}
+	// apksigner 只打印用于验证的最新方案的签名者
+	var signers []*signv2.SignerResult
+	for _, scheme := range []signv2.Scheme{signv2.SchemeV3, signv2.SchemeV2, signv2.SchemeV1} {
+		for _, s := range res.Signers {
+			if s.Scheme == scheme {
+				signers = append(signers, s)
+			}
+		}
+		if len(signers) > 0 {
+			break
+		}
+	}
+	fmt.Printf("Number of signers: %d\n", len(signers))
+	for i, s := range signers {
+		if len(s.Certs) == 0 {
+			continue
+		}
+		c := s.Certs[0]
+		sumMD5 := md5.Sum(c.Raw)
+		fmt.Printf("Signer #%d certificate DN: %s\n", i+1, c.Subject)
+		fmt.Printf("Signer #%d certificate SHA-256 digest: %s\n", i+1, s.SHA256)
+		fmt.Printf("Signer #%d certificate SHA-1 digest: %s\n", i+1, s.SHA1)
+		fmt.Printf("Signer #%d certificate MD5 digest: %s\n", i+1, hex.EncodeToString(sumMD5[:]))
+	}
+	if st := res.SourceStamp; st != nil && st.Cert != nil {
+		sum256 := sha256.Sum256(st.Cert.Raw)
+		fmt.Printf("Source Stamp Signer certificate DN: %s\n", st.Cert.Subject)
+		fmt.Printf("Source Stamp Signer certificate SHA-256 digest: %s\n", hex.EncodeToString(sum256[:]))
+	}
+	return nil
+}
+
+// apksignerRotate 与 apksigner rotate 一致: --out lineage [--in lineage] --old-signer 签名者选项
+// --new-signer 签名者选项. 输出新证书追加到 --in (未指定时从旧证书开始) 后的 lineage,
+// 即 v3 签名中 proof-of-rotation 属性的值
+func apksignerRotate(args []string) error {
+	groups := map[string][]string{}
+	group := ""
+	for _, a := range args {
+		switch a {
+		case "--old-signer", "--new-signer":
+			group = a
+		default:
+			groups[group] = append(groups[group], a)
+		}
+	}
+	fs := flag.NewFlagSet("apksigner rotate", flag.ExitOnError)
+	in := fs.String("in", "", "要追加的 lineage, 未指定时从旧证书开始")
+	out := fs.String("out", "", "输出 lineage")
+	fs.Int("min-sdk-version", 0, "忽略")
+	fs.Parse(groups[""])
+	if *out == "" {
+		return errors.New("--out is required")
+	}
+	var signers [2]*signv2.SigningCert
+	for i, name := range []string{"--old-signer", "--new-signer"} {
+		f := newApksignerFlags("rotate " + name)
+		f.fs.Parse(groups[name])
+		if *f.ks == "" && (*f.key == "" || *f.cert == "") {
+			return fmt.Errorf("%s: --ks, or --key and --cert, are required", name)
+		}
+		keys, err := f.signingCerts()
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		signers[i] = keys[0]
+	}
+	var lineage signv2.Lineage
+	var err error
+	if *in != "" {
+		b, err := os.ReadFile(*in)
+		if err != nil {
+			return err
+		}
+		if lineage, err = signv2.ParseLineage(b); err != nil {
+			return err
+		}
+	} else if lineage, err = signv2.NewLineage(signers[0], signv2.DefaultLineageFlags); err != nil {
+		return err
+	}
+	if lineage, err = lineage.Rotate(signers[0], signers[1], signv2.DefaultLineageFlags); err != nil {
+		return err
+	}
+	return os.WriteFile(*out, lineage.Marshal(), 0644)
+}
+
+// readPEMOrDER loads a key or certificate that apksigner accepts in DER form
+// and wraps it in PEM, which is what SigningCert expects.
+func readPEMOrDER(path, pemType string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.Contains(string(b), "-----BEGIN ") {
+		return b, nil
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: pemType, Bytes: b}), nil
+}
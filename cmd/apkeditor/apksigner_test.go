@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pzx521521/apk-editor/editor/apktest"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+)
+
+func TestApksignerSignVerify(t *testing.T) {
+	in := testApk(t, apktest.Config{MinSDK: 24})
+	out := filepath.Join(t.TempDir(), "out.apk")
+	// apksigner spells its options with two dashes
+	keys := testKeyFiles(t)
+	args := []string{"sign", "-" + keys[0], keys[1], "-" + keys[2], keys[3], "--v3-signing-enabled", "true", "--out", out, in}
+	if err := run(t, apksignerCommand, args...); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	stdout := withStdio(t, "", func() {
+		err = run(t, apksignerCommand, "verify", "--verbose", "--print-certs", out)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Verified using v2 scheme (APK Signature Scheme v2): true",
+		"Verified using v3 scheme (APK Signature Scheme v3): true",
+		"Number of signers: 1",
+		"Signer #1 certificate DN: CN=apktest",
+	} {
+		if !strings.Contains(string(stdout), want) {
+			t.Errorf("verify output lacks %q:\n%s", want, stdout)
+		}
+	}
+}
+
+func TestApksignerVerifyV1Only(t *testing.T) {
+	// sign always adds v2, so the v1-only file comes from signv2
+	apk, err := apktest.Build(apktest.Config{MinSDK: 21})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := apktest.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := signv2.NewApkSign(apk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := z.SignV1([]*signv2.SigningCert{key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(t.TempDir(), "out.apk")
+	if err = os.WriteFile(out, signed, 0644); err != nil {
+		t.Fatal(err)
+	}
+	stdout := withStdio(t, "", func() {
+		err = run(t, apksignerCommand, "verify", "--verbose", "--print-certs", out)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Verified using v1 scheme (JAR signing): true",
+		"Verified using v2 scheme (APK Signature Scheme v2): false",
+		"Number of signers: 1",
+		"Signer #1 certificate DN: CN=apktest",
+	} {
+		if !strings.Contains(string(stdout), want) {
+			t.Errorf("verify output lacks %q:\n%s", want, stdout)
+		}
+	}
+}
+
+func TestApksignerVerifyShortFlags(t *testing.T) {
+	signed := testSignedApk(t, apktest.Config{})
+	var err error
+	out := withStdio(t, "", func() { err = run(t, apksignerCommand, "verify", "-v", "-Werr", signed) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "Verifies") {
+		t.Errorf("-v output lacks Verifies:\n%s", out)
+	}
+
+	// a debug certificate is a warning, which -Werr turns into a failure
+	debug, err := signv2.GenerateDebugCert("", 0, signv2.RSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	debugSigned := testSignedApk(t, apktest.Config{}, debug)
+	withStdio(t, "", func() { err = run(t, apksignerCommand, "verify", "-Werr", debugSigned) })
+	if err == nil {
+		t.Error("-Werr accepted a warning")
+	}
+	withStdio(t, "", func() { err = run(t, apksignerCommand, "verify", debugSigned) })
+	if err != nil {
+		t.Errorf("without -Werr: %v", err)
+	}
+}
+
+func TestApksignerRotate(t *testing.T) {
+	var certs [3]*signv2.SigningCert
+	var flags [3][]string
+	for i := range certs {
+		sc, err := signv2.GenerateDebugCert(fmt.Sprintf("key %d", i), 0, signv2.RSA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs[i], flags[i] = sc, writeKeyFiles(t, sc)
+	}
+	out := filepath.Join(t.TempDir(), "lineage")
+	rotate := func(in string, from, to int) signv2.Lineage {
+		t.Helper()
+		args := []string{"rotate", "--out", out}
+		if in != "" {
+			args = append(args, "--in", in)
+		}
+		args = append(append(args, "--old-signer"), flags[from]...)
+		args = append(append(args, "--new-signer"), flags[to]...)
+		if err := run(t, apksignerCommand, args...); err != nil {
+			t.Fatal(err)
+		}
+		b, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lineage, err := signv2.ParseLineage(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return lineage
+	}
+	lineage := rotate("", 0, 1)
+	if len(lineage) != 2 || !lineage[0].Cert.Equal(certs[0].Certificate) || !lineage[1].Cert.Equal(certs[1].Certificate) {
+		t.Fatalf("got lineage of %d certificates", len(lineage))
+	}
+	// --in extends an existing lineage
+	lineage = rotate(out, 1, 2)
+	if len(lineage) != 3 || !lineage[2].Cert.Equal(certs[2].Certificate) {
+		t.Errorf("extended lineage of %d certificates", len(lineage))
+	}
+}
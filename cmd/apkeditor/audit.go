@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"os/user"
+
+	"github.com/pzx521521/apk-editor/editor/audit"
+)
+
+// addAuditFlag declares -audit, shared by sign and serve.
+func addAuditFlag(fs *flag.FlagSet) *string {
+	return fs.String("audit", os.Getenv("APKEDITOR_AUDIT"), "审计日志: 追加写入的文件, syslog[:TAG] 或 http(s) webhook 地址 (也可设置 APKEDITOR_AUDIT)")
+}
+
+// openAudit opens the -audit sink, or returns nil when none was given.
+func openAudit(spec string) (audit.Sink, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	return audit.Open(spec)
+}
+
+// localActor names the user running the command in audit events.
+func localActor() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return ""
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/pzx521521/apk-editor/editor/diff"
+)
+
+// compareCommand 比较两个 apk, 用于检查重新签名或重新构建的产物
+func compareCommand(fs *flag.FlagSet) func(args []string) error {
+	asJSON := fs.Bool("json", false, "以 JSON 输出报告")
+	return func(args []string) error {
+		if len(args) != 2 {
+			return errors.New("usage: compare [-json] a.apk b.apk")
+		}
+		a, err := readInput(args[0])
+		if err != nil {
+			return err
+		}
+		b, err := readInput(args[1])
+		if err != nil {
+			return err
+		}
+		r, err := diff.CompareApks(a, b)
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			e := json.NewEncoder(os.Stdout)
+			e.SetIndent("", "  ")
+			e.Encode(r)
+		} else {
+			for _, name := range r.Added {
+				fmt.Printf("+ %s\n", name)
+			}
+			for _, name := range r.Removed {
+				fmt.Printf("- %s\n", name)
+			}
+			for _, d := range r.Changed {
+				switch {
+				case d.Content && d.Compression:
+					fmt.Printf("M %s (content, method %d -> %d)\n", d.Name, d.MethodA, d.MethodB)
+				case d.Content:
+					fmt.Printf("M %s (%d -> %d bytes)\n", d.Name, d.SizeA, d.SizeB)
+				default:
+					fmt.Printf("C %s (method %d -> %d, %d -> %d bytes compressed)\n", d.Name, d.MethodA, d.MethodB, d.CompressedA, d.CompressedB)
+				}
+			}
+			for _, d := range r.Manifest {
+				fmt.Printf("manifest %s\n", d)
+			}
+			fmt.Printf("same signer: %t\n", r.SameSigner)
+		}
+		// 与 parity 一致, 有差异时以非零状态退出
+		if !r.Equal() {
+			return errors.New("apks differ")
+		}
+		return nil
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+type flagInfo struct {
+	name, usage string
+	isBool      bool
+}
+
+func completionCommand(fs *flag.FlagSet) func(args []string) error {
+	return func(args []string) error {
+		if len(args) != 1 {
+			return errors.New("usage: completion bash|zsh|fish")
+		}
+		names, flags := completionData()
+		switch args[0] {
+		case "bash":
+			writeBash(os.Stdout, names, flags)
+		case "zsh":
+			writeZsh(os.Stdout, names, flags)
+		case "fish":
+			writeFish(os.Stdout, names, flags)
+		default:
+			return fmt.Errorf("unsupported shell %q", args[0])
+		}
+		return nil
+	}
+}
+
+// completionData collects every command (and user alias) with its flags.
+// Aliases complete with the flags of the command they expand to.
+func completionData() ([]string, map[string][]flagInfo) {
+	flags := map[string][]flagInfo{}
+	var names []string
+	for _, c := range commands {
+		fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+		c.flags(fs)
+		fs.VisitAll(func(f *flag.Flag) {
+			b, ok := f.Value.(interface{ IsBoolFlag() bool })
+			flags[c.name] = append(flags[c.name], flagInfo{f.Name, f.Usage, ok && b.IsBoolFlag()})
+		})
+		names = append(names, c.name)
+	}
+	if a, err := loadAliases(); err == nil {
+		for name, exp := range a {
+			if _, builtin := flags[name]; !builtin && len(exp) > 0 {
+				flags[name] = flags[exp[0]]
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names[len(commands):])
+	return names, flags
+}
+
+func writeBash(w io.Writer, names []string, flags map[string][]flagInfo) {
+	fmt.Fprintln(w, "_apkeditor() {")
+	fmt.Fprintln(w, `	local cur=${COMP_WORDS[COMP_CWORD]} i cmd=""`)
+	fmt.Fprintln(w, `	for ((i = 1; i < COMP_CWORD; i++)); do`)
+	fmt.Fprintln(w, `		case ${COMP_WORDS[i]} in -q|-v) ;; *) cmd=${COMP_WORDS[i]}; break;; esac`)
+	fmt.Fprintln(w, `	done`)
+	fmt.Fprintln(w, `	case $cmd in`)
+	fmt.Fprintf(w, "\t\"\") COMPREPLY=($(compgen -W \"-q -v %s\" -- \"$cur\")); return;;\n", strings.Join(names, " "))
+	for _, name := range names {
+		var opts []string
+		for _, f := range flags[name] {
+			opts = append(opts, "-"+f.name)
+		}
+		fmt.Fprintf(w, "\t%s) COMPREPLY=($(compgen -W \"%s\" -- \"$cur\"));;\n", name, strings.Join(opts, " "))
+	}
+	fmt.Fprintln(w, `	esac`)
+	fmt.Fprintln(w, `	[[ $cur == -* ]] || COMPREPLY+=($(compgen -f -- "$cur"))`)
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "complete -o filenames -F _apkeditor apkeditor")
+}
+
+func writeZsh(w io.Writer, names []string, flags map[string][]flagInfo) {
+	esc := strings.NewReplacer("[", `\[`, "]", `\]`, ":", `\:`, "'", `'\''`)
+	fmt.Fprintln(w, "#compdef apkeditor")
+	fmt.Fprintln(w, "_apkeditor() {")
+	fmt.Fprintln(w, "	local -a cmds")
+	fmt.Fprintln(w, "	cmds=(")
+	for _, name := range names {
+		fmt.Fprintf(w, "\t\t'%s'\n", name)
+	}
+	fmt.Fprintln(w, "	)")
+	fmt.Fprintln(w, "	_arguments '-q[quiet]' '-v[verbose]' '1:command:($cmds)' '*::arg:->args'")
+	fmt.Fprintln(w, "	case $words[1] in")
+	for _, name := range names {
+		fmt.Fprintf(w, "\t%s) _arguments", name)
+		for _, f := range flags[name] {
+			if f.isBool {
+				fmt.Fprintf(w, " '-%s[%s]'", f.name, esc.Replace(f.usage))
+			} else {
+				fmt.Fprintf(w, " '-%s[%s]:%s:_files'", f.name, esc.Replace(f.usage), f.name)
+			}
+		}
+		fmt.Fprintln(w, " '*:file:_files';;")
+	}
+	fmt.Fprintln(w, "	esac")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, `compdef _apkeditor apkeditor`)
+}
+
+func writeFish(w io.Writer, names []string, flags map[string][]flagInfo) {
+	esc := strings.NewReplacer(`'`, `\'`)
+	fmt.Fprintln(w, "complete -c apkeditor -f")
+	fmt.Fprintln(w, "complete -c apkeditor -n __fish_use_subcommand -o q -d 'quiet'")
+	fmt.Fprintln(w, "complete -c apkeditor -n __fish_use_subcommand -o v -d 'verbose'")
+	for _, name := range names {
+		fmt.Fprintf(w, "complete -c apkeditor -n __fish_use_subcommand -a %s\n", name)
+		for _, f := range flags[name] {
+			r := " -r -F"
+			if f.isBool {
+				r = ""
+			}
+			fmt.Fprintf(w, "complete -c apkeditor -n '__fish_seen_subcommand_from %s' -o %s -d '%s'%s\n", name, f.name, esc.Replace(f.usage), r)
+		}
+		fmt.Fprintf(w, "complete -c apkeditor -n '__fish_seen_subcommand_from %s' -F\n", name)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"flag"
+
+	"github.com/pzx521521/apk-editor/editor/delta"
+)
+
+func deltaCommand(fs *flag.FlagSet) func(args []string) error {
+	return func(args []string) error {
+		if len(args) != 4 || args[0] != "diff" && args[0] != "apply" {
+			return errors.New("usage: delta diff old.apk new.apk out.patch | delta apply old.apk in.patch out.apk")
+		}
+		old, err := readInput(args[1])
+		if err != nil {
+			return err
+		}
+		in, err := readInput(args[2])
+		if err != nil {
+			return err
+		}
+		var out []byte
+		if args[0] == "diff" {
+			out, err = delta.Diff(old, in)
+		} else {
+			out, err = delta.Apply(old, in)
+		}
+		if err != nil {
+			return err
+		}
+		if err = writeOutput(args[3], out); err != nil {
+			return err
+		}
+		infof("wrote %s (%d bytes)\n", args[3], len(out))
+		return nil
+	}
+}
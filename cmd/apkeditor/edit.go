@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+
+	"github.com/pzx521521/apk-editor/editor"
+	"github.com/pzx521521/apk-editor/editor/axml"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+)
+
+func editCommand(fs *flag.FlagSet) func(args []string) error {
+	kf := addKeyFlags(fs)
+	var m editor.ManifestEdit
+	fs.StringVar(&m.Package, "package", "", "新包名")
+	versionCode := fs.Int("version-code", 0, "新 versionCode")
+	fs.StringVar(&m.VersionName, "version-name", "", "新 versionName")
+	fs.StringVar(&m.Label, "label", "", "新应用名称")
+	dryRun := fs.Bool("dry-run", false, "只打印将要进行的修改, 不写出文件")
+	return func(args []string) error {
+		if len(args) < 1 || len(args) > 2 {
+			return errors.New("usage: edit [-dry-run] [-package NAME] [-version-code N] [-version-name S] [-label S] [-key key.pem -cert cert.pem|-ks release.keystore] in.apk|-|URL [out.apk|-|URL]")
+		}
+		m.VersionCode = int32(*versionCode)
+		if m == (editor.ManifestEdit{}) {
+			return errors.New("nothing to edit")
+		}
+		in, err := readInput(args[0])
+		if err != nil {
+			return err
+		}
+		out := ""
+		if len(args) == 2 {
+			out = args[1]
+		}
+		// dry-run 与实际修改走同一流程, 只是最后打印修改内容而不写出
+		plan := &editor.EditPlan{OldSize: int64(len(in))}
+		edited, err := editor.EditManifestFunc(in, func(root *axml.Element) error {
+			plan.Manifest = m.Changes(root)
+			return m.Apply(root)
+		})
+		if err != nil {
+			return err
+		}
+		// 未给出密钥时输出未签名的 apk, 需要再用 sign 签名
+		if !kf.set() {
+			if !*dryRun {
+				infof("warning: the output is not signed\n")
+			}
+		} else {
+			keys, err := kf.signingCerts()
+			if err != nil {
+				return err
+			}
+			z, err := signv2.NewApkSignNoCopy(edited)
+			if err != nil {
+				return err
+			}
+			if edited, err = z.SignV2(keys); err != nil {
+				return err
+			}
+			plan.Scheme = string(signv2.SchemeV2)
+		}
+		if *dryRun {
+			plan.NewSize = int64(len(edited))
+			return plan.Print(os.Stdout)
+		}
+		if err = writeOutput(out, edited); err != nil {
+			return err
+		}
+		if out != "" && out != "-" {
+			infof("edited %s\n", out)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pzx521521/apk-editor/editor"
+	"github.com/pzx521521/apk-editor/editor/apktest"
+)
+
+func TestEdit(t *testing.T) {
+	in := testApk(t, apktest.Config{VersionName: "1.0"})
+	out := filepath.Join(t.TempDir(), "out.apk")
+	args := append(testKeyFiles(t), "-version-name", "2.0", "-version-code", "9", in, out)
+
+	var err error
+	stdout := withStdio(t, "", func() { err = run(t, editCommand, append([]string{"-dry-run"}, args...)...) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("dry-run wrote %s", out)
+	}
+	for _, want := range []string{`manifest versionName: "1.0" -> "2.0"`, `manifest versionCode: "1" -> "9"`, "signature scheme v2"} {
+		if !strings.Contains(string(stdout), want) {
+			t.Errorf("dry-run output lacks %q:\n%s", want, stdout)
+		}
+	}
+
+	if err = run(t, editCommand, args...); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := editor.ApkInfo(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.VersionName != "2.0" || info.VersionCode != 9 || len(info.Signers) != 1 {
+		t.Errorf("got version %d %q, %d signers", info.VersionCode, info.VersionName, len(info.Signers))
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/pzx521521/apk-editor/editor"
+)
+
+func infoCommand(fs *flag.FlagSet) func(args []string) error {
+	asJSON := fs.Bool("json", false, "以 JSON 格式输出")
+	return func(args []string) error {
+		if len(args) != 1 {
+			return errors.New("usage: info [-json] app.apk|-|URL")
+		}
+		apk, err := readInput(args[0])
+		if err != nil {
+			return err
+		}
+		info, err := editor.ApkInfo(apk)
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			e := json.NewEncoder(os.Stdout)
+			e.SetIndent("", "  ")
+			return e.Encode(info)
+		}
+		fmt.Printf("package: %s\n", info.Package)
+		fmt.Printf("version: %d (%s)\n", info.VersionCode, info.VersionName)
+		fmt.Printf("min sdk: %d\n", info.MinSDK)
+		fmt.Printf("target sdk: %d\n", info.TargetSDK)
+		fmt.Printf("label: %s\n", info.Label)
+		if info.Debuggable {
+			fmt.Println("debuggable")
+		}
+		for _, p := range info.Permissions {
+			fmt.Printf("uses-permission: %s\n", p)
+		}
+		if a := info.LaunchableActivity(); a != "" {
+			fmt.Printf("launchable activity: %s\n", a)
+		}
+		for _, c := range info.Components {
+			exported := ""
+			if c.Exported {
+				exported = " (exported)"
+			}
+			fmt.Printf("%s: %s%s\n", c.Kind, c.Name, exported)
+		}
+		if len(info.NativeCode) > 0 {
+			fmt.Printf("native code: %v\n", info.NativeCode)
+		}
+		fmt.Printf("schemes: %v\n", info.Schemes)
+		for i, s := range info.Signers {
+			fmt.Printf("signer #%d (%s): %s\n", i+1, s.Scheme, s.Subject)
+			fmt.Printf("signer #%d SHA-256: %s\n", i+1, s.SHA256)
+		}
+		fmt.Printf("debug signed: %t\n", info.DebugSigned)
+		return nil
+	}
+}
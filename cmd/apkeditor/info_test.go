@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/pzx521521/apk-editor/editor"
+	"github.com/pzx521521/apk-editor/editor/apktest"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+)
+
+func TestInfo(t *testing.T) {
+	signed := testSignedApk(t, apktest.Config{VersionCode: 3, VersionName: "0.3"})
+	var err error
+	out := withStdio(t, "", func() { err = run(t, infoCommand, signed) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"package: " + apktest.DefaultPackage, "version: 3 (0.3)", "schemes: [v2]", "signer #1 (v2): CN=apktest", "debug signed: false"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+
+	debug, err := signv2.GenerateDebugCert("", 0, signv2.RSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	debugSigned := testSignedApk(t, apktest.Config{}, debug)
+	out = withStdio(t, "", func() { err = run(t, infoCommand, debugSigned) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "debug signed: true") {
+		t.Errorf("debug certificate not reported:\n%s", out)
+	}
+	out = withStdio(t, "", func() { err = run(t, infoCommand, "-json", debugSigned) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	var info editor.Info
+	if err = json.Unmarshal(out, &info); err != nil {
+		t.Fatalf("%v:\n%s", err, out)
+	}
+	if info.Package != apktest.DefaultPackage || !info.DebugSigned {
+		t.Errorf("got package %q, debug signed %v", info.Package, info.DebugSigned)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pzx521521/apk-editor/editor/device"
+	"github.com/pzx521521/apk-editor/editor/splits"
+)
+
+func installCommand(fs *flag.FlagSet) func(args []string) error {
+	serial := fs.String("device", "", "目标设备序列号 (adb -s)")
+	adbPath := fs.String("adb", "", "adb 可执行文件路径, 默认从 PATH 查找")
+	incremental := fs.Bool("incremental", false, "增量安装, 需要 <apk>.idsig (v4 签名)")
+	reinstall := fs.Bool("r", true, "覆盖安装")
+	downgrade := fs.Bool("d", false, "允许降级安装")
+	return func(args []string) error {
+		if len(args) == 0 {
+			return errors.New("missing apk path")
+		}
+		adb := &device.Adb{Path: *adbPath, Serial: *serial, Stdout: os.Stdout, Stderr: os.Stderr}
+		if len(args) == 1 && isSplitSet(args[0]) {
+			// 按设备配置挑选 split 后解压到临时目录再安装
+			apks, cleanup, err := selectSplits(adb, args[0])
+			if err != nil {
+				return err
+			}
+			defer cleanup()
+			args = apks
+		}
+		return adb.Install(device.InstallOptions{
+			Reinstall:   *reinstall,
+			Downgrade:   *downgrade,
+			Incremental: *incremental,
+		}, args...)
+	}
+}
+
+func isSplitSet(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".apks", ".xapk", ".apkm":
+		return true
+	}
+	return false
+}
+
+func selectSplits(adb *device.Adb, name string) ([]string, func(), error) {
+	set, err := splits.OpenFile(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	spec, err := adb.DeviceSpec()
+	if err != nil {
+		return nil, nil, err
+	}
+	selected := set.Select(*spec)
+	if len(selected) == 0 {
+		return nil, nil, fmt.Errorf("%s: no apk matches the device", name)
+	}
+	dir, err := os.MkdirTemp("", "apkeditor-splits")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	apks, err := set.Extract(dir, selected...)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return apks, cleanup, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/pzx521521/apk-editor/editor/apktest"
+)
+
+// fakeAdb writes a shell script standing in for adb that logs its arguments, one call per line,
+// and returns its path and the log's.
+func fakeAdb(t *testing.T) (string, string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake adb is a shell script")
+	}
+	dir := t.TempDir()
+	adb, log := filepath.Join(dir, "adb"), filepath.Join(dir, "adb.log")
+	script := "#!/bin/sh\necho \"$@\" >> " + log + "\n"
+	if err := os.WriteFile(adb, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return adb, log
+}
+
+func TestInstall(t *testing.T) {
+	adb, log := fakeAdb(t)
+	apk := testSignedApk(t, apktest.Config{})
+	split := testSignedApk(t, apktest.Config{})
+	if err := run(t, installCommand, "-adb", adb, "-device", "emulator-5554", "-d", apk); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(t, installCommand, "-adb", adb, "-r=false", apk, split); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(t, installCommand, "-adb", adb, "-incremental", apk); err == nil || !strings.Contains(err.Error(), ".idsig") {
+		t.Errorf("incremental install without .idsig: got %v", err)
+	}
+	b, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "-s emulator-5554 install -r -d " + apk + "\ninstall-multiple " + apk + " " + split + "\n"
+	if string(b) != want {
+		t.Errorf("adb called with\n%s\nwant\n%s", b, want)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pzx521521/apk-editor/editor/signv2"
+)
+
+// keygenCommand 生成自签名的密钥和证书, 供测试和 CI 重新签名使用, 不需要外部的密钥文件
+func keygenCommand(fs *flag.FlagSet) func(args []string) error {
+	cn := fs.String("cn", "", "证书的 CN, 默认与 SDK 的 debug 证书相同 (CN=Android Debug, O=Android, C=US)")
+	days := fs.Int("days", 0, "证书有效天数, 默认 30 年")
+	keyType := fs.String("type", "rsa", "密钥类型: rsa 或 ec")
+	key := fs.String("key", "", "写出 PEM 格式私钥的路径")
+	cert := fs.String("cert", "", "写出 PEM 格式证书的路径")
+	ks := fs.String("ks", "", "写出 PKCS#12 keystore 的路径")
+	ksPass := fs.String("ks-pass", "pass:android", "keystore 密码 (pass:, env:, file:)")
+	alias := fs.String("ks-key-alias", "androiddebugkey", "keystore 别名")
+	return func(args []string) error {
+		if len(args) != 0 || *ks == "" && (*key == "" || *cert == "") {
+			return errors.New("usage: keygen [-cn NAME] [-days N] [-type rsa|ec] -ks debug.keystore|-key key.pem -cert cert.pem")
+		}
+		var kt signv2.KeyAlgorithm
+		switch strings.ToLower(*keyType) {
+		case "rsa":
+			kt = signv2.RSA
+		case "ec":
+			kt = signv2.EC
+		default:
+			return errors.New("-type must be rsa or ec")
+		}
+		sc, err := signv2.GenerateDebugCert(*cn, time.Duration(*days)*24*time.Hour, kt)
+		if err != nil {
+			return err
+		}
+		// 私钥只允许当前用户读取
+		if *key != "" {
+			if err = os.WriteFile(*key, sc.KeyBytes, 0600); err != nil {
+				return err
+			}
+			if err = os.WriteFile(*cert, sc.CertBytes, 0644); err != nil {
+				return err
+			}
+		}
+		if *ks != "" {
+			pass, err := readPassword("ks-pass", *ksPass)
+			if err != nil {
+				return err
+			}
+			b, err := signv2.EncodePKCS12(sc, *alias, pass)
+			if err != nil {
+				return err
+			}
+			if err = os.WriteFile(*ks, b, 0600); err != nil {
+				return err
+			}
+		}
+		infof("generated %s\n", sc.Certificate.Subject)
+		return nil
+	}
+}
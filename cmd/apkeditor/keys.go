@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pzx521521/apk-editor/editor/signv2"
+)
+
+type keyFlags struct {
+	key, cert *string
+	ks        *string
+	ksPass    *string
+	ksAlias   *string
+	keyPass   *string
+}
+
+func addKeyFlags(fs *flag.FlagSet) *keyFlags {
+	return &keyFlags{
+		key:     fs.String("key", "", "PEM 格式私钥路径 (RSA 或 EC)"),
+		cert:    fs.String("cert", "", "PEM 格式证书路径"),
+		ks:      fs.String("ks", "", "JKS 或 PKCS#12 keystore 路径, 代替 -key 和 -cert"),
+		ksPass:  fs.String("ks-pass", "", "keystore 密码 (pass:, env:, file:)"),
+		ksAlias: fs.String("ks-key-alias", "", "keystore 别名, keystore 只有一个密钥时可省略"),
+		keyPass: fs.String("key-pass", "", "私钥密码 (pass:, env:, file:), 默认同 -ks-pass"),
+	}
+}
+
+// set 报告是否给出了密钥
+func (k *keyFlags) set() bool {
+	return *k.key != "" || *k.cert != "" || *k.ks != ""
+}
+
+// name 是审计日志中记录的密钥名
+func (k *keyFlags) name() string {
+	if *k.ks != "" {
+		return filepath.Base(*k.ks)
+	}
+	return filepath.Base(*k.cert)
+}
+
+func (k *keyFlags) signingCerts() ([]*signv2.SigningCert, error) {
+	if *k.ks != "" {
+		sc, err := loadKeystore(*k.ks, *k.ksPass, *k.ksAlias, *k.keyPass)
+		if err != nil {
+			return nil, err
+		}
+		return []*signv2.SigningCert{sc}, nil
+	}
+	if *k.key == "" || *k.cert == "" {
+		return nil, errors.New("-key and -cert, or -ks, are required")
+	}
+	return []*signv2.SigningCert{{
+		SigningKey: signv2.SigningKey{KeyPath: *k.key},
+		CertPath:   *k.cert,
+	}}, nil
+}
+
+// loadKeystore 读取 keystore, 密码格式同 apksigner
+func loadKeystore(path, ksPass, alias, keyPass string) (*signv2.SigningCert, error) {
+	storePass, err := readPassword("ks-pass", ksPass)
+	if err != nil {
+		return nil, err
+	}
+	if keyPass, err = readPassword("key-pass", keyPass); err != nil {
+		return nil, err
+	}
+	return signv2.LoadKeystore(path, storePass, alias, keyPass)
+}
+
+// readPassword 解析 apksigner 的密码格式: pass:密码, env:环境变量, file:文件的第一行;
+// 不带前缀时即为密码本身
+func readPassword(flagName, spec string) (string, error) {
+	kind, value, ok := strings.Cut(spec, ":")
+	if !ok {
+		return spec, nil
+	}
+	switch kind {
+	case "pass":
+		return value, nil
+	case "env":
+		pass, ok := os.LookupEnv(value)
+		if !ok {
+			return "", fmt.Errorf("-%s: environment variable %s is not set", flagName, value)
+		}
+		return pass, nil
+	case "file":
+		b, err := os.ReadFile(value)
+		if err != nil {
+			return "", fmt.Errorf("-%s: %v", flagName, err)
+		}
+		line, _, _ := strings.Cut(string(b), "\n")
+		return strings.TrimSuffix(line, "\r"), nil
+	}
+	return spec, nil
+}
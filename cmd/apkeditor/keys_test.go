@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadPassword(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "pass")
+	if err := os.WriteFile(file, []byte("from file\r\nsecond line\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("APKEDITOR_TEST_PASS", "from env")
+	for _, c := range []struct {
+		spec, want string
+		err        bool
+	}{
+		{"secret", "secret", false},
+		{"pass:a:b", "a:b", false},
+		{"env:APKEDITOR_TEST_PASS", "from env", false},
+		{"env:APKEDITOR_TEST_UNSET", "", true},
+		{"file:" + file, "from file", false},
+		{"file:" + file + ".missing", "", true},
+		{"other:secret", "other:secret", false},
+	} {
+		got, err := readPassword("ks-pass", c.spec)
+		if got != c.want || (err != nil) != c.err {
+			t.Errorf("readPassword(%q) = %q, %v", c.spec, got, err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+var (
+	quiet   = flag.Bool("q", false, "安静模式, 只输出错误")
+	verbose = flag.Bool("v", false, "输出库的详细日志")
+)
+
+// command is a subcommand. flags declares the command's flags on fs and
+// returns the function that runs it with the remaining positional args;
+// keeping the two apart lets completion inspect flags without running.
+type command struct {
+	name  string
+	usage string
+	flags func(fs *flag.FlagSet) func(args []string) error
+}
+
+// commands is filled in init because completion refers back to it.
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{"sign", "sign -key key.pem -cert cert.pem|-ks release.keystore [-stamp-key k.pem -stamp-cert c.pem] [-policy policy.json] in.apk|in.aab|in.apks|-|URL [out.apk|-|URL]", signCommand},
+		{"sign-dir", "sign-dir -key key.pem -cert cert.pem|-ks release.keystore [-j N] [-fail-fast] in-dir out-dir", signDirCommand},
+		{"verify", "verify [-idsig app.apk.idsig] [-cert-sha256 fp,...] [-min-rsa-bits N] app.apk|-|URL", verifyCommand},
+		{"align", "align [-page 4|16] in.apk|-|URL [out.apk|-|URL] | align -c [-page 4|16] app.apk", alignCommand},
+		{"info", "info [-json] app.apk|-|URL", infoCommand},
+		{"edit", "edit [-dry-run] [-package NAME] [-version-code N] [-version-name S] [-label S] [-key key.pem -cert cert.pem|-ks release.keystore] in.apk|-|URL [out.apk|-|URL]", editCommand},
+		{"install", "install [-device SERIAL] [-incremental] app.apk [split.apk...] | app.apks|app.xapk|app.apkm", installCommand},
+		{"repackage", "repackage [-device SERIAL] -key key.pem -cert cert.pem|-ks release.keystore [-keep-data] [-dir DIR] package", repackageCommand},
+		{"delta", "delta diff old.apk new.apk out.patch | delta apply old.apk in.patch out.apk", deltaCommand},
+		{"obb", "obb -apk app.apk [-patch] [-o DIR] DIR | obb -apk app.apk -check file.obb", obbCommand},
+		{"parity", "parity app.aab app.apk", parityCommand},
+		{"compare", "compare [-json] a.apk b.apk", compareCommand},
+		{"serve", "serve -addr :8080 -key key.pem -cert cert.pem [-api-keys k1,k2] [-tls-cert c -tls-key k] [-remote-storage] [-policy policy.json]", serveCommand},
+		{"keygen", "keygen [-cn NAME] [-days N] [-type rsa|ec] -ks debug.keystore|-key key.pem -cert cert.pem", keygenCommand},
+		{"apksigner", "apksigner sign|verify [apksigner options] app.apk | apksigner rotate --out lineage [--in lineage] --old-signer OPTIONS --new-signer OPTIONS", apksignerCommand},
+		{"completion", "completion bash|zsh|fish", completionCommand},
+	}
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	// 库内部使用标准 log 输出调试信息, 只在 -v 时显示
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+	args := flag.Args()
+	if len(args) < 1 {
+		usage()
+		os.Exit(2)
+	}
+	aliases, err := loadAliases()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "aliases: %v\n", err)
+		os.Exit(1)
+	}
+	args = aliases.expand(args)
+	name := args[0]
+	for _, c := range commands {
+		if c.name == name {
+			fs := flag.NewFlagSet(name, flag.ExitOnError)
+			run := c.flags(fs)
+			fs.Parse(args[1:])
+			if err := run(fs.Args()); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n", name)
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	app := filepath.Base(os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-q|-v] %s\n", app, c.usage)
+	}
+}
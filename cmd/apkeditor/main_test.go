@@ -0,0 +1,146 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pzx521521/apk-editor/editor/apktest"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+)
+
+// run parses args with the flags of cmd and runs it, as main does.
+func run(t *testing.T, cmd func(fs *flag.FlagSet) func(args []string) error, args ...string) error {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	exec := cmd(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	return exec(fs.Args())
+}
+
+// testKeyFiles writes the apktest key to PEM files and returns the flags naming them.
+func testKeyFiles(t *testing.T) []string {
+	t.Helper()
+	sc, err := apktest.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writeKeyFiles(t, sc)
+}
+
+// writeKeyFiles writes the PEM key and certificate of sc and returns the flags naming them.
+func writeKeyFiles(t *testing.T, sc *signv2.SigningCert) []string {
+	t.Helper()
+	dir := t.TempDir()
+	key, cert := filepath.Join(dir, "key.pem"), filepath.Join(dir, "cert.pem")
+	if err := os.WriteFile(key, sc.KeyBytes, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cert, sc.CertBytes, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return []string{"-key", key, "-cert", cert}
+}
+
+// testApk writes an unsigned apk built from c and returns its path.
+func testApk(t *testing.T, c apktest.Config) string {
+	t.Helper()
+	apk, err := apktest.Build(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "in.apk")
+	if err = os.WriteFile(path, apk, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// testSignedApk writes an apk built from c and signed by keys, or the apktest key, and returns its
+// path.
+func testSignedApk(t *testing.T, c apktest.Config, keys ...*signv2.SigningCert) string {
+	t.Helper()
+	apk, err := apktest.BuildSigned(c, keys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "signed.apk")
+	if err = os.WriteFile(path, apk, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// withStdio runs f with stdin read from the file in and returns what it wrote to stdout.
+func withStdio(t *testing.T, in string, f func()) []byte {
+	t.Helper()
+	stdin, stdout := os.Stdin, os.Stdout
+	defer func() { os.Stdin, os.Stdout = stdin, stdout }()
+	var err error
+	if in != "" {
+		if os.Stdin, err = os.Open(in); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Stdin.Close()
+	}
+	out := filepath.Join(t.TempDir(), "stdout")
+	if os.Stdout, err = os.Create(out); err != nil {
+		t.Fatal(err)
+	}
+	f()
+	os.Stdout.Close()
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// TestFlags covers the argument checks each command makes before touching its input.
+func TestFlags(t *testing.T) {
+	keys := testKeyFiles(t)
+	for _, c := range []struct {
+		cmd  string
+		args []string
+		err  string
+	}{
+		{"sign", nil, "usage: sign"},
+		{"sign", []string{"in.apk"}, "-key and -cert, or -ks, are required"},
+		{"sign", append([]string{"-stamp-key", "k.pem"}, append(keys, "in.apk")...), "-stamp-key and -stamp-cert must be given together"},
+		{"verify", nil, "usage: verify"},
+		{"verify", []string{"-algorithms", "0x0103,rsa", "app.apk"}, `bad algorithm ID "rsa"`},
+		{"info", []string{"a.apk", "b.apk"}, "usage: info"},
+		{"edit", []string{"in.apk"}, "nothing to edit"},
+		{"edit", []string{"-version-code", "abc", "in.apk"}, "invalid value"},
+		{"install", nil, "missing apk path"},
+		{"install", []string{"-r=false", "missing.apk"}, "no such file"},
+		{"apksigner", nil, "usage: apksigner"},
+		{"apksigner", []string{"zipalign"}, `unknown apksigner command "zipalign"`},
+		{"apksigner", []string{"sign", "app.apk"}, "--ks, or --key and --cert, are required"},
+		{"apksigner", []string{"verify"}, "missing input apk"},
+		{"apksigner", []string{"rotate", "--old-signer", "--ks", "old.keystore"}, "--out is required"},
+		{"apksigner", []string{"rotate", "--out", "lineage", "--old-signer", "--new-signer"}, "--old-signer: --ks, or --key and --cert, are required"},
+	} {
+		var cmd *command
+		for _, x := range commands {
+			if x.name == c.cmd {
+				cmd = x
+			}
+		}
+		err := run(t, cmd.flags, c.args...)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s %q: got %v, want %q", c.cmd, c.args, err, c.err)
+		}
+	}
+}
+
+func TestMain(m *testing.M) {
+	*quiet = true
+	os.Exit(m.Run())
+}
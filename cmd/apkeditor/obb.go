@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/pzx521521/apk-editor/editor"
+)
+
+func obbCommand(fs *flag.FlagSet) func(args []string) error {
+	apkPath := fs.String("apk", "", "扩展文件所属的 apk, 提供包名和 versionCode")
+	patch := fs.Bool("patch", false, "打包为 patch 扩展文件, 默认为 main")
+	outDir := fs.String("o", ".", "输出目录")
+	check := fs.Bool("check", false, "只检查已有的 .obb 文件名是否与 apk 匹配")
+	return func(args []string) error {
+		if *apkPath == "" || len(args) != 1 {
+			return errors.New("usage: obb -apk app.apk [-patch] [-o DIR] DIR | obb -apk app.apk -check file.obb")
+		}
+		apk, err := readInput(*apkPath)
+		if err != nil {
+			return err
+		}
+		if *check {
+			if err = editor.CheckObb(apk, args[0]); err != nil {
+				return err
+			}
+			infof("%s matches %s\n", filepath.Base(args[0]), *apkPath)
+			return nil
+		}
+		files, err := readTree(args[0])
+		if err != nil {
+			return err
+		}
+		kind := editor.ObbMain
+		if *patch {
+			kind = editor.ObbPatch
+		}
+		name, obb, err := editor.PackageObb(apk, kind, files)
+		if err != nil {
+			return err
+		}
+		out := filepath.Join(*outDir, name)
+		if err = os.WriteFile(out, obb, 0644); err != nil {
+			return err
+		}
+		infof("wrote %s\n", out)
+		return nil
+	}
+}
+
+// readTree reads all files under dir, keyed by their slash-separated path
+// relative to it.
+func readTree(dir string) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)], err = os.ReadFile(p)
+		return err
+	})
+	return files, err
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+
+	"github.com/pzx521521/apk-editor/editor/bundle"
+)
+
+func parityCommand(fs *flag.FlagSet) func(args []string) error {
+	return func(args []string) error {
+		if len(args) != 2 {
+			return errors.New("usage: parity app.aab app.apk")
+		}
+		aab, err := readInput(args[0])
+		if err != nil {
+			return err
+		}
+		apk, err := readInput(args[1])
+		if err != nil {
+			return err
+		}
+		b, err := bundle.Open(aab)
+		if err != nil {
+			return err
+		}
+		r, err := b.CheckParity(apk)
+		if err != nil {
+			return err
+		}
+		// 报告输出为 JSON 供流水线解析, 有差异时以非零状态退出
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+		e.Encode(r)
+		if !r.Equal() {
+			return errors.New("apk does not match the bundle")
+		}
+		infof("%s matches %s\n", args[1], args[0])
+		return nil
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+
+	"github.com/pzx521521/apk-editor/editor"
+)
+
+// addPolicyFlag declares -policy, shared by sign and serve.
+func addPolicyFlag(fs *flag.FlagSet) *string {
+	return fs.String("policy", "", "签名前按 JSON 策略文件检查 apk: 允许的包名, 最低 targetSdk, 禁止的权限, 最大字节数")
+}
+
+// loadPolicy loads the -policy file, or returns nil when none was given.
+func loadPolicy(name string) (*editor.Policy, error) {
+	if name == "" {
+		return nil, nil
+	}
+	return editor.LoadPolicy(name)
+}
+
+// checkPolicy checks apk against p. A violation prints the JSON report on
+// stderr, so scripts can parse why the apk was refused.
+func checkPolicy(p *editor.Policy, apk []byte) error {
+	err := p.Check(apk)
+	var pe *editor.PolicyError
+	if errors.As(err, &pe) {
+		e := json.NewEncoder(os.Stderr)
+		e.SetIndent("", "  ")
+		e.Encode(pe.Report)
+	}
+	return err
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pzx521521/apk-editor/editor/signv2"
+)
+
+// infof prints a status line unless -q was given.
+func infof(format string, args ...any) {
+	if !*quiet {
+		fmt.Fprintf(os.Stderr, format, args...)
+	}
+}
+
+// progressBar renders library progress on stderr. It returns nil in quiet
+// mode so the library skips the callbacks entirely.
+func progressBar() signv2.ProgressFunc {
+	if *quiet {
+		return nil
+	}
+	last := -1
+	return func(stage string, done, total int64) {
+		if total <= 0 {
+			return
+		}
+		pct := int(done * 100 / total)
+		if pct == last {
+			return
+		}
+		last = pct
+		bar := strings.Repeat("=", pct/4) + strings.Repeat(" ", 25-pct/4)
+		fmt.Fprintf(os.Stderr, "\r%-8s [%s] %3d%%", stage, bar, pct)
+		if done >= total {
+			fmt.Fprintln(os.Stderr)
+			last = -1
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+
+	"github.com/pzx521521/apk-editor/editor/device"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+)
+
+func repackageCommand(fs *flag.FlagSet) func(args []string) error {
+	serial := fs.String("device", "", "目标设备序列号 (adb -s)")
+	adbPath := fs.String("adb", "", "adb 可执行文件路径, 默认从 PATH 查找")
+	kf := addKeyFlags(fs)
+	keepData := fs.Bool("keep-data", false, "不卸载直接覆盖安装, 仅在证书与原签名相同时可用")
+	dir := fs.String("dir", "", "保留拉取的原始 apk 和重签后 apk 的目录, 默认使用临时目录")
+	return func(args []string) error {
+		if len(args) != 1 {
+			return errors.New("usage: repackage -key key.pem -cert cert.pem|-ks release.keystore package")
+		}
+		keys, err := kf.signingCerts()
+		if err != nil {
+			return err
+		}
+		adb := &device.Adb{Path: *adbPath, Serial: *serial, Stdout: os.Stdout, Stderr: os.Stderr}
+		// 用同一组密钥重签 base 和所有 split, 否则无法一起安装
+		resign := func(name string, apk []byte) ([]byte, error) {
+			z, err := signv2.NewApkSignNoCopy(apk)
+			if err != nil {
+				return nil, err
+			}
+			infof("re-signing %s\n", name)
+			return z.SignV2(keys)
+		}
+		return adb.Repackage(args[0], resign, device.RepackageOptions{
+			Install:       device.InstallOptions{Reinstall: true},
+			SkipUninstall: *keepData,
+			Dir:           *dir,
+		})
+	}
+}
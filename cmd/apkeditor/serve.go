@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/pzx521521/apk-editor/editor/service"
+)
+
+func serveCommand(fs *flag.FlagSet) func(args []string) error {
+	addr := fs.String("addr", ":8080", "监听地址")
+	kf := addKeyFlags(fs)
+	apiKeys := fs.String("api-keys", os.Getenv("APKEDITOR_API_KEYS"), "逗号分隔的 API Key, 为空时不校验")
+	maxUpload := fs.Int64("max-upload", service.DefaultMaxUpload, "上传 apk 的最大字节数")
+	tlsCert := fs.String("tls-cert", "", "TLS 证书, 启用 HTTPS 及 gRPC (HTTP/2)")
+	tlsKey := fs.String("tls-key", "", "TLS 私钥")
+	policyFile := addPolicyFlag(fs)
+	auditSpec := addAuditFlag(fs)
+	remote := fs.Bool("remote-storage", false, "启用 POST /v1/sign-url, 使用服务端凭证读写 s3:// gs:// https:// 上的 apk")
+	return func(args []string) error {
+		keys, err := kf.signingCerts()
+		if err != nil {
+			return err
+		}
+		svc, err := service.New(keys)
+		if err != nil {
+			return err
+		}
+		if svc.Policy, err = loadPolicy(*policyFile); err != nil {
+			return err
+		}
+		if svc.Audit, err = openAudit(*auditSpec); err != nil {
+			return err
+		}
+		opts := service.HTTPOptions{MaxUpload: *maxUpload, RemoteStorage: *remote}
+		if *apiKeys != "" {
+			opts.APIKeys = strings.Split(*apiKeys, ",")
+		}
+		handler := service.NewHTTPHandler(svc, opts)
+		if *tlsCert != "" && *tlsKey != "" {
+			infof("serving https and grpc on %s\n", *addr)
+			return http.ListenAndServeTLS(*addr, *tlsCert, *tlsKey, handler)
+		}
+		infof("serving on %s (grpc needs -tls-cert/-tls-key)\n", *addr)
+		return http.ListenAndServe(*addr, handler)
+	}
+}
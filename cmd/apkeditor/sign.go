@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pzx521521/apk-editor/editor"
+	"github.com/pzx521521/apk-editor/editor/audit"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+	"github.com/pzx521521/apk-editor/editor/storage"
+)
+
+func signCommand(fs *flag.FlagSet) func(args []string) error {
+	kf := addKeyFlags(fs)
+	preserve := fs.Bool("preserve-blocks", false, "重新签名未修改的 apk 时保留 Play frosting 和依赖信息块")
+	selfCheck := fs.Bool("self-check", false, "输出前重新解析并校验签名结果 (也可设置 APKEDITOR_SELF_CHECK=1)")
+	lenient := fs.Bool("lenient-zip", false, "容忍厂商在 CD 与 EOCD 之间或 EOCD 之后附加的数据, 签名时丢弃")
+	policyFile := addPolicyFlag(fs)
+	auditSpec := addAuditFlag(fs)
+	report := fs.String("report", "", "签名后写出 JSON 格式的签名报告 (输入输出摘要, 签名方案, 证书指纹) 到该文件")
+	workers := fs.Int("j", 0, "同时计算摘要的 1MB 分块数, 默认为 CPU 数")
+	stampKey := fs.String("stamp-key", "", "SourceStamp 私钥路径 (PEM), 与 -stamp-cert 一起给出时重新生成 SourceStamp")
+	stampCert := fs.String("stamp-cert", "", "SourceStamp 证书路径 (PEM)")
+	return func(args []string) (err error) {
+		if len(args) < 1 || len(args) > 2 {
+			return errors.New("usage: sign -key key.pem -cert cert.pem|-ks release.keystore [-stamp-key k.pem -stamp-cert c.pem] [-policy policy.json] in.apk|in.aab|in.apks|-|URL [out.apk|-|URL]")
+		}
+		out := ""
+		if len(args) == 2 {
+			out = args[1]
+		}
+		keys, err := kf.signingCerts()
+		if err != nil {
+			return err
+		}
+		var stamp *signv2.SigningCert
+		if *stampKey != "" || *stampCert != "" {
+			if *stampKey == "" || *stampCert == "" {
+				return errors.New("-stamp-key and -stamp-cert must be given together")
+			}
+			stamp = &signv2.SigningCert{SigningKey: signv2.SigningKey{KeyPath: *stampKey}, CertPath: *stampCert}
+		}
+		policy, err := loadPolicy(*policyFile)
+		if err != nil {
+			return err
+		}
+		sink, err := openAudit(*auditSpec)
+		if err != nil {
+			return err
+		}
+		// 记录成功或失败的签名, 记录失败时签名也视为失败
+		e := &audit.Event{Time: time.Now(), Op: signv2.OpSign, Actor: localActor(), Key: kf.name()}
+		var signed []byte
+		if sink != nil {
+			defer func() {
+				if err == nil && e.Output == "" {
+					var outArt signv2.Artifact
+					if outArt, err = artifact(out, signed); err == nil {
+						e.Output = outArt.Digest["sha256"]
+					}
+				}
+				e.SetOutcome(err)
+				if aerr := sink.Record(context.Background(), e); aerr != nil && err == nil {
+					err = fmt.Errorf("audit: %w", aerr)
+				}
+			}()
+		}
+		opts := []signv2.Option{signv2.WithConcurrency(*workers)}
+		if *lenient {
+			opts = append(opts, signv2.WithLenientZip())
+		}
+		if isBundle(args[0]) || isSplitSet(args[0]) {
+			// AAB 只用 v1 签名, split 集合逐个签名其中的 apk
+			if *policyFile != "" || *report != "" || stamp != nil {
+				return errors.New("-policy, -report and -stamp-key apply to apks only")
+			}
+			in, err := readInput(args[0])
+			if err != nil {
+				return err
+			}
+			c, err := editor.Open(in)
+			if err != nil {
+				return err
+			}
+			if sink != nil {
+				e.Input = audit.Digest(in)
+			}
+			if signed, err = c.Signed(keys, opts...); err != nil {
+				return err
+			}
+			if err = writeOutput(out, signed); err != nil {
+				return err
+			}
+			if out != "" && out != "-" {
+				infof("signed %s\n", out)
+			}
+			return nil
+		}
+		if (args[0] == "-" || out == "" || out == "-") && !storage.IsURL(args[0]) &&
+			stamp == nil && policy == nil && *report == "" && !*lenient && !*selfCheck {
+			// 管道中流式签名, 只在内存中保留文件末尾; 以上选项需要整个文件, 仍读入内存
+			cfg := signv2.StreamConfig{Keys: keys, PreserveBlocks: *preserve, Progress: progressBar()}
+			var ae *audit.Event
+			if sink != nil {
+				ae = e
+			}
+			if err = signStream(args[0], out, cfg, ae); err != nil {
+				return err
+			}
+			if out != "" && out != "-" {
+				infof("signed %s\n", out)
+			}
+			return nil
+		}
+		var z *signv2.ApkSign
+		var in []byte
+		if args[0] == "-" || storage.IsURL(args[0]) || stamp != nil {
+			// 标准输入和远程存储 (s3://, gs://, https://) 读入内存, 不落盘;
+			// 生成 SourceStamp 可能要添加 stamp-cert-sha256, 也需要整个文件在内存中
+			if in, err = readInput(args[0]); err != nil {
+				return err
+			}
+			if *report != "" {
+				// 报告需要原始输入的摘要, 不能让签名复用 in 的内存
+				z, err = signv2.NewApkSign(in, opts...)
+			} else {
+				z, err = signv2.NewApkSignNoCopy(in, opts...)
+			}
+			if err != nil {
+				return err
+			}
+		} else {
+			// 映射文件而不是读入内存, 大 apk 也不会占用等量内存
+			if z, err = signv2.OpenApkSignFile(args[0], opts...); err != nil {
+				return err
+			}
+			defer z.Close()
+		}
+		if sink != nil {
+			e.Input = audit.Digest(z.RawBytes())
+		}
+		if err = checkPolicy(policy, z.RawBytes()); err != nil {
+			return err
+		}
+		var inArt signv2.Artifact
+		if *report != "" {
+			// 在签名前计算, 输出覆盖输入时也是原始输入的摘要
+			if inArt, err = artifact(args[0], in); err != nil {
+				return err
+			}
+		}
+		z.Progress = progressBar()
+		z.PreserveBlocks = *preserve
+		z.SelfCheck = *selfCheck
+		var signOpts []signv2.Option
+		if stamp != nil {
+			signOpts = append(signOpts, signv2.WithSourceStamp(stamp))
+		}
+		// 输出到文件时写入临时文件后再重命名, 输出与输入相同时也不会破坏正在映射的输入
+		if out == "" || out == "-" || storage.IsURL(out) {
+			if signed, err = z.SignV2(keys, signOpts...); err != nil {
+				return err
+			}
+			if err = writeOutput(out, signed); err != nil {
+				return err
+			}
+		} else if err = z.SignV2ToFile(out, keys, signOpts...); err != nil {
+			return err
+		}
+		if out != "" && out != "-" {
+			infof("signed %s\n", out)
+		}
+		if *report != "" {
+			return writeReport(*report, inArt, out, signed)
+		}
+		return nil
+	}
+}
+
+// signStream 用 signv2.SignStream 签名 in 并写到 out, "-" 为标准输入输出. 与 SignV2 不同,
+// 不会先对齐文件. e 不为空时边读写边计算输入输出的摘要
+func signStream(in, out string, cfg signv2.StreamConfig, e *audit.Event) (err error) {
+	var r io.Reader = os.Stdin
+	if in != "-" {
+		f, err := os.Open(in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	var w io.Writer = os.Stdout
+	var buf *bytes.Buffer
+	switch {
+	case out == "" || out == "-":
+	case storage.IsURL(out):
+		buf = new(bytes.Buffer)
+		w = buf
+	default:
+		f, err := os.Create(out)
+		if err != nil {
+			return err
+		}
+		// 出错时输出不完整, 删除
+		defer func() {
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(out)
+			}
+		}()
+		w = f
+	}
+	if e != nil {
+		inHash, outHash := sha256.New(), sha256.New()
+		r, w = io.TeeReader(r, inHash), io.MultiWriter(w, outHash)
+		defer func() {
+			if err == nil {
+				e.Input, e.Output = hex.EncodeToString(inHash.Sum(nil)), hex.EncodeToString(outHash.Sum(nil))
+			}
+		}()
+	}
+	if err = signv2.SignStream(r, w, cfg); err != nil {
+		return err
+	}
+	if buf != nil {
+		return storage.WriteURL(context.Background(), out, buf.Bytes())
+	}
+	return nil
+}
+
+func isBundle(name string) bool {
+	return strings.ToLower(filepath.Ext(name)) == ".aab"
+}
+
+// artifact 计算文件的摘要, data 为 nil 时从文件 path 读取
+func artifact(path string, data []byte) (signv2.Artifact, error) {
+	name := ""
+	if path != "" && path != "-" {
+		name = filepath.Base(path)
+	}
+	if data != nil {
+		return signv2.NewArtifact(name, bytes.NewReader(data))
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return signv2.Artifact{}, err
+	}
+	defer f.Close()
+	return signv2.NewArtifact(name, f)
+}
+
+// writeReport 写出签名报告到 path. signed 为 nil 时从文件 out 读取输出
+func writeReport(path string, in signv2.Artifact, out string, signed []byte) error {
+	outArt, err := artifact(out, signed)
+	if err != nil {
+		return err
+	}
+	var z *signv2.ApkSign
+	if signed != nil {
+		z, err = signv2.NewApkSignNoCopy(signed)
+	} else {
+		z, err = signv2.OpenApkSignFile(out)
+	}
+	if err != nil {
+		return err
+	}
+	defer z.Close()
+	r, err := signv2.NewSigningReport(in, outArt, z)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0644)
+}
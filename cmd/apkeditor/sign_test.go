@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/pzx521521/apk-editor/editor/apktest"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+)
+
+func TestSignStdio(t *testing.T) {
+	in := testApk(t, apktest.Config{})
+	var err error
+	out := withStdio(t, in, func() {
+		err = run(t, signCommand, append(testKeyFiles(t), "-", "-")...)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := signv2.NewApkSign(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = z.VerifyV2(); err != nil {
+		t.Errorf("stdout does not verify: %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/pzx521521/apk-editor/editor/signv2"
+)
+
+// signDirCommand 并发签名目录下的所有 apk, 用于批量生成渠道包
+func signDirCommand(fs *flag.FlagSet) func(args []string) error {
+	kf := addKeyFlags(fs)
+	workers := fs.Int("j", 0, "同时签名的 apk 数, 默认为 CPU 数")
+	failFast := fs.Bool("fail-fast", false, "有 apk 签名失败时停止, 不再签名其余的 apk")
+	preserve := fs.Bool("preserve-blocks", false, "保留 Play frosting 和依赖信息块")
+	selfCheck := fs.Bool("self-check", false, "输出前重新解析并校验签名结果")
+	return func(args []string) error {
+		if len(args) != 2 {
+			return errors.New("usage: sign-dir -key key.pem -cert cert.pem|-ks release.keystore [-j N] [-fail-fast] in-dir out-dir")
+		}
+		keys, err := kf.signingCerts()
+		if err != nil {
+			return err
+		}
+		// 密钥只解析一次, 由所有任务共用
+		for _, sk := range keys {
+			if err = sk.Resolve(); err != nil {
+				return err
+			}
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		b := signv2.NewBatch(ctx, *workers)
+		b.FailFast = *failFast
+		b.PreserveBlocks = *preserve
+		b.SelfCheck = *selfCheck
+		var submitErr error
+		go func() {
+			_, submitErr = b.SubmitSignDir(args[0], args[1], keys)
+			b.Close()
+		}()
+		signed, failed := 0, 0
+		for res := range b.Results() {
+			if res.Err != nil {
+				failed++
+				fmt.Fprintf(os.Stderr, "%s: %v\n", res.ID, res.Err)
+				continue
+			}
+			signed++
+			infof("signed %s\n", res.Path)
+		}
+		if submitErr != nil {
+			return submitErr
+		}
+		infof("%d signed, %d failed\n", signed, failed)
+		if failed > 0 {
+			return fmt.Errorf("%d of %d apks failed to sign", failed, signed+failed)
+		}
+		return nil
+	}
+}
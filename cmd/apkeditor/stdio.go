@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/pzx521521/apk-editor/editor/storage"
+)
+
+// readInput reads an input file, or standard input when path is "-", or an
+// object in remote storage when path is a URL (s3://, gs://, https://).
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	if storage.IsURL(path) {
+		return storage.ReadURL(context.Background(), path)
+	}
+	return os.ReadFile(path)
+}
+
+// writeOutput writes an output file, or standard output when path is "-"
+// or empty, so commands compose in shell pipelines. A URL path uploads the
+// data to remote storage.
+func writeOutput(path string, data []byte) error {
+	if path == "" || path == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	if storage.IsURL(path) {
+		return storage.WriteURL(context.Background(), path, data)
+	}
+	return os.WriteFile(path, data, 0644)
+}
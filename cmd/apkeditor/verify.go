@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pzx521521/apk-editor/editor/signv2"
+)
+
+func verifyCommand(fs *flag.FlagSet) func(args []string) error {
+	idsig := fs.String("idsig", "", "v4 签名文件 (.idsig), 为空时不验证 v4")
+	certs := fs.String("cert-sha256", "", "逗号分隔的可信证书 SHA-256 指纹, 签名者必须使用其中之一")
+	minRSA := fs.Int("min-rsa-bits", 0, "RSA 签名密钥的最小位数, 如 2048")
+	algs := fs.String("algorithms", "", "逗号分隔的允许的签名算法 ID, 如 0x0103,0x0201")
+	expired := fs.Bool("reject-expired", false, "签名证书过期或未生效时验证失败")
+	rotated := fs.Bool("reject-rotated", false, "可信证书只匹配签名者当前的证书, 不匹配轮换前的证书")
+	return func(args []string) error {
+		if len(args) != 1 {
+			return errors.New("usage: verify [-idsig app.apk.idsig] [-cert-sha256 fp,...] [-min-rsa-bits N] app.apk|-|URL")
+		}
+		policy := signv2.TrustPolicy{MinRSABits: *minRSA, RejectExpired: *expired, RejectRotated: *rotated}
+		if *certs != "" {
+			policy.TrustedSHA256 = strings.Split(*certs, ",")
+		}
+		if *algs != "" {
+			for _, s := range strings.Split(*algs, ",") {
+				id, err := strconv.ParseUint(s, 0, 32)
+				if err != nil {
+					return fmt.Errorf("bad algorithm ID %q", s)
+				}
+				policy.Algorithms = append(policy.Algorithms, signv2.AlgorithmID(id))
+			}
+		}
+		apk, err := readInput(args[0])
+		if err != nil {
+			return err
+		}
+		z, err := signv2.NewApkSignNoCopy(apk)
+		if err != nil {
+			return err
+		}
+		var opts signv2.VerifyOptions
+		if *idsig != "" {
+			if opts.IDSig, err = os.ReadFile(*idsig); err != nil {
+				return err
+			}
+		}
+		res := z.VerifyAll(opts)
+		// 每个存在的签名方案输出一行, 便于脚本检查
+		for _, s := range res.Schemes {
+			switch {
+			case s.Err != nil:
+				fmt.Printf("%s: %v\n", s.Scheme, s.Err)
+			case s.Verified:
+				fmt.Printf("%s: verified\n", s.Scheme)
+			}
+		}
+		for _, w := range res.Warnings {
+			fmt.Printf("warning: %s\n", w)
+		}
+		if err = res.Err(); err != nil {
+			return err
+		}
+		// 指定了策略时检查签名者是否可信, 每条违反的规则输出一行
+		if len(policy.TrustedSHA256) > 0 || len(policy.Algorithms) > 0 || policy.MinRSABits > 0 || policy.RejectExpired {
+			policy.VerifyOptions = opts
+			err = z.VerifyWithPolicy(policy)
+			var te *signv2.TrustError
+			if errors.As(err, &te) {
+				for _, v := range te.Violations {
+					fmt.Printf("policy: %s\n", v)
+				}
+			}
+			if err != nil {
+				return err
+			}
+		}
+		infof("%s verifies\n", args[0])
+		return nil
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/pzx521521/apk-editor/editor/apktest"
+)
+
+func TestVerify(t *testing.T) {
+	signed := testSignedApk(t, apktest.Config{})
+	sc, err := apktest.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sc.Resolve(); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(sc.Certificate.Raw)
+	fp := hex.EncodeToString(sum[:])
+
+	var runErr error
+	out := withStdio(t, "", func() { runErr = run(t, verifyCommand, "-cert-sha256", fp, signed) })
+	if runErr != nil {
+		t.Fatal(runErr)
+	}
+	if !strings.Contains(string(out), "v2: verified") {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+
+	out = withStdio(t, "", func() { runErr = run(t, verifyCommand, "-cert-sha256", strings.Repeat("00", 32), signed) })
+	if runErr == nil || !strings.Contains(string(out), "policy: ") {
+		t.Errorf("untrusted certificate: got %v\n%s", runErr, out)
+	}
+	withStdio(t, "", func() { runErr = run(t, verifyCommand, testApk(t, apktest.Config{})) })
+	if runErr == nil {
+		t.Error("unsigned apk verifies")
+	}
+}
@@ -0,0 +1,104 @@
+package editor
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+// 大小分析的分类
+const (
+	CategoryDex       = "dex"
+	CategoryNative    = "native"
+	CategoryResources = "resources"
+	CategoryArsc      = "arsc"
+	CategoryAssets    = "assets"
+	CategoryManifest  = "manifest"
+	CategorySignature = "signature"
+	CategoryOther     = "other"
+)
+
+// SizeEntry 某一分类的大小统计
+type SizeEntry struct {
+	Category     string `json:"category"`
+	ABI          string `json:"abi,omitempty"` // 仅 native 分类
+	Files        int    `json:"files"`
+	Compressed   int64  `json:"compressed"`
+	Uncompressed int64  `json:"uncompressed"`
+}
+
+// SizeReport apk 的大小构成, 类似 apkanalyzer
+type SizeReport struct {
+	FileSize     int64        `json:"file_size"`
+	Compressed   int64        `json:"compressed"`
+	Uncompressed int64        `json:"uncompressed"`
+	Entries      []*SizeEntry `json:"entries"` // 按压缩后大小降序
+}
+
+// Analyze 按分类统计 apk 中各条目压缩前后的大小, native 库按 ABI 分别统计
+func Analyze(apk []byte) (*SizeReport, error) {
+	return AnalyzeContext(context.Background(), apk)
+}
+
+// AnalyzeContext 同 Analyze, ctx 结束时返回 ctx.Err()
+func AnalyzeContext(ctx context.Context, apk []byte) (_ *SizeReport, err error) {
+	defer catch(&err, "Analyze", "")
+	r, err := zip.NewReader(bytes.NewReader(apk), int64(len(apk)))
+	if err != nil {
+		return nil, err
+	}
+	report := &SizeReport{FileSize: int64(len(apk))}
+	byKey := map[string]*SizeEntry{}
+	for _, f := range r.File {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		if strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		category, abi := categorize(f.Name)
+		key := category + "/" + abi
+		e, ok := byKey[key]
+		if !ok {
+			e = &SizeEntry{Category: category, ABI: abi}
+			byKey[key] = e
+			report.Entries = append(report.Entries, e)
+		}
+		e.Files++
+		e.Compressed += int64(f.CompressedSize64)
+		e.Uncompressed += int64(f.UncompressedSize64)
+		report.Compressed += int64(f.CompressedSize64)
+		report.Uncompressed += int64(f.UncompressedSize64)
+	}
+	sort.SliceStable(report.Entries, func(i, j int) bool {
+		return report.Entries[i].Compressed > report.Entries[j].Compressed
+	})
+	return report, nil
+}
+
+// categorize 根据条目路径判断分类, native 库同时返回 ABI
+func categorize(name string) (category, abi string) {
+	switch {
+	case name == zip.ANDROIDMANIFEST:
+		return CategoryManifest, ""
+	case name == "resources.arsc":
+		return CategoryArsc, ""
+	case strings.HasSuffix(name, ".dex") && !strings.Contains(name, "/"):
+		return CategoryDex, ""
+	case strings.HasPrefix(name, "lib/"):
+		if parts := strings.SplitN(name, "/", 3); len(parts) == 3 {
+			return CategoryNative, parts[1]
+		}
+		return CategoryNative, ""
+	case strings.HasPrefix(name, "res/"):
+		return CategoryResources, ""
+	case strings.HasPrefix(name, ASSETS_DIR):
+		return CategoryAssets, ""
+	case strings.HasPrefix(name, "META-INF/"):
+		return CategorySignature, ""
+	}
+	return CategoryOther, ""
+}
@@ -0,0 +1,41 @@
+package editor
+
+import (
+	"archive/zip"
+	"bytes"
+	"testing"
+)
+
+func TestAnalyze(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	for _, name := range []string{
+		"AndroidManifest.xml", "classes.dex", "classes2.dex", "resources.arsc",
+		"lib/arm64-v8a/libfoo.so", "lib/armeabi-v7a/libfoo.so", "res/layout/main.xml",
+		"assets/index.html", "META-INF/CERT.SF", "kotlin/kotlin.kotlin_builtins",
+	} {
+		f, _ := w.Create(name)
+		f.Write(bytes.Repeat([]byte("x"), 100))
+	}
+	w.Close()
+
+	report, err := Analyze(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]*SizeEntry{}
+	for _, e := range report.Entries {
+		got[e.Category+"/"+e.ABI] = e
+	}
+	for key, files := range map[string]int{
+		"dex/": 2, "native/arm64-v8a": 1, "native/armeabi-v7a": 1, "resources/": 1,
+		"arsc/": 1, "assets/": 1, "manifest/": 1, "signature/": 1, "other/": 1,
+	} {
+		if e := got[key]; e == nil || e.Files != files || e.Uncompressed != int64(files*100) {
+			t.Errorf("%s = %+v, want %d files", key, e, files)
+		}
+	}
+	if report.Uncompressed != 1000 || report.Compressed >= report.Uncompressed {
+		t.Errorf("totals = %d/%d", report.Compressed, report.Uncompressed)
+	}
+}
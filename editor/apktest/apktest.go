@@ -0,0 +1,381 @@
+// Package apktest builds tiny but valid APKs in memory, so that tests of code using this module
+// need no binary fixtures: an APK holds a binary AndroidManifest.xml, a resources.arsc with the
+// application label and an empty classes.dex, and may be signed with a throwaway key.
+package apktest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"hash/adler32"
+	"io"
+	"maps"
+	"math/big"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+
+	"github.com/pzx521521/apk-editor/editor/axml"
+	"github.com/pzx521521/apk-editor/editor/internal/stringpool"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+// DefaultPackage is the package name of an APK whose Config sets none.
+const DefaultPackage = "com.example.test"
+
+// LabelID is the resource ID of the string resource app_name holding the application label.
+const LabelID = 0x7f010000
+
+// IconID is the resource ID of mipmap/ic_launcher, the launcher icon of an APK with Config.Icons.
+const IconID = 0x7f020000
+
+// Resource IDs of the android: attributes the manifest uses.
+const (
+	attrLabel            = 0x01010001
+	attrIcon             = 0x01010002
+	attrName             = 0x01010003
+	attrDebuggable       = 0x0101000f
+	attrMinSdkVersion    = 0x0101020c
+	attrVersionCode      = 0x0101021b
+	attrVersionName      = 0x0101021c
+	attrTargetSdkVersion = 0x01010270
+)
+
+// Chunk types of resources.arsc.
+const (
+	chunkTable    = 0x0002
+	chunkPackage  = 0x0200
+	chunkType     = 0x0201
+	chunkTypeSpec = 0x0202
+)
+
+// Config describes the APK to build; the zero value is a valid APK.
+type Config struct {
+	Package     string            // 为空时使用 DefaultPackage
+	VersionCode int               // 为 0 时使用 1
+	VersionName string            // 为空时使用 "1.0"
+	MinSDK      int               // 为 0 时不写 uses-sdk
+	TargetSDK   int               // 为 0 时不写 targetSdkVersion
+	Label       string            // 应用名称, 写入 resources.arsc 的 app_name, 为空时使用 "Test"
+	Permissions []string          // uses-permission
+	Debuggable  bool              // android:debuggable
+	Files       map[string][]byte // 额外写入的文件, 按名称排序, 压缩存储
+	// Icons 启动图标 mipmap/ic_launcher 在各配置下的文件, 按条目名, 如 res/mipmap-hdpi-v4/ic_launcher.png.
+	// 配置的密度取自目录名的限定符, anydpi 用于自适应图标的 XML. 图片不压缩, 与 aapt2 相同
+	Icons map[string][]byte
+}
+
+func (c Config) withDefaults() Config {
+	if c.Package == "" {
+		c.Package = DefaultPackage
+	}
+	if c.VersionCode == 0 {
+		c.VersionCode = 1
+	}
+	if c.VersionName == "" {
+		c.VersionName = "1.0"
+	}
+	if c.Label == "" {
+		c.Label = "Test"
+	}
+	return c
+}
+
+// Build returns an unsigned APK as described by c. resources.arsc comes first and is stored,
+// aligned as zipalign does; the other entries are deflated.
+func Build(c Config) ([]byte, error) {
+	c = c.withDefaults()
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	add := func(name string, method uint16, data []byte) error {
+		fh := &zip.FileHeader{Name: name, Method: method}
+		var fw io.Writer
+		var err error
+		if method == zip.Store {
+			fw, err = w.CreateAlignedHeader(fh, 4)
+		} else {
+			fw, err = w.CreateHeader(fh)
+		}
+		if err == nil {
+			_, err = fw.Write(data)
+		}
+		return err
+	}
+	icons := slices.Sorted(maps.Keys(c.Icons))
+	if err := add("resources.arsc", zip.Store, resourceTable(c.Package, c.Label, icons)); err != nil {
+		return nil, err
+	}
+	if err := add(zip.ANDROIDMANIFEST, zip.Deflate, Manifest(c)); err != nil {
+		return nil, err
+	}
+	if err := add("classes.dex", zip.Deflate, Dex()); err != nil {
+		return nil, err
+	}
+	for _, name := range icons {
+		method := zip.Store
+		if path.Ext(name) == ".xml" {
+			method = zip.Deflate
+		}
+		if err := add(name, method, c.Icons[name]); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.Files)) {
+		if err := add(name, zip.Deflate, c.Files[name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// BuildSigned returns the APK Build returns for c, signed with the v2 scheme by keys, or by Key if
+// none are given.
+func BuildSigned(c Config, keys ...*signv2.SigningCert) ([]byte, error) {
+	apk, err := Build(c)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		key, err := Key()
+		if err != nil {
+			return nil, err
+		}
+		keys = []*signv2.SigningCert{key}
+	}
+	z, err := signv2.NewApkSignNoCopy(apk)
+	if err != nil {
+		return nil, err
+	}
+	return z.SignV2(keys)
+}
+
+// Key returns a throwaway RSA 2048 signing key with a self-signed certificate. It is generated on
+// the first call and shared by later ones.
+func Key() (*signv2.SigningCert, error) {
+	return key()
+}
+
+var key = sync.OnceValues(func() (*signv2.SigningCert, error) {
+	k, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "apktest"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(30, 0, 0),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &k.PublicKey, k)
+	if err != nil {
+		return nil, err
+	}
+	return &signv2.SigningCert{
+		SigningKey: signv2.SigningKey{
+			KeyBytes: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}),
+			Type:     signv2.RSA,
+			Hash:     signv2.SHA256,
+		},
+		CertBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}, nil
+})
+
+// Manifest returns the binary AndroidManifest.xml of the APK Build returns for c. The application
+// label refers to the string resource LabelID.
+func Manifest(c Config) []byte {
+	c = c.withDefaults()
+	android := func(name string, id uint32, typ uint8, data uint32, raw string) *axml.Attr {
+		return &axml.Attr{NS: axml.NSAndroid, Name: name, ResID: id, Type: typ, Data: data, Raw: raw}
+	}
+	root := &axml.Element{
+		Name:       "manifest",
+		Namespaces: []axml.Namespace{{Prefix: "android", URI: axml.NSAndroid}},
+		Attrs: []*axml.Attr{
+			android("versionCode", attrVersionCode, axml.TypeIntDec, uint32(c.VersionCode), ""),
+			android("versionName", attrVersionName, axml.TypeString, 0, c.VersionName),
+			{Name: "package", Type: axml.TypeString, Raw: c.Package},
+		},
+	}
+	if c.MinSDK != 0 || c.TargetSDK != 0 {
+		sdk := &axml.Element{Name: "uses-sdk"}
+		if c.MinSDK != 0 {
+			sdk.Attrs = append(sdk.Attrs, android("minSdkVersion", attrMinSdkVersion, axml.TypeIntDec, uint32(c.MinSDK), ""))
+		}
+		if c.TargetSDK != 0 {
+			sdk.Attrs = append(sdk.Attrs, android("targetSdkVersion", attrTargetSdkVersion, axml.TypeIntDec, uint32(c.TargetSDK), ""))
+		}
+		root.Children = append(root.Children, sdk)
+	}
+	for _, p := range c.Permissions {
+		root.Children = append(root.Children, &axml.Element{
+			Name:  "uses-permission",
+			Attrs: []*axml.Attr{android("name", attrName, axml.TypeString, 0, p)},
+		})
+	}
+	app := &axml.Element{
+		Name:  "application",
+		Attrs: []*axml.Attr{android("label", attrLabel, axml.TypeReference, LabelID, "")},
+	}
+	if len(c.Icons) > 0 {
+		app.Attrs = append(app.Attrs, android("icon", attrIcon, axml.TypeReference, IconID, ""))
+	}
+	if c.Debuggable {
+		app.Attrs = append(app.Attrs, android("debuggable", attrDebuggable, axml.TypeIntBool, 0xffffffff, ""))
+	}
+	root.Children = append(root.Children, app)
+	return axml.Encode(root)
+}
+
+// ResourceTable returns a resources.arsc for the package pkg holding one string resource,
+// app_name (LabelID) with the value label, in the default configuration.
+func ResourceTable(pkg, label string) []byte {
+	return resourceTable(pkg, label, nil)
+}
+
+// densities are the density qualifiers of resource directories.
+var densities = map[string]uint16{
+	"ldpi": 120, "mdpi": 160, "tvdpi": 213, "hdpi": 240, "xhdpi": 320, "xxhdpi": 480, "xxxhdpi": 640,
+	"anydpi": 0xfffe, "nodpi": 0xffff,
+}
+
+// iconDensity returns the density named by the qualifiers of the directory of the entry name.
+func iconDensity(name string) uint16 {
+	for _, q := range strings.Split(path.Base(path.Dir(name)), "-")[1:] {
+		if d, ok := densities[q]; ok {
+			return d
+		}
+	}
+	return 0
+}
+
+// resourceTable is ResourceTable with, if icons are given, the resource mipmap/ic_launcher
+// (IconID), whose value in the configuration of each of the entries icons is the entry name.
+func resourceTable(pkg, label string, icons []string) []byte {
+	var pool, typePool, keyPool stringpool.Pool
+	pool.Add(label)
+	for _, name := range icons {
+		pool.Add(name)
+	}
+	typePool.Add("string")
+	keyPool.Add("app_name")
+	if len(icons) > 0 {
+		typePool.Add("mipmap")
+		keyPool.Add("ic_launcher")
+	}
+	strs := pool.Encode(true)
+	types := typePool.Encode(true)
+	keys := keyPool.Encode(true)
+
+	le := binary.LittleEndian
+	spec := func(id uint8) []byte {
+		const specHeader = 16
+		b := le.AppendUint16(nil, chunkTypeSpec)
+		b = le.AppendUint16(b, specHeader)
+		b = le.AppendUint32(b, specHeader+4)
+		b = append(b, id, 0, 0, 0)
+		b = le.AppendUint32(b, 1)
+		return le.AppendUint32(b, 0)
+	}
+	// a type chunk: header with a 64 byte configuration, one entry offset and the entry, whose
+	// value is the string str
+	typ := func(id uint8, density uint16, key, str uint32) []byte {
+		const typeHeader = 20 + 64
+		b := le.AppendUint16(nil, chunkType)
+		b = le.AppendUint16(b, typeHeader)
+		b = le.AppendUint32(b, typeHeader+4+16)
+		b = append(b, id, 0, 0, 0)
+		b = le.AppendUint32(b, 1)
+		b = le.AppendUint32(b, typeHeader+4)
+		config := make([]byte, 64)
+		le.PutUint32(config, 64)
+		le.PutUint16(config[14:], density)
+		b = append(b, config...)
+		b = le.AppendUint32(b, 0)
+		b = le.AppendUint16(b, 8)
+		b = le.AppendUint16(b, 0)
+		b = le.AppendUint32(b, key)
+		b = append(b, 8, 0, 0, axml.TypeString)
+		return le.AppendUint32(b, str)
+	}
+	chunks := append(spec(1), typ(1, 0, 0, 0)...)
+	if len(icons) > 0 {
+		chunks = append(chunks, spec(2)...)
+		for i, name := range icons {
+			chunks = append(chunks, typ(2, iconDensity(name), 1, uint32(i+1))...)
+		}
+	}
+
+	const pkgHeader = 288
+	p := le.AppendUint16(nil, chunkPackage)
+	p = le.AppendUint16(p, pkgHeader)
+	p = le.AppendUint32(p, uint32(pkgHeader+len(types)+len(keys)+len(chunks)))
+	p = le.AppendUint32(p, LabelID>>24)
+	name := make([]byte, 256)
+	for i, u := range utf16.Encode([]rune(pkg)) {
+		if i == 127 {
+			break
+		}
+		le.PutUint16(name[2*i:], u)
+	}
+	p = append(p, name...)
+	p = le.AppendUint32(p, pkgHeader)
+	p = le.AppendUint32(p, uint32(typePool.Len()))
+	p = le.AppendUint32(p, uint32(pkgHeader+len(types)))
+	p = le.AppendUint32(p, uint32(keyPool.Len()))
+	p = le.AppendUint32(p, 0)
+	p = append(p, types...)
+	p = append(p, keys...)
+	p = append(p, chunks...)
+
+	t := le.AppendUint16(nil, chunkTable)
+	t = le.AppendUint16(t, 12)
+	t = le.AppendUint32(t, uint32(12+len(strs)+len(p)))
+	t = le.AppendUint32(t, 1)
+	return append(append(t, strs...), p...)
+}
+
+// Dex returns a dex file defining no class: a header and the map list, with a valid checksum and
+// signature.
+func Dex() []byte {
+	const (
+		headerSize = 0x70
+		mapSize    = 4 + 2*12
+		fileSize   = headerSize + mapSize
+	)
+	le := binary.LittleEndian
+	b := make([]byte, fileSize)
+	copy(b, "dex\n035\x00")
+	le.PutUint32(b[32:], fileSize)
+	le.PutUint32(b[36:], headerSize)
+	le.PutUint32(b[40:], 0x12345678)
+	le.PutUint32(b[52:], headerSize)
+	le.PutUint32(b[104:], mapSize)
+	le.PutUint32(b[108:], headerSize)
+
+	m := b[headerSize:]
+	le.PutUint32(m, 2)
+	// header_item, then map_list
+	le.PutUint16(m[4:], 0x0000)
+	le.PutUint32(m[8:], 1)
+	le.PutUint32(m[12:], 0)
+	le.PutUint16(m[16:], 0x1000)
+	le.PutUint32(m[20:], 1)
+	le.PutUint32(m[24:], headerSize)
+
+	sum := sha1.Sum(b[32:])
+	copy(b[12:], sum[:])
+	le.PutUint32(b[8:], adler32.Checksum(b[12:]))
+	return b
+}
@@ -0,0 +1,107 @@
+package apktest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/adler32"
+	"io"
+	"testing"
+
+	"github.com/pzx521521/apk-editor/editor"
+	"github.com/pzx521521/apk-editor/editor/axml"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+func TestBuild(t *testing.T) {
+	apk, err := Build(Config{
+		Package:     "com.example.app",
+		VersionCode: 42,
+		MinSDK:      24,
+		TargetSDK:   34,
+		Permissions: []string{"android.permission.CAMERA"},
+		Debuggable:  true,
+		Files:       map[string][]byte{"assets/b.txt": []byte("b"), "assets/a.txt": []byte("a")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := zip.NewReader(bytes.NewReader(apk), int64(len(apk)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	files := map[string][]byte{}
+	for _, f := range r.File {
+		names = append(names, f.Name)
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name], err = io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"resources.arsc", zip.ANDROIDMANIFEST, "classes.dex", "assets/a.txt", "assets/b.txt"}
+	if len(names) != len(want) {
+		t.Fatalf("entries %q, want %q", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("entries %q, want %q", names, want)
+		}
+	}
+	if off, _ := r.File[0].DataOffset(); off%4 != 0 {
+		t.Errorf("resources.arsc at %d is not aligned", off)
+	}
+
+	manifest, err := axml.Parse(files[zip.ANDROIDMANIFEST])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := manifest.AttrValue("", "package"); got != "com.example.app" {
+		t.Errorf("package %q", got)
+	}
+	if got := manifest.AttrValue(axml.NSAndroid, "versionCode"); got != "42" {
+		t.Errorf("versionCode %q", got)
+	}
+	report, err := editor.Permissions(apk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Requested) != 1 || report.Requested[0].Name != "android.permission.CAMERA" {
+		t.Errorf("requested permissions %+v", report.Requested)
+	}
+	issues, err := editor.Lint(apk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	debuggable := false
+	for _, i := range issues {
+		debuggable = debuggable || i.Rule == editor.LintDebuggable
+	}
+	if !debuggable {
+		t.Errorf("lint issues %v miss %s", issues, editor.LintDebuggable)
+	}
+
+	dex := files["classes.dex"]
+	if binary.LittleEndian.Uint32(dex[8:]) != adler32.Checksum(dex[12:]) {
+		t.Error("dex checksum mismatch")
+	}
+}
+
+func TestBuildSigned(t *testing.T) {
+	apk, err := BuildSigned(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := signv2.NewApkSign(apk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = z.VerifyV2(); err != nil {
+		t.Error(err)
+	}
+}
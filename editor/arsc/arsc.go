@@ -0,0 +1,384 @@
+// Package arsc edits resources.arsc, the resource table of an APK: the names of its packages and
+// the values of simple resources, such as the app_name string or the launcher icon. Entries are
+// edited in place and chunks this package does not edit are kept as they are, so that tables of any
+// platform version survive Parse and Encode.
+package arsc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"unicode/utf16"
+
+	"github.com/pzx521521/apk-editor/editor/internal/restable"
+	"github.com/pzx521521/apk-editor/editor/internal/stringpool"
+)
+
+// Chunk types of resources.arsc.
+const (
+	chunkStringPool = 0x0001
+	chunkTable      = 0x0002
+	chunkPackage    = 0x0200
+	chunkType       = 0x0201
+)
+
+// Layout of the chunks this package reads.
+const (
+	poolFlags       = 16
+	poolUTF8        = 1 << 8
+	pkgName         = 12
+	pkgNameLen      = 128 // UTF-16 units, including the terminating zero
+	pkgTypeStrings  = 268
+	pkgKeyStrings   = 276
+	pkgMinHeader    = 284
+	entryComplex    = 0x0001
+	entryCompact    = 0x0008
+	valueHeaderSize = 8
+	typeConfig      = 20 // ResTable_config in a type chunk
+	configDensity   = 14
+)
+
+// Types of resource values.
+const (
+	TypeNull      = 0x00
+	TypeReference = 0x01
+	TypeString    = 0x03
+	TypeIntDec    = 0x10
+	TypeIntBool   = 0x12
+)
+
+var errTruncated = restable.ErrTruncated
+
+// ErrNotFound is returned for a resource ID the table does not define.
+var ErrNotFound = errors.New("resource not found")
+
+// Table is a decoded resources.arsc.
+type Table struct {
+	Packages []*Package
+	pool     stringpool.Pool // 全局字符串池, 保持原有索引, 新字符串追加在后面
+	strs     []string
+	utf8     bool
+	other    [][]byte // 字符串池和包以外的 chunk, 原样保留
+}
+
+// Package is a package of a table, 0x7f for the resources of the app itself.
+type Package struct {
+	ID   uint32
+	Name string // 最多 127 个 UTF-16 字符
+	// TypeNames are the resource types by type ID - 1, e.g. "string" or "mipmap".
+	TypeNames []string
+	// Keys are the resource names, indexed by the key of each entry.
+	Keys     []string
+	header   []byte
+	typeUTF8 bool
+	keyUTF8  bool
+	chunks   [][]byte // 类型字符串池和名称字符串池以外的 chunk, 按原顺序; 类型 chunk 的值被原地修改
+}
+
+// Value is the value of a resource in one configuration.
+type Value struct {
+	Type uint8
+	Data uint32
+	// String is the string a TypeString value refers to.
+	String string
+	// Density is the screen density of the configuration in dpi, 0 for any; 0xfffe is anydpi and
+	// 0xffff nodpi.
+	Density uint16
+}
+
+// Parse decodes a resources.arsc. The table keeps no reference to b.
+func Parse(b []byte) (*Table, error) {
+	if len(b) < 12 || binary.LittleEndian.Uint16(b) != chunkTable {
+		return nil, errors.New("resources.arsc is not a resource table")
+	}
+	t := &Table{}
+	err := restable.Chunks(b, int(binary.LittleEndian.Uint16(b[2:])), func(_ int, typ uint16, c []byte) error {
+		switch typ {
+		case chunkStringPool:
+			if t.strs != nil {
+				return errors.New("more than one global string pool")
+			}
+			strs, spans, utf8, err := decodePool(c)
+			if err != nil {
+				return err
+			}
+			t.strs, t.utf8 = strs, utf8
+			// 重建字符串池, 保持索引不变: 带样式的字符串在前, 重复的字符串各占一个索引
+			seen := map[string]bool{}
+			for i, s := range strs {
+				switch {
+				case i < len(spans):
+					t.pool.AddStyled(s, spans[i])
+				case seen[s]:
+					t.pool.Append(s)
+				default:
+					seen[s] = true
+					t.pool.Add(s)
+				}
+			}
+		case chunkPackage:
+			p, err := parsePackage(c)
+			if err != nil {
+				return err
+			}
+			t.Packages = append(t.Packages, p)
+		default:
+			t.other = append(t.other, clone(c))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func parsePackage(c []byte) (*Package, error) {
+	hdr := int(binary.LittleEndian.Uint16(c[2:]))
+	if hdr < pkgMinHeader || hdr > len(c) {
+		return nil, errTruncated
+	}
+	p := &Package{ID: binary.LittleEndian.Uint32(c[8:]), header: clone(c[:hdr])}
+	name := make([]uint16, 0, pkgNameLen)
+	for i := range pkgNameLen {
+		u := binary.LittleEndian.Uint16(c[pkgName+2*i:])
+		if u == 0 {
+			break
+		}
+		name = append(name, u)
+	}
+	p.Name = string(utf16.Decode(name))
+	typeStrings := int(binary.LittleEndian.Uint32(c[pkgTypeStrings:]))
+	keyStrings := int(binary.LittleEndian.Uint32(c[pkgKeyStrings:]))
+	err := restable.Chunks(c, hdr, func(off int, typ uint16, chunk []byte) (err error) {
+		switch {
+		case typ == chunkStringPool && off == typeStrings:
+			p.TypeNames, _, p.typeUTF8, err = decodePool(chunk)
+		case typ == chunkStringPool && off == keyStrings:
+			p.Keys, _, p.keyUTF8, err = decodePool(chunk)
+		default:
+			p.chunks = append(p.chunks, clone(chunk))
+		}
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("package 0x%02x: %w", p.ID, err)
+	}
+	return p, nil
+}
+
+func decodePool(c []byte) ([]string, [][]stringpool.Span, bool, error) {
+	strs, spans, err := stringpool.Decode(c)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	return strs, spans, binary.LittleEndian.Uint32(c[poolFlags:])&poolUTF8 != 0, nil
+}
+
+// Encode returns the table as a resources.arsc.
+func (t *Table) Encode() []byte {
+	body := t.pool.Encode(t.utf8)
+	for _, p := range t.Packages {
+		body = append(body, p.encode()...)
+	}
+	for _, c := range t.other {
+		body = append(body, c...)
+	}
+	b := binary.LittleEndian.AppendUint16(nil, chunkTable)
+	b = binary.LittleEndian.AppendUint16(b, 12)
+	b = binary.LittleEndian.AppendUint32(b, uint32(12+len(body)))
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(t.Packages)))
+	return append(b, body...)
+}
+
+func (p *Package) encode() []byte {
+	var typePool, keyPool stringpool.Pool
+	for _, s := range p.TypeNames {
+		typePool.Append(s)
+	}
+	for _, s := range p.Keys {
+		keyPool.Append(s)
+	}
+	typeStrings := typePool.Encode(p.typeUTF8)
+	keyStrings := keyPool.Encode(p.keyUTF8)
+	hdr := len(p.header)
+
+	b := clone(p.header)
+	binary.LittleEndian.PutUint32(b[8:], p.ID)
+	name := utf16.Encode([]rune(p.Name))
+	for i := range pkgNameLen {
+		var u uint16
+		if i < len(name) && i < pkgNameLen-1 {
+			u = name[i]
+		}
+		binary.LittleEndian.PutUint16(b[pkgName+2*i:], u)
+	}
+	binary.LittleEndian.PutUint32(b[pkgTypeStrings:], uint32(hdr))
+	binary.LittleEndian.PutUint32(b[pkgKeyStrings:], uint32(hdr+len(typeStrings)))
+	b = append(b, typeStrings...)
+	b = append(b, keyStrings...)
+	for _, c := range p.chunks {
+		b = append(b, c...)
+	}
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(b)))
+	return b
+}
+
+// Package returns the package with the given ID, or nil.
+func (t *Table) Package(id uint32) *Package {
+	for _, p := range t.Packages {
+		if p.ID == id {
+			return p
+		}
+	}
+	return nil
+}
+
+// Lookup returns the ID of the resource typ/name, e.g. string/app_name, in any package.
+func (t *Table) Lookup(typ, name string) (uint32, bool) {
+	for _, p := range t.Packages {
+		for _, c := range p.chunks {
+			if binary.LittleEndian.Uint16(c) != chunkType || len(c) < 20 || int(c[8]) == 0 ||
+				int(c[8]) > len(p.TypeNames) || p.TypeNames[c[8]-1] != typ {
+				continue
+			}
+			var id uint32
+			_ = restable.TypeEntries(c, func(entry int, e []byte) error {
+				if key := restable.EntryKey(e); int64(key) < int64(len(p.Keys)) && p.Keys[key] == name {
+					id = p.ID<<24 | uint32(c[8])<<16 | uint32(entry)
+					return errFound
+				}
+				return nil
+			})
+			if id != 0 {
+				return id, true
+			}
+		}
+	}
+	return 0, false
+}
+
+var errFound = errors.New("found")
+
+// Values returns the value of a simple resource in each configuration that defines it.
+func (t *Table) Values(id uint32) ([]Value, error) {
+	var vs []Value
+	err := t.values(id, func(v value) error {
+		vs = append(vs, t.value(v))
+		return nil
+	})
+	return vs, err
+}
+
+// value decodes v.
+func (t *Table) value(v value) Value {
+	x := Value{Type: *v.typ, Data: binary.LittleEndian.Uint32(v.data), Density: v.density}
+	if x.Type == TypeString && int64(x.Data) < int64(len(t.strs)) {
+		x.String = t.strs[x.Data]
+	}
+	return x
+}
+
+// addString returns the index of s in the global string pool, adding it if needed.
+func (t *Table) addString(s string) uint32 {
+	i := t.pool.Add(s)
+	if int(i) == len(t.strs) {
+		t.strs = append(t.strs, s)
+	}
+	return i
+}
+
+// SetString sets a simple resource to the string s in every configuration, e.g. string/app_name.
+func (t *Table) SetString(id uint32, s string) error {
+	return t.Set(id, TypeString, t.addString(s))
+}
+
+// SetReference makes a simple resource refer to the resource target in every configuration, e.g.
+// to swap the launcher icon mipmap/ic_launcher for another drawable.
+func (t *Table) SetReference(id, target uint32) error {
+	return t.Set(id, TypeReference, target)
+}
+
+// Set sets the type and data of a simple resource in every configuration. Bags, such as styles
+// and arrays, cannot be set.
+func (t *Table) Set(id uint32, typ uint8, data uint32) error {
+	return t.values(id, func(v value) error {
+		*v.typ = typ
+		binary.LittleEndian.PutUint32(v.data, data)
+		return nil
+	})
+}
+
+// Update calls fn with the value of a simple resource in each configuration that defines it, and
+// replaces the value with the one fn returns if ok is true, e.g. to change one configuration of the
+// launcher icon. For a TypeString value, String is set and Data ignored.
+func (t *Table) Update(id uint32, fn func(v Value) (_ Value, ok bool)) error {
+	return t.values(id, func(v value) error {
+		x, ok := fn(t.value(v))
+		if !ok {
+			return nil
+		}
+		if x.Type == TypeString {
+			x.Data = t.addString(x.String)
+		}
+		*v.typ = x.Type
+		binary.LittleEndian.PutUint32(v.data, x.Data)
+		return nil
+	})
+}
+
+// value points at the type and data of a value within a type chunk.
+type value struct {
+	typ     *uint8
+	data    []byte
+	density uint16
+}
+
+// values calls fn with the value of id in each configuration.
+func (t *Table) values(id uint32, fn func(value) error) error {
+	p := t.Package(id >> 24)
+	if p == nil {
+		return fmt.Errorf("%w: 0x%08x", ErrNotFound, id)
+	}
+	typ, index := uint8(id>>16), int(id&0xffff)
+	found := false
+	for _, c := range p.chunks {
+		if binary.LittleEndian.Uint16(c) != chunkType || len(c) < 20 || c[8] != typ {
+			continue
+		}
+		var density uint16
+		if len(c) >= typeConfig+configDensity+2 && binary.LittleEndian.Uint32(c[typeConfig:]) >= configDensity+2 {
+			density = binary.LittleEndian.Uint16(c[typeConfig+configDensity:])
+		}
+		err := restable.TypeEntries(c, func(entry int, e []byte) error {
+			if entry != index {
+				return nil
+			}
+			found = true
+			flags := binary.LittleEndian.Uint16(e[2:])
+			switch {
+			case flags&entryCompact != 0:
+				// 紧凑格式: 类型在 flags 的高字节, 数据在 key 之后
+				return fn(value{&e[3], e[4:8], density})
+			case flags&entryComplex != 0:
+				return fmt.Errorf("0x%08x is not a simple resource", id)
+			}
+			size := int(binary.LittleEndian.Uint16(e))
+			if size+valueHeaderSize > len(e) {
+				return errTruncated
+			}
+			return fn(value{&e[size+3], e[size+4 : size+8], density})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if !found {
+		return fmt.Errorf("%w: 0x%08x", ErrNotFound, id)
+	}
+	return nil
+}
+
+func clone(b []byte) []byte {
+	return append([]byte(nil), b...)
+}
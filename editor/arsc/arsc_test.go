@@ -0,0 +1,172 @@
+package arsc
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/pzx521521/apk-editor/editor/apktest"
+)
+
+func TestEdit(t *testing.T) {
+	b := apktest.ResourceTable("com.example", "Test")
+	table, err := Parse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(table.Encode(), b) {
+		t.Error("unchanged table encodes differently")
+	}
+	id, ok := table.Lookup("string", "app_name")
+	if !ok || id != apktest.LabelID {
+		t.Fatalf("Lookup = 0x%08x, %v", id, ok)
+	}
+	if _, ok = table.Lookup("string", "missing"); ok {
+		t.Error("Lookup found a missing resource")
+	}
+
+	table.Package(0x7f).Name = "org.renamed"
+	if err = table.SetString(id, "Renamed"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Parse(table.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := got.Package(0x7f).Name; name != "org.renamed" {
+		t.Errorf("package = %q", name)
+	}
+	vs, err := got.Values(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Value{{Type: TypeString, Data: 1, String: "Renamed"}}; !reflect.DeepEqual(vs, want) {
+		t.Errorf("Values = %+v, want %+v", vs, want)
+	}
+
+	// setting a string already in the pool reuses it
+	if err = got.SetString(id, "Test"); err != nil {
+		t.Fatal(err)
+	}
+	if vs, _ = got.Values(id); vs[0].Data != 0 || len(got.strs) != 2 {
+		t.Errorf("Values = %+v with %d strings", vs, len(got.strs))
+	}
+	if err = got.SetReference(id, 0x7f020000); err != nil {
+		t.Fatal(err)
+	}
+	if vs, _ = got.Values(id); vs[0].Type != TypeReference || vs[0].Data != 0x7f020000 {
+		t.Errorf("Values = %+v", vs)
+	}
+	for _, missing := range []uint32{0x7f010001, 0x7f020000, 0x01010000} {
+		if err = got.SetString(missing, "x"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("0x%08x: got %v", missing, err)
+		}
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	apk, err := apktest.Build(apktest.Config{Icons: map[string][]byte{
+		"res/mipmap-hdpi-v4/ic_launcher.png":    []byte("png"),
+		"res/mipmap-anydpi-v26/ic_launcher.xml": []byte("xml"),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := zip.NewReader(bytes.NewReader(apk), int64(len(apk)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := r.Open("resources.arsc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err := Parse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// only the anydpi configuration changes
+	err = table.Update(apktest.IconID, func(v Value) (Value, bool) {
+		if v.Density != 0xfffe {
+			return v, false
+		}
+		v.String = "res/mipmap-anydpi-v26/ic_launcher.png"
+		return v, true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Parse(table.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	vs, err := got.Values(apktest.IconID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[uint16]string{240: "res/mipmap-hdpi-v4/ic_launcher.png", 0xfffe: "res/mipmap-anydpi-v26/ic_launcher.png"}
+	if len(vs) != len(want) {
+		t.Fatalf("Values = %+v", vs)
+	}
+	for _, v := range vs {
+		if v.Type != TypeString || want[v.Density] != v.String {
+			t.Errorf("density %d: %q", v.Density, v.String)
+		}
+	}
+}
+
+func TestParseRelease(t *testing.T) {
+	r, err := zip.OpenReader("../../release/app-release.apk")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer r.Close()
+	f, err := r.Open("resources.arsc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table, err := Parse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the demo app has its label in the manifest, so edit a string of its libraries
+	id, ok := table.Lookup("string", "abc_action_mode_done")
+	if !ok {
+		t.Fatal("no string/abc_action_mode_done")
+	}
+	if err = table.SetString(id, "Renamed"); err != nil {
+		t.Fatal(err)
+	}
+	encoded := table.Encode()
+	got, err := Parse(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Encode(), encoded) {
+		t.Error("encoding is not stable")
+	}
+	vs, err := got.Values(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range vs {
+		if v.String != "Renamed" {
+			t.Errorf("abc_action_mode_done = %+v", v)
+		}
+	}
+	if !reflect.DeepEqual(got.strs[:len(table.strs)-1], table.strs[:len(table.strs)-1]) {
+		t.Error("string pool indices changed")
+	}
+}
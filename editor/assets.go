@@ -0,0 +1,50 @@
+package editor
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// AddAsset 编辑时把 data 写入 assets/name, 已有同名条目时替换. 已压缩的格式 (png, jpg, mp3 等)
+// 不压缩并 4 字节对齐, 以便运行时直接映射, 其余压缩存储
+func (a *ApkEditor) AddAsset(name string, data []byte) error {
+	name = strings.TrimPrefix(filepath.ToSlash(name), "/")
+	if name == "" || !fs.ValidPath(name) {
+		return fmt.Errorf("invalid asset path %q", name)
+	}
+	for _, e := range a.assets {
+		if e.Name == ASSETS_DIR+name {
+			e.Data = data
+			return nil
+		}
+	}
+	a.assets = append(a.assets, &MergeEntry{ASSETS_DIR + name, data})
+	return nil
+}
+
+// AddAssetDir 把目录 dir 下的所有文件按相对路径加入 assets/, 如 dir/js/app.js 写入 assets/js/app.js
+func (a *ApkEditor) AddAssetDir(dir string) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		return a.AddAsset(rel, data)
+	})
+}
+
+// storedAsset 条目是否按 aapt2 的默认规则不压缩存储
+func storedAsset(name string) bool {
+	return noCompressExts[strings.ToLower(path.Ext(name))]
+}
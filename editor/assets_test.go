@@ -0,0 +1,89 @@
+package editor
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pzx521521/apk-editor/editor/apktest"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+func TestAddAsset(t *testing.T) {
+	apk, err := apktest.Build(apktest.Config{Files: map[string][]byte{"assets/config.json": []byte("old")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := apktest.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "img"), 0755)
+	os.WriteFile(filepath.Join(dir, "img", "logo.png"), []byte("png"), 0644)
+	os.WriteFile(filepath.Join(dir, "app.js"), []byte("js"), 0644)
+
+	a := NewApkEditor(apk, key.KeyBytes, key.CertBytes)
+	if err = a.AddAsset("config.json", []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.AddAssetDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.AddAsset("../escape", nil); err == nil {
+		t.Error("AddAsset accepted a path outside assets/")
+	}
+	signed, err := a.Edit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := signv2.NewApkSign(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = z.VerifyV2(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := zip.NewReader(bytes.NewReader(signed), int64(len(signed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]struct {
+		data   string
+		method uint16
+	}{
+		"assets/config.json":  {"new", zip.Deflate},
+		"assets/app.js":       {"js", zip.Deflate},
+		"assets/img/logo.png": {"png", zip.Store},
+	}
+	seen := map[string]bool{}
+	for _, f := range r.File {
+		w, ok := want[f.Name]
+		if !ok {
+			continue
+		}
+		if seen[f.Name] {
+			t.Errorf("%s listed twice", f.Name)
+		}
+		seen[f.Name] = true
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		if string(data) != w.data || f.Method != w.method {
+			t.Errorf("%s = %q (method %d), want %q (method %d)", f.Name, data, f.Method, w.data, w.method)
+		}
+		if off, _ := f.DataOffset(); f.Method == zip.Store && off%4 != 0 {
+			t.Errorf("%s: data at %d is not aligned", f.Name, off)
+		}
+	}
+	if len(seen) != len(want) {
+		t.Errorf("found %v, want %d assets", seen, len(want))
+	}
+}
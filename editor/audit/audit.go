@@ -0,0 +1,183 @@
+// Package audit records the signing, verification and edit operations of a shared signing service
+// to a pluggable sink: a JSON lines file, syslog or a webhook. Each event says who asked for the
+// operation, the digests of what went in and came out, which key signed it and how it ended.
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Outcomes of an Event.
+const (
+	OutcomeOK    = "ok"
+	OutcomeError = "error"
+)
+
+// Event is one audited operation.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Op      string    `json:"op"`              // signv2.OpSign, OpVerify or OpEdit
+	Actor   string    `json:"actor,omitempty"` // who asked for the operation, see WithActor
+	Input   string    `json:"input,omitempty"` // hex SHA-256 of the input file
+	Output  string    `json:"output,omitempty"`
+	Key     string    `json:"key,omitempty"` // alias of the signing key, for sign and edit
+	Outcome string    `json:"outcome"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// SetOutcome sets Outcome and Error from the result of the operation.
+func (e *Event) SetOutcome(err error) {
+	e.Outcome, e.Error = OutcomeOK, ""
+	if err != nil {
+		e.Outcome, e.Error = OutcomeError, err.Error()
+	}
+}
+
+// Digest returns the hex SHA-256 of b, the form of Event.Input and Event.Output.
+func Digest(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// Sink stores audit events. Implementations must be safe for concurrent use. A service should treat
+// a failing sink as a failed operation, so that nothing is signed without a record.
+type Sink interface {
+	Record(ctx context.Context, e *Event) error
+}
+
+type actorKey struct{}
+
+// WithActor returns a context carrying the identity of the caller, recorded as Event.Actor.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// Actor returns the identity set by WithActor, or "".
+func Actor(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// File writes each event as a line of JSON.
+type File struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewFile returns a sink writing to w.
+func NewFile(w io.Writer) *File {
+	return &File{w: w}
+}
+
+// OpenFile returns a sink appending to the file name, created if needed. Close closes the file.
+func OpenFile(name string) (*File, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return NewFile(f), nil
+}
+
+func (f *File) Record(_ context.Context, e *Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// a single write, so that concurrent writers to the file never interleave lines
+	_, err = f.w.Write(append(b, '\n'))
+	return err
+}
+
+// Close closes the underlying writer if it is an io.Closer.
+func (f *File) Close() error {
+	if c, ok := f.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Webhook POSTs each event as JSON to URL; any status but 2xx is an error.
+type Webhook struct {
+	URL    string
+	Header http.Header  // extra request headers, e.g. Authorization
+	Client *http.Client // nil means http.DefaultClient
+}
+
+func (w *Webhook) Record(ctx context.Context, e *Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	for k, v := range w.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("audit webhook %s: %s", w.URL, resp.Status)
+	}
+	return nil
+}
+
+// Multi records every event with all sinks, and fails if any of them does.
+type Multi []Sink
+
+func (m Multi) Record(ctx context.Context, e *Event) error {
+	var errs []error
+	for _, s := range m {
+		errs = append(errs, s.Record(ctx, e))
+	}
+	return errors.Join(errs...)
+}
+
+// Open returns the sink described by spec, as given on the command line: "syslog" or
+// "syslog:TAG" for the local syslog daemon, an http:// or https:// URL for a Webhook, or else the
+// name of a file to append to.
+func Open(spec string) (Sink, error) {
+	switch {
+	case spec == "syslog" || strings.HasPrefix(spec, "syslog:"):
+		tag := strings.TrimPrefix(strings.TrimPrefix(spec, "syslog"), ":")
+		if tag == "" {
+			tag = "apkeditor"
+		}
+		s, err := NewSyslog(tag)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		return &Webhook{URL: spec}, nil
+	}
+	f, err := OpenFile(spec)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
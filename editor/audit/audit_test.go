@@ -0,0 +1,79 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "audit.log")
+	s, err := Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := WithActor(context.Background(), "alice")
+	for _, err := range []error{nil, errors.New("bad apk")} {
+		e := &Event{Time: time.Now(), Op: "sign", Actor: Actor(ctx), Input: Digest([]byte("in")), Key: "release"}
+		e.SetOutcome(err)
+		if err := s.Record(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.(*File).Close()
+
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var got []Event
+	for sc := bufio.NewScanner(f); sc.Scan(); {
+		var e Event
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, e)
+	}
+	if len(got) != 2 || got[0].Outcome != OutcomeOK || got[1].Outcome != OutcomeError || got[1].Error != "bad apk" || got[0].Actor != "alice" {
+		t.Errorf("events = %+v", got)
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var got Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	e := &Event{Op: "verify", Outcome: OutcomeOK}
+	hook := &Webhook{URL: srv.URL, Header: http.Header{"Authorization": {"Bearer token"}}}
+	if err := hook.Record(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+	if got.Op != "verify" {
+		t.Errorf("webhook got %+v", got)
+	}
+
+	buf := new(bytes.Buffer)
+	m := Multi{NewFile(buf), &Webhook{URL: srv.URL}}
+	if err := m.Record(context.Background(), e); err == nil {
+		t.Error("Multi ignored a failing sink")
+	}
+	if buf.Len() == 0 {
+		t.Error("Multi skipped the sinks after a failing one")
+	}
+}
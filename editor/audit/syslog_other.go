@@ -0,0 +1,25 @@
+//go:build !unix
+
+package audit
+
+import (
+	"context"
+	"errors"
+)
+
+// Syslog is not available on this platform.
+type Syslog struct{}
+
+// NewSyslog fails: there is no syslog daemon on this platform.
+func NewSyslog(tag string) (*Syslog, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
+
+func (s *Syslog) Record(context.Context, *Event) error {
+	return errors.New("syslog is not supported on this platform")
+}
+
+// Close does nothing.
+func (s *Syslog) Close() error {
+	return nil
+}
@@ -0,0 +1,40 @@
+//go:build unix
+
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"log/syslog"
+)
+
+// Syslog sends each event as JSON to the local syslog daemon, failed operations with priority
+// warning and the others with info.
+type Syslog struct {
+	w *syslog.Writer
+}
+
+// NewSyslog connects to the local syslog daemon, logging with the given tag to the auth facility.
+func NewSyslog(tag string) (*Syslog, error) {
+	w, err := syslog.New(syslog.LOG_AUTH|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &Syslog{w}, nil
+}
+
+func (s *Syslog) Record(_ context.Context, e *Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if e.Outcome != OutcomeOK {
+		return s.w.Warning(string(b))
+	}
+	return s.w.Info(string(b))
+}
+
+// Close closes the connection to the daemon.
+func (s *Syslog) Close() error {
+	return s.w.Close()
+}
@@ -0,0 +1,344 @@
+// Package axml decodes, edits and encodes Android binary XML, the compiled form of
+// AndroidManifest.xml and of the XML files under res/.
+package axml
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/pzx521521/apk-editor/editor/internal/stringpool"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+)
+
+// Chunk types of the binary XML format.
+const (
+	chunkStringPool   = 0x0001
+	chunkXML          = 0x0003
+	chunkStartNS      = 0x0100
+	chunkEndNS        = 0x0101
+	chunkStartElement = 0x0102
+	chunkEndElement   = 0x0103
+	chunkCData        = 0x0104
+	chunkResourceMap  = 0x0180
+)
+
+// Typed value types of attributes.
+const (
+	TypeNull      = 0x00
+	TypeReference = 0x01
+	TypeAttribute = 0x02
+	TypeString    = 0x03
+	TypeFloat     = 0x04
+	TypeDimension = 0x05
+	TypeFraction  = 0x06
+	TypeIntDec    = 0x10
+	TypeIntHex    = 0x11
+	TypeIntBool   = 0x12
+)
+
+// NSAndroid is the namespace of the android: attributes.
+const NSAndroid = "http://schemas.android.com/apk/res/android"
+
+// Attr is an attribute of an element.
+type Attr struct {
+	NS    string
+	Name  string
+	ResID uint32 // 属性的资源 ID, 没有时为 0
+	Raw   string // 原始字符串值, 多数非字符串属性为空
+	Type  uint8
+	Data  uint32
+}
+
+// Value formats the attribute the way aapt2 dump does: strings as is, booleans as true/false,
+// integers in decimal and references as @0x7f...
+func (a *Attr) Value() string {
+	switch a.Type {
+	case TypeString:
+		return a.Raw
+	case TypeIntDec:
+		return fmt.Sprint(int32(a.Data))
+	case TypeIntHex:
+		return fmt.Sprintf("0x%08x", a.Data)
+	case TypeIntBool:
+		if a.Data != 0 {
+			return "true"
+		}
+		return "false"
+	case TypeReference:
+		return fmt.Sprintf("@0x%08x", a.Data)
+	case TypeAttribute:
+		return fmt.Sprintf("?0x%08x", a.Data)
+	}
+	if a.Raw != "" {
+		return a.Raw
+	}
+	return fmt.Sprintf("0x%08x", a.Data)
+}
+
+// Element is a node of the decoded document.
+type Element struct {
+	NS         string
+	Name       string
+	Attrs      []*Attr
+	Children   []*Element
+	Line       uint32
+	Namespaces []Namespace // 在该元素上声明的命名空间
+	Text       string      // 文本节点的内容, 此时 Name 为空
+}
+
+// Namespace is a namespace declaration, e.g. xmlns:android="http://schemas.android.com/apk/res/android".
+type Namespace struct {
+	Prefix string
+	URI    string
+}
+
+// Attr returns the attribute with the given namespace and name, or nil. Attributes whose name was
+// stripped by a resource obfuscator are found by their resource ID if the name is a known android:
+// attribute.
+func (e *Element) Attr(ns, name string) *Attr {
+	id := androidAttrIDs[name]
+	for _, a := range e.Attrs {
+		if a.Name == name && a.NS == ns {
+			return a
+		}
+		if ns == NSAndroid && id != 0 && a.ResID == id {
+			return a
+		}
+	}
+	return nil
+}
+
+// AttrValue returns the formatted value of an attribute, or "" if it is absent.
+func (e *Element) AttrValue(ns, name string) string {
+	if a := e.Attr(ns, name); a != nil {
+		return a.Value()
+	}
+	return ""
+}
+
+// Walk calls fn for e and all its descendants, depth first.
+func (e *Element) Walk(fn func(*Element)) {
+	fn(e)
+	for _, c := range e.Children {
+		c.Walk(fn)
+	}
+}
+
+// androidAttrIDs maps the android: attributes this package looks up by name to their fixed
+// resource IDs.
+var androidAttrIDs = map[string]uint32{
+	"name":                      0x01010003,
+	"protectionLevel":           0x01010009,
+	"permission":                0x01010006,
+	"exported":                  0x01010010,
+	"debuggable":                0x0101000f,
+	"label":                     0x01010001,
+	"icon":                      0x01010002,
+	"versionCode":               0x0101021b,
+	"versionName":               0x0101021c,
+	"minSdkVersion":             0x0101020c,
+	"targetSdkVersion":          0x01010270,
+	"maxSdkVersion":             0x01010271,
+	"required":                  0x0101028e,
+	"glEsVersion":               0x01010281,
+	"allowBackup":               0x01010280,
+	"usesCleartextTraffic":      0x010104ec,
+	"networkSecurityConfig":     0x01010527,
+	"extractNativeLibs":         0x010104ea,
+	"testOnly":                  0x01010272,
+	"sharedUserId":              0x0101000b,
+	"installLocation":           0x010102b7,
+	"compileSdkVersion":         0x01010572,
+	"compileSdkVersionCodename": 0x01010573,
+	"targetActivity":            0x01010202,
+	"backupAgent":               0x0101027f,
+}
+
+var errTruncated = stringpool.ErrTruncated
+
+// Error is a decoding error, located at the chunk it occurred in.
+type Error struct {
+	Offset int64  // 出错的 chunk 在文档中的偏移, 未知时为 -1
+	Chunk  string // chunk 类型, 如 "string pool", "start element"
+	Err    error
+}
+
+func (e *Error) Error() string {
+	if e.Offset < 0 {
+		return fmt.Sprintf("axml: %s: %v", e.Chunk, e.Err)
+	}
+	return fmt.Sprintf("axml: %s at offset %d: %v", e.Chunk, e.Offset, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ErrorLocation reports the location to signv2.ErrorContext.
+func (e *Error) ErrorLocation() (offset int64, chunk, entry string) {
+	return e.Offset, e.Chunk, ""
+}
+
+// chunkName returns the name of a chunk type for errors.
+func chunkName(typ uint16) string {
+	switch typ {
+	case chunkStringPool:
+		return "string pool"
+	case chunkXML:
+		return "document"
+	case chunkStartNS:
+		return "start namespace"
+	case chunkEndNS:
+		return "end namespace"
+	case chunkStartElement:
+		return "start element"
+	case chunkEndElement:
+		return "end element"
+	case chunkCData:
+		return "cdata"
+	case chunkResourceMap:
+		return "resource map"
+	}
+	return fmt.Sprintf("chunk %#04x", typ)
+}
+
+// Parse decodes a binary XML document and returns its root element. Errors are of type *Error. A
+// panic on malformed input is returned as an *Error wrapping a *signv2.PanicError.
+func Parse(b []byte) (_ *Element, err error) {
+	// 当前解析的 chunk, 用于定位 panic
+	at, chunk := int64(0), "document"
+	defer func() {
+		if v := recover(); v != nil {
+			err = &Error{at, chunk, &signv2.PanicError{Func: "axml.Parse", Value: v, Stack: debug.Stack()}}
+		}
+	}()
+	if len(b) < 8 || binary.LittleEndian.Uint16(b) != chunkXML {
+		return nil, &Error{0, "document", errors.New("not a binary XML document")}
+	}
+	headerSize := int(binary.LittleEndian.Uint16(b[2:]))
+	size := int(binary.LittleEndian.Uint32(b[4:]))
+	if size > len(b) || headerSize > size {
+		return nil, &Error{0, "document", errTruncated}
+	}
+
+	var strs []string
+	var resIDs []uint32
+	str := func(i uint32) string {
+		if i == 0xffffffff || int(i) >= len(strs) {
+			return ""
+		}
+		return strs[i]
+	}
+	var root *Element
+	var stack []*Element
+	var namespaces []Namespace
+
+	for off := headerSize; off < size; {
+		if size-off < 8 {
+			return nil, &Error{int64(off), "chunk header", errTruncated}
+		}
+		typ := binary.LittleEndian.Uint16(b[off:])
+		hdr := int(binary.LittleEndian.Uint16(b[off+2:]))
+		n := int(binary.LittleEndian.Uint32(b[off+4:]))
+		at, chunk = int64(off), chunkName(typ)
+		fail := func(err error) (*Element, error) {
+			return nil, &Error{int64(off), chunkName(typ), err}
+		}
+		if n < 8 || off+n > size || hdr > n {
+			return fail(errTruncated)
+		}
+		chunk := b[off : off+n]
+
+		switch typ {
+		case chunkStringPool:
+			var err error
+			if strs, err = parseStringPool(chunk); err != nil {
+				return fail(err)
+			}
+		case chunkResourceMap:
+			for i := hdr; i+4 <= n; i += 4 {
+				resIDs = append(resIDs, binary.LittleEndian.Uint32(chunk[i:]))
+			}
+		case chunkStartElement:
+			if n < hdr+20 || hdr < 16 {
+				return fail(errTruncated)
+			}
+			body := chunk[hdr:]
+			e := &Element{
+				Line: binary.LittleEndian.Uint32(chunk[8:]),
+				NS:   str(binary.LittleEndian.Uint32(body)),
+				Name: str(binary.LittleEndian.Uint32(body[4:])),
+			}
+			attrStart := int(binary.LittleEndian.Uint16(body[8:]))
+			attrSize := int(binary.LittleEndian.Uint16(body[10:]))
+			attrCount := int(binary.LittleEndian.Uint16(body[12:]))
+			if attrSize < 20 || attrStart+attrCount*attrSize > len(body) {
+				return fail(errTruncated)
+			}
+			for i := 0; i < attrCount; i++ {
+				a := body[attrStart+i*attrSize:]
+				nameIdx := binary.LittleEndian.Uint32(a[4:])
+				attr := &Attr{
+					NS:   str(binary.LittleEndian.Uint32(a)),
+					Name: str(nameIdx),
+					Raw:  str(binary.LittleEndian.Uint32(a[8:])),
+					Type: a[15],
+					Data: binary.LittleEndian.Uint32(a[16:]),
+				}
+				if int(nameIdx) < len(resIDs) {
+					attr.ResID = resIDs[nameIdx]
+				}
+				if attr.Type == TypeString && attr.Raw == "" {
+					attr.Raw = str(attr.Data)
+				}
+				e.Attrs = append(e.Attrs, attr)
+			}
+			e.Namespaces, namespaces = namespaces, nil
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Children = append(parent.Children, e)
+			} else if root == nil {
+				root = e
+			}
+			stack = append(stack, e)
+		case chunkStartNS:
+			if n < hdr+8 {
+				return fail(errTruncated)
+			}
+			body := chunk[hdr:]
+			namespaces = append(namespaces, Namespace{
+				Prefix: str(binary.LittleEndian.Uint32(body)),
+				URI:    str(binary.LittleEndian.Uint32(body[4:])),
+			})
+		case chunkCData:
+			if n < hdr+4 || hdr < 16 {
+				return fail(errTruncated)
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Children = append(parent.Children, &Element{
+					Line: binary.LittleEndian.Uint32(chunk[8:]),
+					Text: str(binary.LittleEndian.Uint32(chunk[hdr:])),
+				})
+			}
+		case chunkEndElement:
+			if len(stack) == 0 {
+				return fail(errors.New("unbalanced end element"))
+			}
+			stack = stack[:len(stack)-1]
+		}
+		off += n
+	}
+	if root == nil {
+		return nil, &Error{-1, "document", errors.New("document has no element")}
+	}
+	return root, nil
+}
+
+// parseStringPool decodes all strings of a string pool chunk.
+func parseStringPool(chunk []byte) ([]string, error) {
+	strs, _, err := stringpool.Decode(chunk)
+	return strs, err
+}
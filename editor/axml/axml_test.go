@@ -0,0 +1,203 @@
+package axml
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// readManifest returns the compiled manifest of the demo APK shipped with the repository.
+func readManifest(t *testing.T) []byte {
+	r, err := zip.OpenReader("../../release/app-release.apk")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer r.Close()
+	f, err := r.Open("AndroidManifest.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestParseManifest(t *testing.T) {
+	root, err := Parse(readManifest(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Name != "manifest" {
+		t.Fatalf("root = %q", root.Name)
+	}
+	if got := root.AttrValue("", "package"); got != "com.parap.webview" {
+		t.Errorf("package = %q", got)
+	}
+	if got := root.AttrValue(NSAndroid, "versionCode"); got != "111" {
+		t.Errorf("versionCode = %q", got)
+	}
+	var app *Element
+	root.Walk(func(e *Element) {
+		if e.Name == "application" {
+			app = e
+		}
+	})
+	if app == nil {
+		t.Fatal("no application element")
+	}
+}
+
+func TestParseRejectsGarbage(t *testing.T) {
+	for _, b := range [][]byte{nil, []byte("<manifest/>"), {3, 0, 8, 0, 0xff, 0xff, 0, 0}} {
+		if _, err := Parse(b); err == nil {
+			t.Errorf("Parse(%q) succeeded", b)
+		}
+	}
+}
+
+func TestParseErrorLocation(t *testing.T) {
+	b := readManifest(t)
+	// the string pool is the first chunk after the 8 byte document header; claim it is too long
+	binary.LittleEndian.PutUint32(b[12:], uint32(len(b)))
+	_, err := Parse(b)
+	var e *Error
+	if !errors.As(err, &e) || e.Offset != 8 || e.Chunk != "string pool" || !errors.Is(err, errTruncated) {
+		t.Errorf("got %v", err)
+	}
+}
+
+// dump renders e for comparison, with attribute values as formatted by Value and attributes in
+// sorted order, since Encode reorders them.
+func dump(e *Element) string {
+	var sb strings.Builder
+	e.Walk(func(e *Element) {
+		fmt.Fprintf(&sb, "%q %q %q %v\n", e.NS, e.Name, e.Text, e.Namespaces)
+		var attrs []string
+		for _, a := range e.Attrs {
+			attrs = append(attrs, fmt.Sprintf("  %q %q %#x %d %q\n", a.NS, a.Name, a.ResID, a.Type, a.Value()))
+		}
+		slices.Sort(attrs)
+		sb.WriteString(strings.Join(attrs, ""))
+	})
+	return sb.String()
+}
+
+func TestEncode(t *testing.T) {
+	root := &Element{
+		Name:       "manifest",
+		Namespaces: []Namespace{{"android", NSAndroid}},
+		Attrs: []*Attr{
+			{Name: "package", Raw: "com.example", Type: TypeString},
+			{NS: NSAndroid, Name: "versionName", ResID: 0x0101021c, Raw: "1.0", Type: TypeString},
+			{NS: NSAndroid, Name: "versionCode", ResID: 0x0101021b, Type: TypeIntDec, Data: 7},
+		},
+		Children: []*Element{
+			{Name: "application", Attrs: []*Attr{
+				{NS: NSAndroid, Name: "name", ResID: 0x01010003, Raw: "name", Type: TypeString},
+				{Name: "name", Raw: "plain", Type: TypeString},
+			}, Children: []*Element{{Text: "text"}}},
+		},
+	}
+	b := Encode(root)
+	got, err := Parse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dump(got) != dump(root) {
+		t.Errorf("round trip:\n%s\nwant\n%s", dump(got), dump(root))
+	}
+	if got.Attrs[0].ResID != 0x0101021b {
+		t.Errorf("attributes not sorted by resource ID: %q first", got.Attrs[0].Name)
+	}
+
+	// the demo manifest survives decoding and encoding, and encoding is stable
+	if root, err = Parse(readManifest(t)); err != nil {
+		t.Fatal(err)
+	}
+	b = Encode(root)
+	if got, err = Parse(b); err != nil {
+		t.Fatal(err)
+	}
+	if dump(got) != dump(root) {
+		t.Errorf("demo manifest changed:\n%s\nwant\n%s", dump(got), dump(root))
+	}
+	if !bytes.Equal(Encode(got), b) {
+		t.Error("encoding is not stable")
+	}
+}
+
+func TestEdit(t *testing.T) {
+	app := &Element{Name: "application", Attrs: []*Attr{
+		{NS: NSAndroid, Name: "label", ResID: 0x01010001, Type: TypeReference, Data: 0x7f010000},
+		{NS: NSAndroid, Name: "name", ResID: 0x01010003, Raw: ".App", Type: TypeString},
+	}, Children: []*Element{
+		{Name: "activity", Attrs: []*Attr{{NS: NSAndroid, Name: "name", ResID: 0x01010003, Raw: "Main", Type: TypeString}}},
+		{Name: "service", Attrs: []*Attr{{NS: NSAndroid, Name: "name", ResID: 0x01010003, Raw: "org.lib.Service", Type: TypeString}}},
+		{Name: "meta-data", Attrs: []*Attr{{NS: NSAndroid, Name: "name", ResID: 0x01010003, Raw: "key", Type: TypeString}}},
+	}}
+	root := &Element{
+		Name:       "manifest",
+		Namespaces: []Namespace{{"android", NSAndroid}},
+		Attrs: []*Attr{
+			{Name: "package", Raw: "com.example", Type: TypeString},
+			// an obfuscated name is found by its resource ID
+			{NS: NSAndroid, Name: "", ResID: 0x0101021b, Type: TypeIntDec, Data: 7},
+		},
+		Children: []*Element{app},
+	}
+
+	root.RenamePackage("org.renamed")
+	root.SetInt(NSAndroid, "versionCode", 42)
+	root.SetString(NSAndroid, "versionName", "4.2")
+	root.Child("application").SetString(NSAndroid, "label", "Renamed")
+	root.Child("application").SetBool(NSAndroid, "debuggable", true)
+	if root.Child("uses-sdk") != nil {
+		t.Error("Child found a missing element")
+	}
+	if app.Children[0].RemoveAttr(NSAndroid, "exported") {
+		t.Error("RemoveAttr removed a missing attribute")
+	}
+
+	got, err := Parse(Encode(root))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotApp := got.Child("application")
+	for _, tt := range []struct {
+		e         *Element
+		ns, name  string
+		want      string
+		wantResID uint32
+	}{
+		{got, "", "package", "org.renamed", 0},
+		{got, NSAndroid, "versionCode", "42", 0x0101021b},
+		{got, NSAndroid, "versionName", "4.2", 0x0101021c},
+		{gotApp, NSAndroid, "label", "Renamed", 0x01010001},
+		{gotApp, NSAndroid, "debuggable", "true", 0x0101000f},
+		{gotApp, NSAndroid, "name", "com.example.App", 0x01010003},
+		{gotApp.Children[0], NSAndroid, "name", "com.example.Main", 0x01010003},
+		{gotApp.Children[1], NSAndroid, "name", "org.lib.Service", 0x01010003},
+		{gotApp.Children[2], NSAndroid, "name", "key", 0x01010003},
+	} {
+		a := tt.e.Attr(tt.ns, tt.name)
+		if a == nil || a.Value() != tt.want || a.ResID != tt.wantResID {
+			t.Errorf("%s %s = %+v, want %q", tt.e.Name, tt.name, a, tt.want)
+		}
+	}
+	if n := len(got.Attrs); n != 3 {
+		t.Errorf("manifest has %d attributes, want 3", n)
+	}
+
+	if !gotApp.RemoveAttr(NSAndroid, "debuggable") || gotApp.Attr(NSAndroid, "debuggable") != nil {
+		t.Error("RemoveAttr kept debuggable")
+	}
+}
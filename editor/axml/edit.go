@@ -0,0 +1,97 @@
+package axml
+
+import "strings"
+
+// SetAttr adds a, or replaces the attribute with the same namespace and name. A known android:
+// attribute gets its resource ID if a has none, without which the platform ignores it.
+func (e *Element) SetAttr(a *Attr) {
+	if a.NS == NSAndroid && a.ResID == 0 {
+		a.ResID = androidAttrIDs[a.Name]
+	}
+	old := e.Attr(a.NS, a.Name)
+	for i, b := range e.Attrs {
+		if b == old {
+			e.Attrs[i] = a
+			return
+		}
+	}
+	e.Attrs = append(e.Attrs, a)
+}
+
+// SetString sets an attribute to a literal string, replacing a reference to a string resource.
+func (e *Element) SetString(ns, name, value string) {
+	e.SetAttr(&Attr{NS: ns, Name: name, Type: TypeString, Raw: value})
+}
+
+// SetInt sets an attribute to a decimal integer, as versionCode is.
+func (e *Element) SetInt(ns, name string, value int32) {
+	e.SetAttr(&Attr{NS: ns, Name: name, Type: TypeIntDec, Data: uint32(value)})
+}
+
+// SetBool sets an attribute to a boolean, as debuggable is.
+func (e *Element) SetBool(ns, name string, value bool) {
+	a := &Attr{NS: ns, Name: name, Type: TypeIntBool}
+	if value {
+		a.Data = 0xffffffff
+	}
+	e.SetAttr(a)
+}
+
+// RemoveAttr removes an attribute, reporting whether it was present.
+func (e *Element) RemoveAttr(ns, name string) bool {
+	a := e.Attr(ns, name)
+	if a == nil {
+		return false
+	}
+	for i, old := range e.Attrs {
+		if old == a {
+			e.Attrs = append(e.Attrs[:i], e.Attrs[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// Child returns the first child element with the given name, or nil.
+func (e *Element) Child(name string) *Element {
+	for _, c := range e.Children {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// classAttrs lists the attributes of manifest elements naming a class, which may be relative to the
+// package: ".MainActivity" or "MainActivity".
+var classAttrs = map[string][]string{
+	"application":     {"name", "backupAgent"},
+	"activity":        {"name"},
+	"activity-alias":  {"name", "targetActivity"},
+	"service":         {"name"},
+	"receiver":        {"name"},
+	"provider":        {"name"},
+	"instrumentation": {"name"},
+}
+
+// RenamePackage sets the package of a manifest, whose root is e, to pkg. Class names relative to the
+// old package are made absolute first, so that they still name the classes of the dex files.
+// Authorities, permissions and other names derived from the package are left as is.
+func (e *Element) RenamePackage(pkg string) {
+	old := e.AttrValue("", "package")
+	e.Walk(func(c *Element) {
+		for _, name := range classAttrs[c.Name] {
+			a := c.Attr(NSAndroid, name)
+			if a == nil || a.Type != TypeString || old == "" {
+				continue
+			}
+			switch {
+			case strings.HasPrefix(a.Raw, "."):
+				a.Raw = old + a.Raw
+			case !strings.Contains(a.Raw, "."):
+				a.Raw = old + "." + a.Raw
+			}
+		}
+	})
+	e.SetString("", "package", pkg)
+}
@@ -0,0 +1,157 @@
+package axml
+
+import (
+	"cmp"
+	"encoding/binary"
+	"slices"
+
+	"github.com/pzx521521/apk-editor/editor/internal/stringpool"
+)
+
+const noIndex = 0xffffffff
+
+// resIDID is the resource ID of android:id, whose index the start element chunk records.
+const resIDID = 0x010100d0
+
+// Encode encodes root as a binary XML document, the inverse of Parse, laid out as aapt2 does:
+// strings are stored as UTF-16, the names of attributes with a resource ID come first in the string
+// pool in the order of the resource map, and the attributes of each element are sorted by resource
+// ID. The Data of string attributes is set to the index of Raw; other attributes keep Raw only if it
+// is set.
+func Encode(root *Element) []byte {
+	enc := &encoder{names: map[attrName]uint32{}}
+	var ids []attrName
+	root.Walk(func(e *Element) {
+		for _, a := range e.Attrs {
+			if k := (attrName{a.Name, a.ResID}); a.ResID != 0 && !slices.Contains(ids, k) {
+				ids = append(ids, k)
+			}
+		}
+	})
+	slices.SortFunc(ids, func(a, b attrName) int { return cmp.Compare(a.id, b.id) })
+	for _, k := range ids {
+		enc.names[k] = enc.pool.Append(k.name)
+	}
+	enc.element(root)
+
+	pool := enc.pool.Encode(false)
+	size := 8 + len(pool) + len(enc.body)
+	if len(ids) > 0 {
+		size += 8 + 4*len(ids)
+	}
+	b := make([]byte, 0, size)
+	b = appendHeader(b, chunkXML, 8, size)
+	b = append(b, pool...)
+	if len(ids) > 0 {
+		b = appendHeader(b, chunkResourceMap, 8, 8+4*len(ids))
+		for _, k := range ids {
+			b = binary.LittleEndian.AppendUint32(b, k.id)
+		}
+	}
+	return append(b, enc.body...)
+}
+
+// attrName is an attribute name matched to a resource ID.
+type attrName struct {
+	name string
+	id   uint32
+}
+
+type encoder struct {
+	pool  stringpool.Pool
+	names map[attrName]uint32
+	body  []byte
+}
+
+// ref returns the string pool index of s, or noIndex for "".
+func (enc *encoder) ref(s string) uint32 {
+	if s == "" {
+		return noIndex
+	}
+	return enc.pool.Add(s)
+}
+
+// node appends the header of a node chunk with its line number and no comment.
+func (enc *encoder) node(typ uint16, size int, line uint32) {
+	enc.body = appendHeader(enc.body, typ, 16, size)
+	enc.body = binary.LittleEndian.AppendUint32(enc.body, line)
+	enc.body = binary.LittleEndian.AppendUint32(enc.body, noIndex)
+}
+
+func (enc *encoder) u32(v ...uint32) {
+	for _, x := range v {
+		enc.body = binary.LittleEndian.AppendUint32(enc.body, x)
+	}
+}
+
+func (enc *encoder) element(e *Element) {
+	if e.Name == "" {
+		enc.node(chunkCData, 28, e.Line)
+		enc.u32(enc.ref(e.Text), 8, TypeNull)
+		return
+	}
+	for _, ns := range e.Namespaces {
+		enc.node(chunkStartNS, 24, e.Line)
+		enc.u32(enc.ref(ns.Prefix), enc.ref(ns.URI))
+	}
+
+	attrs := slices.Clone(e.Attrs)
+	slices.SortStableFunc(attrs, func(a, b *Attr) int {
+		if (a.ResID == 0) != (b.ResID == 0) {
+			// 没有资源 ID 的属性排在后面, 保持原顺序
+			if a.ResID == 0 {
+				return 1
+			}
+			return -1
+		}
+		return cmp.Compare(a.ResID, b.ResID)
+	})
+	var id, class, style uint16
+	for i, a := range attrs {
+		switch {
+		case a.ResID == resIDID || a.NS == NSAndroid && a.Name == "id":
+			id = uint16(i + 1)
+		case a.NS == "" && a.Name == "class":
+			class = uint16(i + 1)
+		case a.NS == "" && a.Name == "style":
+			style = uint16(i + 1)
+		}
+	}
+	enc.node(chunkStartElement, 36+20*len(attrs), e.Line)
+	enc.u32(enc.ref(e.NS), enc.ref(e.Name))
+	enc.body = binary.LittleEndian.AppendUint16(enc.body, 20)
+	enc.body = binary.LittleEndian.AppendUint16(enc.body, 20)
+	enc.body = binary.LittleEndian.AppendUint16(enc.body, uint16(len(attrs)))
+	enc.body = binary.LittleEndian.AppendUint16(enc.body, id)
+	enc.body = binary.LittleEndian.AppendUint16(enc.body, class)
+	enc.body = binary.LittleEndian.AppendUint16(enc.body, style)
+	for _, a := range attrs {
+		name, ok := enc.names[attrName{a.Name, a.ResID}]
+		if !ok {
+			name = enc.pool.Add(a.Name)
+		}
+		raw, data := enc.ref(a.Raw), a.Data
+		if a.Type == TypeString {
+			raw = enc.pool.Add(a.Raw)
+			data = raw
+		}
+		enc.u32(enc.ref(a.NS), name, raw, 8|uint32(a.Type)<<24, data)
+	}
+
+	for _, c := range e.Children {
+		enc.element(c)
+	}
+
+	enc.node(chunkEndElement, 24, e.Line)
+	enc.u32(enc.ref(e.NS), enc.ref(e.Name))
+	for i := len(e.Namespaces) - 1; i >= 0; i-- {
+		enc.node(chunkEndNS, 24, e.Line)
+		enc.u32(enc.ref(e.Namespaces[i].Prefix), enc.ref(e.Namespaces[i].URI))
+	}
+}
+
+func appendHeader(b []byte, typ uint16, headerSize, size int) []byte {
+	b = binary.LittleEndian.AppendUint16(b, typ)
+	b = binary.LittleEndian.AppendUint16(b, uint16(headerSize))
+	return binary.LittleEndian.AppendUint32(b, uint32(size))
+}
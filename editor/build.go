@@ -0,0 +1,110 @@
+package editor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/pzx521521/apk-editor/editor/axml"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+// noCompressExts 本身已压缩的文件, aapt2 默认不再压缩, 存储方式为 Store
+var noCompressExts = map[string]bool{}
+
+func init() {
+	for _, ext := range strings.Fields(`.jpg .jpeg .png .gif .webp .wav .mp2 .mp3 .ogg .aac .mpg .mpeg
+		.mid .midi .smf .jet .rtttl .imy .xmf .mp4 .m4a .m4v .3gp .3gpp .3g2 .3gpp2 .amr .awb .wma .wmv
+		.webm .mkv .arsc`) {
+		noCompressExts[ext] = true
+	}
+}
+
+// BuildAPK 用已编译好的各部分组装未签名的 apk, 条目顺序与存储方式同 aapt2 + zipalign:
+//   - resources.arsc 在最前, 不压缩, 4 字节对齐
+//   - AndroidManifest.xml 与 dex 压缩, dex 依次命名为 classes.dex, classes2.dex ...
+//   - res/ 与 assets/ 中已压缩格式 (png, jpg, ogg 等) 不压缩并 4 字节对齐, 其余压缩
+//   - lib/ 中的 .so 在 manifest 声明 android:extractNativeLibs="false" 时不压缩并按 16KB 对齐, 否则压缩
+//
+// resFiles, assets, libs 的键为相对 res/, assets/, lib/ 的路径, 如 "layout/main.xml",
+// "arm64-v8a/libfoo.so"; 各目录内按路径排序写入. arsc 为空时不写 resources.arsc.
+func BuildAPK(manifest, arsc []byte, dexFiles [][]byte, resFiles, assets, libs map[string][]byte) (_ []byte, err error) {
+	defer catch(&err, "BuildAPK", "")
+	root, err := parseManifest(manifest)
+	if err != nil {
+		return nil, err
+	}
+	storeLibs := false
+	for _, app := range root.Children {
+		if app.Name == "application" {
+			if a := app.Attr(axml.NSAndroid, "extractNativeLibs"); a != nil && a.Type == axml.TypeIntBool {
+				storeLibs = a.Data == 0
+			}
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	add := func(name string, method uint16, align int, data []byte) error {
+		fh := &zip.FileHeader{Name: name, Method: method}
+		var fw io.Writer
+		var err error
+		if method == zip.Store {
+			fw, err = w.CreateAlignedHeader(fh, align)
+		} else {
+			fw, err = w.CreateHeader(fh)
+		}
+		if err == nil {
+			_, err = fw.Write(data)
+		}
+		return err
+	}
+	if len(arsc) > 0 {
+		if err = add("resources.arsc", zip.Store, 4, arsc); err != nil {
+			return nil, err
+		}
+	}
+	if err = add(zip.ANDROIDMANIFEST, zip.Deflate, 0, manifest); err != nil {
+		return nil, err
+	}
+	for i, dex := range dexFiles {
+		if err = add(dexName(i), zip.Deflate, 0, dex); err != nil {
+			return nil, err
+		}
+	}
+	for _, dir := range []struct {
+		prefix string
+		files  map[string][]byte
+	}{{"res/", resFiles}, {ASSETS_DIR, assets}, {"lib/", libs}} {
+		names := make([]string, 0, len(dir.files))
+		for name := range dir.files {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			full := dir.prefix + strings.TrimPrefix(name, "/")
+			if clean := path.Clean(full); clean != full || !strings.HasPrefix(clean, dir.prefix) {
+				return nil, fmt.Errorf("invalid path %q in %s", name, dir.prefix)
+			}
+			method, align := zip.Deflate, 0
+			switch {
+			case dir.prefix == "lib/":
+				if storeLibs && strings.HasSuffix(name, ".so") {
+					method, align = zip.Store, PageSize16K
+				}
+			case noCompressExts[strings.ToLower(path.Ext(name))]:
+				method, align = zip.Store, 4
+			}
+			if err = add(full, method, align, dir.files[name]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
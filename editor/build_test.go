@@ -0,0 +1,71 @@
+package editor
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pzx521521/apk-editor/editor/apktest"
+	"github.com/pzx521521/apk-editor/editor/axml"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+func TestBuildAPK(t *testing.T) {
+	manifest := &axml.Element{
+		Name:       "manifest",
+		Namespaces: []axml.Namespace{{Prefix: "android", URI: axml.NSAndroid}},
+		Attrs:      []*axml.Attr{{Name: "package", Raw: "com.example", Type: axml.TypeString}},
+		Children: []*axml.Element{{
+			Name: "application",
+			Attrs: []*axml.Attr{{NS: axml.NSAndroid, Name: "extractNativeLibs", ResID: 0x010104ea,
+				Type: axml.TypeIntBool}},
+		}},
+	}
+	apk, err := BuildAPK(axml.Encode(manifest), apktest.ResourceTable("com.example", "Example"),
+		[][]byte{apktest.Dex(), apktest.Dex()},
+		map[string][]byte{"layout/main.xml": []byte("xml"), "drawable/icon.png": []byte("png")},
+		map[string][]byte{"index.html": []byte("<html></html>")},
+		map[string][]byte{"arm64-v8a/libfoo.so": testELF(PageSize16K)},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := zip.NewReader(bytes.NewReader(apk), int64(len(apk)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		name   string
+		method uint16
+	}{
+		{"resources.arsc", zip.Store},
+		{zip.ANDROIDMANIFEST, zip.Deflate},
+		{"classes.dex", zip.Deflate},
+		{"classes2.dex", zip.Deflate},
+		{"res/drawable/icon.png", zip.Store},
+		{"res/layout/main.xml", zip.Deflate},
+		{"assets/index.html", zip.Deflate},
+		{"lib/arm64-v8a/libfoo.so", zip.Store},
+	}
+	if len(r.File) != len(want) {
+		t.Fatalf("%d entries, want %d", len(r.File), len(want))
+	}
+	for i, f := range r.File {
+		if f.Name != want[i].name || f.Method != want[i].method {
+			t.Errorf("entry %d = %s (method %d), want %s (method %d)", i, f.Name, f.Method, want[i].name, want[i].method)
+		}
+		if off, _ := f.DataOffset(); f.Method == zip.Store && off%4 != 0 {
+			t.Errorf("%s: data at %d is not aligned", f.Name, off)
+		}
+	}
+	libs, err := AuditNativeLibs(apk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(libs) != 1 || !libs[0].Ready16K {
+		t.Errorf("native libs %+v", libs)
+	}
+
+	if _, err = BuildAPK(axml.Encode(manifest), nil, nil, nil, map[string][]byte{"../x": nil}, nil); err == nil {
+		t.Error("BuildAPK accepted a path outside assets/")
+	}
+}
@@ -0,0 +1,249 @@
+package bundle
+
+import (
+	"cmp"
+	"encoding/binary"
+	"slices"
+	"unicode/utf16"
+
+	"github.com/pzx521521/apk-editor/editor/internal/stringpool"
+)
+
+// Chunk types of resources.arsc.
+const (
+	chunkTable    = 0x0002
+	chunkPackage  = 0x0200
+	chunkType     = 0x0201
+	chunkTypeSpec = 0x0202
+)
+
+const (
+	packageHeaderSize = 288
+	typeHeaderSize    = 20 + configSize
+	noEntry           = 0xffffffff
+
+	entryComplex = 0x0001
+	entryPublic  = 0x0002
+	entryWeak    = 0x0004
+	specPublic   = 0x40000000
+)
+
+// mergeTables merges the resource tables of several modules: packages, types and entries with the
+// same IDs are merged, and the values of an entry from later tables are added to those from
+// earlier ones.
+func mergeTables(tables []*table) *table {
+	merged := &table{}
+	pkgs := map[uint32]*resPackage{}
+	types := map[[2]uint32]*resType{}
+	entries := map[[3]uint32]*resEntry{}
+	for _, t := range tables {
+		for _, p := range t.packages {
+			mp := pkgs[p.id]
+			if mp == nil {
+				mp = &resPackage{id: p.id, name: p.name}
+				pkgs[p.id] = mp
+				merged.packages = append(merged.packages, mp)
+			}
+			for _, rt := range p.types {
+				mt := types[[2]uint32{p.id, rt.id}]
+				if mt == nil {
+					mt = &resType{id: rt.id, name: rt.name}
+					types[[2]uint32{p.id, rt.id}] = mt
+					mp.types = append(mp.types, mt)
+				}
+				for _, e := range rt.entries {
+					me := entries[[3]uint32{p.id, rt.id, e.id}]
+					if me == nil {
+						me = &resEntry{id: e.id, name: e.name}
+						entries[[3]uint32{p.id, rt.id, e.id}] = me
+						mt.entries = append(mt.entries, me)
+					}
+					me.public = me.public || e.public
+					me.values = append(me.values, e.values...)
+				}
+			}
+		}
+	}
+	return merged
+}
+
+// items calls fn for every item of t, in a fixed order.
+func (t *table) items(fn func(*item)) {
+	for _, p := range t.packages {
+		for _, rt := range p.types {
+			for _, e := range rt.entries {
+				for _, cv := range e.values {
+					if !cv.value.complex {
+						fn(&cv.value.item)
+					}
+					for i := range cv.value.entries {
+						fn(&cv.value.entries[i].item)
+					}
+				}
+			}
+		}
+	}
+}
+
+// encodeTable returns t as resources.arsc.
+func encodeTable(t *table) []byte {
+	var pool stringpool.Pool
+	t.items(func(it *item) {
+		if it.typ == typeString && len(it.spans) > 0 {
+			it.data = pool.AddStyled(it.str, it.spans)
+		}
+	})
+	t.items(func(it *item) {
+		if it.typ == typeString && len(it.spans) == 0 {
+			it.data = pool.Add(it.str)
+		}
+	})
+
+	pkgs := slices.Clone(t.packages)
+	slices.SortFunc(pkgs, func(a, b *resPackage) int { return cmp.Compare(a.id, b.id) })
+	body := pool.Encode(true)
+	for _, p := range pkgs {
+		body = append(body, encodePackage(p)...)
+	}
+	b := appendHeader(nil, chunkTable, 12, 12+len(body))
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(pkgs)))
+	return append(b, body...)
+}
+
+func encodePackage(p *resPackage) []byte {
+	var typePool, keyPool stringpool.Pool
+	var body []byte
+	types := slices.Clone(p.types)
+	slices.SortFunc(types, func(a, b *resType) int { return cmp.Compare(a.id, b.id) })
+	for _, rt := range types {
+		// the type strings are indexed by type ID - 1
+		for uint32(typePool.Len()) < rt.id-1 {
+			typePool.Append("")
+		}
+		typePool.Append(rt.name)
+		body = append(body, encodeType(rt, &keyPool)...)
+	}
+
+	typeStrings := typePool.Encode(true)
+	keyStrings := keyPool.Encode(true)
+	size := packageHeaderSize + len(typeStrings) + len(keyStrings) + len(body)
+	b := make([]byte, 0, size)
+	b = appendHeader(b, chunkPackage, packageHeaderSize, size)
+	b = binary.LittleEndian.AppendUint32(b, p.id)
+	name := utf16.Encode([]rune(p.name))
+	for i := range 128 {
+		var c uint16
+		if i < len(name) && i < 127 {
+			c = name[i]
+		}
+		b = binary.LittleEndian.AppendUint16(b, c)
+	}
+	b = binary.LittleEndian.AppendUint32(b, packageHeaderSize)
+	b = binary.LittleEndian.AppendUint32(b, uint32(typePool.Len()))
+	b = binary.LittleEndian.AppendUint32(b, uint32(packageHeaderSize+len(typeStrings)))
+	b = binary.LittleEndian.AppendUint32(b, uint32(keyPool.Len()))
+	b = binary.LittleEndian.AppendUint32(b, 0)
+	b = append(b, typeStrings...)
+	b = append(b, keyStrings...)
+	return append(b, body...)
+}
+
+// encodeType returns the type spec chunk of rt followed by a type chunk per configuration, in the
+// order the configurations first appear.
+func encodeType(rt *resType, keyPool *stringpool.Pool) []byte {
+	var count uint32
+	for _, e := range rt.entries {
+		count = max(count, e.id+1)
+	}
+	type valueOf struct {
+		entry *resEntry
+		value *value
+	}
+	var configs []string
+	byConfig := map[string][]valueOf{}
+	flags := make([]uint32, count)
+	keys := make([]uint32, count)
+	for _, e := range rt.entries {
+		keys[e.id] = keyPool.Add(e.name)
+		if e.public {
+			flags[e.id] |= specPublic
+		}
+		for _, cv := range e.values {
+			flags[e.id] |= specFlags(cv.config)
+			c := string(cv.config)
+			if _, ok := byConfig[c]; !ok {
+				configs = append(configs, c)
+			}
+			byConfig[c] = append(byConfig[c], valueOf{e, cv.value})
+		}
+	}
+
+	b := appendHeader(nil, chunkTypeSpec, 16, 16+4*int(count))
+	b = append(b, byte(rt.id), 0, 0, 0)
+	b = binary.LittleEndian.AppendUint32(b, count)
+	for _, f := range flags {
+		b = binary.LittleEndian.AppendUint32(b, f)
+	}
+
+	for _, c := range configs {
+		offsets := make([]uint32, count)
+		for i := range offsets {
+			offsets[i] = noEntry
+		}
+		var entries []byte
+		for _, v := range byConfig[c] {
+			offsets[v.entry.id] = uint32(len(entries))
+			entries = appendEntry(entries, keys[v.entry.id], v.entry.public, v.value)
+		}
+		start := typeHeaderSize + 4*int(count)
+		b = appendHeader(b, chunkType, typeHeaderSize, start+len(entries))
+		b = append(b, byte(rt.id), 0, 0, 0)
+		b = binary.LittleEndian.AppendUint32(b, count)
+		b = binary.LittleEndian.AppendUint32(b, uint32(start))
+		b = append(b, c...)
+		for _, o := range offsets {
+			b = binary.LittleEndian.AppendUint32(b, o)
+		}
+		b = append(b, entries...)
+	}
+	return b
+}
+
+// appendEntry appends a ResTable_entry and its value, or a ResTable_map_entry and its map.
+func appendEntry(b []byte, key uint32, public bool, v *value) []byte {
+	var flags uint16
+	if public {
+		flags |= entryPublic
+	}
+	if v.weak {
+		flags |= entryWeak
+	}
+	if !v.complex {
+		b = binary.LittleEndian.AppendUint16(b, 8)
+		b = binary.LittleEndian.AppendUint16(b, flags)
+		b = binary.LittleEndian.AppendUint32(b, key)
+		return appendValue(b, v.item)
+	}
+	b = binary.LittleEndian.AppendUint16(b, 16)
+	b = binary.LittleEndian.AppendUint16(b, flags|entryComplex)
+	b = binary.LittleEndian.AppendUint32(b, key)
+	b = binary.LittleEndian.AppendUint32(b, v.parent)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(v.entries)))
+	for _, e := range v.entries {
+		b = binary.LittleEndian.AppendUint32(b, e.name)
+		b = appendValue(b, e.item)
+	}
+	return b
+}
+
+// appendValue appends a Res_value.
+func appendValue(b []byte, it item) []byte {
+	b = append(b, 8, 0, 0, it.typ)
+	return binary.LittleEndian.AppendUint32(b, it.data)
+}
+
+func appendHeader(b []byte, typ uint16, headerSize, size int) []byte {
+	b = binary.LittleEndian.AppendUint16(b, typ)
+	b = binary.LittleEndian.AppendUint16(b, uint16(headerSize))
+	return binary.LittleEndian.AppendUint32(b, uint32(size))
+}
@@ -0,0 +1,354 @@
+// Package bundle reads Android App Bundles (.aab) and builds universal APKs from them, a pure Go
+// alternative to `bundletool build-apks --mode=universal`. Resources and XML files, stored as
+// protobuf in a bundle, are compiled to resources.arsc and binary XML. The APK is not signed; sign
+// it with package signv2 like any other.
+package bundle
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pzx521521/apk-editor/editor/axml"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+// NSDist is the namespace of the dist: elements that configure module delivery.
+const NSDist = "http://schemas.android.com/apk/distribution"
+
+// BaseModule is the name of the module every bundle has.
+const BaseModule = "base"
+
+// Alignment of uncompressed entries in the APK, as zipalign -p applies it.
+const (
+	alignDefault = 4
+	alignLibs    = 16 << 10
+)
+
+// ErrNoBase is returned by Open for a zip without a base module.
+var ErrNoBase = errors.New("bundle has no base module")
+
+// Bundle is an opened .aab.
+type Bundle struct {
+	// Modules lists the modules of the bundle, base first and the others by name.
+	Modules []*Module
+}
+
+// Module is one module of a bundle: the base, a feature or an asset pack.
+type Module struct {
+	Name string
+	// Manifest is the decoded manifest of the module.
+	Manifest *axml.Element
+	// Fused is false for a module that declares <dist:fusing dist:include="false"/> and is thus
+	// left out of universal APKs.
+	Fused bool
+	files []*zip.File
+}
+
+// file returns the file at path within the module, or nil.
+func (m *Module) file(path string) *zip.File {
+	for _, f := range m.files {
+		if f.Name == m.Name+"/"+path {
+			return f
+		}
+	}
+	return nil
+}
+
+// Open parses the modules of a bundle. Errors from decoding a module locate the entry, see
+// signv2.ErrorContext.
+func Open(aab []byte) (_ *Bundle, err error) {
+	defer catch(&err, "Open")
+	r, err := zip.NewReader(bytes.NewReader(aab), int64(len(aab)))
+	if err != nil {
+		return nil, err
+	}
+	modules := map[string]*Module{}
+	for _, f := range r.File {
+		name, path, ok := strings.Cut(f.Name, "/")
+		if !ok || path == "" || strings.HasSuffix(path, "/") {
+			continue
+		}
+		m := modules[name]
+		if m == nil {
+			m = &Module{Name: name, Fused: true}
+			modules[name] = m
+		}
+		m.files = append(m.files, f)
+	}
+
+	b := &Bundle{}
+	for _, m := range modules {
+		f := m.file("manifest/AndroidManifest.xml")
+		if f == nil {
+			// BUNDLE-METADATA, META-INF and the like
+			continue
+		}
+		data, err := readFile(f)
+		if err == nil {
+			m.Manifest, err = parseXMLNode(data)
+		}
+		if err != nil {
+			return nil, entryError(f.Name, err)
+		}
+		if m.Name != BaseModule {
+			m.Fused = fused(m.Manifest)
+		}
+		b.Modules = append(b.Modules, m)
+	}
+	slices.SortFunc(b.Modules, func(x, y *Module) int {
+		if (x.Name == BaseModule) != (y.Name == BaseModule) {
+			if x.Name == BaseModule {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(x.Name, y.Name)
+	})
+	if len(b.Modules) == 0 || b.Modules[0].Name != BaseModule {
+		return nil, ErrNoBase
+	}
+	return b, nil
+}
+
+// fused reports whether a feature module is merged into universal APKs.
+func fused(manifest *axml.Element) bool {
+	include := true
+	manifest.Walk(func(e *axml.Element) {
+		if e.NS == NSDist && e.Name == "fusing" {
+			include = e.AttrValue(NSDist, "include") != "false"
+		}
+	})
+	return include
+}
+
+// UniversalAPK builds an unsigned APK holding every fused module: the resource tables of the
+// modules are merged into one resources.arsc, their dex files are numbered after those of the base,
+// and their res, assets, lib and root files are copied. The manifest is that of the base module,
+// into which the build tools have already merged the components of fused modules; it is modified
+// as bundletool does to not require splits.
+//
+// resources.arsc is stored uncompressed, as are native libraries if the manifest disables their
+// extraction, aligned on 16KB; other entries keep the compression they have in the bundle.
+func (b *Bundle) UniversalAPK() (_ []byte, err error) {
+	defer catch(&err, "UniversalAPK")
+	var modules []*Module
+	var tables []*table
+	for _, m := range b.Modules {
+		if !m.Fused {
+			continue
+		}
+		modules = append(modules, m)
+		f := m.file("resources.pb")
+		if f == nil {
+			continue
+		}
+		data, err := readFile(f)
+		if err != nil {
+			return nil, err
+		}
+		t, err := parseTable(data)
+		if err != nil {
+			return nil, entryError(f.Name, err)
+		}
+		tables = append(tables, t)
+	}
+	merged := mergeTables(tables)
+	xmlFiles := map[string]bool{}
+	merged.items(func(it *item) {
+		if it.xml {
+			xmlFiles[it.str] = true
+		}
+	})
+
+	manifest := UniversalManifest(b.Modules[0].Manifest)
+	storeLibs := false
+	manifest.Walk(func(e *axml.Element) {
+		if e.Name == "application" {
+			storeLibs = e.AttrValue(axml.NSAndroid, "extractNativeLibs") == "false"
+		}
+	})
+
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	write := func(name string, method uint16, data []byte) error {
+		fh := &zip.FileHeader{Name: name, Method: method}
+		var fw io.Writer
+		var err error
+		if method == zip.Store {
+			align := alignDefault
+			if strings.HasPrefix(name, "lib/") && strings.HasSuffix(name, ".so") {
+				align = alignLibs
+			}
+			fw, err = w.CreateAlignedHeader(fh, align)
+		} else {
+			fw, err = w.CreateHeader(fh)
+		}
+		if err == nil {
+			_, err = fw.Write(data)
+		}
+		return err
+	}
+
+	// resources.arsc comes first, where aligning it needs no padding
+	if len(tables) > 0 {
+		if err = write("resources.arsc", zip.Store, encodeTable(merged)); err != nil {
+			return nil, err
+		}
+	}
+	if err = write(zip.ANDROIDMANIFEST, zip.Deflate, axml.Encode(manifest)); err != nil {
+		return nil, err
+	}
+	dex := 0
+	for _, m := range modules {
+		for _, f := range dexFiles(m) {
+			data, err := readFile(f)
+			if err != nil {
+				return nil, err
+			}
+			if dex++; dex == 1 {
+				err = write("classes.dex", f.Method, data)
+			} else {
+				err = write("classes"+strconv.Itoa(dex)+".dex", f.Method, data)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	seen := map[string]string{}
+	for _, m := range modules {
+		for _, f := range m.files {
+			name := apkPath(strings.TrimPrefix(f.Name, m.Name+"/"))
+			if name == "" {
+				continue
+			}
+			if other, ok := seen[name]; ok {
+				return nil, fmt.Errorf("%s: present in modules %s and %s", name, other, m.Name)
+			}
+			seen[name] = m.Name
+			data, err := readFile(f)
+			if err != nil {
+				return nil, err
+			}
+			method := f.Method
+			switch {
+			case xmlFiles[name]:
+				if data, err = compileXML(data); err != nil {
+					return nil, entryError(f.Name, err)
+				}
+				method = zip.Deflate
+			case strings.HasPrefix(name, "lib/") && storeLibs:
+				method = zip.Store
+			}
+			if err = write(name, method, data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// apkPath returns the path in the APK of a module file, or "" if it is not copied as is.
+func apkPath(path string) string {
+	dir, rest, _ := strings.Cut(path, "/")
+	switch dir {
+	case "res", "assets", "lib":
+		return path
+	case "root":
+		return rest
+	}
+	return ""
+}
+
+// dexFiles returns the dex files of a module in the order of their numbers.
+func dexFiles(m *Module) []*zip.File {
+	var files []*zip.File
+	for _, f := range m.files {
+		if dexNumber(strings.TrimPrefix(f.Name, m.Name+"/dex/")) > 0 {
+			files = append(files, f)
+		}
+	}
+	slices.SortFunc(files, func(a, b *zip.File) int {
+		return dexNumber(a.Name[strings.LastIndex(a.Name, "/")+1:]) - dexNumber(b.Name[strings.LastIndex(b.Name, "/")+1:])
+	})
+	return files
+}
+
+// dexNumber returns 1 for classes.dex, n for classesN.dex and 0 for other names.
+func dexNumber(name string) int {
+	n, ok := strings.CutPrefix(name, "classes")
+	if n, ok = strings.CutSuffix(n, ".dex"); !ok {
+		return 0
+	}
+	if n == "" {
+		return 1
+	}
+	i, err := strconv.Atoi(n)
+	if err != nil || i < 2 {
+		return 0
+	}
+	return i
+}
+
+// splitAttrs are the manifest attributes that make the platform require split APKs.
+var splitAttrs = []string{"isSplitRequired", "requiredSplitTypes", "splitTypes"}
+
+// splitMetaData are the meta-data through which Play enforces the installation of splits.
+var splitMetaData = []string{"com.android.vending.splits.required", "com.android.vending.splits"}
+
+// UniversalManifest returns a copy of a base manifest that does not require splits, as bundletool
+// writes it into universal and standalone APKs.
+func UniversalManifest(base *axml.Element) *axml.Element {
+	var clone func(e *axml.Element) *axml.Element
+	clone = func(e *axml.Element) *axml.Element {
+		c := *e
+		c.Attrs = slices.DeleteFunc(slices.Clone(e.Attrs), func(a *axml.Attr) bool {
+			return a.NS == axml.NSAndroid && slices.Contains(splitAttrs, a.Name)
+		})
+		c.Children = nil
+		for _, child := range e.Children {
+			if child.Name == "meta-data" && slices.Contains(splitMetaData, child.AttrValue(axml.NSAndroid, "name")) {
+				continue
+			}
+			c.Children = append(c.Children, clone(child))
+		}
+		return &c
+	}
+	return clone(base)
+}
+
+func readFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// entryError locates err in the bundle entry name, see signv2.ErrorContext.
+func entryError(name string, err error) error {
+	return &signv2.ParseError{Location: signv2.Location{Offset: -1, Entry: name}, Err: err}
+}
+
+// catch is deferred by the exported functions: it turns a panic on a malformed bundle into a
+// *signv2.PanicError.
+func catch(err *error, fn string) {
+	if v := recover(); v != nil {
+		*err = &signv2.ParseError{
+			Location: signv2.Location{Offset: -1, Chunk: "bundle"},
+			Err:      &signv2.PanicError{Func: "bundle." + fn, Value: v, Stack: debug.Stack()},
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package bundle
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/pzx521521/apk-editor/editor/axml"
+	pw "github.com/pzx521521/apk-editor/editor/internal/protowire"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+// xmlAttr returns an XmlAttribute message.
+func xmlAttr(ns, name, value string, resID uint32) []byte {
+	b := pw.AppendString(nil, 1, ns)
+	b = pw.AppendString(b, 2, name)
+	b = pw.AppendString(b, 3, value)
+	if resID != 0 {
+		b = pw.AppendUint(b, 5, uint64(resID))
+	}
+	return b
+}
+
+// xmlElement returns an XmlNode message holding an element.
+func xmlElement(ns, name string, attrs [][]byte, children ...[]byte) []byte {
+	var e []byte
+	if name == "manifest" {
+		decl := pw.AppendString(nil, 1, "android")
+		decl = pw.AppendString(decl, 2, axml.NSAndroid)
+		e = pw.AppendBytes(e, 1, decl)
+	}
+	e = pw.AppendString(e, 2, ns)
+	e = pw.AppendString(e, 3, name)
+	for _, a := range attrs {
+		e = pw.AppendBytes(e, 4, a)
+	}
+	for _, c := range children {
+		e = pw.AppendBytes(e, 5, c)
+	}
+	return pw.AppendBytes(nil, 1, e)
+}
+
+// testTable returns a resources.pb with a string and a layout.
+func testTable() []byte {
+	id := func(id uint64) []byte { return pw.AppendUint(nil, 1, id) }
+	entry := func(eid uint64, name string, item []byte) []byte {
+		v := pw.AppendBytes(nil, 4, item)
+		cv := pw.AppendBytes(nil, 2, v)
+		e := pw.AppendBytes(nil, 1, id(eid))
+		e = pw.AppendString(e, 2, name)
+		return pw.AppendBytes(e, 6, cv)
+	}
+	str := pw.AppendBytes(nil, 2, pw.AppendString(nil, 1, "Hello"))
+	file := pw.AppendString(nil, 1, "res/layout/main.xml")
+	file = pw.AppendUint(file, 2, fileTypeProtoXML)
+
+	strings := pw.AppendBytes(nil, 1, id(1))
+	strings = pw.AppendString(strings, 2, "string")
+	strings = pw.AppendBytes(strings, 3, entry(0, "app_name", str))
+	layouts := pw.AppendBytes(nil, 1, id(2))
+	layouts = pw.AppendString(layouts, 2, "layout")
+	layouts = pw.AppendBytes(layouts, 3, entry(0, "main", pw.AppendBytes(nil, 5, file)))
+
+	p := pw.AppendBytes(nil, 1, id(0x7f))
+	p = pw.AppendString(p, 2, "com.example")
+	p = pw.AppendBytes(p, 3, strings)
+	p = pw.AppendBytes(p, 3, layouts)
+	return pw.AppendBytes(nil, 2, p)
+}
+
+func featureManifest(include string) []byte {
+	fusing := xmlElement(NSDist, "fusing", [][]byte{xmlAttr(NSDist, "include", include, 0)})
+	module := xmlElement(NSDist, "module", nil, fusing)
+	return xmlElement("", "manifest", [][]byte{xmlAttr("", "package", "com.example", 0)}, module)
+}
+
+func testBundle(t *testing.T) []byte {
+	meta := xmlElement("", "meta-data", [][]byte{
+		xmlAttr(axml.NSAndroid, "name", "com.android.vending.splits.required", 0x01010003),
+		xmlAttr(axml.NSAndroid, "value", "true", 0x01010024),
+	})
+	app := xmlElement("", "application", [][]byte{
+		xmlAttr(axml.NSAndroid, "label", "@string/app_name", 0x01010001),
+		xmlAttr(axml.NSAndroid, "extractNativeLibs", "false", 0x010104ea),
+	}, meta)
+	manifest := xmlElement("", "manifest", [][]byte{
+		xmlAttr("", "package", "com.example", 0),
+		xmlAttr(axml.NSAndroid, "isSplitRequired", "true", 0x01010591),
+	}, app)
+
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{
+		{"BundleConfig.pb", nil},
+		{"base/manifest/AndroidManifest.xml", manifest},
+		{"base/resources.pb", testTable()},
+		{"base/res/layout/main.xml", xmlElement("", "LinearLayout", nil)},
+		{"base/dex/classes.dex", []byte("base dex")},
+		{"base/lib/arm64-v8a/libfoo.so", []byte("elf")},
+		{"base/root/kotlin/kotlin.kotlin_builtins", []byte("builtins")},
+		{"feature/manifest/AndroidManifest.xml", featureManifest("true")},
+		{"feature/dex/classes.dex", []byte("feature dex")},
+		{"feature/assets/data.txt", []byte("data")},
+		{"ondemand/manifest/AndroidManifest.xml", featureManifest("false")},
+		{"ondemand/dex/classes.dex", []byte("on demand dex")},
+	} {
+		fw, err := w.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(f.data)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testKeys(t *testing.T) []*signv2.SigningCert {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []*signv2.SigningCert{{
+		SigningKey: signv2.SigningKey{
+			KeyBytes: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+			Type:     signv2.RSA,
+			Hash:     signv2.SHA256,
+		},
+		CertBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}}
+}
+
+func TestUniversalAPK(t *testing.T) {
+	b, err := Open(testBundle(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b.Modules) != 3 || b.Modules[0].Name != BaseModule || !b.Modules[1].Fused || b.Modules[2].Fused {
+		t.Fatalf("modules: %+v", b.Modules)
+	}
+	apk, err := b.UniversalAPK()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := zip.NewReader(bytes.NewReader(apk), int64(len(apk)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{}
+	var names []string
+	for _, f := range r.File {
+		data, err := readFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, f.Name)
+		files[f.Name] = data
+		if f.Method == zip.Store {
+			off, err := f.DataOffset()
+			if err != nil {
+				t.Fatal(err)
+			}
+			align := int64(alignDefault)
+			if f.Name == "lib/arm64-v8a/libfoo.so" {
+				align = alignLibs
+			}
+			if off%align != 0 {
+				t.Errorf("%s: data at %d, not aligned on %d", f.Name, off, align)
+			}
+		} else if f.Name == "resources.arsc" || f.Name == "lib/arm64-v8a/libfoo.so" {
+			t.Errorf("%s is compressed", f.Name)
+		}
+	}
+	want := []string{"resources.arsc", "AndroidManifest.xml", "classes.dex", "classes2.dex",
+		"res/layout/main.xml", "lib/arm64-v8a/libfoo.so", "kotlin/kotlin.kotlin_builtins", "assets/data.txt"}
+	if len(names) != len(want) {
+		t.Fatalf("entries %q, want %q", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("entries %q, want %q", names, want)
+		}
+	}
+	if string(files["classes2.dex"]) != "feature dex" {
+		t.Errorf("classes2.dex = %q", files["classes2.dex"])
+	}
+
+	manifest, err := axml.Parse(files["AndroidManifest.xml"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.AttrValue("", "package") != "com.example" || manifest.Attr(axml.NSAndroid, "isSplitRequired") != nil {
+		t.Errorf("manifest attributes %+v", manifest.Attrs)
+	}
+	manifest.Walk(func(e *axml.Element) {
+		if e.Name == "meta-data" {
+			t.Errorf("split meta-data kept: %+v", e.Attrs)
+		}
+	})
+	layout, err := axml.Parse(files["res/layout/main.xml"])
+	if err != nil || layout.Name != "LinearLayout" {
+		t.Errorf("layout: %v, %v", layout, err)
+	}
+
+	arsc := files["resources.arsc"]
+	if binary.LittleEndian.Uint16(arsc) != chunkTable || int(binary.LittleEndian.Uint32(arsc[4:])) != len(arsc) {
+		t.Fatalf("resources.arsc header % x", arsc[:12])
+	}
+	for _, s := range []string{"Hello", "res/layout/main.xml", "app_name", "layout"} {
+		if !bytes.Contains(arsc, []byte(s)) {
+			t.Errorf("resources.arsc lacks %q", s)
+		}
+	}
+
+	signer, err := signv2.NewApkSign(apk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := signer.SignV2(testKeys(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := signv2.NewApkSign(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = verifier.VerifyV2(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	fw, _ := w.Create("feature/manifest/AndroidManifest.xml")
+	fw.Write(featureManifest("true"))
+	w.Close()
+	if _, err := Open(buf.Bytes()); !errors.Is(err, ErrNoBase) {
+		t.Errorf("Open without base = %v, want %v", err, ErrNoBase)
+	}
+
+	buf.Reset()
+	w = zip.NewWriter(buf)
+	fw, _ = w.Create("base/manifest/AndroidManifest.xml")
+	fw.Write([]byte{0xff})
+	w.Close()
+	_, err := Open(buf.Bytes())
+	if loc, ok := signv2.ErrorContext(err); !ok || loc.Entry != "base/manifest/AndroidManifest.xml" {
+		t.Errorf("Open with a broken manifest = %v, located at %+v", err, loc)
+	}
+}
+
+func TestCheckParity(t *testing.T) {
+	b, err := Open(testBundle(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apk, err := b.UniversalAPK()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := signv2.NewApkSign(apk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := signer.SignV2(testKeys(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := b.CheckParity(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Equal() {
+		t.Errorf("universal APK differs from its bundle: %+v", r)
+	}
+
+	// rewrite the APK with a changed dex, a renamed resource, a missing asset and an extra file,
+	// compressing everything to show that storage does not matter
+	zr, err := zip.NewReader(bytes.NewReader(apk), int64(len(apk)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	add := func(name string, data []byte) {
+		fw, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate})
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(data)
+	}
+	for _, f := range zr.File {
+		data, err := readFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch f.Name {
+		case "assets/data.txt":
+			continue
+		case "classes2.dex":
+			data = []byte("patched dex")
+		case "resources.arsc":
+			data = bytes.Replace(data, []byte("app_name"), []byte("app_nane"), 1)
+		}
+		add(f.Name, data)
+	}
+	add("META-INF/CERT.SF", []byte("signature"))
+	add("extra.txt", []byte("extra"))
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if r, err = b.CheckParity(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	check := func(field string, got []string, want ...string) {
+		if !slices.Equal(got, want) {
+			t.Errorf("%s = %q, want %q", field, got, want)
+		}
+	}
+	check("Missing", r.Missing, "assets/data.txt")
+	check("Extra", r.Extra, "extra.txt")
+	check("Changed", r.Changed, "classes2.dex")
+	check("MissingResources", r.MissingResources, "0x7f010000 string/app_name")
+	check("ExtraResources", r.ExtraResources, "0x7f010000 string/app_nane")
+}
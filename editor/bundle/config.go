@@ -0,0 +1,202 @@
+package bundle
+
+import (
+	"encoding/binary"
+	"strings"
+
+	pw "github.com/pzx521521/apk-editor/editor/internal/protowire"
+)
+
+// configSize is the size of the ResTable_config aapt2 writes.
+const configSize = 64
+
+// Offsets of the ResTable_config fields.
+const (
+	cfgMCC              = 4
+	cfgMNC              = 6
+	cfgLanguage         = 8
+	cfgCountry          = 10
+	cfgOrientation      = 12
+	cfgTouchscreen      = 13
+	cfgDensity          = 14
+	cfgKeyboard         = 16
+	cfgNavigation       = 17
+	cfgInputFlags       = 18
+	cfgGrammatical      = 19
+	cfgScreenWidth      = 20
+	cfgScreenHeight     = 22
+	cfgSDKVersion       = 24
+	cfgScreenLayout     = 28
+	cfgUIMode           = 29
+	cfgSmallestWidthDp  = 30
+	cfgScreenWidthDp    = 32
+	cfgScreenHeightDp   = 34
+	cfgLocaleScript     = 36
+	cfgLocaleVariant    = 40
+	cfgScreenLayout2    = 48
+	cfgColorMode        = 49
+	cfgNumberingSystem  = 53
+	numberingSystemSize = 8
+)
+
+// configFlags maps the fields of ResTable_config that an entry may vary on to the
+// ResTable_typeSpec flag reporting it. Each field is given as offset, size and mask.
+var configFlags = []struct {
+	off, size int
+	mask      uint32
+	flag      uint32
+}{
+	{cfgMCC, 2, 0xffff, 0x0001},
+	{cfgMNC, 2, 0xffff, 0x0002},
+	{cfgLanguage, 4, 0xffffffff, 0x0004},
+	{cfgLocaleScript, 4, 0xffffffff, 0x0004},
+	{cfgLocaleVariant, 4, 0xffffffff, 0x0004},
+	{cfgTouchscreen, 1, 0xff, 0x0008},
+	{cfgKeyboard, 1, 0xff, 0x0010},
+	{cfgInputFlags, 1, 0x0f, 0x0020},
+	{cfgNavigation, 1, 0xff, 0x0040},
+	{cfgOrientation, 1, 0xff, 0x0080},
+	{cfgDensity, 2, 0xffff, 0x0100},
+	{cfgScreenWidth, 4, 0xffffffff, 0x0200},
+	{cfgScreenWidthDp, 4, 0xffffffff, 0x0200},
+	{cfgSDKVersion, 2, 0xffff, 0x0400},
+	{cfgScreenLayout, 1, 0x3f, 0x0800},
+	{cfgUIMode, 1, 0xff, 0x1000},
+	{cfgSmallestWidthDp, 2, 0xffff, 0x2000},
+	{cfgScreenLayout, 1, 0xc0, 0x4000},
+	{cfgScreenLayout2, 1, 0x03, 0x8000},
+	{cfgColorMode, 1, 0xff, 0x10000},
+	{cfgGrammatical, 1, 0xff, 0x20000},
+}
+
+// specFlags returns the ResTable_typeSpec flags for the fields set in config.
+func specFlags(config []byte) uint32 {
+	var flags uint32
+	for _, f := range configFlags {
+		var v uint32
+		switch f.size {
+		case 1:
+			v = uint32(config[f.off])
+		case 2:
+			v = uint32(binary.LittleEndian.Uint16(config[f.off:]))
+		default:
+			v = binary.LittleEndian.Uint32(config[f.off:])
+		}
+		if v&f.mask != 0 {
+			flags |= f.flag
+		}
+	}
+	return flags
+}
+
+// encodeConfig converts a Configuration message (frameworks/base/tools/aapt2/Configuration.proto)
+// to a ResTable_config. The enums of the message count from 1 in the order of the ResTable_config
+// constants, so most fields only need shifting into place.
+func encodeConfig(b []byte) ([]byte, error) {
+	c := make([]byte, configSize)
+	binary.LittleEndian.PutUint32(c, configSize)
+	u16 := func(off int, v uint64) { binary.LittleEndian.PutUint16(c[off:], uint16(v)) }
+	// yesNo maps the enums whose first value means yes to the ResTable_config values, where no
+	// comes first.
+	yesNo := func(v uint64) byte { return byte(3-v) % 3 }
+	err := fields(b, func(f pw.Field) error {
+		v := f.Varint
+		switch f.Num {
+		case 1:
+			u16(cfgMCC, v)
+		case 2:
+			u16(cfgMNC, v)
+		case 3:
+			encodeLocale(c, string(f.Bytes))
+		case 4:
+			c[cfgScreenLayout] |= byte(v) << 6
+		case 5:
+			u16(cfgScreenWidth, v)
+		case 6:
+			u16(cfgScreenHeight, v)
+		case 7:
+			u16(cfgScreenWidthDp, v)
+		case 8:
+			u16(cfgScreenHeightDp, v)
+		case 9:
+			u16(cfgSmallestWidthDp, v)
+		case 10:
+			c[cfgScreenLayout] |= byte(v)
+		case 11:
+			c[cfgScreenLayout] |= yesNo(v) << 4
+		case 12:
+			c[cfgScreenLayout2] |= yesNo(v)
+		case 13:
+			c[cfgColorMode] |= yesNo(v)
+		case 14:
+			c[cfgColorMode] |= yesNo(v) << 2
+		case 15:
+			c[cfgOrientation] = byte(v)
+		case 16:
+			c[cfgUIMode] |= byte(v)
+		case 17:
+			c[cfgUIMode] |= yesNo(v) << 4
+		case 18:
+			u16(cfgDensity, v)
+		case 19:
+			c[cfgTouchscreen] = byte(v)
+		case 20:
+			c[cfgInputFlags] |= byte(v)
+		case 21:
+			c[cfgKeyboard] = byte(v)
+		case 22:
+			c[cfgInputFlags] |= byte(v) << 2
+		case 23:
+			c[cfgNavigation] = byte(v)
+		case 24:
+			u16(cfgSDKVersion, v)
+		case 26:
+			c[cfgGrammatical] = byte(v)
+		}
+		return nil
+	})
+	return c, err
+}
+
+// encodeLocale sets the locale fields of c from a BCP 47 tag as aapt2 writes it, e.g. "en-US",
+// "sr-Latn-RS" or "ar-u-nu-latn".
+func encodeLocale(c []byte, tag string) {
+	parts := strings.Split(tag, "-")
+	packLocale(c[cfgLanguage:], parts[0], 'a')
+	for i := 1; i < len(parts); i++ {
+		p := parts[i]
+		switch {
+		case p == "u" && i+2 < len(parts) && parts[i+1] == "nu":
+			copy(c[cfgNumberingSystem:cfgNumberingSystem+numberingSystemSize], parts[i+2])
+			i += 2
+		case len(p) == 4 && !isDigits(p):
+			copy(c[cfgLocaleScript:cfgLocaleScript+4], strings.ToUpper(p[:1])+strings.ToLower(p[1:]))
+		case len(p) == 2 || len(p) == 3 && isDigits(p):
+			packLocale(c[cfgCountry:], strings.ToUpper(p), '0')
+		case len(p) >= 4:
+			copy(c[cfgLocaleVariant:cfgLocaleVariant+8], strings.ToLower(p))
+		}
+	}
+}
+
+// packLocale stores a language or region code in two bytes, packing three letter codes as
+// ResTable_config::packLanguageOrRegion does.
+func packLocale(out []byte, s string, base byte) {
+	switch len(s) {
+	case 2:
+		copy(out, s)
+	case 3:
+		first, second, third := (s[0]-base)&0x7f, (s[1]-base)&0x7f, (s[2]-base)&0x7f
+		out[0] = 0x80 | third<<2 | second>>3
+		out[1] = second<<5 | first
+	}
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,243 @@
+package bundle
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/pzx521521/apk-editor/editor/axml"
+	"github.com/pzx521521/apk-editor/editor/internal/restable"
+	"github.com/pzx521521/apk-editor/editor/internal/stringpool"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+// Layout of resources.arsc read by resourceNames.
+const (
+	chunkStringPool = 0x0001
+	pkgTypeStrings  = 268
+	pkgKeyStrings   = 276
+)
+
+var errTruncatedTable = restable.ErrTruncated
+
+// ParityReport lists how an APK differs from the universal APK of a bundle. Entry names are those
+// in the APK; an empty report means the APK holds exactly the content of the bundle.
+type ParityReport struct {
+	// Missing are entries of the universal APK the APK lacks.
+	Missing []string `json:"missing,omitempty"`
+	// Extra are entries of the APK that do not come from the bundle. Signature files are ignored.
+	Extra []string `json:"extra,omitempty"`
+	// Changed are entries whose content differs. Binary XML files are compared once decoded, so
+	// that string pool order does not matter, dex files and other entries byte for byte.
+	Changed []string `json:"changed,omitempty"`
+	// MissingResources and ExtraResources are resources present in only one resources.arsc, as
+	// "0x7f010000 string/app_name". A renamed resource is listed in both.
+	MissingResources []string `json:"missing_resources,omitempty"`
+	ExtraResources   []string `json:"extra_resources,omitempty"`
+}
+
+// Equal reports whether the report found no difference.
+func (r *ParityReport) Equal() bool {
+	return len(r.Missing)+len(r.Extra)+len(r.Changed)+len(r.MissingResources)+len(r.ExtraResources) == 0
+}
+
+// CheckParity compares apk with the universal APK built from the bundle, for pipelines that replace
+// bundletool and want to make sure the APK they ship, built by either tool, has the content of the
+// bundle. Compression, alignment and entry order are not compared, and resources only by ID and
+// name, as their encoding differs between tools.
+func (b *Bundle) CheckParity(apk []byte) (_ *ParityReport, err error) {
+	defer catch(&err, "CheckParity")
+	universal, err := b.UniversalAPK()
+	if err != nil {
+		return nil, err
+	}
+	want, err := zip.NewReader(bytes.NewReader(universal), int64(len(universal)))
+	if err != nil {
+		return nil, err
+	}
+	got, err := zip.NewReader(bytes.NewReader(apk), int64(len(apk)))
+	if err != nil {
+		return nil, err
+	}
+	gotFiles := map[string]*zip.File{}
+	for _, f := range got.File {
+		gotFiles[f.Name] = f
+	}
+
+	r := &ParityReport{}
+	wantNames := map[string]bool{}
+	for _, w := range want.File {
+		wantNames[w.Name] = true
+		g := gotFiles[w.Name]
+		if g == nil {
+			r.Missing = append(r.Missing, w.Name)
+			continue
+		}
+		wd, err := readFile(w)
+		if err != nil {
+			return nil, err
+		}
+		gd, err := readFile(g)
+		if err != nil {
+			return nil, entryError(g.Name, err)
+		}
+		if w.Name == "resources.arsc" {
+			if err = r.compareResources(wd, gd); err != nil {
+				return nil, entryError(g.Name, err)
+			}
+			continue
+		}
+		if !sameContent(wd, gd) {
+			r.Changed = append(r.Changed, w.Name)
+		}
+	}
+	for _, f := range got.File {
+		if !wantNames[f.Name] && !isSignatureFile(f.Name) && !strings.HasSuffix(f.Name, "/") {
+			r.Extra = append(r.Extra, f.Name)
+		}
+	}
+	slices.Sort(r.Missing)
+	slices.Sort(r.Extra)
+	slices.Sort(r.Changed)
+	return r, nil
+}
+
+// compareResources adds the resources found in only one of two resource tables to r.
+func (r *ParityReport) compareResources(want, got []byte) error {
+	wn, err := resourceNames(want)
+	if err != nil {
+		return err
+	}
+	gn, err := resourceNames(got)
+	if err != nil {
+		return err
+	}
+	diff := func(a, b map[uint32]string) []string {
+		var out []string
+		for id, name := range a {
+			if b[id] != name {
+				out = append(out, fmt.Sprintf("0x%08x %s", id, name))
+			}
+		}
+		slices.Sort(out)
+		return out
+	}
+	r.MissingResources = diff(wn, gn)
+	r.ExtraResources = diff(gn, wn)
+	return nil
+}
+
+// sameContent compares two entries, decoding them first if both are binary XML.
+func sameContent(a, b []byte) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+	ea, err := axml.Parse(a)
+	if err != nil {
+		return false
+	}
+	eb, err := axml.Parse(b)
+	if err != nil {
+		return false
+	}
+	return sameElement(ea, eb)
+}
+
+// sameElement compares two decoded XML trees by names, texts and formatted attribute values;
+// line numbers and namespace declarations are left out.
+func sameElement(a, b *axml.Element) bool {
+	if a.NS != b.NS || a.Name != b.Name || a.Text != b.Text ||
+		len(a.Attrs) != len(b.Attrs) || len(a.Children) != len(b.Children) {
+		return false
+	}
+	for i, x := range a.Attrs {
+		y := b.Attrs[i]
+		if x.NS != y.NS || x.Name != y.Name || x.ResID != y.ResID || x.Value() != y.Value() {
+			return false
+		}
+	}
+	for i := range a.Children {
+		if !sameElement(a.Children[i], b.Children[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// resourceNames returns the resources of every package of a resources.arsc as "type/name" by ID.
+func resourceNames(arsc []byte) (map[uint32]string, error) {
+	if len(arsc) < 12 || binary.LittleEndian.Uint16(arsc) != chunkTable {
+		return nil, errors.New("resources.arsc is not a resource table")
+	}
+	names := map[uint32]string{}
+	err := restable.Chunks(arsc, int(binary.LittleEndian.Uint16(arsc[2:])), func(_ int, typ uint16, c []byte) error {
+		if typ != chunkPackage {
+			return nil
+		}
+		if len(c) < pkgKeyStrings+4 {
+			return errTruncatedTable
+		}
+		id := binary.LittleEndian.Uint32(c[8:])
+		typeStrings := int(binary.LittleEndian.Uint32(c[pkgTypeStrings:]))
+		keyStrings := int(binary.LittleEndian.Uint32(c[pkgKeyStrings:]))
+		var typeNames, keys []string
+		var types [][]byte
+		err := restable.Chunks(c, int(binary.LittleEndian.Uint16(c[2:])), func(off int, typ uint16, chunk []byte) (err error) {
+			switch {
+			case typ == chunkStringPool && off == typeStrings:
+				typeNames, _, err = stringpool.Decode(chunk)
+			case typ == chunkStringPool && off == keyStrings:
+				keys, _, err = stringpool.Decode(chunk)
+			case typ == chunkType:
+				types = append(types, chunk)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for _, t := range types {
+			if len(t) < 9 {
+				return errTruncatedTable
+			}
+			if int(t[8]) == 0 || int(t[8]) > len(typeNames) {
+				return fmt.Errorf("package 0x%02x: type %d out of range", id, t[8])
+			}
+			typeName := typeNames[t[8]-1]
+			err = restable.TypeEntries(t, func(entry int, e []byte) error {
+				key := restable.EntryKey(e)
+				if int64(key) >= int64(len(keys)) {
+					return fmt.Errorf("key %d out of range", key)
+				}
+				names[id<<24|uint32(t[8])<<16|uint32(entry)] = typeName + "/" + keys[key]
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("package 0x%02x: %w", id, err)
+			}
+		}
+		return nil
+	})
+	return names, err
+}
+
+// isSignatureFile reports whether name belongs to the signature of an APK rather than to its
+// content: the v1 signature files and the source stamp certificate digest.
+func isSignatureFile(name string) bool {
+	if name == "stamp-cert-sha256" {
+		return true
+	}
+	dir, file := path.Split(name)
+	if dir != "META-INF/" {
+		return false
+	}
+	switch strings.ToUpper(path.Ext(file)) {
+	case ".MF", ".SF", ".RSA", ".DSA", ".EC":
+		return true
+	}
+	return false
+}
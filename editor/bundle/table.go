@@ -0,0 +1,516 @@
+package bundle
+
+import (
+	"cmp"
+	"errors"
+	"math"
+	"slices"
+
+	pw "github.com/pzx521521/apk-editor/editor/internal/protowire"
+	"github.com/pzx521521/apk-editor/editor/internal/stringpool"
+)
+
+// Res_value data types, see ResourceTypes.h.
+const (
+	typeNull             = 0x00
+	typeReference        = 0x01
+	typeAttribute        = 0x02
+	typeString           = 0x03
+	typeFloat            = 0x04
+	typeDimension        = 0x05
+	typeFraction         = 0x06
+	typeDynamicReference = 0x07
+	typeDynamicAttribute = 0x08
+	typeIntDec           = 0x10
+	typeIntHex           = 0x11
+	typeIntBoolean       = 0x12
+	typeIntColorARGB8    = 0x1c
+	typeIntColorRGB8     = 0x1d
+	typeIntColorARGB4    = 0x1e
+	typeIntColorRGB4     = 0x1f
+)
+
+// Keys of the ResTable_map entries of attributes and plurals.
+const (
+	attrType  = 0x01000000
+	attrMin   = 0x01000001
+	attrMax   = 0x01000002
+	attrOther = 0x01000004
+	attrZero  = 0x01000005
+)
+
+// fileTypeProtoXML is FileReference.Type PROTO_XML: the file is an XmlNode that must be compiled
+// to binary XML for an APK.
+const fileTypeProtoXML = 3
+
+// table is the part of a ResourceTable message (frameworks/base/tools/aapt2/Resources.proto) that
+// goes into resources.arsc.
+type table struct {
+	packages []*resPackage
+}
+
+type resPackage struct {
+	id    uint32
+	name  string
+	types []*resType
+}
+
+type resType struct {
+	id      uint32
+	name    string
+	entries []*resEntry
+}
+
+type resEntry struct {
+	id     uint32
+	name   string
+	public bool
+	values []*configValue
+}
+
+type configValue struct {
+	config []byte // ResTable_config, see encodeConfig
+	value  *value
+}
+
+// value is a compiled value: an item, or a map of items for styles, attributes, arrays and the
+// like.
+type value struct {
+	weak    bool
+	complex bool
+	item    item
+	parent  uint32
+	entries []mapEntry
+}
+
+type mapEntry struct {
+	name uint32
+	item item
+}
+
+// item is a Res_value. For strings, data is assigned when the string pool is built.
+type item struct {
+	typ   uint8
+	data  uint32
+	str   string
+	spans []stringpool.Span
+	// xml is set for a file reference to a proto XML file.
+	xml bool
+}
+
+// fields calls fn for each field of the message b.
+func fields(b []byte, fn func(f pw.Field) error) error {
+	for len(b) > 0 {
+		f, rest, err := pw.Next(b)
+		if err != nil {
+			return err
+		}
+		if err = fn(f); err != nil {
+			return err
+		}
+		b = rest
+	}
+	return nil
+}
+
+// idField returns the id field of the PackageId, TypeId and EntryId messages.
+func idField(b []byte) (id uint32, err error) {
+	err = fields(b, func(f pw.Field) error {
+		if f.Num == 1 {
+			id = uint32(f.Varint)
+		}
+		return nil
+	})
+	return id, err
+}
+
+// stringField returns field 1 of the String and RawString messages.
+func stringField(b []byte) (s string, err error) {
+	err = fields(b, func(f pw.Field) error {
+		if f.Num == 1 {
+			s = string(f.Bytes)
+		}
+		return nil
+	})
+	return s, err
+}
+
+func parseTable(b []byte) (*table, error) {
+	t := &table{}
+	err := fields(b, func(f pw.Field) error {
+		if f.Num != 2 {
+			return nil
+		}
+		p, err := parsePackage(f.Bytes)
+		t.packages = append(t.packages, p)
+		return err
+	})
+	return t, err
+}
+
+func parsePackage(b []byte) (*resPackage, error) {
+	p := &resPackage{}
+	return p, fields(b, func(f pw.Field) (err error) {
+		switch f.Num {
+		case 1:
+			p.id, err = idField(f.Bytes)
+		case 2:
+			p.name = string(f.Bytes)
+		case 3:
+			var t *resType
+			t, err = parseType(f.Bytes)
+			p.types = append(p.types, t)
+		}
+		return err
+	})
+}
+
+func parseType(b []byte) (*resType, error) {
+	t := &resType{}
+	return t, fields(b, func(f pw.Field) (err error) {
+		switch f.Num {
+		case 1:
+			t.id, err = idField(f.Bytes)
+		case 2:
+			t.name = string(f.Bytes)
+		case 3:
+			var e *resEntry
+			e, err = parseEntry(f.Bytes)
+			t.entries = append(t.entries, e)
+		}
+		return err
+	})
+}
+
+func parseEntry(b []byte) (*resEntry, error) {
+	e := &resEntry{}
+	return e, fields(b, func(f pw.Field) (err error) {
+		switch f.Num {
+		case 1:
+			e.id, err = idField(f.Bytes)
+		case 2:
+			e.name = string(f.Bytes)
+		case 3:
+			// Visibility.level PUBLIC
+			err = fields(f.Bytes, func(f pw.Field) error {
+				if f.Num == 3 {
+					e.public = f.Varint == 2
+				}
+				return nil
+			})
+		case 6:
+			cv := &configValue{}
+			err = fields(f.Bytes, func(f pw.Field) (err error) {
+				switch f.Num {
+				case 1:
+					cv.config, err = encodeConfig(f.Bytes)
+				case 2:
+					cv.value, err = parseValue(f.Bytes)
+				}
+				return err
+			})
+			if cv.config == nil {
+				cv.config, _ = encodeConfig(nil)
+			}
+			if cv.value != nil {
+				e.values = append(e.values, cv)
+			}
+		}
+		return err
+	})
+}
+
+// parseValue parses a Value message. It returns nil for values that have no binary form, such as
+// macros.
+func parseValue(b []byte) (*value, error) {
+	v := &value{}
+	var ok bool
+	err := fields(b, func(f pw.Field) (err error) {
+		switch f.Num {
+		case 3:
+			v.weak = f.Varint != 0
+		case 4:
+			v.item, err = parseItem(f.Bytes)
+			ok = true
+		case 5:
+			v.complex = true
+			ok, err = v.parseCompound(f.Bytes)
+		}
+		return err
+	})
+	if err != nil || !ok {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (v *value) add(name uint32, b []byte) error {
+	it, err := parseItem(b)
+	v.entries = append(v.entries, mapEntry{name, it})
+	return err
+}
+
+// parseCompound parses a CompoundValue message into the map entries aapt2 flattens it to.
+func (v *value) parseCompound(b []byte) (ok bool, err error) {
+	err = fields(b, func(f pw.Field) error {
+		switch f.Num {
+		case 1:
+			ok = true
+			return v.parseAttribute(f.Bytes)
+		case 2:
+			ok = true
+			// the platform merges a style with its parent assuming both are sorted by key
+			defer func() {
+				slices.SortStableFunc(v.entries, func(a, b mapEntry) int { return cmp.Compare(a.name, b.name) })
+			}()
+			return fields(f.Bytes, func(f pw.Field) (err error) {
+				switch f.Num {
+				case 1:
+					v.parent, _, err = parseReference(f.Bytes)
+				case 3:
+					var key uint32
+					var it []byte
+					err = fields(f.Bytes, func(f pw.Field) (err error) {
+						switch f.Num {
+						case 3:
+							key, _, err = parseReference(f.Bytes)
+						case 4:
+							it = f.Bytes
+						}
+						return err
+					})
+					if err == nil {
+						err = v.add(key, it)
+					}
+				}
+				return err
+			})
+		case 3:
+			ok = true
+			return fields(f.Bytes, func(f pw.Field) error {
+				if f.Num != 1 {
+					return nil
+				}
+				return fields(f.Bytes, func(f pw.Field) error {
+					if f.Num != 3 {
+						return nil
+					}
+					id, _, err := parseReference(f.Bytes)
+					v.entries = append(v.entries, mapEntry{id, item{}})
+					return err
+				})
+			})
+		case 4:
+			ok = true
+			return fields(f.Bytes, func(f pw.Field) error {
+				if f.Num != 1 {
+					return nil
+				}
+				name := uint32(len(v.entries))
+				return fields(f.Bytes, func(f pw.Field) error {
+					if f.Num != 3 {
+						return nil
+					}
+					return v.add(name, f.Bytes)
+				})
+			})
+		case 5:
+			ok = true
+			return fields(f.Bytes, func(f pw.Field) error {
+				if f.Num != 1 {
+					return nil
+				}
+				var arity uint64
+				var it []byte
+				err := fields(f.Bytes, func(f pw.Field) error {
+					switch f.Num {
+					case 3:
+						arity = f.Varint
+					case 4:
+						it = f.Bytes
+					}
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+				// Arity ZERO..MANY follow each other from attrZero, OTHER comes before them
+				name := uint32(attrZero + arity)
+				if arity == 5 {
+					name = attrOther
+				}
+				return v.add(name, it)
+			})
+		}
+		return nil
+	})
+	return ok, err
+}
+
+func (v *value) parseAttribute(b []byte) error {
+	var format uint32
+	minInt, maxInt := int32(0), int32(0)
+	var symbols []mapEntry
+	err := fields(b, func(f pw.Field) error {
+		switch f.Num {
+		case 1:
+			format = uint32(f.Varint)
+		case 2:
+			minInt = int32(f.Varint)
+		case 3:
+			maxInt = int32(f.Varint)
+		case 4:
+			var s mapEntry
+			s.item.typ = typeIntDec
+			err := fields(f.Bytes, func(f pw.Field) (err error) {
+				switch f.Num {
+				case 3:
+					s.name, _, err = parseReference(f.Bytes)
+				case 4:
+					s.item.data = uint32(f.Varint)
+				case 5:
+					s.item.typ = uint8(f.Varint)
+				}
+				return err
+			})
+			symbols = append(symbols, s)
+			return err
+		}
+		return nil
+	})
+	v.entries = append(v.entries, mapEntry{attrType, item{typ: typeIntDec, data: format}})
+	if minInt != math.MinInt32 {
+		v.entries = append(v.entries, mapEntry{attrMin, item{typ: typeIntDec, data: uint32(minInt)}})
+	}
+	if maxInt != math.MaxInt32 {
+		v.entries = append(v.entries, mapEntry{attrMax, item{typ: typeIntDec, data: uint32(maxInt)}})
+	}
+	v.entries = append(v.entries, symbols...)
+	return err
+}
+
+// parseReference parses a Reference message and returns its resource ID and data type.
+func parseReference(b []byte) (id uint32, typ uint8, err error) {
+	attr, dynamic := false, false
+	err = fields(b, func(f pw.Field) error {
+		switch f.Num {
+		case 1:
+			attr = f.Varint == 1
+		case 2:
+			id = uint32(f.Varint)
+		case 5:
+			// Boolean is_dynamic
+			return fields(f.Bytes, func(f pw.Field) error {
+				dynamic = f.Num == 1 && f.Varint != 0
+				return nil
+			})
+		}
+		return nil
+	})
+	switch {
+	case attr && dynamic:
+		typ = typeDynamicAttribute
+	case attr:
+		typ = typeAttribute
+	case dynamic:
+		typ = typeDynamicReference
+	default:
+		typ = typeReference
+	}
+	return id, typ, err
+}
+
+// parseItem parses an Item message.
+func parseItem(b []byte) (it item, err error) {
+	err = fields(b, func(f pw.Field) (err error) {
+		switch f.Num {
+		case 1:
+			it.data, it.typ, err = parseReference(f.Bytes)
+		case 2, 3:
+			it.typ = typeString
+			it.str, err = stringField(f.Bytes)
+		case 4:
+			it.typ = typeString
+			err = fields(f.Bytes, func(f pw.Field) error {
+				switch f.Num {
+				case 1:
+					it.str = string(f.Bytes)
+				case 2:
+					var s stringpool.Span
+					it.spans = append(it.spans, s)
+					return fields(f.Bytes, func(f pw.Field) error {
+						s := &it.spans[len(it.spans)-1]
+						switch f.Num {
+						case 1:
+							s.Tag = string(f.Bytes)
+						case 2:
+							s.First = uint32(f.Varint)
+						case 3:
+							s.Last = uint32(f.Varint)
+						}
+						return nil
+					})
+				}
+				return nil
+			})
+		case 5:
+			it.typ = typeString
+			err = fields(f.Bytes, func(f pw.Field) error {
+				switch f.Num {
+				case 1:
+					it.str = string(f.Bytes)
+				case 2:
+					it.xml = f.Varint == fileTypeProtoXML
+				}
+				return nil
+			})
+		case 6:
+			// Id is flattened as a false boolean
+			it.typ, it.data = typeIntBoolean, 0
+		case 7:
+			it.typ, it.data, err = parsePrimitive(f.Bytes)
+		}
+		return err
+	})
+	return it, err
+}
+
+// primitiveTypes maps the fields of the Primitive message to data types. null_value and
+// empty_value are handled apart.
+var primitiveTypes = map[int]uint8{
+	3:  typeFloat,
+	4:  typeDimension,
+	5:  typeFraction,
+	6:  typeIntDec,
+	7:  typeIntHex,
+	8:  typeIntBoolean,
+	9:  typeIntColorARGB8,
+	10: typeIntColorRGB8,
+	11: typeIntColorARGB4,
+	12: typeIntColorRGB4,
+	13: typeDimension,
+	14: typeFraction,
+}
+
+func parsePrimitive(b []byte) (typ uint8, data uint32, err error) {
+	err = fields(b, func(f pw.Field) error {
+		switch f.Num {
+		case 1:
+			typ, data = typeNull, 0
+		case 2:
+			// DATA_NULL_EMPTY
+			typ, data = typeNull, 1
+		default:
+			t, ok := primitiveTypes[f.Num]
+			if !ok {
+				return errors.New("unknown primitive value")
+			}
+			typ, data = t, uint32(f.Varint)
+			if t == typeIntBoolean && data != 0 {
+				data = 0xffffffff
+			}
+		}
+		return nil
+	})
+	return typ, data, err
+}
@@ -0,0 +1,108 @@
+package bundle
+
+import (
+	"errors"
+
+	"github.com/pzx521521/apk-editor/editor/axml"
+	pw "github.com/pzx521521/apk-editor/editor/internal/protowire"
+)
+
+// compileXML converts a proto XML file, an XmlNode message, to binary XML.
+func compileXML(b []byte) ([]byte, error) {
+	root, err := parseXMLNode(b)
+	if err != nil {
+		return nil, err
+	}
+	if root.Name == "" {
+		return nil, errors.New("document has no element")
+	}
+	return axml.Encode(root), nil
+}
+
+// parseXMLNode converts an XmlNode message to an element, or to a text node.
+func parseXMLNode(b []byte) (*axml.Element, error) {
+	e := &axml.Element{}
+	err := fields(b, func(f pw.Field) error {
+		switch f.Num {
+		case 1:
+			return parseXMLElement(e, f.Bytes)
+		case 2:
+			e.Text = string(f.Bytes)
+		case 3:
+			// SourcePosition.line_number
+			return fields(f.Bytes, func(f pw.Field) error {
+				if f.Num == 1 {
+					e.Line = uint32(f.Varint)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return e, err
+}
+
+func parseXMLElement(e *axml.Element, b []byte) error {
+	return fields(b, func(f pw.Field) error {
+		switch f.Num {
+		case 1:
+			var ns axml.Namespace
+			err := fields(f.Bytes, func(f pw.Field) error {
+				switch f.Num {
+				case 1:
+					ns.Prefix = string(f.Bytes)
+				case 2:
+					ns.URI = string(f.Bytes)
+				}
+				return nil
+			})
+			e.Namespaces = append(e.Namespaces, ns)
+			return err
+		case 2:
+			e.NS = string(f.Bytes)
+		case 3:
+			e.Name = string(f.Bytes)
+		case 4:
+			a, err := parseXMLAttribute(f.Bytes)
+			e.Attrs = append(e.Attrs, a)
+			return err
+		case 5:
+			c, err := parseXMLNode(f.Bytes)
+			e.Children = append(e.Children, c)
+			return err
+		}
+		return nil
+	})
+}
+
+// parseXMLAttribute converts an XmlAttribute message. The raw value is kept, as aapt2 does for
+// the manifest; an attribute without compiled value is a string.
+func parseXMLAttribute(b []byte) (*axml.Attr, error) {
+	a := &axml.Attr{Type: axml.TypeString}
+	var it *item
+	err := fields(b, func(f pw.Field) error {
+		switch f.Num {
+		case 1:
+			a.NS = string(f.Bytes)
+		case 2:
+			a.Name = string(f.Bytes)
+		case 3:
+			a.Raw = string(f.Bytes)
+		case 5:
+			a.ResID = uint32(f.Varint)
+		case 6:
+			parsed, err := parseItem(f.Bytes)
+			it = &parsed
+			return err
+		}
+		return nil
+	})
+	switch {
+	case it == nil:
+	case it.typ == typeString:
+		a.Raw = it.str
+	default:
+		a.Type, a.Data = it.typ, it.data
+	}
+	return a, err
+}
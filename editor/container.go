@@ -0,0 +1,208 @@
+package editor
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/pzx521521/apk-editor/editor/axml"
+	"github.com/pzx521521/apk-editor/editor/bundle"
+	pw "github.com/pzx521521/apk-editor/editor/internal/protowire"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+	"github.com/pzx521521/apk-editor/editor/splits"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+// ContainerKind Open 识别出的容器格式
+type ContainerKind int
+
+const (
+	ContainerZip    ContainerKind = iota // 不属于以下任何格式的 zip
+	ContainerAPK                         // 单个 apk
+	ContainerAAB                         // Android App Bundle
+	ContainerSplits                      // split apk 集合: .apks, .xapk, .apkm
+	ContainerAPEX                        // APEX 系统模块
+)
+
+func (k ContainerKind) String() string {
+	switch k {
+	case ContainerZip:
+		return "zip"
+	case ContainerAPK:
+		return "apk"
+	case ContainerAAB:
+		return "aab"
+	case ContainerSplits:
+		return "splits"
+	case ContainerAPEX:
+		return "apex"
+	}
+	return "ContainerKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// MarshalText 以 String 的形式输出到 JSON
+func (k ContainerKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// APEX 中的条目
+const (
+	apexManifestPB   = "apex_manifest.pb"
+	apexManifestJSON = "apex_manifest.json" // Android 10 的旧格式
+	apexPayload      = "apex_payload.img"
+	apexPubKey       = "apex_pubkey"
+)
+
+// Apex APEX 模块的清单
+type Apex struct {
+	Name    string // 模块名, 如 com.android.tzdata
+	Version int64
+	PubKey  []byte // apex_pubkey, 校验 payload 的 AVB 公钥
+}
+
+// Container 打开的容器. 只有与 Kind 对应的字段不为空:
+// APK 为 Sign 和 Manifest, AAB 为 Bundle, Splits 为 Splits, APEX 为 Apex 和 Sign.
+// 普通 zip 只有 Data
+type Container struct {
+	Kind     ContainerKind
+	Data     []byte
+	Sign     *signv2.ApkSign // apk 或 APEX 的签名信息
+	Manifest *axml.Element   // apk 的 AndroidManifest.xml
+	Bundle   *bundle.Bundle
+	Splits   *splits.Set
+	Apex     *Apex
+}
+
+// Open 按内容识别 b 的格式并打开, 命令行与服务无需为每种格式提供入口.
+// 依次判断: 含 apex_payload.img 或 apex 清单的为 APEX; 含 BundleConfig.pb 或
+// base/manifest/AndroidManifest.xml 的为 AAB; 根目录有 AndroidManifest.xml 的为 apk;
+// 含 .apk 条目的为 split 集合; 其余为普通 zip
+func Open(b []byte) (_ *Container, err error) {
+	defer catch(&err, "Open", "")
+	r, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return nil, err
+	}
+	c := &Container{Kind: ContainerZip, Data: b}
+	names := map[string]*zip.File{}
+	hasAPK := false
+	for _, f := range r.File {
+		names[f.Name] = f
+		hasAPK = hasAPK || path.Ext(f.Name) == ".apk"
+	}
+	switch {
+	case names[apexPayload] != nil || names[apexManifestPB] != nil || names[apexManifestJSON] != nil:
+		c.Kind = ContainerAPEX
+		if c.Apex, err = readApex(names); err != nil {
+			return nil, err
+		}
+		c.Sign, err = signv2.NewApkSignNoCopy(b)
+	case names["BundleConfig.pb"] != nil || names["base/manifest/AndroidManifest.xml"] != nil:
+		c.Kind = ContainerAAB
+		c.Bundle, err = bundle.Open(b)
+	case names[zip.ANDROIDMANIFEST] != nil:
+		c.Kind = ContainerAPK
+		var manifest []byte
+		if manifest, err = readManifest(r); err == nil {
+			c.Manifest, err = parseManifest(manifest)
+		}
+		if err == nil {
+			c.Sign, err = signv2.NewApkSignNoCopy(b)
+		}
+	case hasAPK:
+		c.Kind = ContainerSplits
+		c.Splits, err = splits.Open(b)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", c.Kind, err)
+	}
+	return c, nil
+}
+
+// Signed 按容器格式用 keys 签名, 返回签名后的文件: apk 用 v2 签名; AAB 与 jarsigner 一样只用 v1 签名;
+// split 集合中的每个 apk 用同一组 keys 签名 (会替换 Splits 中的 apk), 其余条目原样保留.
+// APEX 和普通 zip 返回错误
+func (c *Container) Signed(keys []*signv2.SigningCert, opts ...signv2.Option) (_ []byte, err error) {
+	defer catch(&err, "Signed", "")
+	switch c.Kind {
+	case ContainerAPK:
+		return c.Sign.SignV2(keys, opts...)
+	case ContainerAAB:
+		z, err := signv2.NewApkSign(c.Data, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return z.SignV1(keys, opts...)
+	case ContainerSplits:
+		if err = c.Splits.Sign(keys, opts...); err != nil {
+			return nil, err
+		}
+		buf := new(bytes.Buffer)
+		if _, err = c.Splits.WriteTo(buf); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, fmt.Errorf("%w: %s", signv2.ErrNotSignable, c.Kind)
+}
+
+// OpenFile 读取文件 name 并用 Open 打开
+func OpenFile(name string) (*Container, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return Open(b)
+}
+
+// readApex 读取 APEX 清单, 优先使用 apex_manifest.pb
+func readApex(names map[string]*zip.File) (*Apex, error) {
+	apex := &Apex{}
+	if f := names[apexPubKey]; f != nil {
+		b, err := readEntry(f)
+		if err != nil {
+			return nil, entryError(f.Name, err)
+		}
+		apex.PubKey = b
+	}
+	if f := names[apexManifestPB]; f != nil {
+		b, err := readEntry(f)
+		if err != nil {
+			return nil, entryError(f.Name, err)
+		}
+		fields, err := pw.Fields(b)
+		if err != nil {
+			return nil, entryError(f.Name, err)
+		}
+		// ApexManifest: 1 name, 2 version
+		for _, fd := range fields {
+			switch {
+			case fd.Num == 1 && fd.Type == pw.BytesType:
+				apex.Name = string(fd.Bytes)
+			case fd.Num == 2 && fd.Type == pw.VarintType:
+				apex.Version = int64(fd.Varint)
+			}
+		}
+	} else if f := names[apexManifestJSON]; f != nil {
+		b, err := readEntry(f)
+		if err != nil {
+			return nil, entryError(f.Name, err)
+		}
+		var m struct {
+			Name    string `json:"name"`
+			Version int64  `json:"version"`
+		}
+		if err = json.Unmarshal(b, &m); err != nil {
+			return nil, entryError(f.Name, err)
+		}
+		apex.Name, apex.Version = m.Name, m.Version
+	}
+	if apex.Name == "" {
+		return nil, errors.New("apex has no " + apexManifestPB + " naming the module")
+	}
+	return apex, nil
+}
@@ -0,0 +1,148 @@
+package editor
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/pzx521521/apk-editor/editor/apktest"
+	pw "github.com/pzx521521/apk-editor/editor/internal/protowire"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+// testArchive 按名称/内容对生成 zip
+func testArchive(t *testing.T, entries ...any) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	for i := 0; i+1 < len(entries); i += 2 {
+		fw, err := w.CreateHeader(&zip.FileHeader{Name: entries[i].(string), Method: zip.Deflate})
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(entries[i+1].([]byte))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestOpen(t *testing.T) {
+	apk, err := apktest.BuildSigned(apktest.Config{Package: "com.example.open"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// XmlNode{element: XmlElement{name: "manifest"}}
+	aabManifest := pw.AppendBytes(nil, 1, pw.AppendString(nil, 3, "manifest"))
+	apexManifest := pw.AppendUint(pw.AppendString(nil, 1, "com.android.example"), 2, 330000000)
+
+	for _, tt := range []struct {
+		name string
+		data []byte
+		want ContainerKind
+	}{
+		{"apk", apk, ContainerAPK},
+		{"aab", testArchive(t, "BundleConfig.pb", []byte{}, "base/manifest/AndroidManifest.xml", aabManifest), ContainerAAB},
+		{"apks", testArchive(t, "toc.pb", []byte{}, "splits/base-master.apk", apk), ContainerSplits},
+		{"apex", testArchive(t, apexManifestPB, apexManifest, apexPubKey, []byte("key"), apexPayload, []byte("img")), ContainerAPEX},
+		{"zip", testArchive(t, "readme.txt", []byte("hello")), ContainerZip},
+	} {
+		c, err := Open(tt.data)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if c.Kind != tt.want {
+			t.Errorf("%s: kind %v, want %v", tt.name, c.Kind, tt.want)
+		}
+		switch c.Kind {
+		case ContainerAPK:
+			if c.Sign == nil || c.Manifest.AttrValue("", "package") != "com.example.open" {
+				t.Errorf("apk handle %+v", c)
+			}
+		case ContainerAAB:
+			if c.Bundle == nil || c.Bundle.Modules[0].Name != "base" {
+				t.Errorf("aab handle %+v", c.Bundle)
+			}
+		case ContainerSplits:
+			if c.Splits == nil || c.Splits.Package != "com.example.open" {
+				t.Errorf("splits handle %+v", c.Splits)
+			}
+		case ContainerAPEX:
+			if c.Apex == nil || c.Apex.Name != "com.android.example" || c.Apex.Version != 330000000 || string(c.Apex.PubKey) != "key" {
+				t.Errorf("apex handle %+v", c.Apex)
+			}
+		}
+	}
+
+	if _, err = Open([]byte("not a zip")); err == nil {
+		t.Error("Open accepted a non-zip")
+	}
+	if _, err = Open(testArchive(t, apexPayload, []byte("img"))); err == nil {
+		t.Error("Open accepted an apex without a manifest")
+	}
+}
+
+func TestContainerSigned(t *testing.T) {
+	apk, err := apktest.Build(apktest.Config{Package: "com.example.sign"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := apktest.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	aabManifest := pw.AppendBytes(nil, 1, pw.AppendString(nil, 3, "manifest"))
+	aab := testArchive(t, "BundleConfig.pb", []byte{}, "base/manifest/AndroidManifest.xml", aabManifest)
+	apks := testArchive(t, "toc.pb", []byte{}, "splits/base-master.apk", apk)
+	keys := []*signv2.SigningCert{key}
+
+	// AAB 只有 v1 签名, 不能用 v2 签名
+	c, err := Open(aab)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := c.Signed(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := signv2.NewApkSign(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !z.IsV1Signed || z.IsV2Signed || z.ParseResult().Kind != signv2.KindAAB {
+		t.Errorf("signed aab: v1 %t, v2 %t, kind %v", z.IsV1Signed, z.IsV2Signed, z.ParseResult().Kind)
+	}
+	if _, err = z.SignV2(keys); !errors.Is(err, signv2.ErrNotSignable) {
+		t.Errorf("SignV2 of an aab = %v", err)
+	}
+
+	// split 集合中的每个 apk 都签名
+	if c, err = Open(apks); err != nil {
+		t.Fatal(err)
+	}
+	if signed, err = c.Signed(keys); err != nil {
+		t.Fatal(err)
+	}
+	if c, err = Open(signed); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range c.Splits.Splits {
+		z, err := signv2.NewApkSign(s.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = z.VerifyV2(); err != nil {
+			t.Errorf("%s: %v", s.Path, err)
+		}
+	}
+
+	if c, err = Open(testArchive(t, "readme.txt", []byte("hello"))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Signed(keys); err == nil {
+		t.Error("signed a plain zip")
+	}
+}
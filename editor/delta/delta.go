@@ -0,0 +1,221 @@
+// Package delta computes patches between two versions of an APK at the zip entry level: the
+// compressed data of entries that did not change is referenced in the old APK instead of being
+// shipped, and everything else, changed entries, headers, the signing block and the central
+// directory, is carried in the patch. Apply rebuilds the new APK byte for byte, so its signature
+// still verifies.
+package delta
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"compress/flate"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+// magic starts every patch, followed by the version of the format.
+const magic = "APKDELTA\x01"
+
+// Operations of a patch.
+const (
+	opCopy = 1 // uvarint 旧文件偏移, uvarint 长度
+	opData = 2 // uvarint 长度, 数据
+	opEnd  = 0
+)
+
+// minCopy is the shortest range worth a copy operation instead of literal bytes.
+const minCopy = 64
+
+// Errors returned by Apply.
+var (
+	ErrBadPatch  = errors.New("delta: malformed patch")
+	ErrWrongBase = errors.New("delta: patch was made for a different old apk")
+	ErrMismatch  = errors.New("delta: result does not match the patch digest")
+)
+
+// header is the fixed part of a patch after magic.
+type header struct {
+	OldSize uint64
+	OldHash [32]byte
+	NewSize uint64
+	NewHash [32]byte
+}
+
+// region is a range of the new file found in the old one.
+type region struct {
+	newOff, oldOff, size int64
+}
+
+// Diff returns a patch that turns oldAPK into newAPK. Entries of newAPK whose compressed data
+// is found in oldAPK, under the same name or another, are copied from it; the rest is stored in
+// the patch, deflated.
+func Diff(oldAPK, newAPK []byte) ([]byte, error) {
+	oldZip, err := zip.NewReader(bytes.NewReader(oldAPK), int64(len(oldAPK)))
+	if err != nil {
+		return nil, fmt.Errorf("old apk: %w", err)
+	}
+	newZip, err := zip.NewReader(bytes.NewReader(newAPK), int64(len(newAPK)))
+	if err != nil {
+		return nil, fmt.Errorf("new apk: %w", err)
+	}
+
+	// 按名称或 (CRC, 大小, 压缩方式) 查找旧 apk 中的条目, 压缩后的数据相同才复用
+	type key struct {
+		crc        uint32
+		comp, size uint64
+		method     uint16
+	}
+	byName := map[string]*zip.File{}
+	byContent := map[key]*zip.File{}
+	for _, f := range oldZip.File {
+		byName[f.Name] = f
+		byContent[key{f.CRC32, f.CompressedSize64, f.UncompressedSize64, f.Method}] = f
+	}
+	var regions []region
+	for _, f := range newZip.File {
+		if f.CompressedSize64 < minCopy {
+			continue
+		}
+		k := key{f.CRC32, f.CompressedSize64, f.UncompressedSize64, f.Method}
+		old := byName[f.Name]
+		if old == nil || (key{old.CRC32, old.CompressedSize64, old.UncompressedSize64, old.Method}) != k {
+			old = byContent[k]
+		}
+		if old == nil {
+			continue
+		}
+		newOff, err := f.DataOffset()
+		if err != nil {
+			return nil, fmt.Errorf("new apk: %s: %w", f.Name, err)
+		}
+		oldOff, err := old.DataOffset()
+		if err != nil {
+			return nil, fmt.Errorf("old apk: %s: %w", old.Name, err)
+		}
+		size := int64(f.CompressedSize64)
+		if newOff+size > int64(len(newAPK)) || oldOff+size > int64(len(oldAPK)) ||
+			!bytes.Equal(newAPK[newOff:newOff+size], oldAPK[oldOff:oldOff+size]) {
+			continue
+		}
+		regions = append(regions, region{newOff, oldOff, size})
+	}
+	slices.SortFunc(regions, func(a, b region) int { return cmp.Compare(a.newOff, b.newOff) })
+
+	h := header{OldSize: uint64(len(oldAPK)), OldHash: sha256.Sum256(oldAPK), NewSize: uint64(len(newAPK)), NewHash: sha256.Sum256(newAPK)}
+	out := bytes.NewBufferString(magic)
+	if err = binary.Write(out, binary.LittleEndian, &h); err != nil {
+		return nil, err
+	}
+	fw, err := flate.NewWriter(out, flate.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	var ops []byte
+	data := func(b []byte) {
+		if len(b) > 0 {
+			ops = append(ops, opData)
+			ops = binary.AppendUvarint(ops, uint64(len(b)))
+			if _, err = fw.Write(ops); err == nil {
+				_, err = fw.Write(b)
+			}
+			ops = ops[:0]
+		}
+	}
+	pos := int64(0)
+	for i := 0; i < len(regions); i++ {
+		r := regions[i]
+		if r.newOff < pos {
+			continue // 条目数据重叠的畸形 zip, 按字面数据处理
+		}
+		// 合并新旧文件中都相邻的区间
+		for i+1 < len(regions) && regions[i+1].newOff == r.newOff+r.size && regions[i+1].oldOff == r.oldOff+r.size {
+			r.size += regions[i+1].size
+			i++
+		}
+		if data(newAPK[pos:r.newOff]); err != nil {
+			return nil, err
+		}
+		ops = append(ops, opCopy)
+		ops = binary.AppendUvarint(ops, uint64(r.oldOff))
+		ops = binary.AppendUvarint(ops, uint64(r.size))
+		pos = r.newOff + r.size
+		if _, err = fw.Write(ops); err != nil {
+			return nil, err
+		}
+		ops = ops[:0]
+	}
+	if data(newAPK[pos:]); err != nil {
+		return nil, err
+	}
+	ops = append(ops, opEnd)
+	if _, err = fw.Write(ops); err != nil {
+		return nil, err
+	}
+	if err = fw.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// Apply rebuilds the new APK from oldAPK and a patch made by Diff. The old APK must be the one
+// the patch was made from, and the result is checked against the digest recorded in the patch.
+func Apply(oldAPK, patch []byte) ([]byte, error) {
+	rest, ok := bytes.CutPrefix(patch, []byte(magic))
+	if !ok {
+		return nil, ErrBadPatch
+	}
+	var h header
+	r := bytes.NewReader(rest)
+	if err := binary.Read(r, binary.LittleEndian, &h); err != nil {
+		return nil, ErrBadPatch
+	}
+	if h.OldSize != uint64(len(oldAPK)) || h.OldHash != sha256.Sum256(oldAPK) {
+		return nil, ErrWrongBase
+	}
+	// deflate 最多压缩约 1032 倍, 超出的大小必然是伪造的
+	if h.NewSize > uint64(len(oldAPK))+uint64(len(patch))*1032 {
+		return nil, ErrBadPatch
+	}
+	br := bufio.NewReader(flate.NewReader(r))
+	out := make([]byte, 0, h.NewSize)
+	for {
+		op, err := br.ReadByte()
+		if err != nil {
+			return nil, ErrBadPatch
+		}
+		switch op {
+		case opEnd:
+			if sha256.Sum256(out) != h.NewHash {
+				return nil, ErrMismatch
+			}
+			return out, nil
+		case opCopy:
+			off, err1 := binary.ReadUvarint(br)
+			size, err2 := binary.ReadUvarint(br)
+			if err1 != nil || err2 != nil || off > uint64(len(oldAPK)) || size > uint64(len(oldAPK))-off ||
+				uint64(len(out))+size > h.NewSize {
+				return nil, ErrBadPatch
+			}
+			out = append(out, oldAPK[off:off+size]...)
+		case opData:
+			size, err := binary.ReadUvarint(br)
+			if err != nil || uint64(len(out))+size > h.NewSize {
+				return nil, ErrBadPatch
+			}
+			n := len(out)
+			out = append(out, make([]byte, size)...)
+			if _, err = io.ReadFull(br, out[n:]); err != nil {
+				return nil, ErrBadPatch
+			}
+		default:
+			return nil, ErrBadPatch
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package delta
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/pzx521521/apk-editor/editor/apktest"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+)
+
+func TestDiffApply(t *testing.T) {
+	random := func(seed int64, n int) []byte {
+		b := make([]byte, n)
+		rand.New(rand.NewSource(seed)).Read(b)
+		return b
+	}
+	files := map[string][]byte{
+		"assets/video.bin":         random(1, 1<<20),
+		"assets/level.bin":         random(2, 256<<10),
+		"lib/arm64-v8a/libgame.so": random(3, 512<<10),
+	}
+	oldAPK, err := apktest.BuildSigned(apktest.Config{VersionCode: 1, Files: files})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files["assets/level.bin"] = random(4, 256<<10)
+	newAPK, err := apktest.BuildSigned(apktest.Config{VersionCode: 2, Files: files})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patch, err := Diff(oldAPK, newAPK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 只有修改的 level.bin 需要随补丁发送
+	if len(patch) > 300<<10 {
+		t.Errorf("patch of %d bytes for a %d byte apk", len(patch), len(newAPK))
+	}
+	got, err := Apply(oldAPK, patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, newAPK) {
+		t.Fatal("rebuilt apk differs")
+	}
+	z, err := signv2.NewApkSign(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = z.VerifyV2(); err != nil {
+		t.Error(err)
+	}
+
+	if _, err = Apply(newAPK, patch); !errors.Is(err, ErrWrongBase) {
+		t.Errorf("Apply to the wrong base = %v", err)
+	}
+	bad := bytes.Clone(patch)
+	bad[len(bad)/2] ^= 0xff
+	if _, err = Apply(oldAPK, bad); err == nil {
+		t.Error("Apply accepted a corrupted patch")
+	}
+}
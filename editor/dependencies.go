@@ -0,0 +1,116 @@
+package editor
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+// Dependency 从 apk 中推断出的一个打包进来的库
+type Dependency struct {
+	Name    string `json:"name"`              // maven 坐标 group:artifact, 无法确定 group 时只有 artifact
+	Version string `json:"version,omitempty"` // kotlin_module 不带版本
+	Source  string `json:"source"`            // 推断依据的 zip 条目
+}
+
+// Dependencies 根据构建工具留在 apk 中的元数据尽力列出依赖的 SDK 及版本:
+//
+//	META-INF/<group>_<artifact>.version       androidx 等库的版本文件
+//	META-INF/maven/<group>/<artifact>/pom.properties
+//	<name>.properties                          play-services-* 及 firebase-*
+//	META-INF/*.kotlin_module                   kotlin 模块, 无版本
+//	META-INF/com/android/build/gradle/app-metadata.properties  AGP 版本
+//
+// 经过 R8 或手动清理的 apk 中这些文件可能不存在, 结果不保证完整.
+func Dependencies(apk []byte) (_ []*Dependency, err error) {
+	defer catch(&err, "Dependencies", "")
+	r, err := zip.NewReader(bytes.NewReader(apk), int64(len(apk)))
+	if err != nil {
+		return nil, err
+	}
+	var deps []*Dependency
+	for _, f := range r.File {
+		name := f.Name
+		switch {
+		case strings.HasPrefix(name, "META-INF/") && strings.HasSuffix(name, ".version") && !strings.Contains(name[9:], "/"):
+			b, err := readEntry(f)
+			if err != nil {
+				return nil, err
+			}
+			coord := strings.TrimSuffix(name[9:], ".version")
+			if group, artifact, ok := strings.Cut(coord, "_"); ok {
+				coord = group + ":" + artifact
+			}
+			deps = append(deps, &Dependency{coord, strings.TrimSpace(string(b)), name})
+		case strings.HasPrefix(name, "META-INF/maven/") && strings.HasSuffix(name, "/pom.properties"):
+			props, err := readProperties(f)
+			if err != nil {
+				return nil, err
+			}
+			deps = append(deps, &Dependency{props["groupId"] + ":" + props["artifactId"], props["version"], name})
+		case !strings.Contains(name, "/") && strings.HasSuffix(name, ".properties") &&
+			(strings.HasPrefix(name, "play-services-") || strings.HasPrefix(name, "firebase-")):
+			props, err := readProperties(f)
+			if err != nil {
+				return nil, err
+			}
+			client := props["client"]
+			if client == "" {
+				client = strings.TrimSuffix(name, ".properties")
+			}
+			group := "com.google.android.gms"
+			if strings.HasPrefix(client, "firebase-") {
+				group = "com.google.firebase"
+			}
+			deps = append(deps, &Dependency{group + ":" + client, props["version"], name})
+		case strings.HasPrefix(name, "META-INF/") && strings.HasSuffix(name, ".kotlin_module"):
+			deps = append(deps, &Dependency{strings.TrimSuffix(path.Base(name), ".kotlin_module"), "", name})
+		case name == "META-INF/com/android/build/gradle/app-metadata.properties":
+			props, err := readProperties(f)
+			if err != nil {
+				return nil, err
+			}
+			if v := props["androidGradlePluginVersion"]; v != "" {
+				deps = append(deps, &Dependency{"com.android.tools.build:gradle", v, name})
+			}
+		}
+	}
+	sort.SliceStable(deps, func(i, j int) bool { return deps[i].Name < deps[j].Name })
+	return deps, nil
+}
+
+func readEntry(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// readProperties 解析 java .properties 中简单的 key=value 行
+func readProperties(f *zip.File) (map[string]string, error) {
+	b, err := readEntry(f)
+	if err != nil {
+		return nil, err
+	}
+	props := map[string]string{}
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' || line[0] == '!' {
+			continue
+		}
+		if k, v, ok := strings.Cut(line, "="); ok {
+			props[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		} else if k, v, ok := strings.Cut(line, ":"); ok {
+			props[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	return props, nil
+}
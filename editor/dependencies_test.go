@@ -0,0 +1,27 @@
+package editor
+
+import (
+	"os"
+	"testing"
+)
+
+func TestDependencies(t *testing.T) {
+	apk, err := os.ReadFile("../release/app-release.apk")
+	if err != nil {
+		t.Skip(err)
+	}
+	deps, err := Dependencies(apk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := map[string]string{}
+	for _, d := range deps {
+		found[d.Name] = d.Version
+	}
+	if v, ok := found["androidx.core:core"]; !ok || v == "" {
+		t.Errorf("androidx.core:core = %q, %v", v, ok)
+	}
+	if v := found["com.android.tools.build:gradle"]; v != "7.1.3" {
+		t.Errorf("AGP version = %q", v)
+	}
+}
@@ -0,0 +1,170 @@
+// Package device drives a connected Android device through adb, closing the
+// edit -> sign -> install loop of the editor.
+package device
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/pzx521521/apk-editor/editor/splits"
+)
+
+// Adb wraps the adb binary. The zero value runs "adb" from PATH against the
+// only connected device.
+type Adb struct {
+	Path   string // adb 可执行文件路径, 为空时从 PATH 中查找
+	Serial string // 目标设备序列号, 对应 adb -s
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// InstallOptions mirrors the most common `adb install` switches.
+type InstallOptions struct {
+	Reinstall   bool // -r 覆盖安装
+	Downgrade   bool // -d 允许降级
+	GrantAll    bool // -g 授予所有运行时权限
+	Incremental bool // --incremental, 需要 apk 同目录下存在 .idsig 文件
+}
+
+// Install installs one APK, or a base APK and its splits via install-multiple.
+// With Incremental set every APK must have its v4 signature next to it
+// (app.apk -> app.apk.idsig), which is where adb looks for it.
+func (a *Adb) Install(opts InstallOptions, apks ...string) error {
+	if len(apks) == 0 {
+		return errors.New("no apk to install")
+	}
+	for _, apk := range apks {
+		if _, err := os.Stat(apk); err != nil {
+			return err
+		}
+		if opts.Incremental {
+			if _, err := os.Stat(apk + ".idsig"); err != nil {
+				return fmt.Errorf("incremental install needs %s.idsig: %w", apk, err)
+			}
+		}
+	}
+	args := []string{"install"}
+	if len(apks) > 1 {
+		args[0] = "install-multiple"
+	}
+	if opts.Reinstall {
+		args = append(args, "-r")
+	}
+	if opts.Downgrade {
+		args = append(args, "-d")
+	}
+	if opts.GrantAll {
+		args = append(args, "-g")
+	}
+	if opts.Incremental {
+		args = append(args, "--incremental")
+	}
+	return a.Run(append(args, apks...)...)
+}
+
+// Run executes an arbitrary adb command against the selected device.
+func (a *Adb) Run(args ...string) error {
+	cmd := a.command(args...)
+	cmd.Stdout = a.Stdout
+	cmd.Stderr = a.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("adb %s: %w", args[0], err)
+	}
+	return nil
+}
+
+// Output executes an adb command and returns its standard output.
+func (a *Adb) Output(args ...string) ([]byte, error) {
+	cmd := a.command(args...)
+	cmd.Stderr = a.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("adb %s: %w", args[0], err)
+	}
+	return out, nil
+}
+
+func (a *Adb) command(args ...string) *exec.Cmd {
+	bin := a.Path
+	if bin == "" {
+		bin = "adb"
+	}
+	if a.Serial != "" {
+		args = append([]string{"-s", a.Serial}, args...)
+	}
+	return exec.Command(bin, args...)
+}
+
+// DeviceSpec reads the ABIs, screen density, locale and API level of the
+// device, to select the splits to install on it.
+func (a *Adb) DeviceSpec() (*splits.DeviceSpec, error) {
+	prop := func(name string) (string, error) {
+		out, err := a.Output("shell", "getprop", name)
+		return strings.TrimSpace(string(out)), err
+	}
+	spec := &splits.DeviceSpec{}
+	abis, err := prop("ro.product.cpu.abilist")
+	if err != nil {
+		return nil, err
+	}
+	if abis == "" {
+		// Android 4.x 只有单个 ABI
+		if abis, err = prop("ro.product.cpu.abi"); err != nil {
+			return nil, err
+		}
+	}
+	if abis != "" {
+		spec.ABIs = strings.Split(abis, ",")
+	}
+	sdk, err := prop("ro.build.version.sdk")
+	if err != nil {
+		return nil, err
+	}
+	if spec.SDK, err = strconv.Atoi(sdk); err != nil {
+		return nil, fmt.Errorf("ro.build.version.sdk %q: %w", sdk, err)
+	}
+	locale, err := prop("persist.sys.locale")
+	if err == nil && locale == "" {
+		locale, err = prop("ro.product.locale")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if locale != "" {
+		spec.Locales = []string{locale}
+	}
+	density, err := a.Output("shell", "wm", "density")
+	if err != nil {
+		return nil, err
+	}
+	spec.Density = parseDensity(string(density))
+	return spec, nil
+}
+
+// parseDensity parses the output of `wm density`, preferring the override
+// density the user may have set over the physical one:
+//
+//	Physical density: 420
+//	Override density: 480
+func parseDensity(out string) int {
+	density := 0
+	for _, line := range strings.Split(out, "\n") {
+		label, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		d, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+		if strings.Contains(label, "Override") || density == 0 {
+			density = d
+		}
+	}
+	return density
+}
@@ -0,0 +1,327 @@
+package device
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/pzx521521/apk-editor/editor/signv2"
+)
+
+// Incremental installation data loader protocol, as served by adb for
+// `adb install --incremental`: all integers are big endian, the device sends
+// 12-byte requests starting with "INCR", the host answers with chunks, each an
+// int32 size followed by blocks, each a 10-byte header and its data.
+const (
+	incrBlockSize   = 4096
+	incrHashSize    = 32
+	incrRequestSize = 12
+	incrChunkSize   = 32 << 10 // 一个 chunk 中数据的大致上限
+)
+
+// Request types sent by the device.
+const (
+	incrServingComplete = 0
+	incrBlockMissing    = 1
+	incrPrefetch        = 2
+	incrDestroy         = 3
+)
+
+// Block types of a response.
+const (
+	incrBlockData = 0
+	incrBlockTree = 1
+)
+
+// incrRequest is one request of the device.
+type incrRequest struct {
+	typ    int16
+	fileID int16
+	block  int32
+}
+
+// IncrementalServer serves the blocks of APKs being installed incrementally:
+// the device asks for the blocks it lacks as the app reads them, and each
+// data block is preceded by the Merkle tree blocks needed to verify it, the
+// same tree the v4 signature (.idsig) signs.
+//
+// The file IDs of the requests are the indexes of the APKs given to
+// NewIncrementalServer, in the order the install session lists them.
+type IncrementalServer struct {
+	Logf  func(format string, args ...any) // 记录忽略的请求等, 可为空
+	files []*incrFile
+}
+
+// incrFile is an APK being served and what has been sent of it.
+type incrFile struct {
+	data       []byte
+	tree       []byte
+	levels     []int // 每层在 tree 中的起始块, 顶层在前
+	sentData   []bool
+	sentTree   []bool
+	prefetched int // 预取的下一个数据块, 不预取时为 -1
+}
+
+// NewIncrementalServer returns a server for apks, computing the Merkle tree
+// of each.
+func NewIncrementalServer(apks ...[]byte) *IncrementalServer {
+	s := &IncrementalServer{}
+	for _, apk := range apks {
+		_, tree := signv2.MerkleTree(apk)
+		n := (len(apk) + incrBlockSize - 1) / incrBlockSize
+		s.files = append(s.files, &incrFile{
+			data:       apk,
+			tree:       tree,
+			levels:     treeLevels(n),
+			sentData:   make([]bool, n),
+			sentTree:   make([]bool, len(tree)/incrBlockSize),
+			prefetched: -1,
+		})
+	}
+	return s
+}
+
+// treeLevels returns the first block of each level of the Merkle tree of n
+// data blocks, top level first, as signv2.MerkleTree lays them out.
+func treeLevels(n int) []int {
+	var counts []int
+	for hashes := n; hashes > 0; {
+		blocks := (hashes*incrHashSize + incrBlockSize - 1) / incrBlockSize
+		counts = append(counts, blocks)
+		if hashes*incrHashSize <= incrBlockSize {
+			break
+		}
+		hashes = blocks
+	}
+	levels := make([]int, len(counts))
+	start := 0
+	for i := range counts {
+		levels[i] = start
+		start += counts[len(counts)-1-i]
+	}
+	return levels
+}
+
+// Serve answers the requests of the device on rw until it reports the
+// installation complete, it closes the stream, or ctx is done. Missing blocks
+// are sent as they are requested; prefetched files are streamed in between.
+// Serve does not close rw, so a read that is blocked when it returns ends
+// only when the caller closes it.
+func (s *IncrementalServer) Serve(ctx context.Context, rw io.ReadWriter) error {
+	if _, err := io.WriteString(rw, "OKAY"); err != nil {
+		return err
+	}
+	type result struct {
+		req incrRequest
+		err error
+	}
+	reqs := make(chan result)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		br := bufio.NewReader(rw)
+		for {
+			req, err := readIncrRequest(br)
+			select {
+			case reqs <- result{req, err}:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	w := &incrWriter{w: rw}
+	for {
+		var r result
+		if s.prefetching() {
+			select {
+			case r = <-reqs:
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+				if err := s.prefetch(w); err != nil {
+					return err
+				}
+				continue
+			}
+		} else {
+			select {
+			case r = <-reqs:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if r.err == io.EOF {
+			return nil
+		}
+		if r.err != nil {
+			return r.err
+		}
+		switch r.req.typ {
+		case incrServingComplete:
+			w.header(-1, 0, 0, 0)
+			return w.flush()
+		case incrDestroy:
+			return nil
+		}
+		f, err := s.file(r.req)
+		if err != nil {
+			s.logf("ignoring request: %v", err)
+			continue
+		}
+		switch r.req.typ {
+		case incrBlockMissing:
+			f.sentData[r.req.block] = false
+			if err = s.send(w, r.req.fileID, f, int(r.req.block)); err == nil {
+				err = w.flush()
+			}
+		case incrPrefetch:
+			f.prefetched = int(r.req.block)
+		default:
+			s.logf("ignoring request of type %d", r.req.typ)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// file returns the file a request refers to and checks its block index.
+func (s *IncrementalServer) file(req incrRequest) (*incrFile, error) {
+	if req.fileID < 0 || int(req.fileID) >= len(s.files) {
+		return nil, fmt.Errorf("unknown file %d", req.fileID)
+	}
+	f := s.files[req.fileID]
+	if req.block < 0 || int(req.block) >= len(f.sentData) {
+		return nil, fmt.Errorf("file %d has no block %d", req.fileID, req.block)
+	}
+	return f, nil
+}
+
+func (s *IncrementalServer) logf(format string, args ...any) {
+	if s.Logf != nil {
+		s.Logf(format, args...)
+	}
+}
+
+// prefetching reports whether a file is being prefetched.
+func (s *IncrementalServer) prefetching() bool {
+	for _, f := range s.files {
+		if f.prefetched >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// prefetch sends one chunk of the first file being prefetched.
+func (s *IncrementalServer) prefetch(w *incrWriter) error {
+	for id, f := range s.files {
+		for f.prefetched >= 0 && w.size() < incrChunkSize {
+			if err := s.send(w, int16(id), f, f.prefetched); err != nil {
+				return err
+			}
+			if f.prefetched++; f.prefetched == len(f.sentData) {
+				f.prefetched = -1
+			}
+		}
+		if w.size() > 0 {
+			return w.flush()
+		}
+	}
+	return nil
+}
+
+// send queues data block i of f unless it was sent already, preceded by the
+// tree blocks on its path to the root that were not.
+func (s *IncrementalServer) send(w *incrWriter, id int16, f *incrFile, i int) error {
+	if f.sentData[i] {
+		return nil
+	}
+	idx := i
+	path := make([]int, len(f.levels))
+	for l := len(f.levels) - 1; l >= 0; l-- {
+		idx /= incrBlockSize / incrHashSize
+		path[l] = f.levels[l] + idx
+	}
+	for _, b := range path {
+		if !f.sentTree[b] {
+			f.sentTree[b] = true
+			w.block(id, incrBlockTree, b, f.tree[b*incrBlockSize:(b+1)*incrBlockSize])
+		}
+	}
+	f.sentData[i] = true
+	w.block(id, incrBlockData, i, f.data[i*incrBlockSize:min(len(f.data), (i+1)*incrBlockSize)])
+	if w.size() >= incrChunkSize {
+		return w.flush()
+	}
+	return nil
+}
+
+// readIncrRequest reads the next request, skipping anything before its
+// "INCR" magic, such as messages the device prints.
+func readIncrRequest(r *bufio.Reader) (incrRequest, error) {
+	const magic = "INCR"
+	for matched := 0; matched < len(magic); {
+		c, err := r.ReadByte()
+		if err != nil {
+			return incrRequest{}, err
+		}
+		switch {
+		case c == magic[matched]:
+			matched++
+		case c == magic[0]:
+			matched = 1
+		default:
+			matched = 0
+		}
+	}
+	var b [incrRequestSize - 4]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return incrRequest{}, err
+	}
+	be := binary.BigEndian
+	return incrRequest{int16(be.Uint16(b[0:])), int16(be.Uint16(b[2:])), int32(be.Uint32(b[4:]))}, nil
+}
+
+// incrWriter collects response blocks into a chunk.
+type incrWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+func (w *incrWriter) size() int {
+	return len(w.buf)
+}
+
+func (w *incrWriter) header(id int16, typ uint8, index, size int) {
+	be := binary.BigEndian
+	w.buf = be.AppendUint16(w.buf, uint16(id))
+	w.buf = append(w.buf, typ, 0) // 不压缩
+	w.buf = be.AppendUint32(w.buf, uint32(index))
+	w.buf = be.AppendUint16(w.buf, uint16(size))
+}
+
+func (w *incrWriter) block(id int16, typ uint8, index int, data []byte) {
+	w.header(id, typ, index, len(data))
+	w.buf = append(w.buf, data...)
+}
+
+// flush writes the pending blocks as one chunk.
+func (w *incrWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	chunk := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(w.buf)), uint32(len(w.buf)))
+	_, err := w.w.Write(append(chunk, w.buf...))
+	w.buf = w.buf[:0]
+	return err
+}
@@ -0,0 +1,95 @@
+package device
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"net"
+	"testing"
+
+	"github.com/pzx521521/apk-editor/editor/signv2"
+)
+
+func TestIncrementalServer(t *testing.T) {
+	apk := make([]byte, 600*incrBlockSize+100) // 两层 Merkle 树
+	rand.New(rand.NewSource(1)).Read(apk)
+	_, tree := signv2.MerkleTree(apk)
+	host, dev := net.Pipe()
+	defer dev.Close()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- NewIncrementalServer(apk).Serve(context.Background(), host)
+		host.Close()
+	}()
+
+	okay := make([]byte, 4)
+	if _, err := io.ReadFull(dev, okay); err != nil || string(okay) != "OKAY" {
+		t.Fatalf("handshake %q, %v", okay, err)
+	}
+	request := func(typ, block int) {
+		b := []byte("log line\nINCR")
+		b = binary.BigEndian.AppendUint16(b, uint16(typ))
+		b = binary.BigEndian.AppendUint16(b, 0)
+		b = binary.BigEndian.AppendUint32(b, uint32(block))
+		if _, err := dev.Write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	type block struct {
+		id    int16
+		typ   uint8
+		index int
+		data  []byte
+	}
+	readChunk := func() []block {
+		var size [4]byte
+		if _, err := io.ReadFull(dev, size[:]); err != nil {
+			t.Fatal(err)
+		}
+		chunk := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(dev, chunk); err != nil {
+			t.Fatal(err)
+		}
+		var blocks []block
+		for len(chunk) > 0 {
+			n := int(binary.BigEndian.Uint16(chunk[8:]))
+			blocks = append(blocks, block{int16(binary.BigEndian.Uint16(chunk)), chunk[2],
+				int(binary.BigEndian.Uint32(chunk[4:])), chunk[10 : 10+n]})
+			chunk = chunk[10+n:]
+		}
+		return blocks
+	}
+
+	// 数据块 300 的哈希在叶子层第 300/128 = 2 块, 该层位于根块之后
+	request(incrBlockMissing, 300)
+	blocks := readChunk()
+	if len(blocks) != 3 || blocks[0].typ != incrBlockTree || blocks[0].index != 0 ||
+		blocks[1].typ != incrBlockTree || blocks[1].index != 1+2 || blocks[2].typ != incrBlockData || blocks[2].index != 300 {
+		t.Fatalf("blocks %+v", blocks)
+	}
+	if !bytes.Equal(blocks[0].data, tree[:incrBlockSize]) {
+		t.Error("root block differs from the tree")
+	}
+	sum := sha256.Sum256(blocks[2].data)
+	if leaf := blocks[1].data[(300%128)*incrHashSize:]; !bytes.Equal(sum[:], leaf[:incrHashSize]) {
+		t.Error("data block does not match its hash")
+	}
+
+	// 已发送的树块不再重复发送, 最后一块不足 4K
+	request(incrBlockMissing, 600)
+	blocks = readChunk()
+	if len(blocks) != 2 || blocks[0].index != 1+4 || len(blocks[1].data) != 100 {
+		t.Fatalf("blocks %+v", blocks)
+	}
+
+	request(incrServingComplete, 0)
+	if blocks = readChunk(); len(blocks) != 1 || blocks[0].id != -1 {
+		t.Fatalf("done %+v", blocks)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,120 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// PackagePaths returns the paths on the device of the APKs of an installed
+// package, as `pm path` lists them: the base APK first, then its splits.
+func (a *Adb) PackagePaths(pkg string) ([]string, error) {
+	out, err := a.Output("shell", "pm", "path", pkg)
+	if err != nil {
+		return nil, err
+	}
+	paths := parsePackagePaths(string(out))
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("package %s is not installed", pkg)
+	}
+	return paths, nil
+}
+
+// parsePackagePaths parses the output of `pm path`:
+//
+//	package:/data/app/~~x==/com.example-y==/base.apk
+//	package:/data/app/~~x==/com.example-y==/split_config.arm64_v8a.apk
+func parsePackagePaths(out string) []string {
+	var paths []string
+	for _, line := range strings.Split(out, "\n") {
+		if p, ok := strings.CutPrefix(strings.TrimSpace(line), "package:"); ok && p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// Pull copies a file from the device to local.
+func (a *Adb) Pull(remote, local string) error {
+	return a.Run("pull", remote, local)
+}
+
+// Uninstall removes a package from the device.
+func (a *Adb) Uninstall(pkg string) error {
+	return a.Run("uninstall", pkg)
+}
+
+// EditFunc edits and signs one APK of a package during Repackage. name is
+// the file name of the APK on the device, e.g. base.apk or
+// split_config.xxhdpi.apk; the returned bytes are installed in its place.
+type EditFunc func(name string, apk []byte) ([]byte, error)
+
+// RepackageOptions configures Repackage.
+type RepackageOptions struct {
+	Install       InstallOptions // 安装新 apk 时的参数
+	SkipUninstall bool           // 不先卸载, 签名证书不变时可保留应用数据
+	Dir           string         // 存放拉取和修改后 apk 的目录, 为空时使用临时目录并在结束后删除
+}
+
+// Repackage pulls the APKs of an installed package, passes each through
+// edit, uninstalls the package, since a new signature cannot update it, and
+// installs the edited APKs. If that install fails, the pulled APKs are
+// installed again, so that the device is not left without the app; its data
+// is lost either way unless SkipUninstall is set.
+func (a *Adb) Repackage(pkg string, edit EditFunc, opts RepackageOptions) error {
+	remote, err := a.PackagePaths(pkg)
+	if err != nil {
+		return err
+	}
+	dir := opts.Dir
+	if dir == "" {
+		if dir, err = os.MkdirTemp("", "apkeditor-repackage"); err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+	}
+	origDir := filepath.Join(dir, "original")
+	if err = os.MkdirAll(origDir, 0755); err != nil {
+		return err
+	}
+
+	var originals, edited []string
+	for _, r := range remote {
+		name := path.Base(r)
+		orig := filepath.Join(origDir, name)
+		if err = a.Pull(r, orig); err != nil {
+			return err
+		}
+		apk, err := os.ReadFile(orig)
+		if err != nil {
+			return err
+		}
+		if apk, err = edit(name, apk); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		out := filepath.Join(dir, name)
+		if err = os.WriteFile(out, apk, 0644); err != nil {
+			return err
+		}
+		originals = append(originals, orig)
+		edited = append(edited, out)
+	}
+
+	if !opts.SkipUninstall {
+		if err = a.Uninstall(pkg); err != nil {
+			return err
+		}
+	}
+	if err = a.Install(opts.Install, edited...); err != nil {
+		restore := opts.Install
+		restore.Incremental = false
+		if rerr := a.Install(restore, originals...); rerr != nil {
+			return errors.Join(err, fmt.Errorf("restoring the original apks: %w", rerr))
+		}
+		return fmt.Errorf("%w (the original apks were installed again)", err)
+	}
+	return nil
+}
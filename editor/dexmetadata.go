@@ -0,0 +1,194 @@
+package editor
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/pzx521521/apk-editor/editor/signv2"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+// .dm 中的条目
+const (
+	dmProfile         = "primary.prof"
+	dmProfileMetadata = "primary.profm"
+	dmVdex            = "primary.vdex"
+	dmManifest        = "manifest.json"
+)
+
+// DexMetadata .dm 文件的内容, 安装时随 apk 一起提供给 ART 做预编译
+type DexMetadata struct {
+	Profile         []byte // primary.prof, baseline profile, 以 "pro\x00" 开头
+	ProfileMetadata []byte // primary.profm, 可选, 以 "prm\x00" 开头
+	Vdex            []byte // primary.vdex, 可选, 以 "vdex" 开头
+}
+
+// dmManifestJSON .dm 中的 manifest.json, Android 14 起安装时检查与 apk 一致
+type dmManifestJSON struct {
+	PackageName string `json:"packageName"`
+	VersionCode int64  `json:"versionCode"`
+}
+
+// DexMetadataName 返回与 apk 配对的 .dm 文件名, 如 base.apk -> base.dm, 安装时两者须同名
+func DexMetadataName(apkName string) string {
+	return strings.TrimSuffix(apkName, path.Ext(apkName)) + ".dm"
+}
+
+// BuildDexMetadata 为 apk 生成 .dm 文件, 写入 manifest.json 记录 apk 的包名和 versionCode.
+// keys 不为空时用其签名 (v2), 系统要求 .dm 与 apk 的签名证书相同
+func BuildDexMetadata(apk []byte, dm DexMetadata, keys []*signv2.SigningCert) (_ []byte, err error) {
+	defer catch(&err, "BuildDexMetadata", "")
+	if err = dm.check(); err != nil {
+		return nil, err
+	}
+	pkg, versionCode, err := packageVersion(apk)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := json.Marshal(dmManifestJSON{pkg, int64(versionCode)})
+	if err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	for _, e := range []struct {
+		name string
+		data []byte
+	}{{dmProfile, dm.Profile}, {dmProfileMetadata, dm.ProfileMetadata}, {dmVdex, dm.Vdex}, {dmManifest, manifest}} {
+		if e.data == nil {
+			continue
+		}
+		fw, err := w.CreateHeader(&zip.FileHeader{Name: e.name, Method: zip.Deflate})
+		if err != nil {
+			return nil, err
+		}
+		if _, err = fw.Write(e.data); err != nil {
+			return nil, err
+		}
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return buf.Bytes(), nil
+	}
+	z, err := signv2.NewApkSignNoCopy(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return z.SignV2(keys)
+}
+
+// check 检查各文件的格式标识, profile 必须存在
+func (dm DexMetadata) check() error {
+	if dm.Profile == nil {
+		return errors.New(".dm needs a " + dmProfile)
+	}
+	for _, f := range []struct {
+		name  string
+		data  []byte
+		magic string
+	}{{dmProfile, dm.Profile, "pro\x00"}, {dmProfileMetadata, dm.ProfileMetadata, "prm\x00"}, {dmVdex, dm.Vdex, "vdex"}} {
+		if f.data != nil && !bytes.HasPrefix(f.data, []byte(f.magic)) {
+			return fmt.Errorf("%s does not start with %q", f.name, f.magic)
+		}
+	}
+	return nil
+}
+
+// CheckDexMetadata 按安装时的规则检查 .dm 与 apk 是否匹配:
+//   - 只含 primary.prof, primary.profm, primary.vdex, manifest.json, 且格式标识正确
+//   - 有 manifest.json 时包名和 versionCode 与 apk 相同
+//   - 已签名时签名有效, 且与 apk 的签名证书相同
+func CheckDexMetadata(apk, dmFile []byte) (err error) {
+	defer catch(&err, "CheckDexMetadata", "")
+	r, err := zip.NewReader(bytes.NewReader(dmFile), int64(len(dmFile)))
+	if err != nil {
+		return err
+	}
+	var dm DexMetadata
+	var manifest []byte
+	for _, f := range r.File {
+		var dst *[]byte
+		switch f.Name {
+		case dmProfile:
+			dst = &dm.Profile
+		case dmProfileMetadata:
+			dst = &dm.ProfileMetadata
+		case dmVdex:
+			dst = &dm.Vdex
+		case dmManifest:
+			dst = &manifest
+		default:
+			return fmt.Errorf("unexpected entry %s in .dm", f.Name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		*dst, err = io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return entryError(f.Name, err)
+		}
+	}
+	if err = dm.check(); err != nil {
+		return err
+	}
+	if manifest != nil {
+		var m dmManifestJSON
+		if err = json.Unmarshal(manifest, &m); err != nil {
+			return entryError(dmManifest, err)
+		}
+		pkg, versionCode, err := packageVersion(apk)
+		if err != nil {
+			return err
+		}
+		if m.PackageName != pkg || m.VersionCode != int64(versionCode) {
+			return fmt.Errorf(".dm is for %s version %d, apk is %s version %d", m.PackageName, m.VersionCode, pkg, versionCode)
+		}
+	}
+
+	dz, err := signv2.NewApkSign(dmFile)
+	if err != nil {
+		return err
+	}
+	if parsed := dz.ParseResult(); !parsed.HasScheme(signv2.SchemeV2) && !parsed.HasScheme(signv2.SchemeV3) {
+		return nil
+	}
+	dmCert, err := signerCert(dz)
+	if err != nil {
+		return fmt.Errorf(".dm signature: %w", err)
+	}
+	az, err := signv2.NewApkSign(apk)
+	if err != nil {
+		return err
+	}
+	apkCert, err := signerCert(az)
+	if err != nil {
+		return fmt.Errorf("apk signature: %w", err)
+	}
+	if !bytes.Equal(dmCert, apkCert) {
+		return errors.New(".dm is signed with a different certificate than the apk")
+	}
+	return nil
+}
+
+// signerCert 验证签名并返回第一个签名者的证书 (DER)
+func signerCert(z *signv2.ApkSign) ([]byte, error) {
+	r := z.VerifyAll(signv2.VerifyOptions{})
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
+	for _, s := range r.Signers {
+		if len(s.Certs) > 0 {
+			return s.Certs[0].Raw, nil
+		}
+	}
+	return nil, errors.New("no signer")
+}
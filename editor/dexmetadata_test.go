@@ -0,0 +1,61 @@
+package editor
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/pzx521521/apk-editor/editor/apktest"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+func TestDexMetadata(t *testing.T) {
+	apk, err := apktest.BuildSigned(apktest.Config{VersionCode: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := apktest.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prof := DexMetadata{Profile: []byte("pro\x00010\x00profile"), ProfileMetadata: []byte("prm\x00002\x00")}
+	dm, err := BuildDexMetadata(apk, prof, []*signv2.SigningCert{key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = CheckDexMetadata(apk, dm); err != nil {
+		t.Fatal(err)
+	}
+	if name := DexMetadataName("splits/base.apk"); name != "splits/base.dm" {
+		t.Errorf("DexMetadataName = %q", name)
+	}
+
+	other, err := apktest.BuildSigned(apktest.Config{VersionCode: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = CheckDexMetadata(other, dm); err == nil || !strings.Contains(err.Error(), "version") {
+		t.Errorf("CheckDexMetadata for another version = %v", err)
+	}
+	unsigned, err := BuildDexMetadata(apk, prof, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = CheckDexMetadata(apk, unsigned); err != nil {
+		t.Errorf("unsigned .dm: %v", err)
+	}
+	if _, err = BuildDexMetadata(apk, DexMetadata{Profile: []byte("not a profile")}, nil); err == nil {
+		t.Error("accepted a profile without magic")
+	}
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	for _, name := range []string{"primary.prof", "classes.dex"} {
+		fw, _ := w.Create(name)
+		fw.Write([]byte("pro\x00"))
+	}
+	w.Close()
+	if err = CheckDexMetadata(apk, buf.Bytes()); err == nil {
+		t.Error("accepted a .dm with a dex")
+	}
+}
@@ -0,0 +1,316 @@
+// Package diff compares two APKs, as done when checking a re-signed or rebuilt build against the
+// original: which entries were added, removed or changed, how their compression changed, which
+// AndroidManifest.xml attributes differ, and whether both are signed by the same certificates.
+package diff
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"path"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pzx521521/apk-editor/editor/axml"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+// Report lists how APK b differs from APK a.
+type Report struct {
+	// Added are entries only b has, Removed those only a has. Signature files are left out, as the
+	// signers are compared instead.
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+	// Changed are the entries whose content or compression differ, in the order of a.
+	Changed []*EntryDiff `json:"changed,omitempty"`
+	// Manifest are the differences between the decoded AndroidManifest.xml files.
+	Manifest []*AttrDiff `json:"manifest,omitempty"`
+	// SignersA and SignersB are the SHA-256 fingerprints of the signer certificates of the newest
+	// signature scheme that verifies, as printed by apksigner verify --print-certs. They are empty
+	// for an APK without a valid signature.
+	SignersA []string `json:"signers_a,omitempty"`
+	SignersB []string `json:"signers_b,omitempty"`
+	// SameSigner is true when both APKs are signed by the same set of certificates.
+	SameSigner bool `json:"same_signer"`
+}
+
+// Equal reports whether the APKs have the same content, manifest and signers; two unsigned APKs
+// have the same signers. Alignment, entry order and signature files are not compared.
+func (r *Report) Equal() bool {
+	return len(r.Added)+len(r.Removed)+len(r.Changed)+len(r.Manifest) == 0 && slices.Equal(r.SignersA, r.SignersB)
+}
+
+// EntryDiff describes an entry present in both APKs whose content or compression differ.
+type EntryDiff struct {
+	Name string `json:"name"`
+	// Content is set when the uncompressed data differs.
+	Content bool `json:"content,omitempty"`
+	// Compression is set when the compression method differs, or when the same data is compressed
+	// to a different size, as by another deflate level.
+	Compression bool `json:"compression,omitempty"`
+	// MethodA and MethodB are the compression methods, zip.Store or zip.Deflate.
+	MethodA uint16 `json:"method_a"`
+	MethodB uint16 `json:"method_b"`
+	// SizeA and SizeB are the uncompressed sizes, CompressedA and CompressedB the stored ones.
+	SizeA       uint64 `json:"size_a"`
+	SizeB       uint64 `json:"size_b"`
+	CompressedA uint64 `json:"compressed_a"`
+	CompressedB uint64 `json:"compressed_b"`
+}
+
+// AttrDiff is a manifest attribute whose value differs. Element is the path of its element, as
+// "manifest/application/activity[.MainActivity]": elements with an android:name are identified by
+// it, others by their position among their siblings of the same name, as "meta-data#1". A or B is
+// empty when the attribute, or its element, is missing from that manifest.
+type AttrDiff struct {
+	Element string `json:"element"`
+	Attr    string `json:"attr"`
+	A       string `json:"a"`
+	B       string `json:"b"`
+}
+
+func (d *AttrDiff) String() string {
+	return fmt.Sprintf("%s@%s: %q -> %q", d.Element, d.Attr, d.A, d.B)
+}
+
+// CompareApks compares APK a with APK b. Entries with the same CRC-32 and size are still compared
+// byte for byte, so that a crafted collision does not go unnoticed. An APK without
+// AndroidManifest.xml is compared as if its manifest were empty.
+func CompareApks(a, b []byte) (_ *Report, err error) {
+	defer catch(&err, "CompareApks")
+	za, err := zip.NewReader(bytes.NewReader(a), int64(len(a)))
+	if err != nil {
+		return nil, fmt.Errorf("a: %w", err)
+	}
+	zb, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return nil, fmt.Errorf("b: %w", err)
+	}
+	r := &Report{}
+	if err = r.compareEntries(za, zb); err != nil {
+		return nil, err
+	}
+	if err = r.compareManifests(za, zb); err != nil {
+		return nil, err
+	}
+	r.SignersA, r.SignersB = signers(a), signers(b)
+	r.SameSigner = len(r.SignersA) > 0 && slices.Equal(r.SignersA, r.SignersB)
+	return r, nil
+}
+
+func (r *Report) compareEntries(za, zb *zip.Reader) error {
+	files := map[string]*zip.File{}
+	for _, f := range zb.File {
+		files[f.Name] = f
+	}
+	seen := map[string]bool{}
+	for _, fa := range za.File {
+		if skip(fa.Name) {
+			continue
+		}
+		seen[fa.Name] = true
+		fb := files[fa.Name]
+		if fb == nil {
+			r.Removed = append(r.Removed, fa.Name)
+			continue
+		}
+		d := &EntryDiff{
+			Name: fa.Name, MethodA: fa.Method, MethodB: fb.Method,
+			SizeA: fa.UncompressedSize64, SizeB: fb.UncompressedSize64,
+			CompressedA: fa.CompressedSize64, CompressedB: fb.CompressedSize64,
+		}
+		d.Content = fa.CRC32 != fb.CRC32 || d.SizeA != d.SizeB
+		if !d.Content {
+			ha, err := contentHash(fa)
+			if err != nil {
+				return entryError("a", fa.Name, err)
+			}
+			hb, err := contentHash(fb)
+			if err != nil {
+				return entryError("b", fb.Name, err)
+			}
+			d.Content = ha != hb
+		}
+		d.Compression = d.MethodA != d.MethodB || !d.Content && d.CompressedA != d.CompressedB
+		if d.Content || d.Compression {
+			r.Changed = append(r.Changed, d)
+		}
+	}
+	for _, fb := range zb.File {
+		if !seen[fb.Name] && !skip(fb.Name) {
+			seen[fb.Name] = true
+			r.Added = append(r.Added, fb.Name)
+		}
+	}
+	return nil
+}
+
+// compareManifests lists the attributes that differ between the flattened manifests, ordered by
+// element and attribute.
+func (r *Report) compareManifests(za, zb *zip.Reader) error {
+	ma, err := manifestAttrs(za)
+	if err != nil {
+		return fmt.Errorf("a: %w", err)
+	}
+	mb, err := manifestAttrs(zb)
+	if err != nil {
+		return fmt.Errorf("b: %w", err)
+	}
+	keys := map[attrKey]bool{}
+	for k := range ma {
+		keys[k] = true
+	}
+	for k := range mb {
+		keys[k] = true
+	}
+	for k := range keys {
+		if ma[k] != mb[k] {
+			r.Manifest = append(r.Manifest, &AttrDiff{k.element, k.attr, ma[k], mb[k]})
+		}
+	}
+	slices.SortFunc(r.Manifest, func(x, y *AttrDiff) int {
+		return strings.Compare(x.Element+"@"+x.Attr, y.Element+"@"+y.Attr)
+	})
+	return nil
+}
+
+type attrKey struct{ element, attr string }
+
+// manifestAttrs decodes AndroidManifest.xml and maps each attribute of each element to its value.
+func manifestAttrs(z *zip.Reader) (map[attrKey]string, error) {
+	attrs := map[attrKey]string{}
+	i := slices.IndexFunc(z.File, func(f *zip.File) bool { return f.Name == zip.ANDROIDMANIFEST })
+	if i < 0 {
+		return attrs, nil
+	}
+	b, err := readFile(z.File[i])
+	if err != nil {
+		return nil, entryError("", zip.ANDROIDMANIFEST, err)
+	}
+	root, err := axml.Parse(b)
+	if err != nil {
+		return nil, entryError("", zip.ANDROIDMANIFEST, err)
+	}
+	var walk func(e *axml.Element, p string)
+	walk = func(e *axml.Element, p string) {
+		for _, a := range e.Attrs {
+			attrs[attrKey{p, attrName(a)}] = a.Value()
+		}
+		count := map[string]int{}
+		for _, c := range e.Children {
+			if c.Name == "" {
+				continue
+			}
+			name := c.Name
+			if id := c.AttrValue(axml.NSAndroid, "name"); id != "" {
+				name += "[" + id + "]"
+			} else {
+				name += "#" + strconv.Itoa(count[c.Name])
+				count[c.Name]++
+			}
+			walk(c, p+"/"+name)
+		}
+	}
+	walk(root, root.Name)
+	return attrs, nil
+}
+
+// attrName prefixes the name of an attribute in the android namespace with android:.
+func attrName(a *axml.Attr) string {
+	switch a.NS {
+	case "":
+		return a.Name
+	case axml.NSAndroid:
+		return "android:" + a.Name
+	}
+	return a.NS + ":" + a.Name
+}
+
+// signers returns the sorted certificate fingerprints of the signers of the newest verified scheme.
+func signers(apk []byte) []string {
+	z, err := signv2.NewApkSignNoCopy(apk)
+	if err != nil {
+		return nil
+	}
+	res := z.VerifyAll(signv2.VerifyOptions{})
+	for _, scheme := range []signv2.Scheme{signv2.SchemeV31, signv2.SchemeV3, signv2.SchemeV2, signv2.SchemeV1} {
+		if s := res.Scheme(scheme); s == nil || !s.Verified {
+			continue
+		}
+		var fps []string
+		for _, s := range res.Signers {
+			if s.Scheme == scheme {
+				fps = append(fps, s.SHA256)
+			}
+		}
+		if len(fps) > 0 {
+			slices.Sort(fps)
+			return fps
+		}
+	}
+	return nil
+}
+
+// skip reports whether name is a directory or belongs to the signature of an APK: the v1
+// signature files and the source stamp certificate digest.
+func skip(name string) bool {
+	if strings.HasSuffix(name, "/") || name == "stamp-cert-sha256" {
+		return true
+	}
+	dir, file := path.Split(name)
+	if dir != "META-INF/" {
+		return false
+	}
+	switch strings.ToUpper(path.Ext(file)) {
+	case ".MF", ".SF", ".RSA", ".DSA", ".EC":
+		return true
+	}
+	return false
+}
+
+func contentHash(f *zip.File) (sum [sha256.Size]byte, err error) {
+	rc, err := f.Open()
+	if err != nil {
+		return sum, err
+	}
+	defer rc.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, rc); err != nil {
+		return sum, err
+	}
+	h.Sum(sum[:0])
+	return sum, nil
+}
+
+func readFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// entryError locates err in the entry name of APK side, see signv2.ErrorContext.
+func entryError(side, name string, err error) error {
+	err = &signv2.ParseError{Location: signv2.Location{Offset: -1, Entry: name}, Err: err}
+	if side != "" {
+		return fmt.Errorf("%s: %w", side, err)
+	}
+	return err
+}
+
+// catch is deferred by the exported functions: it turns a panic on a malformed APK into a
+// *signv2.PanicError.
+func catch(err *error, fn string) {
+	if v := recover(); v != nil {
+		*err = &signv2.ParseError{
+			Location: signv2.Location{Offset: -1},
+			Err:      &signv2.PanicError{Func: "diff." + fn, Value: v, Stack: debug.Stack()},
+		}
+	}
+}
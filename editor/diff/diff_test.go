@@ -0,0 +1,84 @@
+package diff
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+
+	"github.com/pzx521521/apk-editor/editor/apktest"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+func TestCompareApks(t *testing.T) {
+	a, err := apktest.BuildSigned(apktest.Config{VersionCode: 1, Files: map[string][]byte{"assets/a.txt": []byte("a")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := CompareApks(a, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Equal() || len(r.SignersA) != 1 {
+		t.Errorf("same apk: %+v", r)
+	}
+
+	other, err := signv2.GenerateDebugCert("other", 0, signv2.RSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := apktest.BuildSigned(apktest.Config{VersionCode: 2, Files: map[string][]byte{"assets/b.txt": []byte("b")}}, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, err = CompareApks(a, b); err != nil {
+		t.Fatal(err)
+	}
+	if r.Equal() || r.SameSigner || len(r.SignersB) != 1 || r.SignersA[0] == r.SignersB[0] {
+		t.Errorf("signers: %v %v", r.SignersA, r.SignersB)
+	}
+	if !slices.Equal(r.Added, []string{"assets/b.txt"}) || !slices.Equal(r.Removed, []string{"assets/a.txt"}) {
+		t.Errorf("added %v, removed %v", r.Added, r.Removed)
+	}
+	want := &AttrDiff{"manifest", "android:versionCode", "1", "2"}
+	if len(r.Manifest) != 1 || *r.Manifest[0] != *want {
+		t.Errorf("manifest: %v", r.Manifest)
+	}
+	if i := slices.IndexFunc(r.Changed, func(d *EntryDiff) bool { return d.Name == zip.ANDROIDMANIFEST }); i < 0 || !r.Changed[i].Content {
+		t.Errorf("changed: %v", r.Changed)
+	}
+}
+
+func TestCompareCompression(t *testing.T) {
+	archive := func(method uint16, data string) []byte {
+		buf := new(bytes.Buffer)
+		w := zip.NewWriter(buf)
+		fw, err := w.CreateHeader(&zip.FileHeader{Name: "res/raw/data", Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte(data))
+		if err = w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	r, err := CompareApks(archive(zip.Store, "data data data data"), archive(zip.Deflate, "data data data data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Changed) != 1 || r.Changed[0].Content || !r.Changed[0].Compression ||
+		r.Changed[0].MethodA != zip.Store || r.Changed[0].MethodB != zip.Deflate {
+		t.Errorf("changed: %+v", r.Changed)
+	}
+	if r.SameSigner || len(r.Manifest) != 0 {
+		t.Errorf("unsigned archives: %+v", r)
+	}
+	if r, err = CompareApks(archive(zip.Store, "data"), archive(zip.Store, "data")); err != nil || !r.Equal() {
+		t.Errorf("unsigned copies: %+v, %v", r, err)
+	}
+
+	if _, err = CompareApks([]byte("not a zip"), nil); err == nil {
+		t.Error("CompareApks accepted a non-zip")
+	}
+}
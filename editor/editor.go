@@ -2,12 +2,17 @@ package editor
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"io"
 	"os"
 	"path/filepath"
+	"runtime/debug"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/pzx521521/apk-editor/editor/axml"
 	"github.com/pzx521521/apk-editor/editor/signv2"
 	"github.com/pzx521521/apk-editor/editor/zip"
 )
@@ -28,25 +33,44 @@ var DefaultManifest = &Manifest{
 	Package:     "com.parap.webview",
 }
 
-func (m *Manifest) Modify(manifest []byte) ([]byte, error) {
-	var modifications []any
+// ManifestChange 描述一个会被修改的 manifest 属性
+type ManifestChange struct {
+	Attr string
+	Old  string
+	New  string
+}
 
-	// 收集所有修改
+// Changes 列出相对于 DefaultManifest 会被修改的属性
+func (m *Manifest) Changes() []ManifestChange {
+	var changes []ManifestChange
 	if m.Label != "" && m.Label != DefaultManifest.Label {
-		modifications = append(modifications,
-			ModifyInfo[string]{Old: DefaultManifest.Label, New: m.Label})
+		changes = append(changes, ManifestChange{"label", DefaultManifest.Label, m.Label})
 	}
 	if m.Package != "" && m.Package != DefaultManifest.Package {
-		modifications = append(modifications,
-			ModifyInfo[string]{Old: DefaultManifest.Package, New: m.Package})
+		changes = append(changes, ManifestChange{"package", DefaultManifest.Package, m.Package})
 	}
 	if m.VersionName != "" && m.VersionName != DefaultManifest.VersionName {
-		modifications = append(modifications,
-			ModifyInfo[string]{Old: DefaultManifest.VersionName, New: m.VersionName})
+		changes = append(changes, ManifestChange{"versionName", DefaultManifest.VersionName, m.VersionName})
 	}
 	if m.VersionCode != 0 && m.VersionCode != DefaultManifest.VersionCode {
-		modifications = append(modifications,
-			ModifyInfo[uint32]{Old: DefaultManifest.VersionCode, New: m.VersionCode})
+		changes = append(changes, ManifestChange{"versionCode",
+			strconv.FormatUint(uint64(DefaultManifest.VersionCode), 10), strconv.FormatUint(uint64(m.VersionCode), 10)})
+	}
+	return changes
+}
+
+func (m *Manifest) Modify(manifest []byte) (_ []byte, err error) {
+	defer catch(&err, "Manifest.Modify", zip.ANDROIDMANIFEST)
+	var modifications []any
+
+	// 收集所有修改
+	for _, c := range m.Changes() {
+		if c.Attr == "versionCode" {
+			modifications = append(modifications,
+				ModifyInfo[uint32]{Old: DefaultManifest.VersionCode, New: m.VersionCode})
+		} else {
+			modifications = append(modifications, ModifyInfo[string]{Old: c.Old, New: c.New})
+		}
 	}
 
 	// 一次性处理所有修改
@@ -71,6 +95,16 @@ type ApkEditor struct {
 	IndexHtml []byte    `json:"index_html,omitempty"`
 	HtmlZip   []byte    `json:"html_zip,omitempty"`
 	Manifest  *Manifest `json:"manifest,omitempty"`
+	// Progress 汇报复制未修改条目 (copy), 计算摘要 (digest) 及 EditTo 写出 (write) 的进度, 可为空
+	Progress signv2.ProgressFunc `json:"-"`
+	// SelfCheck 返回前重新解析并校验输出, 也可通过环境变量 APKEDITOR_SELF_CHECK 开启
+	SelfCheck bool `json:"-"`
+	// MemoryLimit 中间结果 (未签名的 apk) 超过该字节数时转存到临时文件并通过内存映射签名, 0 表示不限制
+	MemoryLimit int64 `json:"-"`
+	// Metrics 记录 edit 及其中的 sign 操作, 为空时使用 signv2.SetMetricsRecorder 设置的记录器
+	Metrics   signv2.MetricsRecorder `json:"-"`
+	assets    []*MergeEntry          // AddAsset 加入的文件, 写在 Url/IndexHtml/HtmlZip 之后, 同名时覆盖它们
+	files     []*fileEdit            // AddFile, ReplaceFile, DeleteFile 的修改, 非空时重写整个 zip
 	apkRaw    []byte
 	keyBytes  []byte
 	certBytes []byte
@@ -86,34 +120,121 @@ func (a *ApkEditor) Init(apk, keyBytes, certBytes []byte) {
 }
 
 func (a *ApkEditor) Edit() ([]byte, error) {
-	modifyContent, err := a.modifyContent()
+	return a.EditContext(context.Background())
+}
+
+// EditContext 同 Edit, ctx 结束时停止签名并返回 ctx.Err()
+func (a *ApkEditor) EditContext(ctx context.Context) (_ []byte, err error) {
+	defer a.record(time.Now(), &err)
+	defer catch(&err, "Edit", "")
+	z, cleanup, err := a.build()
 	if err != nil {
 		return nil, err
 	}
-	if len(modifyContent) == 0 {
-		return nil, errors.New("no content to modify")
+	defer cleanup()
+	return z.SignV2Context(ctx, a.signingCerts(), a.signOptions()...)
+}
+
+// EditTo 同 EditContext, 但把签名后的 apk 直接写入 w. 配合 MemoryLimit 使用时内存占用与 apk 大小无关.
+// SelfCheck 不适用, 因为输出没有保留.
+func (a *ApkEditor) EditTo(ctx context.Context, w io.Writer) (err error) {
+	defer a.record(time.Now(), &err)
+	defer catch(&err, "EditTo", "")
+	z, cleanup, err := a.build()
+	if err != nil {
+		return err
 	}
-	r, err := zip.NewReader(bytes.NewReader(a.apkRaw), int64(len(a.apkRaw)))
+	defer cleanup()
+	return z.SignV2ToContext(ctx, w, a.signingCerts(), a.signOptions()...)
+}
+
+// record 向 Metrics 记录一次 edit 操作, 大小为输入 apk 的大小
+func (a *ApkEditor) record(start time.Time, err *error) {
+	m := a.Metrics
+	if m == nil {
+		m = signv2.DefaultMetricsRecorder()
+	}
+	if m != nil {
+		m.RecordOperation(signv2.OpEdit, int64(len(a.apkRaw)), time.Since(start), *err)
+	}
+}
+
+func (a *ApkEditor) signOptions() []signv2.Option {
+	if a.Metrics == nil {
+		return nil
+	}
+	return []signv2.Option{signv2.WithMetrics(a.Metrics)}
+}
+
+// build 生成修改后未签名的 apk, 返回可用于签名的 ApkSign 及释放临时文件的函数
+func (a *ApkEditor) build() (*signv2.ApkSign, func(), error) {
+	modifyContent, err := a.modifyContent()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	aBuf := new(bytes.Buffer)
-	aBuf.Write(a.apkRaw[:r.AppendOffset()])
-	w := r.Append(aBuf, a.Manifest != nil)
-	err = merge(w, modifyContent...)
+	if len(modifyContent) == 0 && len(a.files) == 0 {
+		return nil, nil, errors.New("no content to modify")
+	}
+	r, err := zip.NewReader(bytes.NewReader(a.apkRaw), int64(len(a.apkRaw)))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	aBuf := &spillBuffer{limit: a.MemoryLimit}
+	fail := func(err error) (*signv2.ApkSign, func(), error) {
+		aBuf.Close()
+		return nil, nil, err
+	}
+	var w *zip.Writer
+	if len(a.files) > 0 {
+		// 有条目被删除或替换时重写整个 zip, 追加无法去掉旧的数据
+		w = zip.NewWriter(aBuf)
+		err = a.rewrite(r, w, modifyContent)
+	} else {
+		err = copyProgress(aBuf, a.apkRaw[:r.AppendOffset()], a.Progress)
+		w = r.Append(aBuf, a.Manifest != nil)
 	}
-	err = a.manifest(r, w)
 	if err != nil {
-		return nil, err
+		return fail(err)
 	}
-	err = w.Close()
+	if err = merge(w, modifyContent...); err != nil {
+		return fail(err)
+	}
+	if err = a.manifest(r, w); err != nil {
+		return fail(err)
+	}
+	if err = w.Close(); err != nil {
+		return fail(err)
+	}
+	z, err := aBuf.apkSign()
 	if err != nil {
-		return nil, err
+		return fail(err)
+	}
+	z.Progress = a.Progress
+	z.SelfCheck = a.SelfCheck
+	return z, func() {
+		z.Close()
+		aBuf.Close()
+	}, nil
+}
+
+// copyProgress 分块写入 b, 每块写完汇报一次 copy 进度
+func copyProgress(w io.Writer, b []byte, progress signv2.ProgressFunc) error {
+	if progress == nil {
+		_, err := w.Write(b)
+		return err
 	}
-	return sign(aBuf.Bytes(), a.keyBytes, a.certBytes)
+	total := int64(len(b))
+	for done := int64(0); done < total; {
+		n, err := w.Write(b[done:min(total, done+4<<20)])
+		done += int64(n)
+		progress(signv2.StageCopy, done, total)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
+
 func (a *ApkEditor) modifyContent() ([]*MergeEntry, error) {
 	var mergeEntries []*MergeEntry
 	if a.Url != "" {
@@ -135,7 +256,7 @@ func (a *ApkEditor) modifyContent() ([]*MergeEntry, error) {
 		}
 		mergeEntries = append(mergeEntries, content...)
 	}
-	return mergeEntries, nil
+	return append(mergeEntries, a.assets...), nil
 }
 
 func (a *ApkEditor) manifest(r *zip.Reader, w *zip.Writer) error {
@@ -156,6 +277,41 @@ func (a *ApkEditor) manifest(r *zip.Reader, w *zip.Writer) error {
 	}
 	return nil
 }
+
+// EditManifest 只修改 apk 中的 AndroidManifest.xml, 返回未签名的 apk
+func EditManifest(apk []byte, m *Manifest) (_ []byte, err error) {
+	defer catch(&err, "EditManifest", "")
+	r, err := zip.NewReader(bytes.NewReader(apk), int64(len(apk)))
+	if err != nil {
+		return nil, err
+	}
+	aBuf := new(bytes.Buffer)
+	aBuf.Write(apk[:r.AppendOffset()])
+	w := r.Append(aBuf, true)
+	a := &ApkEditor{Manifest: m}
+	if err = a.manifest(r, w); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	if signv2.SelfCheckEnabled() {
+		if err = checkEdited(aBuf.Bytes()); err != nil {
+			return nil, &signv2.SelfCheckError{Op: "edit manifest", Err: err}
+		}
+	}
+	return aBuf.Bytes(), nil
+}
+
+// checkEdited 检查未签名的输出: 所有条目可正常解压, 且 manifest 仍是合法的二进制 xml
+func checkEdited(apk []byte) error {
+	if err := signv2.CheckZip(apk); err != nil {
+		return err
+	}
+	_, err := decodeManifest(apk)
+	return err
+}
+
 func zipContent(zipData []byte) ([]*MergeEntry, error) {
 	var mergeEntries []*MergeEntry
 	reader, err := zip.NewReader(bytes.NewReader(zipData), int64(len(zipData)))
@@ -200,21 +356,16 @@ func dirContent(dir string) ([]*MergeEntry, error) {
 	})
 	return mergeEntrys, nil
 }
-func sign(apk, keyBytes, certBytes []byte) ([]byte, error) {
-	var keys = []*signv2.SigningCert{
+func (a *ApkEditor) signingCerts() []*signv2.SigningCert {
+	return []*signv2.SigningCert{
 		{SigningKey: signv2.SigningKey{
-			KeyBytes: keyBytes,
+			KeyBytes: a.keyBytes,
 			Type:     signv2.RSA,
 			Hash:     signv2.SHA256,
 		},
-			CertBytes: certBytes,
+			CertBytes: a.certBytes,
 		},
 	}
-	z, err := signv2.NewApkSign(apk)
-	if err != nil {
-		return nil, err
-	}
-	return z.SignV2(keys)
 }
 func merge(w *zip.Writer, mf ...*MergeEntry) error {
 	for _, file := range mf {
@@ -223,7 +374,15 @@ func merge(w *zip.Writer, mf ...*MergeEntry) error {
 			Method: zip.Deflate,
 		}
 		header.SetMode(0o666)
-		f, err := w.CreateHeader(header)
+		var f io.Writer
+		var err error
+		if storedAsset(file.Name) {
+			// 已压缩的格式不再压缩, 按 zipalign 的规则 4 字节对齐
+			header.Method = zip.Store
+			f, err = w.CreateAlignedHeader(header, 4)
+		} else {
+			f, err = w.CreateHeader(header)
+		}
 		if err != nil {
 			return err
 		}
@@ -234,6 +393,33 @@ func merge(w *zip.Writer, mf ...*MergeEntry) error {
 	}
 	return nil
 }
+
+// entryError 为解析 apk 中条目 name 时的错误附加条目名, 见 signv2.ErrorContext
+func entryError(name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &signv2.ParseError{Location: signv2.Location{Offset: -1, Entry: name}, Err: err}
+}
+
+// catch 在公开函数中 defer 调用, 把处理恶意 apk 时的 panic 转为 *signv2.PanicError,
+// entry 为所处理的条目, 空表示整个 apk
+func catch(err *error, fn, entry string) {
+	if v := recover(); v != nil {
+		loc := signv2.Location{Offset: -1, Chunk: "file"}
+		if entry != "" {
+			loc = signv2.Location{Offset: -1, Chunk: "document", Entry: entry}
+		}
+		*err = &signv2.ParseError{Location: loc, Err: &signv2.PanicError{Func: "editor." + fn, Value: v, Stack: debug.Stack()}}
+	}
+}
+
+// parseManifest 解析二进制 AndroidManifest.xml, 错误附带条目名
+func parseManifest(manifest []byte) (*axml.Element, error) {
+	root, err := axml.Parse(manifest)
+	return root, entryError(zip.ANDROIDMANIFEST, err)
+}
+
 func readManifest(r *zip.Reader) ([]byte, error) {
 	//读取源数据
 	for _, f := range r.File {
@@ -0,0 +1,330 @@
+package editor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pzx521521/apk-editor/editor/axml"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+// WearableAppMeta 宿主 manifest 的 application 下声明内嵌手表应用的 meta-data 名,
+// 其 android:resource 指向描述文件 <wearableApp>
+const WearableAppMeta = "com.google.android.wearable.beta.app"
+
+// wearableDescName 描述文件的惯用路径. 未解析 resources.arsc, 找不到时再查找 res/xml* 下
+// 根元素为 <wearableApp> 的文件
+const wearableDescName = "res/xml/wearable_app_desc.xml"
+
+// featureWatch 手表应用必须声明的 uses-feature
+const featureWatch = "android.hardware.type.watch"
+
+// EmbeddedApp 宿主 apk 中内嵌的手表应用 (micro apk), 由描述文件声明:
+//
+//	<wearableApp package="com.example.app">
+//	  <versionCode>1</versionCode>
+//	  <versionName>1.0</versionName>
+//	  <rawPathResId>wearable_app</rawPathResId>
+//	</wearableApp>
+type EmbeddedApp struct {
+	Desc        string // 描述文件在宿主中的路径
+	Path        string // 内嵌 apk 在宿主中的路径, 如 res/raw/wearable_app.apk, Unbundled 时为空
+	Package     string
+	VersionCode uint32
+	VersionName string
+	Unbundled   bool // 描述文件为 <unbundled/>, 手表应用单独从商店安装, 宿主不含 apk
+}
+
+// FindEmbeddedApp 查找 apk 内嵌的手表应用, manifest 未声明 WearableAppMeta 时返回 nil
+func FindEmbeddedApp(apk []byte) (_ *EmbeddedApp, err error) {
+	defer catch(&err, "FindEmbeddedApp", "")
+	r, err := zip.NewReader(bytes.NewReader(apk), int64(len(apk)))
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := readManifest(r)
+	if err != nil {
+		return nil, err
+	}
+	root, err := parseManifest(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if !hasMetaData(root, WearableAppMeta) {
+		return nil, nil
+	}
+	app, _, err := findEmbeddedApp(r)
+	return app, err
+}
+
+// CheckEmbeddedApp 按手表安装内嵌应用时的规则检查 apk, 未声明内嵌应用时返回 nil:
+//   - 描述文件与内嵌 apk 存在, 包名与宿主相同
+//   - 内嵌 apk 的包名, versionCode, versionName 与描述文件一致, 且声明了 android.hardware.type.watch
+//   - 内嵌 apk 与宿主的签名均有效, 且签名证书相同
+func CheckEmbeddedApp(apk []byte) (err error) {
+	defer catch(&err, "CheckEmbeddedApp", "")
+	r, err := zip.NewReader(bytes.NewReader(apk), int64(len(apk)))
+	if err != nil {
+		return err
+	}
+	manifest, err := readManifest(r)
+	if err != nil {
+		return err
+	}
+	root, err := parseManifest(manifest)
+	if err != nil {
+		return err
+	}
+	if !hasMetaData(root, WearableAppMeta) {
+		return nil
+	}
+	app, _, err := findEmbeddedApp(r)
+	if err != nil {
+		return err
+	}
+	if hostPkg := root.AttrValue("", "package"); app.Package != hostPkg {
+		return fmt.Errorf("%s declares package %s, host is %s", app.Desc, app.Package, hostPkg)
+	}
+	if app.Unbundled {
+		return nil
+	}
+	var micro []byte
+	for _, f := range r.File {
+		if f.Name == app.Path {
+			if micro, err = readEntry(f); err != nil {
+				return entryError(f.Name, err)
+			}
+		}
+	}
+	if err = checkMicroApp(app, micro); err != nil {
+		return err
+	}
+	hz, err := signv2.NewApkSign(apk)
+	if err != nil {
+		return err
+	}
+	hostCert, err := signerCert(hz)
+	if err != nil {
+		return fmt.Errorf("host signature: %w", err)
+	}
+	mz, err := signv2.NewApkSign(micro)
+	if err != nil {
+		return entryError(app.Path, err)
+	}
+	microCert, err := signerCert(mz)
+	if err != nil {
+		return fmt.Errorf("%s signature: %w", app.Path, err)
+	}
+	if !bytes.Equal(hostCert, microCert) {
+		return fmt.Errorf("%s is signed with a different certificate than the host", app.Path)
+	}
+	return nil
+}
+
+// EmbedApp 替换宿主中内嵌的手表应用为 micro, 并按 micro 的 manifest 更新描述文件, 返回重新签名的 apk.
+// 添加资源需要修改 resources.arsc, 因此宿主须已声明内嵌应用 (WearableAppMeta 及描述文件).
+// micro 须已用与宿主相同的证书签名
+func (a *ApkEditor) EmbedApp(micro []byte) (_ []byte, err error) {
+	defer catch(&err, "EmbedApp", "")
+	r, err := zip.NewReader(bytes.NewReader(a.apkRaw), int64(len(a.apkRaw)))
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := readManifest(r)
+	if err != nil {
+		return nil, err
+	}
+	root, err := parseManifest(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if !hasMetaData(root, WearableAppMeta) {
+		return nil, errors.New("manifest declares no " + WearableAppMeta + " meta-data")
+	}
+	app, desc, err := findEmbeddedApp(r)
+	if err != nil {
+		return nil, err
+	}
+	if app.Unbundled {
+		return nil, fmt.Errorf("%s is unbundled and has no embedded apk", app.Desc)
+	}
+
+	// 内嵌 apk 须与宿主签名一致, 宿主将用 signingCerts 重新签名
+	keys := a.signingCerts()
+	if err = keys[0].Resolve(); err != nil {
+		return nil, err
+	}
+	mz, err := signv2.NewApkSign(micro)
+	if err != nil {
+		return nil, err
+	}
+	microCert, err := signerCert(mz)
+	if err != nil {
+		return nil, fmt.Errorf("embedded apk signature: %w", err)
+	}
+	if !bytes.Equal(microCert, keys[0].Certificate.Raw) {
+		return nil, errors.New("embedded apk is signed with a different certificate than the host")
+	}
+
+	pkg, versionCode, err := packageVersion(micro)
+	if err != nil {
+		return nil, err
+	}
+	microRoot, err := decodeManifest(micro)
+	if err != nil {
+		return nil, err
+	}
+	app.Package, app.VersionCode, app.VersionName = pkg, versionCode, microRoot.AttrValue(axml.NSAndroid, "versionName")
+	if err = checkMicroApp(app, micro); err != nil {
+		return nil, err
+	}
+	if want := root.AttrValue("", "package"); pkg != want {
+		return nil, fmt.Errorf("embedded apk is package %s, host is %s", pkg, want)
+	}
+	if attr := desc.Attr("", "package"); attr != nil {
+		attr.Type, attr.Raw = axml.TypeString, pkg
+	}
+	setChildText(desc, "versionCode", strconv.FormatUint(uint64(versionCode), 10))
+	setChildText(desc, "versionName", app.VersionName)
+
+	buf := new(bytes.Buffer)
+	buf.Write(a.apkRaw[:r.AppendOffset()])
+	w := r.Append(buf, false)
+	if err = merge(w, &MergeEntry{app.Desc, axml.Encode(desc)}, &MergeEntry{app.Path, micro}); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	z, err := signv2.NewApkSignNoCopy(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return z.SignV2(keys, a.signOptions()...)
+}
+
+// findEmbeddedApp 读取描述文件及其声明的内嵌应用, 同时返回描述文件的根元素
+func findEmbeddedApp(r *zip.Reader) (*EmbeddedApp, *axml.Element, error) {
+	var desc *axml.Element
+	app := &EmbeddedApp{}
+	i := slices.IndexFunc(r.File, func(f *zip.File) bool { return f.Name == wearableDescName })
+	for j, f := range r.File {
+		if i >= 0 && j != i || i < 0 && !(strings.HasPrefix(path.Dir(f.Name), "res/xml") && path.Ext(f.Name) == ".xml") {
+			continue
+		}
+		b, err := readEntry(f)
+		if err != nil {
+			return nil, nil, entryError(f.Name, err)
+		}
+		root, err := axml.Parse(b)
+		if err != nil {
+			if i >= 0 {
+				return nil, nil, entryError(f.Name, err)
+			}
+			continue // 其他 xml 资源, 不关心能否解析
+		}
+		if root.Name == "wearableApp" {
+			desc, app.Desc = root, f.Name
+			break
+		}
+	}
+	if desc == nil {
+		return nil, nil, errors.New("no <wearableApp> description in res/xml")
+	}
+
+	app.Package = desc.AttrValue("", "package")
+	var raw string
+	for _, c := range desc.Children {
+		switch c.Name {
+		case "versionCode":
+			v, err := strconv.ParseUint(strings.TrimSpace(childText(c)), 10, 32)
+			if err != nil {
+				return nil, nil, entryError(app.Desc, fmt.Errorf("bad versionCode %q", childText(c)))
+			}
+			app.VersionCode = uint32(v)
+		case "versionName":
+			app.VersionName = strings.TrimSpace(childText(c))
+		case "rawPathResId":
+			raw = strings.TrimSpace(childText(c))
+		case "unbundled":
+			app.Unbundled = true
+		}
+	}
+	if app.Unbundled {
+		return app, desc, nil
+	}
+	if raw == "" {
+		return nil, nil, entryError(app.Desc, errors.New("<wearableApp> has no rawPathResId"))
+	}
+	for _, f := range r.File {
+		if strings.HasPrefix(path.Dir(f.Name), "res/raw") && path.Base(f.Name) == raw+".apk" {
+			app.Path = f.Name
+			return app, desc, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("embedded apk %s not found in res/raw", raw+".apk")
+}
+
+// checkMicroApp 检查内嵌 apk 的 manifest 与描述文件一致, 并且是手表应用
+func checkMicroApp(app *EmbeddedApp, micro []byte) error {
+	root, err := decodeManifest(micro)
+	if err != nil {
+		return entryError(app.Path, err)
+	}
+	pkg, versionCode, err := packageVersion(micro)
+	if err != nil {
+		return entryError(app.Path, err)
+	}
+	if pkg != app.Package || versionCode != app.VersionCode {
+		return fmt.Errorf("%s is %s version %d, %s declares %s version %d", app.Path, pkg, versionCode, app.Desc, app.Package, app.VersionCode)
+	}
+	if name := root.AttrValue(axml.NSAndroid, "versionName"); name != app.VersionName {
+		return fmt.Errorf("%s has versionName %q, %s declares %q", app.Path, name, app.Desc, app.VersionName)
+	}
+	if !slices.ContainsFunc(root.Children, func(e *axml.Element) bool {
+		return e.Name == "uses-feature" && e.AttrValue(axml.NSAndroid, "name") == featureWatch
+	}) {
+		return fmt.Errorf("%s does not declare uses-feature %s", app.Path, featureWatch)
+	}
+	return nil
+}
+
+// hasMetaData 判断 application 下是否有名为 name 的 meta-data
+func hasMetaData(root *axml.Element, name string) bool {
+	for _, c := range root.Children {
+		if c.Name == "application" && slices.ContainsFunc(c.Children, func(e *axml.Element) bool {
+			return e.Name == "meta-data" && e.AttrValue(axml.NSAndroid, "name") == name
+		}) {
+			return true
+		}
+	}
+	return false
+}
+
+// childText 返回元素中文本节点的内容
+func childText(e *axml.Element) string {
+	var sb strings.Builder
+	for _, c := range e.Children {
+		if c.Name == "" {
+			sb.WriteString(c.Text)
+		}
+	}
+	return sb.String()
+}
+
+// setChildText 把 e 的子元素 name 的文本设为 text, 没有该子元素时添加
+func setChildText(e *axml.Element, name, text string) {
+	for _, c := range e.Children {
+		if c.Name == name {
+			c.Children = []*axml.Element{{Text: text}}
+			return
+		}
+	}
+	e.Children = append(e.Children, &axml.Element{Name: name, Children: []*axml.Element{{Text: text}}})
+}
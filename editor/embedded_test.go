@@ -0,0 +1,144 @@
+package editor
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+
+	"github.com/pzx521521/apk-editor/editor/apktest"
+	"github.com/pzx521521/apk-editor/editor/axml"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+// withManifest 用 edit 修改 apk 的 manifest 并用 apktest.Key 重新签名
+func withManifest(t *testing.T, apk []byte, edit func(root, app *axml.Element)) []byte {
+	t.Helper()
+	r, err := zip.NewReader(bytes.NewReader(apk), int64(len(apk)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := decodeManifest(apk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range root.Children {
+		if c.Name == "application" {
+			edit(root, c)
+		}
+	}
+	buf := new(bytes.Buffer)
+	buf.Write(apk[:r.AppendOffset()])
+	w := r.Append(buf, true)
+	if err = merge(w, &MergeEntry{zip.ANDROIDMANIFEST, axml.Encode(root)}); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	key, err := apktest.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := signv2.NewApkSignNoCopy(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := z.SignV2([]*signv2.SigningCert{key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// testWearApp 返回声明了 android.hardware.type.watch 的已签名手表应用
+func testWearApp(t *testing.T, versionCode int) []byte {
+	t.Helper()
+	apk, err := apktest.Build(apktest.Config{VersionCode: versionCode, VersionName: strconv.Itoa(versionCode) + ".0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return withManifest(t, apk, func(root, _ *axml.Element) {
+		root.Children = append(root.Children, &axml.Element{Name: "uses-feature", Attrs: []*axml.Attr{
+			{NS: axml.NSAndroid, Name: "name", ResID: 0x01010003, Type: axml.TypeString, Raw: featureWatch}}})
+	})
+}
+
+// testWearHost 返回内嵌 micro 的宿主 apk, 描述文件声明的版本为 versionCode
+func testWearHost(t *testing.T, micro []byte, versionCode int) []byte {
+	t.Helper()
+	text := func(name, s string) *axml.Element {
+		return &axml.Element{Name: name, Children: []*axml.Element{{Text: s}}}
+	}
+	desc := axml.Encode(&axml.Element{
+		Name:  "wearableApp",
+		Attrs: []*axml.Attr{{Name: "package", Type: axml.TypeString, Raw: apktest.DefaultPackage}},
+		Children: []*axml.Element{
+			text("versionCode", strconv.Itoa(versionCode)),
+			text("versionName", strconv.Itoa(versionCode)+".0"),
+			text("rawPathResId", "wearable_app"),
+		},
+	})
+	apk, err := apktest.Build(apktest.Config{Files: map[string][]byte{
+		wearableDescName:           desc,
+		"res/raw/wearable_app.apk": micro,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return withManifest(t, apk, func(_, app *axml.Element) {
+		app.Children = append(app.Children, &axml.Element{Name: "meta-data", Attrs: []*axml.Attr{
+			{NS: axml.NSAndroid, Name: "name", ResID: 0x01010003, Type: axml.TypeString, Raw: WearableAppMeta},
+			{NS: axml.NSAndroid, Name: "resource", ResID: 0x01010025, Type: axml.TypeReference, Data: 0x7f020000}}})
+	})
+}
+
+func TestEmbeddedApp(t *testing.T) {
+	host := testWearHost(t, testWearApp(t, 3), 3)
+	app, err := FindEmbeddedApp(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := EmbeddedApp{Desc: wearableDescName, Path: "res/raw/wearable_app.apk", Package: apktest.DefaultPackage, VersionCode: 3, VersionName: "3.0"}
+	if app == nil || *app != want {
+		t.Fatalf("FindEmbeddedApp = %+v, want %+v", app, want)
+	}
+	if err = CheckEmbeddedApp(host); err != nil {
+		t.Fatal(err)
+	}
+	plain, err := apktest.BuildSigned(apktest.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app, err = FindEmbeddedApp(plain); app != nil || err != nil {
+		t.Errorf("FindEmbeddedApp without meta-data = %v, %v", app, err)
+	}
+
+	if err = CheckEmbeddedApp(testWearHost(t, testWearApp(t, 3), 4)); err == nil {
+		t.Error("CheckEmbeddedApp accepted a description with another versionCode")
+	}
+
+	key, err := apktest.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := NewApkEditor(host, key.KeyBytes, key.CertBytes)
+	updated, err := a.EmbedApp(testWearApp(t, 4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = CheckEmbeddedApp(updated); err != nil {
+		t.Fatal(err)
+	}
+	if app, err = FindEmbeddedApp(updated); err != nil || app.VersionCode != 4 || app.VersionName != "4.0" {
+		t.Errorf("after EmbedApp: %+v, %v", app, err)
+	}
+
+	unsigned, err := apktest.Build(apktest.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = a.EmbedApp(unsigned); err == nil {
+		t.Error("EmbedApp accepted an unsigned embedded apk")
+	}
+}
@@ -0,0 +1,177 @@
+package editor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/pzx521521/apk-editor/editor/signv2"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+// fileEdit AddFile, ReplaceFile, DeleteFile 对一个条目的修改
+type fileEdit struct {
+	name   string
+	data   []byte
+	method uint16 // zip.Store 或 zip.Deflate
+	delete bool
+}
+
+// AddFile 编辑时把 data 写入 apk 中的 name, 已有同名条目时在原位置替换. compress 为 false 时不压缩,
+// 按 zipalign 的规则对齐: lib/ 下的 .so 16KB, 其余 4 字节
+func (a *ApkEditor) AddFile(name string, data []byte, compress bool) error {
+	if !fs.ValidPath(name) || name == "." {
+		return fmt.Errorf("invalid file path %q", name)
+	}
+	method := zip.Store
+	if compress {
+		method = zip.Deflate
+	}
+	a.setFile(&fileEdit{name: name, data: data, method: method})
+	return nil
+}
+
+// ReplaceFile 编辑时替换已有条目 name 的内容, 保持原来的压缩方式
+func (a *ApkEditor) ReplaceFile(name string, data []byte) error {
+	method, ok, err := a.fileMethod(name)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+	}
+	a.setFile(&fileEdit{name: name, data: data, method: method})
+	return nil
+}
+
+// DeleteFile 编辑时删除条目 name
+func (a *ApkEditor) DeleteFile(name string) error {
+	_, ok, err := a.fileMethod(name)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+	}
+	a.setFile(&fileEdit{name: name, delete: true})
+	return nil
+}
+
+// setFile 记录修改, 覆盖同一条目之前的修改
+func (a *ApkEditor) setFile(e *fileEdit) {
+	for i, old := range a.files {
+		if old.name == e.name {
+			a.files[i] = e
+			return
+		}
+	}
+	a.files = append(a.files, e)
+}
+
+// fileMethod 返回条目 name 在修改后的压缩方式, ok 表示条目存在
+func (a *ApkEditor) fileMethod(name string) (method uint16, ok bool, err error) {
+	for _, e := range a.files {
+		if e.name == name {
+			return e.method, !e.delete, nil
+		}
+	}
+	r, err := zip.NewReader(bytes.NewReader(a.apkRaw), int64(len(a.apkRaw)))
+	if err != nil {
+		return 0, false, err
+	}
+	for _, f := range r.File {
+		if f.Name == name {
+			return f.Method, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// rewrite 把 r 中的条目逐个写入新的 w: 未修改的条目按原始字节复制, 不压缩的重新对齐; 替换的条目写在原位置,
+// 新增的写在最后. 删除的条目, 旧的 v1 签名文件, 以及之后由 later 和 Manifest 覆盖的条目不再写入
+func (a *ApkEditor) rewrite(r *zip.Reader, w *zip.Writer, later []*MergeEntry) error {
+	skip := make(map[string]bool, len(later)+1)
+	for _, e := range later {
+		skip[e.Name] = true
+	}
+	if a.Manifest != nil {
+		skip[zip.ANDROIDMANIFEST] = true
+	}
+	added := make(map[string]*fileEdit, len(a.files))
+	for _, e := range a.files {
+		added[e.name] = e
+	}
+	total, done := r.AppendOffset(), int64(0)
+	for _, f := range r.File {
+		e := added[f.Name]
+		delete(added, f.Name)
+		var err error
+		switch {
+		case skip[f.Name] || v1SignatureFile(f.Name):
+		case e != nil:
+			if !e.delete {
+				err = writeFile(w, e)
+			}
+		case f.Method == zip.Store:
+			err = w.CopyAligned(f, fileAlign(f.Name))
+		default:
+			err = w.Copy(f)
+		}
+		if err != nil {
+			return entryError(f.Name, err)
+		}
+		if a.Progress != nil {
+			done = min(total, done+int64(f.CompressedSize64))
+			a.Progress(signv2.StageCopy, done, total)
+		}
+	}
+	for _, e := range a.files {
+		if added[e.name] != nil && !e.delete && !skip[e.name] {
+			if err := writeFile(w, e); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeFile 写入 AddFile 或 ReplaceFile 的条目
+func writeFile(w *zip.Writer, e *fileEdit) error {
+	fh := &zip.FileHeader{Name: e.name, Method: e.method}
+	fh.SetMode(0o666)
+	var f io.Writer
+	var err error
+	if e.method == zip.Store {
+		f, err = w.CreateAlignedHeader(fh, fileAlign(e.name))
+	} else {
+		f, err = w.CreateHeader(fh)
+	}
+	if err == nil {
+		_, err = f.Write(e.data)
+	}
+	return err
+}
+
+// fileAlign 不压缩条目的对齐字节数, 同 zipalign -p
+func fileAlign(name string) int {
+	if strings.HasPrefix(name, "lib/") && strings.HasSuffix(name, ".so") {
+		return PageSize16K
+	}
+	return 4
+}
+
+// v1SignatureFile 是否为 META-INF 下的 v1 签名文件, 重写 zip 后这些签名已失效
+func v1SignatureFile(name string) bool {
+	dir, file := path.Split(name)
+	if dir != "META-INF/" {
+		return false
+	}
+	switch strings.ToUpper(path.Ext(file)) {
+	case ".MF", ".SF", ".RSA", ".DSA", ".EC":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package editor
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"reflect"
+	"testing"
+
+	"github.com/pzx521521/apk-editor/editor/apktest"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+func TestEditFiles(t *testing.T) {
+	apk, err := apktest.Build(apktest.Config{Files: map[string][]byte{
+		"META-INF/MANIFEST.MF":    []byte("Manifest-Version: 1.0\r\n"),
+		"META-INF/CERT.SF":        []byte("Signature-Version: 1.0\r\n"),
+		"META-INF/CERT.RSA":       []byte("pkcs7"),
+		"META-INF/services/a.b.C": []byte("a.b.Impl"),
+		"assets/config.json":      []byte("old"),
+		"assets/deleted.txt":      []byte("deleted"),
+		"res/raw/kept.txt":        []byte("kept"),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := apktest.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := NewApkEditor(apk, key.KeyBytes, key.CertBytes)
+	if err = a.ReplaceFile("assets/config.json", []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.DeleteFile("assets/deleted.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.AddFile("lib/arm64-v8a/libfoo.so", []byte("elf"), false); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.AddFile("assets/data.bin", []byte("data"), false); err != nil {
+		t.Fatal(err)
+	}
+	// 新增后又删除的条目不写入
+	if err = a.AddFile("assets/tmp", []byte("tmp"), true); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.DeleteFile("assets/tmp"); err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range []error{a.ReplaceFile("missing", nil), a.DeleteFile("assets/deleted.txt"), a.DeleteFile("assets/tmp")} {
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("got %v, want fs.ErrNotExist", err)
+		}
+	}
+	if err = a.AddFile("../escape", nil, true); err == nil {
+		t.Error("AddFile accepted a path outside the apk")
+	}
+
+	plan, err := a.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(plan.Deleted, []string{"assets/deleted.txt"}) || len(plan.Replaced) != 1 || len(plan.Added) != 2 {
+		t.Errorf("plan = %+v", plan)
+	}
+
+	signed, err := a.Edit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := signv2.NewApkSign(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = z.VerifyV2(); err != nil {
+		t.Fatal(err)
+	}
+
+	in, err := zip.NewReader(bytes.NewReader(apk), int64(len(apk)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := map[string][]byte{}
+	for _, f := range in.File {
+		raw[f.Name] = rawData(t, apk, f)
+	}
+	r, err := zip.NewReader(bytes.NewReader(signed), int64(len(signed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		got[f.Name] = string(data)
+		off, err := f.DataOffset()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Method == zip.Store && off%int64(fileAlign(f.Name)) != 0 {
+			t.Errorf("%s at %d is not aligned", f.Name, off)
+		}
+		// 未修改的条目按原始字节复制
+		if old, ok := raw[f.Name]; ok && f.Name != "assets/config.json" && !bytes.Equal(rawData(t, signed, f), old) {
+			t.Errorf("%s was not copied as is", f.Name)
+		}
+	}
+	for name, want := range map[string]string{
+		"assets/config.json":      "new",
+		"lib/arm64-v8a/libfoo.so": "elf",
+		"assets/data.bin":         "data",
+		"META-INF/services/a.b.C": "a.b.Impl",
+	} {
+		if got[name] != want {
+			t.Errorf("%s = %q, want %q", name, got[name], want)
+		}
+	}
+	for _, name := range []string{"assets/deleted.txt", "assets/tmp", "META-INF/MANIFEST.MF", "META-INF/CERT.SF", "META-INF/CERT.RSA"} {
+		if _, ok := got[name]; ok {
+			t.Errorf("%s was not removed", name)
+		}
+	}
+	if len(r.File) != len(in.File)-4+2 {
+		t.Errorf("%d entries, want %d", len(r.File), len(in.File)-4+2)
+	}
+}
+
+// rawData 返回条目在 apk 中压缩后的原始字节
+func rawData(t *testing.T, apk []byte, f *zip.File) []byte {
+	off, err := f.DataOffset()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return apk[off : off+int64(f.CompressedSize64)]
+}
@@ -0,0 +1,203 @@
+package editor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pzx521521/apk-editor/editor/axml"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+// LoadMethod 注入的 .so 在应用启动时的加载方式
+type LoadMethod int
+
+const (
+	// LoadProvider 新增一个 dex, 其中的 ContentProvider 在类初始化时 System.loadLibrary,
+	// provider 在 Application.onCreate 之前创建, 无需修改原有代码 (smali)
+	LoadProvider LoadMethod = iota
+	// LoadWrapSh 在每个 ABI 目录写入 wrap.sh, 通过 LD_PRELOAD 在进程启动时加载.
+	// 系统只对 debuggable 的应用执行 wrap.sh, 且要求解压 native 库, 会把 extractNativeLibs 改为 true
+	LoadWrapSh
+)
+
+// 注入的 ContentProvider
+const (
+	gadgetLoaderClass = "com.apkeditor.gadget.GadgetLoader"
+	gadgetAuthority   = ".apkeditor.gadget"
+)
+
+// NativeLibOptions InjectNativeLib 的参数
+type NativeLibOptions struct {
+	Name   string     // 库名, 写入 lib/<abi>/lib<Name>.so, 为空时为 frida-gadget
+	Load   LoadMethod // 加载方式
+	Config []byte     // 可选的配置文件, 写入 lib/<abi>/lib<Name>.config.so, 如 frida gadget 的 json 配置
+}
+
+// InjectNativeLib 向 apk 注入 native 库 (如 frida gadget) 并确保应用启动时加载, 返回重新签名的 apk.
+// libs 的键为 ABI, 如 arm64-v8a, 值为该 ABI 的 .so. 已有同名的库时替换.
+// 库在 manifest 声明 extractNativeLibs="false" 时不压缩并按 16KB 对齐, 否则压缩.
+func (a *ApkEditor) InjectNativeLib(libs map[string][]byte, opts NativeLibOptions) (_ []byte, err error) {
+	defer catch(&err, "InjectNativeLib", "")
+	if len(libs) == 0 {
+		return nil, errors.New("no native library to inject")
+	}
+	if opts.Name == "" {
+		opts.Name = "frida-gadget"
+	}
+	if strings.TrimFunc(opts.Name, func(r rune) bool {
+		return r == '_' || r == '-' || r == '.' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+	}) != "" {
+		return nil, fmt.Errorf("invalid library name %q", opts.Name)
+	}
+	abis := make([]string, 0, len(libs))
+	for abi := range libs {
+		if abi == "" || strings.ContainsAny(abi, "/\\") || abi == "." || abi == ".." {
+			return nil, fmt.Errorf("invalid abi %q", abi)
+		}
+		abis = append(abis, abi)
+	}
+	slices.Sort(abis)
+
+	r, err := zip.NewReader(bytes.NewReader(a.apkRaw), int64(len(a.apkRaw)))
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := readManifest(r)
+	if err != nil {
+		return nil, err
+	}
+	root, err := parseManifest(manifest)
+	if err != nil {
+		return nil, err
+	}
+	var app *axml.Element
+	for _, c := range root.Children {
+		if c.Name == "application" {
+			app = c
+		}
+	}
+	if app == nil {
+		return nil, errors.New("manifest has no application element")
+	}
+	storeLibs := false
+	extract := app.Attr(axml.NSAndroid, "extractNativeLibs")
+	if extract != nil && extract.Type == axml.TypeIntBool {
+		storeLibs = extract.Data == 0
+	}
+
+	var dex []byte
+	switch opts.Load {
+	case LoadProvider:
+		pkg := root.AttrValue("", "package")
+		if pkg == "" {
+			return nil, errors.New("manifest has no package")
+		}
+		for _, c := range app.Children {
+			if c.Name == "provider" && c.AttrValue(axml.NSAndroid, "name") == gadgetLoaderClass {
+				return nil, errors.New("a native library loader is already injected")
+			}
+		}
+		app.Children = append(app.Children, &axml.Element{
+			Name: "provider",
+			Attrs: []*axml.Attr{
+				{NS: axml.NSAndroid, Name: "name", ResID: 0x01010003, Type: axml.TypeString, Raw: gadgetLoaderClass},
+				{NS: axml.NSAndroid, Name: "exported", ResID: 0x01010010, Type: axml.TypeIntBool},
+				{NS: axml.NSAndroid, Name: "authorities", ResID: 0x01010018, Type: axml.TypeString, Raw: pkg + gadgetAuthority},
+			},
+		})
+		dex = loaderDex("L"+strings.ReplaceAll(gadgetLoaderClass, ".", "/")+";", opts.Name)
+	case LoadWrapSh:
+		if app.AttrValue(axml.NSAndroid, "debuggable") != "true" {
+			return nil, errors.New("wrap.sh only runs for debuggable apps")
+		}
+		if storeLibs {
+			extract.Data = 0xffffffff
+			storeLibs = false
+		}
+	default:
+		return nil, fmt.Errorf("unknown load method %d", opts.Load)
+	}
+
+	buf := new(bytes.Buffer)
+	buf.Write(a.apkRaw[:r.AppendOffset()])
+	w := r.Append(buf, true)
+	if err = merge(w, &MergeEntry{zip.ANDROIDMANIFEST, axml.Encode(root)}); err != nil {
+		return nil, err
+	}
+	if dex != nil {
+		if err = merge(w, &MergeEntry{nextDexName(r), dex}); err != nil {
+			return nil, err
+		}
+	}
+	add := func(name string, data []byte) error {
+		fh := &zip.FileHeader{Name: name, Method: zip.Deflate}
+		fh.SetMode(0o666)
+		var fw io.Writer
+		var err error
+		if storeLibs {
+			fh.Method = zip.Store
+			fw, err = w.CreateAlignedHeader(fh, PageSize16K)
+		} else {
+			fw, err = w.CreateHeader(fh)
+		}
+		if err == nil {
+			_, err = fw.Write(data)
+		}
+		return err
+	}
+	for _, abi := range abis {
+		dir := "lib/" + abi + "/lib" + opts.Name
+		if err = add(dir+".so", libs[abi]); err != nil {
+			return nil, err
+		}
+		if opts.Config != nil {
+			if err = add(dir+".config.so", opts.Config); err != nil {
+				return nil, err
+			}
+		}
+		if opts.Load == LoadWrapSh {
+			if err = add("lib/"+abi+"/wrap.sh", wrapSh(opts.Name)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	z, err := signv2.NewApkSignNoCopy(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return z.SignV2(a.signingCerts(), a.signOptions()...)
+}
+
+// nextDexName 返回 apk 中尚未使用的第一个 dex 名称, 如已有 classes.dex, classes2.dex 时为 classes3.dex
+func nextDexName(r *zip.Reader) string {
+	for i := 0; ; i++ {
+		name := dexName(i)
+		if !slices.ContainsFunc(r.File, func(f *zip.File) bool { return f.Name == name }) {
+			return name
+		}
+	}
+}
+
+// dexName 第 i 个 (从 0 开始) dex 的名称: classes.dex, classes2.dex ...
+func dexName(i int) string {
+	if i == 0 {
+		return "classes.dex"
+	}
+	return "classes" + strconv.Itoa(i+1) + ".dex"
+}
+
+// wrapSh 返回通过 LD_PRELOAD 加载 lib<name>.so 的 wrap.sh, 保留原有的 LD_PRELOAD
+func wrapSh(name string) []byte {
+	return []byte("#!/system/bin/sh\n" +
+		`export LD_PRELOAD="$(dirname "$0")/lib` + name + `.so${LD_PRELOAD:+:$LD_PRELOAD}"` + "\n" +
+		`exec "$@"` + "\n")
+}
@@ -0,0 +1,142 @@
+package editor
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"hash/adler32"
+	"io"
+	"slices"
+	"testing"
+
+	"github.com/pzx521521/apk-editor/editor/apktest"
+	"github.com/pzx521521/apk-editor/editor/axml"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+func TestLoaderDex(t *testing.T) {
+	dex := loaderDex("Lcom/example/Loader;", "frida-gadget")
+	le := binary.LittleEndian
+	if string(dex[:8]) != "dex\n035\x00" || int(le.Uint32(dex[32:])) != len(dex) {
+		t.Fatalf("bad header %q, size %d of %d", dex[:8], le.Uint32(dex[32:]), len(dex))
+	}
+	if sum := sha1.Sum(dex[32:]); !bytes.Equal(sum[:], dex[12:32]) {
+		t.Error("bad signature")
+	}
+	if le.Uint32(dex[8:]) != adler32.Checksum(dex[12:]) {
+		t.Error("bad checksum")
+	}
+	// 字符串须按顺序排列, 且 map_list 中各项偏移递增
+	var strs []string
+	n, off := int(le.Uint32(dex[56:])), int(le.Uint32(dex[60:]))
+	for i := range n {
+		p := int(le.Uint32(dex[off+4*i:]))
+		size, k := binary.Uvarint(dex[p:])
+		strs = append(strs, string(dex[p+k:p+k+int(size)]))
+	}
+	if !slices.IsSorted(strs) || !slices.Contains(strs, "frida-gadget") || !slices.Contains(strs, "Lcom/example/Loader;") {
+		t.Errorf("strings %q", strs)
+	}
+	mapOff := int(le.Uint32(dex[52:]))
+	last := -1
+	for i := range int(le.Uint32(dex[mapOff:])) {
+		item := dex[mapOff+4+12*i:]
+		if o := int(le.Uint32(item[8:])); o <= last || o >= len(dex) {
+			t.Errorf("map item %#x at %d after %d", le.Uint16(item), o, last)
+		} else {
+			last = o
+		}
+	}
+	if last != mapOff {
+		t.Errorf("last map item at %d, want map_list at %d", last, mapOff)
+	}
+}
+
+func TestInjectNativeLib(t *testing.T) {
+	key, err := apktest.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lib := testELF(PageSize16K)
+	for _, tt := range []struct {
+		name       string
+		debuggable bool
+		opts       NativeLibOptions
+		wantErr    bool
+		want       []string
+	}{
+		{"provider", false, NativeLibOptions{Config: []byte("{}")}, false,
+			[]string{"classes2.dex", "lib/arm64-v8a/libfrida-gadget.so", "lib/arm64-v8a/libfrida-gadget.config.so"}},
+		{"wrap.sh", true, NativeLibOptions{Name: "hook", Load: LoadWrapSh}, false,
+			[]string{"lib/arm64-v8a/libhook.so", "lib/arm64-v8a/wrap.sh"}},
+		{"wrap.sh release", false, NativeLibOptions{Load: LoadWrapSh}, true, nil},
+		{"bad name", false, NativeLibOptions{Name: "../x"}, true, nil},
+	} {
+		apk, err := apktest.Build(apktest.Config{Debuggable: tt.debuggable})
+		if err != nil {
+			t.Fatal(err)
+		}
+		a := NewApkEditor(apk, key.KeyBytes, key.CertBytes)
+		signed, err := a.InjectNativeLib(map[string][]byte{"arm64-v8a": lib}, tt.opts)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: no error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		z, err := signv2.NewApkSign(signed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = z.VerifyV2(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		r, err := zip.NewReader(bytes.NewReader(signed), int64(len(signed)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range tt.want {
+			if !slices.ContainsFunc(r.File, func(f *zip.File) bool { return f.Name == name }) {
+				t.Errorf("%s: %s missing", tt.name, name)
+			}
+		}
+		manifest, err := readManifest(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		root, err := axml.Parse(manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var provider *axml.Element
+		root.Walk(func(e *axml.Element) {
+			if e.Name == "provider" {
+				provider = e
+			}
+		})
+		if hasProvider := provider != nil; hasProvider != (tt.opts.Load == LoadProvider) {
+			t.Errorf("%s: provider %v", tt.name, provider)
+		} else if provider != nil {
+			if got := provider.AttrValue(axml.NSAndroid, "authorities"); got != apktest.DefaultPackage+gadgetAuthority {
+				t.Errorf("%s: authorities %q", tt.name, got)
+			}
+			if _, err = NewApkEditor(signed, key.KeyBytes, key.CertBytes).InjectNativeLib(map[string][]byte{"arm64-v8a": lib}, tt.opts); err == nil {
+				t.Errorf("%s: injected the loader twice", tt.name)
+			}
+		}
+		for _, f := range r.File {
+			if f.Name == "lib/arm64-v8a/wrap.sh" {
+				rc, _ := f.Open()
+				data, _ := io.ReadAll(rc)
+				rc.Close()
+				if !bytes.Contains(data, []byte("/libhook.so")) {
+					t.Errorf("wrap.sh = %q", data)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package editor
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+// EntryHash 一个 zip 条目解压后内容的摘要
+type EntryHash struct {
+	Name   string `json:"name"`
+	Size   uint64 `json:"size"`   // 解压后大小
+	SHA256 string `json:"sha256"` // 十六进制
+}
+
+// EntryHashes 按 zip 中的顺序计算每个条目的 SHA-256, 目录条目跳过.
+// 摘要针对解压后的内容, 与压缩级别和对齐无关, 适合用于制品证明和增量更新.
+func EntryHashes(apk []byte) (_ []*EntryHash, err error) {
+	defer catch(&err, "EntryHashes", "")
+	r, err := zip.NewReader(bytes.NewReader(apk), int64(len(apk)))
+	if err != nil {
+		return nil, err
+	}
+	var hashes []*EntryHash
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		h := sha256.New()
+		n, err := io.Copy(h, rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, &EntryHash{f.Name, uint64(n), hex.EncodeToString(h.Sum(nil))})
+	}
+	return hashes, nil
+}
+
+// HashManifest 以 JSON 数组输出 EntryHashes 的结果
+func HashManifest(apk []byte) ([]byte, error) {
+	hashes, err := EntryHashes(apk)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(hashes, "", "  ")
+}
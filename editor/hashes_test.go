@@ -0,0 +1,30 @@
+package editor
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestHashManifest(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	w.Create("assets/")
+	f, _ := w.Create("assets/a.txt")
+	f.Write([]byte("abc"))
+	w.Close()
+
+	b, err := HashManifest(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hashes []EntryHash
+	if err = json.Unmarshal(b, &hashes); err != nil {
+		t.Fatal(err)
+	}
+	want := EntryHash{"assets/a.txt", 3, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"}
+	if len(hashes) != 1 || hashes[0] != want {
+		t.Errorf("HashManifest = %s", b)
+	}
+}
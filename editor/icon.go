@@ -0,0 +1,232 @@
+package editor
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pzx521521/apk-editor/editor/arsc"
+	"github.com/pzx521521/apk-editor/editor/axml"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+// Density 屏幕密度 (dpi), 即资源配置中的 density
+type Density uint16
+
+const (
+	DensityDefault Density = 0 // 未指定密度的配置, 按 mdpi 处理
+	DensityLow     Density = 120
+	DensityMedium  Density = 160
+	DensityTV      Density = 213
+	DensityHigh    Density = 240
+	DensityXHigh   Density = 320
+	DensityXXHigh  Density = 480
+	DensityXXXHigh Density = 640
+	DensityAny     Density = 0xfffe // anydpi, 自适应图标所在的配置
+	DensityNone    Density = 0xffff // nodpi
+)
+
+var densityNames = map[Density]string{
+	DensityLow: "ldpi", DensityMedium: "mdpi", DensityTV: "tvdpi", DensityHigh: "hdpi",
+	DensityXHigh: "xhdpi", DensityXXHigh: "xxhdpi", DensityXXXHigh: "xxxhdpi",
+	DensityAny: "anydpi", DensityNone: "nodpi",
+}
+
+// String 返回资源目录的限定符, 如 hdpi, 未指定密度时为空
+func (d Density) String() string {
+	if name, ok := densityNames[d]; ok || d == DensityDefault {
+		return name
+	}
+	return strconv.Itoa(int(d)) + "dpi"
+}
+
+// ParseDensity 解析限定符 (hdpi) 或 dpi 数值 (240)
+func ParseDensity(s string) (Density, error) {
+	for d, name := range densityNames {
+		if s == name {
+			return d, nil
+		}
+	}
+	n, err := strconv.ParseUint(strings.TrimSuffix(s, "dpi"), 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid density %q", s)
+	}
+	return Density(n), nil
+}
+
+// dpi 选择图片时比较用的密度: 未指定按 mdpi, anydpi 和 nodpi 视为最高
+func (d Density) dpi() int {
+	switch d {
+	case DensityDefault:
+		return int(DensityMedium)
+	case DensityAny, DensityNone:
+		return 1 << 16
+	}
+	return int(d)
+}
+
+// IconFile 启动图标在一个配置下的文件
+type IconFile struct {
+	Resource uint32 // 值为该文件的资源, 图标属性引用别名时为别名指向的资源
+	Name     string // 条目名, 如 res/mipmap-hdpi-v4/ic_launcher.png
+	Density  Density
+}
+
+// XML 是否为 XML drawable, 如 mipmap-anydpi-v26 下的自适应图标 (adaptive-icon)
+func (f *IconFile) XML() bool {
+	return strings.HasSuffix(f.Name, ".xml")
+}
+
+// LauncherIcons 返回 application 和各 activity 的 android:icon 与 android:roundIcon 在各配置下的文件.
+// 资源间的引用会被跟随, 同一文件只返回一次
+func LauncherIcons(apk []byte) (_ []*IconFile, err error) {
+	defer catch(&err, "LauncherIcons", "")
+	r, err := zip.NewReader(bytes.NewReader(apk), int64(len(apk)))
+	if err != nil {
+		return nil, err
+	}
+	_, files, err := launcherIcons(r)
+	return files, err
+}
+
+// launcherIcons 实现 LauncherIcons, 同时返回解析的资源表
+func launcherIcons(r *zip.Reader) (*arsc.Table, []*IconFile, error) {
+	manifest, err := readManifest(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	root, err := parseManifest(manifest)
+	if err != nil {
+		return nil, nil, err
+	}
+	var ids []uint32
+	root.Walk(func(e *axml.Element) {
+		if e.Name != "application" && e.Name != "activity" && e.Name != "activity-alias" {
+			return
+		}
+		for _, name := range []string{"icon", "roundIcon"} {
+			if a := e.Attr(axml.NSAndroid, name); a != nil && a.Type == axml.TypeReference && !slices.Contains(ids, a.Data) {
+				ids = append(ids, a.Data)
+			}
+		}
+	})
+	table, err := resourceTable(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	var files []*IconFile
+	seen := map[string]bool{}
+	var add func(id uint32, depth int) error
+	add = func(id uint32, depth int) error {
+		// 别名最多跟随 8 层, 避免循环引用
+		if depth > 8 {
+			return fmt.Errorf("resource 0x%08x: too many references", id)
+		}
+		values, err := table.Values(id)
+		if err != nil {
+			return err
+		}
+		for _, v := range values {
+			switch {
+			case v.Type == arsc.TypeReference:
+				if err = add(v.Data, depth+1); err != nil {
+					return err
+				}
+			case v.Type == arsc.TypeString && !seen[v.String]:
+				seen[v.String] = true
+				files = append(files, &IconFile{id, v.String, Density(v.Density)})
+			}
+		}
+		return nil
+	}
+	for _, id := range ids {
+		// 系统图标 (@android:drawable/...) 不在 apk 中
+		if id>>24 == 0x01 {
+			continue
+		}
+		if err = add(id, 0); err != nil {
+			return nil, nil, entryError("resources.arsc", err)
+		}
+	}
+	return table, files, nil
+}
+
+// ReplaceIcon 编辑时用 icons 中的图片替换启动图标 (见 LauncherIcons), 一次完成更换图标.
+// 每个图片文件按密度使用 icons 中相同密度的图片, 没有时使用高于它的最接近的密度, 都低于它时使用最高的密度;
+// 条目名和压缩方式不变, 因此 .webp 等文件中也写入 PNG, 系统按内容解码.
+// XML 图标 (自适应图标, vector) 在 API 26 以上优先于各密度的图片, 它们在 resources.arsc 中改为引用
+// 同目录下新写入的 .png, 内容为 icons 中 anydpi 的图片, 没有时为最高密度的图片
+func (a *ApkEditor) ReplaceIcon(icons map[Density][]byte) (err error) {
+	defer catch(&err, "ReplaceIcon", "")
+	if len(icons) == 0 {
+		return errors.New("no icon given")
+	}
+	r, err := zip.NewReader(bytes.NewReader(a.apkRaw), int64(len(a.apkRaw)))
+	if err != nil {
+		return err
+	}
+	table, files, err := launcherIcons(r)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return errors.New("apk has no launcher icon")
+	}
+	// 各资源中 XML 文件的新名称
+	renamed := map[uint32]map[string]string{}
+	for _, f := range files {
+		if !f.XML() {
+			if err = a.ReplaceFile(f.Name, pickIcon(icons, f.Density)); err != nil {
+				return err
+			}
+			continue
+		}
+		png := strings.TrimSuffix(f.Name, ".xml") + ".png"
+		// XML 图标用于所有密度; 与 aapt2 相同, 图片不压缩
+		if err = a.AddFile(png, pickIcon(icons, DensityAny), false); err != nil {
+			return err
+		}
+		if renamed[f.Resource] == nil {
+			renamed[f.Resource] = map[string]string{}
+		}
+		renamed[f.Resource][f.Name] = png
+	}
+	if len(renamed) == 0 {
+		return nil
+	}
+	for id, names := range renamed {
+		err = table.Update(id, func(v arsc.Value) (arsc.Value, bool) {
+			png, ok := names[v.String]
+			if v.Type != arsc.TypeString || !ok {
+				return v, false
+			}
+			v.String = png
+			return v, true
+		})
+		if err != nil {
+			return entryError("resources.arsc", err)
+		}
+	}
+	return a.ReplaceFile("resources.arsc", table.Encode())
+}
+
+// pickIcon 选择用于密度 d 的图片, 见 ReplaceIcon
+func pickIcon(icons map[Density][]byte, d Density) []byte {
+	if b, ok := icons[d]; ok {
+		return b
+	}
+	keys := slices.SortedFunc(maps.Keys(icons), func(x, y Density) int {
+		return cmp.Or(cmp.Compare(x.dpi(), y.dpi()), cmp.Compare(x, y))
+	})
+	for _, k := range keys {
+		if k.dpi() >= d.dpi() {
+			return icons[k]
+		}
+	}
+	return icons[keys[len(keys)-1]]
+}
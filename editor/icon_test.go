@@ -0,0 +1,122 @@
+package editor
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+
+	"github.com/pzx521521/apk-editor/editor/apktest"
+	"github.com/pzx521521/apk-editor/editor/arsc"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+func TestReplaceIcon(t *testing.T) {
+	apk, err := apktest.Build(apktest.Config{Icons: map[string][]byte{
+		"res/mipmap-hdpi-v4/ic_launcher.png":    []byte("old hdpi"),
+		"res/mipmap-xxhdpi-v4/ic_launcher.webp": []byte("old xxhdpi"),
+		"res/mipmap-anydpi-v26/ic_launcher.xml": []byte("<adaptive-icon/>"),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	icons, err := LauncherIcons(apk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(icons) != 3 || icons[0].Resource != apktest.IconID {
+		t.Fatalf("LauncherIcons = %v", icons)
+	}
+	for _, f := range icons {
+		if want := map[string]Density{"res/mipmap-hdpi-v4/ic_launcher.png": DensityHigh,
+			"res/mipmap-xxhdpi-v4/ic_launcher.webp": DensityXXHigh,
+			"res/mipmap-anydpi-v26/ic_launcher.xml": DensityAny}[f.Name]; f.Density != want {
+			t.Errorf("%s: density %v, want %v", f.Name, f.Density, want)
+		}
+	}
+
+	key, err := apktest.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := NewApkEditor(apk, key.KeyBytes, key.CertBytes)
+	if err = a.ReplaceIcon(map[Density][]byte{DensityHigh: []byte("new hdpi"), DensityXXXHigh: []byte("new xxxhdpi")}); err != nil {
+		t.Fatal(err)
+	}
+	out, err := a.Edit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := zip.NewReader(bytes.NewReader(out), int64(len(out)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]*zip.File{}
+	for _, f := range r.File {
+		got[f.Name] = f
+	}
+	// 没有 xxhdpi 时使用更高的 xxxhdpi, 自适应图标改为引用最高密度的 png
+	for name, want := range map[string]string{
+		"res/mipmap-hdpi-v4/ic_launcher.png":    "new hdpi",
+		"res/mipmap-xxhdpi-v4/ic_launcher.webp": "new xxxhdpi",
+		"res/mipmap-anydpi-v26/ic_launcher.png": "new xxxhdpi",
+	} {
+		f := got[name]
+		if f == nil {
+			t.Errorf("%s missing", name)
+			continue
+		}
+		b, err := readEntry(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != want || f.Method != zip.Store {
+			t.Errorf("%s: %q, method %d", name, b, f.Method)
+		}
+	}
+	b, err := readEntry(got["resources.arsc"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err := arsc.Parse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := table.Values(apktest.IconID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, v := range values {
+		names = append(names, v.String)
+	}
+	slices.Sort(names)
+	want := []string{"res/mipmap-anydpi-v26/ic_launcher.png", "res/mipmap-hdpi-v4/ic_launcher.png", "res/mipmap-xxhdpi-v4/ic_launcher.webp"}
+	if !slices.Equal(names, want) {
+		t.Errorf("icon values %q, want %q", names, want)
+	}
+
+	plain, err := apktest.Build(apktest.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = NewApkEditor(plain, nil, nil).ReplaceIcon(map[Density][]byte{DensityHigh: nil}); err == nil {
+		t.Error("ReplaceIcon accepted an apk without an icon")
+	}
+}
+
+func TestPickIcon(t *testing.T) {
+	icons := map[Density][]byte{DensityMedium: []byte("m"), DensityXHigh: []byte("xh")}
+	for d, want := range map[Density]string{
+		DensityDefault: "m", DensityLow: "m", DensityHigh: "xh", DensityXXXHigh: "xh", DensityAny: "xh",
+	} {
+		if got := string(pickIcon(icons, d)); got != want {
+			t.Errorf("%v: got %s, want %s", d, got, want)
+		}
+	}
+	if d, err := ParseDensity("xxhdpi"); err != nil || d != DensityXXHigh {
+		t.Errorf("ParseDensity(xxhdpi) = %v, %v", d, err)
+	}
+	if d, err := ParseDensity("400"); err != nil || d != 400 || d.String() != "400dpi" {
+		t.Errorf("ParseDensity(400) = %v, %v", d, err)
+	}
+}
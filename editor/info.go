@@ -0,0 +1,240 @@
+package editor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pzx521521/apk-editor/editor/arsc"
+	"github.com/pzx521521/apk-editor/editor/axml"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+// 组件类型, 即 manifest 中的元素名
+const (
+	ComponentActivity      = "activity"
+	ComponentActivityAlias = "activity-alias"
+	ComponentService       = "service"
+	ComponentReceiver      = "receiver"
+	ComponentProvider      = "provider"
+)
+
+// Info apk 的概要信息, 相当于 aapt dump badging
+type Info struct {
+	Package     string `json:"package"`
+	VersionCode int64  `json:"version_code"` // 含 versionCodeMajor (高 32 位)
+	VersionName string `json:"version_name,omitempty"`
+	MinSDK      int    `json:"min_sdk"`    // 未声明时为 1
+	TargetSDK   int    `json:"target_sdk"` // 未声明时等于 MinSDK
+	// Label 应用名称; 引用资源时取 resources.arsc 中第一个配置 (通常为默认语言) 的字符串
+	Label       string       `json:"label,omitempty"`
+	Debuggable  bool         `json:"debuggable,omitempty"`
+	Permissions []string     `json:"permissions,omitempty"` // uses-permission, 按名称排序
+	Components  []*Component `json:"components,omitempty"`
+	NativeCode  []string     `json:"native_code,omitempty"` // lib/ 下的 ABI
+	// Schemes 验证通过的签名方案, 未签名时为空
+	Schemes []signv2.Scheme `json:"schemes"`
+	Signers []*SignerInfo   `json:"signers"`
+	// DebugSigned 任一方案 (含 v1) 的签名者使用调试证书, 见 signv2.IsDebugCert
+	DebugSigned bool `json:"debug_signed,omitempty"`
+}
+
+// Component 四大组件之一
+type Component struct {
+	Kind     string `json:"kind"` // ComponentActivity 等
+	Name     string `json:"name"` // 完整类名, 以 . 开头的相对名称已补全包名
+	Exported bool   `json:"exported"`
+	Launcher bool   `json:"launcher,omitempty"` // 响应 MAIN/LAUNCHER, 即桌面图标对应的 activity
+}
+
+// SignerInfo 签名者的证书
+type SignerInfo struct {
+	Scheme  signv2.Scheme `json:"scheme"`
+	Subject string        `json:"subject"`
+	SHA256  string        `json:"sha256"` // 证书的 SHA-256 指纹
+}
+
+// LaunchableActivity 返回第一个启动 activity 的类名, 没有时为空
+func (i *Info) LaunchableActivity() string {
+	for _, c := range i.Components {
+		if c.Launcher {
+			return c.Name
+		}
+	}
+	return ""
+}
+
+// ApkInfo 读取 apk 的包名, 版本, SDK 版本, 权限, 应用名称, 组件和签名, 代替调用 aapt dump badging
+func ApkInfo(apk []byte) (_ *Info, err error) {
+	defer catch(&err, "ApkInfo", "")
+	r, err := zip.NewReader(bytes.NewReader(apk), int64(len(apk)))
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := readManifest(r)
+	if err != nil {
+		return nil, err
+	}
+	root, err := parseManifest(manifest)
+	if err != nil {
+		return nil, err
+	}
+	sdk := manifestSDKVersions(root)
+	info := &Info{
+		Package:     root.AttrValue("", "package"),
+		VersionName: root.AttrValue(axml.NSAndroid, "versionName"),
+		MinSDK:      sdk.Min,
+		TargetSDK:   sdk.Target,
+	}
+	if a := root.Attr(axml.NSAndroid, "versionCode"); a != nil {
+		info.VersionCode = int64(a.Data)
+	}
+	if a := root.Attr(axml.NSAndroid, "versionCodeMajor"); a != nil {
+		info.VersionCode |= int64(a.Data) << 32
+	}
+	for _, e := range root.Children {
+		switch e.Name {
+		case "uses-permission", "uses-permission-sdk-23", "uses-permission-sdk-m":
+			if name := e.AttrValue(axml.NSAndroid, "name"); name != "" && !slices.Contains(info.Permissions, name) {
+				info.Permissions = append(info.Permissions, name)
+			}
+		case "application":
+			info.Debuggable = e.AttrValue(axml.NSAndroid, "debuggable") == "true"
+			if info.Label, err = appLabel(r, e.Attr(axml.NSAndroid, "label")); err != nil {
+				return nil, err
+			}
+			info.Components = components(e, info.Package, info.TargetSDK)
+		}
+	}
+	slices.Sort(info.Permissions)
+	for _, f := range r.File {
+		if category, abi := categorize(f.Name); category == CategoryNative && abi != "" && !slices.Contains(info.NativeCode, abi) {
+			info.NativeCode = append(info.NativeCode, abi)
+		}
+	}
+	slices.Sort(info.NativeCode)
+
+	z, err := signv2.NewApkSignNoCopy(apk)
+	if err != nil {
+		return nil, err
+	}
+	res := z.VerifyAll(signv2.VerifyOptions{})
+	for _, s := range res.Schemes {
+		if s.Verified {
+			info.Schemes = append(info.Schemes, s.Scheme)
+		}
+	}
+	for _, s := range res.Signers {
+		if len(s.Certs) > 0 {
+			info.Signers = append(info.Signers, &SignerInfo{s.Scheme, s.Certs[0].Subject.String(), s.SHA256})
+		}
+	}
+	info.DebugSigned = res.DebugSigned
+	return info, nil
+}
+
+// appLabel 返回 android:label 的字符串, 引用资源时从 resources.arsc 读取, 跟随资源间的引用
+func appLabel(r *zip.Reader, a *axml.Attr) (string, error) {
+	switch {
+	case a == nil:
+		return "", nil
+	case a.Type != axml.TypeReference:
+		return a.Value(), nil
+	case a.Data>>24 == 0x01:
+		// 系统字符串 (@android:string/...) 不在 apk 中
+		return a.Value(), nil
+	}
+	table, err := resourceTable(r)
+	if err != nil {
+		return "", err
+	}
+	id := a.Data
+	// 别名最多跟随 8 层, 避免循环引用
+	for range 8 {
+		values, err := table.Values(id)
+		if err != nil {
+			return "", entryError("resources.arsc", err)
+		}
+		if len(values) == 0 {
+			break
+		}
+		switch v := values[0]; v.Type {
+		case arsc.TypeString:
+			return v.String, nil
+		case arsc.TypeReference:
+			id = v.Data
+			continue
+		}
+		break
+	}
+	return fmt.Sprintf("@0x%08x", id), nil
+}
+
+// resourceTable 读取并解析 resources.arsc
+func resourceTable(r *zip.Reader) (*arsc.Table, error) {
+	i := slices.IndexFunc(r.File, func(f *zip.File) bool { return f.Name == "resources.arsc" })
+	if i < 0 {
+		return nil, errors.New("no resources.arsc found")
+	}
+	b, err := readEntry(r.File[i])
+	if err != nil {
+		return nil, entryError("resources.arsc", err)
+	}
+	table, err := arsc.Parse(b)
+	if err != nil {
+		return nil, entryError("resources.arsc", err)
+	}
+	return table, nil
+}
+
+// components 列出 application 下的组件. 未声明 android:exported 时按平台的默认规则:
+// 有 intent-filter 的组件导出, provider 在 targetSdkVersion 低于 17 时导出
+func components(app *axml.Element, pkg string, targetSDK int) []*Component {
+	var list []*Component
+	for _, e := range app.Children {
+		switch e.Name {
+		case ComponentActivity, ComponentActivityAlias, ComponentService, ComponentReceiver, ComponentProvider:
+		default:
+			continue
+		}
+		c := &Component{Kind: e.Name, Name: className(pkg, e.AttrValue(axml.NSAndroid, "name"))}
+		hasFilter := false
+		for _, f := range e.Children {
+			if f.Name != "intent-filter" {
+				continue
+			}
+			hasFilter = true
+			var action, category bool
+			for _, x := range f.Children {
+				name := x.AttrValue(axml.NSAndroid, "name")
+				action = action || x.Name == "action" && name == "android.intent.action.MAIN"
+				category = category || x.Name == "category" && name == "android.intent.category.LAUNCHER"
+			}
+			c.Launcher = c.Launcher || action && category && (e.Name == ComponentActivity || e.Name == ComponentActivityAlias)
+		}
+		switch exported := e.AttrValue(axml.NSAndroid, "exported"); {
+		case exported != "":
+			c.Exported = exported == "true"
+		case e.Name == ComponentProvider:
+			c.Exported = targetSDK < 17
+		default:
+			c.Exported = hasFilter
+		}
+		list = append(list, c)
+	}
+	return list
+}
+
+// className 按 PackageParser 的规则补全类名: 以 . 开头或不含 . 的名称相对于包名
+func className(pkg, name string) string {
+	switch {
+	case strings.HasPrefix(name, "."):
+		return pkg + name
+	case name != "" && !strings.Contains(name, "."):
+		return pkg + "." + name
+	}
+	return name
+}
@@ -0,0 +1,121 @@
+package editor
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/pzx521521/apk-editor/editor/apktest"
+	"github.com/pzx521521/apk-editor/editor/axml"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+)
+
+func TestApkInfo(t *testing.T) {
+	apk, err := apktest.BuildSigned(apktest.Config{
+		VersionCode: 42,
+		VersionName: "4.2",
+		MinSDK:      24,
+		TargetSDK:   34,
+		Label:       "Info",
+		Permissions: []string{"android.permission.INTERNET", "android.permission.CAMERA"},
+		Debuggable:  true,
+		Files:       map[string][]byte{"lib/arm64-v8a/libfoo.so": testELF(PageSize16K)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := ApkInfo(apk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Package != apktest.DefaultPackage || info.VersionCode != 42 || info.VersionName != "4.2" {
+		t.Errorf("got package %s version %d %q", info.Package, info.VersionCode, info.VersionName)
+	}
+	if info.MinSDK != 24 || info.TargetSDK != 34 || info.Label != "Info" || !info.Debuggable {
+		t.Errorf("got sdk %d/%d label %q debuggable %v", info.MinSDK, info.TargetSDK, info.Label, info.Debuggable)
+	}
+	if want := []string{"android.permission.CAMERA", "android.permission.INTERNET"}; !slices.Equal(info.Permissions, want) {
+		t.Errorf("got permissions %v, want %v", info.Permissions, want)
+	}
+	if !slices.Equal(info.NativeCode, []string{"arm64-v8a"}) {
+		t.Errorf("got native code %v", info.NativeCode)
+	}
+	if !slices.Equal(info.Schemes, []signv2.Scheme{signv2.SchemeV2}) || len(info.Signers) != 1 || info.Signers[0].Subject != "CN=apktest" {
+		t.Errorf("got schemes %v signers %d", info.Schemes, len(info.Signers))
+	}
+	if info.DebugSigned {
+		t.Error("apktest key reported as debug")
+	}
+	if _, err = json.Marshal(info); err != nil {
+		t.Error(err)
+	}
+
+	debug, err := signv2.GenerateDebugCert("", 0, signv2.RSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if apk, err = apktest.BuildSigned(apktest.Config{}, debug); err != nil {
+		t.Fatal(err)
+	}
+	if info, err = ApkInfo(apk); err != nil {
+		t.Fatal(err)
+	}
+	if !info.DebugSigned {
+		t.Error("debug certificate not reported")
+	}
+
+	unsigned, err := apktest.Build(apktest.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err = ApkInfo(unsigned); err != nil {
+		t.Fatal(err)
+	}
+	if len(info.Schemes) != 0 || info.MinSDK != 1 || info.Label != "Test" {
+		t.Errorf("unsigned: got schemes %v min sdk %d label %q", info.Schemes, info.MinSDK, info.Label)
+	}
+}
+
+func TestComponents(t *testing.T) {
+	attr := func(name string, typ uint8, data uint32, raw string) *axml.Attr {
+		return &axml.Attr{NS: axml.NSAndroid, Name: name, Type: typ, Data: data, Raw: raw}
+	}
+	named := func(kind, name string, children ...*axml.Element) *axml.Element {
+		return &axml.Element{Name: kind, Attrs: []*axml.Attr{attr("name", axml.TypeString, 0, name)}, Children: children}
+	}
+	launcher := &axml.Element{Name: "intent-filter", Children: []*axml.Element{
+		named("action", "android.intent.action.MAIN"),
+		named("category", "android.intent.category.LAUNCHER"),
+	}}
+	hidden := named(ComponentService, "com.other.Sync")
+	hidden.Attrs = append(hidden.Attrs, attr("exported", axml.TypeIntBool, 0, ""))
+	app := &axml.Element{Name: "application", Children: []*axml.Element{
+		named(ComponentActivity, ".Main", launcher),
+		hidden,
+		named(ComponentReceiver, "Boot", &axml.Element{Name: "intent-filter"}),
+		named(ComponentProvider, ".Files"),
+		named("meta-data", "x"),
+	}}
+	got := components(app, "com.example", 16)
+	want := []Component{
+		{ComponentActivity, "com.example.Main", true, true},
+		{ComponentService, "com.other.Sync", false, false},
+		{ComponentReceiver, "com.example.Boot", true, false},
+		{ComponentProvider, "com.example.Files", true, false},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d components, want %d", len(got), len(want))
+	}
+	for i, c := range got {
+		if *c != want[i] {
+			t.Errorf("component %d: got %+v, want %+v", i, *c, want[i])
+		}
+	}
+	if components(app, "com.example", 17)[3].Exported {
+		t.Error("provider exported by default at target sdk 17")
+	}
+	info := &Info{Components: got}
+	if a := info.LaunchableActivity(); a != "com.example.Main" {
+		t.Errorf("got launchable activity %q", a)
+	}
+}
@@ -0,0 +1,109 @@
+// Package protowire implements the small subset of the protobuf wire format
+// needed by the gRPC service, so the editor does not depend on the protobuf
+// runtime.
+package protowire
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Wire types.
+const (
+	VarintType  = 0
+	Fixed64Type = 1
+	BytesType   = 2
+	Fixed32Type = 5
+)
+
+var ErrTruncated = errors.New("protowire: truncated message")
+
+// Field is one decoded key/value pair. Varint holds the value of varint and
+// fixed-width fields, Bytes the payload of length-delimited ones.
+type Field struct {
+	Num    int
+	Type   int
+	Varint uint64
+	Bytes  []byte
+}
+
+func AppendVarint(b []byte, v uint64) []byte {
+	return binary.AppendUvarint(b, v)
+}
+
+func AppendTag(b []byte, num, typ int) []byte {
+	return AppendVarint(b, uint64(num)<<3|uint64(typ))
+}
+
+func AppendBytes(b []byte, num int, v []byte) []byte {
+	b = AppendTag(b, num, BytesType)
+	b = AppendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func AppendString(b []byte, num int, s string) []byte {
+	return AppendBytes(b, num, []byte(s))
+}
+
+func AppendUint(b []byte, num int, v uint64) []byte {
+	return AppendVarint(AppendTag(b, num, VarintType), v)
+}
+
+func AppendBool(b []byte, num int, v bool) []byte {
+	if !v {
+		return AppendUint(b, num, 0)
+	}
+	return AppendUint(b, num, 1)
+}
+
+// Next decodes the first field of b and returns it with the remaining bytes.
+func Next(b []byte) (Field, []byte, error) {
+	var f Field
+	key, n := binary.Uvarint(b)
+	if n <= 0 {
+		return f, nil, ErrTruncated
+	}
+	b = b[n:]
+	f.Num, f.Type = int(key>>3), int(key&7)
+	switch f.Type {
+	case VarintType:
+		f.Varint, n = binary.Uvarint(b)
+		if n <= 0 {
+			return f, nil, ErrTruncated
+		}
+		b = b[n:]
+	case Fixed64Type:
+		if len(b) < 8 {
+			return f, nil, ErrTruncated
+		}
+		f.Varint, b = binary.LittleEndian.Uint64(b), b[8:]
+	case Fixed32Type:
+		if len(b) < 4 {
+			return f, nil, ErrTruncated
+		}
+		f.Varint, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+	case BytesType:
+		l, n := binary.Uvarint(b)
+		if n <= 0 || l > uint64(len(b)-n) {
+			return f, nil, ErrTruncated
+		}
+		f.Bytes, b = b[n:n+int(l)], b[n+int(l):]
+	default:
+		return f, nil, errors.New("protowire: unsupported wire type")
+	}
+	return f, b, nil
+}
+
+// Fields decodes every field of a message.
+func Fields(b []byte) ([]Field, error) {
+	var fields []Field
+	for len(b) > 0 {
+		f, rest, err := Next(b)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+		b = rest
+	}
+	return fields, nil
+}
@@ -0,0 +1,103 @@
+// Package restable walks the chunks of resources.arsc, the ResTable format, for the packages that
+// read or rewrite resource tables. Every size, count and offset read from the data is checked
+// against it, so that a hostile table yields ErrTruncated rather than a panic.
+package restable
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// ErrTruncated is returned for a chunk too short for the sizes, counts or offsets it declares.
+var ErrTruncated = errors.New("truncated resources.arsc")
+
+const (
+	// flags of a ResTable_type
+	typeSparse   = 0x01
+	typeOffset16 = 0x02
+
+	// entryCompact is the flag of a ResTable_entry in the compact format, which keeps the key
+	// where the size of the other formats is
+	entryCompact = 0x0008
+
+	noEntry   = 0xffffffff
+	noEntry16 = 0xffff
+
+	// typeHeader is the ResTable_type header up to entriesStart
+	typeHeader = 20
+	// entryHeader is the smallest ResTable_entry
+	entryHeader = 8
+)
+
+// Chunks calls fn for every chunk of b from off on, with its offset in b, its type and its bytes.
+func Chunks(b []byte, off int, fn func(off int, typ uint16, chunk []byte) error) error {
+	if off < 0 {
+		return ErrTruncated
+	}
+	for off+8 <= len(b) {
+		size := int(binary.LittleEndian.Uint32(b[off+4:]))
+		if size < 8 || size > len(b)-off {
+			return ErrTruncated
+		}
+		if err := fn(off, binary.LittleEndian.Uint16(b[off:]), b[off:off+size]); err != nil {
+			return err
+		}
+		off += size
+	}
+	return nil
+}
+
+// TypeEntries calls fn with the index and the bytes from the start of every entry of the type chunk
+// c, whether its offsets are 32-bit, 16-bit or sparse. Each e holds at least an entry header.
+func TypeEntries(c []byte, fn func(entry int, e []byte) error) error {
+	if len(c) < typeHeader {
+		return ErrTruncated
+	}
+	hdr := int(binary.LittleEndian.Uint16(c[2:]))
+	flags := c[9]
+	count := int(binary.LittleEndian.Uint32(c[12:]))
+	start := int(binary.LittleEndian.Uint32(c[16:]))
+	// sparse offsets are (index, offset/4) pairs of 16 bits whatever typeOffset16 says
+	width := 4
+	if flags&typeOffset16 != 0 && flags&typeSparse == 0 {
+		width = 2
+	}
+	if hdr > len(c) || count > (len(c)-hdr)/width || start > len(c) {
+		return ErrTruncated
+	}
+	for i := range count {
+		entry, off := i, 0
+		switch {
+		case flags&typeSparse != 0:
+			entry = int(binary.LittleEndian.Uint16(c[hdr+4*i:]))
+			off = 4 * int(binary.LittleEndian.Uint16(c[hdr+4*i+2:]))
+		case width == 2:
+			o := binary.LittleEndian.Uint16(c[hdr+2*i:])
+			if o == noEntry16 {
+				continue
+			}
+			off = 4 * int(o)
+		default:
+			o := binary.LittleEndian.Uint32(c[hdr+4*i:])
+			if o == noEntry {
+				continue
+			}
+			off = int(o)
+		}
+		if off > len(c)-start-entryHeader {
+			return ErrTruncated
+		}
+		if err := fn(entry, c[start+off:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EntryKey returns the key string index of the entry e, compact or not.
+func EntryKey(e []byte) uint32 {
+	if binary.LittleEndian.Uint16(e[2:])&entryCompact != 0 {
+		return uint32(binary.LittleEndian.Uint16(e))
+	}
+	return binary.LittleEndian.Uint32(e[4:])
+}
@@ -0,0 +1,115 @@
+package restable
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// typeChunk builds a ResTable_type with the given flags, offsets and entries.
+func typeChunk(flags uint8, offsets []byte, entries []byte) []byte {
+	start := typeHeader + len(offsets)
+	c := binary.LittleEndian.AppendUint16(nil, 0x0201)
+	c = binary.LittleEndian.AppendUint16(c, typeHeader)
+	c = binary.LittleEndian.AppendUint32(c, uint32(start+len(entries)))
+	c = append(c, 1, flags, 0, 0)
+	width := 4
+	if flags&typeOffset16 != 0 && flags&typeSparse == 0 {
+		width = 2
+	}
+	c = binary.LittleEndian.AppendUint32(c, uint32(len(offsets)/width))
+	c = binary.LittleEndian.AppendUint32(c, uint32(start))
+	return append(append(c, offsets...), entries...)
+}
+
+// entry is a ResTable_entry with key k and no value.
+func entry(k uint32) []byte {
+	return binary.LittleEndian.AppendUint32([]byte{8, 0, 0, 0}, k)
+}
+
+func TestTypeEntries(t *testing.T) {
+	u16 := func(vs ...uint16) (b []byte) {
+		for _, v := range vs {
+			b = binary.LittleEndian.AppendUint16(b, v)
+		}
+		return b
+	}
+	u32 := func(vs ...uint32) (b []byte) {
+		for _, v := range vs {
+			b = binary.LittleEndian.AppendUint32(b, v)
+		}
+		return b
+	}
+	entries := append(entry(7), entry(9)...)
+	for _, tt := range []struct {
+		name    string
+		c       []byte
+		indices []int
+	}{
+		{"dense", typeChunk(0, u32(0, noEntry, 8), entries), []int{0, 2}},
+		{"offset16", typeChunk(typeOffset16, u16(noEntry16, 0, 2), entries), []int{1, 2}},
+		{"sparse", typeChunk(typeSparse, u16(3, 0, 40, 2), entries), []int{3, 40}},
+		// both flags: sparse pairs are 4 bytes, the count must not be checked against 2
+		{"sparse offset16", typeChunk(typeSparse|typeOffset16, u16(3, 0, 40, 2), entries), []int{3, 40}},
+	} {
+		var indices []int
+		var keys []uint32
+		err := TypeEntries(tt.c, func(i int, e []byte) error {
+			indices, keys = append(indices, i), append(keys, EntryKey(e))
+			return nil
+		})
+		if err != nil || len(indices) != 2 || indices[0] != tt.indices[0] || indices[1] != tt.indices[1] || keys[0] != 7 || keys[1] != 9 {
+			t.Errorf("%s: got entries %v keys %v, %v", tt.name, indices, keys, err)
+		}
+	}
+
+	for _, tt := range []struct {
+		name string
+		c    []byte
+	}{
+		{"short header", make([]byte, 12)},
+		{"entry past the end", typeChunk(0, u32(12), entries)},
+		{"count past the end", func() []byte {
+			c := typeChunk(0, u32(0), entries)
+			binary.LittleEndian.PutUint32(c[12:], 1<<30)
+			return c
+		}()},
+		{"sparse count doubled by offset16", func() []byte {
+			c := typeChunk(typeSparse|typeOffset16, u16(3, 0), entries)
+			binary.LittleEndian.PutUint32(c[12:], 6)
+			return c
+		}()},
+	} {
+		if err := TypeEntries(tt.c, func(int, []byte) error { return nil }); !errors.Is(err, ErrTruncated) {
+			t.Errorf("%s: got %v, want ErrTruncated", tt.name, err)
+		}
+	}
+}
+
+func TestChunks(t *testing.T) {
+	chunk := func(typ uint16, size uint32) []byte {
+		c := binary.LittleEndian.AppendUint16(nil, typ)
+		c = binary.LittleEndian.AppendUint16(c, 8)
+		return append(binary.LittleEndian.AppendUint32(c, size), make([]byte, max(int(size), 8)-8)...)
+	}
+	b := append(chunk(1, 8), chunk(2, 12)...)
+	var offs []int
+	err := Chunks(b, 0, func(off int, typ uint16, c []byte) error {
+		if int(typ) != len(offs)+1 {
+			t.Errorf("chunk at %d: type %d", off, typ)
+		}
+		offs = append(offs, off)
+		return nil
+	})
+	if err != nil || len(offs) != 2 || offs[1] != 8 {
+		t.Errorf("got offsets %v, %v", offs, err)
+	}
+	for _, bad := range [][]byte{chunk(1, 4), append(chunk(1, 8), chunk(2, 1<<31)[:8]...)} {
+		if err := Chunks(bad, 0, func(int, uint16, []byte) error { return nil }); !errors.Is(err, ErrTruncated) {
+			t.Errorf("Chunks(%x): got %v, want ErrTruncated", bad, err)
+		}
+	}
+	if err := Chunks(b, -1, func(int, uint16, []byte) error { return nil }); !errors.Is(err, ErrTruncated) {
+		t.Errorf("negative offset: got %v", err)
+	}
+}
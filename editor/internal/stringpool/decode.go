@@ -0,0 +1,113 @@
+package stringpool
+
+import (
+	"encoding/binary"
+	"errors"
+	"unicode/utf16"
+)
+
+// ErrTruncated is returned by Decode for a chunk too short for the
+// offsets and lengths it declares.
+var ErrTruncated = errors.New("truncated chunk")
+
+// Decode parses a string pool chunk and returns its strings and, for the
+// styled ones at its start, their spans.
+func Decode(chunk []byte) (strs []string, spans [][]Span, err error) {
+	if len(chunk) < headerSize {
+		return nil, nil, ErrTruncated
+	}
+	hdr := int(binary.LittleEndian.Uint16(chunk[2:]))
+	count := int(binary.LittleEndian.Uint32(chunk[8:]))
+	styleCount := int(binary.LittleEndian.Uint32(chunk[12:]))
+	flags := binary.LittleEndian.Uint32(chunk[16:])
+	start := int(binary.LittleEndian.Uint32(chunk[20:]))
+	stylesStart := int(binary.LittleEndian.Uint32(chunk[24:]))
+	utf8 := flags&flagUTF8 != 0
+	if count > len(chunk)/4 || styleCount > count || hdr+(count+styleCount)*4 > len(chunk) || start > len(chunk) {
+		return nil, nil, ErrTruncated
+	}
+
+	strs = make([]string, count)
+	for i := range strs {
+		off := start + int(binary.LittleEndian.Uint32(chunk[hdr+i*4:]))
+		if off < 0 || off >= len(chunk) {
+			return nil, nil, ErrTruncated
+		}
+		if strs[i], err = decodeString(chunk[off:], utf8); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	spans = make([][]Span, styleCount)
+	for i := range spans {
+		off := stylesStart + int(binary.LittleEndian.Uint32(chunk[hdr+(count+i)*4:]))
+		for {
+			if off < 0 || off+4 > len(chunk) {
+				return nil, nil, ErrTruncated
+			}
+			name := binary.LittleEndian.Uint32(chunk[off:])
+			if name == spanEnd {
+				break
+			}
+			if off+12 > len(chunk) || name >= uint32(count) {
+				return nil, nil, ErrTruncated
+			}
+			spans[i] = append(spans[i], Span{
+				Tag:   strs[name],
+				First: binary.LittleEndian.Uint32(chunk[off+4:]),
+				Last:  binary.LittleEndian.Uint32(chunk[off+8:]),
+			})
+			off += 12
+		}
+	}
+	return strs, spans, nil
+}
+
+func decodeString(b []byte, utf8 bool) (string, error) {
+	if utf8 {
+		// UTF-16 长度, UTF-8 长度, 各占 1 或 2 字节
+		_, b, ok := utf8Len(b)
+		if !ok {
+			return "", ErrTruncated
+		}
+		n, b, ok := utf8Len(b)
+		if !ok || n > len(b) {
+			return "", ErrTruncated
+		}
+		return string(b[:n]), nil
+	}
+	if len(b) < 2 {
+		return "", ErrTruncated
+	}
+	n := int(binary.LittleEndian.Uint16(b))
+	b = b[2:]
+	if n&0x8000 != 0 {
+		if len(b) < 2 {
+			return "", ErrTruncated
+		}
+		n = (n&0x7fff)<<16 | int(binary.LittleEndian.Uint16(b))
+		b = b[2:]
+	}
+	if n*2 > len(b) {
+		return "", ErrTruncated
+	}
+	u := make([]uint16, n)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(b[i*2:])
+	}
+	return string(utf16.Decode(u)), nil
+}
+
+func utf8Len(b []byte) (int, []byte, bool) {
+	if len(b) < 1 {
+		return 0, nil, false
+	}
+	n := int(b[0])
+	if n&0x80 == 0 {
+		return n, b[1:], true
+	}
+	if len(b) < 2 {
+		return 0, nil, false
+	}
+	return (n&0x7f)<<8 | int(b[1]), b[2:], true
+}
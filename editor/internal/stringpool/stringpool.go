@@ -0,0 +1,163 @@
+// Package stringpool builds the ResStringPool chunk shared by binary XML
+// documents and resources.arsc.
+package stringpool
+
+import (
+	"encoding/binary"
+	"unicode/utf16"
+)
+
+const (
+	chunkType  = 0x0001
+	headerSize = 28
+	flagUTF8   = 1 << 8
+	spanEnd    = 0xffffffff
+)
+
+// Span marks the characters First to Last, inclusive and counted in UTF-16
+// units, of a styled string as enclosed in Tag, e.g. "b" or "font;size=12".
+type Span struct {
+	Tag         string
+	First, Last uint32
+}
+
+// Pool collects strings and assigns them indices in order of addition.
+// Styled strings must all be added before any plain string, since the
+// format stores them first.
+type Pool struct {
+	strs  []string
+	spans [][]Span
+	index map[string]uint32
+}
+
+// Add returns the index of s, adding it if the pool has no plain string s.
+func (p *Pool) Add(s string) uint32 {
+	if i, ok := p.index[s]; ok {
+		return i
+	}
+	if p.index == nil {
+		p.index = map[string]uint32{}
+	}
+	i := uint32(len(p.strs))
+	p.strs = append(p.strs, s)
+	p.index[s] = i
+	return i
+}
+
+// Append adds s as a new string and returns its index. Add does not return
+// strings added this way, so that they can be kept apart, e.g. the attribute
+// names matched to the resource map of a binary XML document.
+func (p *Pool) Append(s string) uint32 {
+	p.strs = append(p.strs, s)
+	return uint32(len(p.strs) - 1)
+}
+
+// AddStyled adds s with its spans and returns its index. It panics if a
+// plain string was added before.
+func (p *Pool) AddStyled(s string, spans []Span) uint32 {
+	if len(p.strs) != len(p.spans) {
+		panic("stringpool: styled string added after plain strings")
+	}
+	p.strs = append(p.strs, s)
+	p.spans = append(p.spans, spans)
+	return uint32(len(p.strs) - 1)
+}
+
+// Len returns the number of strings in the pool.
+func (p *Pool) Len() int {
+	return len(p.strs)
+}
+
+// Encode returns the pool as a string pool chunk, storing strings as UTF-8
+// or UTF-16. The tags of the spans are added to the pool first.
+func (p *Pool) Encode(utf8 bool) []byte {
+	tags := make([][]uint32, len(p.spans))
+	for i, spans := range p.spans {
+		for _, s := range spans {
+			tags[i] = append(tags[i], p.Add(s.Tag))
+		}
+	}
+
+	var data []byte
+	offsets := make([]uint32, len(p.strs))
+	for i, s := range p.strs {
+		offsets[i] = uint32(len(data))
+		if utf8 {
+			data = appendLen8(data, len(utf16.Encode([]rune(s))))
+			data = appendLen8(data, len(s))
+			data = append(append(data, s...), 0)
+		} else {
+			u := utf16.Encode([]rune(s))
+			data = appendLen16(data, len(u))
+			for _, c := range u {
+				data = binary.LittleEndian.AppendUint16(data, c)
+			}
+			data = append(data, 0, 0)
+		}
+	}
+	for len(data)%4 != 0 {
+		data = append(data, 0)
+	}
+
+	var styles []byte
+	styleOffsets := make([]uint32, len(p.spans))
+	for i, spans := range p.spans {
+		styleOffsets[i] = uint32(len(styles))
+		for j, s := range spans {
+			styles = binary.LittleEndian.AppendUint32(styles, tags[i][j])
+			styles = binary.LittleEndian.AppendUint32(styles, s.First)
+			styles = binary.LittleEndian.AppendUint32(styles, s.Last)
+		}
+		styles = binary.LittleEndian.AppendUint32(styles, spanEnd)
+	}
+	if len(p.spans) > 0 {
+		styles = binary.LittleEndian.AppendUint32(styles, spanEnd)
+		styles = binary.LittleEndian.AppendUint32(styles, spanEnd)
+	}
+
+	stringsStart := headerSize + 4*len(offsets) + 4*len(styleOffsets)
+	stylesStart := 0
+	if len(styles) > 0 {
+		stylesStart = stringsStart + len(data)
+	}
+	size := stringsStart + len(data) + len(styles)
+	var flags uint32
+	if utf8 {
+		flags = flagUTF8
+	}
+
+	b := make([]byte, 0, size)
+	b = binary.LittleEndian.AppendUint16(b, chunkType)
+	b = binary.LittleEndian.AppendUint16(b, headerSize)
+	b = binary.LittleEndian.AppendUint32(b, uint32(size))
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(p.strs)))
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(p.spans)))
+	b = binary.LittleEndian.AppendUint32(b, flags)
+	b = binary.LittleEndian.AppendUint32(b, uint32(stringsStart))
+	b = binary.LittleEndian.AppendUint32(b, uint32(stylesStart))
+	for _, o := range offsets {
+		b = binary.LittleEndian.AppendUint32(b, o)
+	}
+	for _, o := range styleOffsets {
+		b = binary.LittleEndian.AppendUint32(b, o)
+	}
+	return append(append(b, data...), styles...)
+}
+
+// appendLen8 appends a length of a UTF-8 pool entry, in one byte or, above
+// 0x7f, two.
+func appendLen8(b []byte, n int) []byte {
+	if n > 0x7f {
+		b = append(b, byte(n>>8)|0x80)
+	}
+	return append(b, byte(n))
+}
+
+// appendLen16 appends a length of a UTF-16 pool entry, in one unit or, above
+// 0x7fff, two.
+func appendLen16(b []byte, n int) []byte {
+	if n > 0x7fff {
+		b = binary.LittleEndian.AppendUint16(b, uint16(n>>16)|0x8000)
+	}
+	return binary.LittleEndian.AppendUint16(b, uint16(n))
+}
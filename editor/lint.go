@@ -0,0 +1,195 @@
+package editor
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"github.com/pzx521521/apk-editor/editor/axml"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+// 安全检查规则
+const (
+	LintDebuggable     = "debuggable"
+	LintAllowBackup    = "allow-backup"
+	LintCleartext      = "cleartext-traffic"
+	LintExported       = "exported-component"
+	LintWeakPermission = "weak-custom-permission"
+)
+
+// 问题严重程度
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// LintIssue 一条安全检查结果
+type LintIssue struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Element  string `json:"element"` // 出问题的组件或权限名, application 级别的规则为 "application"
+	Message  string `json:"message"`
+}
+
+func (i *LintIssue) String() string {
+	return fmt.Sprintf("%s: [%s] %s: %s", i.Severity, i.Rule, i.Element, i.Message)
+}
+
+// Lint 检查 manifest 及网络安全配置中常见的安全配置错误
+func Lint(apk []byte) (_ []*LintIssue, err error) {
+	defer catch(&err, "Lint", "")
+	r, err := zip.NewReader(bytes.NewReader(apk), int64(len(apk)))
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := readManifest(r)
+	if err != nil {
+		return nil, err
+	}
+	root, err := parseManifest(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	var issues []*LintIssue
+	add := func(rule, severity, element, format string, args ...any) {
+		issues = append(issues, &LintIssue{rule, severity, element, fmt.Sprintf(format, args...)})
+	}
+	android := func(e *axml.Element, name string) string { return e.AttrValue(axml.NSAndroid, name) }
+
+	targetSDK := 0
+	for _, e := range root.Children {
+		if e.Name == "uses-sdk" {
+			targetSDK, _ = strconv.Atoi(android(e, "targetSdkVersion"))
+			if targetSDK == 0 {
+				targetSDK, _ = strconv.Atoi(android(e, "minSdkVersion"))
+			}
+		}
+	}
+
+	for _, e := range root.Children {
+		if e.Name != "permission" {
+			continue
+		}
+		level := ProtectionNormal
+		if a := e.Attr(axml.NSAndroid, "protectionLevel"); a != nil {
+			level = protectionLevel(a)
+		}
+		if level == ProtectionNormal || level == ProtectionDangerous {
+			add(LintWeakPermission, SeverityWarning, android(e, "name"),
+				"自定义权限的 protectionLevel 为 %s, 任何应用都能获得, 保护内部组件应使用 signature", level)
+		}
+	}
+
+	for _, app := range root.Children {
+		if app.Name != "application" {
+			continue
+		}
+		if android(app, "debuggable") == "true" {
+			add(LintDebuggable, SeverityError, "application", "android:debuggable=true, 不应发布调试版本")
+		}
+		if v := android(app, "allowBackup"); v != "false" {
+			add(LintAllowBackup, SeverityWarning, "application", "android:allowBackup 未关闭, 应用数据可通过 adb backup 导出")
+		}
+		switch cleartext := android(app, "usesCleartextTraffic"); {
+		case cleartext == "true":
+			add(LintCleartext, SeverityWarning, "application", "android:usesCleartextTraffic=true, 允许明文 HTTP")
+		case android(app, "networkSecurityConfig") != "":
+			if permitted, _ := networkConfigCleartext(r); permitted {
+				add(LintCleartext, SeverityWarning, "application", "网络安全配置中 cleartextTrafficPermitted=true")
+			}
+		case cleartext == "" && targetSDK > 0 && targetSDK < 28:
+			add(LintCleartext, SeverityWarning, "application", "targetSdkVersion %d < 28, 默认允许明文 HTTP", targetSDK)
+		}
+
+		for _, c := range app.Children {
+			switch c.Name {
+			case "activity", "activity-alias", "service", "receiver", "provider":
+			default:
+				continue
+			}
+			if !exported(c, targetSDK) || android(c, "permission") != "" || isLauncher(c) {
+				continue
+			}
+			if c.Name == "provider" && (android(c, "readPermission") != "" || android(c, "writePermission") != "") {
+				continue
+			}
+			add(LintExported, SeverityWarning, android(c, "name"), "导出的 %s 未要求任何权限", c.Name)
+		}
+	}
+	return issues, nil
+}
+
+// exported 判断组件是否对其他应用可见: 显式声明, 否则含 intent-filter 即导出 (targetSdk 31 起必须显式声明)
+func exported(c *axml.Element, targetSDK int) bool {
+	switch c.AttrValue(axml.NSAndroid, "exported") {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if c.Name == "provider" {
+		return targetSDK > 0 && targetSDK < 17
+	}
+	for _, f := range c.Children {
+		if f.Name == "intent-filter" {
+			return true
+		}
+	}
+	return false
+}
+
+// isLauncher 桌面入口 activity 必须导出, 不作为问题报告
+func isLauncher(c *axml.Element) bool {
+	for _, f := range c.Children {
+		if f.Name != "intent-filter" {
+			continue
+		}
+		var main, launcher bool
+		for _, x := range f.Children {
+			switch x.AttrValue(axml.NSAndroid, "name") {
+			case "android.intent.action.MAIN":
+				main = true
+			case "android.intent.category.LAUNCHER":
+				launcher = true
+			}
+		}
+		if main && launcher {
+			return true
+		}
+	}
+	return false
+}
+
+// networkConfigCleartext 检查 res/xml 下的网络安全配置是否允许明文流量.
+// 未解析 resources.arsc, 因此按惯例查找 network_security_config.xml.
+func networkConfigCleartext(r *zip.Reader) (bool, error) {
+	for _, f := range r.File {
+		if f.Name != "res/xml/network_security_config.xml" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return false, err
+		}
+		var buf bytes.Buffer
+		_, err = buf.ReadFrom(rc)
+		rc.Close()
+		if err != nil {
+			return false, err
+		}
+		root, err := axml.Parse(buf.Bytes())
+		if err != nil {
+			return false, entryError(f.Name, err)
+		}
+		permitted := false
+		root.Walk(func(e *axml.Element) {
+			if e.AttrValue("", "cleartextTrafficPermitted") == "true" {
+				permitted = true
+			}
+		})
+		return permitted, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,30 @@
+package editor
+
+import (
+	"os"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	apk, err := os.ReadFile("../release/app-release.apk")
+	if err != nil {
+		t.Skip(err)
+	}
+	issues, err := Lint(apk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := map[string]bool{}
+	for _, i := range issues {
+		rules[i.Rule] = true
+	}
+	// 示例 apk 是一个 WebView 壳, 允许明文且未关闭备份
+	for _, rule := range []string{LintAllowBackup, LintCleartext} {
+		if !rules[rule] {
+			t.Errorf("missing %s in %v", rule, issues)
+		}
+	}
+	if rules[LintDebuggable] {
+		t.Error("release build reported as debuggable")
+	}
+}
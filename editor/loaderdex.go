@@ -0,0 +1,232 @@
+package editor
+
+import (
+	"cmp"
+	"crypto/sha1"
+	"encoding/binary"
+	"hash/adler32"
+	"slices"
+)
+
+// dex 中的类型描述符
+const (
+	descContentProvider = "Landroid/content/ContentProvider;"
+	descString          = "Ljava/lang/String;"
+	descSystem          = "Ljava/lang/System;"
+)
+
+// dex 访问标志
+const (
+	accPublic      = 0x1
+	accStatic      = 0x8
+	accConstructor = 0x10000
+)
+
+// dexProto 方法原型
+type dexProto struct {
+	shorty string
+	ret    string
+	params []string
+}
+
+// dexMethod 方法引用
+type dexMethod struct {
+	class, name string
+	proto       *dexProto
+}
+
+// loaderDex 生成只含一个类的 dex (版本 035): class 继承 android.content.ContentProvider,
+// 类初始化时调用 System.loadLibrary(lib), onCreate 返回 true, 其余抽象方法不实现 (provider 不导出, 不会被调用).
+// class 为类型描述符, 如 "Lcom/example/Loader;", lib 只能含 ASCII 字符
+func loaderDex(class, lib string) []byte {
+	voidProto := &dexProto{"V", "V", nil}
+	loadProto := &dexProto{"VL", "V", []string{descString}}
+	boolProto := &dexProto{"Z", "Z", nil}
+	protos := []*dexProto{voidProto, loadProto, boolProto}
+	superInit := &dexMethod{descContentProvider, "<init>", voidProto}
+	clinit := &dexMethod{class, "<clinit>", voidProto}
+	init := &dexMethod{class, "<init>", voidProto}
+	onCreate := &dexMethod{class, "onCreate", boolProto}
+	loadLibrary := &dexMethod{descSystem, "loadLibrary", loadProto}
+	methods := []*dexMethod{superInit, clinit, init, onCreate, loadLibrary}
+
+	// 字符串, 类型, 原型, 方法均须按规范排序, 下标即排序后的位置
+	strs := []string{lib}
+	for _, p := range protos {
+		strs = append(strs, p.shorty, p.ret)
+		strs = append(strs, p.params...)
+	}
+	for _, m := range methods {
+		strs = append(strs, m.class, m.name)
+	}
+	slices.Sort(strs)
+	strs = slices.Compact(strs)
+	sidx := map[string]uint32{}
+	for i, s := range strs {
+		sidx[s] = uint32(i)
+	}
+	types := []string{descContentProvider, class, descString, descSystem, "V", "Z"}
+	slices.Sort(types)
+	tidx := map[string]uint16{}
+	for i, t := range types {
+		tidx[t] = uint16(i)
+	}
+	slices.SortFunc(protos, func(a, b *dexProto) int {
+		if c := cmp.Compare(tidx[a.ret], tidx[b.ret]); c != 0 {
+			return c
+		}
+		return slices.CompareFunc(a.params, b.params, func(x, y string) int { return cmp.Compare(tidx[x], tidx[y]) })
+	})
+	pidx := map[*dexProto]uint16{}
+	for i, p := range protos {
+		pidx[p] = uint16(i)
+	}
+	slices.SortFunc(methods, func(a, b *dexMethod) int {
+		return cmp.Or(cmp.Compare(tidx[a.class], tidx[b.class]), cmp.Compare(sidx[a.name], sidx[b.name]),
+			cmp.Compare(pidx[a.proto], pidx[b.proto]))
+	})
+	midx := map[*dexMethod]uint16{}
+	for i, m := range methods {
+		midx[m] = uint16(i)
+	}
+
+	le := binary.LittleEndian
+	const headerSize = 0x70
+	stringIdsOff := headerSize
+	typeIdsOff := stringIdsOff + 4*len(strs)
+	protoIdsOff := typeIdsOff + 4*len(types)
+	methodIdsOff := protoIdsOff + 12*len(protos)
+	classDefsOff := methodIdsOff + 8*len(methods)
+	dataOff := classDefsOff + 32
+
+	// data 区: code_item, type_list, string_data, class_data, map_list
+	data := []byte{}
+	align4 := func() {
+		for (dataOff+len(data))%4 != 0 {
+			data = append(data, 0)
+		}
+	}
+	code := func(registers, ins, outs uint16, insns ...uint16) uint32 {
+		align4()
+		off := uint32(dataOff + len(data))
+		data = le.AppendUint16(data, registers)
+		data = le.AppendUint16(data, ins)
+		data = le.AppendUint16(data, outs)
+		data = le.AppendUint16(data, 0) // tries_size
+		data = le.AppendUint32(data, 0) // debug_info_off
+		data = le.AppendUint32(data, uint32(len(insns)))
+		for _, u := range insns {
+			data = le.AppendUint16(data, u)
+		}
+		return off
+	}
+	codeOff := dataOff
+	clinitCode := code(1, 0, 1,
+		0x001a, uint16(sidx[lib]), // const-string v0, lib
+		0x1071, midx[loadLibrary], 0x0000, // invoke-static {v0}, System.loadLibrary
+		0x000e) // return-void
+	initCode := code(1, 1, 1,
+		0x1070, midx[superInit], 0x0000, // invoke-direct {p0}, ContentProvider.<init>
+		0x000e) // return-void
+	onCreateCode := code(2, 1, 0,
+		0x1012, // const/4 v0, 1
+		0x000f) // return v0
+
+	align4()
+	typeListOff := dataOff + len(data)
+	data = le.AppendUint32(data, 1)
+	data = le.AppendUint16(data, tidx[descString])
+
+	stringDataOff := dataOff + len(data)
+	strOffs := make([]uint32, len(strs))
+	for i, s := range strs {
+		strOffs[i] = uint32(dataOff + len(data))
+		data = binary.AppendUvarint(data, uint64(len(s)))
+		data = append(append(data, s...), 0)
+	}
+
+	classDataOff := dataOff + len(data)
+	data = append(data, 0, 0, 2, 1) // 静态字段, 实例字段, direct 方法, virtual 方法的个数
+	data = binary.AppendUvarint(data, uint64(midx[clinit]))
+	data = binary.AppendUvarint(data, accStatic|accConstructor)
+	data = binary.AppendUvarint(data, uint64(clinitCode))
+	data = binary.AppendUvarint(data, uint64(midx[init]-midx[clinit]))
+	data = binary.AppendUvarint(data, accPublic|accConstructor)
+	data = binary.AppendUvarint(data, uint64(initCode))
+	data = binary.AppendUvarint(data, uint64(midx[onCreate]))
+	data = binary.AppendUvarint(data, accPublic)
+	data = binary.AppendUvarint(data, uint64(onCreateCode))
+
+	align4()
+	mapOff := dataOff + len(data)
+	items := []struct {
+		typ       uint16
+		size, off int
+	}{
+		{0x0000, 1, 0},
+		{0x0001, len(strs), stringIdsOff},
+		{0x0002, len(types), typeIdsOff},
+		{0x0003, len(protos), protoIdsOff},
+		{0x0005, len(methods), methodIdsOff},
+		{0x0006, 1, classDefsOff},
+		{0x2001, 3, codeOff},
+		{0x1001, 1, typeListOff},
+		{0x2002, len(strs), stringDataOff},
+		{0x2000, 1, classDataOff},
+		{0x1000, 1, mapOff},
+	}
+	data = le.AppendUint32(data, uint32(len(items)))
+	for _, it := range items {
+		data = le.AppendUint16(data, it.typ)
+		data = le.AppendUint16(data, 0)
+		data = le.AppendUint32(data, uint32(it.size))
+		data = le.AppendUint32(data, uint32(it.off))
+	}
+
+	// 头部及 id 区
+	b := make([]byte, dataOff, dataOff+len(data))
+	copy(b, "dex\n035\x00")
+	le.PutUint32(b[32:], uint32(dataOff+len(data)))
+	le.PutUint32(b[36:], headerSize)
+	le.PutUint32(b[40:], 0x12345678)
+	le.PutUint32(b[52:], uint32(mapOff))
+	for i, field := range [][2]int{
+		{len(strs), stringIdsOff}, {len(types), typeIdsOff}, {len(protos), protoIdsOff},
+		{0, 0}, {len(methods), methodIdsOff}, {1, classDefsOff}, {len(data), dataOff},
+	} {
+		le.PutUint32(b[56+8*i:], uint32(field[0]))
+		le.PutUint32(b[60+8*i:], uint32(field[1]))
+	}
+	for i, off := range strOffs {
+		le.PutUint32(b[stringIdsOff+4*i:], off)
+	}
+	for i, t := range types {
+		le.PutUint32(b[typeIdsOff+4*i:], sidx[t])
+	}
+	for i, p := range protos {
+		e := b[protoIdsOff+12*i:]
+		le.PutUint32(e, sidx[p.shorty])
+		le.PutUint32(e[4:], uint32(tidx[p.ret]))
+		if len(p.params) > 0 {
+			le.PutUint32(e[8:], uint32(typeListOff))
+		}
+	}
+	for i, m := range methods {
+		e := b[methodIdsOff+8*i:]
+		le.PutUint16(e, tidx[m.class])
+		le.PutUint16(e[2:], pidx[m.proto])
+		le.PutUint32(e[4:], sidx[m.name])
+	}
+	c := b[classDefsOff:]
+	le.PutUint32(c, uint32(tidx[class]))
+	le.PutUint32(c[4:], accPublic)
+	le.PutUint32(c[8:], uint32(tidx[descContentProvider]))
+	le.PutUint32(c[16:], 0xffffffff) // source_file_idx: 无
+	le.PutUint32(c[24:], uint32(classDataOff))
+
+	b = append(b, data...)
+	sum := sha1.Sum(b[32:])
+	copy(b[12:], sum[:])
+	le.PutUint32(b[8:], adler32.Checksum(b[12:]))
+	return b
+}
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+
+	"github.com/pzx521521/apk-editor/editor/zip"
 )
 
 type Modifier interface {
@@ -31,7 +33,8 @@ func adjustStringLength(old, new []byte) []byte {
 }
 
 // ModifyAll 支持同时处理不同类型的修改
-func ModifyAll(data []byte, modifications ...any) ([]byte, error) {
+func ModifyAll(data []byte, modifications ...any) (_ []byte, err error) {
+	defer catch(&err, "ModifyAll", zip.ANDROIDMANIFEST)
 	if len(data) == 0 {
 		return nil, fmt.Errorf("empty input data")
 	}
@@ -0,0 +1,111 @@
+package editor
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+
+	"github.com/pzx521521/apk-editor/editor/axml"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+// ManifestEdit 直接修改解码后的 manifest 属性. 与 Manifest 不同, 不要求原值与 DefaultManifest 相同,
+// 适用于任意 apk. 零值的字段不修改
+type ManifestEdit struct {
+	Package     string // 包名, 相对类名会先补全为原包名下的完整类名
+	VersionCode int32
+	VersionName string
+	Label       string // 应用名称, 写为字符串, 替换原来的资源引用
+	Debuggable  *bool  // android:debuggable
+}
+
+// Changes 列出 Apply 会对 root 做的修改及原值, 用于 dry-run; 与原值相同的字段不列出
+func (m *ManifestEdit) Changes(root *axml.Element) []ManifestChange {
+	var changes []ManifestChange
+	add := func(attr, old, new string) {
+		if old != new {
+			changes = append(changes, ManifestChange{attr, old, new})
+		}
+	}
+	app := root.Child("application")
+	if app == nil {
+		app = &axml.Element{}
+	}
+	if m.Package != "" {
+		add("package", root.AttrValue("", "package"), m.Package)
+	}
+	if m.VersionCode != 0 {
+		add("versionCode", root.AttrValue(axml.NSAndroid, "versionCode"), strconv.Itoa(int(m.VersionCode)))
+	}
+	if m.VersionName != "" {
+		add("versionName", root.AttrValue(axml.NSAndroid, "versionName"), m.VersionName)
+	}
+	if m.Label != "" {
+		add("label", app.AttrValue(axml.NSAndroid, "label"), m.Label)
+	}
+	if m.Debuggable != nil {
+		add("debuggable", app.AttrValue(axml.NSAndroid, "debuggable"), strconv.FormatBool(*m.Debuggable))
+	}
+	return changes
+}
+
+// Apply 把修改应用到 manifest 根元素, 可作为 EditManifestFunc 的参数
+func (m *ManifestEdit) Apply(root *axml.Element) error {
+	app := root.Child("application")
+	if app == nil && (m.Label != "" || m.Debuggable != nil) {
+		return errors.New("manifest has no application element")
+	}
+	if m.Package != "" {
+		root.RenamePackage(m.Package)
+	}
+	if m.VersionCode != 0 {
+		root.SetInt(axml.NSAndroid, "versionCode", m.VersionCode)
+	}
+	if m.VersionName != "" {
+		root.SetString(axml.NSAndroid, "versionName", m.VersionName)
+	}
+	if m.Label != "" {
+		app.SetString(axml.NSAndroid, "label", m.Label)
+	}
+	if m.Debuggable != nil {
+		app.SetBool(axml.NSAndroid, "debuggable", *m.Debuggable)
+	}
+	return nil
+}
+
+// EditManifestFunc 解码 apk 的 AndroidManifest.xml, 用 edit 修改后重新编码写回, 返回未签名的 apk.
+// 其他条目原样保留, 需要重新签名
+func EditManifestFunc(apk []byte, edit func(root *axml.Element) error) (_ []byte, err error) {
+	defer catch(&err, "EditManifestFunc", zip.ANDROIDMANIFEST)
+	r, err := zip.NewReader(bytes.NewReader(apk), int64(len(apk)))
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := readManifest(r)
+	if err != nil {
+		return nil, err
+	}
+	root, err := parseManifest(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if err = edit(root); err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	buf.Write(apk[:r.AppendOffset()])
+	w := r.Append(buf, true)
+	if err = merge(w, &MergeEntry{zip.ANDROIDMANIFEST, axml.Encode(root)}); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	if signv2.SelfCheckEnabled() {
+		if err = checkEdited(buf.Bytes()); err != nil {
+			return nil, &signv2.SelfCheckError{Op: "edit manifest", Err: err}
+		}
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,86 @@
+package editor
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/pzx521521/apk-editor/editor/apktest"
+	"github.com/pzx521521/apk-editor/editor/axml"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+func TestEditManifestFunc(t *testing.T) {
+	key, err := apktest.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	apk, err := apktest.BuildSigned(apktest.Config{Debuggable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	debuggable := false
+	m := &ManifestEdit{Package: "org.renamed", VersionCode: 42, VersionName: "4.2", Label: "Renamed", Debuggable: &debuggable}
+	var changes []ManifestChange
+	edited, err := EditManifestFunc(apk, func(root *axml.Element) error {
+		changes = m.Changes(root)
+		return m.Apply(root)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 5 || changes[0] != (ManifestChange{"package", apktest.DefaultPackage, "org.renamed"}) ||
+		changes[4] != (ManifestChange{"debuggable", "true", "false"}) {
+		t.Errorf("got changes %v", changes)
+	}
+	if c := (&ManifestEdit{VersionCode: 1}).Changes(&axml.Element{}); len(c) != 1 || c[0].Old != "" {
+		t.Errorf("versionCode not declared: got changes %v", c)
+	}
+
+	// 与其他修改一样, 结果需要重新签名
+	z, err := signv2.NewApkSign(edited)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := z.SignV2([]*signv2.SigningCert{key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = signv2.CheckSigned(signed); err != nil {
+		t.Fatal(err)
+	}
+	r, err := zip.NewReader(bytes.NewReader(signed), int64(len(signed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := readManifest(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := axml.Parse(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := root.Child("application")
+	for _, tt := range []struct {
+		e              *axml.Element
+		ns, name, want string
+	}{
+		{root, "", "package", "org.renamed"},
+		{root, axml.NSAndroid, "versionCode", "42"},
+		{root, axml.NSAndroid, "versionName", "4.2"},
+		{app, axml.NSAndroid, "label", "Renamed"},
+		{app, axml.NSAndroid, "debuggable", "false"},
+	} {
+		if got := tt.e.AttrValue(tt.ns, tt.name); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// edit 返回的错误原样返回
+	errEdit := errors.New("edit")
+	if _, err = EditManifestFunc(apk, func(*axml.Element) error { return errEdit }); !errors.Is(err, errEdit) {
+		t.Errorf("got %v", err)
+	}
+}
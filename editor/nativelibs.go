@@ -0,0 +1,134 @@
+package editor
+
+import (
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+// PageSize16K 16KB 页大小设备要求的对齐
+const PageSize16K = 16 << 10
+
+// NativeLib 一个 .so 的对齐审计结果
+type NativeLib struct {
+	Path       string   `json:"path"`
+	ABI        string   `json:"abi"`
+	Compressed bool     `json:"compressed"`
+	Offset     int64    `json:"offset"`      // 数据在 apk 中的偏移
+	ZipAligned bool     `json:"zip_aligned"` // 未压缩时数据按 16KB 对齐; 压缩的库安装时解压, 不要求对齐
+	ELFAlign   uint64   `json:"elf_align"`   // PT_LOAD 段中最小的 p_align
+	Ready16K   bool     `json:"ready_16k"`
+	Issues     []string `json:"issues,omitempty"`
+}
+
+// AuditNativeLibs 列出 lib/ 下所有 .so, 检查是否满足 16KB 页大小要求:
+// ELF 的 PT_LOAD 段按 16KB 对齐, 且未压缩存储的库在 apk 中按 16KB 对齐.
+// 只有 64 位 ABI 受此要求约束, 32 位库只报告不标记.
+func AuditNativeLibs(apk []byte) (_ []*NativeLib, err error) {
+	defer catch(&err, "AuditNativeLibs", "")
+	r, err := zip.NewReader(bytes.NewReader(apk), int64(len(apk)))
+	if err != nil {
+		return nil, err
+	}
+	var libs []*NativeLib
+	for _, f := range r.File {
+		category, abi := categorize(f.Name)
+		if category != CategoryNative || !strings.HasSuffix(f.Name, ".so") {
+			continue
+		}
+		lib := &NativeLib{Path: f.Name, ABI: abi, Compressed: f.Method != zip.Store}
+		if lib.Offset, err = f.DataOffset(); err != nil {
+			return nil, err
+		}
+		lib.ZipAligned = lib.Compressed || lib.Offset%PageSize16K == 0
+
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		if lib.ELFAlign, err = elfLoadAlign(data); err != nil {
+			lib.Issues = append(lib.Issues, fmt.Sprintf("无法解析 ELF: %v", err))
+		}
+
+		if !is64BitABI(abi) {
+			lib.Ready16K = true
+			libs = append(libs, lib)
+			continue
+		}
+		if lib.ELFAlign < PageSize16K {
+			lib.Issues = append(lib.Issues, fmt.Sprintf("PT_LOAD 对齐为 %d, 需要 >= %d", lib.ELFAlign, PageSize16K))
+		}
+		if !lib.ZipAligned {
+			lib.Issues = append(lib.Issues, fmt.Sprintf("未压缩存储但偏移 %d 未按 16KB 对齐", lib.Offset))
+		}
+		lib.Ready16K = len(lib.Issues) == 0
+		libs = append(libs, lib)
+	}
+	return libs, nil
+}
+
+// elfLoadAlign 返回所有 PT_LOAD 段中最小的对齐值
+func elfLoadAlign(data []byte) (uint64, error) {
+	ef, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	defer ef.Close()
+	var align uint64
+	for _, p := range ef.Progs {
+		if p.Type == elf.PT_LOAD && (align == 0 || p.Align < align) {
+			align = p.Align
+		}
+	}
+	return align, nil
+}
+
+// abiMachines 各 ABI 的 .so 应有的 ELF 机器类型和位数
+var abiMachines = map[string]struct {
+	machine elf.Machine
+	class   elf.Class
+}{
+	"armeabi":     {elf.EM_ARM, elf.ELFCLASS32},
+	"armeabi-v7a": {elf.EM_ARM, elf.ELFCLASS32},
+	"arm64-v8a":   {elf.EM_AARCH64, elf.ELFCLASS64},
+	"x86":         {elf.EM_386, elf.ELFCLASS32},
+	"x86_64":      {elf.EM_X86_64, elf.ELFCLASS64},
+	"riscv64":     {elf.EM_RISCV, elf.ELFCLASS64},
+	"mips":        {elf.EM_MIPS, elf.ELFCLASS32},
+	"mips64":      {elf.EM_MIPS, elf.ELFCLASS64},
+}
+
+// AddNativeLib 编辑时把 native 库 data 写入 lib/<abi>/<name>, 已有同名条目时在原位置替换.
+// 库不压缩并按 16KB 对齐, 满足 extractNativeLibs="false" 时系统直接从 apk 映射的要求, 解压安装时也可用.
+// name 须为 lib*.so, 否则安装时不会解压; data 须为该 ABI 的 ELF 文件
+func (a *ApkEditor) AddNativeLib(abi, name string, data []byte) error {
+	want, ok := abiMachines[abi]
+	if !ok {
+		return fmt.Errorf("unknown abi %q", abi)
+	}
+	if !strings.HasPrefix(name, "lib") || !strings.HasSuffix(name, ".so") || len(name) <= len("lib.so") || strings.ContainsAny(name, "/\\") {
+		return fmt.Errorf("invalid library name %q, want lib<name>.so", name)
+	}
+	ef, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	ef.Close()
+	if ef.Machine != want.machine || ef.Class != want.class {
+		return fmt.Errorf("%s: %v %v library does not match abi %s", name, ef.Class, ef.Machine, abi)
+	}
+	return a.AddFile("lib/"+abi+"/"+name, data, false)
+}
+
+func is64BitABI(abi string) bool {
+	return abi == "arm64-v8a" || abi == "x86_64" || abi == "riscv64"
+}
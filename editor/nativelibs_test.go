@@ -0,0 +1,110 @@
+package editor
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/pzx521521/apk-editor/editor/apktest"
+)
+
+// testELF builds a minimal 64-bit shared object with one PT_LOAD segment.
+func testELF(align uint64) []byte {
+	b := make([]byte, 64+56)
+	copy(b, []byte{0x7f, 'E', 'L', 'F', 2, 1, 1})
+	le := binary.LittleEndian
+	le.PutUint16(b[16:], 3)   // ET_DYN
+	le.PutUint16(b[18:], 183) // EM_AARCH64
+	le.PutUint32(b[20:], 1)
+	le.PutUint64(b[32:], 64) // e_phoff
+	le.PutUint16(b[52:], 64) // e_ehsize
+	le.PutUint16(b[54:], 56) // e_phentsize
+	le.PutUint16(b[56:], 1)  // e_phnum
+	le.PutUint16(b[58:], 64) // e_shentsize
+	ph := b[64:]
+	le.PutUint32(ph, 1) // PT_LOAD
+	le.PutUint32(ph[4:], 5)
+	le.PutUint64(ph[32:], uint64(len(b)))
+	le.PutUint64(ph[40:], uint64(len(b)))
+	le.PutUint64(ph[48:], align)
+	return b
+}
+
+func TestAuditNativeLibs(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	add := func(name string, method uint16, data []byte) {
+		f, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(data)
+	}
+	add("lib/arm64-v8a/libok.so", zip.Deflate, testELF(PageSize16K))
+	add("lib/arm64-v8a/libold.so", zip.Deflate, testELF(4096))
+	add("lib/arm64-v8a/libstored.so", zip.Store, testELF(PageSize16K))
+	add("lib/armeabi-v7a/libold.so", zip.Deflate, testELF(4096))
+	w.Close()
+
+	libs, err := AuditNativeLibs(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{
+		"lib/arm64-v8a/libok.so":     true,
+		"lib/arm64-v8a/libold.so":    false,
+		"lib/arm64-v8a/libstored.so": false, // 未按 16KB 对齐
+		"lib/armeabi-v7a/libold.so":  true,  // 32 位不受约束
+	}
+	if len(libs) != len(want) {
+		t.Fatalf("got %d libs", len(libs))
+	}
+	for _, l := range libs {
+		if l.Ready16K != want[l.Path] {
+			t.Errorf("%s: ready = %v, issues %q", l.Path, l.Ready16K, l.Issues)
+		}
+	}
+}
+
+func TestAddNativeLib(t *testing.T) {
+	apk, err := apktest.Build(apktest.Config{Files: map[string][]byte{"assets/a.txt": []byte("a")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := apktest.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := NewApkEditor(apk, key.KeyBytes, key.CertBytes)
+	lib := testELF(PageSize16K)
+	if err = a.AddNativeLib("arm64-v8a", "libfoo.so", lib); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct{ abi, name string }{
+		{"armeabi-v7a", "libfoo.so"}, // 64 位的库
+		{"arm64", "libfoo.so"},
+		{"arm64-v8a", "foo.so"},
+		{"arm64-v8a", "libfoo"},
+		{"arm64-v8a", "../libfoo.so"},
+	} {
+		if err = a.AddNativeLib(c.abi, c.name, lib); err == nil {
+			t.Errorf("AddNativeLib(%q, %q) succeeded", c.abi, c.name)
+		}
+	}
+	if err = a.AddNativeLib("arm64-v8a", "libbar.so", []byte("not elf")); err == nil {
+		t.Error("AddNativeLib accepted a file that is not ELF")
+	}
+
+	signed, err := a.Edit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	libs, err := AuditNativeLibs(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(libs) != 1 || libs[0].Path != "lib/arm64-v8a/libfoo.so" || libs[0].Compressed || !libs[0].Ready16K {
+		t.Errorf("libs = %+v", libs)
+	}
+}
@@ -0,0 +1,132 @@
+package editor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pzx521521/apk-editor/editor/axml"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+// ObbKind 扩展文件的类型, 每个应用最多各有一个
+type ObbKind string
+
+const (
+	ObbMain  ObbKind = "main"  // 主扩展文件
+	ObbPatch ObbKind = "patch" // 补丁扩展文件, 对主扩展文件的更新
+)
+
+// ObbName 返回扩展文件的文件名 <kind>.<versionCode>.<package>.obb, 如 main.12.com.example.app.obb.
+// 设备上的路径为 /sdcard/Android/obb/<package>/<文件名>
+func ObbName(kind ObbKind, versionCode uint32, pkg string) string {
+	return fmt.Sprintf("%s.%d.%s.obb", kind, versionCode, pkg)
+}
+
+// ParseObbName 解析 ObbName 格式的文件名, name 可以带目录
+func ParseObbName(name string) (kind ObbKind, versionCode uint32, pkg string, err error) {
+	base := path.Base(strings.ReplaceAll(name, "\\", "/"))
+	rest, ok := strings.CutSuffix(base, ".obb")
+	parts := strings.SplitN(rest, ".", 3)
+	if !ok || len(parts) != 3 || parts[2] == "" {
+		return "", 0, "", fmt.Errorf("invalid obb name %q, want <main|patch>.<versionCode>.<package>.obb", base)
+	}
+	kind = ObbKind(parts[0])
+	if kind != ObbMain && kind != ObbPatch {
+		return "", 0, "", fmt.Errorf("invalid obb name %q: kind %q is neither main nor patch", base, parts[0])
+	}
+	v, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return "", 0, "", fmt.Errorf("invalid obb name %q: bad versionCode %q", base, parts[1])
+	}
+	return kind, uint32(v), parts[2], nil
+}
+
+// BuildObb 把 files 打包为 zip 格式的扩展文件. 条目不压缩, 以便 APK Expansion Zip Library
+// 直接按偏移读取; 键为 zip 内的路径, 按路径排序写入
+func BuildObb(files map[string][]byte) (_ []byte, err error) {
+	defer catch(&err, "BuildObb", "")
+	names := make([]string, 0, len(files))
+	for name := range files {
+		if name == "" || path.Clean(name) != name || strings.HasPrefix(name, "/") || strings.HasPrefix(name, "../") || name == ".." {
+			return nil, fmt.Errorf("invalid path %q", name)
+		}
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	for _, name := range names {
+		fw, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		if err != nil {
+			return nil, err
+		}
+		if _, err = fw.Write(files[name]); err != nil {
+			return nil, err
+		}
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// PackageObb 用 apk 的包名和 versionCode 打包扩展文件, 返回文件名及内容
+func PackageObb(apk []byte, kind ObbKind, files map[string][]byte) (name string, obb []byte, err error) {
+	if kind != ObbMain && kind != ObbPatch {
+		return "", nil, fmt.Errorf("invalid obb kind %q", kind)
+	}
+	pkg, versionCode, err := packageVersion(apk)
+	if err != nil {
+		return "", nil, err
+	}
+	if obb, err = BuildObb(files); err != nil {
+		return "", nil, err
+	}
+	return ObbName(kind, versionCode, pkg), obb, nil
+}
+
+// CheckObb 检查扩展文件名是否属于 apk: 包名相同, 且 versionCode 不大于 apk 的 versionCode.
+// 扩展文件沿用首次上传时的 versionCode, 因此比 apk 旧是正常的, 但不能比 apk 新
+func CheckObb(apk []byte, obbName string) error {
+	_, versionCode, pkg, err := ParseObbName(obbName)
+	if err != nil {
+		return err
+	}
+	apkPkg, apkVersion, err := packageVersion(apk)
+	if err != nil {
+		return err
+	}
+	if pkg != apkPkg {
+		return fmt.Errorf("obb %s is for package %s, apk is %s", obbName, pkg, apkPkg)
+	}
+	if versionCode > apkVersion {
+		return fmt.Errorf("obb %s has versionCode %d, newer than the apk's %d", obbName, versionCode, apkVersion)
+	}
+	return nil
+}
+
+// packageVersion 读取 apk manifest 中的包名与 versionCode
+func packageVersion(apk []byte) (pkg string, versionCode uint32, err error) {
+	root, err := decodeManifest(apk)
+	if err != nil {
+		return "", 0, err
+	}
+	pkg = root.AttrValue("", "package")
+	v := root.Attr(axml.NSAndroid, "versionCode")
+	if pkg == "" || v == nil {
+		return "", 0, errors.New("manifest has no package or versionCode")
+	}
+	if v.Type == axml.TypeString {
+		n, err := strconv.ParseUint(v.Raw, 0, 32)
+		if err != nil {
+			return "", 0, fmt.Errorf("bad versionCode %q", v.Raw)
+		}
+		return pkg, uint32(n), nil
+	}
+	return pkg, v.Data, nil
+}
@@ -0,0 +1,47 @@
+package editor
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pzx521521/apk-editor/editor/apktest"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+func TestPackageObb(t *testing.T) {
+	apk, err := apktest.Build(apktest.Config{Package: "com.example.game", VersionCode: 12})
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, obb, err := PackageObb(apk, ObbMain, map[string][]byte{"data/level1.bin": []byte("level"), "music.ogg": []byte("ogg")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "main.12.com.example.game.obb" {
+		t.Errorf("name %q", name)
+	}
+	r, err := zip.NewReader(bytes.NewReader(obb), int64(len(obb)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.File) != 2 || r.File[0].Name != "data/level1.bin" || r.File[0].Method != zip.Store {
+		t.Errorf("entries %v", r.File)
+	}
+	if _, _, err = PackageObb(apk, ObbMain, map[string][]byte{"../x": nil}); err == nil {
+		t.Error("accepted a path outside the archive")
+	}
+
+	for name, ok := range map[string]bool{
+		"/sdcard/Android/obb/com.example.game/main.12.com.example.game.obb": true,
+		"patch.3.com.example.game.obb":                                      true,
+		"main.13.com.example.game.obb":                                      false, // 比 apk 新
+		"main.12.com.example.other.obb":                                     false,
+		"extra.12.com.example.game.obb":                                     false,
+		"main.x.com.example.game.obb":                                       false,
+		"main.12.com.example.game.zip":                                      false,
+	} {
+		if err := CheckObb(apk, name); (err == nil) != ok {
+			t.Errorf("CheckObb(%s) = %v", name, err)
+		}
+	}
+}
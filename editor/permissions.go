@@ -0,0 +1,156 @@
+package editor
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pzx521521/apk-editor/editor/axml"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+// 权限保护级别
+const (
+	ProtectionNormal    = "normal"
+	ProtectionDangerous = "dangerous"
+	ProtectionSignature = "signature"
+	ProtectionUnknown   = "unknown" // 第三方权限, 且未在本 apk 中声明
+)
+
+// dangerousPermissions 需要运行时授权的系统权限
+var dangerousPermissions = map[string]bool{}
+
+// signaturePermissions 应用常申请的 signature / appop 系统权限, 普通应用需用户在设置中单独授予
+var signaturePermissions = map[string]bool{}
+
+func init() {
+	for _, p := range strings.Fields(`READ_CALENDAR WRITE_CALENDAR CAMERA READ_CONTACTS WRITE_CONTACTS
+		GET_ACCOUNTS ACCESS_FINE_LOCATION ACCESS_COARSE_LOCATION ACCESS_BACKGROUND_LOCATION RECORD_AUDIO
+		READ_PHONE_STATE READ_PHONE_NUMBERS CALL_PHONE ANSWER_PHONE_CALLS READ_CALL_LOG WRITE_CALL_LOG
+		ADD_VOICEMAIL USE_SIP PROCESS_OUTGOING_CALLS BODY_SENSORS BODY_SENSORS_BACKGROUND SEND_SMS
+		RECEIVE_SMS READ_SMS RECEIVE_WAP_PUSH RECEIVE_MMS READ_EXTERNAL_STORAGE WRITE_EXTERNAL_STORAGE
+		ACCESS_MEDIA_LOCATION ACTIVITY_RECOGNITION BLUETOOTH_SCAN BLUETOOTH_CONNECT BLUETOOTH_ADVERTISE
+		UWB_RANGING NEARBY_WIFI_DEVICES POST_NOTIFICATIONS READ_MEDIA_IMAGES READ_MEDIA_VIDEO
+		READ_MEDIA_AUDIO READ_MEDIA_VISUAL_USER_SELECTED ACCEPT_HANDOVER`) {
+		dangerousPermissions["android.permission."+p] = true
+	}
+	for _, p := range strings.Fields(`SYSTEM_ALERT_WINDOW WRITE_SETTINGS REQUEST_INSTALL_PACKAGES
+		PACKAGE_USAGE_STATS MANAGE_EXTERNAL_STORAGE BIND_ACCESSIBILITY_SERVICE
+		BIND_NOTIFICATION_LISTENER_SERVICE BIND_DEVICE_ADMIN BIND_VPN_SERVICE BIND_INPUT_METHOD
+		SCHEDULE_EXACT_ALARM INSTALL_PACKAGES DELETE_PACKAGES READ_LOGS WRITE_SECURE_SETTINGS
+		MANAGE_MEDIA READ_PRIVILEGED_PHONE_STATE`) {
+		signaturePermissions["android.permission."+p] = true
+	}
+}
+
+// Permission 一条申请或声明的权限
+type Permission struct {
+	Name            string `json:"name"`
+	ProtectionLevel string `json:"protection_level"`
+	MaxSDK          int    `json:"max_sdk,omitempty"` // android:maxSdkVersion, 0 表示不限
+}
+
+// Feature 一条 uses-feature
+type Feature struct {
+	Name        string `json:"name,omitempty"`
+	Required    bool   `json:"required"`
+	GLESVersion string `json:"gles_version,omitempty"` // 仅 glEsVersion 形式, 如 "2.0"
+}
+
+// PermissionReport manifest 中的权限与硬件特性, 用于合规审查
+type PermissionReport struct {
+	Requested []Permission `json:"requested"` // uses-permission
+	Declared  []Permission `json:"declared"`  // 应用自定义的 permission
+	Features  []Feature    `json:"features"`
+}
+
+// ByLevel 按保护级别对申请的权限分组
+func (r *PermissionReport) ByLevel() map[string][]string {
+	m := map[string][]string{}
+	for _, p := range r.Requested {
+		m[p.ProtectionLevel] = append(m[p.ProtectionLevel], p.Name)
+	}
+	return m
+}
+
+// Permissions 解析 apk 的 AndroidManifest.xml, 报告申请/声明的权限和 uses-feature
+func Permissions(apk []byte) (_ *PermissionReport, err error) {
+	defer catch(&err, "Permissions", "")
+	root, err := decodeManifest(apk)
+	if err != nil {
+		return nil, err
+	}
+	report := &PermissionReport{}
+	declared := map[string]string{}
+	for _, e := range root.Children {
+		if e.Name != "permission" {
+			continue
+		}
+		p := Permission{Name: e.AttrValue(axml.NSAndroid, "name"), ProtectionLevel: ProtectionNormal}
+		if a := e.Attr(axml.NSAndroid, "protectionLevel"); a != nil {
+			p.ProtectionLevel = protectionLevel(a)
+		}
+		declared[p.Name] = p.ProtectionLevel
+		report.Declared = append(report.Declared, p)
+	}
+	for _, e := range root.Children {
+		switch e.Name {
+		case "uses-permission", "uses-permission-sdk-23", "uses-permission-sdk-m":
+			p := Permission{Name: e.AttrValue(axml.NSAndroid, "name")}
+			p.MaxSDK, _ = strconv.Atoi(e.AttrValue(axml.NSAndroid, "maxSdkVersion"))
+			switch level, ok := declared[p.Name]; {
+			case ok:
+				p.ProtectionLevel = level
+			case dangerousPermissions[p.Name]:
+				p.ProtectionLevel = ProtectionDangerous
+			case signaturePermissions[p.Name]:
+				p.ProtectionLevel = ProtectionSignature
+			case strings.HasPrefix(p.Name, "android.permission."):
+				p.ProtectionLevel = ProtectionNormal
+			default:
+				p.ProtectionLevel = ProtectionUnknown
+			}
+			report.Requested = append(report.Requested, p)
+		case "uses-feature":
+			f := Feature{Name: e.AttrValue(axml.NSAndroid, "name"), Required: e.AttrValue(axml.NSAndroid, "required") != "false"}
+			if a := e.Attr(axml.NSAndroid, "glEsVersion"); a != nil {
+				f.GLESVersion = fmt.Sprintf("%d.%d", a.Data>>16, a.Data&0xffff)
+			}
+			report.Features = append(report.Features, f)
+		}
+	}
+	sort.SliceStable(report.Requested, func(i, j int) bool { return report.Requested[i].Name < report.Requested[j].Name })
+	return report, nil
+}
+
+// protectionLevel 将 android:protectionLevel 的基础级别转换为名称, 忽略 privileged 等附加标志
+func protectionLevel(a *axml.Attr) string {
+	if a.Type == axml.TypeString {
+		base, _, _ := strings.Cut(a.Raw, "|")
+		return base
+	}
+	switch a.Data & 0xf {
+	case 0:
+		return ProtectionNormal
+	case 1:
+		return ProtectionDangerous
+	case 2, 3: // signatureOrSystem 视为 signature
+		return ProtectionSignature
+	}
+	return ProtectionUnknown
+}
+
+// decodeManifest 读取并解析 apk 中的二进制 AndroidManifest.xml
+func decodeManifest(apk []byte) (*axml.Element, error) {
+	r, err := zip.NewReader(bytes.NewReader(apk), int64(len(apk)))
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := readManifest(r)
+	if err != nil {
+		return nil, err
+	}
+	return parseManifest(manifest)
+}
@@ -0,0 +1,50 @@
+package editor
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/pzx521521/apk-editor/editor/signv2"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+func TestPermissions(t *testing.T) {
+	apk, err := os.ReadFile("../release/app-release.apk")
+	if err != nil {
+		t.Skip(err)
+	}
+	report, err := Permissions(apk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Requested) == 0 {
+		t.Fatal("no permissions found")
+	}
+	for _, p := range report.Requested {
+		if p.Name == "" || p.ProtectionLevel == "" {
+			t.Errorf("incomplete permission %+v", p)
+		}
+		if p.Name == "android.permission.INTERNET" && p.ProtectionLevel != ProtectionNormal {
+			t.Errorf("INTERNET = %s, want normal", p.ProtectionLevel)
+		}
+	}
+}
+
+func TestManifestErrorContext(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	f, err := w.Create(zip.ANDROIDMANIFEST)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("<manifest/>"))
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Permissions(buf.Bytes())
+	loc, ok := signv2.ErrorContext(err)
+	if want := (signv2.Location{Offset: 0, Chunk: "document", Entry: zip.ANDROIDMANIFEST}); !ok || loc != want {
+		t.Errorf("ErrorContext(%v) = %+v, want %+v", err, loc, want)
+	}
+}
@@ -0,0 +1,94 @@
+package editor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+// EditPlan 描述 Edit 会做的修改, 用于 dry-run
+type EditPlan struct {
+	Added    []*MergeEntry    // 新增的条目
+	Replaced []*MergeEntry    // 覆盖已有同名条目
+	Deleted  []string         // DeleteFile 删除的条目
+	Manifest []ManifestChange // manifest 属性修改
+	OldSize  int64
+	NewSize  int64  // 签名后输出的实际大小
+	Scheme   string // 使用的签名方案
+}
+
+// Plan 在内存中执行一次 Edit 并报告修改内容, 不产生任何输出文件
+func (a *ApkEditor) Plan() (_ *EditPlan, err error) {
+	defer catch(&err, "Plan", "")
+	modifyContent, err := a.modifyContent()
+	if err != nil {
+		return nil, err
+	}
+	r, err := zip.NewReader(bytes.NewReader(a.apkRaw), int64(len(a.apkRaw)))
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]bool, len(r.File))
+	for _, f := range r.File {
+		existing[f.Name] = true
+	}
+	plan := &EditPlan{OldSize: int64(len(a.apkRaw)), Scheme: "v2"}
+	for _, e := range modifyContent {
+		if existing[e.Name] {
+			plan.Replaced = append(plan.Replaced, e)
+		} else {
+			plan.Added = append(plan.Added, e)
+		}
+	}
+	for _, e := range a.files {
+		switch {
+		case e.delete:
+			if existing[e.name] {
+				plan.Deleted = append(plan.Deleted, e.name)
+			}
+		case existing[e.name]:
+			plan.Replaced = append(plan.Replaced, &MergeEntry{e.name, e.data})
+		default:
+			plan.Added = append(plan.Added, &MergeEntry{e.name, e.data})
+		}
+	}
+	if a.Manifest != nil {
+		plan.Manifest = a.Manifest.Changes()
+	}
+	out, err := a.Edit()
+	if err != nil {
+		return nil, err
+	}
+	plan.NewSize = int64(len(out))
+	return plan, nil
+}
+
+// Print 把修改内容逐行写到 w, dry-run 时的输出
+func (p *EditPlan) Print(w io.Writer) error {
+	lines := []string{"dry-run, nothing written"}
+	for _, e := range p.Added {
+		lines = append(lines, fmt.Sprintf("  + %s (%d bytes)", e.Name, len(e.Data)))
+	}
+	for _, e := range p.Replaced {
+		lines = append(lines, fmt.Sprintf("  ~ %s (%d bytes)", e.Name, len(e.Data)))
+	}
+	for _, name := range p.Deleted {
+		lines = append(lines, fmt.Sprintf("  - %s", name))
+	}
+	for _, c := range p.Manifest {
+		lines = append(lines, fmt.Sprintf("  manifest %s: %q -> %q", c.Attr, c.Old, c.New))
+	}
+	scheme := p.Scheme
+	if scheme == "" {
+		scheme = "none (unsigned)"
+	}
+	lines = append(lines, fmt.Sprintf("size: %d -> %d bytes, signature scheme %s", p.OldSize, p.NewSize, scheme))
+	for _, l := range lines {
+		if _, err := fmt.Fprintln(w, l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
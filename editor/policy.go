@@ -0,0 +1,157 @@
+package editor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pzx521521/apk-editor/editor/axml"
+)
+
+// 签名策略规则
+const (
+	PolicyPackage    = "package"
+	PolicyTargetSDK  = "target-sdk"
+	PolicyPermission = "forbidden-permission"
+	PolicySize       = "max-size"
+)
+
+// Policy 签名服务与命令行在签名前检查 apk 的策略, 零值不做任何限制.
+// 可由 JSON 文件加载, 见 LoadPolicy
+type Policy struct {
+	// Packages 允许签名的包名, 支持 path.Match 通配, 如 com.example.*, 为空时不限制
+	Packages []string `json:"packages,omitempty"`
+	// MinTargetSDK 要求的最低 targetSdkVersion, 未声明 targetSdkVersion 时按 minSdkVersion 计算
+	MinTargetSDK int `json:"min_target_sdk,omitempty"`
+	// ForbiddenPermissions 禁止申请的权限, 同样支持通配, 如 android.permission.READ_SMS
+	ForbiddenPermissions []string `json:"forbidden_permissions,omitempty"`
+	MaxSize              int64    `json:"max_size,omitempty"` // apk 的最大字节数, 0 表示不限
+}
+
+// PolicyViolation 一条违反的策略
+type PolicyViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func (v *PolicyViolation) String() string {
+	return fmt.Sprintf("[%s] %s", v.Rule, v.Message)
+}
+
+// PolicyReport 策略检查结果, 同时记录检查时读到的值
+type PolicyReport struct {
+	Package     string             `json:"package"`
+	TargetSDK   int                `json:"target_sdk"`
+	Size        int64              `json:"size"`
+	Permissions []string           `json:"permissions,omitempty"`
+	Violations  []*PolicyViolation `json:"violations,omitempty"`
+}
+
+// Passed 是否未违反任何策略
+func (r *PolicyReport) Passed() bool {
+	return len(r.Violations) == 0
+}
+
+// PolicyError 违反策略时 Policy.Check 返回的错误, 带有完整报告, 服务据此返回结构化的拒绝原因
+type PolicyError struct {
+	Report *PolicyReport
+}
+
+func (e *PolicyError) Error() string {
+	msgs := make([]string, len(e.Report.Violations))
+	for i, v := range e.Report.Violations {
+		msgs[i] = v.String()
+	}
+	return "policy violation: " + strings.Join(msgs, "; ")
+}
+
+// LoadPolicy 从 JSON 文件加载策略, 未知字段视为错误, 以免拼错的规则被静默忽略
+func LoadPolicy(name string) (*Policy, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	p := &Policy{}
+	if err = d.Decode(p); err != nil {
+		return nil, fmt.Errorf("policy %s: %w", name, err)
+	}
+	for _, pattern := range append(p.Packages, p.ForbiddenPermissions...) {
+		if _, err = path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("policy %s: bad pattern %q", name, pattern)
+		}
+	}
+	return p, nil
+}
+
+// Evaluate 按策略检查 apk, 返回所有违反的规则. 只有 apk 无法解析时才返回错误
+func (p *Policy) Evaluate(apk []byte) (_ *PolicyReport, err error) {
+	defer catch(&err, "Policy.Evaluate", "")
+	report := &PolicyReport{Size: int64(len(apk))}
+	add := func(rule, format string, args ...any) {
+		report.Violations = append(report.Violations, &PolicyViolation{rule, fmt.Sprintf(format, args...)})
+	}
+	// 大小无需解析, 先于解析检查, 超限的上传不必再解压 manifest
+	if p.MaxSize > 0 && report.Size > p.MaxSize {
+		add(PolicySize, "apk is %d bytes, limit is %d", report.Size, p.MaxSize)
+	}
+	root, err := decodeManifest(apk)
+	if err != nil {
+		return nil, err
+	}
+	report.Package = root.AttrValue("", "package")
+	for _, e := range root.Children {
+		switch e.Name {
+		case "uses-sdk":
+			report.TargetSDK, _ = strconv.Atoi(e.AttrValue(axml.NSAndroid, "targetSdkVersion"))
+			if report.TargetSDK == 0 {
+				report.TargetSDK, _ = strconv.Atoi(e.AttrValue(axml.NSAndroid, "minSdkVersion"))
+			}
+		case "uses-permission", "uses-permission-sdk-23", "uses-permission-sdk-m":
+			report.Permissions = append(report.Permissions, e.AttrValue(axml.NSAndroid, "name"))
+		}
+	}
+
+	if len(p.Packages) > 0 && !matchAny(p.Packages, report.Package) {
+		add(PolicyPackage, "package %s is not allowed", report.Package)
+	}
+	if p.MinTargetSDK > 0 && report.TargetSDK < p.MinTargetSDK {
+		add(PolicyTargetSDK, "targetSdkVersion %d is below the required %d", report.TargetSDK, p.MinTargetSDK)
+	}
+	for _, perm := range report.Permissions {
+		if matchAny(p.ForbiddenPermissions, perm) {
+			add(PolicyPermission, "permission %s is forbidden", perm)
+		}
+	}
+	return report, nil
+}
+
+// Check 同 Evaluate, 违反策略时返回 *PolicyError. p 为 nil 时不做检查
+func (p *Policy) Check(apk []byte) error {
+	if p == nil {
+		return nil
+	}
+	report, err := p.Evaluate(apk)
+	if err != nil {
+		return err
+	}
+	if !report.Passed() {
+		return &PolicyError{report}
+	}
+	return nil
+}
+
+// matchAny 判断 name 是否匹配 patterns 中的任意一个
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package editor
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pzx521521/apk-editor/editor/apktest"
+)
+
+func TestPolicy(t *testing.T) {
+	apk, err := apktest.Build(apktest.Config{
+		Package:     "com.example.policy",
+		MinSDK:      21,
+		TargetSDK:   30,
+		Permissions: []string{"android.permission.INTERNET", "android.permission.READ_SMS"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	allowed := &Policy{Packages: []string{"com.example.*"}, MinTargetSDK: 30, ForbiddenPermissions: []string{"android.permission.CAMERA"}}
+	if err = allowed.Check(apk); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if err = (*Policy)(nil).Check(apk); err != nil {
+		t.Fatalf("nil policy: %v", err)
+	}
+
+	strict := &Policy{
+		Packages:             []string{"org.example.app"},
+		MinTargetSDK:         34,
+		ForbiddenPermissions: []string{"android.permission.*SMS"},
+		MaxSize:              16,
+	}
+	err = strict.Check(apk)
+	var pe *PolicyError
+	if !errors.As(err, &pe) {
+		t.Fatalf("Check = %v, want *PolicyError", err)
+	}
+	r := pe.Report
+	if r.Package != "com.example.policy" || r.TargetSDK != 30 || r.Size != int64(len(apk)) {
+		t.Errorf("report = %+v", r)
+	}
+	var rules []string
+	for _, v := range r.Violations {
+		rules = append(rules, v.Rule)
+	}
+	want := []string{PolicySize, PolicyPackage, PolicyTargetSDK, PolicyPermission}
+	if len(rules) != len(want) {
+		t.Fatalf("violations %v, want %v", rules, want)
+	}
+	for i := range want {
+		if rules[i] != want[i] {
+			t.Errorf("violations %v, want %v", rules, want)
+		}
+	}
+
+	name := filepath.Join(t.TempDir(), "policy.json")
+	os.WriteFile(name, []byte(`{"packages": ["com.example.*"], "min_target_sdk": 30}`), 0644)
+	p, err := LoadPolicy(name)
+	if err != nil || p.MinTargetSDK != 30 || p.Packages[0] != "com.example.*" {
+		t.Errorf("LoadPolicy = %+v, %v", p, err)
+	}
+	os.WriteFile(name, []byte(`{"min_target": 30}`), 0644)
+	if _, err = LoadPolicy(name); err == nil {
+		t.Error("LoadPolicy accepted an unknown rule")
+	}
+}
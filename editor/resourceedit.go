@@ -0,0 +1,61 @@
+package editor
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/pzx521521/apk-editor/editor/arsc"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+// EditResourcesFunc 解码 apk 的 resources.arsc, 用 edit 修改后重新编码写回, 返回未签名的 apk.
+// 资源表按 zipalign 的规则不压缩且 4 字节对齐, 其他条目原样保留, 需要重新签名
+func EditResourcesFunc(apk []byte, edit func(t *arsc.Table) error) (_ []byte, err error) {
+	defer catch(&err, "EditResourcesFunc", "resources.arsc")
+	r, err := zip.NewReader(bytes.NewReader(apk), int64(len(apk)))
+	if err != nil {
+		return nil, err
+	}
+	var b []byte
+	for _, f := range r.File {
+		if f.Name == "resources.arsc" {
+			rc, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			b, err = io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				return nil, entryError(f.Name, err)
+			}
+		}
+	}
+	if b == nil {
+		return nil, errors.New("no resources.arsc found")
+	}
+	table, err := arsc.Parse(b)
+	if err != nil {
+		return nil, entryError("resources.arsc", err)
+	}
+	if err = edit(table); err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	buf.Write(apk[:r.AppendOffset()])
+	// 同名条目在 Close 时被替换
+	w := r.Append(buf, false)
+	if err = merge(w, &MergeEntry{"resources.arsc", table.Encode()}); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	if signv2.SelfCheckEnabled() {
+		if err = checkEdited(buf.Bytes()); err != nil {
+			return nil, &signv2.SelfCheckError{Op: "edit resources", Err: err}
+		}
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,75 @@
+package editor
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/pzx521521/apk-editor/editor/apktest"
+	"github.com/pzx521521/apk-editor/editor/arsc"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+func TestEditResourcesFunc(t *testing.T) {
+	apk, err := apktest.BuildSigned(apktest.Config{Label: "Old"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	edited, err := EditResourcesFunc(apk, func(table *arsc.Table) error {
+		table.Package(0x7f).Name = "org.renamed"
+		return table.SetString(apktest.LabelID, "New")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := apktest.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := signv2.NewApkSign(edited)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := z.SignV2([]*signv2.SigningCert{key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = signv2.CheckSigned(signed); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := zip.NewReader(bytes.NewReader(signed), int64(len(signed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, f := range r.File {
+		if f.Name != "resources.arsc" {
+			continue
+		}
+		n++
+		// 资源表必须不压缩且 4 字节对齐, 否则 Android 11 起拒绝安装
+		off, err := f.DataOffset()
+		if err != nil || f.Method != zip.Store || off%4 != 0 {
+			t.Errorf("resources.arsc method %d at %d, %v", f.Method, off, err)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(rc)
+		rc.Close()
+		table, err := arsc.Parse(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vs, err := table.Values(apktest.LabelID)
+		if err != nil || len(vs) != 1 || vs[0].String != "New" || table.Package(0x7f).Name != "org.renamed" {
+			t.Errorf("label %+v, %v, package %q", vs, err, table.Package(0x7f).Name)
+		}
+	}
+	if n != 1 {
+		t.Errorf("%d resources.arsc entries", n)
+	}
+}
@@ -0,0 +1,152 @@
+package editor
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+
+	"github.com/pzx521521/apk-editor/editor/axml"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+)
+
+// sdkDevelopment 预览版 SDK 的代号 (如 "VanillaIceCream") 对应的 API 级别, 与 Build.VERSION_CODES.CUR_DEVELOPMENT 相同
+const sdkDevelopment = 10000
+
+// sdkV2 Android 7.0 起验证 v2 签名, 更低的版本只认 v1
+const sdkV2 = 24
+
+// SDKVersions AndroidManifest.xml 中 uses-sdk 声明的 API 级别
+type SDKVersions struct {
+	Min    int // minSdkVersion, 未声明时为 1
+	Target int // targetSdkVersion, 未声明时等于 Min
+}
+
+// ReadSDKVersions 从 apk 的二进制 AndroidManifest.xml 读取 minSdkVersion 和 targetSdkVersion.
+// 预览版 SDK 的代号按 10000 计算
+func ReadSDKVersions(apk []byte) (_ SDKVersions, err error) {
+	defer catch(&err, "ReadSDKVersions", "")
+	root, err := decodeManifest(apk)
+	if err != nil {
+		return SDKVersions{}, err
+	}
+	return manifestSDKVersions(root), nil
+}
+
+// manifestSDKVersions 按 PackageManager 的规则读取 uses-sdk
+func manifestSDKVersions(root *axml.Element) SDKVersions {
+	v := SDKVersions{Min: 1}
+	for _, e := range root.Children {
+		if e.Name != "uses-sdk" {
+			continue
+		}
+		if s := e.AttrValue(axml.NSAndroid, "minSdkVersion"); s != "" {
+			v.Min = sdkLevel(s)
+		}
+		if s := e.AttrValue(axml.NSAndroid, "targetSdkVersion"); s != "" {
+			v.Target = sdkLevel(s)
+		}
+	}
+	if v.Target == 0 {
+		v.Target = v.Min
+	}
+	return v
+}
+
+func sdkLevel(s string) int {
+	if n, err := strconv.Atoi(s); err == nil {
+		return n
+	}
+	return sdkDevelopment
+}
+
+// AutoSchemes 按 apksigner 的默认规则选择签名方案: minSdkVersion 低于 24 时需要 v1 (低于 18 时用 SHA-1);
+// 总是签 v2, 因为 targetSdkVersion 达到 30 时只有 v1 签名的 apk 无法安装; 所有密钥共用一个证书时加签 v3
+// (v3 只允许一个签名者); v4 为 true 时加上 v4. keys 会被 Resolve
+func AutoSchemes(sdk SDKVersions, keys []*signv2.SigningCert, v4 bool) ([]signv2.Scheme, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no signing keys")
+	}
+	for _, sk := range keys {
+		if err := sk.Resolve(); err != nil {
+			return nil, err
+		}
+	}
+	var schemes []signv2.Scheme
+	if sdk.Min < sdkV2 {
+		schemes = append(schemes, signv2.SchemeV1)
+	}
+	schemes = append(schemes, signv2.SchemeV2)
+	if !slices.ContainsFunc(keys, func(sk *signv2.SigningCert) bool { return sk.CertHash != keys[0].CertHash }) {
+		schemes = append(schemes, signv2.SchemeV3)
+	}
+	if v4 {
+		schemes = append(schemes, signv2.SchemeV4)
+	}
+	return schemes, nil
+}
+
+// AutoSigned SignAuto 的结果
+type AutoSigned struct {
+	APK     []byte
+	IDSig   []byte // v4 签名, 需要与 apk 一起安装 (adb install --incremental), 未签 v4 时为空
+	SDK     SDKVersions
+	Schemes []signv2.Scheme // AutoSchemes 选择的方案
+}
+
+// SignAuto 按 manifest 声明的 API 级别用 AutoSchemes 选出的方案签名 apk, 调用方无需了解各 Android 版本的要求.
+// 签名时附加 signv2.WithMinSdk
+func SignAuto(apk []byte, keys []*signv2.SigningCert, v4 bool, opts ...signv2.Option) (_ *AutoSigned, err error) {
+	defer catch(&err, "SignAuto", "")
+	sdk, err := ReadSDKVersions(apk)
+	if err != nil {
+		return nil, err
+	}
+	schemes, err := AutoSchemes(sdk, keys, v4)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts[:len(opts):len(opts)], signv2.WithMinSdk(sdk.Min))
+	res := &AutoSigned{SDK: sdk, Schemes: schemes}
+	if res.APK, res.IDSig, err = SignSchemes(apk, keys, schemes, opts...); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// SignSchemes 用 schemes 中的方案签名 apk, 返回签名后的 apk 和 v4 签名 (未包含 v4 时为空).
+// v2 总会签上; 包含 v3 时与 v2 一起写入签名块. 未对齐的 apk 由 v1 重写或 v2/v3 签名时对齐
+func SignSchemes(apk []byte, keys []*signv2.SigningCert, schemes []signv2.Scheme, opts ...signv2.Option) (signed, idsig []byte, err error) {
+	defer catch(&err, "SignSchemes", "")
+	z, err := signv2.NewApkSign(apk, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if slices.Contains(schemes, signv2.SchemeV1) {
+		// v1 签名重写 zip, 须在 v2 之前; .SF 中声明随后的 v2 (v3), 防止它们被去掉后降级为 v1
+		v1SignedWith := []signv2.Scheme{signv2.SchemeV2}
+		if slices.Contains(schemes, signv2.SchemeV3) {
+			v1SignedWith = append(v1SignedWith, signv2.SchemeV3)
+		}
+		b, err := z.SignV1(keys, append(opts[:len(opts):len(opts)], signv2.WithV1SignedWith(v1SignedWith...))...)
+		if err != nil {
+			return nil, nil, err
+		}
+		if z, err = signv2.NewApkSignNoCopy(b, opts...); err != nil {
+			return nil, nil, err
+		}
+	}
+	if slices.Contains(schemes, signv2.SchemeV3) {
+		signed, err = z.SignV3(keys, nil, opts...)
+	} else {
+		signed, err = z.SignV2(keys, opts...)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if slices.Contains(schemes, signv2.SchemeV4) {
+		if idsig, err = signv2.SignV4(signed, keys); err != nil {
+			return nil, nil, err
+		}
+	}
+	return signed, idsig, nil
+}
@@ -0,0 +1,122 @@
+package editor
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/pzx521521/apk-editor/editor/apktest"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+func TestReadSDKVersions(t *testing.T) {
+	for _, c := range []struct {
+		min, target int
+		want        SDKVersions
+	}{
+		{0, 0, SDKVersions{1, 1}},
+		{21, 0, SDKVersions{21, 21}},
+		{24, 34, SDKVersions{24, 34}},
+	} {
+		apk, err := apktest.Build(apktest.Config{MinSDK: c.min, TargetSDK: c.target})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ReadSDKVersions(apk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Errorf("min %d target %d: got %+v, want %+v", c.min, c.target, got, c.want)
+		}
+	}
+	if sdkLevel("VanillaIceCream") != sdkDevelopment {
+		t.Error("codename is not CUR_DEVELOPMENT")
+	}
+	if _, err := ReadSDKVersions([]byte("not a zip")); err == nil {
+		t.Error("ReadSDKVersions accepted a non-zip")
+	}
+}
+
+func TestSignAuto(t *testing.T) {
+	key, err := apktest.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []*signv2.SigningCert{key}
+	for _, c := range []struct {
+		min  int
+		v4   bool
+		want []signv2.Scheme
+	}{
+		{15, false, []signv2.Scheme{signv2.SchemeV1, signv2.SchemeV2, signv2.SchemeV3}},
+		{21, false, []signv2.Scheme{signv2.SchemeV1, signv2.SchemeV2, signv2.SchemeV3}},
+		{24, false, []signv2.Scheme{signv2.SchemeV2, signv2.SchemeV3}},
+		{28, true, []signv2.Scheme{signv2.SchemeV2, signv2.SchemeV3, signv2.SchemeV4}},
+	} {
+		apk, err := apktest.Build(apktest.Config{MinSDK: c.min, TargetSDK: 34})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := SignAuto(apk, keys, c.v4)
+		if err != nil {
+			t.Fatalf("min %d: %v", c.min, err)
+		}
+		if !slices.Equal(res.Schemes, c.want) || res.SDK.Min != c.min || (res.IDSig != nil) != c.v4 {
+			t.Errorf("min %d: schemes %v, sdk %+v, idsig %d bytes", c.min, res.Schemes, res.SDK, len(res.IDSig))
+		}
+		z, err := signv2.NewApkSign(res.APK)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := z.VerifyAll(signv2.VerifyOptions{MinSDK: c.min, IDSig: res.IDSig})
+		if !r.Verified {
+			t.Fatalf("min %d: %v", c.min, r.Errors())
+		}
+		for _, s := range c.want {
+			if sr := r.Scheme(s); sr == nil || !sr.Present || !sr.Verified {
+				t.Errorf("min %d: %v not verified: %+v", c.min, s, sr)
+			}
+		}
+		// 双签名时 v1 声明了 v2 和 v3, 没有降级的警告
+		if slices.ContainsFunc(r.Warnings, func(w string) bool { return strings.Contains(w, "X-Android-APK-Signed") }) {
+			t.Errorf("min %d: warnings %q", c.min, r.Warnings)
+		}
+		// Android 4.3 (18) 之前 v1 只能用 SHA-1
+		if c.min < 18 {
+			zr, err := zip.NewReader(bytes.NewReader(res.APK), int64(len(res.APK)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var mf []byte
+			for _, f := range zr.File {
+				if f.Name == "META-INF/MANIFEST.MF" {
+					if mf, err = readEntry(f); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			if !bytes.Contains(mf, []byte("SHA1-Digest: ")) || bytes.Contains(mf, []byte("SHA-256")) {
+				t.Errorf("min %d: manifest\n%s", c.min, mf)
+			}
+		}
+	}
+
+	// v3 只允许一个签名者, 证书不同的密钥只签 v2
+	other, err := signv2.GenerateDebugCert("other", 0, signv2.EC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schemes, err := AutoSchemes(SDKVersions{Min: 24, Target: 34}, []*signv2.SigningCert{key, other}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(schemes, []signv2.Scheme{signv2.SchemeV2}) {
+		t.Errorf("two certificates: %v", schemes)
+	}
+	if _, err = AutoSchemes(SDKVersions{}, nil, false); err == nil {
+		t.Error("AutoSchemes accepted no keys")
+	}
+}
@@ -0,0 +1,244 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pzx521521/apk-editor/editor"
+	"github.com/pzx521521/apk-editor/editor/audit"
+	pw "github.com/pzx521521/apk-editor/editor/internal/protowire"
+)
+
+// GRPCServicePath is the path prefix of the methods declared in apkeditor.proto.
+const GRPCServicePath = "/apkeditor.v1.ApkEditor/"
+
+const grpcChunkSize = 1 << 20
+
+// gRPC status codes used by the handler.
+const (
+	grpcOK                 = 0
+	grpcCancelled          = 1
+	grpcInvalidArgument    = 3
+	grpcDeadlineExceeded   = 4
+	grpcResourceExhausted  = 8
+	grpcFailedPrecondition = 9
+	grpcUnimplemented      = 12
+	grpcInternal           = 13
+	grpcUnauthenticated    = 16
+)
+
+type grpcError struct {
+	code int
+	msg  string
+}
+
+func (e *grpcError) Error() string { return e.msg }
+
+// NewGRPCHandler serves the ApkEditor service of apkeditor.proto. gRPC needs
+// HTTP/2, which net/http only negotiates over TLS, so mount it on a server
+// started with ListenAndServeTLS. Authentication uses the same API keys as the
+// HTTP API, passed as "x-api-key" or "authorization: Bearer" metadata.
+func NewGRPCHandler(s *Service, opts HTTPOptions) http.Handler {
+	if opts.MaxUpload <= 0 {
+		opts.MaxUpload = DefaultMaxUpload
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			http.Error(w, "not a grpc request", http.StatusUnsupportedMediaType)
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		err := serveGRPC(s, opts, w, r)
+		code, msg := grpcOK, ""
+		if err != nil {
+			code, msg = grpcInternal, err.Error()
+			var ge *grpcError
+			var pe *editor.PolicyError
+			switch {
+			case errors.As(err, &ge):
+				code = ge.code
+			case errors.As(err, &pe):
+				code = grpcFailedPrecondition
+			case errors.Is(err, context.Canceled):
+				code = grpcCancelled
+			case errors.Is(err, context.DeadlineExceeded):
+				code = grpcDeadlineExceeded
+			}
+		}
+		w.Header().Set("Grpc-Status", strconv.Itoa(code))
+		w.Header().Set("Grpc-Message", msg)
+	})
+}
+
+func serveGRPC(s *Service, opts HTTPOptions, w http.ResponseWriter, r *http.Request) error {
+	if !opts.allowed(r) {
+		return &grpcError{grpcUnauthenticated, "invalid api key"}
+	}
+	r = r.WithContext(audit.WithActor(r.Context(), opts.actor(r)))
+	msgs, err := readGRPCMessages(http.MaxBytesReader(nil, r.Body, opts.MaxUpload), opts.MaxUpload)
+	if err != nil {
+		var ge *grpcError
+		if errors.As(err, &ge) {
+			return err
+		}
+		return &grpcError{grpcInvalidArgument, err.Error()}
+	}
+	method := strings.TrimPrefix(r.URL.Path, GRPCServicePath)
+	switch method {
+	case "Sign", "Verify", "Info":
+		var apk []byte
+		for _, m := range msgs {
+			fields, err := pw.Fields(m)
+			if err != nil {
+				return &grpcError{grpcInvalidArgument, err.Error()}
+			}
+			for _, f := range fields {
+				if f.Num == 1 {
+					apk = append(apk, f.Bytes...)
+				}
+			}
+		}
+		switch method {
+		case "Sign":
+			signed, err := s.Sign(r.Context(), apk)
+			if err != nil {
+				return err
+			}
+			return writeGRPCChunks(w, signed)
+		case "Verify":
+			var resp []byte
+			if err := s.Verify(r.Context(), apk); err != nil {
+				resp = pw.AppendString(resp, 2, err.Error())
+			} else {
+				resp = pw.AppendBool(resp, 1, true)
+			}
+			return writeGRPCMessage(w, resp)
+		default:
+			info, err := s.Info(apk)
+			if err != nil {
+				return err
+			}
+			var resp []byte
+			resp = pw.AppendUint(resp, 1, uint64(info.Size))
+			resp = pw.AppendBool(resp, 2, info.IsAPK)
+			resp = pw.AppendBool(resp, 3, info.IsV2Signed)
+			for _, e := range info.Entries {
+				resp = pw.AppendString(resp, 4, e)
+			}
+			resp = pw.AppendBool(resp, 5, info.DebugSigned)
+			return writeGRPCMessage(w, resp)
+		}
+	case "EditManifest":
+		var apk []byte
+		var m *editor.Manifest
+		for _, msg := range msgs {
+			fields, err := pw.Fields(msg)
+			if err != nil {
+				return &grpcError{grpcInvalidArgument, err.Error()}
+			}
+			for _, f := range fields {
+				switch f.Num {
+				case 1:
+					if m, err = decodeManifest(f.Bytes); err != nil {
+						return &grpcError{grpcInvalidArgument, err.Error()}
+					}
+				case 2:
+					apk = append(apk, f.Bytes...)
+				}
+			}
+		}
+		if m == nil {
+			return &grpcError{grpcInvalidArgument, "manifest is required"}
+		}
+		edited, err := s.EditManifest(r.Context(), apk, m)
+		if err != nil {
+			return err
+		}
+		return writeGRPCChunks(w, edited)
+	}
+	return &grpcError{grpcUnimplemented, fmt.Sprintf("unknown method %q", method)}
+}
+
+func decodeManifest(b []byte) (*editor.Manifest, error) {
+	fields, err := pw.Fields(b)
+	if err != nil {
+		return nil, err
+	}
+	m := &editor.Manifest{}
+	for _, f := range fields {
+		switch f.Num {
+		case 1:
+			m.VersionCode = uint32(f.Varint)
+		case 2:
+			m.VersionName = string(f.Bytes)
+		case 3:
+			m.Label = string(f.Bytes)
+		case 4:
+			m.Package = string(f.Bytes)
+		}
+	}
+	return m, nil
+}
+
+// readGRPCMessages reads length-prefixed gRPC frames until the client closes
+// its side of the stream. The frames may total at most limit bytes, headers
+// included; a frame declaring more than what is left is rejected before its
+// buffer is allocated.
+func readGRPCMessages(r io.Reader, limit int64) ([][]byte, error) {
+	var msgs [][]byte
+	var hdr [5]byte
+	for {
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			if err == io.EOF {
+				return msgs, nil
+			}
+			return nil, err
+		}
+		if hdr[0] != 0 {
+			return nil, errors.New("compressed grpc messages are not supported")
+		}
+		limit -= int64(len(hdr))
+		n := int64(binary.BigEndian.Uint32(hdr[1:]))
+		if n > limit {
+			return nil, &grpcError{grpcResourceExhausted, fmt.Sprintf("grpc message of %d bytes exceeds the upload limit", n)}
+		}
+		limit -= n
+		msg := make([]byte, n)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+func writeGRPCMessage(w io.Writer, msg []byte) error {
+	var hdr [5]byte
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(msg)))
+	_, err := w.Write(append(hdr[:], msg...))
+	return err
+}
+
+// writeGRPCChunks streams apk back as a sequence of ApkChunk messages.
+func writeGRPCChunks(w io.Writer, apk []byte) error {
+	r := bytes.NewReader(apk)
+	buf := make([]byte, grpcChunkSize)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if err := writeGRPCMessage(w, pw.AppendBytes(nil, 1, buf[:n])); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pzx521521/apk-editor/editor"
+	"github.com/pzx521521/apk-editor/editor/apktest"
+	pw "github.com/pzx521521/apk-editor/editor/internal/protowire"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+)
+
+func testGRPCServer(t *testing.T, opts HTTPOptions) *httptest.Server {
+	svc, err := New(testKeys(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(NewHTTPHandler(svc, opts))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// grpcCall sends body to method and returns the response messages and the
+// grpc-status trailer.
+func grpcCall(t *testing.T, srv *httptest.Server, method string, body []byte) ([][]byte, string) {
+	req, _ := http.NewRequest("POST", srv.URL+GRPCServicePath+method, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/grpc")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("expected HTTP/2, got %s", resp.Proto)
+	}
+	msgs, err := readGRPCMessages(resp.Body, DefaultMaxUpload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	if status := resp.Trailer.Get("Grpc-Status"); status != "0" {
+		return msgs, status + ": " + resp.Trailer.Get("Grpc-Message")
+	}
+	return msgs, "0"
+}
+
+// grpcApk sends apk as ApkChunk messages, in two frames to exercise reassembly.
+func grpcApk(field int, apk []byte) []byte {
+	body := new(bytes.Buffer)
+	half := len(apk) / 2
+	writeGRPCMessage(body, pw.AppendBytes(nil, field, apk[:half]))
+	writeGRPCMessage(body, pw.AppendBytes(nil, field, apk[half:]))
+	return body.Bytes()
+}
+
+// grpcChunks joins the ApkChunk messages of a streamed response.
+func grpcChunks(t *testing.T, msgs [][]byte) []byte {
+	var apk []byte
+	for _, m := range msgs {
+		fields, err := pw.Fields(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range fields {
+			if f.Num == 1 {
+				apk = append(apk, f.Bytes...)
+			}
+		}
+	}
+	return apk
+}
+
+func TestGRPCInfo(t *testing.T) {
+	srv := testGRPCServer(t, HTTPOptions{})
+	msgs, status := grpcCall(t, srv, "Info", grpcApk(1, testZip(t)))
+	if status != "0" {
+		t.Fatalf("grpc-status = %s", status)
+	}
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 response message, got %d", len(msgs))
+	}
+	fields, err := pw.Fields(msgs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []string
+	for _, f := range fields {
+		if f.Num == 4 {
+			entries = append(entries, string(f.Bytes))
+		}
+	}
+	if len(entries) != 1 || entries[0] != "assets/index.html" {
+		t.Fatalf("unexpected entries %v", entries)
+	}
+}
+
+func TestGRPCSignVerify(t *testing.T) {
+	srv := testGRPCServer(t, HTTPOptions{})
+	msgs, status := grpcCall(t, srv, "Sign", grpcApk(1, testZip(t)))
+	if status != "0" {
+		t.Fatalf("Sign: grpc-status = %s", status)
+	}
+	signed := grpcChunks(t, msgs)
+	z, err := signv2.NewApkSign(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := z.VerifyV2(); err != nil {
+		t.Fatalf("signed output does not verify: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		apk   []byte
+		valid bool
+	}{
+		{"signed", signed, true},
+		{"unsigned", testZip(t), false},
+	} {
+		msgs, status := grpcCall(t, srv, "Verify", grpcApk(1, tc.apk))
+		if status != "0" || len(msgs) != 1 {
+			t.Fatalf("Verify %s: grpc-status = %s, %d messages", tc.name, status, len(msgs))
+		}
+		fields, err := pw.Fields(msgs[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		var valid bool
+		var msg string
+		for _, f := range fields {
+			switch f.Num {
+			case 1:
+				valid = f.Varint != 0
+			case 2:
+				msg = string(f.Bytes)
+			}
+		}
+		if valid != tc.valid || valid == (msg != "") {
+			t.Errorf("Verify %s: valid %v, error %q", tc.name, valid, msg)
+		}
+	}
+}
+
+func TestGRPCEditManifest(t *testing.T) {
+	srv := testGRPCServer(t, HTTPOptions{})
+	// EditManifest rewrites the values of the template apk, editor.DefaultManifest
+	d := editor.DefaultManifest
+	apk, err := apktest.Build(apktest.Config{Package: d.Package, VersionCode: int(d.VersionCode), VersionName: d.VersionName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m []byte
+	m = pw.AppendUint(m, 1, 7)
+	m = pw.AppendString(m, 2, "222.222.222")
+	body := new(bytes.Buffer)
+	writeGRPCMessage(body, pw.AppendBytes(nil, 1, m))
+	body.Write(grpcApk(2, apk))
+	msgs, status := grpcCall(t, srv, "EditManifest", body.Bytes())
+	if status != "0" {
+		t.Fatalf("grpc-status = %s", status)
+	}
+	edited := grpcChunks(t, msgs)
+	info, err := editor.ApkInfo(edited)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.VersionCode != 7 || info.VersionName != "222.222.222" || len(info.Signers) == 0 {
+		t.Errorf("got version %d %q, %d signers", info.VersionCode, info.VersionName, len(info.Signers))
+	}
+
+	if _, status = grpcCall(t, srv, "EditManifest", grpcApk(2, apk)); !strings.HasPrefix(status, "3:") {
+		t.Errorf("without a manifest: grpc-status = %s, want 3", status)
+	}
+}
+
+func TestGRPCOversizedFrame(t *testing.T) {
+	srv := testGRPCServer(t, HTTPOptions{MaxUpload: 1 << 10})
+	// the frame declares 2GB but carries a few bytes: it must be refused from
+	// its header, not by allocating the declared length
+	var hdr [5]byte
+	binary.BigEndian.PutUint32(hdr[1:], 1<<31)
+	if _, status := grpcCall(t, srv, "Info", append(hdr[:], "tiny"...)); !strings.HasPrefix(status, "8:") {
+		t.Errorf("grpc-status = %s, want 8 (resource exhausted)", status)
+	}
+
+	// frames within the limit one by one but not together
+	body := new(bytes.Buffer)
+	for range 3 {
+		writeGRPCMessage(body, make([]byte, 400))
+	}
+	if _, status := grpcCall(t, srv, "Info", body.Bytes()); !strings.HasPrefix(status, "8:") {
+		t.Errorf("grpc-status = %s, want 8 (resource exhausted)", status)
+	}
+
+	if _, err := readGRPCMessages(bytes.NewReader(append(hdr[:], "tiny"...)), 1<<40); err == nil {
+		t.Error("readGRPCMessages accepted a truncated frame")
+	}
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/pzx521521/apk-editor/editor"
+	"github.com/pzx521521/apk-editor/editor/audit"
+)
+
+// DefaultMaxUpload caps the accepted APK size when HTTPOptions.MaxUpload is 0.
+const DefaultMaxUpload = 1 << 30
+
+// HTTPOptions configures the HTTP front end.
+type HTTPOptions struct {
+	// APIKeys lists accepted keys, sent as "X-API-Key: <key>" or
+	// "Authorization: Bearer <key>". An empty list disables authentication.
+	APIKeys   []string
+	MaxUpload int64
+	// RemoteStorage enables POST /v1/sign-url, which reads and writes
+	// the APK in remote storage with the credentials of the server.
+	RemoteStorage bool
+}
+
+// NewHTTPHandler returns a handler serving:
+//
+//	POST /v1/sign   body: the APK (raw, or multipart field "apk"); response: the signed APK
+//	POST /v1/sign-url  body: {"input": URL, "output": URL}, if RemoteStorage is set
+//	GET  /healthz
+//
+// An APK rejected by the Policy of s gets 403 Forbidden with the JSON
+// editor.PolicyReport as body.
+//
+// and the gRPC methods of apkeditor.proto under GRPCServicePath.
+func NewHTTPHandler(s *Service, opts HTTPOptions) http.Handler {
+	if opts.MaxUpload <= 0 {
+		opts.MaxUpload = DefaultMaxUpload
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	})
+	mux.Handle("POST /v1/sign", opts.auth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apk, err := readUpload(r, opts.MaxUpload)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		signed, err := s.Sign(r.Context(), apk)
+		if err != nil {
+			writeSignError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.android.package-archive")
+		w.Header().Set("Content-Disposition", `attachment; filename="signed.apk"`)
+		w.Write(signed)
+	})))
+	if opts.RemoteStorage {
+		mux.Handle("POST /v1/sign-url", opts.auth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req struct {
+				Input  string `json:"input"`
+				Output string `json:"output"`
+			}
+			if err := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 1<<16)).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := s.SignURL(r.Context(), req.Input, req.Output); err != nil {
+				writeSignError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})))
+	}
+	mux.Handle("POST "+GRPCServicePath, NewGRPCHandler(s, opts))
+	return mux
+}
+
+// writeSignError reports a failed signature, with the policy report for a
+// policy violation.
+func writeSignError(w http.ResponseWriter, err error) {
+	var pe *editor.PolicyError
+	if !errors.As(err, &pe) {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(pe.Report)
+}
+
+func (o HTTPOptions) auth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !o.allowed(r) {
+			http.Error(w, "invalid api key", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(audit.WithActor(r.Context(), o.actor(r))))
+	})
+}
+
+func (o HTTPOptions) allowed(r *http.Request) bool {
+	if len(o.APIKeys) == 0 {
+		return true
+	}
+	key := apiKey(r)
+	for _, k := range o.APIKeys {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func readUpload(r *http.Request, limit int64) ([]byte, error) {
+	body := http.MaxBytesReader(nil, r.Body, limit)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		r.Body = body
+		f, _, err := r.FormFile("apk")
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return io.ReadAll(f)
+	}
+	return io.ReadAll(body)
+}
+
+func apiKey(r *http.Request) string {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		key = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	return key
+}
+
+// actor identifies the caller in audit events: by a digest of its API key,
+// never the key itself, or by its address when authentication is off.
+func (o HTTPOptions) actor(r *http.Request) string {
+	if len(o.APIKeys) == 0 {
+		return r.RemoteAddr
+	}
+	return "api-key:" + audit.Digest([]byte(apiKey(r)))[:12]
+}
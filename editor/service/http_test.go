@@ -0,0 +1,217 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pzx521521/apk-editor/editor"
+	"github.com/pzx521521/apk-editor/editor/apktest"
+	"github.com/pzx521521/apk-editor/editor/audit"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+)
+
+func testKeys(t *testing.T) []*signv2.SigningCert {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []*signv2.SigningCert{{
+		SigningKey: signv2.SigningKey{
+			KeyBytes: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+			Type:     signv2.RSA,
+			Hash:     signv2.SHA256,
+		},
+		CertBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}}
+}
+
+func testZip(t *testing.T) []byte {
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	f, _ := w.Create("assets/index.html")
+	f.Write([]byte("<html></html>"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestHTTPSign(t *testing.T) {
+	svc, err := New(testKeys(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(NewHTTPHandler(svc, HTTPOptions{APIKeys: []string{"secret"}}))
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/v1/sign", "application/octet-stream", bytes.NewReader(testZip(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without api key, got %d", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest("POST", srv.URL+"/v1/sign", bytes.NewReader(testZip(t)))
+	req.Header.Set("X-API-Key", "secret")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	signed := new(bytes.Buffer)
+	signed.ReadFrom(resp.Body)
+	z, err := signv2.NewApkSign(signed.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := z.VerifyV2(); err != nil {
+		t.Fatalf("signed output does not verify: %v", err)
+	}
+}
+
+func TestHTTPPolicy(t *testing.T) {
+	svc, err := New(testKeys(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.Policy = &editor.Policy{Packages: []string{"org.example.*"}}
+	srv := httptest.NewServer(NewHTTPHandler(svc, HTTPOptions{}))
+	defer srv.Close()
+
+	apk, err := apktest.Build(apktest.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(srv.URL+"/v1/sign", "application/octet-stream", bytes.NewReader(apk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var report editor.PolicyReport
+	if err = json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusForbidden || len(report.Violations) != 1 || report.Violations[0].Rule != editor.PolicyPackage {
+		t.Errorf("got %d %+v, want 403 with a package violation", resp.StatusCode, report)
+	}
+}
+
+func TestHTTPAudit(t *testing.T) {
+	svc, err := New(testKeys(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := new(bytes.Buffer)
+	svc.Audit = audit.NewFile(log)
+	srv := httptest.NewServer(NewHTTPHandler(svc, HTTPOptions{APIKeys: []string{"secret"}}))
+	defer srv.Close()
+
+	in := testZip(t)
+	req, _ := http.NewRequest("POST", srv.URL+"/v1/sign", bytes.NewReader(in))
+	req.Header.Set("X-API-Key", "secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	var e audit.Event
+	if err = json.Unmarshal(log.Bytes(), &e); err != nil {
+		t.Fatal(err)
+	}
+	want := audit.Event{Op: signv2.OpSign, Actor: "api-key:" + audit.Digest([]byte("secret"))[:12],
+		Input: audit.Digest(in), Output: audit.Digest(signed), Key: "test", Outcome: audit.OutcomeOK}
+	e.Time = time.Time{}
+	if e != want {
+		t.Errorf("audit event %+v, want %+v", e, want)
+	}
+	if strings.Contains(log.String(), "secret") {
+		t.Error("audit log contains the api key")
+	}
+
+	// nothing is signed without a record
+	svc.Audit = audit.Multi{&audit.Webhook{URL: srv.URL + "/missing"}}
+	if _, err = svc.Sign(context.Background(), in); err == nil {
+		t.Error("Sign succeeded although the audit event was not recorded")
+	}
+}
+
+func TestHTTPSignURL(t *testing.T) {
+	svc, err := New(testKeys(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	objects := map[string][]byte{"/in.apk": testZip(t)}
+	store := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method == http.MethodPut {
+			objects[r.URL.Path], _ = io.ReadAll(r.Body)
+			return
+		}
+		w.Write(objects[r.URL.Path])
+	}))
+	defer store.Close()
+	srv := httptest.NewServer(NewHTTPHandler(svc, HTTPOptions{RemoteStorage: true}))
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		in, out string
+		status  int
+	}{
+		{store.URL + "/in.apk", store.URL + "/out.apk", http.StatusNoContent},
+		{"/etc/passwd", store.URL + "/out.apk", http.StatusUnprocessableEntity},
+	} {
+		body := fmt.Sprintf(`{"input": %q, "output": %q}`, tt.in, tt.out)
+		resp, err := http.Post(srv.URL+"/v1/sign-url", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.in, resp.StatusCode, tt.status)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	z, err := signv2.NewApkSign(objects["/out.apk"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := z.VerifyV2(); err != nil {
+		t.Fatalf("stored output does not verify: %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+// Package service exposes the editor and signer as a network service, so
+// build platforms can sign APKs without linking the library themselves.
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pzx521521/apk-editor/editor"
+	"github.com/pzx521521/apk-editor/editor/audit"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+	"github.com/pzx521521/apk-editor/editor/storage"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+// Service signs uploaded APKs with a fixed set of keys. It is safe for
+// concurrent use.
+type Service struct {
+	keys []*signv2.SigningCert
+	// Policy, if set, is checked before every signature; a rejected APK
+	// fails with an *editor.PolicyError. Set it before serving.
+	Policy *editor.Policy
+	// Audit, if set, records every sign, verify and edit operation. A
+	// signature that cannot be recorded fails. Set it before serving.
+	Audit audit.Sink
+	// KeyAlias names the signing key in audit events; New sets it to the
+	// common name of the certificate of the first key.
+	KeyAlias string
+	// SigningCert.Resolve writes back into the key, so signing calls are
+	// serialized until the signer itself is safe to share.
+	mu sync.Mutex
+}
+
+// New resolves the keys once up front so a broken key is reported at
+// start-up rather than on the first request.
+func New(keys []*signv2.SigningCert) (*Service, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no signing keys configured")
+	}
+	for _, k := range keys {
+		if err := k.Resolve(); err != nil {
+			return nil, err
+		}
+	}
+	alias := keys[0].Certificate.Subject.CommonName
+	if alias == "" {
+		alias = keys[0].CertHash
+	}
+	return &Service{keys: keys, KeyAlias: alias}, nil
+}
+
+// Sign returns apk signed with the service keys using the v2 scheme, after
+// checking it against the Policy. It gives up with ctx.Err() once ctx is
+// done, e.g. when the client went away.
+func (s *Service) Sign(ctx context.Context, apk []byte) (signed []byte, err error) {
+	defer s.record(ctx, signv2.OpSign, apk, &signed, &err)
+	if err := s.Policy.Check(apk); err != nil {
+		return nil, err
+	}
+	z, err := signv2.NewApkSign(apk)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return z.SignV2Context(ctx, s.keys)
+}
+
+// SignURL signs the APK stored at in and stores the result at out, both
+// remote storage URLs (s3://, gs://, https://), see the storage package.
+// Local paths and file:// URLs are refused, so that clients cannot reach the
+// file system of the server.
+func (s *Service) SignURL(ctx context.Context, in, out string) error {
+	for _, u := range []string{in, out} {
+		if !storage.IsRemote(u) {
+			return fmt.Errorf("%q is not a remote storage URL", u)
+		}
+	}
+	apk, err := storage.ReadURL(ctx, in)
+	if err != nil {
+		return err
+	}
+	signed, err := s.Sign(ctx, apk)
+	if err != nil {
+		return err
+	}
+	return storage.WriteURL(ctx, out, signed)
+}
+
+// Verify checks the v2 signature of apk.
+func (s *Service) Verify(ctx context.Context, apk []byte) (err error) {
+	defer s.record(ctx, signv2.OpVerify, apk, nil, &err)
+	z, err := signv2.NewApkSign(apk)
+	if err != nil {
+		return err
+	}
+	return z.VerifyV2Context(ctx)
+}
+
+// EditManifest applies m to the manifest of apk and re-signs the result.
+func (s *Service) EditManifest(ctx context.Context, apk []byte, m *editor.Manifest) (signed []byte, err error) {
+	defer s.record(ctx, signv2.OpEdit, apk, &signed, &err)
+	edited, err := editor.EditManifest(apk, m)
+	if err != nil {
+		return nil, err
+	}
+	return s.Sign(ctx, edited)
+}
+
+// record writes the audit event of an operation on in. It is meant to be
+// deferred with pointers to the named results; out is nil for operations
+// without output. When the event cannot be written, a successful operation
+// fails and its output is dropped.
+func (s *Service) record(ctx context.Context, op string, in []byte, out *[]byte, err *error) {
+	if s.Audit == nil {
+		return
+	}
+	e := &audit.Event{Time: time.Now(), Op: op, Actor: audit.Actor(ctx), Input: audit.Digest(in)}
+	if out != nil {
+		e.Key = s.KeyAlias
+		if *out != nil {
+			e.Output = audit.Digest(*out)
+		}
+	}
+	e.SetOutcome(*err)
+	// the request context may be cancelled already, the record must still be written
+	if aerr := s.Audit.Record(context.WithoutCancel(ctx), e); aerr != nil && *err == nil {
+		*err = fmt.Errorf("audit: %w", aerr)
+		if out != nil {
+			*out = nil
+		}
+	}
+}
+
+// Info is a short summary of an uploaded archive.
+type Info struct {
+	Size       int64 `json:"size"`
+	IsAPK      bool  `json:"is_apk"`
+	IsV2Signed bool  `json:"is_v2_signed"`
+	// Parsed 解析得到的类型, 签名方案及偏移
+	Parsed signv2.ParseResult `json:"parsed"`
+	// DebugSigned 由调试证书签名, 见 signv2.IsDebugCert
+	DebugSigned bool     `json:"debug_signed"`
+	Entries     []string `json:"entries"`
+}
+
+// Info parses apk without verifying it.
+func (s *Service) Info(apk []byte) (*Info, error) {
+	z, err := signv2.NewApkSign(apk)
+	if err != nil {
+		return nil, err
+	}
+	r, err := zip.NewReader(bytes.NewReader(apk), int64(len(apk)))
+	if err != nil {
+		return nil, err
+	}
+	info := &Info{Size: int64(len(apk)), IsAPK: z.IsAPK, IsV2Signed: z.IsV2Signed, Parsed: z.ParseResult()}
+	if debug, err := z.DebugSigned(); err == nil {
+		info.DebugSigned = debug
+	}
+	for _, f := range r.File {
+		info.Entries = append(info.Entries, f.Name)
+	}
+	return info, nil
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/pzx521521/apk-editor/editor/signv2"
+)
+
+func TestInfoDebugSigned(t *testing.T) {
+	svc, err := New(testKeys(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	debug, err := signv2.GenerateDebugCert("", 0, signv2.RSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a v1 only signature must be checked as well as the v2 and v3 blocks
+	for name, sign := range map[string]func(z *signv2.ApkSign) ([]byte, error){
+		"v1": func(z *signv2.ApkSign) ([]byte, error) { return z.SignV1([]*signv2.SigningCert{debug}) },
+		"v3": func(z *signv2.ApkSign) ([]byte, error) { return z.SignV3([]*signv2.SigningCert{debug}, nil) },
+	} {
+		z, err := signv2.NewApkSign(testZip(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		apk, err := sign(z)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		info, err := svc.Info(apk)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !info.DebugSigned {
+			t.Errorf("%s: debug certificate not reported", name)
+		}
+	}
+
+	info, err := svc.Info(testZip(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.DebugSigned {
+		t.Error("unsigned archive reported as debug signed")
+	}
+}
@@ -2,14 +2,27 @@ package signv2
 
 import (
 	"crypto"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
 )
 
 // KeyAlgorithm is used to map strings used in e.g. config files to implementations.
 type KeyAlgorithm string
 
 const (
-	RSA KeyAlgorithm = "RSA"
-	EC               = "EC"
+	RSA     KeyAlgorithm = "RSA"
+	EC                   = "EC"
+	Ed25519 KeyAlgorithm = "Ed25519" // 仅限 WithGenericZip, Android 不支持
 )
 
 // HashAlgorithm is used to map strings used in e.g. config files to implementations. This is
@@ -40,3 +53,208 @@ func (h HashAlgorithm) AsHash() crypto.Hash {
 // serve the same function as the usual ASN.1 object ID registered constants, but in an integer
 // format.
 type AlgorithmID uint32
+
+// Signature algorithm IDs defined by the APK Signature Scheme v2/v3 spec.
+const (
+	RSAPSSWithSHA256      AlgorithmID = 0x0101
+	RSAPSSWithSHA512      AlgorithmID = 0x0102
+	RSAPKCS1v15WithSHA256 AlgorithmID = 0x0103
+	RSAPKCS1v15WithSHA512 AlgorithmID = 0x0104
+	ECDSAWithSHA256       AlgorithmID = 0x0201
+	ECDSAWithSHA512       AlgorithmID = 0x0202
+	DSAWithSHA256         AlgorithmID = 0x0301
+)
+
+// Ed25519WithSHA512 is not defined by the spec and unknown to Android: this package uses it for
+// Ed25519 signatures over SHA-512 content digests, with WithGenericZip only. The ID is taken from a
+// range the spec leaves unassigned.
+const Ed25519WithSHA512 AlgorithmID = 0x0501
+
+// Algorithm implements a signature algorithm ID of the APK Signing Block: the content digest signed
+// by signers using it, and the signature over their signed data. The algorithms this package
+// supports are built in; RegisterAlgorithm adds others, such as new curves or vendor-specific
+// schemes, which the v2, v3 and v4 signers and verifiers then use like the built-in ones.
+type Algorithm struct {
+	// Hash is the hash of the chunked content digest. Sign and Verify are free to use another one.
+	Hash crypto.Hash
+	// Sign signs data with key. It is nil for algorithms that are only verified.
+	Sign func(key crypto.Signer, data []byte) ([]byte, error)
+	// Verify checks sig over data with pub, the public key of the signer.
+	Verify func(pub crypto.PublicKey, data, sig []byte) error
+	// Strength orders the algorithms of a signer, which is verified with its strongest one; ties go
+	// to the higher ID.
+	Strength int
+	// GenericZipOnly restricts the algorithm to WithGenericZip, for algorithms Android does not know.
+	GenericZipOnly bool
+}
+
+// builtinAlgorithms are the algorithms of this package, which RegisterAlgorithm may not replace. As
+// on Android, signatures over SHA-512 content digests are stronger than those over SHA-256 ones.
+// DSA is only verified: the standard library has no DSA crypto.Signer.
+var builtinAlgorithms = map[AlgorithmID]*Algorithm{
+	RSAPSSWithSHA256:      {Hash: crypto.SHA256, Sign: signPSS(crypto.SHA256), Verify: verifyPSS(crypto.SHA256), Strength: 1},
+	RSAPSSWithSHA512:      {Hash: crypto.SHA512, Sign: signPSS(crypto.SHA512), Verify: verifyPSS(crypto.SHA512), Strength: 2},
+	RSAPKCS1v15WithSHA256: {Hash: crypto.SHA256, Sign: signDigest(crypto.SHA256), Verify: verifyPKCS1v15(crypto.SHA256), Strength: 1},
+	RSAPKCS1v15WithSHA512: {Hash: crypto.SHA512, Sign: signDigest(crypto.SHA512), Verify: verifyPKCS1v15(crypto.SHA512), Strength: 2},
+	ECDSAWithSHA256:       {Hash: crypto.SHA256, Sign: signDigest(crypto.SHA256), Verify: verifyECDSA(crypto.SHA256), Strength: 1},
+	ECDSAWithSHA512:       {Hash: crypto.SHA512, Sign: signDigest(crypto.SHA512), Verify: verifyECDSA(crypto.SHA512), Strength: 2},
+	DSAWithSHA256:         {Hash: crypto.SHA256, Verify: verifyDSA, Strength: 1},
+	Ed25519WithSHA512:     {Hash: crypto.SHA512, Sign: signEd25519, Verify: verifyEd25519, Strength: 3, GenericZipOnly: true},
+}
+
+var (
+	algorithmsMu sync.RWMutex
+	algorithms   = map[AlgorithmID]*Algorithm{}
+)
+
+// RegisterAlgorithm makes a available under id for signing and verification, replacing any
+// algorithm registered for the same ID. It panics if id is one of the built-in algorithms, or if a
+// lacks Verify or an available Hash.
+func RegisterAlgorithm(id AlgorithmID, a *Algorithm) {
+	if _, ok := builtinAlgorithms[id]; ok {
+		panic(fmt.Sprintf("signv2: algorithm for the built-in ID %#04x", uint32(id)))
+	}
+	if a.Verify == nil || !a.Hash.Available() {
+		panic(fmt.Sprintf("signv2: incomplete algorithm %#04x", uint32(id)))
+	}
+	algorithmsMu.Lock()
+	defer algorithmsMu.Unlock()
+	algorithms[id] = a
+}
+
+// UnregisterAlgorithm removes the algorithm registered for id, if any.
+func UnregisterAlgorithm(id AlgorithmID) {
+	algorithmsMu.Lock()
+	defer algorithmsMu.Unlock()
+	delete(algorithms, id)
+}
+
+// LookupAlgorithm returns the implementation of id, built in or registered, or nil.
+func LookupAlgorithm(id AlgorithmID) *Algorithm {
+	if a, ok := builtinAlgorithms[id]; ok {
+		return a
+	}
+	algorithmsMu.RLock()
+	defer algorithmsMu.RUnlock()
+	return algorithms[id]
+}
+
+// hash returns the content digest algorithm of a signature algorithm, and false when the algorithm is
+// neither built in nor registered.
+func (id AlgorithmID) hash() (crypto.Hash, bool) {
+	if a := LookupAlgorithm(id); a != nil {
+		return a.Hash, true
+	}
+	return 0, false
+}
+
+// verify checks sig over data using the DER-encoded SubjectPublicKeyInfo publicKey.
+func (id AlgorithmID) verify(publicKey, data, sig []byte) error {
+	a := LookupAlgorithm(id)
+	if a == nil {
+		return fmt.Errorf("%w 0x%04x", ErrUnknownAlgorithm, uint32(id))
+	}
+	pubkey, err := x509.ParsePKIXPublicKey(publicKey)
+	if err != nil {
+		return err
+	}
+	return a.Verify(pubkey, data, sig)
+}
+
+// signDigest signs the hash digest of data. With these options RSA keys sign with PKCS #1 v1.5 and
+// ECDSA keys return ASN.1 DER signatures, the encodings of the spec.
+func signDigest(hash crypto.Hash) func(crypto.Signer, []byte) ([]byte, error) {
+	return func(key crypto.Signer, data []byte) ([]byte, error) {
+		h := hash.New()
+		h.Write(data)
+		return key.Sign(rand.Reader, h.Sum(nil), hash)
+	}
+}
+
+func verifyPKCS1v15(hash crypto.Hash) func(crypto.PublicKey, []byte, []byte) error {
+	return func(pub crypto.PublicKey, data, sig []byte) error {
+		rsaKey, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: RSA signature with a %T key", ErrUnknownAlgorithm, pub)
+		}
+		h := hash.New()
+		h.Write(data)
+		return rsa.VerifyPKCS1v15(rsaKey, hash, h.Sum(nil), sig)
+	}
+}
+
+// pssOptions are the RSASSA-PSS parameters of the spec: MGF1 with the digest's hash and a salt as
+// long as the digest.
+func pssOptions(hash crypto.Hash) *rsa.PSSOptions {
+	return &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hash}
+}
+
+func signPSS(hash crypto.Hash) func(crypto.Signer, []byte) ([]byte, error) {
+	return func(key crypto.Signer, data []byte) ([]byte, error) {
+		h := hash.New()
+		h.Write(data)
+		return key.Sign(rand.Reader, h.Sum(nil), pssOptions(hash))
+	}
+}
+
+func verifyPSS(hash crypto.Hash) func(crypto.PublicKey, []byte, []byte) error {
+	return func(pub crypto.PublicKey, data, sig []byte) error {
+		rsaKey, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: RSA signature with a %T key", ErrUnknownAlgorithm, pub)
+		}
+		h := hash.New()
+		h.Write(data)
+		return rsa.VerifyPSS(rsaKey, hash, h.Sum(nil), sig, pssOptions(hash))
+	}
+}
+
+func verifyECDSA(hash crypto.Hash) func(crypto.PublicKey, []byte, []byte) error {
+	return func(pub crypto.PublicKey, data, sig []byte) error {
+		ecKey, ok := pub.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: ECDSA signature with a %T key", ErrUnknownAlgorithm, pub)
+		}
+		h := hash.New()
+		h.Write(data)
+		if !ecdsa.VerifyASN1(ecKey, h.Sum(nil), sig) {
+			return errors.New("ecdsa: verification error")
+		}
+		return nil
+	}
+}
+
+// verifyDSA checks an ASN.1 DER DSA signature over the SHA-256 digest of data, truncated to the
+// size of the subgroup as FIPS 186-3 specifies.
+func verifyDSA(pub crypto.PublicKey, data, sig []byte) error {
+	dsaKey, ok := pub.(*dsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("%w: DSA signature with a %T key", ErrUnknownAlgorithm, pub)
+	}
+	var rs struct{ R, S *big.Int }
+	if rest, err := asn1.Unmarshal(sig, &rs); err != nil || len(rest) != 0 {
+		return errors.New("dsa: malformed signature")
+	}
+	sum := sha256.Sum256(data)
+	digest := sum[:]
+	if n := (dsaKey.Q.BitLen() + 7) / 8; n < len(digest) {
+		digest = digest[:n]
+	}
+	if !dsa.Verify(dsaKey, digest, rs.R, rs.S) {
+		return errors.New("dsa: verification error")
+	}
+	return nil
+}
+
+// signEd25519 signs data itself, Ed25519 hashes internally.
+func signEd25519(key crypto.Signer, data []byte) ([]byte, error) {
+	return key.Sign(rand.Reader, data, crypto.Hash(0))
+}
+
+func verifyEd25519(pub crypto.PublicKey, data, sig []byte) error {
+	edKey, ok := pub.(ed25519.PublicKey)
+	if !ok || !ed25519.Verify(edKey, data, sig) {
+		return errors.New("ed25519: verification error")
+	}
+	return nil
+}
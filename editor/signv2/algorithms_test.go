@@ -0,0 +1,183 @@
+package signv2
+
+import (
+	"crypto"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// testPSSAlgorithm is a vendor algorithm: RSASSA-PSS over SHA-512 content digests.
+const testPSSAlgorithm AlgorithmID = 0x0f01
+
+func TestRegisterAlgorithm(t *testing.T) {
+	pss := &Algorithm{
+		Hash: crypto.SHA512,
+		Sign: func(key crypto.Signer, data []byte) ([]byte, error) {
+			h := crypto.SHA512.New()
+			h.Write(data)
+			return key.Sign(rand.Reader, h.Sum(nil), &rsa.PSSOptions{Hash: crypto.SHA512})
+		},
+		Verify: func(pub crypto.PublicKey, data, sig []byte) error {
+			h := crypto.SHA512.New()
+			h.Write(data)
+			return rsa.VerifyPSS(pub.(*rsa.PublicKey), crypto.SHA512, h.Sum(nil), sig, nil)
+		},
+		Strength: 10,
+	}
+	RegisterAlgorithm(testPSSAlgorithm, pss)
+	defer UnregisterAlgorithm(testPSSAlgorithm)
+	if LookupAlgorithm(testPSSAlgorithm) != pss {
+		t.Fatal("LookupAlgorithm does not return the registered algorithm")
+	}
+
+	z, err := NewApkSign(testZip(t, "AndroidManifest.xml", "manifest", "classes.dex", "dex"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := testSigningCerts(t)
+	custom := *keys[0]
+	custom.Algorithm = testPSSAlgorithm
+	signed, err := z.SignV2([]*SigningCert{keys[0], &custom})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sz, err := NewApkSign(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, err := sz.V2Block()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sig := strongestSignature(v2.Signers[0].Signatures, false); sig == nil || AlgorithmID(sig.AlgorithmID) != testPSSAlgorithm {
+		t.Errorf("strongest signature = %+v, want the registered algorithm", sig)
+	}
+	if err = sz.VerifyV2(); err != nil {
+		t.Fatalf("VerifyV2: %v", err)
+	}
+
+	// without the registration, the PKCS#1 signature of the same signer is verified instead
+	UnregisterAlgorithm(testPSSAlgorithm)
+	if err = sz.VerifyV2(); err != nil {
+		t.Errorf("VerifyV2 after unregistering: %v", err)
+	}
+	if _, err = z.SignV2([]*SigningCert{&custom}); !errors.Is(err, ErrUnknownAlgorithm) {
+		t.Errorf("signing with an unregistered algorithm = %v, want ErrUnknownAlgorithm", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("RegisterAlgorithm replaced a built-in algorithm")
+		}
+	}()
+	RegisterAlgorithm(RSAPKCS1v15WithSHA256, pss)
+}
+
+// generateECCert creates a self-signed ECDSA key pair, as an unresolved SigningCert without a Type.
+func generateECCert(t *testing.T, curve elliptic.Curve) *SigningCert {
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ec test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec1, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &SigningCert{
+		SigningKey: SigningKey{KeyBytes: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1})},
+		CertBytes:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+func TestSpecAlgorithms(t *testing.T) {
+	rsaKey := *testSigningCerts(t)[0]
+	p256 := generateECCert(t, elliptic.P256())
+	p384 := generateECCert(t, elliptic.P384())
+	p384.Hash = SHA512
+	for _, tt := range []struct {
+		key  SigningCert
+		alg  AlgorithmID
+		want AlgorithmID
+	}{
+		{key: rsaKey, alg: RSAPSSWithSHA256, want: RSAPSSWithSHA256},
+		{key: rsaKey, alg: RSAPSSWithSHA512, want: RSAPSSWithSHA512},
+		{key: *p256, want: ECDSAWithSHA256},
+		{key: *p384, want: ECDSAWithSHA512},
+	} {
+		sc := tt.key
+		sc.Algorithm = tt.alg
+		z, err := NewApkSign(testZip(t, "AndroidManifest.xml", "manifest", "classes.dex", "dex"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		signed, err := z.SignV2([]*SigningCert{&sc})
+		if err != nil {
+			t.Fatalf("%#04x: %v", uint32(tt.want), err)
+		}
+		if z, err = NewApkSign(signed); err != nil {
+			t.Fatal(err)
+		}
+		v2, err := z.V2Block()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.alg == 0 && (sc.Type != EC || sc.ECKey == nil) {
+			t.Errorf("EC key resolved as %q", sc.Type)
+		}
+		if got := AlgorithmID(v2.Signers[0].Signatures[0].AlgorithmID); got != tt.want {
+			t.Errorf("signed with %#04x, want %#04x", uint32(got), uint32(tt.want))
+		}
+		if r := z.VerifyAll(VerifyOptions{}); !r.Verified {
+			t.Errorf("%#04x: not verified: %v", uint32(tt.want), r.Errors())
+		}
+	}
+}
+
+func TestVerifyDSA(t *testing.T) {
+	var key dsa.PrivateKey
+	if err := dsa.GenerateParameters(&key.Parameters, rand.Reader, dsa.L1024N160); err != nil {
+		t.Fatal(err)
+	}
+	if err := dsa.GenerateKey(&key, rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("signed data")
+	sum := sha256.Sum256(data)
+	r, s, err := dsa.Sign(rand.Reader, &key, sum[:20])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	if err != nil {
+		t.Fatal(err)
+	}
+	alg := LookupAlgorithm(DSAWithSHA256)
+	if err = alg.Verify(&key.PublicKey, data, sig); err != nil {
+		t.Fatal(err)
+	}
+	if err = alg.Verify(&key.PublicKey, []byte("other data"), sig); err == nil {
+		t.Error("DSA signature verified over other data")
+	}
+}
@@ -0,0 +1,152 @@
+package signv2
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+// Alignments applied by Align, as zipalign -p applies them: stored entries on 4 bytes, stored native
+// libraries on a memory page so that the platform can map them from the APK. Android 15 devices may
+// use 16KB pages, which Google Play requires apps targeting it to support.
+const (
+	AlignDefault = 4
+	PageSize4K   = 4 << 10
+	PageSize16K  = 16 << 10
+)
+
+// ErrMisaligned is returned by CheckAlignment for a file with a stored entry that is not aligned.
+var ErrMisaligned = errors.New("zip entry is not aligned")
+
+// Align returns the zip buf with the data of every stored entry starting at a multiple of 4 bytes, and that
+// of every stored native library (lib/**/*.so) at a multiple of pageSize, PageSize4K or PageSize16K,
+// as zipalign -p does; 0 means PageSize16K. The padding goes at the end of the extra field of the
+// local headers, replacing that of an earlier alignment, and the Central Directory is rewritten with
+// the new offsets. Entries are otherwise copied byte for byte, local headers included, so a v1
+// signature stays valid and so do timestamps and extra fields, but the APK Signing Block is
+// dropped: sign the result.
+func Align(buf []byte, pageSize int) (_ []byte, err error) {
+	defer catch(&err, "Align", "file")
+	if pageSize, err = checkPageSize(pageSize); err != nil {
+		return nil, err
+	}
+	return alignZip(context.Background(), bytes.NewReader(buf), int64(len(buf)), pageSize, nil)
+}
+
+// CheckAlignment returns an error wrapping ErrMisaligned and naming the first entry Align would
+// move, as zipalign -c -p does; 0 means PageSize16K.
+func (apkSign *ApkSign) CheckAlignment(pageSize int) (err error) {
+	defer catch(&err, "CheckAlignment", "file")
+	if pageSize, err = checkPageSize(pageSize); err != nil {
+		return err
+	}
+	r, err := apkSign.zipReader()
+	if err != nil {
+		return err
+	}
+	for _, f := range r.File {
+		if f.Method != zip.Store || strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		off, err := f.DataOffset()
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+		if align := entryAlign(f.Name, pageSize); off%int64(align) != 0 {
+			return fmt.Errorf("%w: %s at offset %d, want a multiple of %d", ErrMisaligned, f.Name, off, align)
+		}
+	}
+	return nil
+}
+
+// aligned returns the ApkSign SignV2 signs: apkSign itself, or if a stored entry is misaligned, a
+// copy aligned on 16KB pages. Files NewApkSignFromReaderAt opened are signed as they are, as aligning
+// them would hold the output in memory, and so are files whose other blocks PreserveBlocks keeps,
+// which are only valid for the original layout.
+func (apkSign *ApkSign) aligned(ctx context.Context, o options) (*ApkSign, error) {
+	if o.noAlign || apkSign.raw == nil || apkSign.PreserveBlocks {
+		return apkSign, nil
+	}
+	err := apkSign.CheckAlignment(PageSize16K)
+	if !errors.Is(err, ErrMisaligned) {
+		return apkSign, err
+	}
+	o.logf("aligning before signing: %v", err)
+	buf, err := alignZip(ctx, apkSign, apkSign.size, PageSize16K, apkSign.Progress)
+	if err != nil {
+		return nil, err
+	}
+	z, err := parseApkSign(buf, apkSign.options)
+	if err != nil {
+		return nil, err
+	}
+	z.Progress = apkSign.Progress
+	z.SelfCheck = apkSign.SelfCheck
+	return z, nil
+}
+
+// alignZip implements Align, reporting the entries copied to progress as StageRebuild and stopping
+// between entries once ctx is done.
+func alignZip(ctx context.Context, ra io.ReaderAt, size int64, pageSize int, progress ProgressFunc) ([]byte, error) {
+	r, err := zip.NewReader(ra, size)
+	if err != nil {
+		return nil, err
+	}
+	p := &progressCounter{progress: progress, stage: StageRebuild, total: compressedSize(r.File)}
+	// reserve the input size plus the most padding each stored entry may take, so that the buffer
+	// is not reallocated, which would briefly need twice the memory
+	grow := size
+	for _, f := range r.File {
+		if f.Method == zip.Store {
+			grow += int64(entryAlign(f.Name, pageSize))
+		}
+	}
+	buf := new(bytes.Buffer)
+	buf.Grow(int(grow))
+	w := zip.NewWriter(buf)
+	for _, f := range r.File {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err = w.CopyAligned(f, entryAlign(f.Name, pageSize)); err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		p.add(int(f.CompressedSize64))
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// compressedSize returns the total size of the compressed data of files.
+func compressedSize(files []*zip.File) int64 {
+	var n int64
+	for _, f := range files {
+		n += int64(f.CompressedSize64)
+	}
+	return n
+}
+
+// entryAlign returns the alignment of the data of the stored entry name.
+func entryAlign(name string, pageSize int) int {
+	if strings.HasPrefix(name, "lib/") && strings.HasSuffix(name, ".so") {
+		return pageSize
+	}
+	return AlignDefault
+}
+
+func checkPageSize(pageSize int) (int, error) {
+	switch pageSize {
+	case 0:
+		return PageSize16K, nil
+	case PageSize4K, PageSize16K:
+		return pageSize, nil
+	}
+	return 0, fmt.Errorf("unsupported page size %d, want %d or %d", pageSize, PageSize4K, PageSize16K)
+}
@@ -0,0 +1,119 @@
+package signv2
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// testUnaligned builds an archive whose stored entries follow a one byte name, so their data is
+// misaligned.
+func testUnaligned(t *testing.T) []byte {
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	for _, e := range []struct {
+		name   string
+		method uint16
+	}{
+		{"a", zip.Store},
+		{"resources.arsc", zip.Store},
+		{"classes.dex", zip.Deflate},
+		{"lib/arm64-v8a/libfoo.so", zip.Store},
+		{"res/raw/b.png", zip.Store},
+	} {
+		f, err := w.CreateHeader(&zip.FileHeader{Name: e.name, Method: e.method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(bytes.Repeat([]byte(e.name), 3))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestAlign(t *testing.T) {
+	unaligned := testUnaligned(t)
+	z, err := NewApkSign(unaligned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = z.CheckAlignment(0); !errors.Is(err, ErrMisaligned) {
+		t.Fatalf("CheckAlignment = %v", err)
+	}
+	for _, page := range []int{PageSize4K, PageSize16K} {
+		aligned, err := Align(unaligned, page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		z, err := NewApkSign(aligned)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = z.CheckAlignment(page); err != nil {
+			t.Errorf("%d: %v", page, err)
+		}
+		if again, err := Align(aligned, page); err != nil || !bytes.Equal(again, aligned) {
+			t.Errorf("%d: aligning an aligned file changed it: %v", page, err)
+		}
+		r, err := zip.NewReader(bytes.NewReader(aligned), int64(len(aligned)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range r.File {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := io.ReadAll(rc)
+			if err != nil || !bytes.Equal(b, bytes.Repeat([]byte(f.Name), 3)) {
+				t.Errorf("%s = %q, %v", f.Name, b, err)
+			}
+		}
+	}
+	if _, err = Align(unaligned, 8192); err == nil {
+		t.Error("Align accepted an 8KB page")
+	}
+}
+
+func TestSignAligns(t *testing.T) {
+	keys := testSigningCerts(t)
+	for name, sign := range map[string]func(z *ApkSign, opts ...Option) ([]byte, error){
+		"v2": func(z *ApkSign, opts ...Option) ([]byte, error) { return z.SignV2(keys, opts...) },
+		"v3": func(z *ApkSign, opts ...Option) ([]byte, error) { return z.SignV3(keys, nil, opts...) },
+	} {
+		z, err := NewApkSign(testUnaligned(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		signed, err := sign(z)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err = CheckSigned(signed); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if z, err = NewApkSign(signed); err != nil {
+			t.Fatal(err)
+		}
+		if err = z.CheckAlignment(PageSize16K); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+
+		if z, err = NewApkSign(testUnaligned(t)); err != nil {
+			t.Fatal(err)
+		}
+		if signed, err = sign(z, WithoutAlign()); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if z, err = NewApkSign(signed); err != nil {
+			t.Fatal(err)
+		}
+		if err = z.CheckAlignment(0); !errors.Is(err, ErrMisaligned) {
+			t.Errorf("%s WithoutAlign: CheckAlignment = %v", name, err)
+		}
+	}
+}
@@ -3,10 +3,18 @@ package signv2
 import (
 	"archive/zip"
 	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
 	"encoding/binary"
 	"errors"
-	"log"
+	"fmt"
+	"io"
+	"os"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 )
 
 // ApkSign loads and performs operations on ZIP files required for signing Android APKs. It supports the
@@ -16,16 +24,52 @@ import (
 //
 // As this signing scheme does not rely on any Android-related content in the ApkSign file itself, it
 // can actually be used to sign arbitrary ApkSign files; they need not be Android APKs.
+//
+// An ApkSign is immutable once constructed and its exported fields are set: signing, verifying and
+// reading never modify it, and state computed on demand is guarded, so one instance may be used by
+// any number of goroutines. The exception is Close, which must not run concurrently with anything
+// else. Use Clone to get an instance with different settings, e.g. a Progress per goroutine.
 type ApkSign struct {
+	// IsAPK, IsV1Signed and IsV2Signed are kept for compatibility; ParseResult has the details.
+	// IsV2Signed is set whenever there is an APK Signing Block, whatever schemes it holds.
 	IsAPK      bool
+	IsV1Signed bool
 	IsV2Signed bool
 
+	// Progress, if set, is notified while content digests are computed (StageDigest), while
+	// SignV2To writes the output (StageWrite) and while the zip is rewritten by SignV1 or aligned by
+	// SignV2 (StageRebuild).
+	Progress ProgressFunc
+	// PreserveBlocks keeps the Play frosting, dependency metadata and SourceStamp blocks of an
+	// existing signing block when signing. They are only valid for unchanged contents, i.e. when
+	// re-signing a file without editing it: signing fails with ErrContentModified if the contents no
+	// longer match the existing signature. WithSourceStamp makes a new stamp instead.
+	PreserveBlocks bool
+	// SelfCheck re-parses and verifies the signed output before SignV2 returns it, see CheckSigned.
+	// It is also enabled by the SelfCheckEnv environment variable.
+	SelfCheck bool
+
 	raw        []byte
 	size       int64
 	eocdOffset uint64
 	cdOffset   uint64
 	asv2Offset uint64
 	rawASv2    []byte
+	unmap      func() error // set by OpenApkSignFile
+	file       *os.File     // set by OpenApkSignFile
+	options    options
+	parsed     ParseResult
+	ra         io.ReaderAt // set in windowed mode, see newWindowed
+	window     int         // set in windowed mode: raw is nil and ra is read window bytes at a time
+	tail       []byte      // set in windowed mode: the bytes from tailBase to the end, holding the CD and EOCD
+	tailBase   uint64
+
+	fileMu   sync.Mutex     // serializes use of the offset of file by writeRaw
+	cachesMu sync.Mutex     // guards caches
+	caches   []*DigestCache // caches holding sections of raw, emptied by Close
+	zipOnce  sync.Once      // guards zipR and zipErr, see zipReader
+	zipR     *zip.Reader
+	zipErr   error
 }
 
 // NewZip attempts to parse its input as a ApkSign file, determining along the way whether the input is
@@ -37,12 +81,44 @@ type ApkSign struct {
 // Note that this function does NOT use the Go standard zip library. As the Android v2 signing scheme is
 // non-standard and involves injecting a non-ApkSign data-block into the file before the ApkSign central
 // directory, this code does byte parsing of its input to locate the relevant offsets.
-func NewApkSign(buf []byte) (*ApkSign, error) {
-	z := &ApkSign{}
+func NewApkSign(buf []byte, opts ...Option) (*ApkSign, error) {
+	if !newOptions(opts).noCopy {
+		raw := make([]byte, len(buf))
+		copy(raw, buf)
+		buf = raw
+	}
+	return NewApkSignNoCopy(buf, opts...)
+}
+
+// NewApkSignNoCopy is like NewApkSign but uses buf as is instead of copying it, halving peak memory
+// for large files. The returned ApkSign takes ownership of buf: the caller must not modify it
+// afterwards, or parsed results and signatures computed from it become wrong.
+func NewApkSignNoCopy(buf []byte, opts ...Option) (_ *ApkSign, err error) {
+	defer catch(&err, "NewApkSign", "file")
+	z, err := parseApkSign(buf, newOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+	if z.options.strictZip {
+		if err = z.checkStrictZip(); err != nil {
+			return nil, err
+		}
+	}
+	return z, nil
+}
+
+// parseApkSign locates the signing block, the CD and the EOCD of buf.
+func parseApkSign(buf []byte, o options) (*ApkSign, error) {
+	z := &ApkSign{options: o}
+	if o.lenientZip {
+		buf, z.parsed.Quirks = normalizeZip(buf)
+		for _, q := range z.parsed.Quirks {
+			o.logf("ApkSign.New lenient: %s", q)
+		}
+	}
 
 	z.size = int64(len(buf))
-	z.raw = make([]byte, z.size)
-	copy(z.raw, buf)
+	z.raw = buf
 
 	// now scan for key offsets: Central Directory (CD) table; End Of Central Directory (EOCD) table;
 	// and the Android Signing Scheme v2 block (ASv2). If the file lacks either a CD or EOCD, it
@@ -51,135 +127,435 @@ func NewApkSign(buf []byte) (*ApkSign, error) {
 	// EOCD tables -- this isn't a general-purpose zip utility.
 
 	if z.size < 22 { // cannot possibly be a ZIP
-		return nil, errors.New("input is too small to be a zip")
-	}
-
-	var b []byte
-	var start int64
-	for i := uint32(0); i < 65535; i++ {
-		// The "end of central directory" block has 22 bytes of fixed headers, followed by a variable
-		// length comment, whose length is stored in the final 16 bits of the EOCD block. This means
-		// that we can't just look at EOF - 22 for the EOCD magic identifier, we have to read backward
-		// to accommodate a possible zip file comment.
-
-		start = z.size - 22 - int64(i)
-		b = z.raw[start : start+22]
-
-		// check for the EOCD magic string, 0x06054b50. note that zip files are little endian
-		if binary.LittleEndian.Uint32(b[:4]) == 0x06054b50 {
-			// we now have a candidate, but we don't know for sure that this is the EOCD: the comment
-			// could technically contain the EOCD magic. so verify that the number of bytes we've read
-			// backward matches what the EOCD should say is the comment length. This also covers this
-			// verification requirement:
-			// Spec: "verify that ... ZIP End of Central Directory is not followed by more data"
-			commentLen := binary.LittleEndian.Uint16(b[20:22])
-			if uint16(i) != commentLen {
-				continue // can't be the EOCD; keep going
-			}
-
-			// comment length checks out, but that could be a coincidence, so also check CD offset, which we need anyway
-			candidateEOCD := uint64(z.size) - 22 - uint64(i)
-			eocdCD := binary.LittleEndian.Uint32(b[16:20])
-			eocdCDLen := binary.LittleEndian.Uint32(b[12:16])
-			b2 := z.raw[int64(eocdCD):]
-			if binary.LittleEndian.Uint32(b2) != 0x02014b50 {
-				continue // CD pointed to by "EOCD" is not a valid CD, but there may still be comment bytes to unwind
-			}
-
-			// Spec: "verify that ... ZIP Central Directory is immediately followed by ZIP End of Central Directory record"
-			if uint64(eocdCD)+uint64(eocdCDLen) != candidateEOCD {
-				return nil, errors.New("CD not adjacent to EOCD")
-			}
-
-			// now we have an EOCD that checks out and appears to point to a CD, so we are pretty sure this is a zip file
-			z.cdOffset = uint64(eocdCD)
-			z.eocdOffset = candidateEOCD
-
-			// scan the file using zip library, looking for specific file names
-			r, err := zip.NewReader(bytes.NewReader(z.raw), z.size)
-			if err != nil {
-				return nil, err
-			}
-			hasClassesDex := false
-			hasAndroidManifestXML := false
-			hasResourcesARSC := false
-			hasSF := false
-			hasRSA := false
-			for _, f := range r.File {
-				switch f.FileHeader.Name {
-				case "classes.dex":
-					hasClassesDex = true
-				case "AndroidManifest.xml":
-					hasAndroidManifestXML = true
-				case "resources.arsc":
-					hasResourcesARSC = true
-				}
-				hasSF = hasSF || strings.HasSuffix(f.FileHeader.Name, ".SF")
-				hasRSA = hasRSA || strings.HasSuffix(f.FileHeader.Name, ".RSA") || strings.HasSuffix(f.FileHeader.Name, ".DSA")
-			}
-			z.IsAPK = hasClassesDex && hasAndroidManifestXML && hasResourcesARSC
-
-			// now see if there is an Android signing v2 block
-			start = int64(z.cdOffset) - 16
-			magic := z.raw[start:z.cdOffset]
-			if string(magic) != "APK Sig Block 42" {
-				return z, nil
-			}
-
-			// it has the ASv2 magic in the expected spot, so check size fields: size field is uint64 & is
-			// repeated at start & end of block, but pre-size copy does not include itself
-			start = int64(z.cdOffset - 16 - 8)
-			b64 := z.raw[start : start+8]
-			postSize := binary.LittleEndian.Uint64(b64)
-			start = int64(z.cdOffset - postSize - 8)
-			b64 = z.raw[start : start+8]
-			preSize := binary.LittleEndian.Uint64(b64)
-			if preSize == postSize { // Spec: "Two size fields of APK Signing Block contain the same value"
-				z.asv2Offset = z.cdOffset - postSize - 8
-				z.rawASv2 = make([]byte, preSize-24)
-				start = int64(z.asv2Offset + 8)
-				copy(z.rawASv2, z.raw[start:])
-			}
-
-			z.IsV2Signed = z.asv2Offset > 0
-
-			log.Println("ApkSign.New", "ASv2, CD, EOCD", z.asv2Offset, z.cdOffset, z.eocdOffset)
-
-			return z, nil
+		return nil, locateAt(fmt.Errorf("%w: input is too small", ErrNotZip), "file", 0)
+	}
+
+	// The "end of central directory" block has 22 bytes of fixed headers, followed by a variable
+	// length comment, whose length is stored in the final 16 bits of the EOCD block. This means that
+	// we can't just look at EOF - 22 for the EOCD magic identifier, we have to search backward to
+	// accommodate a possible zip file comment. The comment could technically contain the EOCD magic
+	// too, so a candidate is only taken if it also points to a CD.
+	// For a Zip64 archive the CD is located through the Zip64 records before the EOCD.
+	var eocdCD, eocdCDLen uint64
+	var end int
+	eocd := findEOCD(z.raw, false, func(off int) bool {
+		cd, n, e, ok := centralDirectory(z.raw, 0, off)
+		// CD pointed to by "EOCD" must be a valid CD, otherwise there may still be comment bytes to unwind
+		// cd comes from the file: compare without adding to it, which could overflow
+		if !ok || cd > uint64(e) || uint64(e)-cd < 4 || binary.LittleEndian.Uint32(z.raw[cd:]) != 0x02014b50 {
+			return false
+		}
+		eocdCD, eocdCDLen, end = cd, n, e
+		return true
+	})
+	if eocd < 0 {
+		// tell a damaged zip from something else entirely
+		if i := bytes.LastIndex(z.raw[max(0, len(z.raw)-22-65535):], eocdMagic); i >= 0 {
+			off := int64(max(0, len(z.raw)-22-65535) + i)
+			return nil, locateAt(fmt.Errorf("%w: %w", ErrNotZip, ErrMalformedEOCD), "EOCD", off)
 		}
+		return nil, locateAt(ErrNotZip, "EOCD", -1)
+	}
+
+	// Spec: "verify that ... ZIP Central Directory is immediately followed by ZIP End of Central Directory record"
+	if eocdCD+eocdCDLen != uint64(end) {
+		return nil, locateAt(ErrCDNotAdjacent, "EOCD", int64(eocd))
+	}
+
+	// now we have an EOCD that checks out and appears to point to a CD, so we are pretty sure this is a zip file
+	z.cdOffset = eocdCD
+	z.eocdOffset = uint64(end)
+
+	names, err := z.scanEntries()
+	if err != nil {
+		return nil, locateAt(err, "central directory", int64(z.cdOffset))
 	}
+	defer func() { z.setParsed(names) }()
 
-	// if we fall past the end of the loop, means we exhausted all possibility of it being a zip
-	return nil, errors.New("input is not a zip")
+	// now see if there is an Android signing v2 block
+	if z.cdOffset < 32 { // no room for a signing block before the CD
+		return z, nil
+	}
+	start := int64(z.cdOffset) - 16
+	magic := z.raw[start:z.cdOffset]
+	if string(magic) != "APK Sig Block 42" {
+		return z, nil
+	}
+
+	// it has the ASv2 magic in the expected spot, so check size fields: size field is uint64 & is
+	// repeated at start & end of block, but pre-size copy does not include itself
+	start = int64(z.cdOffset - 16 - 8)
+	b64 := z.raw[start : start+8]
+	postSize := binary.LittleEndian.Uint64(b64)
+	// the block holds at least its trailing size and magic, and must fit before the CD
+	if postSize < 24 || postSize > z.cdOffset-8 {
+		return nil, locateAt(fmt.Errorf("%w - bad size %d", ErrBadSigBlock, postSize), "signing block", start)
+	}
+	if postSize > MaxSigningBlockSize {
+		return nil, locateAt(fmt.Errorf("%w: signing block of %d bytes", ErrLimitExceeded, postSize), "signing block", start)
+	}
+	start = int64(z.cdOffset - postSize - 8)
+	b64 = z.raw[start : start+8]
+	preSize := binary.LittleEndian.Uint64(b64)
+	if preSize == postSize { // Spec: "Two size fields of APK Signing Block contain the same value"
+		z.asv2Offset = z.cdOffset - postSize - 8
+		start = int64(z.asv2Offset + 8)
+		end := start + int64(preSize) - 24
+		z.rawASv2 = z.raw[start:end:end]
+	}
+
+	z.IsV2Signed = z.asv2Offset > 0
+
+	z.options.debug("parsed zip layout", "signingBlock", z.asv2Offset, "cd", z.cdOffset, "eocd", z.eocdOffset)
+
+	return z, nil
 }
 
-func (apkSign *ApkSign) SignV2(keys []*SigningCert) ([]byte, error) {
-	for _, sk := range keys {
-		if err := sk.Resolve(); err != nil {
+// scanEntries sets IsAPK and IsV1Signed from the names in the Central Directory, which it returns.
+// IsAPK stays false with WithGenericZip.
+func (apkSign *ApkSign) scanEntries() ([]string, error) {
+	// scan the file using zip library, looking for specific file names
+	r, err := apkSign.zipReader()
+	if err != nil {
+		return nil, err
+	}
+	hasClassesDex := false
+	hasAndroidManifestXML := false
+	hasResourcesARSC := false
+	hasSF := false
+	hasRSA := false
+	names := make([]string, len(r.File))
+	for i, f := range r.File {
+		names[i] = f.FileHeader.Name
+		switch f.FileHeader.Name {
+		case "classes.dex":
+			hasClassesDex = true
+		case "AndroidManifest.xml":
+			hasAndroidManifestXML = true
+		case "resources.arsc":
+			hasResourcesARSC = true
+		}
+		hasSF = hasSF || strings.HasSuffix(f.FileHeader.Name, ".SF")
+		hasRSA = hasRSA || strings.HasSuffix(f.FileHeader.Name, ".RSA") || strings.HasSuffix(f.FileHeader.Name, ".DSA") ||
+			strings.HasSuffix(f.FileHeader.Name, ".EC")
+	}
+	apkSign.IsAPK = !apkSign.options.genericZip && hasClassesDex && hasAndroidManifestXML && hasResourcesARSC
+	apkSign.IsV1Signed = hasSF && hasRSA
+	return names, nil
+}
+
+// eocdMagic is the signature of the End of Central Directory record, 0x06054b50 in little endian.
+var eocdMagic = []byte{0x50, 0x4b, 0x05, 0x06}
+
+// findEOCD returns the offset of the End of Central Directory record of the zip in buf, or -1. It
+// searches the last 64KB backward for the EOCD magic, taking the first candidate whose comment
+// length field matches the bytes that follow it, which also covers this requirement:
+// Spec: "verify that ... ZIP End of Central Directory is not followed by more data"
+// If trailing is set, the comment may also be followed by other data, up to the 64KB searched.
+// accept can reject a candidate after further checks, and the search then continues backward.
+func findEOCD(buf []byte, trailing bool, accept func(off int) bool) int {
+	if len(buf) < 22 {
+		return -1
+	}
+	base := max(0, len(buf)-22-65535)
+	window := buf[base:]
+	for end := len(window) - 22 + len(eocdMagic); ; {
+		i := bytes.LastIndex(window[:end], eocdMagic)
+		if i < 0 {
+			return -1
+		}
+		end = i + len(eocdMagic) - 1 // continue with matches starting before i
+		off := base + i
+		comment, rest := int(binary.LittleEndian.Uint16(buf[off+20:])), len(buf)-off-22
+		if (comment == rest || trailing && comment < rest) && accept(off) {
+			return off
+		}
+	}
+}
+
+// normalizeZip implements WithLenientZip. It returns buf as is if its Central Directory is followed
+// by the EOCD and nothing after it, or cannot be found at all; otherwise it returns a copy with the
+// data in between and after dropped, and describes what was dropped.
+func normalizeZip(buf []byte) ([]byte, []string) {
+	var cd, cdEnd uint64
+	var end int
+	eocd := findEOCD(buf, true, func(off int) bool {
+		var n uint64
+		var ok bool
+		cd, n, end, ok = centralDirectory(buf, 0, off)
+		cdEnd = cd + n
+		return ok && cdEnd <= uint64(end) && cd+4 <= uint64(end) && binary.LittleEndian.Uint32(buf[cd:]) == 0x02014b50
+	})
+	if eocd < 0 {
+		return buf, nil
+	}
+	eocdEnd := eocd + 22 + int(binary.LittleEndian.Uint16(buf[eocd+20:]))
+	var quirks []string
+	if gap := uint64(end) - cdEnd; gap > 0 {
+		quirks = append(quirks, fmt.Sprintf("%d bytes between the Central Directory and the EOCD", gap))
+	}
+	if trailing := len(buf) - eocdEnd; trailing > 0 {
+		quirks = append(quirks, fmt.Sprintf("%d bytes after the EOCD", trailing))
+	}
+	if quirks == nil {
+		return buf, nil
+	}
+	out := make([]byte, 0, int(cdEnd)+eocdEnd-end)
+	out = append(append(out, buf[:cdEnd]...), buf[end:eocdEnd]...)
+	setCDOffset(out[cdEnd:], cd, cdEnd-cd)
+	return out, quirks
+}
+
+// SignV2 returns the file signed with APK Signature Scheme v2 by keys. Keys sharing a certificate
+// make one signer with a signature per algorithm; each other certificate adds a signer, all of which
+// must verify. A file with a misaligned stored entry is first aligned as Align does with
+// PageSize16K, unless WithoutAlign is given. As with apksigner, the files section is padded with
+// zeros and the signing block with a verity padding pair, so that both the block and the Central
+// Directory start on a 4096 byte page.
+func (apkSign *ApkSign) SignV2(keys []*SigningCert, opts ...Option) ([]byte, error) {
+	return apkSign.SignV2Context(context.Background(), keys, opts...)
+}
+
+// SignV2Context is like SignV2 but stops computing digests and returns ctx.Err() once ctx is done.
+func (apkSign *ApkSign) SignV2Context(ctx context.Context, keys []*SigningCert, opts ...Option) (_ []byte, err error) {
+	defer apkSign.options.with(opts).record(OpSign, apkSign.size, time.Now(), &err)
+	defer catch(&err, "SignV2", "file")
+	o := apkSign.options.with(opts)
+	z, err := apkSign.aligned(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+	if o.stamp != nil {
+		if z, err = z.withStampCert(o.stamp); err != nil {
 			return nil, err
 		}
 	}
+	block, err := z.signV2Block(ctx, keys, opts)
+	if err != nil {
+		return nil, err
+	}
+	var signed []byte
+	if o.stamp == nil {
+		signed, err = z.injected(z.padFiles(block))
+	} else {
+		signed, err = z.stamped(block, o.stamp)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if apkSign.SelfCheck || SelfCheckEnabled() {
+		if err = checkSigned(signed, apkSign.options.with(opts).genericZip); err != nil {
+			return nil, &SelfCheckError{"sign", err}
+		}
+	}
+	return signed, nil
+}
+
+// SignV2To is like SignV2 but writes the signed file to w instead of returning it, so that signing
+// a file opened with OpenApkSignFile or NewApkSignFromReaderAt never holds the whole output in
+// memory. The files section is written as is, from the input slice or, for such a file, copied from
+// the file by the kernel where possible, so only the signing block, the Central Directory and the
+// EOCD are built in memory.
+// SelfCheck is not applied, since the output is not kept; re-open the written file and verify it
+// instead. Nor is the file aligned first, as SignV2 does, since that would hold it in memory: check
+// it with CheckAlignment and use Align where needed.
+func (apkSign *ApkSign) SignV2To(w io.Writer, keys []*SigningCert, opts ...Option) error {
+	return apkSign.SignV2ToContext(context.Background(), w, keys, opts...)
+}
+
+// SignV2ToContext is like SignV2To but stops computing digests and returns ctx.Err() once ctx is
+// done. Nothing is written to w in that case.
+func (apkSign *ApkSign) SignV2ToContext(ctx context.Context, w io.Writer, keys []*SigningCert, opts ...Option) (err error) {
+	defer apkSign.options.with(opts).record(OpSign, apkSign.size, time.Now(), &err)
+	defer catch(&err, "SignV2To", "file")
+	if apkSign.options.with(opts).stamp != nil {
+		return errors.New("WithSourceStamp rewrites the file, use SignV2")
+	}
+	block, err := apkSign.signV2Block(ctx, keys, opts)
+	if err != nil {
+		return err
+	}
+	return apkSign.writeInjected(w, apkSign.padFiles(block))
+}
+
+// signV2Block resolves keys and returns the new APK Signing Block.
+func (apkSign *ApkSign) signV2Block(ctx context.Context, keys []*SigningCert, opts []Option) ([]byte, error) {
+	o := apkSign.options.with(opts)
+	if err := apkSign.prepareSigning(keys, o); err != nil {
+		return nil, err
+	}
 	v2 := V2Block{}
-	return v2.Sign(apkSign, keys)
+	return v2.signingBlock(ctx, apkSign, keys, o)
+}
+
+// prepareSigning checks that the file can be signed with o and resolves keys.
+func (apkSign *ApkSign) prepareSigning(keys []*SigningCert, o options) error {
+	if err := apkSign.checkMinSdk(o); err != nil {
+		return err
+	}
+	if !o.genericZip && apkSign.IsAPK {
+		if err := apkSign.checkMethods(); err != nil {
+			return err
+		}
+	}
+	if kind := apkSign.parsed.Kind; !o.genericZip && (kind == KindAAB || kind == KindAPKSet) {
+		return fmt.Errorf("%w: %s", ErrNotSignable, kind)
+	}
+	for _, sk := range keys {
+		if err := sk.Resolve(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// V2Block parses the v2 signing block without verifying it. Calling this when IsV2Signed == false
+// is an error.
+func (apkSign *ApkSign) V2Block() (_ *V2Block, err error) {
+	defer catch(&err, "V2Block", BlockName(BlockIDV2))
+	if !apkSign.IsV2Signed {
+		return nil, ErrNotV2Signed
+	}
+	v2, err := ParseV2Block(apkSign.rawASv2)
+	return v2, apkSign.inBlock(err)
+}
+
+// inBlock turns the offsets of a *ParseError from parsing rawASv2 into file offsets.
+func (apkSign *ApkSign) inBlock(err error) error {
+	if err == nil || errors.Is(err, ErrNotV2Signed) {
+		return err
+	}
+	return locateAt(err, "signing block", int64(apkSign.asv2Offset)+8)
 }
 
 // VerifyV2 returns a non-nil error if the represented ApkSign file has a v2 (i.e. Android-specific
-// whole-file) signature that does not verify. Note that calling this when z.IsV2Signed == false is
-// always an error. VerifyV2 returns nil if the signature validates.
+// whole-file) signature that does not verify, or whose signers claim a v3 signature that is missing
+// (see CheckStripping), or for which a BlockProcessor in effect fails. Note that calling this when
+// z.IsV2Signed == false is always an error. VerifyV2 returns nil if the signature validates.
 func (apkSign *ApkSign) VerifyV2() error {
+	return apkSign.VerifyV2Context(context.Background())
+}
+
+// VerifyV2Signers is like VerifyV2 but also returns the certificate of each signer, in block order.
+// Every signer must verify: SignV2 adds one per certificate among its keys.
+func (apkSign *ApkSign) VerifyV2Signers() ([]*x509.Certificate, error) {
+	if err := apkSign.VerifyV2(); err != nil {
+		return nil, err
+	}
+	v2, err := apkSign.V2Block()
+	if err != nil {
+		return nil, err
+	}
+	return v2.Certificates(), nil
+}
+
+// VerifyV2Context is like VerifyV2 but stops computing digests and returns ctx.Err() once ctx is
+// done.
+func (apkSign *ApkSign) VerifyV2Context(ctx context.Context) (err error) {
+	defer catch(&err, "VerifyV2", BlockName(BlockIDV2))
 	var v2 *V2Block
-	var err error
 
 	if !apkSign.IsV2Signed {
-		return errors.New("v2 verification attempted on non-v2-signed file")
+		return ErrNotV2Signed
 	}
 
-	v2, err = ParseV2Block(apkSign.rawASv2)
+	v2, err = apkSign.V2Block()
 	if err != nil {
 		return err
 	}
 
-	return v2.Verify(apkSign)
+	if err = v2.verify(ctx, apkSign); err != nil {
+		return err
+	}
+	// a valid v2 signature is not enough if the signer says there was also a v3 one
+	if err = apkSign.CheckStripping(); err != nil {
+		return err
+	}
+	ps := apkSign.options.blockProcessors()
+	errs, err := apkSign.verifyBlocks(ps)
+	if err != nil {
+		return err
+	}
+	for _, p := range ps {
+		if err := errs[p.BlockID()]; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// contentDigest computes the v2 content digest of the file: the chunked digest of the files section,
+// the Central Directory and the EOCD, in that order.
+//
+// Per spec, the EOCD's pointer to the CD is "revised" to point at the offset of the ASv2 block. As the
+// ASv2 block changes in length it moves the CD, and since the block is added after the fact, a changing
+// EOCD would alter the hash. Essentially this hashes the "pristine" file, as it would be if the ASv2
+// block didn't exist. This is a RAM-only operation; on disk it would be an invalid zip.
+//
+// The computation stops early, returning ctx.Err(), when ctx is done.
+func (apkSign *ApkSign) contentDigest(ctx context.Context, hash crypto.Hash) ([]byte, error) {
+	return apkSign.optionsContentDigest(ctx, hash, apkSign.options, 0)
+}
+
+// signingDigest is the content digest of the file as the signers write it, with the files section
+// padded by filesPadding, computed with the digest cache and concurrency of o.
+func (apkSign *ApkSign) signingDigest(ctx context.Context, hash crypto.Hash, o options) ([]byte, error) {
+	return apkSign.optionsContentDigest(ctx, hash, o, filesPadding(apkSign.filesEnd()))
+}
+
+// optionsContentDigest is contentDigest with the digest cache and concurrency of o, which may be
+// the options of a call rather than those of apkSign, and with pad zero bytes added to the files
+// section.
+func (apkSign *ApkSign) optionsContentDigest(ctx context.Context, hash crypto.Hash, o options, pad uint64) ([]byte, error) {
+	if apkSign.window > 0 {
+		return apkSign.windowedContentDigest(ctx, hash, o.concurrency, pad)
+	}
+	cache := o.digestCache
+	endOfFileSection := apkSign.filesEnd()
+
+	revisedEOCD := make([]byte, apkSign.size-int64(apkSign.eocdOffset))
+	copy(revisedEOCD, apkSign.raw[apkSign.eocdOffset:])
+	setCDOffset(revisedEOCD, endOfFileSection+pad, apkSign.eocdOffset-apkSign.cdOffset)
+	files, cd := apkSign.raw[:endOfFileSection], apkSign.raw[apkSign.cdOffset:apkSign.eocdOffset]
+	if cache != nil {
+		if sum := cache.lookup(hash, files, pad, cd, revisedEOCD); sum != nil {
+			return sum, nil
+		}
+	}
+
+	d := NewDigester(hash)
+	d.ctx = ctx
+	d.Concurrency = o.concurrency
+	if apkSign.Progress != nil {
+		total := int64(endOfFileSection+pad+apkSign.eocdOffset-apkSign.cdOffset) + int64(len(revisedEOCD))
+		d.Progress = func(done int64) { apkSign.Progress(StageDigest, done, total) }
+	}
+	if pad == 0 {
+		d.Write(files) // send files section to be hashed
+	} else {
+		// the padding continues the last chunk of the files section: hash the whole chunks in place
+		// and only copy the rest
+		whole := len(files) &^ (1<<20 - 1)
+		last := make([]byte, uint64(len(files)-whole)+pad)
+		copy(last, files[whole:])
+		d.Write(files[:whole])
+		d.Write(last)
+	}
+	d.Write(cd)          // send CD to be hashed as separate block per spec
+	d.Write(revisedEOCD) // send revised EOCD to be hashed as separate block per spec
+	sum := d.Sum(nil)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if cache != nil {
+		cache.store(apkSign.raw, hash, files, pad, cd, revisedEOCD, sum)
+		apkSign.cachesMu.Lock()
+		if !slices.Contains(apkSign.caches, cache) {
+			apkSign.caches = append(apkSign.caches, cache)
+		}
+		apkSign.cachesMu.Unlock()
+	}
+	return sum, nil
 }
 
 // InjectBeforeCD modifies the ApkSign file bytes represented by this instance by injecting the input
@@ -203,7 +579,7 @@ func (apkSign *ApkSign) InjectBeforeCD(data []byte) []byte {
 
 	newEocd := make([]byte, apkSign.size-int64(apkSign.eocdOffset))
 	copy(newEocd, apkSign.raw[apkSign.eocdOffset:])
-	binary.LittleEndian.PutUint32(newEocd[16:], uint32(endOfFilesSection+uint64(len(data))))
+	setCDOffset(newEocd, endOfFilesSection+uint64(len(data)), apkSign.eocdOffset-apkSign.cdOffset)
 
 	// allocate & copy in the data
 	ret := make([]byte, newSize)
@@ -215,9 +591,197 @@ func (apkSign *ApkSign) InjectBeforeCD(data []byte) []byte {
 	return ret
 }
 
-// Bytes returns a slice over a new copy of the bytes underlying `z`.
+// InjectBeforeCDApk is like InjectBeforeCD but returns the result as a new ApkSign, with offsets
+// derived from those of `z` rather than found by parsing the new bytes again. data must be a
+// complete APK Signing Block, which replaces any existing one. The new ApkSign has the same settings
+// as `z`.
+func (apkSign *ApkSign) InjectBeforeCDApk(data []byte) (_ *ApkSign, err error) {
+	defer catch(&err, "InjectBeforeCDApk", "signing block")
+	n := uint64(len(data))
+	if n < 32 || string(data[n-16:]) != "APK Sig Block 42" {
+		return nil, fmt.Errorf("%w - missing magic", ErrBadSigBlock)
+	}
+	size := binary.LittleEndian.Uint64(data)
+	if size != n-8 || binary.LittleEndian.Uint64(data[n-24:]) != size {
+		return nil, fmt.Errorf("%w - bad size %d", ErrBadSigBlock, size)
+	}
+	if size > MaxSigningBlockSize {
+		return nil, fmt.Errorf("%w: signing block of %d bytes", ErrLimitExceeded, size)
+	}
+
+	endOfFilesSection := apkSign.cdOffset
+	if apkSign.asv2Offset > 0 {
+		endOfFilesSection = apkSign.asv2Offset
+	}
+	raw := apkSign.InjectBeforeCD(data)
+	z := &ApkSign{
+		IsAPK:          apkSign.IsAPK,
+		IsV1Signed:     apkSign.IsV1Signed,
+		Progress:       apkSign.Progress,
+		PreserveBlocks: apkSign.PreserveBlocks,
+		SelfCheck:      apkSign.SelfCheck,
+		raw:            raw,
+		size:           int64(len(raw)),
+		asv2Offset:     endOfFilesSection,
+		cdOffset:       endOfFilesSection + n,
+		options:        apkSign.options,
+	}
+	z.eocdOffset = z.cdOffset + (apkSign.eocdOffset - apkSign.cdOffset)
+	end := z.cdOffset - 24
+	z.rawASv2 = raw[z.asv2Offset+8 : end : end]
+	z.IsV2Signed = z.asv2Offset > 0
+	z.parsed = apkSign.parsed
+	z.setParsed(nil)
+	return z, nil
+}
+
+// writeInjected writes what InjectBeforeCD returns to w, without building it in memory.
+func (apkSign *ApkSign) writeInjected(w io.Writer, data []byte) error {
+	endOfFilesSection := apkSign.cdOffset
+	if apkSign.asv2Offset > 0 {
+		endOfFilesSection = apkSign.asv2Offset
+	}
+	newEocd := make([]byte, apkSign.size-int64(apkSign.eocdOffset))
+	copy(newEocd, apkSign.section(apkSign.eocdOffset, uint64(apkSign.size)))
+	setCDOffset(newEocd, endOfFilesSection+uint64(len(data)), apkSign.eocdOffset-apkSign.cdOffset)
+
+	tail := [][]byte{data, apkSign.section(apkSign.cdOffset, apkSign.eocdOffset), newEocd}
+	p := &progressCounter{progress: apkSign.Progress, stage: StageWrite, total: int64(endOfFilesSection)}
+	for _, b := range tail {
+		p.total += int64(len(b))
+	}
+	if err := apkSign.writeRaw(w, endOfFilesSection, p); err != nil {
+		return err
+	}
+	for _, b := range tail {
+		if err := p.write(w, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// injected is InjectBeforeCD for the signers: in windowed mode, where the file is not in memory,
+// the result is read into a new slice, which may fail.
+func (apkSign *ApkSign) injected(data []byte) ([]byte, error) {
+	if apkSign.window == 0 {
+		return apkSign.InjectBeforeCD(data), nil
+	}
+	buf := new(bytes.Buffer)
+	if err := apkSign.writeInjected(buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// filesEnd returns the end of the files section: the start of the signing block, or of the Central
+// Directory if there is none.
+func (apkSign *ApkSign) filesEnd() uint64 {
+	if apkSign.asv2Offset > 0 {
+		return apkSign.asv2Offset
+	}
+	return apkSign.cdOffset
+}
+
+// padFiles returns block preceded by the zeros that pad the files section as filesPadding
+// describes, for the signers to inject in place of the signing block.
+func (apkSign *ApkSign) padFiles(block []byte) []byte {
+	pad := filesPadding(apkSign.filesEnd())
+	if pad == 0 {
+		return block
+	}
+	return concat(make([]byte, pad), block)
+}
+
+// section returns the bytes from off to end, which in windowed mode must lie in the tail.
+func (apkSign *ApkSign) section(off, end uint64) []byte {
+	if apkSign.window > 0 {
+		return apkSign.tail[off-apkSign.tailBase : end-apkSign.tailBase]
+	}
+	return apkSign.raw[off:end]
+}
+
+// Clone returns a new ApkSign over the same bytes, with the same exported fields and options, which
+// can then be changed without affecting `z`. The bytes are shared, not copied. A clone of a file
+// opened with OpenApkSignFile shares its mapping: it must not be used after `z` is closed, and its
+// own Close does nothing.
+func (apkSign *ApkSign) Clone() *ApkSign {
+	return &ApkSign{
+		IsAPK:          apkSign.IsAPK,
+		IsV1Signed:     apkSign.IsV1Signed,
+		IsV2Signed:     apkSign.IsV2Signed,
+		Progress:       apkSign.Progress,
+		PreserveBlocks: apkSign.PreserveBlocks,
+		SelfCheck:      apkSign.SelfCheck,
+		raw:            apkSign.raw,
+		size:           apkSign.size,
+		eocdOffset:     apkSign.eocdOffset,
+		cdOffset:       apkSign.cdOffset,
+		asv2Offset:     apkSign.asv2Offset,
+		rawASv2:        apkSign.rawASv2,
+		ra:             apkSign.ra,
+		window:         apkSign.window,
+		tail:           apkSign.tail,
+		tailBase:       apkSign.tailBase,
+		options:        apkSign.options,
+		parsed:         apkSign.ParseResult(),
+	}
+}
+
+// zipReader returns the archive/zip view of the file, parsed on first use.
+func (apkSign *ApkSign) zipReader() (*zip.Reader, error) {
+	apkSign.zipOnce.Do(func() {
+		apkSign.zipR, apkSign.zipErr = zip.NewReader(apkSign, apkSign.size)
+	})
+	return apkSign.zipR, apkSign.zipErr
+}
+
+// Bytes returns a slice over a new copy of the bytes underlying `z`. See RawBytes to avoid the copy.
+// It returns nil for an ApkSign opened with NewApkSignFromReaderAt; use WriteTo instead.
 func (apkSign *ApkSign) Bytes() []byte {
+	if apkSign.raw == nil {
+		return nil
+	}
 	ret := make([]byte, len(apkSign.raw))
 	copy(ret, apkSign.raw)
 	return ret
 }
+
+// RawBytes returns the bytes underlying `z` without copying them. The slice must not be modified.
+// Like Bytes, it returns nil for an ApkSign opened with NewApkSignFromReaderAt.
+func (apkSign *ApkSign) RawBytes() []byte {
+	return apkSign.raw
+}
+
+// Size returns the length of the file in bytes.
+func (apkSign *ApkSign) Size() int64 {
+	return apkSign.size
+}
+
+// ReadAt implements io.ReaderAt over the file bytes, so that `z` can be handed to zip.NewReader, or
+// through io.NewSectionReader to http.ServeContent, without copying them.
+func (apkSign *ApkSign) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("ApkSign.ReadAt: negative offset")
+	}
+	if apkSign.window > 0 {
+		return apkSign.ra.ReadAt(p, off)
+	}
+	if off >= int64(len(apkSign.raw)) {
+		return 0, io.EOF
+	}
+	n := copy(p, apkSign.raw[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// WriteTo implements io.WriterTo, writing the file bytes to w. For a file opened with
+// OpenApkSignFile the copy is done by the kernel where possible, as for SignV2To, and for one
+// opened with NewApkSignFromReaderAt it is read one window at a time.
+func (apkSign *ApkSign) WriteTo(w io.Writer) (int64, error) {
+	p := &progressCounter{}
+	err := apkSign.writeRaw(w, uint64(apkSign.size), p)
+	return p.done, err
+}
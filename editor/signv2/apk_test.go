@@ -1,7 +1,13 @@
 package signv2
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -50,7 +56,7 @@ func saveFile(name string, b []byte) error {
 func TestGenerateSignedFile(t *testing.T) {
 	var z *ApkSign
 	var err error
-	var b []byte
+	var b, signed []byte
 
 	if b, err = loadFile("/Users/parapeng/Desktop/apkEditor/release/notsigned.apk"); err != nil {
 		t.Log("error loading file", err)
@@ -60,11 +66,203 @@ func TestGenerateSignedFile(t *testing.T) {
 		t.Log("error parsing zip", err)
 		t.FailNow()
 	}
-	if z, err = z.SignV2(keys); err != nil {
+	if signed, err = z.SignV2(keys); err != nil {
 		t.Log("error signing zip", err)
 		t.FailNow()
 	}
-	if err = saveFile("/Users/parapeng/Desktop/apkEditor/release/signed.apk", z.Bytes()); err != nil {
+	if err = saveFile("/Users/parapeng/Desktop/apkEditor/release/signed.apk", signed); err != nil {
 		t.Error("error signing zip", err)
 	}
 }
+
+func TestNewApkSignNoCopy(t *testing.T) {
+	signed := testSignedApk(t)
+	z, err := NewApkSignNoCopy(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw := z.RawBytes(); &raw[0] != &signed[0] {
+		t.Error("NewApkSignNoCopy copied its input")
+	}
+	if err = z.VerifyV2(); err != nil {
+		t.Fatal(err)
+	}
+	if b := z.Bytes(); &b[0] == &signed[0] || !bytes.Equal(b, signed) {
+		t.Error("Bytes did not return a copy")
+	}
+}
+
+// testCommentedZip builds an archive whose zip comment is comment.
+func testCommentedZip(t testing.TB, comment string) []byte {
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	f, err := w.Create("AndroidManifest.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("manifest"))
+	if err = w.SetComment(comment); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestFindEOCD(t *testing.T) {
+	plain := testZip(t, "AndroidManifest.xml", "manifest")
+	// a comment holding an EOCD magic whose comment length matches, but which points at no CD
+	fake := make([]byte, 22)
+	copy(fake, eocdMagic)
+	binary.LittleEndian.PutUint32(fake[16:], 1)
+	for name, comment := range map[string]string{
+		"none":     "",
+		"magic":    "PK\x05\x06PK\x05\x06",
+		"fake":     string(fake),
+		"max size": strings.Repeat("x", 65531) + "PK\x05\x06",
+	} {
+		apk := testCommentedZip(t, comment)
+		z, err := NewApkSignNoCopy(apk)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if want := len(apk) - len(comment) - 22; z.eocdOffset != uint64(want) {
+			t.Errorf("%s: EOCD at %d, want %d", name, z.eocdOffset, want)
+		}
+	}
+
+	// trailing data after the comment must not be taken for a zip
+	if _, err := NewApkSignNoCopy(append(plain[:len(plain):len(plain)], 0)); !errors.Is(err, ErrNotZip) {
+		t.Errorf("trailing byte: got %v, want ErrNotZip", err)
+	}
+	if got := findEOCD(plain[:21], false, func(int) bool { return true }); got != -1 {
+		t.Errorf("short input: got %d", got)
+	}
+}
+
+func BenchmarkNewApkSign(b *testing.B) {
+	// the worst case for the EOCD search is a maximum length comment
+	apk := testCommentedZip(b, strings.Repeat("x", 65535))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := NewApkSignNoCopy(apk); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestInjectBeforeCDApk(t *testing.T) {
+	for name, apk := range map[string][]byte{
+		"unsigned": testZip(t, "AndroidManifest.xml", "manifest"),
+		"signed":   testSignedApk(t),
+	} {
+		z, err := NewApkSign(apk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the signers pad the files section to a page, which InjectBeforeCDApk leaves to the caller
+		if pad := filesPadding(z.filesEnd()); pad > 0 {
+			if z, err = NewApkSign(z.InjectBeforeCD(make([]byte, pad))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		block, err := z.signV2Block(context.Background(), testSigningCerts(t), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := z.InjectBeforeCDApk(block)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		want, err := NewApkSign(z.InjectBeforeCD(block))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.raw, want.raw) || got.size != want.size || got.asv2Offset != want.asv2Offset ||
+			got.cdOffset != want.cdOffset || got.eocdOffset != want.eocdOffset ||
+			!bytes.Equal(got.rawASv2, want.rawASv2) || got.IsV2Signed != want.IsV2Signed {
+			t.Errorf("%s: InjectBeforeCDApk differs from parsing the injected bytes", name)
+		}
+		if err = got.VerifyV2(); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	z, err := NewApkSign(testZip(t, "AndroidManifest.xml", "manifest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = z.InjectBeforeCDApk([]byte("not a signing block")); err == nil {
+		t.Error("accepted a malformed signing block")
+	}
+}
+
+func TestParseResult(t *testing.T) {
+	jar, err := NewApkSign(testZip(t, "META-INF/MANIFEST.MF", "Manifest-Version: 1.0", "a.class", "x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := jar.ParseResult()
+	if r.Kind != KindJAR || r.Entries != 2 || len(r.Schemes) != 0 || r.SigningBlockOffset != 0 {
+		t.Errorf("jar: %+v", r)
+	}
+	for kind, entries := range map[ZipKind][]string{
+		KindAAB:    {"BundleConfig.pb", "", "base/manifest/AndroidManifest.xml", "manifest"},
+		KindAPKSet: {"toc.pb", "", "splits/base-master.apk", "apk"},
+	} {
+		z, err := NewApkSign(testZip(t, entries...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := z.ParseResult().Kind; got != kind {
+			t.Errorf("%v: kind %v", entries, got)
+		}
+		if _, err = z.SignV2(testSigningCerts(t)); !errors.Is(err, ErrNotSignable) {
+			t.Errorf("%v: SignV2 = %v", kind, err)
+		}
+	}
+
+	signed := testSignedApk(t)
+	z, err := NewApkSign(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = z.ParseResult()
+	if r.Kind != KindAPK || r.Entries != 4 || r.Size != int64(len(signed)) {
+		t.Errorf("apk: %+v", r)
+	}
+	if !r.HasScheme(SchemeV2) || r.HasScheme(SchemeV1) || r.HasScheme(SchemeV3) {
+		t.Errorf("schemes %v, want [v2]", r.Schemes)
+	}
+	block := signed[r.SigningBlockOffset : r.SigningBlockOffset+r.SigningBlockSize]
+	if !bytes.HasSuffix(block, []byte("APK Sig Block 42")) || r.SigningBlockOffset+r.SigningBlockSize != r.CDOffset {
+		t.Errorf("signing block at %d+%d, CD at %d", r.SigningBlockOffset, r.SigningBlockSize, r.CDOffset)
+	}
+	if string(signed[r.EOCDOffset:r.EOCDOffset+4]) != "PK\x05\x06" {
+		t.Errorf("EOCD offset %d", r.EOCDOffset)
+	}
+
+	// the result follows a new signing block, and copies are independent
+	r.Schemes[0] = SchemeV4
+	if z.Clone().ParseResult().Schemes[0] != SchemeV2 {
+		t.Error("ParseResult shares its Schemes")
+	}
+	stripped := append(bytes.Clone(signed[:r.SigningBlockOffset]), signed[r.CDOffset:]...)
+	binary.LittleEndian.PutUint32(stripped[len(stripped)-6:], uint32(r.SigningBlockOffset))
+	unsigned, err := NewApkSign(stripped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := unsigned.ParseResult().Schemes; len(s) != 0 {
+		t.Errorf("stripped: schemes %v", s)
+	}
+	injected, err := unsigned.InjectBeforeCDApk(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := injected.ParseResult(); got.Kind != KindAPK || !got.HasScheme(SchemeV2) || got.CDOffset != r.CDOffset {
+		t.Errorf("injected: %+v", got)
+	}
+}
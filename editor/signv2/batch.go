@@ -0,0 +1,205 @@
+package signv2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// ErrBatchAborted is the error of the jobs a FailFast Batch did not run after a job failed.
+var ErrBatchAborted = errors.New("batch aborted")
+
+// BatchResult is the outcome of one job submitted to a Batch.
+type BatchResult struct {
+	// ID is the identifier the job was submitted with.
+	ID string
+	// Signed is the signed file of a successful SubmitSign job.
+	Signed []byte
+	// Path is the signed file a SubmitSignFile job wrote, set even if the job failed.
+	Path string
+	// Verification is the report of a SubmitVerify job; it is nil if the file did not parse.
+	Verification *VerificationResult
+	// Err is set if the job failed: the file did not parse or sign, or did not verify.
+	Err error
+}
+
+// Batch signs and verifies many APKs on a fixed number of workers. Jobs are submitted with
+// SubmitSign, SubmitSignFile, SubmitSignDir and SubmitVerify and their results delivered on Results
+// in completion order, so a caller should drain Results from another goroutine while submitting.
+// Close must be called after the last job; Results is closed once all jobs are done.
+//
+// The options must be set before the first job is submitted.
+type Batch struct {
+	// PreserveBlocks and SelfCheck apply to sign jobs, see ApkSign.
+	PreserveBlocks bool
+	SelfCheck      bool
+	// VerifyOptions applies to verify jobs.
+	VerifyOptions VerifyOptions
+	// FailFast stops the batch at the first failed job: the running jobs are canceled and the
+	// remaining ones fail without running, with an error wrapping ErrBatchAborted. By default every
+	// job runs whatever the outcome of the others.
+	FailFast bool
+
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	jobs    chan batchJob
+	results chan BatchResult
+	close   sync.Once
+}
+
+type batchJob struct {
+	id  string
+	run func() BatchResult
+}
+
+// NewBatch starts a Batch with concurrency workers, runtime.GOMAXPROCS(0) if concurrency <= 0.
+// Once ctx is done, running jobs stop and the remaining ones fail with ctx.Err().
+func NewBatch(ctx context.Context, concurrency int) *Batch {
+	if concurrency <= 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+	b := &Batch{
+		jobs:    make(chan batchJob),
+		results: make(chan BatchResult, concurrency),
+	}
+	b.ctx, b.cancel = context.WithCancelCause(ctx)
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for range concurrency {
+		go func() {
+			defer wg.Done()
+			for job := range b.jobs {
+				b.results <- b.run(job)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		b.cancel(nil)
+		close(b.results)
+	}()
+	return b
+}
+
+// run runs job unless the batch was stopped, and stops a FailFast batch if it fails.
+func (b *Batch) run(job batchJob) BatchResult {
+	if b.ctx.Err() != nil {
+		return BatchResult{ID: job.id, Err: context.Cause(b.ctx)}
+	}
+	res := job.run()
+	if res.Err != nil && b.FailFast {
+		b.cancel(fmt.Errorf("%w: %s failed: %v", ErrBatchAborted, job.id, res.Err))
+	}
+	return res
+}
+
+// Results returns the channel the results of all jobs are delivered on.
+func (b *Batch) Results() <-chan BatchResult {
+	return b.results
+}
+
+// Close tells the Batch that no more jobs will be submitted. Submitting after Close panics.
+func (b *Batch) Close() {
+	b.close.Do(func() { close(b.jobs) })
+}
+
+// SubmitSign queues apk to be v2 signed with keys. It blocks until a worker takes the job. apk is
+// used without copying, so it must not be modified until the result is delivered.
+//
+// keys are resolved before SubmitSign returns, so the same keys may be passed to any number of jobs.
+func (b *Batch) SubmitSign(id string, apk []byte, keys []*SigningCert) {
+	if err := resolveAll(keys); err != nil {
+		b.jobs <- batchJob{id, func() BatchResult { return BatchResult{ID: id, Err: err} }}
+		return
+	}
+	b.jobs <- batchJob{id, func() BatchResult {
+		res := BatchResult{ID: id}
+		z, err := NewApkSignNoCopy(apk)
+		if err != nil {
+			res.Err = err
+			return res
+		}
+		z.PreserveBlocks, z.SelfCheck = b.PreserveBlocks, b.SelfCheck
+		res.Signed, res.Err = z.SignV2Context(b.ctx, keys)
+		return res
+	}}
+}
+
+// SubmitSignFile queues the file inPath to be v2 signed with keys into outPath, as SignFile does: the
+// input is mapped and the output streamed to a temporary file renamed over outPath, so that a job
+// holds neither file in memory. It blocks until a worker takes the job. keys are resolved as by
+// SubmitSign.
+func (b *Batch) SubmitSignFile(id, inPath, outPath string, keys []*SigningCert) {
+	if err := resolveAll(keys); err != nil {
+		b.jobs <- batchJob{id, func() BatchResult { return BatchResult{ID: id, Path: outPath, Err: err} }}
+		return
+	}
+	b.jobs <- batchJob{id, func() BatchResult {
+		res := BatchResult{ID: id, Path: outPath}
+		z, err := OpenApkSignFile(inPath)
+		if err != nil {
+			res.Err = err
+			return res
+		}
+		z.PreserveBlocks, z.SelfCheck = b.PreserveBlocks, b.SelfCheck
+		res.Err = z.signAndClose(b.ctx, outPath, keys, nil)
+		return res
+	}}
+}
+
+// SubmitSignDir queues every .apk file directly in dir to be signed into outDir under the same name
+// with SubmitSignFile, in name order, with the file name as ID, and returns the number of jobs
+// submitted. outDir is created if needed and may be dir itself, to sign the files in place. Once
+// the batch is stopped, by its context or FailFast, the remaining files are not submitted.
+func (b *Batch) SubmitSignDir(dir, outDir string, keys []*SigningCert) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	if err = os.MkdirAll(outDir, 0o755); err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, e := range entries {
+		if e.IsDir() || !strings.EqualFold(filepath.Ext(e.Name()), ".apk") {
+			continue
+		}
+		if b.ctx.Err() != nil {
+			break
+		}
+		b.SubmitSignFile(e.Name(), filepath.Join(dir, e.Name()), filepath.Join(outDir, e.Name()), keys)
+		n++
+	}
+	return n, nil
+}
+
+// SubmitVerify queues apk to be checked with VerifyAll. It blocks until a worker takes the job. apk
+// is used without copying, so it must not be modified until the result is delivered.
+func (b *Batch) SubmitVerify(id string, apk []byte) {
+	b.jobs <- batchJob{id, func() BatchResult {
+		res := BatchResult{ID: id}
+		z, err := NewApkSignNoCopy(apk)
+		if err != nil {
+			res.Err = err
+			return res
+		}
+		res.Verification = z.VerifyAllContext(b.ctx, b.VerifyOptions)
+		res.Err = res.Verification.Err()
+		return res
+	}}
+}
+
+// resolveAll resolves keys once for all the jobs they are passed to.
+func resolveAll(keys []*SigningCert) error {
+	for _, sk := range keys {
+		if err := sk.Resolve(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
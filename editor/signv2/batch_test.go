@@ -0,0 +1,135 @@
+package signv2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	unsigned := testZip(t, "AndroidManifest.xml", "manifest", "classes.dex", "dex")
+	want := testSign(t, unsigned)
+	keys := testSigningCerts(t) // shared by all jobs
+
+	b := NewBatch(context.Background(), 3)
+	go func() {
+		for i := range 10 {
+			b.SubmitSign(fmt.Sprint("sign", i), unsigned, keys)
+			b.SubmitVerify(fmt.Sprint("verify", i), want)
+		}
+		b.SubmitSign("bad", []byte("not a zip"), keys)
+		b.SubmitVerify("unsigned", unsigned)
+		b.Close()
+	}()
+
+	results := map[string]BatchResult{}
+	for res := range b.Results() {
+		results[res.ID] = res
+	}
+	if len(results) != 22 {
+		t.Fatalf("got %d results, want 22", len(results))
+	}
+	for i := range 10 {
+		if res := results[fmt.Sprint("sign", i)]; res.Err != nil || string(res.Signed) != string(want) {
+			t.Errorf("sign%d: err %v, output matches %t", i, res.Err, string(res.Signed) == string(want))
+		}
+		if res := results[fmt.Sprint("verify", i)]; res.Err != nil || !res.Verification.Verified {
+			t.Errorf("verify%d: %v", i, res.Err)
+		}
+	}
+	if !errors.Is(results["bad"].Err, ErrNotZip) {
+		t.Errorf("bad: got %v, want ErrNotZip", results["bad"].Err)
+	}
+	if res := results["unsigned"]; res.Err == nil || res.Verification == nil || res.Verification.Verified {
+		t.Errorf("unsigned: got %v", res.Err)
+	}
+}
+
+func TestBatchCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	apk, keys := testZip(t, "AndroidManifest.xml", "manifest"), testSigningCerts(t)
+	b := NewBatch(ctx, 0)
+	go func() {
+		b.SubmitSign("sign", apk, keys)
+		b.Close()
+	}()
+	for res := range b.Results() {
+		if !errors.Is(res.Err, context.Canceled) {
+			t.Errorf("%s: got %v, want context.Canceled", res.ID, res.Err)
+		}
+	}
+}
+
+func TestBatchSignDir(t *testing.T) {
+	unsigned := testZip(t, "AndroidManifest.xml", "manifest", "classes.dex", "dex")
+	want := testSign(t, unsigned)
+	dir, out := t.TempDir(), filepath.Join(t.TempDir(), "signed")
+	for name, data := range map[string][]byte{
+		"a.apk": unsigned, "B.APK": unsigned, "bad.apk": []byte("not a zip"), "notes.txt": []byte("skipped"),
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b := NewBatch(context.Background(), 2)
+	var n int
+	var err error
+	go func() {
+		n, err = b.SubmitSignDir(dir, out, testSigningCerts(t))
+		b.Close()
+	}()
+	results := map[string]BatchResult{}
+	for res := range b.Results() {
+		results[res.ID] = res
+	}
+	if err != nil || n != 3 || len(results) != 3 {
+		t.Fatalf("submitted %d jobs (%v), got %d results, want 3", n, err, len(results))
+	}
+	for _, name := range []string{"a.apk", "B.APK"} {
+		if res := results[name]; res.Err != nil || res.Path != filepath.Join(out, name) {
+			t.Fatalf("%s: %v, path %s", name, res.Err, res.Path)
+		}
+		if got, err := os.ReadFile(filepath.Join(out, name)); err != nil || string(got) != string(want) {
+			t.Errorf("%s: %v, output matches %t", name, err, string(got) == string(want))
+		}
+	}
+	if !errors.Is(results["bad.apk"].Err, ErrNotZip) {
+		t.Errorf("bad.apk: got %v, want ErrNotZip", results["bad.apk"].Err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "bad.apk")); !os.IsNotExist(err) {
+		t.Errorf("bad.apk was written: %v", err)
+	}
+}
+
+func TestBatchFailFast(t *testing.T) {
+	apk, keys := testZip(t, "AndroidManifest.xml", "manifest"), testSigningCerts(t)
+	b := NewBatch(context.Background(), 1)
+	b.FailFast = true
+	go func() {
+		b.SubmitSign("bad", []byte("not a zip"), keys)
+		for i := range 3 {
+			b.SubmitSign(fmt.Sprint("sign", i), apk, keys)
+		}
+		b.Close()
+	}()
+	n := 0
+	for res := range b.Results() {
+		n++
+		switch {
+		case res.ID == "bad":
+			if !errors.Is(res.Err, ErrNotZip) {
+				t.Errorf("bad: got %v, want ErrNotZip", res.Err)
+			}
+		case !errors.Is(res.Err, ErrBatchAborted) || res.Signed != nil:
+			t.Errorf("%s: got %v, want ErrBatchAborted", res.ID, res.Err)
+		}
+	}
+	if n != 4 {
+		t.Errorf("got %d results, want 4", n)
+	}
+}
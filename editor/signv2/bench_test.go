@@ -0,0 +1,236 @@
+package signv2
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto"
+	"fmt"
+	"io"
+	"math/rand"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+/* Benchmarks of the hot paths over synthetic APKs of a few sizes. To check a change for performance
+   regressions, run them before and after it and compare with benchstat:
+
+	go test -run '^$' -bench . -benchmem -count 10 ./editor/signv2 > old.txt
+	go test -run '^$' -bench . -benchmem -count 10 ./editor/signv2 > new.txt
+	benchstat old.txt new.txt
+*/
+
+// benchSizes are the sizes of the stored payload of the synthetic APKs.
+var benchSizes = []struct {
+	name string
+	size int
+}{
+	{"small", 100 << 10},
+	{"large", 32 << 20},
+}
+
+var (
+	benchMu   sync.Mutex
+	benchAPKs = map[string][2][]byte{} // unsigned and signed, by size name
+)
+
+// benchAPK returns an unsigned and a signed APK whose random payload of size bytes is stored
+// uncompressed, spread over a few entries. They are built once per size.
+func benchAPK(b testing.TB, name string, size int) (unsigned, signed []byte) {
+	benchMu.Lock()
+	defer benchMu.Unlock()
+	if apks, ok := benchAPKs[name]; ok {
+		return apks[0], apks[1]
+	}
+	payload := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(payload)
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	for i, name := range []string{"AndroidManifest.xml", "classes.dex", "resources.arsc", "assets/data.bin"} {
+		f, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		if err != nil {
+			b.Fatal(err)
+		}
+		part := payload[i*size/4 : (i+1)*size/4]
+		f.Write(part)
+	}
+	if err := w.Close(); err != nil {
+		b.Fatal(err)
+	}
+	unsigned = buf.Bytes()
+	z, err := NewApkSign(unsigned, WithLogger(nil))
+	if err != nil {
+		b.Fatal(err)
+	}
+	if signed, err = z.SignV2(testSigningCerts(b)); err != nil {
+		b.Fatal(err)
+	}
+	benchAPKs[name] = [2][]byte{unsigned, signed}
+	return unsigned, signed
+}
+
+// benchEach runs fn as a sub-benchmark for every size, reporting throughput over the signed APK.
+func benchEach(b *testing.B, fn func(b *testing.B, unsigned, signed []byte)) {
+	for _, s := range benchSizes {
+		b.Run(s.name, func(b *testing.B) {
+			unsigned, signed := benchAPK(b, s.name, s.size)
+			b.SetBytes(int64(len(signed)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			fn(b, unsigned, signed)
+		})
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	benchEach(b, func(b *testing.B, _, signed []byte) {
+		for i := 0; i < b.N; i++ {
+			if _, err := NewApkSign(signed, WithNoCopy(), WithLogger(nil)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkDigest(b *testing.B) {
+	for _, hash := range []crypto.Hash{crypto.SHA256, crypto.SHA512} {
+		b.Run(fmt.Sprint(hash), func(b *testing.B) {
+			benchEach(b, func(b *testing.B, _, signed []byte) {
+				z, err := NewApkSign(signed, WithNoCopy(), WithLogger(nil))
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err = z.contentDigest(context.Background(), hash); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkDigestConcurrency shows how the content digest of the large APK scales with the number
+// of chunks hashed at a time, from one to GOMAXPROCS.
+func BenchmarkDigestConcurrency(b *testing.B) {
+	_, signed := benchAPK(b, "large", 32<<20)
+	for n := 1; ; n *= 2 {
+		n = min(n, runtime.GOMAXPROCS(0))
+		b.Run(fmt.Sprintf("workers=%d", n), func(b *testing.B) {
+			z, err := NewApkSign(signed, WithNoCopy(), WithLogger(nil), WithConcurrency(n))
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(int64(len(signed)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err = z.contentDigest(context.Background(), crypto.SHA256); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		if n == runtime.GOMAXPROCS(0) {
+			break
+		}
+	}
+}
+
+func BenchmarkSign(b *testing.B) {
+	keys := testSigningCerts(b)
+	benchEach(b, func(b *testing.B, unsigned, _ []byte) {
+		z, err := NewApkSign(unsigned, WithNoCopy(), WithLogger(nil))
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err = z.SignV2(keys); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkSignTo(b *testing.B) {
+	keys := testSigningCerts(b)
+	benchEach(b, func(b *testing.B, unsigned, _ []byte) {
+		z, err := NewApkSign(unsigned, WithNoCopy(), WithLogger(nil))
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err = z.SignV2To(io.Discard, keys); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkSignStream(b *testing.B) {
+	keys := testSigningCerts(b)
+	benchEach(b, func(b *testing.B, unsigned, _ []byte) {
+		for i := 0; i < b.N; i++ {
+			if err := SignStream(bytes.NewReader(unsigned), io.Discard, StreamConfig{Keys: keys}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkVerify(b *testing.B) {
+	benchEach(b, func(b *testing.B, _, signed []byte) {
+		z, err := NewApkSign(signed, WithNoCopy(), WithLogger(nil))
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err = z.VerifyV2(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkVerifyAll(b *testing.B) {
+	benchEach(b, func(b *testing.B, _, signed []byte) {
+		z, err := NewApkSign(signed, WithNoCopy(), WithLogger(nil))
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if res := z.VerifyAll(VerifyOptions{}); !res.Verified {
+				b.Fatal(res.Err())
+			}
+		}
+	})
+}
+
+// TestSignToMemory guards the property the streaming paths exist for: signing into a writer
+// allocates a small fixed amount, not something proportional to the APK.
+func TestSignToMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs a benchmark")
+	}
+	unsigned, _ := benchAPK(t, "large", 32<<20)
+	keys := testSigningCerts(t)
+	res := testing.Benchmark(func(b *testing.B) {
+		b.ReportAllocs()
+		z, err := NewApkSign(unsigned, WithNoCopy(), WithLogger(nil))
+		if err != nil {
+			b.Fatal(err)
+		}
+		for i := 0; i < b.N; i++ {
+			if err = z.SignV2To(io.Discard, keys); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	if perOp := res.AllocedBytesPerOp(); perOp > 1<<20 {
+		t.Errorf("SignV2To allocates %d bytes per 32MB APK", perOp)
+	}
+}
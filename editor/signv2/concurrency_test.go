@@ -0,0 +1,74 @@
+package signv2
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestSharedApkSign(t *testing.T) {
+	signed := testSignedApk(t)
+	path := filepath.Join(t.TempDir(), "signed.apk")
+	if err := os.WriteFile(path, signed, 0644); err != nil {
+		t.Fatal(err)
+	}
+	z, err := OpenApkSignFile(path, WithDigestCache(NewDigestCache()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	keys := testSigningCerts(t)
+	if err = keys[0].Resolve(); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if res := z.VerifyAll(VerifyOptions{}); !res.Verified {
+				t.Error(res.Err())
+			}
+			out := new(bytes.Buffer)
+			if err := z.SignV2To(out, keys); err != nil || !bytes.Equal(out.Bytes(), signed) {
+				t.Errorf("SignV2To: %v", err)
+			}
+			out.Reset()
+			if _, err := z.WriteTo(out); err != nil || !bytes.Equal(out.Bytes(), signed) {
+				t.Errorf("WriteTo: %v", err)
+			}
+			if _, err := z.VerifySourceStamp(); err == nil {
+				t.Error("found a source stamp that is not there")
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestClone(t *testing.T) {
+	z, err := NewApkSign(testSignedApk(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := z.Clone()
+	var digested bool
+	c.Progress = func(string, int64, int64) { digested = true }
+	if z.Progress != nil {
+		t.Error("setting Progress on the clone changed the original")
+	}
+	if &c.RawBytes()[0] != &z.RawBytes()[0] {
+		t.Error("clone copied the bytes")
+	}
+	if err = c.VerifyV2(); err != nil || !digested {
+		t.Errorf("clone: %v, progress reported %t", err, digested)
+	}
+	if err = c.Close(); err != nil {
+		t.Error(err)
+	}
+	if err = z.VerifyV2(); err != nil {
+		t.Errorf("original after closing the clone: %v", err)
+	}
+}
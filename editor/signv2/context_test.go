@@ -0,0 +1,41 @@
+package signv2
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestContextCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	signed := testSignedApk(t)
+	z, err := NewApkSign(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = z.SignV2Context(ctx, testSigningCerts(t)); !errors.Is(err, context.Canceled) {
+		t.Errorf("SignV2Context: got %v, want context.Canceled", err)
+	}
+	if err = z.VerifyV2Context(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("VerifyV2Context: got %v, want context.Canceled", err)
+	}
+	if r := z.VerifyAllContext(ctx, VerifyOptions{}); r.Verified || !errors.Is(r.Scheme(SchemeV2).Err, context.Canceled) {
+		t.Errorf("VerifyAllContext: verified %v, v2 error %v", r.Verified, r.Scheme(SchemeV2).Err)
+	}
+	if err = z.VerifyV2Context(context.Background()); err != nil {
+		t.Errorf("VerifyV2Context: %v", err)
+	}
+
+	// rebuilding the zip stops as well
+	if z, err = NewApkSign(testUnaligned(t)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = z.SignV2Context(ctx, testSigningCerts(t)); !errors.Is(err, context.Canceled) {
+		t.Errorf("SignV2Context aligning: got %v, want context.Canceled", err)
+	}
+	if _, err = z.SignV1Context(ctx, testSigningCerts(t)); !errors.Is(err, context.Canceled) {
+		t.Errorf("SignV1Context: got %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,110 @@
+package signv2
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"time"
+)
+
+// DebugCertValidity is the validity GenerateDebugCert uses by default, that of the debug keystore
+// the Android Gradle plugin creates.
+const DebugCertValidity = 30 * 365 * 24 * time.Hour
+
+// oidEmailAddress is the emailAddress attribute used in the subject of the AOSP test keys.
+var oidEmailAddress = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}
+
+// IsDebugCert reports whether cert is a well-known debug certificate: the androiddebugkey the
+// Android SDK generates ("CN=Android Debug, O=Android, C=US"), or one of the AOSP test keys
+// (testkey, platform, shared, media), which all share the same subject. Anything signed with these
+// must not be shipped, as their private keys are public or at least not protected.
+func IsDebugCert(cert *x509.Certificate) bool {
+	s := cert.Subject
+	if s.CommonName == "Android Debug" && has(s.Organization, "Android") && has(s.Country, "US") {
+		return true
+	}
+	if s.CommonName == "Android" && has(s.Organization, "Android") && has(s.OrganizationalUnit, "Android") &&
+		has(s.Locality, "Mountain View") {
+		for _, n := range s.Names {
+			if n.Type.Equal(oidEmailAddress) && n.Value == "android@android.com" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func has(values []string, v string) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// GenerateDebugCert creates a key of keyType, 2048 bit RSA, ECDSA on P-256 or Ed25519, and a
+// self-signed certificate for it valid from now for validity, or DebugCertValidity if zero. An empty
+// cn gives the subject of the SDK's debug certificate, which IsDebugCert then recognizes; with any
+// other, the certificate has the subject CN=cn. The result is resolved, and its KeyBytes and
+// CertBytes hold PEM that can be saved for later use, or saved as a keystore with EncodePKCS12.
+func GenerateDebugCert(cn string, validity time.Duration, keyType KeyAlgorithm) (_ *SigningCert, err error) {
+	defer catch(&err, "GenerateDebugCert", "key")
+	var key crypto.Signer
+	switch keyType {
+	case RSA:
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case EC:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case Ed25519:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, errors.New("unknown signing key type")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if validity == 0 {
+		validity = DebugCertValidity
+	}
+	subject := pkix.Name{CommonName: cn}
+	if cn == "" {
+		subject = pkix.Name{CommonName: "Android Debug", Organization: []string{"Android"}, Country: []string{"US"}}
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 63))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      subject,
+		NotBefore:    now,
+		NotAfter:     now.Add(validity),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	sc := &SigningCert{
+		SigningKey: SigningKey{KeyBytes: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})},
+		CertBytes:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+	if err = sc.Resolve(); err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
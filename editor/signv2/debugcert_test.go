@@ -0,0 +1,119 @@
+package signv2
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestIsDebugCert(t *testing.T) {
+	for _, c := range []struct {
+		subject pkix.Name
+		debug   bool
+	}{
+		{pkix.Name{CommonName: "Android Debug", Organization: []string{"Android"}, Country: []string{"US"}}, true},
+		{pkix.Name{CommonName: "Android Debug"}, false},
+		{pkix.Name{CommonName: "Release", Organization: []string{"Android"}, Country: []string{"US"}}, false},
+	} {
+		if got := IsDebugCert(&x509.Certificate{Subject: c.subject}); got != c.debug {
+			t.Errorf("IsDebugCert(%s) = %v", c.subject, got)
+		}
+	}
+	sc := testSigningCerts(t)[0]
+	if err := sc.Resolve(); err != nil {
+		t.Fatal(err)
+	}
+	if IsDebugCert(sc.Certificate) {
+		t.Error("test key reported as debug")
+	}
+}
+
+func TestGenerateDebugCert(t *testing.T) {
+	debug, err := GenerateDebugCert("", 0, RSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsDebugCert(debug.Certificate) || debug.Certificate.NotAfter.Before(time.Now().Add(DebugCertValidity-time.Hour)) {
+		t.Errorf("debug certificate %s valid until %s", debug.Certificate.Subject, debug.Certificate.NotAfter)
+	}
+	for _, kt := range []KeyAlgorithm{RSA, EC, Ed25519} {
+		sc, err := GenerateDebugCert("ci "+string(kt), time.Hour, kt)
+		if err != nil {
+			t.Fatalf("%s: %v", kt, err)
+		}
+		if sc.Type != kt || IsDebugCert(sc.Certificate) || sc.Certificate.Subject.CommonName != "ci "+string(kt) {
+			t.Errorf("%s: type %s, subject %s", kt, sc.Type, sc.Certificate.Subject)
+		}
+		var opts []Option
+		if kt == Ed25519 {
+			opts = append(opts, WithGenericZip())
+		}
+		z, err := NewApkSign(testZip(t, "AndroidManifest.xml", "manifest"), opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signed, err := z.SignV2([]*SigningCert{sc})
+		if err != nil {
+			t.Fatalf("%s: %v", kt, err)
+		}
+		if z, err = NewApkSign(signed, opts...); err != nil {
+			t.Fatal(err)
+		}
+		if err = z.VerifyV2(); err != nil {
+			t.Errorf("%s: %v", kt, err)
+		}
+
+		// the keystore reads back with the same key and certificate
+		ks, err := EncodePKCS12(sc, "release", "secret")
+		if err != nil {
+			t.Fatalf("%s: %v", kt, err)
+		}
+		loaded, err := ParseKeystore(ks, "secret", "release", "")
+		if err != nil {
+			t.Fatalf("%s: %v", kt, err)
+		}
+		if !loaded.Certificate.Equal(sc.Certificate) || !certPubKey(sc.Certificate).Equal(loaded.signer().Public()) {
+			t.Errorf("%s: keystore holds another key", kt)
+		}
+		if _, err = ParseKeystore(ks, "wrong", "", ""); !errors.Is(err, ErrKeystorePassword) {
+			t.Errorf("%s: wrong password gave %v", kt, err)
+		}
+	}
+}
+
+func TestDebugSigned(t *testing.T) {
+	debug, err := GenerateDebugCert("", 0, RSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned := testZip(t, "AndroidManifest.xml", "manifest")
+	sign := map[string]func(z *ApkSign, keys []*SigningCert) ([]byte, error){
+		"v1": func(z *ApkSign, keys []*SigningCert) ([]byte, error) { return z.SignV1(keys) },
+		"v2": func(z *ApkSign, keys []*SigningCert) ([]byte, error) { return z.SignV2(keys) },
+		"v3": func(z *ApkSign, keys []*SigningCert) ([]byte, error) { return z.SignV3(keys, nil) },
+	}
+	for name, sign := range sign {
+		for _, keys := range [][]*SigningCert{{debug}, testSigningCerts(t)} {
+			z, err := NewApkSign(unsigned)
+			if err != nil {
+				t.Fatal(err)
+			}
+			signed, err := sign(z, keys)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if z, err = NewApkSign(signed); err != nil {
+				t.Fatal(err)
+			}
+			want := keys[0] == debug
+			if got, err := z.DebugSigned(); got != want || err != nil {
+				t.Errorf("%s: DebugSigned = %v, %v, want %v", name, got, err, want)
+			}
+			if got := z.VerifyAll(VerifyOptions{}).DebugSigned; got != want {
+				t.Errorf("%s: VerifyAll DebugSigned = %v, want %v", name, got, want)
+			}
+		}
+	}
+}
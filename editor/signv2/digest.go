@@ -1,25 +1,32 @@
 package signv2
 
 import (
-	"bytes"
+	"context"
 	"crypto"
 	"encoding/binary"
-	"hash"
-	"io"
-	"log"
+	"runtime"
 )
 
 // Digester is a crypto.Hash implementation that implements the Merkel-tree-flavored hash scheme
 // defined in the Android APK v2 signing scheme.
 type Digester struct {
-	Hash   crypto.Hash
+	Hash crypto.Hash
+	// Progress, if set, is called from Sum as each chunk digest completes
+	// with the number of input bytes hashed so far.
+	Progress func(done int64)
+	// Concurrency is the number of chunks hashed at a time, runtime.GOMAXPROCS(0) if <= 0.
+	Concurrency int
+	// ctx, if set, stops hashing of chunks not yet started once it is done; Sum then returns garbage
+	// and the caller must check ctx.Err().
+	ctx    context.Context
 	chunks []chan []byte
+	sizes  []int
 }
 
 // NewDigester returns a new instance using the provided crypto.Hash algorithm for its underlying
 // hash scheme.
 func NewDigester(h crypto.Hash) *Digester {
-	return &Digester{h, make([]chan []byte, 0)}
+	return &Digester{Hash: h, chunks: make([]chan []byte, 0)}
 }
 
 // Write starts a parallelized chunk-based hash computation of the input. Note that this differs
@@ -27,9 +34,13 @@ func NewDigester(h crypto.Hash) *Digester {
 // of its input. This means that `d.Write(a); d.Write(b)` != `d.Write(append(a, b...))` unless `a`
 // happens to be an exact multiple of 1k.
 //
-// This function starts `len(p) / 1k` goroutines.
+// This function returns immediately; the chunks are hashed in the background, at most Concurrency
+// at a time.
 func (d *Digester) Write(p []byte) (n int, err error) {
-	d.chunks = append(d.chunks, parallelBufferHash(p, d.Hash.New)...)
+	d.chunks = append(d.chunks, parallelBufferHash(d.ctx, p, d.Hash, d.Concurrency)...)
+	for n := len(p); n > 0; n -= 1048576 {
+		d.sizes = append(d.sizes, min(n, 1048576))
+	}
 	return len(p), nil
 }
 
@@ -41,15 +52,14 @@ func (d *Digester) Sum(b []byte) []byte {
 	accumHash := d.Hash.New()
 	accumHash.Write([]byte{0x5a})
 	accumHash.Write(numChunks)
-	for _, c := range d.chunks {
+	var done int64
+	for i, c := range d.chunks {
 		buf := <-c
-		//log.Println("Digester.Sum", "chunk hash", hex.EncodeToString(buf))
-		_, err := io.Copy(accumHash, bytes.NewReader(buf))
-		if err != nil {
-			// this is highly unlikely to happen, but given return type, nothing we can do
-			log.Println("Digester.Sum", "error copying RAM buffers", err)
-			break
+		if d.Progress != nil {
+			done += int64(d.sizes[i])
+			d.Progress(done)
 		}
+		accumHash.Write(buf) // hash.Hash never returns an error
 	}
 	return accumHash.Sum(b)
 }
@@ -57,6 +67,7 @@ func (d *Digester) Sum(b []byte) []byte {
 // Reset clears this Digester, preparing it for a new usage.
 func (d *Digester) Reset() {
 	d.chunks = make([]chan []byte, 0)
+	d.sizes = nil
 }
 
 // Size() returns the size of the Digester, which is the same as the size of its current underlying
@@ -71,35 +82,42 @@ func (d *Digester) BlockSize() int {
 	return d.Hash.New().BlockSize()
 }
 
-func parallelBufferHash(inbuf []byte, newHash func() hash.Hash) []chan []byte {
-	hasher := func(d []byte, h hash.Hash, c chan []byte) {
-		h.Write(d)
-		c <- h.Sum(nil)
-	}
+// parallelBufferHash hashes inbuf in 1MB chunks, returning one channel per chunk that yields its
+// digest. At most workers chunks are hashed at a time, GOMAXPROCS if workers <= 0, and chunks are hashed in place rather
+// than copied, so inbuf may be a memory-mapped file much larger than RAM. Hash states are taken
+// from a pool and returned once each chunk digest is computed.
+func parallelBufferHash(ctx context.Context, inbuf []byte, hash crypto.Hash, workers int) []chan []byte {
 	var ret []chan []byte
-	count := len(inbuf)
-	for count > 0 {
-		c := make(chan []byte)
-		var buf []byte
-		var l uint32
-		if count < 1048576 {
-			buf = make([]byte, count+5)
-			copy(buf[5:], inbuf[:count])
-			l = uint32(count)
-			count = 0
-		} else {
-			buf = make([]byte, 1048576+5)
-			copy(buf[5:], inbuf[:1048576])
-			l = 1048576
-			count -= 1048576
-			inbuf = inbuf[1048576:]
-		}
-		buf[0] = 0xa5
-		binary.LittleEndian.PutUint32(buf[1:5], l)
-		go hasher(buf, newHash(), c)
-		ret = append(ret, c)
+	for n := len(inbuf); n > 0; n -= 1048576 {
+		ret = append(ret, make(chan []byte, 1))
 	}
-
+	go func() {
+		if workers <= 0 {
+			workers = runtime.GOMAXPROCS(0)
+		}
+		slots := make(chan struct{}, workers)
+		for _, c := range ret {
+			chunk := inbuf[:min(len(inbuf), 1048576)]
+			inbuf = inbuf[len(chunk):]
+			if ctx != nil && ctx.Err() != nil {
+				c <- nil
+				continue
+			}
+			slots <- struct{}{}
+			go func() {
+				var prefix [5]byte
+				prefix[0] = 0xa5
+				binary.LittleEndian.PutUint32(prefix[1:], uint32(len(chunk)))
+				h := getHash(hash)
+				h.Write(prefix[:])
+				h.Write(chunk)
+				sum := h.Sum(nil)
+				putHash(hash, h)
+				<-slots
+				c <- sum
+			}()
+		}
+	}()
 	return ret
 }
 
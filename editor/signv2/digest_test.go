@@ -0,0 +1,64 @@
+package signv2
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"testing"
+)
+
+func TestDigestConcurrency(t *testing.T) {
+	signed := testSignedApk(t)
+	z, err := NewApkSign(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := testContentDigest(t, z)
+	for _, n := range []int{1, 2, 3, 64} {
+		z, err := NewApkSign(signed, WithConcurrency(n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := testContentDigest(t, z); !bytes.Equal(got, want) {
+			t.Errorf("%d workers: digest differs", n)
+		}
+		if err = z.VerifyV2(); err != nil {
+			t.Errorf("%d workers: %v", n, err)
+		}
+		windowed, err := NewApkSignFromReaderAt(bytes.NewReader(signed), int64(len(signed)), WithWindow(1), WithConcurrency(n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := testContentDigest(t, windowed); !bytes.Equal(got, want) {
+			t.Errorf("%d workers, windowed: digest differs", n)
+		}
+	}
+
+	d := NewDigester(crypto.SHA256)
+	d.Concurrency = 1
+	d.Write(bytes.Repeat([]byte{1}, 5<<20/2))
+	sequential := d.Sum(nil)
+	d = NewDigester(crypto.SHA256)
+	d.ctx = context.Background()
+	d.Write(bytes.Repeat([]byte{1}, 5<<20/2))
+	if !bytes.Equal(d.Sum(nil), sequential) {
+		t.Error("Digester with one worker differs")
+	}
+
+	// the option of a SignV2 call applies to that call
+	unsigned, err := NewApkSign(testZip(t, "classes.dex", string(bytes.Repeat([]byte{2}, 3<<20))), WithDeterministic())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := unsigned.SignV2(testSigningCerts(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := unsigned.SignV2(testSigningCerts(t), WithConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a, b) {
+		t.Error("signing with one worker gave another file")
+	}
+}
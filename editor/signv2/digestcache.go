@@ -0,0 +1,92 @@
+package signv2
+
+import (
+	"bytes"
+	"crypto"
+	"slices"
+	"sync"
+)
+
+// DigestCache remembers v2 content digests, so that verifying an APK and then re-signing the same
+// content with new keys hashes the file only once. Attach it with WithDigestCache; it is safe for
+// concurrent use and may be shared by any number of ApkSigns.
+//
+// A digest is reused only for byte-identical content: the files section, the Central Directory and
+// the EOCD are compared, which costs a fraction of hashing them and is free when they are the very
+// same memory. Since the signing block is not part of the content, this also covers the output of
+// signing. The cache keeps the compared sections alive, so use one per group of related operations
+// rather than for the life of the process.
+type DigestCache struct {
+	mu      sync.Mutex
+	entries []*digestEntry
+}
+
+type digestEntry struct {
+	owner           *byte // first byte of the file the sections are slices of
+	hash            crypto.Hash
+	files, cd, eocd []byte
+	pad             uint64 // zero bytes following files in the files section
+	digest          []byte
+}
+
+// NewDigestCache returns an empty DigestCache.
+func NewDigestCache() *DigestCache {
+	return &DigestCache{}
+}
+
+// lookup returns the cached digest of the given sections, or nil. The files section is files
+// followed by pad zero bytes, so the padded section a signer hashed matches the signed output, where
+// the zeros are part of the file.
+func (c *DigestCache) lookup(hash crypto.Hash, files []byte, pad uint64, cd, eocd []byte) []byte {
+	c.mu.Lock()
+	entries := c.entries
+	c.mu.Unlock()
+	for _, e := range entries {
+		if e.hash == hash && samePadded(e.files, e.pad, files, pad) && sameBytes(e.cd, cd) && bytes.Equal(e.eocd, eocd) {
+			return e.digest
+		}
+	}
+	return nil
+}
+
+// store adds the digest of sections of raw.
+func (c *DigestCache) store(raw []byte, hash crypto.Hash, files []byte, pad uint64, cd, eocd, digest []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = append(c.entries, &digestEntry{&raw[0], hash, files, cd, eocd, pad, digest})
+}
+
+// forget drops the entries for sections of raw, which must be done before it is unmapped.
+func (c *DigestCache) forget(raw []byte) {
+	if len(raw) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var kept []*digestEntry
+	for _, e := range c.entries {
+		if e.owner != &raw[0] {
+			kept = append(kept, e)
+		}
+	}
+	c.entries = kept
+}
+
+// samePadded reports whether a followed by apad zero bytes equals b followed by bpad zero bytes.
+func samePadded(a []byte, apad uint64, b []byte, bpad uint64) bool {
+	if uint64(len(a))+apad != uint64(len(b))+bpad {
+		return false
+	}
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	return sameBytes(a, b[:len(a)]) && !slices.ContainsFunc(b[len(a):], func(c byte) bool { return c != 0 })
+}
+
+// sameBytes is bytes.Equal with a shortcut for two slices over the same memory.
+func sameBytes(a, b []byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	return len(a) == 0 || &a[0] == &b[0] || bytes.Equal(a, b)
+}
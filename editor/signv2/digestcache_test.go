@@ -0,0 +1,78 @@
+package signv2
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDigestCache(t *testing.T) {
+	signed := testSignedApk(t)
+	cache := NewDigestCache()
+	var hashed int
+	open := func(apk []byte) *ApkSign {
+		z, err := NewApkSign(apk, WithDigestCache(cache))
+		if err != nil {
+			t.Fatal(err)
+		}
+		z.Progress = func(stage string, done, total int64) { hashed++ }
+		return z
+	}
+
+	z := open(signed)
+	if err := z.VerifyV2(); err != nil {
+		t.Fatal(err)
+	}
+	if hashed == 0 {
+		t.Fatal("first verification did not hash")
+	}
+
+	// re-signing the same content, and verifying a copy of it or the re-signed output, hashes nothing
+	hashed = 0
+	resigned, err := z.SignV2([]*SigningCert{generateSigningCert(t, "new key")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, apk := range map[string][]byte{"copy": signed, "re-signed": resigned} {
+		if err = open(apk).VerifyV2(); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if hashed != 0 {
+		t.Errorf("hashed again despite the cache")
+	}
+
+	// different content must not hit
+	tampered := append([]byte(nil), signed...)
+	tampered[40] ^= 1
+	if err = open(tampered).VerifyV2(); !errors.Is(err, ErrDigestMismatch) {
+		t.Errorf("tampered: got %v, want ErrDigestMismatch", err)
+	}
+	if hashed == 0 {
+		t.Error("tampered content was not hashed")
+	}
+}
+
+func TestDigestCacheClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "signed.apk")
+	if err := os.WriteFile(path, testSignedApk(t), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cache := NewDigestCache()
+	z, err := OpenApkSignFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a cache given to a single call must be emptied of the mapping as well
+	if _, err = z.SignV2(testSigningCerts(t), WithDigestCache(cache)); err != nil {
+		t.Fatal(err)
+	}
+	if len(cache.entries) == 0 {
+		t.Fatal("nothing cached")
+	}
+	z.Close()
+	if len(cache.entries) != 0 {
+		t.Error("Close left entries pointing into the unmapped file")
+	}
+}
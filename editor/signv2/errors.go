@@ -0,0 +1,189 @@
+package signv2
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+)
+
+// Errors returned by parsing and verification. They may be wrapped with more detail, so test for
+// them with errors.Is.
+var (
+	// ErrNotZip is returned when the input has no valid End of Central Directory record.
+	ErrNotZip = errors.New("input is not a zip")
+	// ErrMalformedEOCD is returned along with ErrNotZip when the input has an End of Central
+	// Directory record, but it does not point to a Central Directory.
+	ErrMalformedEOCD = errors.New("malformed End of Central Directory record")
+	// ErrCDNotAdjacent is returned when the Central Directory is not immediately followed by the
+	// End of Central Directory record, which the v2 scheme requires.
+	ErrCDNotAdjacent = errors.New("CD not adjacent to EOCD")
+	// ErrBadSigBlock is returned when the APK Signing Block, or the pairs or signers in it, cannot
+	// be parsed. The error gives the offset of the broken structure, see ErrorContext.
+	ErrBadSigBlock = errors.New("malformed signing block")
+	// ErrNotV2Signed is returned by v2 operations on a file without a v2 signature.
+	ErrNotV2Signed = errors.New("file is not v2 signed")
+	// ErrDigestMismatch is returned when a content digest recorded by a signer does not match the
+	// file, i.e. the file was modified after signing.
+	ErrDigestMismatch = errors.New("content digest mismatch")
+	// ErrUnknownAlgorithm is returned when no signature or digest uses an algorithm this package
+	// supports.
+	ErrUnknownAlgorithm = errors.New("unsupported signature algorithm")
+	// ErrLimitExceeded is returned when a file exceeds one of the parser limits, such as
+	// MaxSigners or MaxSigningBlockSize.
+	ErrLimitExceeded = errors.New("parser limit exceeded")
+	// ErrCertMismatch is returned by VerifyAgainstCert when the APK verifies but is signed by a
+	// different certificate.
+	ErrCertMismatch = errors.New("APK is not signed by the expected certificate")
+	// ErrUntrusted is matched by the *TrustError VerifyWithPolicy returns when an APK verifies but its
+	// signers break the TrustPolicy.
+	ErrUntrusted = errors.New("APK signers do not meet the trust policy")
+	// ErrV1Required is returned when signing for a minimum API level below 24 (see WithMinSdk) a
+	// file without a v1 signature, since those platforms ignore the v2 signature.
+	ErrV1Required = errors.New("APK needs a v1 signature for API levels below 24")
+	// ErrGenericZipOnly is returned when signing with an option the Android platform does not
+	// accept, an Ed25519 key or an APK entry compressed with neither store nor deflate, without
+	// WithGenericZip.
+	ErrGenericZipOnly = errors.New("not supported for APKs, only with WithGenericZip")
+	// ErrNotSignable is returned when signing an app bundle or an APK set with a scheme they do not
+	// take: bundles are signed with SignV1, and the APKs of a set one by one.
+	ErrNotSignable = errors.New("scheme does not apply to this kind of archive")
+	// ErrContentModified is returned when signing with PreserveBlocks a file whose contents no
+	// longer match the digests its signers recorded: the blocks PreserveBlocks keeps describe the
+	// original contents and would be wrong for the new ones.
+	ErrContentModified = errors.New("contents modified since the preserved blocks were made")
+	// ErrKeystore is returned by LoadKeystore for a malformed or unsupported keystore, or one
+	// without the requested key entry.
+	ErrKeystore = errors.New("invalid keystore")
+	// ErrKeystorePassword is returned by LoadKeystore when the keystore or key password is wrong.
+	ErrKeystorePassword = errors.New("wrong keystore password")
+	// ErrPanic is matched by the error of an operation that panicked, see PanicError.
+	ErrPanic = errors.New("internal error")
+)
+
+// Location says where in the input a parse error occurred.
+type Location struct {
+	// Offset is the byte offset of the failing structure, or -1 if unknown. Errors from ApkSign
+	// constructors and methods give file offsets; the Parse functions give offsets into their
+	// input. With Entry set, it is an offset into the uncompressed entry.
+	Offset int64
+	// Chunk names the structure being parsed, e.g. "EOCD", "signing block pair" or "signed data".
+	Chunk string
+	// Entry is the zip entry the error occurred in, if any.
+	Entry string
+}
+
+func (l Location) String() string {
+	s := l.Chunk
+	if l.Offset >= 0 {
+		s += fmt.Sprintf(" at offset %d", l.Offset)
+	}
+	if l.Entry != "" {
+		s = l.Entry + ": " + s
+	}
+	return s
+}
+
+// ParseError is a parse error together with its location. Use ErrorContext to retrieve the location
+// of any error, as several may be wrapped inside each other.
+type ParseError struct {
+	Location
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	return e.Location.String() + ": " + e.Err.Error()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorLocation implements the interface ErrorContext looks for.
+func (e *ParseError) ErrorLocation() (offset int64, chunk, entry string) {
+	return e.Offset, e.Chunk, e.Entry
+}
+
+// ErrorContext returns where in the input err occurred. It combines the locations of the errors in
+// err's chain that have an ErrorLocation method, such as *ParseError and the errors of package axml:
+// the entry comes from the outermost error naming one, the offset and chunk from the innermost error
+// that has an offset. ok is false if no error in the chain has a location.
+func ErrorContext(err error) (loc Location, ok bool) {
+	loc.Offset = -1
+	for ; err != nil; err = errors.Unwrap(err) {
+		l, has := err.(interface {
+			ErrorLocation() (offset int64, chunk, entry string)
+		})
+		if !has {
+			continue
+		}
+		offset, chunk, entry := l.ErrorLocation()
+		if loc.Entry == "" {
+			loc.Entry = entry
+		}
+		if offset >= 0 || !ok {
+			loc.Offset, loc.Chunk = offset, chunk
+		}
+		ok = true
+	}
+	return loc, ok
+}
+
+// PanicError reports a panic recovered at a public entry point of this package or of package editor.
+// Parsing should never panic, but a signing service fed hostile input must not crash if a bounds
+// check is missing, so the entry points that parse turn a panic into an error instead. Panics in
+// callbacks such as ApkSign.Progress are recovered the same way. The error is wrapped in a
+// *ParseError naming the structure being processed, see ErrorContext, and matches ErrPanic.
+type PanicError struct {
+	// Func is the entry point that recovered the panic, e.g. "ParseV2Block".
+	Func  string
+	Value any
+	// Stack is the stack trace of the panicking goroutine.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("%v: %s panicked: %v", ErrPanic, e.Func, e.Value)
+}
+
+// Unwrap returns the panic value if it is an error, such as a runtime.Error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+func (e *PanicError) Is(target error) bool {
+	return target == ErrPanic
+}
+
+// catch is deferred by the public entry points with a pointer to their named error result. It
+// recovers a panic and reports it as a *PanicError from fn while processing chunk.
+func catch(err *error, fn, chunk string) {
+	if v := recover(); v != nil {
+		*err = &ParseError{Location{-1, chunk, ""}, &PanicError{fn, v, debug.Stack()}}
+	}
+}
+
+// locate wraps a non-nil err in a *ParseError for chunk, at the offset of sub within base, where sub
+// is a subslice of base sharing its end of capacity. If err already is a *ParseError from a nested
+// structure, its offset is shifted instead, keeping the more precise chunk. A nil err is returned
+// as is.
+func locate(err error, chunk string, base, sub []byte) error {
+	if err == nil {
+		return nil
+	}
+	return locateAt(err, chunk, int64(cap(base)-cap(sub)))
+}
+
+// locateAt is locate with an explicit offset.
+func locateAt(err error, chunk string, offset int64) error {
+	if err == nil {
+		return nil
+	}
+	if pe, ok := err.(*ParseError); ok && pe.Entry == "" {
+		if pe.Offset >= 0 {
+			pe.Offset += offset
+		}
+		return pe
+	}
+	return &ParseError{Location{offset, chunk, ""}, err}
+}
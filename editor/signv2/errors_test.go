@@ -0,0 +1,172 @@
+package signv2
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"testing"
+)
+
+func TestErrors(t *testing.T) {
+	if _, err := NewApkSign(make([]byte, 10)); !errors.Is(err, ErrNotZip) {
+		t.Errorf("short input: got %v, want ErrNotZip", err)
+	}
+	if _, err := NewApkSign(make([]byte, 100)); !errors.Is(err, ErrNotZip) || errors.Is(err, ErrMalformedEOCD) {
+		t.Errorf("no EOCD: got %v, want ErrNotZip", err)
+	}
+	// an EOCD pointing past the end of the file
+	eocd := make([]byte, 100)
+	copy(eocd[78:], concat(eocdMagic, make([]byte, 12), u32(1000), make([]byte, 2)))
+	_, err := NewApkSign(eocd)
+	if !errors.Is(err, ErrNotZip) || !errors.Is(err, ErrMalformedEOCD) {
+		t.Errorf("bad EOCD: got %v, want ErrMalformedEOCD", err)
+	}
+	if loc, _ := ErrorContext(err); loc.Offset != 78 || loc.Chunk != "EOCD" {
+		t.Errorf("bad EOCD: location %v", loc)
+	}
+	if _, err = ParseSigningBlock([]byte{1, 2, 3}); !errors.Is(err, ErrBadSigBlock) {
+		t.Errorf("short pair: got %v, want ErrBadSigBlock", err)
+	}
+
+	z, err := NewApkSign(testZip(t, "a.txt", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = z.VerifyV2(); !errors.Is(err, ErrNotV2Signed) {
+		t.Errorf("unsigned: got %v, want ErrNotV2Signed", err)
+	}
+
+	signed := testSignedApk(t)
+	signed[40] ^= 1
+	if z, err = NewApkSign(signed); err != nil {
+		t.Fatal(err)
+	}
+	if err = z.VerifyV2(); !errors.Is(err, ErrDigestMismatch) {
+		t.Errorf("modified: got %v, want ErrDigestMismatch", err)
+	}
+	if err = z.TamperCheck(); !errors.Is(err, ErrDigestMismatch) {
+		t.Errorf("TamperCheck: got %v, want ErrDigestMismatch", err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	z, err := NewApkSign(testZip(t, "a.txt", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := testSigningCerts(t)[0]
+	if err = sc.Resolve(); err != nil {
+		t.Fatal(err)
+	}
+	signer := push32(testV2Signer(t, z, sc))
+	var signers [][]byte
+	for i := 0; i <= MaxSigners; i++ {
+		signers = append(signers, signer)
+	}
+	block := push64(concat(u32(BlockIDV2), push32(concat(signers...))))
+	if _, err = ParseV2Block(block); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("%d signers: got %v, want ErrLimitExceeded", len(signers), err)
+	}
+	block = push64(concat(u32(BlockIDV2), push32(concat(signers[:MaxSigners]...))))
+	if _, err = ParseV2Block(block); err != nil {
+		t.Errorf("%d signers: %v", MaxSigners, err)
+	}
+}
+
+func TestErrorContext(t *testing.T) {
+	signed := testSignedApk(t)
+	z, err := NewApkSign(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := testSigningCerts(t)[0]
+	if err = sc.Resolve(); err != nil {
+		t.Fatal(err)
+	}
+	pair := int64(z.asv2Offset) + 8
+	cert := int64(bytes.Index(signed, sc.Certificate.Raw))
+
+	for _, tc := range []struct {
+		name   string
+		offset int64
+		value  []byte
+		chunk  string
+		at     int64
+	}{
+		{"pair length", pair, []byte{0xff, 0xff, 0xff, 0xff}, "signing block pair", pair},
+		{"certificate", cert, []byte{0}, "certificate", cert},
+	} {
+		b := bytes.Clone(signed)
+		copy(b[tc.offset:], tc.value)
+		z, err := NewApkSign(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = z.VerifyV2()
+		loc, ok := ErrorContext(err)
+		if !ok || loc.Chunk != tc.chunk || loc.Offset != tc.at {
+			t.Errorf("%s: ErrorContext(%v) = %+v, %v; want %s at %d", tc.name, err, loc, ok, tc.chunk, tc.at)
+		}
+	}
+
+	if _, err = NewApkSign(append(bytes.Clone(signed), 0)); err == nil {
+		t.Fatal("trailing byte accepted")
+	} else if loc, ok := ErrorContext(err); !ok || loc.Chunk != "EOCD" || !errors.Is(err, ErrNotZip) {
+		t.Errorf("no EOCD: %+v, %v", loc, ok)
+	}
+
+	// the entry comes from the outermost error, the offset from the innermost
+	nested := fmt.Errorf("lint: %w", &ParseError{Location{-1, "", "AndroidManifest.xml"},
+		&ParseError{Location{8, "string pool", ""}, errors.New("truncated")}})
+	loc, ok := ErrorContext(nested)
+	if want := (Location{8, "string pool", "AndroidManifest.xml"}); !ok || loc != want {
+		t.Errorf("nested: %+v, want %+v", loc, want)
+	}
+	if want := "AndroidManifest.xml: string pool at offset 8"; loc.String() != want {
+		t.Errorf("String() = %q, want %q", loc.String(), want)
+	}
+	if _, ok = ErrorContext(errors.New("plain")); ok {
+		t.Error("plain error has a location")
+	}
+}
+
+func TestPanicRecovery(t *testing.T) {
+	index := func(b []byte, i int) (err error) {
+		defer catch(&err, "index", "test chunk")
+		_ = b[i]
+		return nil
+	}
+	err := index(nil, 1)
+	var re runtime.Error
+	if !errors.Is(err, ErrPanic) || !errors.As(err, &re) {
+		t.Fatalf("got %v, want ErrPanic wrapping a runtime.Error", err)
+	}
+	if loc, ok := ErrorContext(err); !ok || loc.Chunk != "test chunk" {
+		t.Errorf("ErrorContext = %+v, %v", loc, ok)
+	}
+	var pe *PanicError
+	if !errors.As(err, &pe) || pe.Func != "index" || len(pe.Stack) == 0 {
+		t.Errorf("PanicError = %+v", pe)
+	}
+
+	z, err := NewApkSign(testSignedApk(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	z.Progress = func(string, int64, int64) { panic("progress") }
+	if _, err = z.SignV2(testSigningCerts(t)); !errors.Is(err, ErrPanic) {
+		t.Errorf("SignV2: got %v, want ErrPanic", err)
+	}
+	if err = z.SignV2To(io.Discard, testSigningCerts(t)); !errors.Is(err, ErrPanic) {
+		t.Errorf("SignV2To: got %v, want ErrPanic", err)
+	}
+	r := z.VerifyAll(VerifyOptions{})
+	if r.Verified || !errors.Is(r.Err(), ErrPanic) {
+		t.Errorf("VerifyAll: verified %v, err %v", r.Verified, r.Err())
+	}
+	if len(r.Schemes) != 5 {
+		t.Errorf("VerifyAll: %d schemes, want 5", len(r.Schemes))
+	}
+}
@@ -0,0 +1,252 @@
+package signv2
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// OpenApkSignFile parses the file at path like NewApkSign, but maps it into memory instead of reading
+// it, so that files larger than the available RAM can be verified and, with SignV2To, signed. The
+// file must not be modified while it is open.
+//
+// The file stays open, so that SignV2To can copy the unchanged files section straight from it: when
+// the destination is an *os.File (or a writer wrapping one, such as a bufio.Writer) the copy is done
+// by the kernel with copy_file_range or sendfile where available, and re-signing only touches the
+// signing block, the Central Directory and the EOCD in memory.
+//
+// Close must be called when done. Values parsed from the file, such as the signers returned by
+// V2Block, refer to the mapping and must not be used after Close.
+func OpenApkSignFile(path string, opts ...Option) (*ApkSign, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	buf, unmap, err := mapFile(f, fi.Size())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	z, err := NewApkSignNoCopy(buf, opts...)
+	if err != nil {
+		unmap()
+		f.Close()
+		return nil, err
+	}
+	z.file, z.unmap = f, unmap
+	return z, nil
+}
+
+// NewApkSignFromReaderAt parses the size bytes of r like NewApkSign, but holds at most a fixed window
+// of them in memory, DefaultVerifyWindow unless set with WithWindow: the signing block, the Central
+// Directory and the EOCD must fit in it, and the files section is read from r one window at a time
+// when digesting or writing. Memory use thus stays bounded whatever the size of the APK, e.g. for
+// APKs read from object storage through a ranged reader.
+//
+// Verification and SignV2To work as for an ApkSign created from a slice; SignV2, SignV3 and the other
+// methods returning the signed file as a slice hold it in memory. Bytes and RawBytes return nil, and
+// v4 signatures are not checked. r must not change while the ApkSign is in use.
+func NewApkSignFromReaderAt(r io.ReaderAt, size int64, opts ...Option) (_ *ApkSign, err error) {
+	defer catch(&err, "NewApkSignFromReaderAt", "file")
+	o := newOptions(opts)
+	window := o.window
+	if window <= 0 {
+		window = DefaultVerifyWindow
+	}
+	window = (window + 1048575) &^ 1048575
+	z, err := newWindowed(r, size, window, o)
+	if err != nil {
+		return nil, err
+	}
+	if o.strictZip {
+		if err = z.checkStrictZip(); err != nil {
+			return nil, err
+		}
+	}
+	return z, nil
+}
+
+// Close releases the mapping and the file opened with OpenApkSignFile. It does nothing for an
+// ApkSign created from a byte slice.
+func (apkSign *ApkSign) Close() error {
+	if apkSign.unmap == nil {
+		return nil
+	}
+	apkSign.cachesMu.Lock()
+	for _, c := range apkSign.caches {
+		c.forget(apkSign.raw)
+	}
+	apkSign.caches = nil
+	apkSign.cachesMu.Unlock()
+	err := apkSign.unmap()
+	if cerr := apkSign.file.Close(); err == nil {
+		err = cerr
+	}
+	apkSign.unmap, apkSign.file = nil, nil
+	apkSign.raw, apkSign.rawASv2 = nil, nil
+	return err
+}
+
+// writeRaw writes the first n bytes of the file to w. For a file opened with
+// OpenApkSignFile they are read from the file rather than the mapping, which lets io.Copy hand the
+// copy to the kernel when w can take it, and for one opened with NewApkSignFromReaderAt they are
+// read one window at a time; otherwise they are written from memory without copying. When progress
+// is reported the copy is done in progressChunk pieces.
+func (apkSign *ApkSign) writeRaw(w io.Writer, n uint64, p *progressCounter) error {
+	var r io.Reader
+	step := int64(n)
+	switch {
+	case apkSign.window > 0:
+		r = io.NewSectionReader(apkSign.ra, 0, int64(n))
+		step = int64(apkSign.window)
+	case apkSign.file == nil:
+		return p.write(w, apkSign.raw[:n])
+	default:
+		apkSign.fileMu.Lock()
+		defer apkSign.fileMu.Unlock()
+		if _, err := apkSign.file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		r = apkSign.file
+	}
+	if p.progress != nil {
+		step = min(step, progressChunk)
+	}
+	for remaining := int64(n); remaining > 0; {
+		copied, err := io.Copy(w, io.LimitReader(r, min(remaining, step)))
+		p.add(int(copied))
+		if err == nil && copied != min(remaining, step) {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		remaining -= copied
+	}
+	return nil
+}
+
+// SignFile signs the file at inPath with SignV2 and writes the result to outPath without reading
+// either into memory: the input is mapped with OpenApkSignFile and the output streamed to a temporary
+// file next to outPath, which is synced and then renamed over outPath. outPath thus never holds a
+// partial file, and may be inPath itself. The output gets the permissions of the input.
+func SignFile(inPath, outPath string, keys []*SigningCert, opts ...Option) (err error) {
+	defer catch(&err, "SignFile", "file")
+	z, err := OpenApkSignFile(inPath, opts...)
+	if err != nil {
+		return err
+	}
+	return z.signAndClose(context.Background(), outPath, keys, opts)
+}
+
+// signAndClose implements SignFile for an ApkSign OpenApkSignFile returned, closing it.
+func (apkSign *ApkSign) signAndClose(ctx context.Context, path string, keys []*SigningCert, opts []Option) error {
+	tmp, err := apkSign.signToTemp(ctx, path, keys, opts)
+	// Unmap before renaming: Windows cannot replace a mapped file.
+	if cerr := apkSign.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		if tmp != "" {
+			os.Remove(tmp)
+		}
+		return err
+	}
+	return replaceFile(tmp, path)
+}
+
+// SignV2ToFile is like SignFile for an ApkSign that is already open, e.g. to set Progress or
+// PreserveBlocks first. On Windows path must not be the file the ApkSign was opened from, since a
+// mapped file cannot be replaced there; use SignFile for that.
+func (apkSign *ApkSign) SignV2ToFile(path string, keys []*SigningCert, opts ...Option) (err error) {
+	defer catch(&err, "SignV2ToFile", "file")
+	tmp, err := apkSign.signToTemp(context.Background(), path, keys, opts)
+	if err != nil {
+		return err
+	}
+	return replaceFile(tmp, path)
+}
+
+// signToTemp writes the signed file to a synced temporary file in the directory of path and returns
+// its name, stopping once ctx is done. Files that SignV2To cannot sign as they are, being misaligned or getting a source
+// stamp, are signed in memory with SignV2. The streamed output is self-checked as SignV2 does.
+func (apkSign *ApkSign) signToTemp(ctx context.Context, path string, keys []*SigningCert, opts []Option) (_ string, err error) {
+	o := apkSign.options.with(opts)
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	inMemory := o.stamp != nil || !o.noAlign && errors.Is(apkSign.CheckAlignment(0), ErrMisaligned)
+	if inMemory {
+		signed, err := apkSign.SignV2Context(ctx, keys, opts...)
+		if err != nil {
+			return "", err
+		}
+		if _, err = f.Write(signed); err != nil {
+			return "", err
+		}
+	} else {
+		w := bufio.NewWriterSize(f, 1<<20)
+		if err = apkSign.SignV2ToContext(ctx, w, keys, opts...); err != nil {
+			return "", err
+		}
+		if err = w.Flush(); err != nil {
+			return "", err
+		}
+	}
+	mode := os.FileMode(0644)
+	if apkSign.file != nil {
+		if fi, err := apkSign.file.Stat(); err == nil {
+			mode = fi.Mode().Perm()
+		}
+	}
+	if err = f.Chmod(mode); err != nil {
+		return "", err
+	}
+	if err = f.Sync(); err != nil {
+		return "", err
+	}
+	if err = f.Close(); err != nil {
+		return "", err
+	}
+	if !inMemory && (apkSign.SelfCheck || SelfCheckEnabled()) {
+		signed, err := OpenApkSignFile(f.Name(), opts...)
+		if err != nil {
+			return "", err
+		}
+		err = signed.VerifyV2()
+		signed.Close()
+		if err != nil {
+			return "", &SelfCheckError{Op: "sign", Err: err}
+		}
+	}
+	return f.Name(), nil
+}
+
+// replaceFile renames tmp to path and syncs the directory so that the rename survives a crash. The
+// directory sync is best effort, as not every system can open a directory for it.
+func replaceFile(tmp, path string) error {
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if d, err := os.Open(filepath.Dir(path)); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+package signv2
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestOpenApkSignFile(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.apk")
+	unsigned := testZip(t, "AndroidManifest.xml", "manifest", "assets/big.bin", string(bytes.Repeat([]byte{7}, 3<<20)))
+	if err := os.WriteFile(in, unsigned, 0644); err != nil {
+		t.Fatal(err)
+	}
+	z, err := OpenApkSignFile(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := new(bytes.Buffer)
+	err = z.SignV2To(out, testSigningCerts(t))
+	z.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// must match signing in memory
+	mem, err := NewApkSign(unsigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := mem.SignV2(testSigningCerts(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), want) {
+		t.Error("SignV2To output differs from SignV2")
+	}
+	if err = CheckSigned(out.Bytes()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSignFile(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.apk")
+	unsigned := testZip(t, "AndroidManifest.xml", "manifest", "assets/big.bin", string(bytes.Repeat([]byte{7}, 3<<20)))
+	if err := os.WriteFile(in, unsigned, 0600); err != nil {
+		t.Fatal(err)
+	}
+	mem, err := NewApkSign(unsigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := mem.SignV2(testSigningCerts(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a failed signing leaves the input alone and no temporary file behind
+	missing := &SigningCert{SigningKey: SigningKey{KeyPath: filepath.Join(dir, "missing.pem")}, CertPath: filepath.Join(dir, "missing.pem")}
+	if err = SignFile(in, in, []*SigningCert{missing}); err == nil {
+		t.Fatal("SignFile with a missing key succeeded")
+	}
+	// signing in place replaces the input
+	if err = SignFile(in, in, testSigningCerts(t)); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("SignFile output differs from SignV2")
+	}
+	if fi, err := os.Stat(in); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("mode of signed file = %v, %v, want 0600", fi.Mode().Perm(), err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("directory holds %d entries, want only the signed file (%v)", len(entries), err)
+	}
+}
+
+// fileCopyRecorder records whether the files section was offered to it as a file read, which is
+// what lets an *os.File destination use copy_file_range or sendfile.
+type fileCopyRecorder struct {
+	bytes.Buffer
+	fromFile int64
+}
+
+func (r *fileCopyRecorder) ReadFrom(src io.Reader) (int64, error) {
+	if lr, ok := src.(*io.LimitedReader); ok {
+		if _, ok = lr.R.(*os.File); ok {
+			r.fromFile += lr.N
+		}
+	}
+	return r.Buffer.ReadFrom(src)
+}
+
+func TestSignV2ToFileCopy(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.apk")
+	signed := testSign(t, testZip(t, "AndroidManifest.xml", "manifest", "assets/big.bin", string(bytes.Repeat([]byte{7}, 3<<20))))
+	if err := os.WriteFile(in, signed, 0644); err != nil {
+		t.Fatal(err)
+	}
+	z, err := OpenApkSignFile(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+
+	// re-signing with the same key must reproduce the input, the files section coming from the file
+	rec := new(fileCopyRecorder)
+	if err = z.SignV2To(rec, testSigningCerts(t)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rec.Bytes(), signed) {
+		t.Error("re-signed output differs")
+	}
+	if rec.fromFile != int64(z.asv2Offset) {
+		t.Errorf("%d bytes copied from the file, want the %d byte files section", rec.fromFile, z.asv2Offset)
+	}
+
+	// and again into a real file through a bufio.Writer, as the CLI does
+	out, err := os.Create(filepath.Join(dir, "out.apk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := bufio.NewWriter(out)
+	if err = z.SignV2To(w, testSigningCerts(t)); err == nil {
+		err = w.Flush()
+	}
+	out.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(out.Name()); !bytes.Equal(b, signed) {
+		t.Error("re-signed file differs")
+	}
+}
+
+func TestReadAtWriteTo(t *testing.T) {
+	signed := testSignedApk(t)
+	path := filepath.Join(t.TempDir(), "signed.apk")
+	if err := os.WriteFile(path, signed, 0644); err != nil {
+		t.Fatal(err)
+	}
+	mem, err := NewApkSign(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapped, err := OpenApkSignFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mapped.Close()
+	windowed, err := NewApkSignFromReaderAt(bytes.NewReader(signed), int64(len(signed)), WithWindow(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, z := range map[string]*ApkSign{"memory": mem, "file": mapped, "reader": windowed} {
+		r, err := zip.NewReader(z, z.Size())
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(r.File) != 4 {
+			t.Errorf("%s: zip.NewReader found %d entries", name, len(r.File))
+		}
+		tail := make([]byte, 30)
+		if n, err := z.ReadAt(tail, z.Size()-20); n != 20 || err != io.EOF || !bytes.Equal(tail[:n], signed[len(signed)-20:]) {
+			t.Errorf("%s: ReadAt past the end = %d, %v", name, n, err)
+		}
+
+		out := new(bytes.Buffer)
+		if n, err := z.WriteTo(out); err != nil || n != int64(len(signed)) || !bytes.Equal(out.Bytes(), signed) {
+			t.Errorf("%s: WriteTo = %d, %v", name, n, err)
+		}
+	}
+}
+
+// boundedReaderAt fails reads larger than max, standing in for a source too large to load.
+type boundedReaderAt struct {
+	r   io.ReaderAt
+	max int
+}
+
+func (b *boundedReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) > b.max {
+		return 0, fmt.Errorf("read of %d bytes", len(p))
+	}
+	return b.r.ReadAt(p, off)
+}
+
+func TestNewApkSignFromReaderAt(t *testing.T) {
+	unsigned := testZip(t, "AndroidManifest.xml", "manifest", "assets/big.bin", string(bytes.Repeat([]byte{7}, 5<<20)))
+	mem, err := NewApkSign(unsigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := mem.SignV2(testSigningCerts(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// with a 1MB window the 5MB file is never read at once, yet signs as in memory
+	z, err := NewApkSignFromReaderAt(&boundedReaderAt{bytes.NewReader(unsigned), 1 << 20}, int64(len(unsigned)), WithWindow(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if z.Bytes() != nil || z.RawBytes() != nil {
+		t.Error("Bytes holds the file")
+	}
+	if p := z.ParseResult(); !reflect.DeepEqual(p, mem.ParseResult()) {
+		t.Errorf("ParseResult %+v, want %+v", p, mem.ParseResult())
+	}
+	out := new(bytes.Buffer)
+	if err = z.SignV2To(out, testSigningCerts(t)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), want) {
+		t.Error("SignV2To output differs from SignV2")
+	}
+	signed, err := z.SignV2(testSigningCerts(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(signed, want) {
+		t.Error("SignV2 output differs")
+	}
+	v3, err := z.SignV3(testSigningCerts(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = CheckSigned(v3); err != nil {
+		t.Error(err)
+	}
+
+	// and verifies its output the same way
+	s, err := NewApkSignFromReaderAt(&boundedReaderAt{bytes.NewReader(want), 1 << 20}, int64(len(want)), WithWindow(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.VerifyV2(); err != nil {
+		t.Error(err)
+	}
+	if res := s.VerifyAll(VerifyOptions{}); !res.Verified {
+		t.Errorf("VerifyAll = %+v", res)
+	}
+
+	// the Central Directory must fit in the window
+	if _, err = NewApkSignFromReaderAt(bytes.NewReader(want[:100]), 100); err == nil {
+		t.Error("truncated file parsed")
+	}
+}
@@ -0,0 +1,168 @@
+package signv2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+// noPanic fails the fuzz target if err is a recovered panic: the parsers must reject hostile input
+// with an error, and a panic caught by catch is a missing bounds check.
+func noPanic(t *testing.T, err error) {
+	t.Helper()
+	if errors.Is(err, ErrPanic) {
+		var pe *PanicError
+		errors.As(err, &pe)
+		t.Fatalf("%v\n%s", err, pe.Stack)
+	}
+}
+
+// FuzzNewApkSign feeds corrupted archives through parsing and verification. Errors are expected,
+// panics are not.
+func FuzzNewApkSign(f *testing.F) {
+	unsigned := testZip(f, "AndroidManifest.xml", "manifest", "classes.dex", "dex")
+	f.Add(unsigned)
+	f.Add(testSign(f, unsigned))
+	f.Add(testZip64(f, unsigned))
+	f.Add(testSign(f, testZip64(f, unsigned)))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		// the lenient parser repairs the input first, the strict one checks where each entry lies
+		for _, opt := range []Option{WithStrictZip(), WithLenientZip()} {
+			_, err := NewApkSign(b, opt)
+			noPanic(t, err)
+		}
+		z, err := NewApkSign(b)
+		noPanic(t, err)
+		if err != nil {
+			return
+		}
+		noPanic(t, z.VerifyAll(VerifyOptions{Time: time.Now()}).Err())
+		noPanic(t, z.TamperCheck())
+	})
+}
+
+// FuzzParseV2Block covers the signing block parsers on their own, so the fuzzer does not have to
+// produce a valid zip around them.
+func FuzzParseV2Block(f *testing.F) {
+	z, err := NewApkSign(testSign(f, testZip(f, "a.txt", "a")))
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(z.rawASv2)
+	f.Fuzz(func(t *testing.T, b []byte) {
+		_, err := ParseV2Block(b)
+		noPanic(t, err)
+		_, err = ParseV3Block(b, BlockIDV3)
+		noPanic(t, err)
+		_, err = ParseSourceStamp(b)
+		noPanic(t, err)
+		_, err = ParseLineage(b)
+		noPanic(t, err)
+		_, err = ParseV4Signature(b)
+		noPanic(t, err)
+	})
+}
+
+// FuzzParseSigner starts from a v2 signer, below the signing block and pair framing.
+func FuzzParseSigner(f *testing.F) {
+	z, err := NewApkSign(testSign(f, testZip(f, "a.txt", "a")))
+	if err != nil {
+		f.Fatal(err)
+	}
+	v2, err := z.GetBlock(BlockIDV2)
+	if err != nil {
+		f.Fatal(err)
+	}
+	block, err := UnmarshalV2Block(v2)
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(block.Signers[0].Marshal())
+	f.Add(block.Signers[0].SignedData.Marshal())
+	f.Fuzz(func(t *testing.T, b []byte) {
+		_, err := ParseSigner(b)
+		noPanic(t, err)
+		_, err = ParseSignedData(b)
+		noPanic(t, err)
+		_, err = ParseSignature(b)
+		noPanic(t, err)
+		_, err = UnmarshalV2Block(b)
+		noPanic(t, err)
+	})
+}
+
+// FuzzVerifyV4 feeds corrupted .idsig files to the v4 verifier along with the APK they sign.
+func FuzzVerifyV4(f *testing.F) {
+	apk := testSign(f, testZip(f, "a.txt", "a"))
+	idsig, err := SignV4(apk, testSigningCerts(f))
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(idsig)
+	f.Fuzz(func(t *testing.T, b []byte) {
+		noPanic(t, VerifyV4(apk, b))
+	})
+}
+
+// TestHostileOffsets covers offsets read from the file that overflow when added to, which the
+// fuzzer is unlikely to produce.
+func TestHostileOffsets(t *testing.T) {
+	plain := testZip(t, "a.txt", "a")
+	record := len(plain) - 22
+	for _, cd := range []uint64{1<<64 - 1, 1<<64 - 2, 1 << 63, uint64(record) + 1} {
+		b := testZip64(t, plain)
+		binary.LittleEndian.PutUint64(b[record+48:], cd)
+		_, err := NewApkSign(b)
+		if err == nil || errors.Is(err, ErrPanic) {
+			t.Errorf("CD offset %#x: got %v, want a parse error", cd, err)
+		}
+	}
+
+	// signing block size fields past the start of the file
+	signed := testSign(t, plain)
+	z, err := NewApkSign(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range []uint64{1<<64 - 1, z.cdOffset, 23} {
+		b := bytes.Clone(signed)
+		binary.LittleEndian.PutUint64(b[z.cdOffset-24:], size)
+		_, err := NewApkSign(b)
+		if errors.Is(err, ErrPanic) || err == nil && size != 23 {
+			t.Errorf("signing block size %#x: got %v", size, err)
+		}
+	}
+
+	// lengths inside the block that overflow a uint32 sum
+	for _, b := range [][]byte{
+		concat(u32(0xffffffff), u32(0xfffffffc)),
+		concat(u32(8), u32(1), u32(0xfffffffc)),
+		push32(concat(u32(0xffffffff), make([]byte, 8))),
+	} {
+		for name, parse := range map[string]func([]byte) error{
+			"ParseSigner":      func(b []byte) error { _, err := ParseSigner(b); return err },
+			"ParseSignedData":  func(b []byte) error { _, err := ParseSignedData(b); return err },
+			"ParseSignature":   func(b []byte) error { _, err := ParseSignature(b); return err },
+			"UnmarshalV2Block": func(b []byte) error { _, err := UnmarshalV2Block(b); return err },
+			"ParseLineage":     func(b []byte) error { _, err := ParseLineage(b); return err },
+		} {
+			if err := parse(b); err == nil || errors.Is(err, ErrPanic) {
+				t.Errorf("%s(%x): got %v, want a parse error", name, b, err)
+			}
+		}
+	}
+}
+
+func testSign(t testing.TB, apk []byte) []byte {
+	z, err := NewApkSign(apk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := z.SignV2(testSigningCerts(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
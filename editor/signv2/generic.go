@@ -0,0 +1,46 @@
+package signv2
+
+import (
+	"crypto/x509"
+	"fmt"
+
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+// checkMethods returns ErrGenericZipOnly if an entry is compressed with a method other than store
+// and deflate, the only ones the Android package manager reads.
+func (apkSign *ApkSign) checkMethods() error {
+	r, err := apkSign.zipReader()
+	if err != nil {
+		return err
+	}
+	for _, f := range r.File {
+		if f.Method != zip.Store && f.Method != zip.Deflate {
+			return fmt.Errorf("%w: entry %s uses compression method %d", ErrGenericZipOnly, f.Name, f.Method)
+		}
+	}
+	return nil
+}
+
+// VerifyGenericZip verifies the v2 signature of an archive signed WithGenericZip, such as a
+// firmware image, and returns the certificate of each signer. Unlike VerifyV2 on an ApkSign opened
+// without the option, Ed25519 signatures are accepted.
+func VerifyGenericZip(buf []byte) (_ []*x509.Certificate, err error) {
+	defer catch(&err, "VerifyGenericZip", "file")
+	z, err := NewApkSign(buf, WithNoCopy(), WithGenericZip(), WithLogger(nil))
+	if err != nil {
+		return nil, err
+	}
+	if err = z.VerifyV2(); err != nil {
+		return nil, err
+	}
+	v2, err := z.V2Block()
+	if err != nil {
+		return nil, err
+	}
+	certs := make([]*x509.Certificate, 0, len(v2.Signers))
+	for _, s := range v2.Signers {
+		certs = append(certs, s.SignedData.Certs[0])
+	}
+	return certs, nil
+}
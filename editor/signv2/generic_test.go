@@ -0,0 +1,122 @@
+package signv2
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// generateEd25519Cert creates a self-signed Ed25519 key pair.
+func generateEd25519Cert(t testing.TB) *SigningCert {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "firmware"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, pub, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &SigningCert{
+		SigningKey: SigningKey{
+			KeyBytes: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}),
+			Type:     Ed25519,
+			Hash:     SHA512,
+		},
+		CertBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// zstdZip returns an archive that looks like an APK and has an entry compressed with zstd.
+func zstdZip(t testing.TB) []byte {
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	for _, name := range []string{"AndroidManifest.xml", "classes.dex", "resources.arsc"} {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(name))
+	}
+	f, err := w.CreateRaw(&zip.FileHeader{Name: "rootfs.img", Method: 93, CompressedSize64: 4, UncompressedSize64: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("zstd"))
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestGenericZip(t *testing.T) {
+	in := zstdZip(t)
+
+	z, err := NewApkSign(in, WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = z.SignV2(testSigningCerts(t)); !errors.Is(err, ErrGenericZipOnly) {
+		t.Fatalf("signing an APK with a zstd entry: got %v, want ErrGenericZipOnly", err)
+	}
+	plain, err := NewApkSign(testZip(t, "a.txt", "a"), WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = plain.SignV2([]*SigningCert{generateEd25519Cert(t)}); !errors.Is(err, ErrGenericZipOnly) {
+		t.Fatalf("signing with an Ed25519 key: got %v, want ErrGenericZipOnly", err)
+	}
+
+	z, err = NewApkSign(in, WithLogger(nil), WithGenericZip())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if z.IsAPK || z.ParseResult().Kind != KindZip {
+		t.Errorf("kind = %v, want zip", z.ParseResult().Kind)
+	}
+	key := generateEd25519Cert(t)
+	z.SelfCheck = true
+	signed, err := z.SignV2([]*SigningCert{key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, err := VerifyGenericZip(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 1 || !certs[0].Equal(key.Certificate) {
+		t.Errorf("signers = %v, want the Ed25519 certificate", certs)
+	}
+
+	// Android does not know the algorithm, and neither does verification in APK mode
+	apk, err := NewApkSign(signed, WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = apk.VerifyV2(); !errors.Is(err, ErrUnknownAlgorithm) {
+		t.Errorf("VerifyV2 without WithGenericZip: got %v, want ErrUnknownAlgorithm", err)
+	}
+
+	tampered := bytes.Clone(signed)
+	tampered[bytes.Index(tampered, []byte("classes.dex"))+len("classes.dex")] ^= 1
+	if _, err = VerifyGenericZip(tampered); err == nil {
+		t.Error("VerifyGenericZip accepted a modified archive")
+	}
+}
@@ -0,0 +1,108 @@
+package signv2
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	testKeyOnce sync.Once
+	testKey     *SigningCert
+)
+
+// testSigningCerts returns a freshly resolved key pair shared by the tests of
+// this package; generating RSA keys is too slow to do per test.
+func testSigningCerts(t testing.TB) []*SigningCert {
+	testKeyOnce.Do(func() {
+		testKey = generateSigningCert(t, "signv2 test")
+	})
+	return []*SigningCert{{
+		SigningKey: SigningKey{KeyBytes: testKey.KeyBytes, Type: RSA, Hash: SHA256},
+		CertBytes:  testKey.CertBytes,
+	}}
+}
+
+// generateSigningCert creates a self-signed RSA key pair.
+func generateSigningCert(t testing.TB, cn string) *SigningCert {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := &SigningCert{
+		SigningKey: SigningKey{
+			KeyBytes: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+			Type:     RSA,
+			Hash:     SHA256,
+		},
+		CertBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+	if err = sc.Resolve(); err != nil {
+		t.Fatal(err)
+	}
+	return sc
+}
+
+// testZip builds an archive with the given name/content pairs.
+func testZip(t testing.TB, entries ...string) []byte {
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	for i := 0; i+1 < len(entries); i += 2 {
+		f, err := w.Create(entries[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(entries[i+1]))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// testSignedApk returns a v2 signed archive with a few entries.
+func testSignedApk(t testing.TB) []byte {
+	z, err := NewApkSign(testZip(t,
+		"AndroidManifest.xml", "manifest",
+		"classes.dex", "dex",
+		"resources.arsc", "arsc",
+		"assets/big.bin", string(bytes.Repeat([]byte{7}, 3<<20)),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := z.SignV2(testSigningCerts(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// testContentDigest returns the chunked SHA-256 content digest of z.
+func testContentDigest(t testing.TB, z *ApkSign) []byte {
+	digest, err := z.contentDigest(context.Background(), crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return digest
+}
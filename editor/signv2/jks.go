@@ -0,0 +1,163 @@
+package signv2
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/subtle"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+)
+
+// Magic numbers of the keystores of the Sun provider.
+const (
+	jksMagic   = 0xfeedfeed
+	jceksMagic = 0xcececece
+)
+
+// Tags of JKS entries.
+const (
+	jksPrivateKey  = 1
+	jksTrustedCert = 2
+)
+
+// oidJKSKeyProtector is the algorithm of keys protected by sun.security.provider.KeyProtector.
+var oidJKSKeyProtector = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 17, 1, 1}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+// jksReader reads the big-endian fields of a JKS file, recording the first error.
+type jksReader struct {
+	b   []byte
+	err error
+}
+
+func (r *jksReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.b) {
+		r.err = fmt.Errorf("%w: JKS file is truncated", ErrKeystore)
+		return nil
+	}
+	b := r.b[:n:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *jksReader) uint16() int {
+	if b := r.next(2); b != nil {
+		return int(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (r *jksReader) uint32() int {
+	if b := r.next(4); b != nil {
+		return int(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+// utf reads a string written by DataOutputStream.writeUTF. Aliases and certificate types are
+// ASCII in practice, where modified UTF-8 is the same as UTF-8.
+func (r *jksReader) utf() string {
+	return string(r.next(r.uint16()))
+}
+
+// parseJKS returns the private key entries of a JKS file, checking its integrity with storePass and
+// decrypting the keys with keyPass.
+func parseJKS(data []byte, storePass, keyPass string) ([]*keystoreEntry, error) {
+	if len(data) < 8+sha1.Size {
+		return nil, fmt.Errorf("%w: JKS file is truncated", ErrKeystore)
+	}
+	body, sum := data[:len(data)-sha1.Size], data[len(data)-sha1.Size:]
+	// the digest of the password, the string "Mighty Aphrodite" and the body
+	h := sha1.New()
+	h.Write(bmpPassword(storePass))
+	h.Write([]byte("Mighty Aphrodite"))
+	h.Write(body)
+	if subtle.ConstantTimeCompare(h.Sum(nil), sum) != 1 {
+		return nil, fmt.Errorf("%w: keystore integrity check failed", ErrKeystorePassword)
+	}
+
+	r := &jksReader{b: body[4:]}
+	version := r.uint32()
+	if version != 1 && version != 2 {
+		return nil, fmt.Errorf("%w: JKS version %d", ErrKeystore, version)
+	}
+	var entries []*keystoreEntry
+	for n := r.uint32(); n > 0 && r.err == nil; n-- {
+		tag := r.uint32()
+		alias := r.utf()
+		r.next(8) // creation time
+		switch tag {
+		case jksPrivateKey:
+			e := &keystoreEntry{alias: alias}
+			protected := r.next(r.uint32())
+			for certs := r.uint32(); certs > 0 && r.err == nil; certs-- {
+				if version == 2 {
+					r.utf() // certificate type, X.509
+				}
+				e.certs = append(e.certs, r.next(r.uint32()))
+			}
+			if r.err != nil {
+				break
+			}
+			key, err := jksDecryptKey(protected, keyPass)
+			if err != nil {
+				return nil, fmt.Errorf("entry %q: %w", alias, err)
+			}
+			e.key = key
+			entries = append(entries, e)
+		case jksTrustedCert:
+			if version == 2 {
+				r.utf()
+			}
+			r.next(r.uint32())
+		default:
+			return nil, fmt.Errorf("%w: JKS entry %q has tag %d", ErrKeystore, alias, tag)
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return entries, nil
+}
+
+// jksDecryptKey returns the PKCS #8 key protected by sun.security.provider.KeyProtector: the
+// encrypted data is a 20 byte salt, the key XORed with a keystream of chained SHA-1 digests of the
+// password and the salt, and the SHA-1 digest of the password and the key as a check.
+func jksDecryptKey(der []byte, password string) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrKeystore, err)
+	}
+	if !info.Algorithm.Algorithm.Equal(oidJKSKeyProtector) {
+		return nil, fmt.Errorf("%w: key protection %v", ErrUnknownAlgorithm, info.Algorithm.Algorithm)
+	}
+	b := info.EncryptedData
+	if len(b) < 2*sha1.Size {
+		return nil, fmt.Errorf("%w: protected key is truncated", ErrKeystore)
+	}
+	salt, enc, check := b[:sha1.Size], b[sha1.Size:len(b)-sha1.Size], b[len(b)-sha1.Size:]
+	pw := bmpPassword(password)
+	key := make([]byte, len(enc))
+	digest := salt
+	for i := range enc {
+		if i%sha1.Size == 0 {
+			sum := sha1.Sum(append(bytes.Clone(pw), digest...))
+			digest = sum[:]
+		}
+		key[i] = enc[i] ^ digest[i%sha1.Size]
+	}
+	sum := sha1.Sum(append(pw, key...))
+	if subtle.ConstantTimeCompare(sum[:], check) != 1 {
+		return nil, fmt.Errorf("%w: cannot decrypt the key", ErrKeystorePassword)
+	}
+	return key, nil
+}
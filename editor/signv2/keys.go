@@ -2,6 +2,8 @@ package signv2
 
 import (
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
@@ -9,32 +11,57 @@ import (
 	"encoding/hex"
 	"encoding/pem"
 	"errors"
-	"log"
+	"fmt"
 	"os"
 	"path/filepath"
 )
 
 // SigningKey wraps a private key disk file with functions that know how to parse the key, and sign
-// things with it. Currently only RSA keys and SHA-2/256 and SHA-2/512 digests are supported.
+// things with it. RSA and ECDSA keys are supported with SHA-2/256 and SHA-2/512 digests, plus
+// Ed25519 keys, which always use SHA-2/512 content digests, for WithGenericZip. RSA keys sign with
+// PKCS #1 v1.5 unless Algorithm selects RSA-PSS.
+//
+// The private key need not be in the process: Signer may be any crypto.Signer, such as a key of a
+// PKCS #11 token or a cloud KMS, which is then asked for one signature per signed data.
 type SigningKey struct {
 	KeyPath  string
 	KeyBytes []byte
 	Type     KeyAlgorithm
 	Hash     HashAlgorithm
-	Key      *rsa.PrivateKey
+	// Algorithm, if set, is the signature algorithm to use instead of the one Type and Hash select,
+	// built in or added with RegisterAlgorithm; Hash is then ignored.
+	Algorithm AlgorithmID
+	Key       *rsa.PrivateKey
+	EdKey     ed25519.PrivateKey // Type 为 Ed25519 时代替 Key
+	ECKey     *ecdsa.PrivateKey  // Type 为 EC 时代替 Key
+	// Signer, if set, signs instead of Key, EdKey and ECKey, and KeyPath and KeyBytes are ignored.
+	// Type defaults to that of its public key, and Hash to SHA256, or SHA512 for Ed25519. RSA and
+	// ECDSA signers get digests to sign, with rsa.PSSOptions for RSA-PSS, Ed25519 ones the data
+	// itself with crypto.Hash(0), as crypto.Signer specifies.
+	Signer crypto.Signer
 }
 
 // Resolve loads the private key from disk and parses it. A non-nil error is returned if the parsing
-// fails for any reason, or if the key type is unsupported.
+// fails for any reason, or if the key type is unsupported. An empty Type is that of the key, whose
+// Hash then defaults as for a Signer.
 func (sk *SigningKey) Resolve() error {
-	if sk.Type != RSA {
-		// TODO: support EC
-		return errors.New("elliptic curve support not currently implemented")
+	switch {
+	case sk.Signer != nil:
+		return sk.resolveSigner()
+	case sk.Type == "":
+		return sk.resolveType()
+	case sk.Type == Ed25519:
+		return sk.resolveEd25519()
+	case sk.Type == EC:
+		return sk.resolveEC()
+	case sk.Type != RSA:
+		return errors.New("unknown signing key type")
 	}
 
-	switch sk.Hash {
-	case SHA256:
-	case SHA512:
+	switch {
+	case sk.Algorithm != 0:
+	case sk.Hash == SHA256:
+	case sk.Hash == SHA512:
 	default:
 		return errors.New("unsupported hash algorithm was specified")
 	}
@@ -65,34 +92,218 @@ func (sk *SigningKey) Resolve() error {
 		}
 		key, err := x509.ParsePKCS1PrivateKey(block.Bytes) // assumes ASN1 DER representation of a PKCS1 key
 		if err != nil {
-			log.Println("SigningKey.Resolve", "error parsing PKCS1 private key, retrying with PKCS8", err)
-
+			// not PKCS1, retry with PKCS8
 			keyPKCS8, err := x509.ParsePKCS8PrivateKey(block.Bytes)
 			if err != nil {
-				log.Println("SigningKey.Resolve", "error parsing PKCS8 private key", err)
 				return err
 			}
-			key = keyPKCS8.(*rsa.PrivateKey)
+			rsaKey, ok := keyPKCS8.(*rsa.PrivateKey)
+			if !ok {
+				return errors.New("type set as RSA but key is not an RSA key")
+			}
+			key = rsaKey
 		}
 		sk.Key = key
 		return nil
 
-	case EC:
-		// TODO: support EC
-		return errors.New("EC not currently supported")
-
 	default:
 		return errors.New("unknown signing key type")
 	}
 }
 
+// loadKey returns the PEM block of the private key.
+func (sk *SigningKey) loadKey() (*pem.Block, error) {
+	someBytes := sk.KeyBytes
+	if sk.KeyPath != "" {
+		var err error
+		if someBytes, err = safeLoad(sk.KeyPath); err != nil {
+			return nil, err
+		}
+	}
+	block, _ := pem.Decode(someBytes)
+	if block == nil {
+		return nil, errors.New("key does not decode as PEM")
+	}
+	return block, nil
+}
+
+// resolveType is Resolve for keys without a Type, which is taken from the key.
+func (sk *SigningKey) resolveType() error {
+	var key any
+	switch {
+	case sk.Key != nil:
+		key = sk.Key
+	case sk.ECKey != nil:
+		key = sk.ECKey
+	case sk.EdKey != nil:
+		key = sk.EdKey
+	default:
+		block, err := sk.loadKey()
+		if err != nil {
+			return err
+		}
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		default:
+			return fmt.Errorf("key is a PEM %q block, not a private key", block.Type)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	typ, err := publicKeyType(key.(crypto.Signer).Public())
+	if err != nil {
+		return err
+	}
+	sk.Type = typ
+	if err = sk.defaultHash(); err != nil {
+		return err
+	}
+	return sk.Resolve()
+}
+
+// resolveEC is Resolve for ECDSA keys, which are stored as SEC 1 "EC PRIVATE KEY" or PKCS#8
+// "PRIVATE KEY" blocks.
+func (sk *SigningKey) resolveEC() error {
+	switch {
+	case sk.Algorithm != 0:
+	case sk.Hash == SHA256:
+	case sk.Hash == SHA512:
+	default:
+		return errors.New("unsupported hash algorithm was specified")
+	}
+	if sk.KeyPath == "" && sk.ECKey != nil {
+		return nil
+	}
+	block, err := sk.loadKey()
+	if err != nil {
+		return err
+	}
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		sk.ECKey, err = x509.ParseECPrivateKey(block.Bytes)
+		return err
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return err
+		}
+		ecKey, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return errors.New("type set as EC but key is not an ECDSA key")
+		}
+		sk.ECKey = ecKey
+		return nil
+	}
+	return errors.New("type set as EC but PEM block does not look like an 'EC PRIVATE KEY'")
+}
+
+// resolveSigner is Resolve for keys with a Signer, whose public key gives the default Type.
+func (sk *SigningKey) resolveSigner() error {
+	typ, err := publicKeyType(sk.Signer.Public())
+	if err != nil {
+		return err
+	}
+	if sk.Type == "" {
+		sk.Type = typ
+	}
+	if sk.Type != typ {
+		return fmt.Errorf("type set as %s but the signer has a %s key", sk.Type, typ)
+	}
+	return sk.defaultHash()
+}
+
+// defaultHash sets an empty Hash to that of Type, and checks it.
+func (sk *SigningKey) defaultHash() error {
+	switch {
+	case sk.Algorithm != 0:
+	case sk.Hash == "" && sk.Type == Ed25519:
+		sk.Hash = SHA512
+	case sk.Hash == "":
+		sk.Hash = SHA256
+	case sk.Type == Ed25519 && sk.Hash != SHA512:
+		return errors.New("Ed25519 keys need the SHA512 hash algorithm")
+	case sk.Hash != SHA256 && sk.Hash != SHA512:
+		return errors.New("unsupported hash algorithm was specified")
+	}
+	return nil
+}
+
+// publicKeyType returns the KeyAlgorithm of pub.
+func publicKeyType(pub crypto.PublicKey) (KeyAlgorithm, error) {
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		return RSA, nil
+	case *ecdsa.PublicKey:
+		return EC, nil
+	case ed25519.PublicKey:
+		return Ed25519, nil
+	}
+	return "", fmt.Errorf("%w: %T public key", ErrUnknownAlgorithm, pub)
+}
+
+// signer returns the crypto.Signer that signs with the key, nil before Resolve.
+func (sk *SigningKey) signer() crypto.Signer {
+	switch {
+	case sk.Signer != nil:
+		return sk.Signer
+	case sk.Type == Ed25519 && sk.EdKey != nil:
+		return sk.EdKey
+	case sk.Type == EC && sk.ECKey != nil:
+		return sk.ECKey
+	case sk.Type == RSA && sk.Key != nil:
+		return sk.Key
+	}
+	return nil
+}
+
+// resolveEd25519 is Resolve for Ed25519 keys, which are stored as PKCS#8 "PRIVATE KEY" blocks.
+func (sk *SigningKey) resolveEd25519() error {
+	if sk.Hash != SHA512 {
+		return errors.New("Ed25519 keys need the SHA512 hash algorithm")
+	}
+	if sk.KeyPath == "" && sk.EdKey != nil {
+		return nil
+	}
+	someBytes := sk.KeyBytes
+	if sk.KeyPath != "" {
+		var err error
+		if someBytes, err = safeLoad(sk.KeyPath); err != nil {
+			return err
+		}
+	}
+	block, _ := pem.Decode(someBytes)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return errors.New("type set as Ed25519 but key is not a PEM 'PRIVATE KEY'")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return err
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return errors.New("type set as Ed25519 but key is not an Ed25519 key")
+	}
+	sk.EdKey = edKey
+	return nil
+}
+
 // Sign returns the input bytes signed using the private key and the provided hash function. A
 // non-nil error indicates that the signing operation failed for some reason, usually do to
 // incorrect use of the configured cryptosystem.
 //
 // It is an error to call this function before Resolve(). Note again that currently only RSA is
-// supported; the returned bytes will specifically be in binary DER-encoded PKCS#1v1.5 format.
+// supported; the returned bytes will specifically be in binary DER-encoded PKCS#1v1.5 format. Ed25519
+// keys sign data itself and ignore hash.
 func (sk *SigningKey) Sign(data []byte, hash crypto.Hash) ([]byte, error) {
+	if sk.Type == Ed25519 {
+		return sk.signer().Sign(rand.Reader, data, crypto.Hash(0))
+	}
 	h := hash.New()
 	h.Write(data)
 	sum := h.Sum(nil)
@@ -102,11 +313,7 @@ func (sk *SigningKey) Sign(data []byte, hash crypto.Hash) ([]byte, error) {
 // SignPrehashed is the same as Sign, except that its input bytes must be pre-hashed (or at least
 // the same length as a digest under the provided crypto.Hash scheme.)
 func (sk *SigningKey) SignPrehashed(data []byte, hash crypto.Hash) ([]byte, error) {
-	res, err := rsa.SignPKCS1v15(rand.Reader, sk.Key, hash, data)
-	if err != nil {
-		log.Println("SigningKey.SignPrehashed", "error during sign", err)
-	}
-	return res, err
+	return sk.signer().Sign(rand.Reader, data, hash)
 }
 
 // SigningCert is a SigningKey that adds a public key Certificate.
@@ -121,29 +328,38 @@ type SigningCert struct {
 // Resolve parses the PEM-encoded DER/ASN.1 X.509 certificate, as well as the private key (by
 // calling SigningKey.Resolve() on itself.) A non-nil error is returned if the parsing fails for any
 // reason, or on I/O errors.
+//
+// Certificate may be set instead of CertPath or CertBytes. Once the key and certificate are
+// resolved, Resolve does nothing, so that a resolved SigningCert can be shared by concurrent
+// signing operations.
 func (sc *SigningCert) Resolve() error {
+	if sc.signer() != nil && sc.Certificate != nil && sc.CertHash != "" {
+		return nil
+	}
 	err := sc.SigningKey.Resolve()
 	if err != nil {
 		return err
 	}
 
-	// parse Certificate
-	var someBytes []byte
-	if sc.CertPath != "" && sc.CertBytes == nil {
-		someBytes, err = safeLoad(sc.CertPath)
-	} else {
-		someBytes = sc.CertBytes
-	}
-	if err != nil {
-		return err
-	}
-	block, _ := pem.Decode(someBytes) // require cert to be in first block in file; ignore rest
-	if block == nil {
-		return errors.New("certificate does not decode as PEM")
-	}
-	cert, err := x509.ParseCertificate(block.Bytes) // assumes ASN1 DER
-	if err != nil {
-		return err
+	// parse Certificate, unless it was given parsed, as with a Signer from a token holding both
+	cert := sc.Certificate
+	if cert == nil || sc.CertPath != "" || sc.CertBytes != nil {
+		var someBytes []byte
+		if sc.CertPath != "" && sc.CertBytes == nil {
+			someBytes, err = safeLoad(sc.CertPath)
+		} else {
+			someBytes = sc.CertBytes
+		}
+		if err != nil {
+			return err
+		}
+		block, _ := pem.Decode(someBytes) // require cert to be in first block in file; ignore rest
+		if block == nil {
+			return errors.New("certificate does not decode as PEM")
+		}
+		if cert, err = x509.ParseCertificate(block.Bytes); err != nil { // assumes ASN1 DER
+			return err
+		}
 	}
 	b := sha256.Sum256(cert.Raw)
 	certHash := hex.EncodeToString(b[:]) // cert.Raw == block.Bytes
@@ -155,39 +371,54 @@ func (sc *SigningCert) Resolve() error {
 		default:
 			return errors.New("type set as RSA but certificate doesn't contain RSA public key")
 		}
-		certPubKey := cert.PublicKey.(*rsa.PublicKey)
-		if sc.Key.N.Cmp(certPubKey.N) != 0 || sc.Key.E != certPubKey.E {
-			log.Println("SigningCert.Resolve", "certificate public key does not match private key's copy", sc.Key.N, certPubKey.N, sc.Key.E, certPubKey.E)
+		if !certPubKey(cert).Equal(sc.signer().Public()) {
+			return errors.New("certificate public key does not match private key's copy")
+		}
+		sc.Certificate, sc.CertHash = cert, certHash
+		return nil
+
+	case Ed25519:
+		if _, ok := cert.PublicKey.(ed25519.PublicKey); !ok {
+			return errors.New("type set as Ed25519 but certificate doesn't contain Ed25519 public key")
+		}
+		if !certPubKey(cert).Equal(sc.signer().Public()) {
 			return errors.New("certificate public key does not match private key's copy")
 		}
 		sc.Certificate, sc.CertHash = cert, certHash
 		return nil
 
 	case EC:
-		// TODO: support EC
-		return errors.New("EC not currently supported")
+		if _, ok := cert.PublicKey.(*ecdsa.PublicKey); !ok {
+			return errors.New("type set as EC but certificate doesn't contain ECDSA public key")
+		}
+		if !certPubKey(cert).Equal(sc.signer().Public()) {
+			return errors.New("certificate public key does not match private key's copy")
+		}
+		sc.Certificate, sc.CertHash = cert, certHash
+		return nil
 
 	default:
 		return errors.New("unknown signing key type")
 	}
 }
 
+// certPubKey returns the public key of cert, which is one of the types of the standard library, all
+// of which have an Equal method.
+func certPubKey(cert *x509.Certificate) interface{ Equal(crypto.PublicKey) bool } {
+	return cert.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+}
+
 func safeLoad(path string) ([]byte, error) {
 	var err error
 
 	if path, err = filepath.Abs(path); err != nil {
-		log.Println("android.safeLoad", "file '"+path+"' does not resolve")
 		return nil, err
 	}
 
-	if stat, err := os.Stat(path); err != nil || (stat != nil && stat.IsDir()) {
-		log.Println("android.safeLoad", "file '"+path+"' does not stat or is a directory", err)
-		return nil, err
-	}
-	fileBytes, err := os.ReadFile(path)
-	if err != nil {
-		log.Println("android.safeLoad", "file '"+path+"' failed to load", err)
+	if stat, err := os.Stat(path); err != nil {
 		return nil, err
+	} else if stat.IsDir() {
+		return nil, &os.PathError{Op: "read", Path: path, Err: errors.New("is a directory")}
 	}
-	return fileBytes, nil
+	return os.ReadFile(path)
 }
@@ -0,0 +1,82 @@
+package signv2
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+)
+
+// remoteSigner is a crypto.Signer whose private key is out of reach of the package, as that of a
+// token or a KMS is.
+type remoteSigner struct {
+	key   crypto.Signer
+	calls atomic.Int32
+	err   error
+}
+
+func (s *remoteSigner) Public() crypto.PublicKey { return s.key.Public() }
+
+func (s *remoteSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	s.calls.Add(1)
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.key.Sign(rand, digest, opts)
+}
+
+func TestSigner(t *testing.T) {
+	key := testSigningCerts(t)[0]
+	if err := key.Resolve(); err != nil {
+		t.Fatal(err)
+	}
+	remote := &remoteSigner{key: key.Key}
+	sc := &SigningCert{SigningKey: SigningKey{Signer: remote}, Certificate: key.Certificate}
+	if err := sc.Resolve(); err != nil {
+		t.Fatal(err)
+	}
+	if sc.Type != RSA || sc.Key != nil {
+		t.Errorf("resolved to type %q, key in memory %v", sc.Type, sc.Key != nil)
+	}
+
+	z, err := NewApkSign(testZip(t, "AndroidManifest.xml", "manifest", "classes.dex", "dex"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := z.SignV2([]*SigningCert{sc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := remote.calls.Load(); n != 1 {
+		t.Errorf("signer called %d times, want once", n)
+	}
+	if z, err = NewApkSign(signed); err != nil {
+		t.Fatal(err)
+	}
+	if r := z.VerifyAll(VerifyOptions{}); !r.Verified {
+		t.Fatalf("not verified: %v", r.Errors())
+	}
+
+	if _, err = z.SignV1([]*SigningCert{sc}); err != nil {
+		t.Errorf("SignV1: %v", err)
+	}
+
+	remote.err = errors.New("token removed")
+	if _, err = z.SignV2([]*SigningCert{sc}); !errors.Is(err, remote.err) {
+		t.Errorf("failing signer gave %v", err)
+	}
+
+	other := generateSigningCert(t, "other")
+	bad := &SigningCert{SigningKey: SigningKey{Signer: key.Key}, Certificate: other.Certificate}
+	if err = bad.Resolve(); err == nil {
+		t.Error("signer of another certificate resolved")
+	}
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	bad = &SigningCert{SigningKey: SigningKey{Signer: edKey, Type: RSA}, Certificate: key.Certificate}
+	if err = bad.Resolve(); err == nil {
+		t.Error("Ed25519 signer resolved as RSA")
+	}
+}
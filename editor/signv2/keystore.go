@@ -0,0 +1,131 @@
+package signv2
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// keystoreEntry is a private key entry of a keystore.
+type keystoreEntry struct {
+	alias string
+	key   []byte   // PKCS #8 PrivateKeyInfo
+	certs [][]byte // the certificate chain, leaf first
+}
+
+// LoadKeystore reads the key entry alias of a Java keystore (JKS) or PKCS #12 file, such as the
+// release.keystore of a Gradle build, and returns it as a resolved SigningCert using the leaf
+// certificate of the entry's chain. See ParseKeystore.
+func LoadKeystore(path, storePass, alias, keyPass string) (*SigningCert, error) {
+	data, err := safeLoad(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseKeystore(data, storePass, alias, keyPass)
+}
+
+// ParseKeystore is LoadKeystore for the keystore data. The format is detected from its content.
+// An empty alias selects the only key entry, which is an error if there are several; aliases match
+// case-insensitively, as keytool lowercases them. An empty keyPass means the key is protected by
+// storePass, which is what keytool does unless told otherwise. A wrong password gives an error
+// wrapping ErrKeystorePassword.
+//
+// JKS keys are protected with the proprietary scheme of the Sun provider; JCEKS keystores are not
+// supported. PKCS #12 files may use PBES2 with PBKDF2 and AES, as keytool does from Java 12 and
+// OpenSSL 3 do, or the legacy PBE with SHA-1 and 3DES or RC2.
+func ParseKeystore(data []byte, storePass, alias, keyPass string) (_ *SigningCert, err error) {
+	defer catch(&err, "ParseKeystore", "keystore")
+	if keyPass == "" {
+		keyPass = storePass
+	}
+	var entries []*keystoreEntry
+	switch {
+	case len(data) >= 4 && binary.BigEndian.Uint32(data) == jksMagic:
+		entries, err = parseJKS(data, storePass, keyPass)
+	case len(data) >= 4 && binary.BigEndian.Uint32(data) == jceksMagic:
+		return nil, fmt.Errorf("%w: JCEKS keystores are not supported", ErrKeystore)
+	case len(data) > 0 && data[0] == 0x30:
+		entries, err = parsePKCS12(data, storePass, keyPass)
+	default:
+		return nil, fmt.Errorf("%w: neither JKS nor PKCS #12", ErrKeystore)
+	}
+	if err != nil {
+		return nil, err
+	}
+	e, err := findEntry(entries, alias)
+	if err != nil {
+		return nil, err
+	}
+	return keystoreCert(e)
+}
+
+// findEntry returns the entry alias, or the only entry if alias is empty.
+func findEntry(entries []*keystoreEntry, alias string) (*keystoreEntry, error) {
+	if alias == "" {
+		switch len(entries) {
+		case 0:
+			return nil, fmt.Errorf("%w: no private key entry", ErrKeystore)
+		case 1:
+			return entries[0], nil
+		}
+		names := make([]string, len(entries))
+		for i, e := range entries {
+			names[i] = e.alias
+		}
+		return nil, fmt.Errorf("%w: %d private key entries, give one of the aliases %s", ErrKeystore, len(entries), strings.Join(names, ", "))
+	}
+	for _, e := range entries {
+		if strings.EqualFold(e.alias, alias) {
+			return e, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: no private key entry %q", ErrKeystore, alias)
+}
+
+// keystoreCert returns the resolved SigningCert of e.
+func keystoreCert(e *keystoreEntry) (*SigningCert, error) {
+	if len(e.certs) == 0 {
+		return nil, fmt.Errorf("%w: entry %q has no certificate", ErrKeystore, e.alias)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(e.key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: entry %q: %v", ErrKeystore, e.alias, err)
+	}
+	sc := &SigningCert{
+		SigningKey: SigningKey{KeyBytes: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: e.key})},
+		CertBytes:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: e.certs[0]}),
+	}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		// PKCS #1, which Resolve tries first
+		sc.KeyBytes = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)})
+		sc.Type, sc.Hash = RSA, SHA256
+	case *ecdsa.PrivateKey:
+		sc.Type, sc.Hash = EC, SHA256
+	case ed25519.PrivateKey:
+		sc.Type, sc.Hash = Ed25519, SHA512
+	default:
+		return nil, fmt.Errorf("%w: entry %q has a %T key", ErrUnknownAlgorithm, e.alias, key)
+	}
+	if err = sc.Resolve(); err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+// bmpPassword returns password as the big-endian UTF-16 code units Java keystores use.
+func bmpPassword(password string) []byte {
+	var b bytes.Buffer
+	for _, c := range utf16.Encode([]rune(password)) {
+		b.WriteByte(byte(c >> 8))
+		b.WriteByte(byte(c))
+	}
+	return b.Bytes()
+}
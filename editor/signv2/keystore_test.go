@@ -0,0 +1,211 @@
+package signv2
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// PKCS #12 files made by OpenSSL 3 with the password "secret" for a 1024 bit RSA key with the alias
+// "release" and the subject CN=keystore test:
+//
+//	openssl pkcs12 -export [-legacy] -inkey key.pem -in cert.pem -name release -passout pass:secret
+var (
+	// PBES2 with PBKDF2 and AES-256-CBC, and a SHA-256 MAC
+	testPKCS12 = mustBase64(
+		"MIIGzgIBAzCCBoQGCSqGSIb3DQEHAaCCBnUEggZxMIIGbTCCAxIGCSqGSIb3DQEHBqCCAwMwggL/AgEAMIIC+AYJKoZIhvcN" +
+			"AQcBMFcGCSqGSIb3DQEFDTBKMCkGCSqGSIb3DQEFDDAcBAhxsXvHrTmGwQICCAAwDAYIKoZIhvcNAgkFADAdBglghkgBZQME" +
+			"ASoEEHlnRmfHIe1WjeyfrVRjW9SAggKQ92q2kk3IVzpRHY7Zyf8Z3ZcsIyYq2hKLC/lUaegrf6yqXhhv08mqWK1TK04I2jW8" +
+			"fp1IlbK/U3UlexmTdgaKRW7v++wchjGZ9He7c8AJ3zXDZkBOBrMWUvItB7veuru7z1DX3KOQtJ7JQRUS29BgyeMhdIs/3NU5" +
+			"ou+IAHgNN7Vnn3276/9ksB6w27XF3iIx1YOfrh7USs0mSHmKTXmQXrjUVs7idhLitsUKQNiuAdjNbN4iOdju1nE2JWdk64fI" +
+			"m5kpvvBwAC/L8LAz/BnBDeIhbOGqISx8bs48tb+YeyAXQgFOM/7x4ikKnJBDA/bjD7LWuUOeVauU42TgNqKjTd6F6mN3fxNr" +
+			"b37uCVxBWVv3Ek4YlY15cON+btbKgGEpDHmupitcA4vF8TdKzjMLUom+bYfMoXU8XK2w4p8ykyeSZfeuBYvXvSZ3DH/WTJ3o" +
+			"DJrKKHe5mCYcMXtGPMXbeBYt2ACv53t/U+8DPJ89MUL0wA5ius9HMt3EVZ2/dZIiV5nR/kOZOZhUKFWrg9950RcRCMqntwHQ" +
+			"9wIY6KxF55Iet/1bGtQkjDi934m36ZG+ITPSxHCBj2qEYdEWsi8tHgc284eQWBHEfQ2ZoPdpLeLqpq9NeN87FKIRTIpvh13h" +
+			"ZyAAr1RQlGE75XpgshUir6QJWdqzbw724autAw3wyue+xPuIqtvkaqy8FtLvQprzNkQToEBDKhWpAN1tdHobWrvY6SJi+V/G" +
+			"haMp0L0lao5swrTG4EfUxypo/5kXf712SvbM370VTOyDhbvilBqvg1hkRGhMDvPD71seaYYCOLfe+OAAyP21eMimz/cq0kUV" +
+			"+gabALJMohWTBVxUAPefp0l9cril7wHgOwWj4B6lvqcwggNTBgkqhkiG9w0BBwGgggNEBIIDQDCCAzwwggM4BgsqhkiG9w0B" +
+			"DAoBAqCCAuEwggLdMFcGCSqGSIb3DQEFDTBKMCkGCSqGSIb3DQEFDDAcBAjc0RN79xsIJwICCAAwDAYIKoZIhvcNAgkFADAd" +
+			"BglghkgBZQMEASoEEJI/vD/K0Qj0l17csQ3Im/sEggKADhfb2JbaM5gAV/p3w85dJrdmEc0yKtyZRqztBdCzpllMRfeO0pep" +
+			"ItN3bZWSq/3wLm3WOjD09lLjAj4zm3B+MIyi7ewSuE5gAle9Tuprc8LRyrzZ4bmwk5jztjtzViL5SO0i1NTX6Jq2TjqRELy0" +
+			"aS+iEYkVtGjGdTwBMc/0AW83ifKgmzPw9qZXX9ugF4qotIH0YyGZ5pwyGsZxaulTM0GYyt3kSaI9C1rDjGC/ENQIHhCpMSbt" +
+			"3t2kzP3cZT/o7oSEj4FUUfkCNEkuAULYsnrroXIkjTIc8h7pFIp+sDPQ+sM5Fo4zq5T5e0+zlStVpuNA+ICm+rGLmF7e0qa9" +
+			"C4uhN7dZOei1oPoCBRCt2uyWC4sm1x024JQvoQP8iTknvnH7N4rmkXl8eGeweXv3pbOLlC9Attb59xmJ/hRuUZ3QyZ9UJL1T" +
+			"QG8iUp5ik1lRm1vBLtRQot8Bes+87xMbIQfbsvAAh3AUg/toX20ii6ktnMC7Y4UnM23xw3jBdH4+QKxL55LS6q4Vkgb0pFH+" +
+			"qgSH0vcZ1cZKYPLiRCt2uz2aUvZ0752AEhijLEpPoW1o25cAEUKKxwUadlLcL0Or3qeYMKK1nm30Qa7+iQKOcRBrLXGgEbbK" +
+			"QJbCKRgsKyrQswhEOtTBJPPurO6VG05diHiMnSjpR5NbB0mIWWBxhOtCiAGK89NaSw0KWHG/ydMWbLZHQXO/URcnKrBcwe1k" +
+			"zwEoQYgFQZsqtPJVDwP1U3e7ibkbRkpjm52VwcF2V0FIy4Ex375wOnotluTublz0Nmn5SvYHB2KE6MhAaCG61ACcAkErUPg3" +
+			"18fjx8BeaQnjjmKf+oM4dACJ8NmhtMmwpzFEMB0GCSqGSIb3DQEJFDEQHg4AcgBlAGwAZQBhAHMAZTAjBgkqhkiG9w0BCRUx" +
+			"FgQUaksSaDdC1KVJ/qzwlTTIei27H4UwQTAxMA0GCWCGSAFlAwQCAQUABCBgGoqMUw5P1WxMHPQyM6L1EKWnJGWugUrZbx7v" +
+			"JN2FrAQIZI5MwMFrnQwCAggA")
+	// the certificates encrypted with 40 bit RC2, the key with 3DES, and a SHA-1 MAC
+	testPKCS12Legacy = mustBase64(
+		"MIIGSAIBAzCCBg4GCSqGSIb3DQEHAaCCBf8EggX7MIIF9zCCAtcGCSqGSIb3DQEHBqCCAsgwggLEAgEAMIICvQYJKoZIhvcN" +
+			"AQcBMBwGCiqGSIb3DQEMAQYwDgQIR+At7ihSt30CAggAgIICkMh1GD9qH93GYksdtU05leM7dnSMS4wc1+46dGgXcbqfHGtU" +
+			"CtpX0yPVBl9krEqtbDZxlryME0OlvO7muWznOfTlRqVlyWQSMdhxDYNsapUe/UQjVuiLN4JORGNQY9DZIDg1xMSyrgCC/vLe" +
+			"ZVU6ojFp/iFvcCQLnXdIcRfmwWUbIVKhiRXlIvLpguIKtJ0UUnHvEqaEfgrK21nlXusz2R4R/R0aeSs94waCjJWCAhNIpNLb" +
+			"erBdATBpLxGwrCHA1KP97RkcEMdzooiew2xlMqlP06vEdA9fmoI7uMSNr3T1dFWjltDgCRxyqdy0YbvlJkcefx6mv8IuPg0+" +
+			"lgcaG/RkwxjnlgMPRLnxKgawn3kex4cqhRRqhPCkbKtCNsrhaCTsiWtidl6ayHryTz2RRU+vcywp4e2rR2zD656T5hBzZn3x" +
+			"E9/4agpne6lufCHdmC8OFg97FE4M/AKvjn6c7c27UhSQFBVpfIURVzvdXNde2UL1XvZZdvgKAjdumXOwbCsvC7CxQyD0ewTu" +
+			"bQwHzpZZm+CKt8EzqMFdIQdMPj+GbfISW4qlnsX2cuHu9V9tFts56zybAtc1UeqOlGVH5hNU6gTUSEhXC+9upa2ybgZ/Kxwc" +
+			"/Fxn5neuSiPeMzlHw4lU+SQysmkCrqsFeSarf7V9dVPRfngEvJoq3iIMOFIILSLwJuFkDX07bSRvx4tghmhzbHT0WYYF/YWj" +
+			"K/mJOZjdon02Qq8KKSWQ4MxlV+3DxEOszckBQMa/rpVRnNxsCeZC+eloAJdE1HvWodSgxVq0kJ+7y3LPAJvGMG9GT5m7vy1Z" +
+			"rJ+tjUY7K/7ktcUmhM4wPoCJ7Sw2VAb4cHtYk7N+uWTcRoI2nmLe6D3fDEilMIIDGAYJKoZIhvcNAQcBoIIDCQSCAwUwggMB" +
+			"MIIC/QYLKoZIhvcNAQwKAQKgggKmMIICojAcBgoqhkiG9w0BDAEDMA4ECCZbgLebsRjPAgIIAASCAoDtEMG6dFcJbYOFA4Ck" +
+			"MfpZbcW+OZPyHVSEZGrfJ18LhKTo9+BF7cMgALGe6s5xL7ztGGZmZVj3bzXWBL+foQwZKG287NLAFqwocIdhE7Vqn1hY15Vt" +
+			"sLPl2Ve91cu4IMnU/7jl3jV+eEMdPsaVPmjKVpkwt+0LRkfs+m6sbOTrXuKDsoUNOApSx8Z+DWjxoyamLEAGkwg+EpFfHvWB" +
+			"GVTMEeH/jgCe9zQdaoXI0ct40UnXzrCRDPuHNc3zTM65QA3LPUqvSDF9p5czMeZ7wbKiIuBC2nzrrpshpPorYDBKOrwECs6g" +
+			"JsdWS+mcY1HegT+8OOPCqnMTSOlxtQzEc+T98dFHowaTUrvhEANOi2JeskX6sRZZNADiV+HwMHAgzNfoRbXCW3WGaqkkOLe3" +
+			"T6kBqNvQhTNj9XYgFCywYPjREDLXwHiUnp/b+vXbggjNLHdUloxCR/3vmps7VWA/SE5CcT8vKEZZKqyHXx/Ar6MClzJxbB2B" +
+			"uIRRaWIAAfyCSNMF4S39YXEuucxSEdr0E4hXFHfpdyciQaKz24VAWO2tNQHIp/V5rJU1dZ3vP45ceWm0nXwvP+KxZd1rmOpy" +
+			"sDgiP83Ufe5y7SuN7QQ4mTU03miyY4ivW9UtJKjTyzd59XhtyTFfC/gTA26xY8GWUCPZD7k22ob9IbRe95sNfYDWhSyLv8e2" +
+			"Z1FzwoQ2hykXpAlBAIzoUPKfPyuzktSPq/5TxuIgluiKxXidgN/ZWF+uIzThtD9qw0BwJzQE/SsDx9pZpNilMSDiFV7y4qkB" +
+			"/VH/A22hqfj5+fUKrntDNkvvzok5joSZBPCIKUqtMnCOHkaQ1xRjY/XmWNDqcq4Ppl8IMUQwHQYJKoZIhvcNAQkUMRAeDgBy" +
+			"AGUAbABlAGEAcwBlMCMGCSqGSIb3DQEJFTEWBBRqSxJoN0LUpUn+rPCVNMh6LbsfhTAxMCEwCQYFKw4DAhoFAAQUIuyLmluK" +
+			"0+fWEb1Y7dSLTGd46FUECBbVmdzhZuEAAgIIAA==")
+)
+
+func mustBase64(s string) []byte {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// testJKS builds a JKS version 2 file holding sc under each of aliases, as keytool writes it.
+func testJKS(t *testing.T, sc *SigningCert, storePass, keyPass string, aliases ...string) []byte {
+	key, err := x509.MarshalPKCS8PrivateKey(sc.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// KeyProtector: salt, the key XORed with the keystream, the check digest
+	pw := bmpPassword(keyPass)
+	salt := make([]byte, sha1.Size)
+	rand.Read(salt)
+	protected := append([]byte(nil), salt...)
+	digest := salt
+	for i := range key {
+		if i%sha1.Size == 0 {
+			sum := sha1.Sum(append(bytes.Clone(pw), digest...))
+			digest = sum[:]
+		}
+		protected = append(protected, key[i]^digest[i%sha1.Size])
+	}
+	check := sha1.Sum(append(bytes.Clone(pw), key...))
+	protected = append(protected, check[:]...)
+	der, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidJKSKeyProtector, Parameters: asn1.NullRawValue},
+		EncryptedData: protected,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	u16 := func(v int) { binary.Write(&b, binary.BigEndian, uint16(v)) }
+	u32 := func(v int) { binary.Write(&b, binary.BigEndian, uint32(v)) }
+	utf := func(s string) { u16(len(s)); b.WriteString(s) }
+	u32(jksMagic)
+	u32(2)
+	u32(len(aliases))
+	for _, alias := range aliases {
+		u32(jksPrivateKey)
+		utf(alias)
+		b.Write(make([]byte, 8))
+		u32(len(der))
+		b.Write(der)
+		u32(1)
+		utf("X.509")
+		u32(len(sc.Certificate.Raw))
+		b.Write(sc.Certificate.Raw)
+	}
+	h := sha1.New()
+	h.Write(bmpPassword(storePass))
+	h.Write([]byte("Mighty Aphrodite"))
+	h.Write(b.Bytes())
+	return h.Sum(b.Bytes())
+}
+
+func TestParseKeystore(t *testing.T) {
+	for name, data := range map[string][]byte{"modern": testPKCS12, "legacy": testPKCS12Legacy} {
+		for _, alias := range []string{"release", "RELEASE", ""} {
+			sc, err := ParseKeystore(data, "secret", alias, "")
+			if err != nil {
+				t.Fatalf("%s %q: %v", name, alias, err)
+			}
+			if cn := sc.Certificate.Subject.CommonName; cn != "keystore test" || sc.Key == nil || sc.Type != RSA {
+				t.Errorf("%s %q: CN %q, key %v", name, alias, cn, sc.Key != nil)
+			}
+		}
+		if _, err := ParseKeystore(data, "wrong", "", ""); !errors.Is(err, ErrKeystorePassword) {
+			t.Errorf("%s: wrong password gave %v", name, err)
+		}
+		if _, err := ParseKeystore(data, "secret", "debug", ""); !errors.Is(err, ErrKeystore) {
+			t.Errorf("%s: missing alias gave %v", name, err)
+		}
+	}
+
+	sc := generateSigningCert(t, "jks test")
+	jks := testJKS(t, sc, "storepass", "keypass", "release", "upload")
+	got, err := ParseKeystore(jks, "storepass", "upload", "keypass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Certificate.Equal(sc.Certificate) || !got.Key.Equal(sc.Key) {
+		t.Error("JKS entry differs from the key pair written")
+	}
+	if _, err = ParseKeystore(jks, "storepass", "", "keypass"); !errors.Is(err, ErrKeystore) {
+		t.Errorf("ambiguous alias gave %v", err)
+	}
+	if _, err = ParseKeystore(jks, "storepass", "upload", ""); !errors.Is(err, ErrKeystorePassword) {
+		t.Errorf("wrong key password gave %v", err)
+	}
+	if _, err = ParseKeystore(jks, "keypass", "upload", "keypass"); !errors.Is(err, ErrKeystorePassword) {
+		t.Errorf("wrong store password gave %v", err)
+	}
+	if _, err = ParseKeystore(jks[:len(jks)/2], "storepass", "upload", "keypass"); err == nil {
+		t.Error("truncated JKS parsed")
+	}
+	if _, err = ParseKeystore([]byte("PK\x03\x04"), "", "", ""); !errors.Is(err, ErrKeystore) {
+		t.Errorf("zip parsed as a keystore: %v", err)
+	}
+}
+
+func TestLoadKeystoreSigns(t *testing.T) {
+	key := testSigningCerts(t)[0]
+	if err := key.Resolve(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "release.keystore")
+	if err := os.WriteFile(path, testJKS(t, key, "android", "android", "androiddebugkey"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	sc, err := LoadKeystore(path, "android", "androiddebugkey", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := NewApkSign(testZip(t, "AndroidManifest.xml", "manifest", "classes.dex", "dex"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := z.SignV2([]*SigningCert{sc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if z, err = NewApkSign(signed); err != nil {
+		t.Fatal(err)
+	}
+	if r := z.VerifyAll(VerifyOptions{}); !r.Verified {
+		t.Fatalf("not verified: %v", r.Errors())
+	}
+}
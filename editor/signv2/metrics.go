@@ -0,0 +1,76 @@
+package signv2
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// Operation names passed to MetricsRecorder.
+const (
+	OpSign   = "sign"
+	OpVerify = "verify"
+	// OpEdit is recorded by the editor package, whose edits also record the OpSign they end with.
+	OpEdit = "edit"
+)
+
+// MetricsRecorder receives one call per completed operation, so that a service embedding the package
+// can export metrics, e.g. to Prometheus, without wrapping every call. It may be called from many
+// goroutines at once and should return quickly.
+//
+// Signing is recorded by SignV2, SignV2To, their Context variants and SignStream; verification by
+// VerifyAll, VerifyAllContext and VerifyFile. bytes is the size of the input file, and err is nil on
+// success; for verification it is VerificationResult.Err.
+type MetricsRecorder interface {
+	RecordOperation(op string, bytes int64, d time.Duration, err error)
+}
+
+// defaultMetrics holds a *MetricsRecorder set by SetMetricsRecorder.
+var defaultMetrics atomic.Pointer[MetricsRecorder]
+
+// SetMetricsRecorder sets the recorder used when no WithMetrics option is given; nil disables it.
+func SetMetricsRecorder(r MetricsRecorder) {
+	if r == nil {
+		defaultMetrics.Store(nil)
+		return
+	}
+	defaultMetrics.Store(&r)
+}
+
+// DefaultMetricsRecorder returns the recorder set by SetMetricsRecorder, or nil.
+func DefaultMetricsRecorder() MetricsRecorder {
+	if r := defaultMetrics.Load(); r != nil {
+		return *r
+	}
+	return nil
+}
+
+// WithMetrics records the operations of the ApkSign, or of a single call, with r instead of the
+// recorder set by SetMetricsRecorder.
+func WithMetrics(r MetricsRecorder) Option {
+	return func(o *options) { o.metrics = r }
+}
+
+// record reports an operation started at start to the recorder in effect. It is meant to be
+// deferred with a pointer to the named error result.
+func (o options) record(op string, bytes int64, start time.Time, err *error) {
+	r := o.metrics
+	if r == nil {
+		r = DefaultMetricsRecorder()
+	}
+	if r != nil {
+		r.RecordOperation(op, bytes, time.Since(start), *err)
+	}
+}
+
+// countingReader counts the bytes read through it, for streams whose size is not known up front.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
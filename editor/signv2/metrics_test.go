@@ -0,0 +1,92 @@
+package signv2
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+)
+
+// metricsLog records the operations reported to it.
+type metricsLog struct {
+	mu  sync.Mutex
+	ops []metricsEntry
+}
+
+type metricsEntry struct {
+	op    string
+	bytes int64
+	err   error
+}
+
+func (l *metricsLog) RecordOperation(op string, bytes int64, d time.Duration, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.ops = append(l.ops, metricsEntry{op, bytes, err})
+}
+
+func (l *metricsLog) take() []metricsEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ops := l.ops
+	l.ops = nil
+	return ops
+}
+
+func TestMetrics(t *testing.T) {
+	global := new(metricsLog)
+	SetMetricsRecorder(global)
+	defer SetMetricsRecorder(nil)
+
+	unsigned := testZip(t, "AndroidManifest.xml", "manifest", "classes.dex", "dex")
+	z, err := NewApkSign(unsigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := z.SignV2(testSigningCerts(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := global.take(); len(got) != 1 || got[0] != (metricsEntry{OpSign, int64(len(unsigned)), nil}) {
+		t.Errorf("SignV2 recorded %+v", got)
+	}
+
+	// verification failures are recorded as errors
+	z.VerifyAll(VerifyOptions{})
+	if got := global.take(); len(got) != 1 || got[0].op != OpVerify || got[0].err == nil {
+		t.Errorf("VerifyAll of an unsigned file recorded %+v", got)
+	}
+	s, err := NewApkSign(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.VerifyAll(VerifyOptions{})
+	if got := global.take(); len(got) != 1 || got[0] != (metricsEntry{OpVerify, int64(len(signed)), nil}) {
+		t.Errorf("VerifyAll recorded %+v", got)
+	}
+
+	// an option takes precedence over the global recorder, for the ApkSign or for one call
+	own := new(metricsLog)
+	if err = z.SignV2To(new(bytes.Buffer), testSigningCerts(t), WithMetrics(own)); err != nil {
+		t.Fatal(err)
+	}
+	o, err := NewApkSign(unsigned, WithMetrics(own))
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.VerifyAll(VerifyOptions{})
+	if got := own.take(); len(got) != 2 || got[0].op != OpSign || got[1].op != OpVerify {
+		t.Errorf("WithMetrics recorded %+v", got)
+	}
+	if got := global.take(); len(got) != 0 {
+		t.Errorf("global recorder also got %+v", got)
+	}
+
+	err = SignStream(bytes.NewReader(unsigned), new(bytes.Buffer), StreamConfig{Keys: testSigningCerts(t), Metrics: own})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := own.take(); len(got) != 1 || got[0] != (metricsEntry{OpSign, int64(len(unsigned)), nil}) {
+		t.Errorf("SignStream recorded %+v", got)
+	}
+}
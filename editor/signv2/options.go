@@ -0,0 +1,192 @@
+package signv2
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+)
+
+// Option configures NewApkSign, NewApkSignNoCopy and OpenApkSignFile, and the SignV2 methods. Options
+// given to a SignV2 method apply to that call only, on top of those the ApkSign was created with.
+type Option func(*options)
+
+type options struct {
+	logger        *log.Logger
+	slog          *slog.Logger
+	noCopy        bool
+	strictZip     bool
+	lenientZip    bool
+	deterministic bool
+	minSdk        int
+	digestCache   *DigestCache
+	metrics       MetricsRecorder
+	processors    []BlockProcessor
+	genericZip    bool
+	window        int
+	noAlign       bool
+	concurrency   int
+	stamp         *SigningCert
+	v1SignedWith  []Scheme
+	oldestSigner  []*SigningCert
+}
+
+func newOptions(opts []Option) options {
+	o := options{logger: log.Default()}
+	return o.with(opts)
+}
+
+// with returns a copy of o with opts applied.
+func (o options) with(opts []Option) options {
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// logf reports something the caller may want to know about, such as a quirk WithLenientZip worked
+// around: at LevelWarn to the slog.Logger of WithSlog if there is one, else to the log.Logger.
+func (o options) logf(format string, args ...any) {
+	switch {
+	case o.slog != nil:
+		o.slog.Warn(fmt.Sprintf(format, args...))
+	case o.logger != nil:
+		o.logger.Printf(format, args...)
+	}
+}
+
+// debug reports details such as the offsets found while parsing, with slog key-value args. Only a
+// slog.Logger gets them, at LevelDebug.
+func (o options) debug(msg string, args ...any) {
+	if o.slog != nil {
+		o.slog.Debug(msg, args...)
+	}
+}
+
+// WithLogger sends diagnostic messages to l instead of the standard logger; nil discards them.
+func WithLogger(l *log.Logger) Option {
+	return func(o *options) { o.logger = l }
+}
+
+// WithSlog sends diagnostic messages to l instead: warnings at LevelWarn, and details such as the
+// layout of each parsed file at LevelDebug. It takes precedence over WithLogger; nil reverts to it.
+func WithSlog(l *slog.Logger) Option {
+	return func(o *options) { o.slog = l }
+}
+
+// WithNoCopy makes NewApkSign use its input as is, like NewApkSignNoCopy.
+func WithNoCopy() Option {
+	return func(o *options) { o.noCopy = true }
+}
+
+// WithStrictZip makes parsing reject archives the Android platform refuses but this package would
+// otherwise accept: duplicate entry names, and entries whose data does not lie within the files
+// section, e.g. overlapping the signing block.
+func WithStrictZip() Option {
+	return func(o *options) { o.strictZip, o.lenientZip = true, false }
+}
+
+// WithLenientZip makes parsing accept archives that break the "Central Directory immediately
+// followed by EOCD" rule of the v2 spec because a vendor tool added data between the two, or after
+// the EOCD. Such data is dropped, so that signing produces a file the platform accepts, and each
+// quirk is listed in ParseResult.Quirks. Without this option, or WithStrictZip, which overrides it,
+// parsing fails with ErrCDNotAdjacent or ErrNotZip.
+func WithLenientZip() Option {
+	return func(o *options) { o.strictZip, o.lenientZip = false, true }
+}
+
+// WithDeterministic orders the signers of a new signature as their keys are given, rather than in
+// map iteration order, so signing the same input with the same keys always gives the same output.
+func WithDeterministic() Option {
+	return func(o *options) { o.deterministic = true }
+}
+
+// WithMinSdk sets the lowest Android API level the signed APK must install on. Below 24, which
+// does not know APK Signature Scheme v2, signing fails with ErrV1Required unless the file also has
+// a v1 (JAR) signature.
+func WithMinSdk(level int) Option {
+	return func(o *options) { o.minSdk = level }
+}
+
+// WithV1SignedWith makes SignV1 list schemes, SchemeV2 and SchemeV3, in the X-Android-APK-Signed
+// header of the .SF files, as apksigner does when dual-signing: Android 7.0 and later then reject
+// the file if the signatures of those schemes, to be added to the result, are stripped from it.
+func WithV1SignedWith(schemes ...Scheme) Option {
+	return func(o *options) { o.v1SignedWith = schemes }
+}
+
+// WithOldestSigner gives SignV3 the keys of the first certificate of a lineage with a rotation. As
+// apksigner does, they sign the v2 block, which is all devices before Android 9 see, so the update
+// still installs over an app signed with the original key; sign v1 with them too when dual-signing.
+func WithOldestSigner(keys ...*SigningCert) Option {
+	return func(o *options) { o.oldestSigner = keys }
+}
+
+// WithGenericZip treats the input as an arbitrary zip archive, such as a firmware image or a
+// document bundle, rather than an APK: entries are not inspected for classes.dex and
+// AndroidManifest.xml, so ParseResult never reports KindAPK and WithMinSdk is ignored, and
+// options the Android platform rejects are allowed: Ed25519 keys, and entries compressed with
+// methods other than store and deflate, e.g. zstd. Files signed this way do not install on Android;
+// verify them with VerifyGenericZip, or by opening them with this option.
+func WithGenericZip() Option {
+	return func(o *options) { o.genericZip = true }
+}
+
+// WithWindow sets the bytes of the file NewApkSignFromReaderAt holds in memory, rounded up to a whole
+// number of 1MB digest chunks. Other constructors ignore it.
+func WithWindow(n int) Option {
+	return func(o *options) { o.window = n }
+}
+
+// WithoutAlign makes SignV2 sign the file as it is. By default it first realigns the file as Align
+// does with PageSize16K if a stored entry is misaligned, since the signature covers the layout and
+// aligning afterwards would break it.
+func WithoutAlign() Option {
+	return func(o *options) { o.noAlign = true }
+}
+
+// WithConcurrency hashes at most n 1MB chunks of the content digest at a time, for signing and
+// verification alike; n <= 0 means runtime.GOMAXPROCS(0), the default, and 1 hashes the chunks one
+// after another, leaving the other cores to concurrent signing operations.
+func WithConcurrency(n int) Option {
+	return func(o *options) { o.concurrency = n }
+}
+
+// WithDigestCache looks up and stores content digests in c, see DigestCache.
+func WithDigestCache(c *DigestCache) Option {
+	return func(o *options) { o.digestCache = c }
+}
+
+// checkStrictZip implements WithStrictZip.
+func (apkSign *ApkSign) checkStrictZip() error {
+	filesEnd := apkSign.cdOffset
+	if apkSign.asv2Offset > 0 {
+		filesEnd = apkSign.asv2Offset
+	}
+	r, err := apkSign.zipReader()
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool, len(r.File))
+	for _, f := range r.File {
+		if seen[f.Name] {
+			return fmt.Errorf("%w: duplicate entry %s", ErrNotZip, f.Name)
+		}
+		seen[f.Name] = true
+		off, err := f.DataOffset()
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+		if off < 0 || uint64(off)+f.CompressedSize64 > filesEnd {
+			return fmt.Errorf("%w: entry %s is not within the files section", ErrNotZip, f.Name)
+		}
+	}
+	return nil
+}
+
+// checkMinSdk implements WithMinSdk.
+func (apkSign *ApkSign) checkMinSdk(o options) error {
+	if !o.genericZip && o.minSdk > 0 && o.minSdk < 24 && !apkSign.IsV1Signed {
+		return fmt.Errorf("%w: min sdk %d", ErrV1Required, o.minSdk)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package signv2
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestOptions(t *testing.T) {
+	unsigned := testZip(t, "AndroidManifest.xml", "manifest", "classes.dex", "dex")
+
+	z, err := NewApkSign(unsigned, WithNoCopy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if &z.RawBytes()[0] != &unsigned[0] {
+		t.Error("WithNoCopy: input was copied")
+	}
+
+	// parsing a conforming file logs nothing; a quirk WithLenientZip works around is a warning
+	logs := new(bytes.Buffer)
+	signed := testSignedApk(t)
+	if _, err = NewApkSign(signed, WithLogger(log.New(logs, "", 0))); err != nil || logs.Len() != 0 {
+		t.Errorf("WithLogger: got %q, %v", logs, err)
+	}
+	trailing := append(bytes.Clone(signed), "junk"...)
+	if _, err = NewApkSign(trailing, WithLenientZip(), WithLogger(log.New(logs, "", 0))); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(logs.String(), "ApkSign.New lenient") {
+		t.Errorf("WithLogger: got %q", logs)
+	}
+
+	dup := testZip(t, "AndroidManifest.xml", "manifest", "AndroidManifest.xml", "other")
+	if _, err = NewApkSign(dup); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewApkSign(dup, WithStrictZip()); !errors.Is(err, ErrNotZip) {
+		t.Errorf("WithStrictZip: got %v, want ErrNotZip", err)
+	}
+	if _, err = NewApkSign(testSignedApk(t), WithStrictZip()); err != nil {
+		t.Errorf("WithStrictZip on a signed apk: %v", err)
+	}
+
+	if _, err = z.SignV2(testSigningCerts(t), WithMinSdk(21)); !errors.Is(err, ErrV1Required) {
+		t.Errorf("WithMinSdk(21): got %v, want ErrV1Required", err)
+	}
+	if _, err = z.SignV2(testSigningCerts(t), WithMinSdk(24)); err != nil {
+		t.Errorf("WithMinSdk(24): %v", err)
+	}
+}
+
+func TestWithSlog(t *testing.T) {
+	signed := testSignedApk(t)
+	logs, records := new(bytes.Buffer), new(bytes.Buffer)
+	l := slog.New(slog.NewTextHandler(records, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	z, err := NewApkSign(append(bytes.Clone(signed), "junk"...), WithLenientZip(), WithSlog(l), WithLogger(log.New(logs, "", 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logs.Len() != 0 {
+		t.Errorf("WithLogger got %q despite WithSlog", logs)
+	}
+	for _, want := range []string{
+		"level=WARN msg=\"ApkSign.New lenient",
+		fmt.Sprintf("level=DEBUG msg=\"parsed zip layout\" signingBlock=%d cd=%d eocd=%d", z.asv2Offset, z.cdOffset, z.eocdOffset),
+	} {
+		if !strings.Contains(records.String(), want) {
+			t.Errorf("missing %q in %q", want, records)
+		}
+	}
+}
+
+func TestWithDeterministic(t *testing.T) {
+	z, err := NewApkSign(testZip(t, "AndroidManifest.xml", "manifest"), WithDeterministic())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []*SigningCert{generateSigningCert(t, "first"), generateSigningCert(t, "second")}
+	want, err := z.SignV2(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 10 {
+		if got, err := z.SignV2(keys); err != nil || !bytes.Equal(got, want) {
+			t.Fatalf("output differs between runs (%v)", err)
+		}
+	}
+	signed, err := NewApkSign(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, err := signed.V2Block()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cn := v2.Signers[0].SignedData.Certs[0].Subject.CommonName; cn != "first" {
+		t.Errorf("first signer is %q, want the first key", cn)
+	}
+}
+
+func TestWithLenientZip(t *testing.T) {
+	signed := testSignedApk(t)
+	eocd := len(signed) - 22
+	junk := []byte("vendor data")
+	gap := append(append(bytes.Clone(signed[:eocd]), junk...), signed[eocd:]...)
+	trailing := append(bytes.Clone(signed), junk...)
+
+	for name, tc := range map[string]struct {
+		apk    []byte
+		strict error
+	}{
+		"gap":      {gap, ErrCDNotAdjacent},
+		"trailing": {trailing, ErrNotZip},
+	} {
+		if _, err := NewApkSign(tc.apk); !errors.Is(err, tc.strict) {
+			t.Errorf("%s: default mode got %v, want %v", name, err, tc.strict)
+		}
+		if _, err := NewApkSign(tc.apk, WithLenientZip(), WithStrictZip()); !errors.Is(err, tc.strict) {
+			t.Errorf("%s: WithStrictZip after WithLenientZip got %v, want %v", name, err, tc.strict)
+		}
+		z, err := NewApkSign(tc.apk, WithLenientZip(), WithLogger(nil))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if q := z.ParseResult().Quirks; len(q) != 1 || !strings.Contains(q[0], "11 bytes") {
+			t.Errorf("%s: quirks %q", name, q)
+		}
+		// the dropped data is not part of the archive, so the signature still verifies
+		if err = z.VerifyV2(); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if !bytes.Equal(z.RawBytes(), signed) {
+			t.Errorf("%s: normalized archive differs from the original", name)
+		}
+	}
+
+	z, err := NewApkSign(signed, WithLenientZip())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := z.ParseResult().Quirks; q != nil {
+		t.Errorf("conforming archive: quirks %q", q)
+	}
+}
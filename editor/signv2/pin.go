@@ -0,0 +1,209 @@
+package signv2
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// VerifyAgainstCert verifies the APK and checks that it is signed by the certificate whose SHA-256
+// digest, over its DER encoding as printed by apksigner --print-certs, is expectedSHA256. This is
+// the check an update server needs before distributing a build.
+//
+// The signers checked are those of the newest scheme present, v3 (with v3.1) or else v2, as that is
+// what the platform compares on update. Every signer must match. A v3 signer that rotated away from
+// the expected certificate, i.e. whose lineage contains it, matches as well.
+func (apkSign *ApkSign) VerifyAgainstCert(expectedSHA256 []byte) (err error) {
+	defer catch(&err, "VerifyAgainstCert", "file")
+	r := apkSign.VerifyAll(VerifyOptions{})
+	if !r.Verified {
+		if errs := r.Errors(); len(errs) > 0 {
+			return errs[0]
+		}
+		return ErrNotV2Signed
+	}
+
+	signers, err := apkSign.updateSigners()
+	if err != nil {
+		return err
+	}
+	for _, signer := range signers {
+		ok, err := signer.signedBy(expectedSHA256, true)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%w: signer certificate SHA-256 is %x", ErrCertMismatch, certSHA256(signer.SignedData.Certs[0]))
+		}
+	}
+	return nil
+}
+
+// updateSigners returns the signers the platform compares on update: those of the v3 and v3.1 blocks
+// if the file has a v3 block, otherwise those of the v2 block.
+func (apkSign *ApkSign) updateSigners() ([]*Signer, error) {
+	v3, v31, err := apkSign.V3Blocks()
+	if err != nil {
+		return nil, err
+	}
+	if v3 == nil {
+		v2, err := apkSign.V2Block()
+		if err != nil {
+			return nil, err
+		}
+		return v2.Signers, nil
+	}
+	signers := v3.Signers
+	if v31 != nil {
+		signers = append(slices.Clip(signers), v31.Signers...)
+	}
+	return signers, nil
+}
+
+// signedBy reports whether the signer's certificate has the given digest, or with rotated set,
+// whether one in its lineage has.
+func (signer *Signer) signedBy(digest []byte, rotated bool) (bool, error) {
+	if len(signer.SignedData.Certs) > 0 && bytes.Equal(certSHA256(signer.SignedData.Certs[0]), digest) {
+		return true, nil
+	}
+	if !rotated {
+		return false, nil
+	}
+	lineage, err := signer.Lineage()
+	if err != nil {
+		return false, err
+	}
+	for _, n := range lineage {
+		if bytes.Equal(certSHA256(n.Cert), digest) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// TrustPolicy is what VerifyWithPolicy requires of the signers of an APK beyond a valid signature,
+// so that a release pipeline can assert that a build is signed by its own key rather than by any
+// key. The zero value requires nothing more than VerifyAll.
+//
+// The rules apply to the signers VerifyAgainstCert checks, those of v3 and v3.1 or else v2.
+type TrustPolicy struct {
+	// VerifyOptions are passed to VerifyAll; Time is also the time RejectExpired checks against.
+	VerifyOptions
+	// TrustedSHA256 pins the certificates allowed to sign, by the hex SHA-256 fingerprint of their
+	// DER encoding as apksigner --print-certs prints it; colons and case are ignored. If set, every
+	// signer must use one of them.
+	TrustedSHA256 []string
+	// RejectRotated makes a pin match the current certificate of a signer only. By default, as with
+	// VerifyAgainstCert, a v3 signer whose lineage contains a pinned certificate matches too, so a
+	// key rotated away from the trusted one stays trusted.
+	RejectRotated bool
+	// MinRSABits, if set, is the minimum size of RSA signer keys, such as 2048. Keys of other types
+	// are not affected.
+	MinRSABits int
+	// Algorithms, if set, are the signature algorithms signers may use. Every signature of every
+	// signer must use one, as a verifier picks any of them.
+	Algorithms []AlgorithmID
+	// RejectExpired rejects signer certificates outside their validity period. Android ignores
+	// validity, so VerifyAll only warns about it.
+	RejectExpired bool
+}
+
+// TrustError is returned by VerifyWithPolicy for an APK that verifies but breaks the policy. It
+// matches ErrUntrusted.
+type TrustError struct {
+	// Violations describes each broken rule, one signer at a time.
+	Violations []string
+}
+
+func (e *TrustError) Error() string {
+	return ErrUntrusted.Error() + ": " + strings.Join(e.Violations, "; ")
+}
+
+func (e *TrustError) Is(target error) bool {
+	return target == ErrUntrusted
+}
+
+// VerifyWithPolicy verifies the APK as VerifyAll does and checks its signers against p, returning
+// the verification error if the APK does not verify, and otherwise a *TrustError listing every
+// violation of p, or nil.
+func (apkSign *ApkSign) VerifyWithPolicy(p TrustPolicy) (err error) {
+	defer catch(&err, "VerifyWithPolicy", "file")
+	var pins [][]byte
+	for _, fp := range p.TrustedSHA256 {
+		pin, err := hex.DecodeString(strings.ReplaceAll(fp, ":", ""))
+		if err != nil || len(pin) != sha256.Size {
+			return fmt.Errorf("bad SHA-256 fingerprint %q", fp)
+		}
+		pins = append(pins, pin)
+	}
+	if err = apkSign.VerifyAll(p.VerifyOptions).Err(); err != nil {
+		return err
+	}
+	signers, err := apkSign.updateSigners()
+	if err != nil {
+		return err
+	}
+	now := p.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	var violations []string
+	for i, signer := range signers {
+		if len(signer.SignedData.Certs) == 0 {
+			return errors.New("signer without certificate")
+		}
+		cert := signer.SignedData.Certs[0]
+		add := func(format string, args ...any) {
+			violations = append(violations, fmt.Sprintf("signer #%d: ", i+1)+fmt.Sprintf(format, args...))
+		}
+		if len(pins) > 0 {
+			trusted := false
+			for _, pin := range pins {
+				if trusted, err = signer.signedBy(pin, !p.RejectRotated); err != nil || trusted {
+					break
+				}
+			}
+			if err != nil {
+				return err
+			}
+			if !trusted {
+				add("certificate SHA-256 %x is not trusted", certSHA256(cert))
+			}
+		}
+		if k, ok := cert.PublicKey.(*rsa.PublicKey); ok && p.MinRSABits > 0 && k.N.BitLen() < p.MinRSABits {
+			add("RSA key of %d bits, want at least %d", k.N.BitLen(), p.MinRSABits)
+		}
+		if len(p.Algorithms) > 0 {
+			for _, sig := range signer.Signatures {
+				if !slices.Contains(p.Algorithms, AlgorithmID(sig.AlgorithmID)) {
+					add("signature algorithm %#04x is not allowed", sig.AlgorithmID)
+				}
+			}
+		}
+		if p.RejectExpired {
+			switch {
+			case now.After(cert.NotAfter):
+				add("certificate %q expired on %s", cert.Subject, cert.NotAfter.Format(time.DateOnly))
+			case now.Before(cert.NotBefore):
+				add("certificate %q is not valid before %s", cert.Subject, cert.NotBefore.Format(time.DateOnly))
+			}
+		}
+	}
+	if len(violations) > 0 {
+		return &TrustError{violations}
+	}
+	return nil
+}
+
+func certSHA256(c *x509.Certificate) []byte {
+	sum := sha256.Sum256(c.Raw)
+	return sum[:]
+}
@@ -0,0 +1,110 @@
+package signv2
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyAgainstCert(t *testing.T) {
+	z, err := NewApkSign(testSignedApk(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := testSigningCerts(t)[0]
+	if err = sc.Resolve(); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(sc.Certificate.Raw)
+	if err = z.VerifyAgainstCert(sum[:]); err != nil {
+		t.Errorf("pinned certificate: %v", err)
+	}
+	other := sha256.Sum256([]byte("other"))
+	if err = z.VerifyAgainstCert(other[:]); !errors.Is(err, ErrCertMismatch) {
+		t.Errorf("other certificate: got %v, want ErrCertMismatch", err)
+	}
+
+	unsigned, err := NewApkSign(testZip(t, "a.txt", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = unsigned.VerifyAgainstCert(sum[:]); err == nil {
+		t.Error("unsigned APK matched")
+	}
+}
+
+func TestVerifyWithPolicy(t *testing.T) {
+	z, err := NewApkSign(testSignedApk(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := testSigningCerts(t)[0]
+	if err = sc.Resolve(); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(sc.Certificate.Raw)
+	pin := strings.ToUpper(hex.EncodeToString(sum[:]))
+	if err = z.VerifyWithPolicy(TrustPolicy{}); err != nil {
+		t.Errorf("zero policy: %v", err)
+	}
+	v2, err := z.V2Block()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var algs []AlgorithmID
+	for _, s := range v2.Signers {
+		for _, sig := range s.Signatures {
+			algs = append(algs, AlgorithmID(sig.AlgorithmID))
+		}
+	}
+	pass := TrustPolicy{TrustedSHA256: []string{pin}, MinRSABits: 2048, Algorithms: algs, RejectExpired: true}
+	if err = z.VerifyWithPolicy(pass); err != nil {
+		t.Errorf("matching policy: %v", err)
+	}
+
+	other := sha256.Sum256([]byte("other"))
+	for name, p := range map[string]TrustPolicy{
+		"pin":        {TrustedSHA256: []string{hex.EncodeToString(other[:])}},
+		"key size":   {MinRSABits: 8192},
+		"algorithms": {Algorithms: []AlgorithmID{Ed25519WithSHA512}},
+		"expired":    {VerifyOptions: VerifyOptions{Time: time.Now().AddDate(100, 0, 0)}, RejectExpired: true},
+	} {
+		err = z.VerifyWithPolicy(p)
+		var te *TrustError
+		if !errors.Is(err, ErrUntrusted) || !errors.As(err, &te) || len(te.Violations) != 1 {
+			t.Errorf("%s: got %v, want one violation", name, err)
+		}
+	}
+	if err = z.VerifyWithPolicy(TrustPolicy{TrustedSHA256: []string{"zz"}}); err == nil || errors.Is(err, ErrUntrusted) {
+		t.Errorf("bad fingerprint: got %v", err)
+	}
+
+	// a v3 signer rotated away from the pinned key
+	newKey := generateSigningCert(t, "rotated")
+	lineage, err := NewLineage(sc, DefaultLineageFlags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lineage, err = lineage.Rotate(sc, newKey, 0); err != nil {
+		t.Fatal(err)
+	}
+	signed, err := z.SignV3([]*SigningCert{newKey}, lineage, WithOldestSigner(sc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rz, err := NewApkSign(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinned := TrustPolicy{TrustedSHA256: []string{pin}}
+	if err = rz.VerifyWithPolicy(pinned); err != nil {
+		t.Errorf("rotated: %v", err)
+	}
+	pinned.RejectRotated = true
+	if err = rz.VerifyWithPolicy(pinned); !errors.Is(err, ErrUntrusted) {
+		t.Errorf("rotated with RejectRotated: got %v, want ErrUntrusted", err)
+	}
+}
@@ -0,0 +1,540 @@
+package signv2
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"hash"
+	"unicode/utf16"
+)
+
+var (
+	oidEncryptedData      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+	oidKeyBag             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 1}
+	oidShroudedKeyBag     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertBag            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidX509Certificate    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidFriendlyName       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidPBEWithSHA3DES     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidPBEWithSHARC2      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 5}
+	oidPBEWithSHARC2_40   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 6}
+	oidPBES2              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1       = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256     = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA512     = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC          = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC          = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC          = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidDESEDE3CBC         = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+	oidPKCS12KeyAlgorithm = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1}
+)
+
+// pfxPDU is the PFX structure of RFC 7292.
+type pfxPDU struct {
+	Version  int
+	AuthSafe pkcs7ContentInfo
+	MacData  pfxMacData `asn1:"optional"`
+}
+
+type pfxMacData struct {
+	Mac        pfxDigestInfo
+	MacSalt    []byte
+	Iterations int `asn1:"optional,default:1"`
+}
+
+type pfxDigestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type pkcs12EncryptedData struct {
+	Version              int
+	EncryptedContentInfo pkcs12EncryptedContentInfo
+}
+
+type pkcs12EncryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0,optional"`
+}
+
+// pkcs12SafeBag is a SafeBag. As with pkcs7ContentInfo, Value holds the explicit tag, whose content
+// is the bag.
+type pkcs12SafeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue    `asn1:"tag:0,explicit"`
+	Attributes []pkcs7Attribute `asn1:"set,optional"`
+}
+
+type pkcs12CertBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+type pkcs12PBEParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt       []byte
+	Iterations int
+	KeyLength  int                      `asn1:"optional"`
+	PRF        pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// parsePKCS12 returns the private key entries of a PKCS #12 file, checking its MAC and decrypting
+// its contents with storePass and its keys with keyPass. The chain of a key is the certificate
+// with its public key, followed by the other certificates of the file.
+func parsePKCS12(data []byte, storePass, keyPass string) ([]*keystoreEntry, error) {
+	var pfx pfxPDU
+	if rest, err := asn1.Unmarshal(data, &pfx); err != nil || len(rest) > 0 {
+		return nil, fmt.Errorf("%w: not a PKCS #12 file: %v", ErrKeystore, err)
+	}
+	if pfx.Version != 3 || !pfx.AuthSafe.ContentType.Equal(oidData) {
+		return nil, fmt.Errorf("%w: PKCS #12 version %d, content %v", ErrKeystore, pfx.Version, pfx.AuthSafe.ContentType)
+	}
+	// the explicitly tagged content is an OCTET STRING
+	var authSafe []byte
+	if _, err := asn1.Unmarshal(pfx.AuthSafe.Content.Bytes, &authSafe); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrKeystore, err)
+	}
+	if pfx.MacData.Mac.Algorithm.Algorithm != nil {
+		if err := checkPKCS12MAC(&pfx.MacData, authSafe, storePass); err != nil {
+			return nil, err
+		}
+	}
+	var contents []pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(authSafe, &contents); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrKeystore, err)
+	}
+
+	var bags []pkcs12SafeBag
+	for _, ci := range contents {
+		var safe []byte
+		switch {
+		case ci.ContentType.Equal(oidData):
+			if _, err := asn1.Unmarshal(ci.Content.Bytes, &safe); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrKeystore, err)
+			}
+		case ci.ContentType.Equal(oidEncryptedData):
+			var ed pkcs12EncryptedData
+			if _, err := asn1.Unmarshal(ci.Content.Bytes, &ed); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrKeystore, err)
+			}
+			var err error
+			eci := ed.EncryptedContentInfo
+			if safe, err = pbeDecrypt(eci.ContentEncryptionAlgorithm, eci.EncryptedContent, storePass); err != nil {
+				return nil, err
+			}
+		default:
+			// enveloped data, encrypted for a public key, holds nothing we could read
+			continue
+		}
+		var b []pkcs12SafeBag
+		if _, err := asn1.Unmarshal(safe, &b); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrKeystore, err)
+		}
+		bags = append(bags, b...)
+	}
+
+	var entries []*keystoreEntry
+	var certs [][]byte
+	for _, bag := range bags {
+		switch {
+		case bag.ID.Equal(oidKeyBag):
+			entries = append(entries, &keystoreEntry{alias: friendlyName(bag.Attributes), key: bag.Value.Bytes})
+		case bag.ID.Equal(oidShroudedKeyBag):
+			var info encryptedPrivateKeyInfo
+			if _, err := asn1.Unmarshal(bag.Value.Bytes, &info); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrKeystore, err)
+			}
+			key, err := pbeDecrypt(info.Algorithm, info.EncryptedData, keyPass)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, &keystoreEntry{alias: friendlyName(bag.Attributes), key: key})
+		case bag.ID.Equal(oidCertBag):
+			var cb pkcs12CertBag
+			if _, err := asn1.Unmarshal(bag.Value.Bytes, &cb); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrKeystore, err)
+			}
+			if cb.ID.Equal(oidX509Certificate) {
+				certs = append(certs, cb.Data)
+			}
+		}
+	}
+	for _, e := range entries {
+		key, err := x509.ParsePKCS8PrivateKey(e.key)
+		if err != nil {
+			return nil, fmt.Errorf("%w: entry %q: %v", ErrKeystore, e.alias, err)
+		}
+		pub := key.(interface{ Public() crypto.PublicKey }).Public()
+		for _, der := range certs {
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				continue
+			}
+			if eq, ok := pub.(interface{ Equal(crypto.PublicKey) bool }); ok && eq.Equal(cert.PublicKey) {
+				e.certs = append([][]byte{der}, e.certs...)
+			} else {
+				e.certs = append(e.certs, der)
+			}
+		}
+	}
+	return entries, nil
+}
+
+// friendlyName returns the friendlyName attribute of a bag, the alias keytool shows.
+func friendlyName(attrs []pkcs7Attribute) string {
+	for _, a := range attrs {
+		if a.Type.Equal(oidFriendlyName) && len(a.Values) > 0 {
+			b := a.Values[0].Bytes
+			u := make([]uint16, len(b)/2)
+			for i := range u {
+				u[i] = uint16(b[2*i])<<8 | uint16(b[2*i+1])
+			}
+			return string(utf16.Decode(u))
+		}
+	}
+	return ""
+}
+
+// checkPKCS12MAC checks the HMAC of the authenticated safe, keyed as RFC 7292 appendix B derives it
+// from the password.
+func checkPKCS12MAC(m *pfxMacData, data []byte, password string) error {
+	h, err := pkcs7Hash(m.Mac.Algorithm)
+	if err != nil {
+		return err
+	}
+	key := pkcs12KDF(h, pkcs12Password(password), m.MacSalt, m.Iterations, 3, h.Size())
+	mac := hmac.New(h.New, key)
+	mac.Write(data)
+	if subtle.ConstantTimeCompare(mac.Sum(nil), m.Mac.Digest) != 1 {
+		return fmt.Errorf("%w: MAC check failed", ErrKeystorePassword)
+	}
+	return nil
+}
+
+// pbeDecrypt decrypts data encrypted with a password based scheme of PKCS #12 or PKCS #5.
+func pbeDecrypt(alg pkix.AlgorithmIdentifier, data []byte, password string) ([]byte, error) {
+	var block cipher.Block
+	var iv []byte
+	switch {
+	case alg.Algorithm.Equal(oidPBES2):
+		var err error
+		if block, iv, err = pbes2Cipher(alg.Parameters.FullBytes, password); err != nil {
+			return nil, err
+		}
+	case len(alg.Algorithm) == len(oidPKCS12KeyAlgorithm)+1 && alg.Algorithm[:len(oidPKCS12KeyAlgorithm)].Equal(oidPKCS12KeyAlgorithm):
+		var params pkcs12PBEParams
+		if _, err := asn1.Unmarshal(alg.Parameters.FullBytes, &params); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrKeystore, err)
+		}
+		pw := pkcs12Password(password)
+		key := func(n int) []byte { return pkcs12KDF(crypto.SHA1, pw, params.Salt, params.Iterations, 1, n) }
+		switch {
+		case alg.Algorithm.Equal(oidPBEWithSHA3DES):
+			block, _ = des.NewTripleDESCipher(key(24))
+		case alg.Algorithm.Equal(oidPBEWithSHARC2):
+			block = newRC2(key(16), 128)
+		case alg.Algorithm.Equal(oidPBEWithSHARC2_40):
+			block = newRC2(key(5), 40)
+		default:
+			return nil, fmt.Errorf("%w: PKCS #12 encryption %v", ErrUnknownAlgorithm, alg.Algorithm)
+		}
+		iv = pkcs12KDF(crypto.SHA1, pw, params.Salt, params.Iterations, 2, block.BlockSize())
+	default:
+		return nil, fmt.Errorf("%w: PKCS #12 encryption %v", ErrUnknownAlgorithm, alg.Algorithm)
+	}
+	n := block.BlockSize()
+	if len(data) == 0 || len(data)%n != 0 {
+		return nil, fmt.Errorf("%w: encrypted data is not a whole number of blocks", ErrKeystore)
+	}
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+	// a wrong password leaves garbage instead of PKCS #7 padding, most of the time
+	pad := int(out[len(out)-1])
+	if pad == 0 || pad > n || !bytes.Equal(out[len(out)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		return nil, fmt.Errorf("%w: cannot decrypt", ErrKeystorePassword)
+	}
+	return out[:len(out)-pad], nil
+}
+
+// pbes2Cipher returns the cipher and IV of PBES2 parameters, with the key derived by PBKDF2 from
+// the UTF-8 password.
+func pbes2Cipher(der []byte, password string) (cipher.Block, []byte, error) {
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(der, &params); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrKeystore, err)
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, nil, fmt.Errorf("%w: key derivation %v", ErrUnknownAlgorithm, params.KeyDerivationFunc.Algorithm)
+	}
+	var kdf pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrKeystore, err)
+	}
+	var prf func() hash.Hash
+	switch alg := kdf.PRF.Algorithm; {
+	case alg == nil, alg.Equal(oidHMACWithSHA1):
+		prf = sha1.New
+	case alg.Equal(oidHMACWithSHA256):
+		prf = sha256.New
+	case alg.Equal(oidHMACWithSHA512):
+		prf = sha512.New
+	default:
+		return nil, nil, fmt.Errorf("%w: PBKDF2 with %v", ErrUnknownAlgorithm, alg)
+	}
+	enc := params.EncryptionScheme
+	var keyLen int
+	var newCipher func([]byte) (cipher.Block, error)
+	switch {
+	case enc.Algorithm.Equal(oidAES128CBC):
+		keyLen, newCipher = 16, aes.NewCipher
+	case enc.Algorithm.Equal(oidAES192CBC):
+		keyLen, newCipher = 24, aes.NewCipher
+	case enc.Algorithm.Equal(oidAES256CBC):
+		keyLen, newCipher = 32, aes.NewCipher
+	case enc.Algorithm.Equal(oidDESEDE3CBC):
+		keyLen, newCipher = 24, des.NewTripleDESCipher
+	default:
+		return nil, nil, fmt.Errorf("%w: PBES2 with %v", ErrUnknownAlgorithm, enc.Algorithm)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(enc.Parameters.FullBytes, &iv); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrKeystore, err)
+	}
+	block, err := newCipher(pbkdf2Key(prf, []byte(password), kdf.Salt, kdf.Iterations, keyLen))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(iv) != block.BlockSize() {
+		return nil, nil, fmt.Errorf("%w: IV of %d bytes", ErrKeystore, len(iv))
+	}
+	return block, iv, nil
+}
+
+// pbkdf2Key is PBKDF2 of RFC 8018.
+func pbkdf2Key(prf func() hash.Hash, password, salt []byte, iterations, keyLen int) []byte {
+	mac := hmac.New(prf, password)
+	var out []byte
+	for block := uint32(1); len(out) < keyLen; block++ {
+		mac.Reset()
+		mac.Write(salt)
+		mac.Write([]byte{byte(block >> 24), byte(block >> 16), byte(block >> 8), byte(block)})
+		u := mac.Sum(nil)
+		t := bytes.Clone(u)
+		for i := 1; i < iterations; i++ {
+			mac.Reset()
+			mac.Write(u)
+			u = mac.Sum(u[:0])
+			subtle.XORBytes(t, t, u)
+		}
+		out = append(out, t...)
+	}
+	return out[:keyLen]
+}
+
+// pkcs12Password returns password as the null terminated BMPString the PKCS #12 key derivation
+// takes.
+func pkcs12Password(password string) []byte {
+	return append(bmpPassword(password), 0, 0)
+}
+
+// pkcs12KDF derives size bytes of key material for purpose id (1 key, 2 IV, 3 MAC key) as RFC 7292
+// appendix B.2 does.
+func pkcs12KDF(h crypto.Hash, password, salt []byte, iterations int, id byte, size int) []byte {
+	v := h.New().BlockSize()
+	fill := func(b []byte) []byte {
+		if len(b) == 0 {
+			return nil
+		}
+		out := make([]byte, v*((len(b)+v-1)/v))
+		for i := range out {
+			out[i] = b[i%len(b)]
+		}
+		return out
+	}
+	d := bytes.Repeat([]byte{id}, v)
+	in := append(fill(salt), fill(password)...)
+	var out []byte
+	for {
+		a := h.New()
+		a.Write(d)
+		a.Write(in)
+		sum := a.Sum(nil)
+		for i := 1; i < iterations; i++ {
+			a.Reset()
+			a.Write(sum)
+			sum = a.Sum(sum[:0])
+		}
+		if out = append(out, sum...); len(out) >= size {
+			return out[:size]
+		}
+		// add B + 1, B being sum repeated to v bytes, to each v byte block of the input
+		b := make([]byte, v)
+		for i := range b {
+			b[i] = sum[i%len(sum)]
+		}
+		for j := 0; j < len(in); j += v {
+			carry := 1
+			for k := v - 1; k >= 0; k-- {
+				x := int(in[j+k]) + int(b[k]) + carry
+				in[j+k], carry = byte(x), x>>8
+			}
+		}
+	}
+}
+
+// oidLocalKeyID is the localKeyId attribute pairing a key bag with the bag of its certificate.
+var oidLocalKeyID = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+
+// pkcs12Iterations is the iteration count of the key derivations of EncodePKCS12, the default of
+// keytool.
+const pkcs12Iterations = 10000
+
+// EncodePKCS12 returns a PKCS #12 keystore holding the key and certificate of sc under alias, both
+// protected by password, which keytool, apksigner and Gradle read as a release.keystore would be.
+// It uses PBES2 with PBKDF2-HMAC-SHA256 and AES-256 and a SHA-256 MAC, as keytool does from Java 12
+// and OpenSSL 3 do, so older tools may not read it. The key must be in memory: a Signer cannot be
+// exported.
+func EncodePKCS12(sc *SigningCert, alias, password string) (_ []byte, err error) {
+	defer catch(&err, "EncodePKCS12", "keystore")
+	if err = sc.Resolve(); err != nil {
+		return nil, err
+	}
+	key, err := x509.MarshalPKCS8PrivateKey(sc.signer())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrKeystore, err)
+	}
+	keyID := sha1.Sum(sc.Certificate.Raw)
+	attrs := []pkcs7Attribute{
+		{Type: oidFriendlyName, Values: []asn1.RawValue{{Tag: asn1.TagBMPString, Bytes: bmpPassword(alias)}}},
+		{Type: oidLocalKeyID, Values: []asn1.RawValue{{Tag: asn1.TagOctetString, Bytes: keyID[:]}}},
+	}
+
+	alg, enc, err := pbes2Encrypt(key, password)
+	if err != nil {
+		return nil, err
+	}
+	shrouded, err := asn1.Marshal(encryptedPrivateKeyInfo{alg, enc})
+	if err != nil {
+		return nil, err
+	}
+	keySafe, err := asn1.Marshal([]pkcs12SafeBag{{ID: oidShroudedKeyBag, Value: explicitTag(shrouded), Attributes: attrs}})
+	if err != nil {
+		return nil, err
+	}
+	certBag, err := asn1.Marshal(pkcs12CertBag{ID: oidX509Certificate, Data: sc.Certificate.Raw})
+	if err != nil {
+		return nil, err
+	}
+	certSafe, err := asn1.Marshal([]pkcs12SafeBag{{ID: oidCertBag, Value: explicitTag(certBag), Attributes: attrs}})
+	if err != nil {
+		return nil, err
+	}
+
+	// the certificate goes in encrypted data and the shrouded key in plain data, as OpenSSL does
+	if alg, enc, err = pbes2Encrypt(certSafe, password); err != nil {
+		return nil, err
+	}
+	encrypted, err := asn1.Marshal(pkcs12EncryptedData{EncryptedContentInfo: pkcs12EncryptedContentInfo{oidData, alg, enc}})
+	if err != nil {
+		return nil, err
+	}
+	plain, err := asn1.Marshal(keySafe)
+	if err != nil {
+		return nil, err
+	}
+	authSafe, err := asn1.Marshal([]pkcs7ContentInfo{
+		{ContentType: oidEncryptedData, Content: explicitTag(encrypted)},
+		{ContentType: oidData, Content: explicitTag(plain)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, 16)
+	if _, err = rand.Read(salt); err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, pkcs12KDF(crypto.SHA256, pkcs12Password(password), salt, pkcs12Iterations, 3, sha256.Size))
+	mac.Write(authSafe)
+	content, err := asn1.Marshal(authSafe)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pfxPDU{
+		Version:  3,
+		AuthSafe: pkcs7ContentInfo{ContentType: oidData, Content: explicitTag(content)},
+		MacData: pfxMacData{
+			Mac:        pfxDigestInfo{pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}, mac.Sum(nil)},
+			MacSalt:    salt,
+			Iterations: pkcs12Iterations,
+		},
+	})
+}
+
+// pbes2Encrypt encrypts data with PBES2, using PBKDF2-HMAC-SHA256 and AES-256-CBC, and returns the
+// algorithm identifier with its parameters and the ciphertext.
+func pbes2Encrypt(data []byte, password string) (pkix.AlgorithmIdentifier, []byte, error) {
+	var alg pkix.AlgorithmIdentifier
+	salt, iv := make([]byte, 16), make([]byte, aes.BlockSize)
+	if _, err := rand.Read(salt); err != nil {
+		return alg, nil, err
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return alg, nil, err
+	}
+	kdf, err := asn1.Marshal(pbkdf2Params{
+		Salt:       salt,
+		Iterations: pkcs12Iterations,
+		PRF:        pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return alg, nil, err
+	}
+	ivDER, err := asn1.Marshal(iv)
+	if err != nil {
+		return alg, nil, err
+	}
+	params, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdf}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivDER}},
+	})
+	if err != nil {
+		return alg, nil, err
+	}
+	block, err := aes.NewCipher(pbkdf2Key(sha256.New, []byte(password), salt, pkcs12Iterations, 32))
+	if err != nil {
+		return alg, nil, err
+	}
+	pad := aes.BlockSize - len(data)%aes.BlockSize
+	out := append(bytes.Clone(data), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, out)
+	return pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}}, out, nil
+}
+
+// explicitTag returns der wrapped in the explicit [0] tag of a ContentInfo or SafeBag.
+func explicitTag(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
@@ -0,0 +1,349 @@
+package signv2
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/subtle"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"time"
+)
+
+// Object identifiers of PKCS #7 (RFC 2315) and of the algorithms JAR signatures use.
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidRSAEncryption = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidECPublicKey   = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidECDSAWithSHA1 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 1}
+	oidECDSAWithSHA2 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3} // arc of ecdsa-with-SHA256 etc.
+	oidSHA1          = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA512        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+)
+
+// ErrPKCS7 is matched by the errors of VerifyPKCS7 for a malformed or wrong signature.
+var ErrPKCS7 = errors.New("invalid PKCS #7 signature")
+
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      pkcs7ContentInfo
+	Certificates     []asn1.RawValue   `asn1:"optional,set,tag:0"`
+	CRLs             []asn1.RawValue   `asn1:"optional,set,tag:1"`
+	SignerInfos      []pkcs7SignerInfo `asn1:"set"`
+}
+
+type pkcs7SignerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     pkcs7IssuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   []pkcs7Attribute `asn1:"optional,set,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+}
+
+type pkcs7IssuerAndSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type pkcs7Attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// PKCS7Options configures SignPKCS7.
+type PKCS7Options struct {
+	// SignedAttributes adds the contentType, signingTime and messageDigest attributes and signs
+	// them instead of the content, as jarsigner does. apksigner signs the content directly.
+	SignedAttributes bool
+	// SigningTime is the time recorded in the signingTime attribute; the zero value means now.
+	SigningTime time.Time
+	// Hash is the digest algorithm of the signature, crypto.SHA1 for instance for JAR signatures
+	// that Android versions before 4.3 verify; zero means the Hash of the key.
+	Hash crypto.Hash
+}
+
+// SignPKCS7 returns a detached PKCS #7 SignedData over content in DER, with the certificate of sc
+// and a single signer. This is the signature block file of a v1 (JAR) signature, e.g.
+// META-INF/CERT.RSA, whose content is the signature file META-INF/CERT.SF. RSA and ECDSA keys are
+// supported; as apksigner does, an ECDSA signer names its algorithm by the public key type.
+func (sc *SigningCert) SignPKCS7(content []byte, opts PKCS7Options) ([]byte, error) {
+	if err := sc.Resolve(); err != nil {
+		return nil, err
+	}
+	hash := opts.Hash
+	if hash == 0 {
+		hash = sc.Hash.AsHash()
+	}
+	digestAlg, err := pkcs7DigestAlgorithm(hash)
+	if err != nil {
+		return nil, err
+	}
+	var encAlg pkix.AlgorithmIdentifier
+	switch sc.Certificate.PublicKey.(type) {
+	case *rsa.PublicKey:
+		encAlg = pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}
+	case *ecdsa.PublicKey:
+		encAlg = pkix.AlgorithmIdentifier{Algorithm: oidECPublicKey}
+	default:
+		return nil, fmt.Errorf("%w: PKCS #7 signing supports RSA and ECDSA keys only", ErrUnknownAlgorithm)
+	}
+	h := hash.New()
+	h.Write(content)
+	digest := h.Sum(nil)
+
+	signer := pkcs7SignerInfo{
+		Version: 1,
+		IssuerAndSerialNumber: pkcs7IssuerAndSerial{
+			Issuer:       asn1.RawValue{FullBytes: sc.Certificate.RawIssuer},
+			SerialNumber: sc.Certificate.SerialNumber,
+		},
+		DigestAlgorithm:           digestAlg,
+		DigestEncryptionAlgorithm: encAlg,
+	}
+	if opts.SignedAttributes {
+		signingTime := opts.SigningTime
+		if signingTime.IsZero() {
+			signingTime = time.Now()
+		}
+		attrs, err := pkcs7Attributes(
+			oidContentType, oidData,
+			oidSigningTime, signingTime.UTC(),
+			oidMessageDigest, digest,
+		)
+		if err != nil {
+			return nil, err
+		}
+		signer.AuthenticatedAttributes = attrs
+		// the signature covers the attributes encoded as a SET OF, not with the [0] tag they have in
+		// the SignerInfo
+		signed, err := asn1.MarshalWithParams(attrs, "set")
+		if err != nil {
+			return nil, err
+		}
+		h := hash.New()
+		h.Write(signed)
+		digest = h.Sum(nil)
+	}
+	if signer.EncryptedDigest, err = sc.SignPrehashed(digest, hash); err != nil {
+		return nil, err
+	}
+
+	sd, err := asn1.Marshal(pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{digestAlg},
+		ContentInfo:      pkcs7ContentInfo{ContentType: oidData},
+		Certificates:     []asn1.RawValue{{FullBytes: sc.Certificate.Raw}},
+		SignerInfos:      []pkcs7SignerInfo{signer},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pkcs7ContentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sd},
+	})
+}
+
+// pkcs7Attributes encodes the attributes given as type, value pairs, sorted by their encoding as
+// DER requires of a SET OF.
+func pkcs7Attributes(typeValues ...any) ([]pkcs7Attribute, error) {
+	var attrs []pkcs7Attribute
+	var encoded [][]byte
+	for i := 0; i < len(typeValues); i += 2 {
+		v, err := asn1.Marshal(typeValues[i+1])
+		if err != nil {
+			return nil, err
+		}
+		attr := pkcs7Attribute{Type: typeValues[i].(asn1.ObjectIdentifier), Values: []asn1.RawValue{{FullBytes: v}}}
+		b, err := asn1.Marshal(attr)
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, attr)
+		encoded = append(encoded, b)
+	}
+	order := make([]int, len(attrs))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(a, b int) int { return bytes.Compare(encoded[a], encoded[b]) })
+	sorted := make([]pkcs7Attribute, len(attrs))
+	for i, o := range order {
+		sorted[i] = attrs[o]
+	}
+	return sorted, nil
+}
+
+func pkcs7DigestAlgorithm(hash crypto.Hash) (pkix.AlgorithmIdentifier, error) {
+	var oid asn1.ObjectIdentifier
+	switch hash {
+	case crypto.SHA1:
+		oid = oidSHA1
+	case crypto.SHA256:
+		oid = oidSHA256
+	case crypto.SHA512:
+		oid = oidSHA512
+	default:
+		return pkix.AlgorithmIdentifier{}, fmt.Errorf("%w: digest %v", ErrUnknownAlgorithm, hash)
+	}
+	return pkix.AlgorithmIdentifier{Algorithm: oid, Parameters: asn1.NullRawValue}, nil
+}
+
+func pkcs7Hash(alg pkix.AlgorithmIdentifier) (crypto.Hash, error) {
+	switch {
+	case alg.Algorithm.Equal(oidSHA1):
+		return crypto.SHA1, nil
+	case alg.Algorithm.Equal(oidSHA256):
+		return crypto.SHA256, nil
+	case alg.Algorithm.Equal(oidSHA512):
+		return crypto.SHA512, nil
+	}
+	return 0, fmt.Errorf("%w: digest %v", ErrUnknownAlgorithm, alg.Algorithm)
+}
+
+// VerifyPKCS7 verifies the detached PKCS #7 SignedData sig over content, such as a v1 signature
+// block file over its signature file, and returns the certificate of each signer. It checks the
+// signatures only: whether the certificates are trusted is up to the caller, as it is for APKs.
+func VerifyPKCS7(sig, content []byte) ([]*x509.Certificate, error) {
+	sd, certs, err := parsePKCS7(sig)
+	if err != nil {
+		return nil, err
+	}
+	var signers []*x509.Certificate
+	for _, si := range sd.SignerInfos {
+		cert := pkcs7SignerCert(certs, si)
+		if cert == nil {
+			return nil, fmt.Errorf("%w: no certificate for signer", ErrPKCS7)
+		}
+		hash, err := pkcs7Hash(si.DigestAlgorithm)
+		if err != nil {
+			return nil, err
+		}
+		h := hash.New()
+		h.Write(content)
+		digest := h.Sum(nil)
+		if len(si.AuthenticatedAttributes) > 0 {
+			if err = checkPKCS7Attributes(si.AuthenticatedAttributes, digest); err != nil {
+				return nil, err
+			}
+			signed, err := asn1.MarshalWithParams(si.AuthenticatedAttributes, "set")
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrPKCS7, err)
+			}
+			h := hash.New()
+			h.Write(signed)
+			digest = h.Sum(nil)
+		}
+		alg := si.DigestEncryptionAlgorithm.Algorithm
+		switch pub := cert.PublicKey.(type) {
+		case *rsa.PublicKey:
+			if !alg.Equal(oidRSAEncryption) {
+				return nil, fmt.Errorf("%w: RSA signature %v", ErrUnknownAlgorithm, alg)
+			}
+			if err = rsa.VerifyPKCS1v15(pub, hash, digest, si.EncryptedDigest); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrPKCS7, err)
+			}
+		case *ecdsa.PublicKey:
+			// jarsigner names the signature algorithm, apksigner the key type
+			if !alg.Equal(oidECPublicKey) && !alg.Equal(oidECDSAWithSHA1) &&
+				!(len(alg) == len(oidECDSAWithSHA2)+1 && slices.Equal(alg[:len(oidECDSAWithSHA2)], oidECDSAWithSHA2)) {
+				return nil, fmt.Errorf("%w: ECDSA signature %v", ErrUnknownAlgorithm, alg)
+			}
+			if !ecdsa.VerifyASN1(pub, digest, si.EncryptedDigest) {
+				return nil, fmt.Errorf("%w: ecdsa: verification error", ErrPKCS7)
+			}
+		default:
+			return nil, fmt.Errorf("%w: signature %v (only RSA and ECDSA are supported)", ErrUnknownAlgorithm, alg)
+		}
+		signers = append(signers, cert)
+	}
+	return signers, nil
+}
+
+// parsePKCS7 parses the detached PKCS #7 SignedData sig and the certificates it carries.
+func parsePKCS7(sig []byte) (*pkcs7SignedData, []*x509.Certificate, error) {
+	var ci pkcs7ContentInfo
+	if rest, err := asn1.Unmarshal(sig, &ci); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrPKCS7, err)
+	} else if len(rest) > 0 {
+		return nil, nil, fmt.Errorf("%w: trailing data", ErrPKCS7)
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, nil, fmt.Errorf("%w: content type %v is not signed data", ErrPKCS7, ci.ContentType)
+	}
+	var sd pkcs7SignedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrPKCS7, err)
+	}
+	var certs []*x509.Certificate
+	for _, raw := range sd.Certificates {
+		cert, err := x509.ParseCertificate(raw.FullBytes)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrPKCS7, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(sd.SignerInfos) == 0 {
+		return nil, nil, fmt.Errorf("%w: no signer", ErrPKCS7)
+	}
+	return &sd, certs, nil
+}
+
+// pkcs7SignerCert returns the certificate of certs that si names, or nil.
+func pkcs7SignerCert(certs []*x509.Certificate, si pkcs7SignerInfo) *x509.Certificate {
+	var cert *x509.Certificate
+	for _, c := range certs {
+		if bytes.Equal(c.RawIssuer, si.IssuerAndSerialNumber.Issuer.FullBytes) &&
+			c.SerialNumber.Cmp(si.IssuerAndSerialNumber.SerialNumber) == 0 {
+			cert = c
+		}
+	}
+	return cert
+}
+
+// checkPKCS7Attributes checks the contentType and messageDigest attributes a SignerInfo with
+// authenticated attributes must have.
+func checkPKCS7Attributes(attrs []pkcs7Attribute, digest []byte) error {
+	var contentType, messageDigest bool
+	for _, a := range attrs {
+		if len(a.Values) != 1 {
+			continue
+		}
+		switch {
+		case a.Type.Equal(oidContentType):
+			var oid asn1.ObjectIdentifier
+			if _, err := asn1.Unmarshal(a.Values[0].FullBytes, &oid); err != nil || !oid.Equal(oidData) {
+				return fmt.Errorf("%w: content type attribute is not data", ErrPKCS7)
+			}
+			contentType = true
+		case a.Type.Equal(oidMessageDigest):
+			var md []byte
+			if _, err := asn1.Unmarshal(a.Values[0].FullBytes, &md); err != nil || subtle.ConstantTimeCompare(md, digest) != 1 {
+				return fmt.Errorf("%w: %w of the signed content", ErrPKCS7, ErrDigestMismatch)
+			}
+			messageDigest = true
+		}
+	}
+	if !contentType || !messageDigest {
+		return fmt.Errorf("%w: missing content type or message digest attribute", ErrPKCS7)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package signv2
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPKCS7(t *testing.T) {
+	sc := testSigningCerts(t)[0]
+	content := []byte("Signature-Version: 1.0\r\nCreated-By: 1.0 (Android)\r\n\r\n")
+	for _, opts := range []PKCS7Options{
+		{},
+		{SignedAttributes: true, SigningTime: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)},
+	} {
+		sig, err := sc.SignPKCS7(content, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signers, err := VerifyPKCS7(sig, content)
+		if err != nil {
+			t.Fatalf("%+v: %v", opts, err)
+		}
+		if len(signers) != 1 || !signers[0].Equal(sc.Certificate) {
+			t.Errorf("%+v: signers %v", opts, signers)
+		}
+		if _, err = VerifyPKCS7(sig, append(content, '\n')); !errors.Is(err, ErrPKCS7) {
+			t.Errorf("%+v: verifying other content = %v, want %v", opts, err, ErrPKCS7)
+		}
+		if opts.SignedAttributes {
+			if _, err = VerifyPKCS7(sig, content[1:]); !errors.Is(err, ErrDigestMismatch) {
+				t.Errorf("verifying other content = %v, want %v", err, ErrDigestMismatch)
+			}
+		}
+	}
+
+	ec, err := GenerateDebugCert("ec", 0, EC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := ec.SignPKCS7(content, PKCS7Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signers, err := VerifyPKCS7(sig, content); err != nil || len(signers) != 1 || !signers[0].Equal(ec.Certificate) {
+		t.Errorf("ECDSA: signers %v, %v", signers, err)
+	}
+	if _, err = VerifyPKCS7(sig, content[1:]); !errors.Is(err, ErrPKCS7) {
+		t.Errorf("ECDSA: verifying other content = %v, want %v", err, ErrPKCS7)
+	}
+
+	if _, err := VerifyPKCS7([]byte{0x30, 0x00}, content); !errors.Is(err, ErrPKCS7) {
+		t.Errorf("verifying garbage = %v, want %v", err, ErrPKCS7)
+	}
+}
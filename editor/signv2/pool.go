@@ -0,0 +1,71 @@
+package signv2
+
+import (
+	"bytes"
+	"crypto"
+	"hash"
+	"sync"
+)
+
+/* Scratch buffers and hash states are recycled through sync.Pools, so that a long running process
+   signing many APKs back to back does not allocate a fresh 1MB chunk buffer and a fresh hash state
+   per chunk for every file. Nothing obtained from a pool may escape to the caller of an exported
+   function; results are always copied out first.
+*/
+
+// maxPooledBuffer is the capacity above which serialization buffers are dropped rather than pooled,
+// so that one unusually large signing block does not pin its memory for the life of the process.
+const maxPooledBuffer = 1 << 20
+
+// hashPools holds one pool of hash states per crypto.Hash.
+var hashPools [crypto.BLAKE2b_512 + 1]sync.Pool
+
+// getHash returns a reset hash state for h, reusing a pooled one if available.
+func getHash(h crypto.Hash) hash.Hash {
+	if v := hashPools[h].Get(); v != nil {
+		hh := v.(hash.Hash)
+		hh.Reset()
+		return hh
+	}
+	return h.New()
+}
+
+// putHash returns a hash state obtained from getHash(h) to its pool.
+func putHash(h crypto.Hash, hh hash.Hash) {
+	hashPools[h].Put(hh)
+}
+
+// chunkPool holds 1MB buffers for the v2 content digest chunks.
+var chunkPool = sync.Pool{New: func() any {
+	b := make([]byte, 0, 1048576)
+	return &b
+}}
+
+// getChunk returns an empty buffer with a capacity of one v2 digest chunk.
+func getChunk() *[]byte {
+	b := chunkPool.Get().(*[]byte)
+	*b = (*b)[:0]
+	return b
+}
+
+// putChunk returns a buffer obtained from getChunk to the pool.
+func putChunk(b *[]byte) {
+	chunkPool.Put(b)
+}
+
+// bufferPool holds buffers used while serializing signing blocks.
+var bufferPool = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// getBuffer returns an empty serialization buffer.
+func getBuffer() *bytes.Buffer {
+	b := bufferPool.Get().(*bytes.Buffer)
+	b.Reset()
+	return b
+}
+
+// putBuffer returns a buffer obtained from getBuffer to the pool, unless it grew too large.
+func putBuffer(b *bytes.Buffer) {
+	if b.Cap() <= maxPooledBuffer {
+		bufferPool.Put(b)
+	}
+}
@@ -0,0 +1,78 @@
+package signv2
+
+import (
+	"bytes"
+	"crypto"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+func TestPooledHash(t *testing.T) {
+	h := getHash(crypto.SHA256)
+	h.Write([]byte("left over state"))
+	putHash(crypto.SHA256, h)
+	for range 4 {
+		h = getHash(crypto.SHA256)
+		if !bytes.Equal(h.Sum(nil), crypto.SHA256.New().Sum(nil)) {
+			t.Fatal("getHash returned a hash that was not reset")
+		}
+		putHash(crypto.SHA256, h)
+	}
+}
+
+func TestConcurrentSign(t *testing.T) {
+	random := make([]byte, 5<<19) // spans several digest chunks
+	rand.New(rand.NewSource(1)).Read(random)
+	unsigned := testZip(t, "AndroidManifest.xml", "manifest", "assets/big.bin", string(random))
+	want := testSign(t, unsigned)
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			keys := testSigningCerts(t) // Resolve writes to the SigningCert, so each goroutine needs its own
+			z, err := NewApkSignNoCopy(unsigned)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			signed, err := z.SignV2(keys)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !bytes.Equal(signed, want) {
+				t.Error("concurrent SignV2 output differs")
+			}
+			out := new(bytes.Buffer)
+			if err = SignStream(bytes.NewReader(unsigned), out, StreamConfig{Keys: keys, TailSize: 64 << 10}); err != nil {
+				t.Error(err)
+				return
+			}
+			if !bytes.Equal(out.Bytes(), want) {
+				t.Error("concurrent SignStream output differs")
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkSignV2(b *testing.B) {
+	random := make([]byte, 4<<20)
+	rand.New(rand.NewSource(1)).Read(random)
+	unsigned := testZip(b, "AndroidManifest.xml", "manifest", "assets/big.bin", string(random))
+	keys := testSigningCerts(b)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(unsigned)))
+	for i := 0; i < b.N; i++ {
+		z, err := NewApkSignNoCopy(unsigned)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err = z.SignV2(keys); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package signv2
+
+import (
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// BlockProcessor produces and checks an ID-value pair of the APK Signing Block that is not part of a
+// signature scheme, such as telemetry or licensing data, so that such pairs can be handled without
+// changing the signer. A processor is in effect when registered with RegisterBlockProcessor, or for
+// one ApkSign or SignV2 call with WithBlockProcessors.
+//
+// The signature schemes do not cover the other pairs of the block, so a processor that needs its
+// pair to be authentic must sign it itself. Its methods may be called from many goroutines at once.
+// SignStream does not run processors, as it never holds the whole file.
+type BlockProcessor interface {
+	// BlockID returns the ID of the pair the processor handles.
+	BlockID() uint32
+	// SignBlock returns the value of the pair to add when z is signed, or nil to add none. old is
+	// the value of the pair in the signing block z already has, or nil.
+	SignBlock(z *ApkSign, old []byte) ([]byte, error)
+	// VerifyBlock checks the value of the pair in the signing block of z; value is nil if there is
+	// no such pair.
+	VerifyBlock(z *ApkSign, value []byte) error
+}
+
+// BlockError is returned when a BlockProcessor fails.
+type BlockError struct {
+	ID  uint32
+	Err error
+}
+
+func (e *BlockError) Error() string {
+	if name := BlockName(e.ID); name != "" {
+		return fmt.Sprintf("signing block pair %#08x (%s): %v", e.ID, name, e.Err)
+	}
+	return fmt.Sprintf("signing block pair %#08x: %v", e.ID, e.Err)
+}
+
+func (e *BlockError) Unwrap() error {
+	return e.Err
+}
+
+// schemeBlocks are the pairs this package writes itself, which no processor may take over.
+var schemeBlocks = []uint32{BlockIDV2, BlockIDV3, BlockIDV31, BlockIDPadding}
+
+var (
+	processorsMu sync.RWMutex
+	processors   = map[uint32]BlockProcessor{}
+)
+
+// RegisterBlockProcessor makes p run whenever a file is signed or verified, replacing any processor
+// registered for the same ID. It panics if p handles one of the pairs of the signature schemes.
+func RegisterBlockProcessor(p BlockProcessor) {
+	id := p.BlockID()
+	if slices.Contains(schemeBlocks, id) {
+		panic(fmt.Sprintf("signv2: block processor for the reserved ID %#08x", id))
+	}
+	processorsMu.Lock()
+	defer processorsMu.Unlock()
+	processors[id] = p
+}
+
+// UnregisterBlockProcessor removes the processor registered for id, if any.
+func UnregisterBlockProcessor(id uint32) {
+	processorsMu.Lock()
+	defer processorsMu.Unlock()
+	delete(processors, id)
+}
+
+// WithBlockProcessors runs ps in addition to the registered processors, taking precedence over those
+// with the same ID. Processors for the pairs of the signature schemes are ignored.
+func WithBlockProcessors(ps ...BlockProcessor) Option {
+	return func(o *options) { o.processors = append(slices.Clip(o.processors), ps...) }
+}
+
+// blockProcessors returns the processors in effect for o, ordered by ID.
+func (o options) blockProcessors() []BlockProcessor {
+	byID := map[uint32]BlockProcessor{}
+	processorsMu.RLock()
+	for id, p := range processors {
+		byID[id] = p
+	}
+	processorsMu.RUnlock()
+	for _, p := range o.processors {
+		if id := p.BlockID(); !slices.Contains(schemeBlocks, id) {
+			byID[id] = p
+		}
+	}
+	ps := make([]BlockProcessor, 0, len(byID))
+	for _, p := range byID {
+		ps = append(ps, p)
+	}
+	slices.SortFunc(ps, func(a, b BlockProcessor) int { return cmp.Compare(a.BlockID(), b.BlockID()) })
+	return ps
+}
+
+// processedPairs returns the encoded pairs ps produce for a new signing block of apkSign.
+func (apkSign *ApkSign) processedPairs(ps []BlockProcessor) ([]byte, error) {
+	var pairs []*Pair
+	if len(ps) > 0 && apkSign.rawASv2 != nil {
+		var err error
+		if pairs, err = apkSign.signingBlockPairs(); err != nil {
+			return nil, err
+		}
+	}
+	var out [][]byte
+	for _, p := range ps {
+		old, err := findPair(pairs, p.BlockID())
+		if err == nil {
+			old, err = p.SignBlock(apkSign, old)
+		}
+		if err != nil {
+			return nil, &BlockError{p.BlockID(), err}
+		}
+		if old == nil {
+			continue
+		}
+		value := push32(old)
+		binary.LittleEndian.PutUint32(value, p.BlockID())
+		out = append(out, push64(value))
+	}
+	return concat(out...), nil
+}
+
+// verifyBlocks runs ps on the signing block of apkSign and returns their errors, as *BlockError, by
+// ID.
+func (apkSign *ApkSign) verifyBlocks(ps []BlockProcessor) (map[uint32]error, error) {
+	if len(ps) == 0 {
+		return nil, nil
+	}
+	pairs, err := apkSign.signingBlockPairs()
+	if err != nil {
+		return nil, err
+	}
+	errs := map[uint32]error{}
+	for _, p := range ps {
+		value, err := findPair(pairs, p.BlockID())
+		if err == nil {
+			err = p.VerifyBlock(apkSign, value)
+		}
+		if err != nil {
+			errs[p.BlockID()] = &BlockError{p.BlockID(), err}
+		}
+	}
+	return errs, nil
+}
@@ -0,0 +1,92 @@
+package signv2
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// counterProcessor writes a one byte counter, incremented on each signing, and requires it on
+// verification.
+type counterProcessor struct{ id uint32 }
+
+var errNoCounter = errors.New("no counter")
+
+func (p counterProcessor) BlockID() uint32 { return p.id }
+
+func (p counterProcessor) SignBlock(_ *ApkSign, old []byte) ([]byte, error) {
+	if old == nil {
+		return []byte{1}, nil
+	}
+	return []byte{old[0] + 1}, nil
+}
+
+func (p counterProcessor) VerifyBlock(_ *ApkSign, value []byte) error {
+	if len(value) != 1 {
+		return errNoCounter
+	}
+	return nil
+}
+
+func TestBlockProcessor(t *testing.T) {
+	const id = 0x4c494331
+	p := counterProcessor{id}
+	z, err := NewApkSign(testSignedApk(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// without the pair, verification fails only where the processor is in effect
+	if err = z.VerifyV2(); err != nil {
+		t.Fatal(err)
+	}
+	withP, err := NewApkSign(z.Bytes(), WithBlockProcessors(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = withP.VerifyV2(); !errors.Is(err, errNoCounter) {
+		t.Errorf("VerifyV2 = %v, want %v", err, errNoCounter)
+	}
+	var be *BlockError
+	if r := withP.VerifyAll(VerifyOptions{}); r.Verified || len(r.BlockErrors) != 1 || !errors.As(r.BlockErrors[0], &be) || be.ID != id {
+		t.Errorf("VerifyAll: verified %v, block errors %v", r.Verified, r.BlockErrors)
+	}
+
+	signed, err := z.SignV2(testSigningCerts(t), WithBlockProcessors(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	RegisterBlockProcessor(p)
+	defer UnregisterBlockProcessor(id)
+	z, err = NewApkSign(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = z.VerifyV2(); err != nil {
+		t.Error(err)
+	}
+	if r := z.VerifyAll(VerifyOptions{}); !r.Verified || !r.HasBlock(id) || len(r.Warnings) != 0 {
+		t.Errorf("VerifyAll: verified %v, warnings %q", r.Verified, r.Warnings)
+	}
+
+	// the registered processor sees the previous value when re-signing
+	resigned, err := z.SignV2(testSigningCerts(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err = NewApkSign(resigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := findPair(mustPairs(t, z), id); !bytes.Equal(got, []byte{2}) {
+		t.Errorf("re-signed counter = %v, want [2]", got)
+	}
+}
+
+func TestRegisterReservedBlock(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a processor for the v2 block did not panic")
+		}
+	}()
+	RegisterBlockProcessor(counterProcessor{BlockIDV2})
+}
@@ -0,0 +1,56 @@
+package signv2
+
+import "io"
+
+// ProgressFunc receives progress updates from long running operations. stage
+// names the current step (one of the Stage constants); done and total are byte
+// counts within that stage. total is 0 when it is not known in advance.
+type ProgressFunc func(stage string, done, total int64)
+
+// Stages reported to a ProgressFunc.
+const (
+	// StageDigest is the computation of the v2 content digests.
+	StageDigest = "digest"
+	// StageCopy is the copying of the unchanged entries of an edited apk.
+	StageCopy = "copy"
+	// StageWrite is the writing of the signed output by SignV2To and SignStream.
+	StageWrite = "write"
+	// StageRebuild is the rewriting of the zip by SignV1, and by SignV2 when it aligns the file
+	// first; done and total count the compressed entry data copied.
+	StageRebuild = "rebuild"
+)
+
+// progressChunk is how many bytes are copied between two progress updates.
+const progressChunk = 4 << 20
+
+// progressCounter reports each increment of a running byte count for one stage. It is a no-op when
+// progress is nil.
+type progressCounter struct {
+	progress    ProgressFunc
+	stage       string
+	done, total int64
+}
+
+func (p *progressCounter) add(n int) {
+	p.done += int64(n)
+	if p.progress != nil {
+		p.progress(p.stage, p.done, p.total)
+	}
+}
+
+// write writes b to w, in progressChunk pieces if progress is reported.
+func (p *progressCounter) write(w io.Writer, b []byte) error {
+	step := len(b)
+	if p.progress != nil {
+		step = progressChunk
+	}
+	for len(b) > 0 {
+		n, err := w.Write(b[:min(len(b), step)])
+		p.add(n)
+		if err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package signv2
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// progressLog records the updates of one ProgressFunc per stage.
+type progressLog map[string][][2]int64
+
+func (l progressLog) record(stage string, done, total int64) {
+	l[stage] = append(l[stage], [2]int64{done, total})
+}
+
+// check verifies that the updates of stage increase and end at want, with a total of total.
+func (l progressLog) check(t *testing.T, stage string, want, total int64) {
+	t.Helper()
+	updates := l[stage]
+	if len(updates) < 2 {
+		t.Fatalf("%s: %d updates, want several", stage, len(updates))
+	}
+	for i, u := range updates {
+		if u[1] != total || (i > 0 && u[0] <= updates[i-1][0]) {
+			t.Fatalf("%s: bad update %d of %v", stage, i, updates)
+		}
+	}
+	if last := updates[len(updates)-1][0]; last != want {
+		t.Errorf("%s: ends at %d, want %d", stage, last, want)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	in := filepath.Join(t.TempDir(), "in.apk")
+	unsigned := testZip(t, "AndroidManifest.xml", "manifest", "assets/big.bin", string(bytes.Repeat([]byte{7}, 9<<20)))
+	if err := os.WriteFile(in, unsigned, 0644); err != nil {
+		t.Fatal(err)
+	}
+	z, err := OpenApkSignFile(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	log := progressLog{}
+	z.Progress = log.record
+	out := new(bytes.Buffer)
+	if err = z.SignV2To(out, testSigningCerts(t)); err != nil {
+		t.Fatal(err)
+	}
+	// the digest covers the zeros padding the files section to a page
+	digested := int64(len(unsigned)) + int64(filesPadding(z.cdOffset))
+	log.check(t, StageDigest, digested, digested)
+	log.check(t, StageWrite, int64(out.Len()), int64(out.Len()))
+
+	log = progressLog{}
+	out.Reset()
+	if err = SignStream(bytes.NewReader(unsigned), out, StreamConfig{Keys: testSigningCerts(t), Progress: log.record}); err != nil {
+		t.Fatal(err)
+	}
+	log.check(t, StageWrite, int64(out.Len()), 0)
+}
+
+func TestRebuildProgress(t *testing.T) {
+	unaligned := testUnaligned(t)
+	r, err := zip.NewReader(bytes.NewReader(unaligned), int64(len(unaligned)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var compressed, uncompressed int64
+	for _, f := range r.File {
+		compressed += int64(f.CompressedSize64)
+		uncompressed += int64(f.UncompressedSize64)
+	}
+
+	// SignV2 aligns the file before signing it
+	z, err := NewApkSign(unaligned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := progressLog{}
+	z.Progress = log.record
+	if _, err = z.SignV2(testSigningCerts(t)); err != nil {
+		t.Fatal(err)
+	}
+	log.check(t, StageRebuild, compressed, compressed)
+
+	log = progressLog{}
+	z.Progress = log.record
+	if _, err = z.SignV1(testSigningCerts(t)); err != nil {
+		t.Fatal(err)
+	}
+	log.check(t, StageDigest, uncompressed, uncompressed)
+	log.check(t, StageRebuild, compressed, compressed)
+}
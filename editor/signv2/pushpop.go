@@ -2,39 +2,15 @@ package signv2
 
 import (
 	"encoding/binary"
+	"fmt"
 )
 
-/* This idiom is very common in the v2 Android signing scheme:
-   val := binary.LittleEndian.Uint32(buf) // parse 4 bytes into a uint32
-	 buf = buf[4:]													// advance the buffer past the "consumed" bytes
-
-	 ...and same for uint64 values.
-
-	 It's not a lot of code but when it appears many times in succession it detracts from readability
-	 and is prone to typos and copy/paste bugs. So we wrap this in a few convenience functions to
-	 improve this. The compiler generally seems to inline calls to these.
+/* The v2 signing scheme and its successors encode everything as little-endian integers and
+   length-prefixed byte strings, nested several levels deep. Parsing is done with reader, which pops
+   values off the input and checks each length against the bytes left; encoding is done with the
+   push functions and concat below.
 */
 
-// pop32 returns the first 4 bytes of the input decoded into a uint32, and a new slice over the same
-// backing array, shifted past the 4 bytes. That is, it pops the first 4 bytes off the slice into a
-// uint32, and returns both.
-func pop32(in []byte) (uint32, []byte) {
-	return binary.LittleEndian.Uint32(in[:4]), in[4:]
-}
-
-// pop64 returns the first 8 bytes of the input decoded into a uint32, and a new slice over the same
-// backing array, shifted past the 8 bytes. That is, it pops the first 8 bytes off the slice into a
-// uint64, and returns both.
-func pop64(in []byte) (uint64, []byte) {
-	return binary.LittleEndian.Uint64(in[:8]), in[8:]
-}
-
-// popN pops the first `count` bytes of `in` into a new slice, and then returns it along with the
-// remainder.
-func popN(in []byte, count int) ([]byte, []byte) {
-	return in[:count], in[count:]
-}
-
 // push32 returns a new slice with new backing array that is identical to the input except with 4
 // additional bytes at its head. These 4 new bytes are populated with the uint32-encoded length of
 // the input array. That is, push32 returns a new slice (and array) prepended with a 4-byte length
@@ -75,3 +51,126 @@ func concat(blocks ...[]byte) []byte {
 	}
 	return out
 }
+
+// reader pops values off the formats of the signing schemes, checking every read against the bytes
+// left, so that lengths taken from a hostile file cannot slice out of range. The first failed read
+// records an error, located with locate at the structure that did not fit, and every later read
+// returns zero values: a parser reads a whole structure and checks err once, or before using what
+// it read to index anything else.
+//
+// Readers made by section read the sub-structures of a structure and share its error.
+type reader struct {
+	base []byte // the input of the top-level reader, which error offsets are relative to
+	buf  []byte // the bytes left
+	bad  error  // the error wrapped by errors about the encoding, such as ErrBadSigBlock
+	err  *error
+}
+
+// newReader returns a reader over b whose errors about the encoding wrap bad, if not nil.
+func newReader(b []byte, bad error) *reader {
+	return &reader{base: b, buf: b, bad: bad, err: new(error)}
+}
+
+// Err returns the error of the first failed read, or nil.
+func (r *reader) Err() error {
+	return *r.err
+}
+
+// len returns the number of bytes left, 0 once a read failed.
+func (r *reader) len() int {
+	if *r.err != nil {
+		return 0
+	}
+	return len(r.buf)
+}
+
+// fail records the malformed structure chunk at the bytes at, unless a read failed before.
+func (r *reader) fail(chunk string, at []byte, format string, args ...any) {
+	if *r.err != nil {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	err := fmt.Errorf("malformed %s - %s", chunk, msg)
+	if r.bad != nil {
+		err = fmt.Errorf("%w - %s: %s", r.bad, chunk, msg)
+	}
+	*r.err = locate(err, chunk, r.base, at)
+	r.buf = nil
+}
+
+// failErr records err from parsing the structure chunk at the bytes at, unless a read failed first.
+func (r *reader) failErr(chunk string, at []byte, err error) {
+	if *r.err == nil && err != nil {
+		*r.err = locate(err, chunk, r.base, at)
+		r.buf = nil
+	}
+}
+
+// next pops n bytes of the structure chunk.
+func (r *reader) next(chunk string, n uint64) []byte {
+	if *r.err != nil {
+		return nil
+	}
+	if n > uint64(len(r.buf)) {
+		r.fail(chunk, r.buf, "need %d bytes, %d left", n, len(r.buf))
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *reader) uint8(chunk string) uint8 {
+	if b := r.next(chunk, 1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *reader) uint32(chunk string) uint32 {
+	if b := r.next(chunk, 4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *reader) uint64(chunk string) uint64 {
+	if b := r.next(chunk, 8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+// prefixed pops a structure chunk preceded by its uint32 length. A length past the bytes left is
+// reported at the length field.
+func (r *reader) prefixed(chunk string) []byte {
+	at := r.buf
+	n := r.uint32(chunk)
+	if *r.err == nil && uint64(n) > uint64(len(r.buf)) {
+		r.fail(chunk, at, "length %d, %d bytes left", n, len(r.buf))
+		return nil
+	}
+	return r.next(chunk, uint64(n))
+}
+
+// section pops a structure chunk preceded by its uint32 length and returns a reader over it.
+func (r *reader) section(chunk string) *reader {
+	return r.sub(r.prefixed(chunk))
+}
+
+// sub returns a reader over b, a part of the input of r, sharing its error.
+func (r *reader) sub(b []byte) *reader {
+	return &reader{base: r.base, buf: b, bad: r.bad, err: r.err}
+}
+
+// rest pops all the bytes left.
+func (r *reader) rest() []byte {
+	return r.next("", uint64(r.len()))
+}
+
+// end records an error if bytes of the structure chunk are left unread.
+func (r *reader) end(chunk string) {
+	if r.len() > 0 {
+		r.fail(chunk, r.buf, "%d trailing bytes", len(r.buf))
+	}
+}
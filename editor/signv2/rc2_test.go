@@ -0,0 +1,39 @@
+package signv2
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestRC2(t *testing.T) {
+	// test vectors of RFC 2268
+	for _, tc := range []struct {
+		key       string
+		effective int
+		plain     string
+		cipher    string
+	}{
+		{"0000000000000000", 63, "0000000000000000", "ebb773f993278eff"},
+		{"ffffffffffffffff", 64, "ffffffffffffffff", "278b27e42e2f0d49"},
+		{"3000000000000000", 64, "1000000000000001", "30649edf9be7d2c2"},
+		{"88", 64, "0000000000000000", "61a8a244adacccf0"},
+		{"88bca90e90875a", 64, "0000000000000000", "6ccf4308974c267f"},
+		{"88bca90e90875a7f0f79c384627bafb2", 64, "0000000000000000", "1a807d272bbe5db1"},
+		{"88bca90e90875a7f0f79c384627bafb2", 128, "0000000000000000", "2269552ab0f85ca6"},
+	} {
+		key, _ := hex.DecodeString(tc.key)
+		plain, _ := hex.DecodeString(tc.plain)
+		want, _ := hex.DecodeString(tc.cipher)
+		c := newRC2(key, tc.effective)
+		got := make([]byte, 8)
+		c.Encrypt(got, plain)
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s/%d: Encrypt = %x, want %x", tc.key, tc.effective, got, want)
+		}
+		c.Decrypt(got, want)
+		if !bytes.Equal(got, plain) {
+			t.Errorf("%s/%d: Decrypt = %x, want %x", tc.key, tc.effective, got, plain)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package signv2
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"runtime/debug"
+	"slices"
+	"time"
+)
+
+// modulePath is the module this package belongs to, looked up in the build info for the tool
+// version of a SigningReport.
+const modulePath = "github.com/pzx521521/apk-editor/editor"
+
+// Artifact identifies a file by name and digest, in the layout of an in-toto statement subject so
+// that a report can feed SLSA provenance as is.
+type Artifact struct {
+	Name   string            `json:"name,omitempty"`
+	Size   int64             `json:"size"`
+	Digest map[string]string `json:"digest"` // 算法名 -> 十六进制摘要, 目前只有 sha256
+}
+
+// NewArtifact reads r to the end and returns its size and SHA-256 digest.
+func NewArtifact(name string, r io.Reader) (Artifact, error) {
+	h := sha256.New()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return Artifact{}, err
+	}
+	return Artifact{Name: name, Size: n, Digest: map[string]string{"sha256": hex.EncodeToString(h.Sum(nil))}}, nil
+}
+
+// SignerReport describes the certificate of one signer.
+type SignerReport struct {
+	Subject            string    `json:"subject"`
+	Issuer             string    `json:"issuer"`
+	SerialNumber       string    `json:"serial_number"`
+	SHA256             string    `json:"sha256"` // 证书 DER 的 SHA-256 指纹
+	PublicKeyAlgorithm string    `json:"public_key_algorithm"`
+	NotBefore          time.Time `json:"not_before"`
+	NotAfter           time.Time `json:"not_after"`
+	Schemes            []Scheme  `json:"schemes"` // 该证书签名的方案
+}
+
+// SigningReport is a machine-readable record of a signing operation, meant to be attached to
+// release records: what went in, what came out, and who signed it with which schemes.
+type SigningReport struct {
+	Tool      string         `json:"tool"`
+	Version   string         `json:"version"`
+	Timestamp time.Time      `json:"timestamp"`
+	Input     Artifact       `json:"input"`
+	Output    Artifact       `json:"output"`
+	Schemes   []Scheme       `json:"schemes"` // 输出中验证通过的方案
+	Signers   []SignerReport `json:"signers"`
+}
+
+// NewSigningReport reports on signed, the output of signing input. The schemes and signers are
+// those VerifyAll finds verified in signed, so a report is never issued for a signature that does
+// not check out: the error of the verification is returned instead.
+func NewSigningReport(input, output Artifact, signed *ApkSign) (*SigningReport, error) {
+	r := signed.VerifyAll(VerifyOptions{})
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
+	report := &SigningReport{
+		Tool:      modulePath,
+		Version:   toolVersion(),
+		Timestamp: time.Now().UTC(),
+		Input:     input,
+		Output:    output,
+		Schemes:   []Scheme{},
+		Signers:   []SignerReport{},
+	}
+	for _, sr := range r.Schemes {
+		if sr.Verified {
+			report.Schemes = append(report.Schemes, sr.Scheme)
+		}
+	}
+	for _, s := range r.Signers {
+		if len(s.Certs) == 0 {
+			continue
+		}
+		cert := s.Certs[0]
+		sum := sha256.Sum256(cert.Raw)
+		fp := hex.EncodeToString(sum[:])
+		i := slices.IndexFunc(report.Signers, func(sr SignerReport) bool { return sr.SHA256 == fp })
+		if i < 0 {
+			report.Signers = append(report.Signers, SignerReport{
+				Subject:            cert.Subject.String(),
+				Issuer:             cert.Issuer.String(),
+				SerialNumber:       cert.SerialNumber.String(),
+				SHA256:             fp,
+				PublicKeyAlgorithm: cert.PublicKeyAlgorithm.String(),
+				NotBefore:          cert.NotBefore.UTC(),
+				NotAfter:           cert.NotAfter.UTC(),
+			})
+			i = len(report.Signers) - 1
+		}
+		if !slices.Contains(report.Signers[i].Schemes, s.Scheme) {
+			report.Signers[i].Schemes = append(report.Signers[i].Schemes, s.Scheme)
+		}
+	}
+	return report, nil
+}
+
+// toolVersion returns the version of this module in the running binary, "(devel)" when it is
+// built from a checkout.
+func toolVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "(devel)"
+	}
+	mods := append([]*debug.Module{&info.Main}, info.Deps...)
+	for _, m := range mods {
+		if m.Path != modulePath {
+			continue
+		}
+		if m.Replace != nil && m.Replace.Version != "" {
+			return m.Replace.Version
+		}
+		if m.Version != "" && m.Replace == nil {
+			return m.Version
+		}
+	}
+	return "(devel)"
+}
@@ -0,0 +1,58 @@
+package signv2
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestSigningReport(t *testing.T) {
+	unsigned := testZip(t, "AndroidManifest.xml", "manifest", "classes.dex", "dex")
+	z, err := NewApkSign(unsigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := z.SignV2(testSigningCerts(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, err := NewArtifact("app.apk", bytes.NewReader(unsigned))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ := NewArtifact("app-signed.apk", bytes.NewReader(signed))
+	sz, err := NewApkSign(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := NewSigningReport(in, out, sz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(unsigned)
+	if report.Input.Digest["sha256"] != hex.EncodeToString(sum[:]) || report.Output.Size != int64(len(signed)) {
+		t.Errorf("artifacts %+v, %+v", report.Input, report.Output)
+	}
+	if !slices.Contains(report.Schemes, SchemeV2) || len(report.Signers) != 1 || !slices.Contains(report.Signers[0].Schemes, SchemeV2) {
+		t.Fatalf("schemes %v, signers %+v", report.Schemes, report.Signers)
+	}
+	if report.Signers[0].SHA256 == "" || report.Signers[0].PublicKeyAlgorithm != "RSA" {
+		t.Errorf("signer %+v", report.Signers[0])
+	}
+	b, err := json.Marshal(report)
+	if err != nil || !bytes.Contains(b, []byte(`"schemes":["v2"`)) {
+		t.Errorf("json %s, %v", b, err)
+	}
+
+	// 签名无效时不出报告
+	sz, err = NewApkSign(unsigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewSigningReport(in, in, sz); err == nil {
+		t.Error("report for an unsigned file")
+	}
+}
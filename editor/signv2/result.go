@@ -0,0 +1,135 @@
+package signv2
+
+import (
+	"encoding/binary"
+	"slices"
+	"strings"
+)
+
+// ZipKind classifies a parsed archive by its entries.
+type ZipKind int
+
+const (
+	// KindZip is any other zip file.
+	KindZip ZipKind = iota
+	// KindJAR has a META-INF/MANIFEST.MF but is not an APK.
+	KindJAR
+	// KindAPK has classes.dex, AndroidManifest.xml and resources.arsc, see ApkSign.IsAPK.
+	KindAPK
+	// KindAAB is an Android App Bundle: it has a BundleConfig.pb or a base module manifest. Bundles
+	// are signed with v1 only, as jarsigner does.
+	KindAAB
+	// KindAPKSet holds APKs, as the .apks, .xapk and .apkm archives of split APKs do. Its APKs are
+	// signed one by one, see the splits package.
+	KindAPKSet
+)
+
+func (k ZipKind) String() string {
+	switch k {
+	case KindJAR:
+		return "jar"
+	case KindAPK:
+		return "apk"
+	case KindAAB:
+		return "aab"
+	case KindAPKSet:
+		return "apks"
+	}
+	return "zip"
+}
+
+// MarshalText encodes the kind as its String, so it reads well in JSON.
+func (k ZipKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// ParseResult summarizes what parsing found, so that callers need not re-derive it from the raw
+// bytes. Nothing in it has been verified.
+type ParseResult struct {
+	Kind ZipKind `json:"kind"`
+	// Schemes lists the signature schemes whose data is present, in the order v1, v2, v3, v3.1.
+	// v4 is not listed since its signature lives in a separate .idsig file.
+	Schemes []Scheme `json:"schemes"`
+	// Entries is the number of entries in the Central Directory.
+	Entries int   `json:"entries"`
+	Size    int64 `json:"size"`
+	// SigningBlockOffset and SigningBlockSize locate the APK Signing Block, including its size
+	// fields and magic. Both are 0 if there is none.
+	SigningBlockOffset int64 `json:"signing_block_offset"`
+	SigningBlockSize   int64 `json:"signing_block_size"`
+	CDOffset           int64 `json:"cd_offset"`
+	// EOCDOffset is that of the Zip64 end of central directory record if Zip64 is set, as the Zip64
+	// records and the EOCD are signed as one.
+	EOCDOffset int64 `json:"eocd_offset"`
+	Zip64      bool  `json:"zip64,omitempty"`
+	// Quirks describes the deviations from the spec WithLenientZip tolerated. The offsets above
+	// are those of the archive with the offending data dropped.
+	Quirks []string `json:"quirks,omitempty"`
+}
+
+// HasScheme reports whether data for scheme s is present.
+func (r *ParseResult) HasScheme(s Scheme) bool {
+	return slices.Contains(r.Schemes, s)
+}
+
+// ParseResult returns what parsing found. The result is a copy and may be modified.
+func (apkSign *ApkSign) ParseResult() ParseResult {
+	r := apkSign.parsed
+	r.Schemes = slices.Clone(r.Schemes)
+	r.Quirks = slices.Clone(r.Quirks)
+	return r
+}
+
+// setParsed fills in parsed from the offsets and flags found by parsing. entries lists the names in
+// the Central Directory, or is nil to keep the current kind and count, when only the signing block
+// changed.
+func (apkSign *ApkSign) setParsed(entries []string) {
+	r := &apkSign.parsed
+	if entries != nil {
+		r.Entries = len(entries)
+		r.Kind = KindZip
+		switch {
+		case apkSign.IsAPK:
+			r.Kind = KindAPK
+		case containsName(entries, "BundleConfig.pb") || containsName(entries, "base/manifest/AndroidManifest.xml"):
+			r.Kind = KindAAB
+		case slices.ContainsFunc(entries, func(name string) bool { return strings.HasSuffix(name, ".apk") }):
+			r.Kind = KindAPKSet
+		case containsName(entries, "META-INF/MANIFEST.MF"):
+			r.Kind = KindJAR
+		}
+	}
+	r.Size = apkSign.size
+	r.CDOffset = int64(apkSign.cdOffset)
+	r.EOCDOffset = int64(apkSign.eocdOffset)
+	r.Zip64 = binary.LittleEndian.Uint32(apkSign.section(apkSign.eocdOffset, apkSign.eocdOffset+4)) == zip64RecordMagic
+	r.SigningBlockOffset, r.SigningBlockSize = 0, 0
+	r.Schemes = nil
+	if apkSign.IsV1Signed {
+		r.Schemes = append(r.Schemes, SchemeV1)
+	}
+	if !apkSign.IsV2Signed {
+		return
+	}
+	r.SigningBlockOffset = int64(apkSign.asv2Offset)
+	r.SigningBlockSize = int64(apkSign.cdOffset - apkSign.asv2Offset)
+	// a malformed block is reported when a scheme is verified, not here
+	pairs, _ := apkSign.signingBlockPairs()
+	for _, s := range []struct {
+		scheme Scheme
+		id     uint32
+	}{{SchemeV2, BlockIDV2}, {SchemeV3, BlockIDV3}, {SchemeV31, BlockIDV31}} {
+		if slices.ContainsFunc(pairs, func(p *Pair) bool { return p.ID == s.id }) {
+			r.Schemes = append(r.Schemes, s.scheme)
+		}
+	}
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package signv2
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// SelfCheckEnv, when set to a non-empty value other than "0", turns on self-checking for every
+// signing operation regardless of ApkSign.SelfCheck.
+const SelfCheckEnv = "APKEDITOR_SELF_CHECK"
+
+// SelfCheckEnabled reports whether self-checking is forced on by the environment.
+func SelfCheckEnabled() bool {
+	v := os.Getenv(SelfCheckEnv)
+	return v != "" && v != "0"
+}
+
+// SelfCheckError is returned when an operation produced output that does not parse or verify. It
+// always indicates a bug in this package rather than a problem with the input.
+type SelfCheckError struct {
+	Op  string
+	Err error
+}
+
+func (e *SelfCheckError) Error() string {
+	return fmt.Sprintf("self-check after %s failed: %v", e.Op, e.Err)
+}
+
+func (e *SelfCheckError) Unwrap() error { return e.Err }
+
+// CheckZip re-reads apk with the standard library zip reader and decompresses every entry, so
+// broken offsets, sizes or CRCs are caught before the file leaves the process.
+func CheckZip(apk []byte) (err error) {
+	defer catch(&err, "CheckZip", "file")
+	return checkZip(apk, false)
+}
+
+// checkZip implements CheckZip. With generic set, entries compressed with a method the standard
+// library cannot decompress, such as zstd, are skipped instead of failing the check.
+func checkZip(apk []byte, generic bool) error {
+	r, err := zip.NewReader(bytes.NewReader(apk), int64(len(apk)))
+	if err != nil {
+		return err
+	}
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if generic && errors.Is(err, zip.ErrAlgorithm) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+		_, err = io.Copy(io.Discard, rc)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+	}
+	return nil
+}
+
+// CheckSigned runs CheckZip and then parses and verifies the v2 signature of apk.
+func CheckSigned(apk []byte) (err error) {
+	defer catch(&err, "CheckSigned", "file")
+	return checkSigned(apk, false)
+}
+
+// checkSigned implements CheckSigned, parsing apk WithGenericZip if generic is set.
+func checkSigned(apk []byte, generic bool) error {
+	if err := checkZip(apk, generic); err != nil {
+		return err
+	}
+	var opts []Option
+	if generic {
+		opts = append(opts, WithGenericZip())
+	}
+	z, err := NewApkSignNoCopy(apk, opts...)
+	if err != nil {
+		return err
+	}
+	return z.VerifyV2()
+}
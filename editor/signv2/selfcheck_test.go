@@ -0,0 +1,27 @@
+package signv2
+
+import "testing"
+
+func TestSelfCheck(t *testing.T) {
+	z, err := NewApkSign(testZip(t, "AndroidManifest.xml", "manifest", "classes.dex", "dex"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	z.SelfCheck = true
+	signed, err := z.SignV2(testSigningCerts(t))
+	if err != nil {
+		t.Fatalf("SignV2 with SelfCheck: %v", err)
+	}
+	if err = CheckSigned(signed); err != nil {
+		t.Fatal(err)
+	}
+
+	// corrupt the data of the first entry, the CRC check must fail
+	signed[31+len("AndroidManifest.xml")] ^= 0xff
+	if err = CheckZip(signed); err == nil {
+		t.Error("CheckZip accepted a corrupted entry")
+	}
+	if err = CheckSigned(signed); err == nil {
+		t.Error("CheckSigned accepted a corrupted entry")
+	}
+}
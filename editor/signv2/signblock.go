@@ -0,0 +1,284 @@
+package signv2
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/binary"
+	"fmt"
+	"slices"
+)
+
+// IDs of the ID-value pairs found in the APK Signing Block.
+const (
+	BlockIDV2      uint32 = 0x7109871a
+	BlockIDV3      uint32 = 0xf05368c0
+	BlockIDV31     uint32 = 0x1b93ad61
+	BlockIDPadding uint32 = 0x42726577
+	// BlockIDFrosting is added by Google Play; it signs the APK contents with a Play key.
+	BlockIDFrosting uint32 = 0x2146444e
+	// BlockIDDependencyInfo holds the encrypted dependency metadata AGP embeds for Play.
+	BlockIDDependencyInfo uint32 = 0x504b4453
+	// BlockIDWalle holds the channel of Walle (Meituan's channel packaging tool) as a JSON object
+	// such as {"channel":"meituan"}.
+	BlockIDWalle uint32 = 0x71777777
+)
+
+// Limits enforced while parsing, so that a hostile upload cannot make a verifier allocate or hash
+// without bound. Real APKs stay far below them.
+const (
+	// MaxSigningBlockSize is the largest APK Signing Block accepted.
+	MaxSigningBlockSize = 64 << 20
+	// MaxSigners is the most signers a v2 or v3 block may list, the same limit apksig uses.
+	MaxSigners = 10
+	// MaxCertificates is the most certificates a signer, or a signing certificate lineage, may hold.
+	MaxCertificates = 64
+)
+
+// preservableBlocks are the pairs kept by PreserveBlocks. They stay valid as long as the content they
+// describe is unchanged, which the v2 content digest does not depend on; the SourceStamp signs the
+// content digests, so it also needs the new signers to use the same digest algorithms.
+var preservableBlocks = []uint32{BlockIDFrosting, BlockIDDependencyInfo, BlockIDSourceStampV2}
+
+// blockNames names the pair IDs seen in the wild, including those added by app stores and build
+// tools rather than by the signing schemes.
+var blockNames = map[uint32]string{
+	BlockIDV2:             "APK Signature Scheme v2",
+	BlockIDV3:             "APK Signature Scheme v3",
+	BlockIDV31:            "APK Signature Scheme v3.1",
+	BlockIDPadding:        "verity padding",
+	BlockIDSourceStampV1:  "source stamp v1",
+	BlockIDSourceStampV2:  "source stamp v2",
+	BlockIDDependencyInfo: "dependency metadata",
+	BlockIDFrosting:       "Google Play frosting",
+	BlockIDTimestamp:      "RFC 3161 timestamp",
+	BlockIDWalle:          "Walle channel info",
+}
+
+// BlockName returns a human readable name for a signing block pair ID, or "" if it is unknown.
+func BlockName(id uint32) string {
+	return blockNames[id]
+}
+
+// Pair is one ID-value pair of the APK Signing Block.
+type Pair struct {
+	ID    uint32
+	Value []byte
+}
+
+// encode returns the pair as it is stored in the signing block: a uint64 length, the ID and the value.
+func (p *Pair) encode() []byte {
+	value := push32(p.Value)
+	binary.LittleEndian.PutUint32(value, p.ID)
+	return push64(value)
+}
+
+// encodeSigningBlock wraps the encoded pairs into an APK Signing Block, framed by its size fields and
+// magic.
+func encodeSigningBlock(pairs []byte) []byte {
+	size := len(pairs) + 8 + 16   // size is key/value portion + uint64 footer size + 16-byte footer magic string
+	block := make([]byte, size+8) // need another uint64 to prepend another copy of size
+	binary.LittleEndian.PutUint64(block[:8], uint64(size))
+	copy(block[8:], pairs)
+	binary.LittleEndian.PutUint64(block[8+len(pairs):], uint64(size))
+	copy(block[len(block)-16:], "APK Sig Block 42")
+	return block
+}
+
+// ParseSigningBlock splits the contents of an APK Signing Block (everything between the leading
+// size field and the trailing size field) into its ID-value pairs.
+func ParseSigningBlock(block []byte) (_ []*Pair, err error) {
+	defer catch(&err, "ParseSigningBlock", "signing block")
+	var pairs []*Pair
+	r := newReader(block, ErrBadSigBlock)
+	for r.len() > 0 {
+		pair := r.buf
+		size := r.uint64("signing block pair")
+		if r.Err() == nil && (size < 4 || size > uint64(len(r.buf))) {
+			r.fail("signing block pair", pair, "bad pair length %d", size)
+		}
+		value := r.sub(r.next("signing block pair", size))
+		id := value.uint32("signing block pair")
+		pairs = append(pairs, &Pair{id, value.rest()})
+	}
+	if err = r.Err(); err != nil {
+		return nil, err
+	}
+	return pairs, nil
+}
+
+// signingBlockPairs is ParseSigningBlock for the signing block of the file, with file offsets in errors.
+func (apkSign *ApkSign) signingBlockPairs() ([]*Pair, error) {
+	pairs, err := ParseSigningBlock(apkSign.rawASv2)
+	return pairs, apkSign.inBlock(err)
+}
+
+// findPair returns the value of the pair with the given ID, or nil if there is none. More than one
+// pair with the same ID is treated as an attack on the verifier and rejected.
+func findPair(pairs []*Pair, id uint32) ([]byte, error) {
+	var found []byte
+	for _, p := range pairs {
+		if p.ID != id {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("duplicate signing block ID %#x", id)
+		}
+		found = p.Value
+	}
+	return found, nil
+}
+
+// GetBlock returns the value of the pair id of the signing block, or nil if there is no such pair.
+// A file without a signing block gives ErrNotV2Signed.
+func (apkSign *ApkSign) GetBlock(id uint32) (_ []byte, err error) {
+	defer catch(&err, "GetBlock", "signing block")
+	if apkSign.rawASv2 == nil {
+		return nil, ErrNotV2Signed
+	}
+	pairs, err := apkSign.signingBlockPairs()
+	if err != nil {
+		return nil, err
+	}
+	return findPair(pairs, id)
+}
+
+// SetBlock returns the file with the pair id of its signing block set to data, added after the
+// other pairs if there was none, or removed if data is nil, as app market channel tools such as
+// Walle do. The signature schemes do not cover the signing block, and their content digests do
+// not depend on its size, so the v1, v2 and v3 signatures stay valid; a v4 signature, which covers
+// the whole file, does not. The block ends with a verity padding pair sized to keep it a multiple of
+// 4096 bytes, whether or not it had one before.
+//
+// The pairs of the signature schemes and the padding cannot be set. Re-signing the file drops the
+// pair unless a BlockProcessor produces it.
+func (apkSign *ApkSign) SetBlock(id uint32, data []byte) (_ []byte, err error) {
+	defer catch(&err, "SetBlock", "signing block")
+	if slices.Contains(schemeBlocks, id) {
+		return nil, fmt.Errorf("signing block pair %#08x is written by the signer", id)
+	}
+	if apkSign.rawASv2 == nil {
+		return nil, ErrNotV2Signed
+	}
+	pairs, err := apkSign.signingBlockPairs()
+	if err != nil {
+		return nil, err
+	}
+	return apkSign.injected(setPair(pairs, id, data))
+}
+
+// setPair returns the signing block of pairs with the pair id set to data as SetBlock describes.
+func setPair(pairs []*Pair, id uint32, data []byte) []byte {
+	var out [][]byte
+	set := data == nil
+	for _, p := range pairs {
+		switch {
+		case p.ID == BlockIDPadding:
+		case p.ID != id:
+			out = append(out, p.encode())
+		case !set:
+			out = append(out, (&Pair{id, data}).encode())
+			set = true
+		}
+	}
+	if !set {
+		out = append(out, (&Pair{id, data}).encode())
+	}
+	return paddedSigningBlock(concat(out...))
+}
+
+// EncodeSigningBlock returns an APK Signing Block holding pairs, followed by a verity padding pair
+// as the signers write it, ready to be inserted before the Central Directory with
+// ApkSign.InjectBeforeCDApk. ParseSigningBlock parses it back without its size fields and magic.
+func EncodeSigningBlock(pairs ...*Pair) []byte {
+	var encoded [][]byte
+	for _, p := range pairs {
+		encoded = append(encoded, p.encode())
+	}
+	return paddedSigningBlock(concat(encoded...))
+}
+
+// paddedSigningBlock is encodeSigningBlock for pairs without a verity padding pair: one is added
+// last to make the block a multiple of 4096 bytes, as apksigner does. With the files section padded
+// by filesPadding, the Central Directory then starts on a page boundary as well, which fs-verity
+// and some OEM verifiers rely on.
+func paddedSigningBlock(pairs []byte) []byte {
+	return encodeSigningBlock(concat(pairs, paddingPair(len(pairs))))
+}
+
+// paddingPair returns the verity padding pair that makes a signing block with n bytes of other pairs
+// a multiple of 4096 bytes, as apksigner writes it.
+func paddingPair(n int) []byte {
+	// the block adds 8 bytes of size before the pairs and 24 of size and magic after them, and the
+	// padding pair 12 bytes of length and ID
+	size := n + 8 + 24 + 12
+	pad := (4096 - size%4096) % 4096
+	return (&Pair{BlockIDPadding, make([]byte, pad)}).encode()
+}
+
+// filesPadding returns the number of zero bytes the signers write after a files section ending at
+// end, so that the signing block starts on a 4096 byte page as apksigner lays it out. The zeros are
+// part of the files section, and so of the content digest.
+func filesPadding(end uint64) uint64 {
+	return (4096 - end%4096) % 4096
+}
+
+// preservedPairs returns the encoded pairs of the current signing block to carry over into a new one,
+// when PreserveBlocks is set, leaving out those the processors ps produce. digest returns the content
+// digest of the contents being signed as the current signers computed it, i.e. without the padding
+// the new block may add; if it differs from theirs, the pairs are stale and ErrContentModified is
+// returned.
+func (apkSign *ApkSign) preservedPairs(ps []BlockProcessor, digest func(crypto.Hash) ([]byte, error)) ([]byte, error) {
+	if !apkSign.PreserveBlocks || apkSign.rawASv2 == nil {
+		return nil, nil
+	}
+	pairs, err := apkSign.signingBlockPairs()
+	if err != nil {
+		return nil, err
+	}
+	var out [][]byte
+	for _, p := range pairs {
+		if slices.ContainsFunc(ps, func(bp BlockProcessor) bool { return bp.BlockID() == p.ID }) {
+			continue
+		}
+		for _, id := range preservableBlocks {
+			if p.ID == id {
+				out = append(out, p.encode())
+			}
+		}
+	}
+	if len(out) > 0 {
+		if err := apkSign.checkUnmodified(digest); err != nil {
+			return nil, err
+		}
+	}
+	return concat(out...), nil
+}
+
+// checkUnmodified compares the first content digest of a known algorithm the first v2 signer, or v3
+// signer for files without a v2 block, recorded with digest.
+func (apkSign *ApkSign) checkUnmodified(digest func(crypto.Hash) ([]byte, error)) error {
+	var signers []*Signer
+	if v2, err := ParseV2Block(apkSign.rawASv2); err == nil {
+		signers = v2.Signers
+	} else if v3, err := ParseV3Block(apkSign.rawASv2, BlockIDV3); err == nil && v3 != nil {
+		signers = v3.Signers
+	}
+	if len(signers) == 0 {
+		return fmt.Errorf("%w: no v2 or v3 signer to check the preserved blocks against", ErrContentModified)
+	}
+	for _, d := range signers[0].SignedData.Digests {
+		hash, ok := AlgorithmID(d.AlgorithmID).hash()
+		if !ok {
+			continue
+		}
+		sum, err := digest(hash)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(sum, d.Digest) {
+			return ErrContentModified
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: no content digest with a known algorithm", ErrContentModified)
+}
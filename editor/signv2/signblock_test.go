@@ -0,0 +1,231 @@
+package signv2
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"errors"
+	"testing"
+)
+
+func TestPreserveBlocks(t *testing.T) {
+	z, err := NewApkSign(testSignedApk(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, _ := findPair(mustPairs(t, z), BlockIDV2)
+	frosting := []byte("play frosting")
+	z = testWithSigningBlock(t, z, &Pair{BlockIDV2, v2}, &Pair{BlockIDFrosting, frosting}, &Pair{0x1234, []byte("other")})
+
+	for _, preserve := range []bool{true, false} {
+		z.PreserveBlocks = preserve
+		signed, err := z.SignV2(testSigningCerts(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resigned, err := NewApkSign(signed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pairs := mustPairs(t, resigned)
+		got, _ := findPair(pairs, BlockIDFrosting)
+		if preserve != bytes.Equal(got, frosting) {
+			t.Errorf("preserve=%v: frosting = %q", preserve, got)
+		}
+		if other, _ := findPair(pairs, 0x1234); other != nil {
+			t.Errorf("preserve=%v: unknown block carried over", preserve)
+		}
+		r := resigned.VerifyAll(VerifyOptions{})
+		if !r.Verified || r.HasBlock(BlockIDFrosting) != preserve {
+			t.Errorf("preserve=%v: verified %v, frosting reported %v", preserve, r.Verified, r.HasBlock(BlockIDFrosting))
+		}
+	}
+}
+
+// TestPreserveBlocksModified checks that blocks describing the original contents are not carried
+// over onto edited ones, and that the padding of the new block does not count as an edit.
+func TestPreserveBlocksModified(t *testing.T) {
+	frosting := &Pair{BlockIDFrosting, []byte("play frosting")}
+	sign := map[string]func(z *ApkSign) ([]byte, error){
+		"v2": func(z *ApkSign) ([]byte, error) { return z.SignV2(testSigningCerts(t)) },
+		"v3": func(z *ApkSign) ([]byte, error) { return z.SignV3(testSigningCerts(t), nil) },
+		"stream": func(z *ApkSign) ([]byte, error) {
+			out := new(bytes.Buffer)
+			err := SignStream(bytes.NewReader(z.raw), out, StreamConfig{Keys: testSigningCerts(t), PreserveBlocks: true})
+			return out.Bytes(), err
+		},
+	}
+
+	z, err := NewApkSign(testSignedApk(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, _ := findPair(mustPairs(t, z), BlockIDV2)
+	z = testWithSigningBlock(t, z, &Pair{BlockIDV2, v2}, frosting)
+	// an edit that leaves a valid zip: the modification time of the first entry
+	edited := bytes.Clone(z.raw)
+	edited[10] ^= 0xff
+	if z, err = NewApkSign(edited); err != nil {
+		t.Fatal(err)
+	}
+	z.PreserveBlocks = true
+	for name, sign := range sign {
+		if _, err := sign(z); !errors.Is(err, ErrContentModified) {
+			t.Errorf("%s: got %v, want ErrContentModified", name, err)
+		}
+	}
+	z.PreserveBlocks = false
+	if _, err = z.SignV2(testSigningCerts(t)); err != nil {
+		t.Errorf("without PreserveBlocks: %v", err)
+	}
+
+	// a file signed without padding by an older signer
+	if z, err = NewApkSign(testZip(t, "AndroidManifest.xml", "manifest", "classes.dex", "dex")); err != nil {
+		t.Fatal(err)
+	}
+	if filesPadding(z.cdOffset) == 0 {
+		t.Fatal("test file already ends its files section on a page")
+	}
+	keys := testSigningCerts(t)
+	if err = keys[0].Resolve(); err != nil {
+		t.Fatal(err)
+	}
+	digest := func(h crypto.Hash) ([]byte, error) { return z.contentDigest(context.Background(), h) }
+	block, err := (&V2Block{}).buildSigningBlock(keys, digest, frosting.encode(), options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if z, err = z.InjectBeforeCDApk(block); err != nil {
+		t.Fatal(err)
+	}
+	z.PreserveBlocks = true
+	for name, sign := range sign {
+		signed, err := sign(z)
+		if err != nil {
+			t.Fatalf("unpadded %s: %v", name, err)
+		}
+		resigned, err := NewApkSign(signed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := findPair(mustPairs(t, resigned), BlockIDFrosting); !bytes.Equal(got, frosting.Value) {
+			t.Errorf("unpadded %s: frosting = %q", name, got)
+		}
+	}
+}
+
+func TestSetBlock(t *testing.T) {
+	z, err := NewApkSign(testSignedApk(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	channel := []byte(`{"channel":"meituan"}`)
+	for _, value := range [][]byte{channel, []byte(`{"channel":"huawei"}`), nil} {
+		out, err := z.SetBlock(BlockIDWalle, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if z, err = NewApkSign(out); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := z.GetBlock(BlockIDWalle); err != nil || !bytes.Equal(got, value) {
+			t.Errorf("GetBlock = %q, %v, want %q", got, err, value)
+		}
+		if r := z.VerifyAll(VerifyOptions{}); !r.Verified {
+			t.Fatalf("%q: not verified: %v", value, r.Errors())
+		}
+	}
+	if pairs := mustPairs(t, z); len(pairs) != 2 || pairs[1].ID != BlockIDPadding {
+		t.Errorf("pairs left after removing the channel: %v", pairs)
+	}
+
+	// the padding pair stays last and keeps the block a multiple of 4096 bytes
+	v2, _ := findPair(mustPairs(t, z), BlockIDV2)
+	z = testWithSigningBlock(t, z, &Pair{BlockIDV2, v2}, &Pair{BlockIDPadding, nil})
+	out, err := z.SetBlock(BlockIDWalle, channel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if z, err = NewApkSign(out); err != nil {
+		t.Fatal(err)
+	}
+	pairs := mustPairs(t, z)
+	if len(pairs) != 3 || pairs[1].ID != BlockIDWalle || pairs[2].ID != BlockIDPadding {
+		t.Errorf("pairs %v", pairs)
+	}
+	if n := len(z.rawASv2) + 8 + 24; n%4096 != 0 {
+		t.Errorf("padded signing block of %d bytes", n)
+	}
+	if err = z.VerifyV2(); err != nil {
+		t.Error(err)
+	}
+
+	if _, err = z.SetBlock(BlockIDV2, channel); err == nil {
+		t.Error("SetBlock replaced the v2 signature")
+	}
+	unsigned, err := NewApkSign(testZip(t, "classes.dex", "dex"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = unsigned.SetBlock(BlockIDWalle, channel); !errors.Is(err, ErrNotV2Signed) {
+		t.Errorf("unsigned file gave %v", err)
+	}
+}
+
+func TestSigningBlockLayout(t *testing.T) {
+	unsigned := testZip(t, "AndroidManifest.xml", "manifest", "assets/big.bin", string(bytes.Repeat([]byte{7}, 3<<20)))
+	cache := NewDigestCache()
+	z, err := NewApkSign(unsigned, WithDigestCache(cache))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filesPadding(z.cdOffset) == 0 {
+		t.Fatal("test file already ends its files section on a page")
+	}
+	windowed, err := NewApkSignFromReaderAt(bytes.NewReader(unsigned), int64(len(unsigned)), WithWindow(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outputs := map[string][]byte{}
+	if outputs["v2"], err = z.SignV2(testSigningCerts(t)); err != nil {
+		t.Fatal(err)
+	}
+	if outputs["v3"], err = z.SignV3(testSigningCerts(t), nil); err != nil {
+		t.Fatal(err)
+	}
+	for name, sign := range map[string]func(w *bytes.Buffer) error{
+		"SignV2To": func(w *bytes.Buffer) error { return z.SignV2To(w, testSigningCerts(t)) },
+		"windowed": func(w *bytes.Buffer) error { return windowed.SignV2To(w, testSigningCerts(t)) },
+		"stream": func(w *bytes.Buffer) error {
+			return SignStream(bytes.NewReader(unsigned), w, StreamConfig{Keys: testSigningCerts(t)})
+		},
+	} {
+		w := new(bytes.Buffer)
+		if err = sign(w); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		outputs[name] = w.Bytes()
+	}
+	if !bytes.Equal(outputs["SignV2To"], outputs["v2"]) || !bytes.Equal(outputs["windowed"], outputs["v2"]) {
+		t.Error("streamed output differs from SignV2")
+	}
+
+	// the signing block and the Central Directory start on a page, and the block ends with the
+	// verity padding
+	for name, out := range outputs {
+		signed, err := NewApkSign(out, WithDigestCache(cache))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if signed.asv2Offset%4096 != 0 || signed.cdOffset%4096 != 0 {
+			t.Errorf("%s: signing block at %d, Central Directory at %d", name, signed.asv2Offset, signed.cdOffset)
+		}
+		if pairs := mustPairs(t, signed); pairs[len(pairs)-1].ID != BlockIDPadding {
+			t.Errorf("%s: last pair %#08x, want the verity padding", name, pairs[len(pairs)-1].ID)
+		}
+		if r := signed.VerifyAll(VerifyOptions{}); !r.Verified {
+			t.Errorf("%s: not verified: %v", name, r.Errors())
+		}
+	}
+}
@@ -0,0 +1,358 @@
+package signv2
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+
+	forkzip "github.com/pzx521521/apk-editor/editor/zip"
+)
+
+// Signing block IDs of the SourceStamp, a signature Play adds to mark builds it distributed.
+const (
+	BlockIDSourceStampV1 uint32 = 0x2b09189e
+	BlockIDSourceStampV2 uint32 = 0x6dff800d
+)
+
+// stampCertEntry is the zip entry holding the SHA-256 of the stamp certificate; it ties the stamp to
+// the APK's v1/v2/v3 signatures, which cover the zip entries.
+const stampCertEntry = "stamp-cert-sha256"
+
+// Scheme IDs the SourceStamp uses to label the digests it signs.
+const (
+	stampSchemeV1 = 1
+	stampSchemeV2 = 2
+	stampSchemeV3 = 3
+)
+
+// Content digest algorithm IDs used in the data signed by the SourceStamp.
+const (
+	contentDigestChunkedSHA256 = 1
+	contentDigestChunkedSHA512 = 2
+	contentDigestSHA256        = 4 // v1: digest of META-INF/MANIFEST.MF
+)
+
+// SourceStamp is the value of a v2 SourceStamp block: the stamp certificate and, per signature
+// scheme of the APK, the stamp's signatures over that scheme's digests.
+type SourceStamp struct {
+	Cert       *x509.Certificate
+	Signatures map[uint32][]*Signature
+}
+
+// ParseSourceStamp parses the value of a BlockIDSourceStampV2 pair. Stamp attributes, if any, are not
+// interpreted.
+func ParseSourceStamp(value []byte) (_ *SourceStamp, err error) {
+	defer catch(&err, "ParseSourceStamp", "source stamp")
+	r := newReader(value, ErrBadSigBlock)
+	data := r.section("source stamp")
+	certBytes := data.prefixed("source stamp certificate")
+	schemes := data.section("source stamp signatures")
+	if err = r.Err(); err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		return nil, locate(err, "source stamp certificate", value, certBytes)
+	}
+	stamp := &SourceStamp{cert, map[uint32][]*Signature{}}
+	for schemes.len() > 0 {
+		scheme := schemes.section("source stamp signatures")
+		id := scheme.uint32("source stamp signatures")
+		sigs := scheme.prefixed("source stamp signatures")
+		if err = r.Err(); err != nil {
+			return nil, err
+		}
+		parsed, err := ParseSignature(sigs)
+		if err != nil {
+			return nil, locate(err, "source stamp signatures", value, sigs)
+		}
+		stamp.Signatures[id] = parsed
+	}
+	if err = r.Err(); err != nil {
+		return nil, err
+	}
+	return stamp, nil
+}
+
+// SourceStamp parses the SourceStamp block, returning nil and no error if the file has none.
+func (apkSign *ApkSign) SourceStamp() (_ *SourceStamp, err error) {
+	defer catch(&err, "SourceStamp", "source stamp")
+	if apkSign.rawASv2 == nil {
+		return nil, nil
+	}
+	pairs, err := apkSign.signingBlockPairs()
+	if err != nil {
+		return nil, err
+	}
+	if v1, _ := findPair(pairs, BlockIDSourceStampV1); v1 != nil {
+		return nil, errors.New("source stamp v1 is not supported")
+	}
+	value, err := findPair(pairs, BlockIDSourceStampV2)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	return ParseSourceStamp(value)
+}
+
+// VerifySourceStamp checks the stamp certificate against the stamp-cert-sha256 entry, and the stamp's
+// signatures over the digests of every signature scheme the APK is signed with. It returns the stamp
+// certificate. The digests are taken from the signers as signed; the schemes themselves must be
+// verified separately, as VerifyAll does.
+func (apkSign *ApkSign) VerifySourceStamp() (_ *x509.Certificate, err error) {
+	defer catch(&err, "VerifySourceStamp", "source stamp")
+	stamp, err := apkSign.SourceStamp()
+	if err != nil {
+		return nil, err
+	}
+	if stamp == nil {
+		return nil, errors.New("no source stamp")
+	}
+
+	r, err := apkSign.zipReader()
+	if err != nil {
+		return nil, err
+	}
+	certDigest, err := readZipEntry(r, stampCertEntry)
+	if err != nil {
+		return nil, fmt.Errorf("source stamp: %v", err)
+	}
+	sum := sha256.Sum256(stamp.Cert.Raw)
+	if !bytes.Equal(certDigest, sum[:]) {
+		return nil, errors.New("source stamp certificate does not match " + stampCertEntry)
+	}
+
+	digests, err := apkSign.stampDigests(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(digests) == 0 {
+		return nil, errors.New("source stamp on unsigned APK")
+	}
+	for scheme, d := range digests {
+		sigs, ok := stamp.Signatures[scheme]
+		if !ok {
+			return nil, fmt.Errorf("source stamp does not sign scheme %d digests", scheme)
+		}
+		sig := strongestSignature(sigs, false)
+		if sig == nil {
+			return nil, fmt.Errorf("source stamp: %w", ErrUnknownAlgorithm)
+		}
+		err = AlgorithmID(sig.AlgorithmID).verify(stamp.Cert.RawSubjectPublicKeyInfo, encodeStampDigests(d), sig.Signature)
+		if err != nil {
+			return nil, fmt.Errorf("source stamp signature over scheme %d digests: %v", scheme, err)
+		}
+	}
+	return stamp.Cert, nil
+}
+
+// WithSourceStamp makes SignV2 add a SourceStamp signed with stamp, replacing any existing one. A
+// stamp-cert-sha256 entry naming the stamp certificate is added to the file first if it lacks one,
+// which a v1 signature would not cover: sign v1 after adding the entry, e.g. with AddFile of the
+// editor, or not at all. SignV2To and SignStream do not support it.
+func WithSourceStamp(stamp *SigningCert) Option {
+	return func(o *options) { o.stamp = stamp }
+}
+
+// AddSourceStamp returns the signed file with a v2 SourceStamp made with stamp over the digests of
+// each of its signature schemes, replacing any existing stamp, which keeps the signatures valid as
+// any other pair of the signing block does. The file must already have a stamp-cert-sha256 entry with
+// the SHA-256 digest of the stamp certificate; WithSourceStamp adds both when signing. Only RSA and
+// ECDSA stamp keys with SHA256 are accepted by the platform.
+func (apkSign *ApkSign) AddSourceStamp(stamp *SigningCert) (_ []byte, err error) {
+	defer catch(&err, "AddSourceStamp", "source stamp")
+	if err = stamp.Resolve(); err != nil {
+		return nil, err
+	}
+	if apkSign.rawASv2 == nil {
+		return nil, ErrNotV2Signed
+	}
+	r, err := apkSign.zipReader()
+	if err != nil {
+		return nil, err
+	}
+	certDigest, err := readZipEntry(r, stampCertEntry)
+	if err != nil {
+		return nil, fmt.Errorf("source stamp: %v", err)
+	}
+	if sum := sha256.Sum256(stamp.Certificate.Raw); !bytes.Equal(certDigest, sum[:]) {
+		return nil, errors.New(stampCertEntry + " names another source stamp certificate")
+	}
+	digests, err := apkSign.stampDigests(r)
+	if err != nil {
+		return nil, err
+	}
+	value, err := encodeSourceStamp(stamp, digests)
+	if err != nil {
+		return nil, err
+	}
+	pairs, err := apkSign.signingBlockPairs()
+	if err != nil {
+		return nil, err
+	}
+	pairs = slices.DeleteFunc(pairs, func(p *Pair) bool { return p.ID == BlockIDSourceStampV1 })
+	return apkSign.injected(setPair(pairs, BlockIDSourceStampV2, value))
+}
+
+// encodeSourceStamp returns the value of a v2 SourceStamp pair in which stamp signs digests, as
+// ParseSourceStamp reads it.
+func encodeSourceStamp(stamp *SigningCert, digests map[uint32]map[uint32][]byte) ([]byte, error) {
+	if len(digests) == 0 {
+		return nil, errors.New("source stamp on unsigned APK")
+	}
+	id, alg, err := stamp.algorithm(options{})
+	if err != nil {
+		return nil, err
+	}
+	schemes := make([]uint32, 0, len(digests))
+	for scheme := range digests {
+		schemes = append(schemes, scheme)
+	}
+	slices.Sort(schemes)
+	var blocks [][]byte
+	for _, scheme := range schemes {
+		sig, err := stamp.sign(alg, encodeStampDigests(digests[scheme]))
+		if err != nil {
+			return nil, err
+		}
+		sigs := push32(push32((&Signature{uint32(id), sig}).Marshal()))
+		blocks = append(blocks, push32(concat(binary.LittleEndian.AppendUint32(nil, scheme), sigs)))
+	}
+	return push32(concat(push32(stamp.Certificate.Raw), push32(concat(blocks...)))), nil
+}
+
+// withStampCert returns the file with a stamp-cert-sha256 entry naming the certificate of stamp:
+// apkSign itself if it has one, otherwise a copy with the entry added, or replaced, at the end.
+func (apkSign *ApkSign) withStampCert(stamp *SigningCert) (*ApkSign, error) {
+	if err := stamp.Resolve(); err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(stamp.Certificate.Raw)
+	r, err := apkSign.zipReader()
+	if err != nil {
+		return nil, err
+	}
+	if old, err := readZipEntry(r, stampCertEntry); err == nil && bytes.Equal(old, sum[:]) {
+		return apkSign, nil
+	}
+	if apkSign.IsV1Signed {
+		return nil, errors.New("adding " + stampCertEntry + " would break the v1 signature")
+	}
+	if apkSign.raw == nil {
+		return nil, errors.New("adding " + stampCertEntry + " needs the file in memory")
+	}
+	fr, err := forkzip.NewReader(apkSign, apkSign.size)
+	if err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	w := forkzip.NewWriter(buf)
+	for _, f := range fr.File {
+		if f.Name == stampCertEntry {
+			continue
+		}
+		if err = w.CopyAligned(f, entryAlign(f.Name, PageSize16K)); err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+	}
+	if err = writeV1Entry(w, stampCertEntry, forkzip.Store, sum[:]); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	z, err := parseApkSign(buf.Bytes(), apkSign.options)
+	if err != nil {
+		return nil, err
+	}
+	z.Progress, z.SelfCheck = apkSign.Progress, apkSign.SelfCheck
+	return z, nil
+}
+
+// stamped is injected for a file signed with WithSourceStamp: the signing block, then the stamp.
+func (apkSign *ApkSign) stamped(block []byte, stamp *SigningCert) ([]byte, error) {
+	signed, err := apkSign.injected(apkSign.padFiles(block))
+	if err != nil {
+		return nil, err
+	}
+	z, err := parseApkSign(signed, apkSign.options)
+	if err != nil {
+		return nil, err
+	}
+	return z.AddSourceStamp(stamp)
+}
+
+// stampDigests returns the digests of each signature scheme present, keyed by stamp scheme ID and
+// then content digest algorithm ID.
+func (apkSign *ApkSign) stampDigests(r *zip.Reader) (map[uint32]map[uint32][]byte, error) {
+	digests := map[uint32]map[uint32][]byte{}
+	if apkSign.IsV1Signed {
+		mf, err := readZipEntry(r, "META-INF/MANIFEST.MF")
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(mf)
+		digests[stampSchemeV1] = map[uint32][]byte{contentDigestSHA256: sum[:]}
+	}
+	signerDigests := func(scheme uint32, signers []*Signer) {
+		if len(signers) == 0 {
+			return
+		}
+		m := map[uint32][]byte{}
+		for _, d := range signers[0].SignedData.Digests {
+			if h, ok := AlgorithmID(d.AlgorithmID).hash(); ok {
+				id := uint32(contentDigestChunkedSHA256)
+				if h.Size() == 64 {
+					id = contentDigestChunkedSHA512
+				}
+				m[id] = d.Digest
+			}
+		}
+		digests[scheme] = m
+	}
+	if v2, err := apkSign.V2Block(); err == nil {
+		signerDigests(stampSchemeV2, v2.Signers)
+	}
+	if v3, _, err := apkSign.V3Blocks(); err != nil {
+		return nil, err
+	} else if v3 != nil {
+		signerDigests(stampSchemeV3, v3.Signers)
+	}
+	return digests, nil
+}
+
+// encodeStampDigests encodes digests as signed by the stamp: a sequence, ordered by algorithm ID, of
+// length-prefixed (algorithm ID, length-prefixed digest) pairs.
+func encodeStampDigests(digests map[uint32][]byte) []byte {
+	ids := make([]uint32, 0, len(digests))
+	for id := range digests {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	var blocks [][]byte
+	for _, id := range ids {
+		blocks = append(blocks, push32((&Digest{id, digests[id]}).Marshal()))
+	}
+	return concat(blocks...)
+}
+
+func readZipEntry(r *zip.Reader, name string) ([]byte, error) {
+	for _, f := range r.File {
+		if f.Name != name {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return io.ReadAll(rc)
+	}
+	return nil, fmt.Errorf("%s not found", name)
+}
@@ -0,0 +1,137 @@
+package signv2
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"testing"
+)
+
+// testSourceStamp encodes a v2 SourceStamp block signing the v2 digests of z with stamp.
+func testSourceStamp(t *testing.T, z *ApkSign, stamp *SigningCert) []byte {
+	v2, err := z.V2Block()
+	if err != nil {
+		t.Fatal(err)
+	}
+	digests := map[uint32][]byte{contentDigestChunkedSHA256: v2.Signers[0].SignedData.Digests[0].Digest}
+	sig, err := stamp.Sign(encodeStampDigests(digests), crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigs := push32(push32((&Signature{uint32(RSAPKCS1v15WithSHA256), sig}).Marshal()))
+	schemes := push32(push32(concat(u32(stampSchemeV2), sigs)))
+	return push32(concat(push32(stamp.Certificate.Raw), schemes))
+}
+
+func TestVerifySourceStamp(t *testing.T) {
+	stamp := generateSigningCert(t, "stamp")
+	sum := sha256.Sum256(stamp.Certificate.Raw)
+	unsigned, err := NewApkSign(testZip(t,
+		"AndroidManifest.xml", "manifest",
+		"classes.dex", "dex",
+		"resources.arsc", "arsc",
+		stampCertEntry, string(sum[:]),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := unsigned.SignV2(testSigningCerts(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := NewApkSign(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, _ := findPair(mustPairs(t, z), BlockIDV2)
+
+	stamped := testWithSigningBlock(t, z, &Pair{BlockIDV2, v2}, &Pair{BlockIDSourceStampV2, testSourceStamp(t, z, stamp)})
+	cert, err := stamped.VerifySourceStamp()
+	if err != nil {
+		t.Fatalf("VerifySourceStamp: %v", err)
+	}
+	if cert.Subject.CommonName != "stamp" {
+		t.Errorf("stamp certificate = %s", cert.Subject)
+	}
+	r := stamped.VerifyAll(VerifyOptions{})
+	if r.SourceStamp == nil || r.SourceStamp.Err != nil || !r.Verified {
+		t.Errorf("VerifyAll stamp = %+v", r.SourceStamp)
+	}
+
+	// a stamp made with a different certificate than the one named in the APK
+	other := generateSigningCert(t, "other")
+	stamped = testWithSigningBlock(t, z, &Pair{BlockIDV2, v2}, &Pair{BlockIDSourceStampV2, testSourceStamp(t, z, other)})
+	if _, err = stamped.VerifySourceStamp(); err == nil {
+		t.Error("VerifySourceStamp accepted a foreign stamp certificate")
+	}
+
+	if r := z.VerifyAll(VerifyOptions{}); r.SourceStamp != nil {
+		t.Errorf("unstamped file reported stamp %+v", r.SourceStamp)
+	}
+}
+
+func TestSignWithSourceStamp(t *testing.T) {
+	stamp := generateSigningCert(t, "stamp")
+	unsigned, err := NewApkSign(testZip(t, "AndroidManifest.xml", "manifest", "classes.dex", "dex"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := unsigned.SignV2(testSigningCerts(t), WithSourceStamp(stamp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := NewApkSign(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert, err := z.VerifySourceStamp(); err != nil || cert.Subject.CommonName != "stamp" {
+		t.Fatalf("VerifySourceStamp = %v, %v", cert, err)
+	}
+	if r := z.VerifyAll(VerifyOptions{}); !r.Verified || r.SourceStamp == nil || r.SourceStamp.Err != nil {
+		t.Fatalf("VerifyAll = %+v, stamp %+v", r, r.SourceStamp)
+	}
+
+	// re-stamping with the same certificate keeps the file valid
+	restamped, err := z.AddSourceStamp(stamp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if z, err = NewApkSign(restamped); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = z.VerifySourceStamp(); err != nil {
+		t.Errorf("restamped: %v", err)
+	}
+
+	// a stamp key other than the one named by stamp-cert-sha256
+	if _, err = z.AddSourceStamp(generateSigningCert(t, "other")); err == nil {
+		t.Error("AddSourceStamp accepted a certificate not named in the APK")
+	}
+
+	// re-signing with PreserveBlocks keeps the stamp
+	z.PreserveBlocks = true
+	resigned, err := z.SignV2(testSigningCerts(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if z, err = NewApkSign(resigned); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = z.VerifySourceStamp(); err != nil {
+		t.Errorf("preserved stamp: %v", err)
+	}
+}
+
+func TestAddSourceStampUnsigned(t *testing.T) {
+	z, err := NewApkSign(testZip(t, "AndroidManifest.xml", "manifest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = z.AddSourceStamp(generateSigningCert(t, "stamp")); !errors.Is(err, ErrNotV2Signed) {
+		t.Errorf("AddSourceStamp on unsigned file = %v", err)
+	}
+	if err = z.SignV2To(io.Discard, testSigningCerts(t), WithSourceStamp(generateSigningCert(t, "stamp"))); err == nil {
+		t.Error("SignV2To accepted WithSourceStamp")
+	}
+}
@@ -0,0 +1,312 @@
+package signv2
+
+import (
+	"crypto"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"slices"
+	"time"
+)
+
+// DefaultStreamTail is the tail size SignStream keeps in memory when StreamConfig.TailSize is zero.
+const DefaultStreamTail = 16 << 20
+
+// StreamConfig configures SignStream.
+type StreamConfig struct {
+	Keys []*SigningCert
+	// TailSize is how many trailing bytes of the input are held back in memory. The Central
+	// Directory, the EOCD and any existing signing block must fit in it. Up to twice as much memory
+	// is used while reading.
+	TailSize int
+	// PreserveBlocks has the same meaning as ApkSign.PreserveBlocks.
+	PreserveBlocks bool
+	// Progress, if set, is notified of the bytes written to out, with a total of 0 since the output
+	// size is only known at the end.
+	Progress ProgressFunc
+	// Metrics, if set, records the operation instead of the recorder set by SetMetricsRecorder.
+	Metrics MetricsRecorder
+}
+
+// SignStream v2 signs the zip read from in and writes the result to out, without holding the whole
+// file in memory. The files section is hashed and passed through as it is read; only the last
+// TailSize bytes are buffered until the end of the input shows where the Central Directory is.
+//
+// If the Central Directory and existing signing block do not fit in the tail, an error is returned
+// after part of the output has been written, so out should be discarded on error.
+func SignStream(in io.Reader, out io.Writer, cfg StreamConfig) (err error) {
+	counted := &countingReader{r: in}
+	defer func(start time.Time) {
+		options{metrics: cfg.Metrics}.record(OpSign, counted.n, start, &err)
+	}(time.Now())
+	defer catch(&err, "SignStream", "file")
+	return signStream(counted, out, cfg)
+}
+
+func signStream(in io.Reader, out io.Writer, cfg StreamConfig) error {
+	for _, sk := range cfg.Keys {
+		if err := sk.Resolve(); err != nil {
+			return err
+		}
+	}
+	tailSize := cfg.TailSize
+	if tailSize <= 0 {
+		tailSize = DefaultStreamTail
+	}
+	hashes, err := keyHashes(cfg.Keys)
+	if err != nil {
+		return err
+	}
+	if cfg.PreserveBlocks {
+		// the blocks to preserve are checked against a digest the old signers recorded, of either hash
+		for _, h := range []crypto.Hash{crypto.SHA256, crypto.SHA512} {
+			if !slices.Contains(hashes, h) {
+				hashes = append(hashes, h)
+			}
+		}
+	}
+	ch := newChunkHasher(hashes)
+	defer ch.release()
+
+	// emit passes bytes known to belong to the files section through to out
+	p := &progressCounter{progress: cfg.Progress, stage: StageWrite}
+	var emitted int64
+	emit := func(b []byte) error {
+		ch.Write(b)
+		emitted += int64(len(b))
+		return p.write(out, b)
+	}
+
+	buf := make([]byte, 0, 2*tailSize)
+	for {
+		n, err := io.ReadFull(in, buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		// the buffer is full: everything but the last tailSize bytes is in the files section
+		if err = emit(buf[:len(buf)-tailSize]); err != nil {
+			return err
+		}
+		buf = buf[:copy(buf, buf[len(buf)-tailSize:])]
+	}
+
+	t, err := parseTail(buf, emitted)
+	if err != nil {
+		return err
+	}
+	if err = emit(buf[:t.filesEnd]); err != nil {
+		return err
+	}
+	// the contents as the old signers saw them, before the padding the new block may add
+	var unpadded *chunkHasher
+	filesEnd := emitted
+	if cfg.PreserveBlocks {
+		unpadded = ch.clone()
+		defer unpadded.release()
+	}
+	if err = emit(make([]byte, filesPadding(uint64(emitted)))); err != nil {
+		return err
+	}
+	ch.Flush()
+	cd := buf[t.cd:t.eocd]
+	ch.Write(cd)
+	ch.Flush()
+	eocd := make([]byte, len(buf)-t.eocd)
+	copy(eocd, buf[t.eocd:])
+	setCDOffset(eocd, uint64(emitted), uint64(len(cd))) // "revised" to point at the files section end
+	ch.Write(eocd)
+	ch.Flush()
+
+	var oldDigests map[crypto.Hash][]byte
+	oldDigest := func(hash crypto.Hash) ([]byte, error) {
+		if oldDigests == nil {
+			unpadded.Flush()
+			unpadded.Write(cd)
+			unpadded.Flush()
+			oldEOCD := slices.Clone(eocd)
+			setCDOffset(oldEOCD, uint64(filesEnd), uint64(len(cd)))
+			unpadded.Write(oldEOCD)
+			unpadded.Flush()
+			oldDigests = unpadded.Sum()
+		}
+		return oldDigests[hash], nil
+	}
+	preserved, err := (&ApkSign{PreserveBlocks: cfg.PreserveBlocks, rawASv2: t.block}).preservedPairs(nil, oldDigest)
+	if err != nil {
+		return err
+	}
+	digests := ch.Sum()
+	v2 := V2Block{}
+	block, err := v2.buildSigningBlock(cfg.Keys, func(h crypto.Hash) ([]byte, error) { return digests[h], nil }, preserved, options{})
+	if err != nil {
+		return err
+	}
+	setCDOffset(eocd, uint64(emitted)+uint64(len(block)), uint64(len(cd)))
+	for _, b := range [][]byte{block, cd, eocd} {
+		if err = p.write(out, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// streamTail holds offsets into the tail buffer.
+type streamTail struct {
+	filesEnd int    // end of the files section, i.e. start of the signing block or of the CD
+	cd, eocd int    // start of the CD and of the records ending it, see centralDirectory
+	block    []byte // pairs of an existing signing block, nil if there is none
+}
+
+// parseTail locates the Central Directory and an existing signing block in the last bytes of a zip;
+// base is the file offset of tail[0].
+func parseTail(tail []byte, base int64) (*streamTail, error) {
+	tooSmall := fmt.Errorf("%w: Central Directory does not fit in the stream tail", ErrLimitExceeded)
+	eocd := findEOCD(tail, false, func(int) bool { return true })
+	if eocd < 0 {
+		return nil, locateAt(ErrNotZip, "EOCD", -1)
+	}
+	cd, cdLen, end, ok := centralDirectory(tail, base, eocd)
+	switch {
+	case !ok && base > 0:
+		return nil, tooSmall
+	case !ok:
+		return nil, locateAt(ErrNotZip, "EOCD", int64(eocd))
+	}
+	cdOffset := int64(cd)
+	if cdOffset < 0 || cd+cdLen != uint64(base)+uint64(end) {
+		return nil, locateAt(ErrCDNotAdjacent, "EOCD", base+int64(eocd))
+	}
+	if cdOffset < base || (base > 0 && cdOffset-16 < base) {
+		return nil, tooSmall
+	}
+	t := &streamTail{filesEnd: int(cdOffset - base), cd: int(cdOffset - base), eocd: end}
+	if t.cd < 32 || string(tail[t.cd-16:t.cd]) != "APK Sig Block 42" {
+		return t, nil
+	}
+	size := binary.LittleEndian.Uint64(tail[t.cd-24:])
+	if size < 24 || size > uint64(t.cd)-8 {
+		if base > 0 {
+			return nil, tooSmall
+		}
+		return nil, locateAt(fmt.Errorf("%w - bad size %d", ErrBadSigBlock, size), "signing block", base+int64(t.cd-24))
+	}
+	start := t.cd - int(size) - 8
+	if binary.LittleEndian.Uint64(tail[start:]) != size {
+		return nil, locateAt(fmt.Errorf("%w - size fields differ", ErrBadSigBlock), "signing block", base+int64(start))
+	}
+	t.filesEnd = start
+	t.block = tail[start+8 : t.cd-24]
+	return t, nil
+}
+
+// keyHashes returns the content digest algorithms needed to sign with keys.
+func keyHashes(keys []*SigningCert) ([]crypto.Hash, error) {
+	var hashes []crypto.Hash
+	seen := map[crypto.Hash]bool{}
+	for _, sk := range keys {
+		var h crypto.Hash
+		switch sk.Hash {
+		case SHA256:
+			h = crypto.SHA256
+		case SHA512:
+			h = crypto.SHA512
+		default:
+			return nil, errors.New("unsupported hash algorithm specified")
+		}
+		if !seen[h] {
+			seen[h] = true
+			hashes = append(hashes, h)
+		}
+	}
+	return hashes, nil
+}
+
+// chunkHasher computes the v2 chunked content digests of data written to it sequentially. Flush
+// ends a section, so that no chunk spans two sections.
+type chunkHasher struct {
+	hashes []crypto.Hash
+	buf    *[]byte // pooled backing buffer of chunk
+	chunk  []byte
+	count  uint32
+	sums   map[crypto.Hash][]byte // concatenated chunk digests
+	h      map[crypto.Hash]hash.Hash
+}
+
+func newChunkHasher(hashes []crypto.Hash) *chunkHasher {
+	buf := getChunk()
+	c := &chunkHasher{hashes: hashes, buf: buf, chunk: *buf, sums: map[crypto.Hash][]byte{}, h: map[crypto.Hash]hash.Hash{}}
+	for _, h := range hashes {
+		c.h[h] = getHash(h)
+	}
+	return c
+}
+
+// release returns the chunk buffer and hash states to their pools; c must not be used afterwards.
+func (c *chunkHasher) release() {
+	putChunk(c.buf)
+	for hash, h := range c.h {
+		putHash(hash, h)
+	}
+	c.buf, c.chunk, c.h = nil, nil, nil
+}
+
+func (c *chunkHasher) Write(p []byte) {
+	for len(p) > 0 {
+		n := copy(c.chunk[len(c.chunk):cap(c.chunk)], p)
+		c.chunk = c.chunk[:len(c.chunk)+n]
+		p = p[n:]
+		if len(c.chunk) == cap(c.chunk) {
+			c.Flush()
+		}
+	}
+}
+
+func (c *chunkHasher) Flush() {
+	if len(c.chunk) == 0 {
+		return
+	}
+	var prefix [5]byte
+	prefix[0] = 0xa5
+	binary.LittleEndian.PutUint32(prefix[1:], uint32(len(c.chunk)))
+	for _, hash := range c.hashes {
+		h := c.h[hash]
+		h.Reset()
+		h.Write(prefix[:])
+		h.Write(c.chunk)
+		c.sums[hash] = h.Sum(c.sums[hash])
+	}
+	c.count++
+	c.chunk = c.chunk[:0]
+}
+
+// clone returns a copy of c that continues independently from the data written so far.
+func (c *chunkHasher) clone() *chunkHasher {
+	d := newChunkHasher(c.hashes)
+	d.chunk = append(d.chunk, c.chunk...)
+	d.count = c.count
+	for hash, sum := range c.sums {
+		d.sums[hash] = slices.Clone(sum)
+	}
+	return d
+}
+
+// Sum returns the top level digest for each algorithm.
+func (c *chunkHasher) Sum() map[crypto.Hash][]byte {
+	out := map[crypto.Hash][]byte{}
+	var count [4]byte
+	binary.LittleEndian.PutUint32(count[:], c.count)
+	for _, hash := range c.hashes {
+		h := hash.New()
+		h.Write([]byte{0x5a})
+		h.Write(count[:])
+		h.Write(c.sums[hash])
+		out[hash] = h.Sum(nil)
+	}
+	return out
+}
@@ -0,0 +1,30 @@
+package signv2
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func TestSignStream(t *testing.T) {
+	random := make([]byte, 3<<20) // incompressible, so the files section is larger than the tail
+	rand.New(rand.NewSource(1)).Read(random)
+	unsigned := testZip(t, "AndroidManifest.xml", "manifest", "assets/big.bin", string(random))
+	signed := testSign(t, unsigned)
+	for name, in := range map[string][]byte{"unsigned": unsigned, "signed": signed} {
+		out := new(bytes.Buffer)
+		err := SignStream(bytes.NewReader(in), out, StreamConfig{Keys: testSigningCerts(t), TailSize: 64 << 10})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(out.Bytes(), signed) {
+			t.Errorf("%s: SignStream output differs from SignV2", name)
+		}
+	}
+
+	err := SignStream(bytes.NewReader(signed), new(bytes.Buffer), StreamConfig{Keys: testSigningCerts(t), TailSize: 64})
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("tiny tail: got %v, want ErrLimitExceeded", err)
+	}
+}
@@ -0,0 +1,265 @@
+package signv2
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+// StripError reports a signature that another signature of the same file says was applied, but
+// which is missing. Removing the newest signature block is how an attacker would downgrade an APK to
+// a scheme that does not protect, for example, key rotation.
+type StripError struct {
+	Missing   Scheme
+	ClaimedBy Scheme
+}
+
+func (e *StripError) Error() string {
+	return fmt.Sprintf("%s signature claimed by %s signature is missing, it may have been stripped", e.Missing, e.ClaimedBy)
+}
+
+// v1Header is the .SF main attribute listing the newer schemes an APK was also signed with.
+const v1Header = "X-Android-APK-Signed"
+
+// v1UnprotectedWarning is the warning of VerifyAll for a file v1Unprotected reports.
+const v1UnprotectedWarning = "v1 signature does not list the newer schemes in " + v1Header + ": stripping them would downgrade the APK to v1 unnoticed"
+
+// CheckStripping looks for signatures that the remaining ones claim should be there: v2 signers
+// carrying the stripping-protection attribute for v3, and a v1 signature whose .SF file lists v2 or
+// v3 in its X-Android-APK-Signed header. It returns a *StripError for the first one missing.
+func (apkSign *ApkSign) CheckStripping() (err error) {
+	defer catch(&err, "CheckStripping", "signing block")
+	stripped, err := apkSign.strippedSchemes()
+	if err != nil {
+		return err
+	}
+	if len(stripped) > 0 {
+		return stripped[0]
+	}
+	return nil
+}
+
+// StripSignatures returns the file without the signatures of schemes, or of all its signatures if
+// none are given, ready to be edited and signed again. Removing v1 deletes the v1 signature files,
+// which changes the entries the other schemes sign, so v2, v3 and v3.1 must be removed along with it.
+// The SourceStamp signs the digests of every scheme and goes with any of them; the signing block is
+// dropped as a whole once no scheme is left in it. A remaining signature that claims a removed one,
+// which would then fail with a *StripError, is an error.
+func (apkSign *ApkSign) StripSignatures(schemes ...Scheme) (_ []byte, err error) {
+	defer catch(&err, "StripSignatures", "file")
+	if len(schemes) == 0 {
+		schemes = []Scheme{SchemeV1, SchemeV2, SchemeV3, SchemeV31}
+	}
+	ids := map[Scheme]uint32{SchemeV2: BlockIDV2, SchemeV3: BlockIDV3, SchemeV31: BlockIDV31}
+	strip := map[uint32]bool{}
+	v1 := false
+	for _, s := range schemes {
+		switch s {
+		case SchemeV1:
+			v1 = true
+		case SchemeV2, SchemeV3, SchemeV31:
+			strip[ids[s]] = true
+		case SchemeV4:
+			return nil, errors.New("v4 signatures are kept in the .idsig file, not the APK")
+		default:
+			return nil, fmt.Errorf("unknown signature scheme %q", s)
+		}
+	}
+
+	var pairs []*Pair
+	if apkSign.rawASv2 != nil {
+		if pairs, err = apkSign.signingBlockPairs(); err != nil {
+			return nil, err
+		}
+	}
+	if v1 && apkSign.IsV1Signed {
+		for _, p := range pairs {
+			if (p.ID == BlockIDV2 || p.ID == BlockIDV3 || p.ID == BlockIDV31) && !strip[p.ID] {
+				return nil, fmt.Errorf("removing v1 invalidates the %s signature, remove it too", BlockName(p.ID))
+			}
+		}
+		return apkSign.withoutV1()
+	}
+
+	var block []byte
+	if slices.ContainsFunc(pairs, func(p *Pair) bool { return strip[p.ID] }) {
+		kept := slices.DeleteFunc(slices.Clone(pairs), func(p *Pair) bool {
+			return strip[p.ID] || p.ID == BlockIDSourceStampV1 || p.ID == BlockIDSourceStampV2
+		})
+		if slices.ContainsFunc(kept, func(p *Pair) bool { return p.ID == BlockIDV2 || p.ID == BlockIDV3 || p.ID == BlockIDV31 }) {
+			// setPair puts the verity padding back at the end with the right size
+			block = setPair(kept, BlockIDSourceStampV2, nil)
+		}
+	} else if apkSign.rawASv2 != nil {
+		block = encodeSigningBlock(apkSign.rawASv2)
+	}
+	out, err := apkSign.injected(block)
+	if err != nil {
+		return nil, err
+	}
+	z, err := parseApkSign(out, apkSign.options)
+	if err != nil {
+		return nil, err
+	}
+	if err = z.CheckStripping(); err != nil {
+		return nil, fmt.Errorf("remaining signature claims a removed one: %w", err)
+	}
+	return out, nil
+}
+
+// withoutV1 returns the file rewritten without its v1 signature files and signing block.
+func (apkSign *ApkSign) withoutV1() ([]byte, error) {
+	r, err := zip.NewReader(apkSign, apkSign.size)
+	if err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	for _, f := range r.File {
+		if isV1SignatureFile(f.Name) {
+			continue
+		}
+		if err = w.CopyAligned(f, entryAlign(f.Name, PageSize16K)); err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (apkSign *ApkSign) strippedSchemes() ([]*StripError, error) {
+	present := map[Scheme]bool{}
+	claims := map[Scheme]Scheme{}
+	if apkSign.rawASv2 != nil {
+		pairs, err := apkSign.signingBlockPairs()
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range pairs {
+			switch p.ID {
+			case BlockIDV2:
+				present[SchemeV2] = true
+			case BlockIDV3:
+				present[SchemeV3] = true
+			case BlockIDV31:
+				present[SchemeV31] = true
+			}
+		}
+		if present[SchemeV2] {
+			v2, err := apkSign.V2Block()
+			if err != nil {
+				return nil, err
+			}
+			for _, s := range v2.Signers {
+				attr := s.attribute(AttrStrippingProtection)
+				if len(attr) == 4 && binary.LittleEndian.Uint32(attr) == 3 {
+					claims[SchemeV3] = SchemeV2
+				}
+			}
+		}
+	}
+	if apkSign.IsV1Signed {
+		schemes, err := apkSign.v1SignedWith()
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range schemes {
+			if _, ok := claims[s]; !ok {
+				claims[s] = SchemeV1
+			}
+		}
+	}
+
+	var stripped []*StripError
+	for _, s := range []Scheme{SchemeV2, SchemeV3} {
+		if by, ok := claims[s]; ok && !present[s] {
+			stripped = append(stripped, &StripError{s, by})
+		}
+	}
+	return stripped, nil
+}
+
+// v1Unprotected reports whether the file has a v1 signature and a newer one, but no .SF file lists
+// the newer schemes in its X-Android-APK-Signed header: stripping the APK Signing Block then
+// downgrades the file to a v1 signed one that Android 7.0 and later still install.
+func (apkSign *ApkSign) v1Unprotected() (bool, error) {
+	if !apkSign.IsV1Signed || apkSign.rawASv2 == nil {
+		return false, nil
+	}
+	pairs, err := apkSign.signingBlockPairs()
+	if err != nil {
+		return false, err
+	}
+	if !slices.ContainsFunc(pairs, func(p *Pair) bool {
+		return p.ID == BlockIDV2 || p.ID == BlockIDV3 || p.ID == BlockIDV31
+	}) {
+		return false, nil
+	}
+	schemes, err := apkSign.v1SignedWith()
+	return len(schemes) == 0, err
+}
+
+// v1SignedWith returns the schemes listed in the X-Android-APK-Signed header of the .SF files.
+func (apkSign *ApkSign) v1SignedWith() ([]Scheme, error) {
+	r, err := apkSign.zipReader()
+	if err != nil {
+		return nil, err
+	}
+	var schemes []Scheme
+	for _, f := range r.File {
+		if !strings.HasPrefix(f.Name, "META-INF/") || !strings.HasSuffix(f.Name, ".SF") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range strings.Split(mainAttribute(b, v1Header), ",") {
+			switch strings.TrimSpace(id) {
+			case "2":
+				schemes = append(schemes, SchemeV2)
+			case "3":
+				schemes = append(schemes, SchemeV3)
+			}
+		}
+	}
+	return schemes, nil
+}
+
+// mainAttribute returns the value of a header in the main section of a JAR manifest or .SF file.
+// Continuation lines, which start with a space, are joined.
+func mainAttribute(manifest []byte, name string) string {
+	var lines []string
+	sc := bufio.NewScanner(bytes.NewReader(manifest))
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), "\r")
+		if line == "" {
+			break // end of main section
+		}
+		if strings.HasPrefix(line, " ") && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	for _, line := range lines {
+		if k, v, ok := strings.Cut(line, ":"); ok && strings.EqualFold(k, name) {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,165 @@
+package signv2
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestCheckStripping(t *testing.T) {
+	z, err := NewApkSign(testSignedApk(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := testSigningCerts(t)[0]
+	if err = sc.Resolve(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a v2 signer that says the file was also signed with v3, but no v3 block
+	v2 := push32(push32(testV2Signer(t, z, sc, &Attribute{AttrStrippingProtection, u32(3)})))
+	stripped := testWithSigningBlock(t, z, &Pair{BlockIDV2, v2})
+	var se *StripError
+	if err = stripped.VerifyV2(); !errors.As(err, &se) || se.Missing != SchemeV3 {
+		t.Fatalf("VerifyV2 = %v, want v3 stripping error", err)
+	}
+	if r := stripped.VerifyAll(VerifyOptions{}); r.Verified || r.Scheme(SchemeV3).Err == nil {
+		t.Errorf("VerifyAll reported stripped file as verified")
+	}
+
+	// with the v3 block back in place it verifies
+	v3 := push32(push32(testV3Signer(t, z, sc, 28, 0x7fffffff)))
+	full := testWithSigningBlock(t, z, &Pair{BlockIDV2, v2}, &Pair{BlockIDV3, v3})
+	if err = full.VerifyV2(); err != nil {
+		t.Errorf("VerifyV2: %v", err)
+	}
+}
+
+func TestMainAttribute(t *testing.T) {
+	sf := "Signature-Version: 1.0\r\nX-Android-APK-Signed: 2,\r\n  3\r\n\r\nName: X-Android-APK-Signed: 9\r\n"
+	if got := mainAttribute([]byte(sf), v1Header); got != "2, 3" {
+		t.Errorf("mainAttribute = %q", got)
+	}
+}
+
+func TestStripSignatures(t *testing.T) {
+	in, err := NewApkSign(testZip(t, "AndroidManifest.xml", "manifest", "classes.dex", "dex"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1, err := in.SignV1(testSigningCerts(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := NewApkSign(v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := z.SignV3(testSigningCerts(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if z, err = NewApkSign(signed); err != nil {
+		t.Fatal(err)
+	}
+
+	// the v2 signer claims v3
+	if _, err = z.StripSignatures(SchemeV3); err == nil {
+		t.Error("stripped v3 while the v2 signer claims it")
+	}
+	if _, err = z.StripSignatures(SchemeV1); err == nil {
+		t.Error("stripped v1 leaving v2 and v3 invalid")
+	}
+
+	unsigned, err := z.StripSignatures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := NewApkSign(unsigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.IsV1Signed || u.IsV2Signed {
+		t.Errorf("stripped file signed: v1 %t, v2 %t", u.IsV1Signed, u.IsV2Signed)
+	}
+	r, err := u.zipReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.File) != 2 {
+		t.Errorf("stripped file has %d entries, want 2", len(r.File))
+	}
+	resigned, err := u.SignV2(testSigningCerts(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u, err = NewApkSign(resigned); err != nil {
+		t.Fatal(err)
+	}
+	if err = u.VerifyV2(); err != nil {
+		t.Errorf("re-signed: %v", err)
+	}
+
+	// removing only the signing block of a v2 signed file
+	z, err = NewApkSign(testSignedApk(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unsigned, err = z.StripSignatures(SchemeV2); err != nil {
+		t.Fatal(err)
+	}
+	if len(unsigned) >= len(z.Bytes()) {
+		t.Error("signing block not removed")
+	}
+	if u, err = NewApkSign(unsigned); err != nil || u.IsV2Signed {
+		t.Errorf("stripped v2: %v, signed %t", err, u != nil && u.IsV2Signed)
+	}
+	if _, err = z.StripSignatures(SchemeV4); err == nil {
+		t.Error("stripped v4")
+	}
+}
+
+func TestV1SignedWith(t *testing.T) {
+	in, err := NewApkSign(testZip(t, "AndroidManifest.xml", "manifest", "classes.dex", "dex"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dualSign := func(opts ...Option) *ApkSign {
+		v1, err := in.SignV1(testSigningCerts(t), opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		z, err := NewApkSign(v1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signed, err := z.SignV2(testSigningCerts(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if z, err = NewApkSign(signed); err != nil {
+			t.Fatal(err)
+		}
+		return z
+	}
+
+	z := dualSign(WithV1SignedWith(SchemeV2))
+	if schemes, err := z.v1SignedWith(); err != nil || !slices.Equal(schemes, []Scheme{SchemeV2}) {
+		t.Errorf("v1SignedWith = %v, %v", schemes, err)
+	}
+	if r := z.VerifyAll(VerifyOptions{}); !r.Verified || slices.Contains(r.Warnings, v1UnprotectedWarning) {
+		t.Errorf("VerifyAll: verified %t, warnings %q", r.Verified, r.Warnings)
+	}
+	// the header lets the platform notice the v2 signature is gone
+	if _, err = z.StripSignatures(SchemeV2); err == nil {
+		t.Error("stripped v2 while the v1 signature claims it")
+	}
+
+	z = dualSign()
+	if r := z.VerifyAll(VerifyOptions{}); !slices.Contains(r.Warnings, v1UnprotectedWarning) {
+		t.Errorf("VerifyAll warnings %q, want a downgrade warning", r.Warnings)
+	}
+	if _, err = in.SignV1(testSigningCerts(t), WithV1SignedWith(SchemeV4)); err == nil {
+		t.Error("SignV1 listed v4")
+	}
+}
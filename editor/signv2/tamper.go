@@ -0,0 +1,72 @@
+package signv2
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+)
+
+// TamperCheck recomputes the content digests of the file and compares them with the digests recorded
+// by the v2, v3 and v3.1 signers. Signatures and certificates are not checked, so this only detects
+// modification of a file whose signing block is trusted, e.g. one verified when it was stored. It is
+// considerably cheaper than full verification when a file is checked repeatedly.
+func (apkSign *ApkSign) TamperCheck() error {
+	return apkSign.TamperCheckContext(context.Background())
+}
+
+// TamperCheckContext is like TamperCheck but stops computing digests and returns ctx.Err() once ctx
+// is done.
+func (apkSign *ApkSign) TamperCheckContext(ctx context.Context) (err error) {
+	defer catch(&err, "TamperCheck", "signing block")
+	if apkSign.rawASv2 == nil {
+		return errors.New("file has no APK Signing Block")
+	}
+	var signers []*Signer
+	if pairs, err := apkSign.signingBlockPairs(); err != nil {
+		return err
+	} else if v2, _ := findPair(pairs, BlockIDV2); v2 != nil {
+		v2Block, err := apkSign.V2Block()
+		if err != nil {
+			return err
+		}
+		signers = append(signers, v2Block.Signers...)
+	}
+	v3, v31, err := apkSign.V3Blocks()
+	if err != nil {
+		return err
+	}
+	for _, b := range []*V3Block{v3, v31} {
+		if b != nil {
+			signers = append(signers, b.Signers...)
+		}
+	}
+
+	computed := make(map[crypto.Hash][]byte)
+	checked := 0
+	for _, s := range signers {
+		for _, d := range s.SignedData.Digests {
+			hash, ok := AlgorithmID(d.AlgorithmID).hash()
+			if !ok {
+				continue
+			}
+			digest, ok := computed[hash]
+			if !ok {
+				var err error
+				if digest, err = apkSign.contentDigest(ctx, hash); err != nil {
+					return err
+				}
+				computed[hash] = digest
+			}
+			if !bytes.Equal(digest, d.Digest) {
+				return fmt.Errorf("%w (algorithm 0x%04x)", ErrDigestMismatch, d.AlgorithmID)
+			}
+			checked++
+		}
+	}
+	if checked == 0 {
+		return fmt.Errorf("%w: no content digest with a supported algorithm", ErrUnknownAlgorithm)
+	}
+	return nil
+}
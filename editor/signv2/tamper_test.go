@@ -0,0 +1,21 @@
+package signv2
+
+import "testing"
+
+func TestTamperCheck(t *testing.T) {
+	signed := testSignedApk(t)
+	z, err := NewApkSign(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = z.TamperCheck(); err != nil {
+		t.Fatalf("TamperCheck: %v", err)
+	}
+	signed[40] ^= 1
+	if z, err = NewApkSign(signed); err != nil {
+		t.Fatal(err)
+	}
+	if err = z.TamperCheck(); err == nil {
+		t.Error("TamperCheck accepted a modified file")
+	}
+}
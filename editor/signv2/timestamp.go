@@ -0,0 +1,389 @@
+package signv2
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+// BlockIDTimestamp is the ID of the pair AddTimestamp writes: an RFC 3161 timestamp token over the
+// SHA-256 digest of the value of the v2 pair, which holds the signed data and the signatures of
+// every signer. The pair is not part of any signature scheme, and Android ignores it.
+const BlockIDTimestamp uint32 = 0x54535031
+
+// oidTSTInfo is the content type of the TSTInfo a timestamp token signs, id-ct-TSTInfo.
+var oidTSTInfo = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+
+// ErrTimestamp is matched by the errors of VerifyTimestamp and TSAClient for a malformed token, or
+// one that does not cover the signature of the file.
+var ErrTimestamp = errors.New("invalid timestamp token")
+
+// Timestamper obtains RFC 3161 timestamp tokens, DER-encoded ContentInfo structures, from a
+// time-stamping authority.
+type Timestamper interface {
+	Timestamp(ctx context.Context, hash crypto.Hash, digest []byte) ([]byte, error)
+}
+
+// Timestamp is a verified timestamp of the v2 signature of a file.
+type Timestamp struct {
+	Time   time.Time             // genTime, when the TSA issued the token
+	Serial *big.Int              // serial number the TSA assigned to the token
+	Policy asn1.ObjectIdentifier // TSA policy the token was issued under
+	TSA    *x509.Certificate     // certificate that signed the token
+	Token  []byte                // the DER token, as stored in the signing block
+}
+
+type tsMessageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type tsRequest struct {
+	Version        int
+	MessageImprint tsMessageImprint
+	Nonce          *big.Int `asn1:"optional"`
+	CertReq        bool     `asn1:"optional"`
+}
+
+type tsResponse struct {
+	Status struct {
+		Status       int
+		StatusString []string       `asn1:"optional,utf8"`
+		FailInfo     asn1.BitString `asn1:"optional"`
+	}
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+type tsAccuracy struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"optional,tag:0"`
+	Micros  int `asn1:"optional,tag:1"`
+}
+
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint tsMessageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time     `asn1:"generalized"`
+	Accuracy       tsAccuracy    `asn1:"optional"`
+	Ordering       bool          `asn1:"optional"`
+	Nonce          *big.Int      `asn1:"optional"`
+	TSA            asn1.RawValue `asn1:"optional,tag:0"`
+	Extensions     asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type tsSignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      pkcs7ContentInfo
+	Certificates     []asn1.RawValue `asn1:"optional,set,tag:0"`
+	CRLs             []asn1.RawValue `asn1:"optional,set,tag:1"`
+	SignerInfos      []tsSignerInfo  `asn1:"set"`
+}
+
+// tsSignerInfo is a CMS SignerInfo. The signed attributes are kept as encoded, since the signature
+// covers their exact DER.
+type tsSignerInfo struct {
+	Version               int
+	IssuerAndSerialNumber pkcs7IssuerAndSerial
+	DigestAlgorithm       pkix.AlgorithmIdentifier
+	SignedAttrs           asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm    pkix.AlgorithmIdentifier
+	Signature             []byte
+	UnsignedAttrs         asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+// TSAClient is a Timestamper that uses the HTTP transport of RFC 3161: the request is POSTed to URL
+// as application/timestamp-query.
+type TSAClient struct {
+	URL    string
+	Client *http.Client // nil means http.DefaultClient
+}
+
+// Timestamp requests a token for digest, with a nonce and the TSA certificate included, and checks
+// that the token answers the request.
+func (c *TSAClient) Timestamp(ctx context.Context, hash crypto.Hash, digest []byte) ([]byte, error) {
+	alg, err := pkcs7DigestAlgorithm(hash)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+	req, err := asn1.Marshal(tsRequest{Version: 1, MessageImprint: tsMessageImprint{alg, digest}, Nonce: nonce, CertReq: true})
+	if err != nil {
+		return nil, err
+	}
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	hreq.Header.Set("Content-Type", "application/timestamp-query")
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(hreq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("timestamp authority %s: %s", c.URL, resp.Status)
+	}
+	var tsr tsResponse
+	if _, err = asn1.Unmarshal(body, &tsr); err != nil {
+		return nil, fmt.Errorf("%w: response: %v", ErrTimestamp, err)
+	}
+	// 0 granted, 1 grantedWithMods
+	if s := tsr.Status; s.Status > 1 || tsr.TimeStampToken.FullBytes == nil {
+		return nil, fmt.Errorf("timestamp authority %s refused the request: status %d %q", c.URL, s.Status, s.StatusString)
+	}
+	token := tsr.TimeStampToken.FullBytes
+	info, _, err := parseTimestampToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if info.Nonce == nil || info.Nonce.Cmp(nonce) != 0 {
+		return nil, fmt.Errorf("%w: nonce does not match the request", ErrTimestamp)
+	}
+	if !bytes.Equal(info.MessageImprint.HashedMessage, digest) {
+		return nil, fmt.Errorf("%w: token is for another digest", ErrTimestamp)
+	}
+	return token, nil
+}
+
+// AddTimestamp returns the file with a BlockIDTimestamp pair holding a token ts issues for its v2
+// signature, replacing any previous one. The signatures stay valid, since they do not cover the
+// signing block. Re-signing the file drops the pair, as the token would no longer match.
+func (apkSign *ApkSign) AddTimestamp(ctx context.Context, ts Timestamper) (_ []byte, err error) {
+	defer catch(&err, "AddTimestamp", "signing block")
+	pairs, imprint, err := apkSign.timestampImprint()
+	if err != nil {
+		return nil, err
+	}
+	token, err := ts.Timestamp(ctx, crypto.SHA256, imprint)
+	if err != nil {
+		return nil, err
+	}
+	if info, _, err := parseTimestampToken(token); err != nil {
+		return nil, err
+	} else if !bytes.Equal(info.MessageImprint.HashedMessage, imprint) {
+		return nil, fmt.Errorf("%w: token is for another digest", ErrTimestamp)
+	}
+
+	return apkSign.injected(setPair(pairs, BlockIDTimestamp, token))
+}
+
+// VerifyTimestamp checks the BlockIDTimestamp pair: the token must be signed by its TSA and cover
+// the current v2 signature, and each v2 signer certificate must have been valid at the time of the
+// timestamp, which is what proves the signature even after the certificate expired. If roots is
+// not nil, the TSA certificate must chain to one of them and be valid for timestamping. The v2
+// signature itself is verified separately, as by VerifyV2.
+func (apkSign *ApkSign) VerifyTimestamp(roots *x509.CertPool) (_ *Timestamp, err error) {
+	defer catch(&err, "VerifyTimestamp", "signing block")
+	pairs, imprint, err := apkSign.timestampImprint()
+	if err != nil {
+		return nil, err
+	}
+	token, err := findPair(pairs, BlockIDTimestamp)
+	if err != nil {
+		return nil, err
+	}
+	if token == nil {
+		return nil, errors.New("no timestamp")
+	}
+	info, sd, err := parseTimestampToken(token)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := pkcs7Hash(info.MessageImprint.HashAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	if hash != crypto.SHA256 || subtle.ConstantTimeCompare(info.MessageImprint.HashedMessage, imprint) != 1 {
+		return nil, fmt.Errorf("%w: token does not cover the v2 signature", ErrTimestamp)
+	}
+	tsa, certs, err := verifyTimestampSigner(sd)
+	if err != nil {
+		return nil, err
+	}
+	if roots != nil {
+		inter := x509.NewCertPool()
+		for _, c := range certs {
+			inter.AddCert(c)
+		}
+		_, err = tsa.Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: inter,
+			CurrentTime:   info.GenTime,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("timestamp authority: %w", err)
+		}
+	}
+
+	v2, err := apkSign.V2Block()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range v2.Signers {
+		for _, c := range s.SignedData.Certs[:min(1, len(s.SignedData.Certs))] {
+			if info.GenTime.Before(c.NotBefore) || info.GenTime.After(c.NotAfter) {
+				return nil, fmt.Errorf("signer certificate %s was not valid at the timestamp %v", c.Subject, info.GenTime)
+			}
+		}
+	}
+	return &Timestamp{Time: info.GenTime, Serial: info.SerialNumber, Policy: info.Policy, TSA: tsa, Token: token}, nil
+}
+
+// timestampImprint returns the pairs of the signing block and the digest a timestamp covers.
+func (apkSign *ApkSign) timestampImprint() ([]*Pair, []byte, error) {
+	if !apkSign.IsV2Signed {
+		return nil, nil, ErrNotV2Signed
+	}
+	pairs, err := apkSign.signingBlockPairs()
+	if err != nil {
+		return nil, nil, err
+	}
+	v2, err := findPair(pairs, BlockIDV2)
+	if err != nil {
+		return nil, nil, err
+	}
+	if v2 == nil {
+		return nil, nil, ErrNotV2Signed
+	}
+	sum := sha256.Sum256(v2)
+	return pairs, sum[:], nil
+}
+
+// parseTimestampToken decodes the TSTInfo of a timestamp token, without checking its signature.
+func parseTimestampToken(token []byte) (*tstInfo, *tsSignedData, error) {
+	var ci pkcs7ContentInfo
+	if rest, err := asn1.Unmarshal(token, &ci); err != nil || len(rest) > 0 || !ci.ContentType.Equal(oidSignedData) {
+		return nil, nil, fmt.Errorf("%w: not a signed data content info", ErrTimestamp)
+	}
+	sd := &tsSignedData{}
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, sd); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrTimestamp, err)
+	}
+	if !sd.ContentInfo.ContentType.Equal(oidTSTInfo) {
+		return nil, nil, fmt.Errorf("%w: content type %v is not TSTInfo", ErrTimestamp, sd.ContentInfo.ContentType)
+	}
+	var content []byte
+	if _, err := asn1.Unmarshal(sd.ContentInfo.Content.Bytes, &content); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrTimestamp, err)
+	}
+	info := &tstInfo{}
+	if _, err := asn1.Unmarshal(content, info); err != nil {
+		return nil, nil, fmt.Errorf("%w: TSTInfo: %v", ErrTimestamp, err)
+	}
+	return info, sd, nil
+}
+
+// verifyTimestampSigner checks the signature of the single signer of a timestamp token and returns
+// its certificate and the others the token carries.
+func verifyTimestampSigner(sd *tsSignedData) (*x509.Certificate, []*x509.Certificate, error) {
+	if len(sd.SignerInfos) != 1 {
+		return nil, nil, fmt.Errorf("%w: %d signers", ErrTimestamp, len(sd.SignerInfos))
+	}
+	si := sd.SignerInfos[0]
+	var tsa *x509.Certificate
+	var certs []*x509.Certificate
+	for _, raw := range sd.Certificates {
+		cert, err := x509.ParseCertificate(raw.FullBytes)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrTimestamp, err)
+		}
+		if bytes.Equal(cert.RawIssuer, si.IssuerAndSerialNumber.Issuer.FullBytes) &&
+			cert.SerialNumber.Cmp(si.IssuerAndSerialNumber.SerialNumber) == 0 {
+			tsa = cert
+		} else {
+			certs = append(certs, cert)
+		}
+	}
+	if tsa == nil {
+		return nil, nil, fmt.Errorf("%w: no certificate for the signer", ErrTimestamp)
+	}
+	if si.SignedAttrs.FullBytes == nil {
+		return nil, nil, fmt.Errorf("%w: no signed attributes", ErrTimestamp)
+	}
+	hash, err := pkcs7Hash(si.DigestAlgorithm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the signed attributes must name TSTInfo as the content type and carry its digest
+	var content []byte
+	if _, err = asn1.Unmarshal(sd.ContentInfo.Content.Bytes, &content); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrTimestamp, err)
+	}
+	h := hash.New()
+	h.Write(content)
+	digest := h.Sum(nil)
+	var contentType, messageDigest bool
+	for rest := si.SignedAttrs.Bytes; len(rest) > 0; {
+		var a pkcs7Attribute
+		if rest, err = asn1.Unmarshal(rest, &a); err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrTimestamp, err)
+		}
+		if len(a.Values) != 1 {
+			continue
+		}
+		switch {
+		case a.Type.Equal(oidContentType):
+			var oid asn1.ObjectIdentifier
+			_, err := asn1.Unmarshal(a.Values[0].FullBytes, &oid)
+			contentType = err == nil && oid.Equal(oidTSTInfo)
+		case a.Type.Equal(oidMessageDigest):
+			var md []byte
+			_, err := asn1.Unmarshal(a.Values[0].FullBytes, &md)
+			messageDigest = err == nil && subtle.ConstantTimeCompare(md, digest) == 1
+		}
+	}
+	if !contentType || !messageDigest {
+		return nil, nil, fmt.Errorf("%w: signed attributes do not match the TSTInfo", ErrTimestamp)
+	}
+
+	// the signature covers the attributes encoded as a SET OF, not with their [0] tag
+	signed := bytes.Clone(si.SignedAttrs.FullBytes)
+	signed[0] = 0x31
+	h = hash.New()
+	h.Write(signed)
+	sum := h.Sum(nil)
+	switch pub := tsa.PublicKey.(type) {
+	case *rsa.PublicKey:
+		err = rsa.VerifyPKCS1v15(pub, hash, sum, si.Signature)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, sum, si.Signature) {
+			err = errors.New("ecdsa: verification error")
+		}
+	default:
+		return nil, nil, fmt.Errorf("%w: timestamp signed with %v", ErrUnknownAlgorithm, tsa.PublicKeyAlgorithm)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrTimestamp, err)
+	}
+	return tsa, certs, nil
+}
@@ -0,0 +1,234 @@
+package signv2
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testTSA is an in-process time-stamping authority issuing tokens for time at.
+type testTSA struct {
+	key  *ecdsa.PrivateKey
+	cert *x509.Certificate
+	at   time.Time
+}
+
+func newTestTSA(t *testing.T, at time.Time) *testTSA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(7),
+		Subject:               pkix.Name{CommonName: "tsa"},
+		NotBefore:             at.Add(-time.Hour),
+		NotAfter:              at.Add(time.Hour),
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testTSA{key, cert, at}
+}
+
+func (tsa *testTSA) Timestamp(_ context.Context, hash crypto.Hash, digest []byte) ([]byte, error) {
+	return tsa.token(hash, digest, nil)
+}
+
+func (tsa *testTSA) token(hash crypto.Hash, digest []byte, nonce *big.Int) ([]byte, error) {
+	alg, err := pkcs7DigestAlgorithm(hash)
+	if err != nil {
+		return nil, err
+	}
+	content, err := asn1.Marshal(tstInfo{
+		Version:        1,
+		Policy:         asn1.ObjectIdentifier{1, 2, 3},
+		MessageImprint: tsMessageImprint{alg, digest},
+		SerialNumber:   big.NewInt(42),
+		GenTime:        tsa.at.UTC().Truncate(time.Second),
+		Nonce:          nonce,
+	})
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(content)
+	attr := func(oid asn1.ObjectIdentifier, v any) []byte {
+		raw, _ := asn1.Marshal(v)
+		b, _ := asn1.Marshal(pkcs7Attribute{oid, []asn1.RawValue{{FullBytes: raw}}})
+		return b
+	}
+	attrs := append(attr(oidContentType, oidTSTInfo), attr(oidMessageDigest, sum[:])...)
+	signedAttrs, _ := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attrs})
+	toSign := bytes.Clone(signedAttrs)
+	toSign[0] = 0x31
+	h := sha256.Sum256(toSign)
+	sig, err := ecdsa.SignASN1(rand.Reader, tsa.key, h[:])
+	if err != nil {
+		return nil, err
+	}
+	octets, _ := asn1.Marshal(content)
+	sha256Alg, _ := pkcs7DigestAlgorithm(crypto.SHA256)
+	sd, err := asn1.Marshal(tsSignedData{
+		Version:          3,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{sha256Alg},
+		ContentInfo:      pkcs7ContentInfo{ContentType: oidTSTInfo, Content: asn1.RawValue{FullBytes: mustExplicit(octets)}},
+		Certificates:     []asn1.RawValue{{FullBytes: tsa.cert.Raw}},
+		SignerInfos: []tsSignerInfo{{
+			Version:               1,
+			IssuerAndSerialNumber: pkcs7IssuerAndSerial{asn1.RawValue{FullBytes: tsa.cert.RawIssuer}, tsa.cert.SerialNumber},
+			DigestAlgorithm:       sha256Alg,
+			SignedAttrs:           asn1.RawValue{FullBytes: signedAttrs},
+			SignatureAlgorithm:    pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}},
+			Signature:             sig,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pkcs7ContentInfo{ContentType: oidSignedData, Content: asn1.RawValue{FullBytes: mustExplicit(sd)}})
+}
+
+// mustExplicit wraps b in an explicit [0] tag.
+func mustExplicit(b []byte) []byte {
+	out, _ := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: b})
+	return out
+}
+
+func TestTimestamp(t *testing.T) {
+	z, err := NewApkSign(testSignedApk(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tsa := newTestTSA(t, time.Now())
+	stamped, err := z.AddTimestamp(context.Background(), tsa)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sz, err := NewApkSign(stamped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sz.VerifyV2(); err != nil {
+		t.Fatalf("v2 signature broken by the timestamp: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(tsa.cert)
+	ts, err := sz.VerifyTimestamp(roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ts.Time.Equal(tsa.at.Truncate(time.Second)) || ts.Serial.Int64() != 42 || ts.TSA.Subject.CommonName != "tsa" {
+		t.Errorf("timestamp = %+v", ts)
+	}
+
+	// stamping again replaces the pair
+	again, err := sz.AddTimestamp(context.Background(), tsa)
+	if err != nil {
+		t.Fatal(err)
+	}
+	az, err := NewApkSign(again)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := countPairs(t, az, BlockIDTimestamp); n != 1 {
+		t.Errorf("%d timestamp pairs after restamping", n)
+	}
+
+	if _, err = sz.VerifyTimestamp(x509.NewCertPool()); err == nil {
+		t.Error("VerifyTimestamp accepted an untrusted TSA")
+	}
+	if _, err = z.VerifyTimestamp(nil); err == nil {
+		t.Error("VerifyTimestamp accepted a file without a timestamp")
+	}
+
+	// a token from before the signer certificate was issued proves nothing
+	early := newTestTSA(t, time.Now().Add(-48*time.Hour))
+	stamped, err = z.AddTimestamp(context.Background(), early)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sz, err = NewApkSign(stamped); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sz.VerifyTimestamp(nil); err == nil {
+		t.Error("VerifyTimestamp accepted a timestamp outside the signer certificate validity")
+	}
+
+	// a token over some other signature
+	foreign := sha256.Sum256([]byte("other signed data"))
+	token, err := tsa.token(crypto.SHA256, foreign[:], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, _ := findPair(mustPairs(t, z), BlockIDV2)
+	forged := testWithSigningBlock(t, z, &Pair{BlockIDV2, v2}, &Pair{BlockIDTimestamp, token})
+	if _, err = forged.VerifyTimestamp(nil); !errors.Is(err, ErrTimestamp) {
+		t.Errorf("VerifyTimestamp of a foreign token = %v, want ErrTimestamp", err)
+	}
+}
+
+func countPairs(t *testing.T, z *ApkSign, id uint32) int {
+	n := 0
+	for _, p := range mustPairs(t, z) {
+		if p.ID == id {
+			n++
+		}
+	}
+	return n
+}
+
+func TestTSAClient(t *testing.T) {
+	tsa := newTestTSA(t, time.Now())
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req tsRequest
+		if _, err := asn1.Unmarshal(body, &req); err != nil || r.Header.Get("Content-Type") != "application/timestamp-query" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		token, err := tsa.token(crypto.SHA256, req.MessageImprint.HashedMessage, req.Nonce)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var resp tsResponse
+		resp.TimeStampToken = asn1.RawValue{FullBytes: token}
+		b, _ := asn1.Marshal(resp)
+		w.Header().Set("Content-Type", "application/timestamp-reply")
+		w.Write(b)
+	}))
+	defer srv.Close()
+
+	digest := sha256.Sum256([]byte("signed data"))
+	c := &TSAClient{URL: srv.URL}
+	token, err := c.Timestamp(context.Background(), crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, _, err := parseTimestampToken(token)
+	if err != nil || !bytes.Equal(info.MessageImprint.HashedMessage, digest[:]) {
+		t.Errorf("token = %+v, %v", info, err)
+	}
+}
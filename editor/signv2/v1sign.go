@@ -0,0 +1,482 @@
+package signv2
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+// v1 signature files and the headers written into them.
+const (
+	v1Manifest  = "META-INF/MANIFEST.MF"
+	v1CreatedBy = "1.0 (apkEditor)"
+	v1MaxLine   = 70 // bytes before the CRLF, as the 72 byte limit of the JAR spec counts it
+	// minSDKV1SHA256 is the first API level, Android 4.3, to verify v1 signatures made with SHA-256
+	// or with ECDSA keys.
+	minSDKV1SHA256 = 18
+)
+
+// v1Digests names the digest headers of JAR manifests and signature files by hash.
+var v1Digests = map[crypto.Hash]string{
+	crypto.SHA1:   "SHA1-Digest",
+	crypto.SHA256: "SHA-256-Digest",
+	crypto.SHA512: "SHA-512-Digest",
+}
+
+// SignV1 returns the zip signed with the v1 (JAR) scheme: a META-INF/MANIFEST.MF with the SHA-256
+// digest of every entry, and for each key a signature file META-INF/CERT.SF (CERT2.SF and so on
+// for the next keys) with its PKCS #7 signature block META-INF/CERT.RSA, or CERT.EC for an ECDSA
+// key. RSA and ECDSA keys are supported. As with apksigner, a WithMinSdk level below 18, which
+// does not verify SHA-256, makes the digests and the signatures SHA-1 ones; ECDSA keys are
+// refused there, as those platforms do not verify them at all.
+//
+// Existing v1 signature files and the APK Signing Block are dropped, as the other schemes do not
+// survive the rewrite; to dual-sign, call SignV2 or SignV3 on the result. Entries are copied as
+// they are stored, local headers included, and stored entries realigned.
+func (apkSign *ApkSign) SignV1(keys []*SigningCert, opts ...Option) ([]byte, error) {
+	return apkSign.SignV1Context(context.Background(), keys, opts...)
+}
+
+// SignV1Context is like SignV1 but returns ctx.Err() once ctx is done. Progress is notified as the
+// entries are digested (StageDigest, counting uncompressed bytes) and copied (StageRebuild).
+func (apkSign *ApkSign) SignV1Context(ctx context.Context, keys []*SigningCert, opts ...Option) (_ []byte, err error) {
+	o := apkSign.options.with(opts)
+	defer o.record(OpSign, apkSign.size, time.Now(), &err)
+	defer catch(&err, "SignV1", "file")
+	if len(keys) == 0 {
+		return nil, errors.New("no signing keys")
+	}
+	for _, sk := range keys {
+		if err = sk.Resolve(); err != nil {
+			return nil, err
+		}
+		if sk.Type != RSA && sk.Type != EC || sk.Hash != SHA256 && sk.Hash != SHA512 {
+			return nil, errors.New("v1 signing supports RSA and EC keys with SHA256 or SHA512 only")
+		}
+	}
+	hash := crypto.SHA256
+	if o.minSdk > 0 && o.minSdk < minSDKV1SHA256 {
+		hash = crypto.SHA1
+		for _, sk := range keys {
+			if sk.Type == EC {
+				return nil, fmt.Errorf("v1 signatures with EC keys need min sdk %d, not %d", minSDKV1SHA256, o.minSdk)
+			}
+		}
+	}
+	digestAttr := v1Digests[hash]
+	// the fork of archive/zip, whose writer copies entries raw and aligns them
+	r, err := zip.NewReader(apkSign, apkSign.size)
+	if err != nil {
+		return nil, err
+	}
+	var files []*zip.File
+	for _, f := range r.File {
+		if !strings.HasSuffix(f.Name, "/") && !isV1SignatureFile(f.Name) {
+			files = append(files, f)
+		}
+	}
+
+	// MANIFEST.MF holds the digest of each entry, the .SF files the digest of each manifest section
+	mf := appendManifestLine(nil, "Manifest-Version", "1.0")
+	mf = appendManifestLine(mf, "Created-By", v1CreatedBy)
+	mf = append(mf, "\r\n"...)
+	mainEnd := len(mf)
+	var sections []byte
+	digested := &progressCounter{progress: apkSign.Progress, stage: StageDigest}
+	for _, f := range files {
+		digested.total += int64(f.UncompressedSize64)
+	}
+	for _, f := range files {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		digest, err := entryDigest(f, hash)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		digested.add(int(f.UncompressedSize64))
+		section := appendManifestLine(nil, "Name", f.Name)
+		section = appendManifestLine(section, digestAttr, digest)
+		section = append(section, "\r\n"...)
+		mf = append(mf, section...)
+		sections = appendManifestLine(sections, "Name", f.Name)
+		sections = appendManifestLine(sections, digestAttr, base64Digest(hash, section))
+		sections = append(sections, "\r\n"...)
+	}
+	sf := appendManifestLine(nil, "Signature-Version", "1.0")
+	sf = appendManifestLine(sf, "Created-By", v1CreatedBy)
+	if len(o.v1SignedWith) > 0 {
+		ids, err := v1SchemeIDs(o.v1SignedWith)
+		if err != nil {
+			return nil, err
+		}
+		sf = appendManifestLine(sf, v1Header, ids)
+	}
+	sf = appendManifestLine(sf, digestAttr+"-Manifest", base64Digest(hash, mf))
+	sf = appendManifestLine(sf, digestAttr+"-Manifest-Main-Attributes", base64Digest(hash, mf[:mainEnd]))
+	sf = append(append(sf, "\r\n"...), sections...)
+
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	// the manifest goes first, where java.util.jar.JarInputStream looks for it
+	if err = writeV1Entry(w, v1Manifest, zip.Deflate, mf); err != nil {
+		return nil, err
+	}
+	for i, sk := range keys {
+		name := "META-INF/CERT"
+		if i > 0 {
+			name += strconv.Itoa(i + 1)
+		}
+		block, err := sk.SignPKCS7(sf, PKCS7Options{Hash: hash})
+		if err != nil {
+			return nil, err
+		}
+		if err = writeV1Entry(w, name+".SF", zip.Deflate, sf); err != nil {
+			return nil, err
+		}
+		// as apksigner does, the block is named after the key algorithm
+		ext := ".RSA"
+		if sk.Type == EC {
+			ext = ".EC"
+		}
+		if err = writeV1Entry(w, name+ext, zip.Deflate, block); err != nil {
+			return nil, err
+		}
+	}
+	copied := &progressCounter{progress: apkSign.Progress, stage: StageRebuild, total: compressedSize(files)}
+	for _, f := range files {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err = w.CopyAligned(f, entryAlign(f.Name, PageSize16K)); err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		copied.add(int(f.CompressedSize64))
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// appendManifestLine appends the header "name: value" of a JAR manifest or signature file, wrapped
+// as apksigner does: lines hold at most 72 bytes with their CRLF, continuation lines starting with a
+// space.
+func appendManifestLine(b []byte, name, value string) []byte {
+	line := name + ": " + value
+	for n := v1MaxLine; len(line) > n; n = v1MaxLine - 1 {
+		b = append(append(b, line[:n]...), "\r\n "...)
+		line = line[n:]
+	}
+	return append(append(b, line...), "\r\n"...)
+}
+
+// entryDigest returns the base64 hash digest of the uncompressed content of f, a *zip.File of the
+// fork or of archive/zip.
+func entryDigest(f interface{ Open() (io.ReadCloser, error) }, hash crypto.Hash) (string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	h := hash.New()
+	if _, err = io.Copy(h, rc); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// base64Digest returns the base64 hash digest of b, as the JAR digest headers hold it.
+func base64Digest(hash crypto.Hash, b []byte) string {
+	h := hash.New()
+	h.Write(b)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// writeV1Entry writes an entry of the signed zip, aligning stored ones.
+func writeV1Entry(w *zip.Writer, name string, method uint16, data []byte) error {
+	fh := &zip.FileHeader{Name: name, Method: method}
+	var fw io.Writer
+	var err error
+	if method != zip.Store {
+		fw, err = w.CreateHeader(fh)
+	} else {
+		fw, err = w.CreateAlignedHeader(fh, entryAlign(name, PageSize16K))
+	}
+	if err == nil {
+		_, err = fw.Write(data)
+	}
+	return err
+}
+
+// v1SchemeIDs returns the X-Android-APK-Signed value listing schemes, as "2, 3".
+func v1SchemeIDs(schemes []Scheme) (string, error) {
+	var ids []string
+	for _, id := range []struct {
+		scheme Scheme
+		id     string
+	}{{SchemeV2, "2"}, {SchemeV3, "3"}} {
+		if slices.Contains(schemes, id.scheme) {
+			ids = append(ids, id.id)
+		}
+	}
+	for _, s := range schemes {
+		if s != SchemeV2 && s != SchemeV3 {
+			return "", fmt.Errorf("%s cannot be listed in %s", s, v1Header)
+		}
+	}
+	return strings.Join(ids, ", "), nil
+}
+
+// isV1SignatureFile reports whether name is part of a v1 signature: the manifest, or a signature
+// file or signature block directly in META-INF.
+func isV1SignatureFile(name string) bool {
+	dir, file := path.Split(name)
+	if dir != "META-INF/" {
+		return false
+	}
+	switch strings.ToUpper(path.Ext(file)) {
+	case ".MF", ".SF", ".RSA", ".DSA", ".EC":
+		return true
+	}
+	return false
+}
+
+// v1Hashes are the digests of v1Digests, weakest first.
+var v1Hashes = []crypto.Hash{crypto.SHA1, crypto.SHA256, crypto.SHA512}
+
+// VerifyV1 returns a non-nil error if the file has no v1 (JAR) signature, or if it does not verify:
+// each signature block must be a valid PKCS #7 signature of its .SF file, the .SF file must match
+// META-INF/MANIFEST.MF, and the manifest must hold the digest of every other entry. Whether the
+// signers are trusted is up to the caller, as for the other schemes.
+func (apkSign *ApkSign) VerifyV1() error {
+	return apkSign.VerifyV1Context(context.Background())
+}
+
+// VerifyV1Context is like VerifyV1 but stops digesting entries and returns ctx.Err() once ctx is
+// done.
+func (apkSign *ApkSign) VerifyV1Context(ctx context.Context) (err error) {
+	defer catch(&err, "VerifyV1", "file")
+	_, err = apkSign.verifyV1(ctx)
+	return err
+}
+
+// verifyV1 implements VerifyV1Context. It returns the signer of each signature block verified,
+// which on error are those checked before it.
+func (apkSign *ApkSign) verifyV1(ctx context.Context) ([]*SignerResult, error) {
+	if !apkSign.IsV1Signed {
+		return nil, errors.New("v1 verification attempted on non-v1-signed file")
+	}
+	r, err := apkSign.zipReader()
+	if err != nil {
+		return nil, err
+	}
+	mf, err := readZipEntry(r, v1Manifest)
+	if err != nil {
+		return nil, err
+	}
+	mfMain, mfSections, err := parseJARSections(mf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", v1Manifest, err)
+	}
+
+	var signers []*SignerResult
+	for _, f := range r.File {
+		// the signature block files, each signing the .SF file of the same name
+		if ext := strings.ToUpper(path.Ext(f.Name)); !isV1SignatureFile(f.Name) || ext == ".MF" || ext == ".SF" {
+			continue
+		}
+		block, err := readZipEntry(r, f.Name)
+		if err != nil {
+			return signers, err
+		}
+		sfName := strings.TrimSuffix(f.Name, path.Ext(f.Name)) + ".SF"
+		sf, err := readZipEntry(r, sfName)
+		if err != nil {
+			return signers, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		certs, err := VerifyPKCS7(block, sf)
+		if err != nil {
+			return signers, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		if err = checkSignatureFile(sf, mf, mfMain, mfSections); err != nil {
+			return signers, fmt.Errorf("%s: %w", sfName, err)
+		}
+		for _, cert := range certs {
+			signers = append(signers, newSignerResult(SchemeV1, &Signer{SignedData: &SignedData{Certs: []*x509.Certificate{cert}}}))
+		}
+	}
+	if len(signers) == 0 {
+		return nil, errors.New("no v1 signature block")
+	}
+
+	// every entry but the signature files must be listed with its digest, and only those
+	sections := make(map[string]*jarSection, len(mfSections))
+	for _, s := range mfSections {
+		sections[s.name] = s
+	}
+	entries := map[string]bool{}
+	for _, f := range r.File {
+		entries[f.Name] = true
+		if strings.HasSuffix(f.Name, "/") || isV1SignatureFile(f.Name) {
+			continue
+		}
+		if err = ctx.Err(); err != nil {
+			return signers, err
+		}
+		s := sections[f.Name]
+		if s == nil {
+			return signers, fmt.Errorf("%s: not listed in %s", f.Name, v1Manifest)
+		}
+		checked := false
+		for _, hash := range v1Hashes {
+			want, ok := s.attrs[strings.ToLower(v1Digests[hash])]
+			if !ok {
+				continue
+			}
+			got, err := entryDigest(f, hash)
+			if err != nil {
+				return signers, fmt.Errorf("%s: %w", f.Name, err)
+			}
+			if got != want {
+				return signers, fmt.Errorf("%s: %w: %s", f.Name, ErrDigestMismatch, v1Digests[hash])
+			}
+			checked = true
+		}
+		if !checked {
+			return signers, fmt.Errorf("%s: %w: no supported digest in %s", f.Name, ErrUnknownAlgorithm, v1Manifest)
+		}
+	}
+	for _, s := range mfSections {
+		if !entries[s.name] {
+			return signers, fmt.Errorf("%s: listed in %s but not in the file", s.name, v1Manifest)
+		}
+	}
+	return signers, nil
+}
+
+// checkSignatureFile checks that the signature file sf matches the manifest mf, parsed into mfMain
+// and mfSections: by its digest of the whole manifest, or failing that, as jarsigner and apksigner
+// accept both, by its digests of the main attributes and of every manifest section.
+func checkSignatureFile(sf, mf []byte, mfMain *jarSection, mfSections []*jarSection) error {
+	main, sections, err := parseJARSections(sf)
+	if err != nil {
+		return err
+	}
+	for _, hash := range v1Hashes {
+		if want, ok := main.attrs[strings.ToLower(v1Digests[hash]+"-Manifest")]; ok && base64Digest(hash, mf) == want {
+			return nil
+		}
+	}
+	for _, hash := range v1Hashes {
+		want, ok := main.attrs[strings.ToLower(v1Digests[hash]+"-Manifest-Main-Attributes")]
+		if ok && base64Digest(hash, mfMain.raw) != want {
+			return fmt.Errorf("%w: %s of the manifest main attributes", ErrDigestMismatch, v1Digests[hash])
+		}
+	}
+	signed := make(map[string]*jarSection, len(sections))
+	for _, s := range sections {
+		signed[s.name] = s
+	}
+	for _, ms := range mfSections {
+		s := signed[ms.name]
+		if s == nil {
+			return fmt.Errorf("%s: manifest section not signed", ms.name)
+		}
+		checked := false
+		for _, hash := range v1Hashes {
+			want, ok := s.attrs[strings.ToLower(v1Digests[hash])]
+			if !ok {
+				continue
+			}
+			if base64Digest(hash, ms.raw) != want {
+				return fmt.Errorf("%s: %w: %s of the manifest section", ms.name, ErrDigestMismatch, v1Digests[hash])
+			}
+			checked = true
+		}
+		if !checked {
+			return fmt.Errorf("%s: %w: no supported digest of the manifest section", ms.name, ErrUnknownAlgorithm)
+		}
+		delete(signed, ms.name)
+	}
+	for _, s := range sections {
+		if signed[s.name] != nil {
+			return fmt.Errorf("%s: signed section not in the manifest", s.name)
+		}
+	}
+	return nil
+}
+
+// jarSection is a section of a JAR manifest or signature file.
+type jarSection struct {
+	name  string            // the Name header, empty for the main section
+	raw   []byte            // the section as written, with its terminating blank line, as digested
+	attrs map[string]string // the headers by lower case name
+}
+
+// parseJARSections splits a JAR manifest or signature file into its main section and the named
+// sections that follow, in file order. Continuation lines, which start with a space, are joined.
+func parseJARSections(b []byte) (main *jarSection, sections []*jarSection, err error) {
+	names := map[string]bool{}
+	for len(b) > 0 {
+		s := &jarSection{attrs: map[string]string{}}
+		var last string
+		n := 0
+		for n < len(b) {
+			end := len(b)
+			if i := bytes.IndexByte(b[n:], '\n'); i >= 0 {
+				end = n + i + 1
+			}
+			line := strings.TrimRight(string(b[n:end]), "\r\n")
+			n = end
+			if line == "" {
+				break
+			}
+			if line[0] == ' ' {
+				if last == "" {
+					return nil, nil, errors.New("continuation line without header")
+				}
+				s.attrs[last] += line[1:]
+				continue
+			}
+			k, v, ok := strings.Cut(line, ": ")
+			if !ok {
+				return nil, nil, fmt.Errorf("malformed header %q", line)
+			}
+			last = strings.ToLower(k)
+			s.attrs[last] = v
+		}
+		s.raw, b = b[:n], b[n:]
+		if main == nil {
+			main = s
+			continue
+		}
+		if len(s.attrs) == 0 {
+			continue // a stray blank line
+		}
+		if s.name = s.attrs["name"]; s.name == "" {
+			return nil, nil, errors.New("section without a Name header")
+		}
+		if names[s.name] {
+			return nil, nil, fmt.Errorf("%s: duplicate section", s.name)
+		}
+		names[s.name] = true
+		sections = append(sections, s)
+	}
+	if main == nil {
+		return nil, nil, errors.New("empty file")
+	}
+	return main, sections, nil
+}
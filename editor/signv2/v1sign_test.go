@@ -0,0 +1,333 @@
+package signv2
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSignV1(t *testing.T) {
+	long := "assets/" + strings.Repeat("very-long-directory-name/", 4) + "file.txt"
+	z, err := NewApkSign(testSignedApk(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an APK signed before keeps no trace of the old signing block
+	unsigned, err := NewApkSign(testZip(t, "AndroidManifest.xml", "manifest", "classes.dex", "dex", long, "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := testSigningCerts(t)
+	for _, in := range []*ApkSign{z, unsigned} {
+		signed, err := in.SignV1(keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sz, err := NewApkSign(signed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sz.IsV1Signed || sz.IsV2Signed {
+			t.Errorf("IsV1Signed = %v, IsV2Signed = %v", sz.IsV1Signed, sz.IsV2Signed)
+		}
+		files := testV1Entries(t, signed)
+		mf, sf := files[v1Manifest], files["META-INF/CERT.SF"]
+		for _, line := range strings.SplitAfter(string(mf)+string(sf), "\r\n") {
+			if len(line) > 72 {
+				t.Errorf("line of %d bytes: %q", len(line), line)
+			}
+		}
+		mfSum := sha256.Sum256(mf)
+		if got := mainAttribute(sf, "SHA-256-Digest-Manifest"); got != base64.StdEncoding.EncodeToString(mfSum[:]) {
+			t.Errorf("SHA-256-Digest-Manifest = %q", got)
+		}
+		// every entry but the signature files is listed with its digest
+		joined := strings.ReplaceAll(string(mf), "\r\n ", "")
+		for name, data := range files {
+			sum := sha256.Sum256(data)
+			listed := strings.Contains(joined, "Name: "+name+"\r\nSHA-256-Digest: "+base64.StdEncoding.EncodeToString(sum[:])+"\r\n")
+			if listed == isV1SignatureFile(name) {
+				t.Errorf("%s: listed in the manifest = %v", name, listed)
+			}
+		}
+		certs, err := VerifyPKCS7(files["META-INF/CERT.RSA"], sf)
+		if err != nil || len(certs) != 1 {
+			t.Fatalf("CERT.RSA: %v, %v", certs, err)
+		}
+
+		// dual signing, which WithMinSdk below Nougat requires
+		v2, err := sz.SignV2(keys, WithMinSdk(21))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if vz, err := NewApkSign(v2); err != nil || !vz.IsV1Signed {
+			t.Fatalf("v1+v2: %v", err)
+		} else if err = vz.VerifyV2(); err != nil {
+			t.Errorf("VerifyV2 of v1+v2: %v", err)
+		}
+
+		// signing again replaces the signature files
+		again, err := sz.SignV1(keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := len(testV1Entries(t, again)); n != len(files) {
+			t.Errorf("%d entries after signing again, want %d", n, len(files))
+		}
+	}
+	if _, err = z.SignV1(nil); err == nil {
+		t.Error("SignV1 without keys succeeded")
+	}
+}
+
+func TestSignV1EC(t *testing.T) {
+	ec, err := GenerateDebugCert("ec", 0, EC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := NewApkSign(testZip(t, "AndroidManifest.xml", "manifest", "classes.dex", "dex"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := z.SignV1([]*SigningCert{ec}, WithV1SignedWith(SchemeV2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := testV1Entries(t, signed)
+	if _, ok := files["META-INF/CERT.RSA"]; ok {
+		t.Error("ECDSA signature block named CERT.RSA")
+	}
+	certs, err := VerifyPKCS7(files["META-INF/CERT.EC"], files["META-INF/CERT.SF"])
+	if err != nil || len(certs) != 1 || !certs[0].Equal(ec.Certificate) {
+		t.Fatalf("CERT.EC: %v, %v", certs, err)
+	}
+
+	// v1 and v2 with the same EC key, as SignSchemes does for a low minSdkVersion
+	sz, err := NewApkSign(signed)
+	if err != nil || !sz.IsV1Signed {
+		t.Fatalf("IsV1Signed = %v, %v", sz != nil && sz.IsV1Signed, err)
+	}
+	dual, err := sz.SignV2([]*SigningCert{ec}, WithMinSdk(21))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vz, err := NewApkSign(dual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = vz.VerifyAll(VerifyOptions{}).Err(); err != nil {
+		t.Fatalf("VerifyAll: %v", err)
+	}
+	signers, err := vz.Signers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v1 int
+	for _, s := range signers {
+		if s.Scheme == SchemeV1 {
+			v1++
+		}
+	}
+	if v1 != 1 {
+		t.Errorf("%d v1 signers, want 1", v1)
+	}
+
+	// keys v1 cannot use are refused with an error rather than a broken signature
+	ed, err := GenerateDebugCert("ed", 0, Ed25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = z.SignV1([]*SigningCert{ed}); err == nil {
+		t.Error("SignV1 accepted an Ed25519 key")
+	}
+}
+
+func TestSignV1SHA1(t *testing.T) {
+	z, err := NewApkSign(testZip(t, "AndroidManifest.xml", "manifest", "classes.dex", "dex"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Android 4.2 and earlier only verify SHA-1 digests
+	signed, err := z.SignV1(testSigningCerts(t), WithMinSdk(17))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := testV1Entries(t, signed)
+	mf, sf := files[v1Manifest], files["META-INF/CERT.SF"]
+	mfSum := sha1.Sum(mf)
+	if got := mainAttribute(sf, "SHA1-Digest-Manifest"); got != base64.StdEncoding.EncodeToString(mfSum[:]) {
+		t.Errorf("SHA1-Digest-Manifest = %q", got)
+	}
+	dexSum := sha1.Sum(files["classes.dex"])
+	if !strings.Contains(string(mf), "Name: classes.dex\r\nSHA1-Digest: "+base64.StdEncoding.EncodeToString(dexSum[:])+"\r\n") {
+		t.Errorf("manifest lacks the SHA-1 digest of classes.dex:\n%s", mf)
+	}
+	if strings.Contains(string(mf)+string(sf), "SHA-256") {
+		t.Error("SHA-256 digests written for min sdk 17")
+	}
+	sd, _, err := parsePKCS7(files["META-INF/CERT.RSA"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alg := sd.SignerInfos[0].DigestAlgorithm.Algorithm; !alg.Equal(oidSHA1) {
+		t.Errorf("signature digest %v, want SHA-1", alg)
+	}
+	if _, err = VerifyPKCS7(files["META-INF/CERT.RSA"], sf); err != nil {
+		t.Error(err)
+	}
+
+	// from Android 4.3 on SHA-256 is used
+	if signed, err = z.SignV1(testSigningCerts(t), WithMinSdk(18)); err != nil {
+		t.Fatal(err)
+	}
+	if sf = testV1Entries(t, signed)["META-INF/CERT.SF"]; mainAttribute(sf, "SHA-256-Digest-Manifest") == "" {
+		t.Errorf("min sdk 18 signature file:\n%s", sf)
+	}
+
+	ec, err := GenerateDebugCert("ec", 0, EC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = z.SignV1([]*SigningCert{ec}, WithMinSdk(17)); err == nil {
+		t.Error("SignV1 accepted an EC key for min sdk 17")
+	}
+}
+
+func TestVerifyV1(t *testing.T) {
+	z, err := NewApkSign(testZip(t, "AndroidManifest.xml", "manifest", "classes.dex", "dex", "assets/a.txt", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := testSigningCerts(t)
+	for _, min := range []int{17, 18} {
+		signed, err := z.SignV1(keys, WithMinSdk(min))
+		if err != nil {
+			t.Fatal(err)
+		}
+		vz, err := NewApkSign(signed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = vz.VerifyV1(); err != nil {
+			t.Fatalf("min sdk %d: %v", min, err)
+		}
+		r := vz.VerifyAll(VerifyOptions{MinSDK: min})
+		if sr := r.Scheme(SchemeV1); !r.Verified || !sr.Verified {
+			t.Errorf("min sdk %d: VerifyAll: %+v, %v", min, sr, r.Errors())
+		}
+		if len(r.Signers) != 1 || r.Signers[0].Scheme != SchemeV1 || !r.Signers[0].Certs[0].Equal(keys[0].Certificate) {
+			t.Errorf("min sdk %d: signers %+v", min, r.Signers)
+		}
+	}
+	if err = z.VerifyV1(); err == nil {
+		t.Error("VerifyV1 of an unsigned file succeeded")
+	}
+
+	signed, err := z.SignV1(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := testV1Entries(t, signed)
+	digest := func(data string) string {
+		sum := sha256.Sum256([]byte(data))
+		return base64.StdEncoding.EncodeToString(sum[:])
+	}
+	for _, c := range []struct {
+		name   string
+		change func(files map[string][]byte)
+		want   error
+	}{
+		{"entry", func(f map[string][]byte) { f["classes.dex"] = []byte("patched") }, ErrDigestMismatch},
+		{"entry and manifest", func(f map[string][]byte) {
+			f["classes.dex"] = []byte("patched")
+			f[v1Manifest] = []byte(strings.Replace(string(f[v1Manifest]), digest("dex"), digest("patched"), 1))
+		}, ErrDigestMismatch},
+		{"added entry", func(f map[string][]byte) { f["assets/b.txt"] = []byte("b") }, nil},
+		{"removed entry", func(f map[string][]byte) { delete(f, "assets/a.txt") }, nil},
+		{"manifest removed", func(f map[string][]byte) { delete(f, v1Manifest) }, nil},
+		{"signature file", func(f map[string][]byte) {
+			f["META-INF/CERT.SF"] = []byte(strings.Replace(string(f["META-INF/CERT.SF"]), "1.0", "1.1", 1))
+		}, ErrPKCS7},
+		{"signature block", func(f map[string][]byte) {
+			block := bytes.Clone(f["META-INF/CERT.RSA"])
+			block[len(block)-1] ^= 1
+			f["META-INF/CERT.RSA"] = block
+		}, ErrPKCS7},
+	} {
+		tampered := maps.Clone(files)
+		c.change(tampered)
+		var entries []string
+		for _, name := range slices.Sorted(maps.Keys(tampered)) {
+			entries = append(entries, name, string(tampered[name]))
+		}
+		vz, err := NewApkSign(testZip(t, entries...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = vz.VerifyV1(); err == nil || c.want != nil && !errors.Is(err, c.want) {
+			t.Errorf("%s: VerifyV1 = %v, want %v", c.name, err, c.want)
+		}
+		if r := vz.VerifyAll(VerifyOptions{}); r.Verified || r.Scheme(SchemeV1).Err == nil {
+			t.Errorf("%s: VerifyAll verified", c.name)
+		}
+	}
+}
+
+func TestParseJARSections(t *testing.T) {
+	mf := "Manifest-Version: 1.0\r\n\r\nName: assets/very-long\r\n -name.txt\r\nSHA1-Digest: abc\r\n\r\n\r\nName: b\nSHA1-Digest: def\n\n"
+	main, sections, err := parseJARSections([]byte(mf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if main.attrs["manifest-version"] != "1.0" || string(main.raw) != "Manifest-Version: 1.0\r\n\r\n" {
+		t.Errorf("main section %q: %v", main.raw, main.attrs)
+	}
+	if len(sections) != 2 || sections[0].name != "assets/very-long-name.txt" || sections[1].name != "b" ||
+		sections[0].attrs["sha1-digest"] != "abc" || string(sections[1].raw) != "Name: b\nSHA1-Digest: def\n\n" {
+		t.Fatalf("sections %+v", sections)
+	}
+	for _, bad := range []string{"", " continued\r\n", "Name\r\n", "A: 1\r\n\r\nB: 2\r\n", "A: 1\r\n\r\nName: a\r\n\r\nName: a\r\n"} {
+		if _, _, err = parseJARSections([]byte(bad)); err == nil {
+			t.Errorf("%q: no error", bad)
+		}
+	}
+}
+
+func testV1Entries(t *testing.T, apk []byte) map[string][]byte {
+	r, err := zip.NewReader(bytes.NewReader(apk), int64(len(apk)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{}
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name], err = io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return files
+}
+
+func TestAppendManifestLine(t *testing.T) {
+	b := appendManifestLine(nil, "Name", strings.Repeat("x", 200))
+	lines := strings.Split(strings.TrimSuffix(string(b), "\r\n"), "\r\n")
+	if len(lines[0]) != 70 || len(lines[1]) != 70 || lines[1][0] != ' ' {
+		t.Errorf("lines %q", lines)
+	}
+	if got := strings.ReplaceAll(string(b), "\r\n ", ""); got != "Name: "+strings.Repeat("x", 200)+"\r\n" {
+		t.Errorf("unwrapped %q", got)
+	}
+}
@@ -2,279 +2,289 @@ package signv2
 
 import (
 	"bytes"
+	"context"
 	"crypto"
-	"crypto/rsa"
-	"crypto/sha256"
-	"crypto/sha512"
+	_ "crypto/sha512" // registers crypto.SHA512 for Digester and signature hashing
 	"crypto/x509"
 	"encoding/binary"
 	"errors"
-	"log"
+	"fmt"
+	"maps"
+	"slices"
 )
 
+// Digest is a content digest in the signed data of a signer: the digest of the APK contents computed
+// as the signature algorithm AlgorithmID requires.
 type Digest struct {
 	AlgorithmID uint32
 	Digest      []byte
 }
 
+// Attribute is an additional attribute of the signed data, such as AttrStrippingProtection, whose
+// Value the signers protect along with the digests.
 type Attribute struct {
 	ID    uint32
 	Value []byte
 }
 
+// SignedData is the part of a signer its signatures cover: one digest per signature algorithm, the
+// certificate chain, the first of which holds the public key, and the additional attributes.
 type SignedData struct {
 	Digests    []*Digest
 	Certs      []*x509.Certificate
 	Attributes []*Attribute
-	Raw        []byte // used to store raw bytes for signing & verifying
+	MinSDK     uint32 // v3 only
+	MaxSDK     uint32 // v3 only
+	// Raw is the encoding the signatures were made over, as parsed or last signed by Signer.Sign;
+	// Marshal encodes the fields instead, so Raw goes stale when they are changed.
+	Raw []byte
 }
 
+// Signature is the signature of the signed data with the algorithm AlgorithmID.
 type Signature struct {
 	AlgorithmID uint32
 	Signature   []byte
 }
 
+// Signer is one signer of a v2 or v3 block: its signed data, the signatures of it, one per
+// algorithm, and the public key they verify with, a DER SubjectPublicKeyInfo.
 type Signer struct {
 	SignedData *SignedData
 	Signatures []*Signature
 	PublicKey  []byte
+	MinSDK     uint32 // v3 only
+	MaxSDK     uint32 // v3 only
 }
 
+// V2Block is the value of the APK Signature Scheme v2 pair of the signing block, the list of its
+// signers. Parse it from a file with ApkSign.V2Block, from a signing block with ParseV2Block, or
+// from the value of the pair with UnmarshalV2Block; Marshal encodes it back, byte for byte for a
+// well-formed block that was parsed and not changed. To hand-craft a block, e.g. for test vectors,
+// change a parsed one, re-sign each signer with Signer.Sign, and insert the result with
+// EncodeSigningBlock and ApkSign.InjectBeforeCDApk.
 type V2Block struct {
 	Signers []*Signer
 }
 
-func ParseV2Block(block []byte) (*V2Block, error) {
-	var size32 uint32
-
-	v2 := &V2Block{}
-
-	// check the key/value pair block; we expect only one entry, w/ key 0x7109871a (meaning signature v2 specifically)
-	asv2Len, block := pop64(block)
-	if uint64(len(block)) != asv2Len {
-		log.Println("ParseV2Block", "unsupported: multiple ID/Value pair blocks at top level", asv2Len, len(block))
-		return nil, errors.New("unsupported: multiple ID/Value pair blocks at top level")
-		// So there are 3 reasons there might be multiple blocks here:
-		//
-		// 1. there is a second v2 signing block, which is probably an attack (i.e. attempt to break verification)
-		// 2. there is a v3 or later signing block, which doesn't exist at time of this writing and we can't cope with
-		//    (Android itself has to be able to deal with this, but we don't)
-		// 3. someone added a block with their own ID in here as a way to store some random data
-		//
-		// The spec says "ID-value pairs with unknown IDs should be ignored when interpreting the
-		// block", so only #1 should be fatal here, technically. But out of an abundance of caution we
-		// abort anyway. This can be fixed later if there's an actual need to; this should be fine for
-		// the forseeable future.
-	}
-
-	// verify the block ID
-	size32, block = pop32(block)
-	if size32 != 0x7109871a {
-		return nil, errors.New("unsupported: not an Android v2 signature block")
-		// note that even when/if they add a v3 signing scheme, we still should error out here b/c we
-		// won't know how to handle it anyway
-	}
-
-	// we now know we have exactly 1 signature block, w/ ID 0x7109871a, of length size64 - 4
-
-	// now extract out all the signer blocks
-	size32, block = pop32(block) // length of all signer blocks combined
-	if size32 != uint32(len(block)) {
-		return nil, errors.New("spurious data after signers sequence")
-	}
-	for len(block) > 0 {
-		var signer []byte
-		if len(block) < 5 { // 4 bytes for size prefix plus at least 1 byte for data
-			return nil, errors.New("malformed signing block - short signer")
-		}
-		size32, block = pop32(block)
-		if size32 > uint32(len(block)) {
-			return nil, errors.New("malformed signing block - long signer")
-		}
+// ParseV2Block parses the v2 pair of block, the contents of an APK Signing Block between its leading
+// and trailing size fields, without verifying it. A block without a v2 pair gives ErrNotV2Signed.
+func ParseV2Block(block []byte) (_ *V2Block, err error) {
+	defer catch(&err, "ParseV2Block", BlockName(BlockIDV2))
+	// Spec: "ID-value pairs with unknown IDs should be ignored when interpreting the block". A second
+	// v2 pair is probably an attempt to break verification, so that one is fatal.
+	pairs, err := ParseSigningBlock(block)
+	if err != nil {
+		return nil, err
+	}
+	value, err := findPair(pairs, BlockIDV2)
+	if err != nil {
+		return nil, locateAt(err, "signing block", 0)
+	}
+	if value == nil {
+		return nil, ErrNotV2Signed
+	}
+	signers, err := parseSigners(value, false)
+	if err != nil {
+		return nil, locate(err, "v2 block", block, value)
+	}
+	return &V2Block{signers}, nil
+}
 
-		// handle current signer block
-		signer, block = popN(block, int(size32))
-		s, err := ParseSigner(signer)
+// UnmarshalV2Block parses the value of a v2 pair, as GetBlock(BlockIDV2) returns it or
+// V2Block.Marshal encodes it, without verifying it.
+func UnmarshalV2Block(value []byte) (_ *V2Block, err error) {
+	defer catch(&err, "UnmarshalV2Block", BlockName(BlockIDV2))
+	signers, err := parseSigners(value, false)
+	if err != nil {
+		return nil, err
+	}
+	return &V2Block{signers}, nil
+}
+
+// Marshal encodes v2 as the value of the v2 pair: the length-prefixed sequence of its signers.
+func (v2 *V2Block) Marshal() []byte {
+	var signers [][]byte
+	for _, s := range v2.Signers {
+		signers = append(signers, push32(s.Marshal()))
+	}
+	return push32(concat(signers...))
+}
+
+// Pair returns v2 as the pair of a signing block, see EncodeSigningBlock.
+func (v2 *V2Block) Pair() *Pair {
+	return &Pair{BlockIDV2, v2.Marshal()}
+}
+
+// parseSigners parses the length-prefixed sequence of signers that makes up the value of a v2 or v3
+// block. v3 signers carry min/max SDK versions in addition to the v2 fields.
+func parseSigners(block []byte, v3 bool) ([]*Signer, error) {
+	var signers []*Signer
+	r := newReader(block, ErrBadSigBlock)
+	seq := r.section("signers")
+	r.end("signers")
+	for seq.len() > 0 {
+		signer := seq.prefixed("signer")
+		if seq.Err() != nil {
+			break
+		}
+		s, err := parseSigner(signer, v3)
 		if err != nil {
-			return nil, err
+			return nil, locate(err, "signer", block, signer)
 		}
-		if s != nil {
-			v2.Signers = append(v2.Signers, s)
+		signers = append(signers, s)
+		if len(signers) > MaxSigners {
+			return nil, fmt.Errorf("%w: more than %d signers", ErrLimitExceeded, MaxSigners)
 		}
 	}
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
+	return signers, nil
+}
 
-	return v2, nil
+// ParseSignedData parses the encoding of v2 signed data, as SignedData.Marshal returns it.
+func ParseSignedData(sd []byte) (_ *SignedData, err error) {
+	defer catch(&err, "ParseSignedData", "signed data")
+	return parseSignedData(sd, false)
 }
 
-func ParseSignedData(sd []byte) (*SignedData, error) {
+func parseSignedData(sd []byte, v3 bool) (*SignedData, error) {
 	raw := make([]byte, len(sd))
 	copy(raw, sd)
+	r := newReader(sd, ErrBadSigBlock)
 
-	if len(sd) < 12 {
-		return nil, errors.New("malformed signed data block - not even enough bytes for length prefixes")
-	}
-
-	// digests section
-	var digestsLen uint32
-	var digestsBytes []byte
+	// digests section: at least one length-prefixed (algorithm ID, length-prefixed digest)
 	var digests []*Digest
-	digestsLen, sd = pop32(sd)
-	if digestsLen > uint32(len(sd))-8 || digestsLen < 4 {
-		return nil, errors.New("malformed signed data block - bogus digests sub block")
-	}
-	digestsBytes, sd = popN(sd, int(digestsLen))
-	for len(digestsBytes) > 0 {
-		if len(digestsBytes) < 9 {
-			return nil, errors.New("malformed digests block - not enough bytes")
-		}
-		var algID, digestLen, curBlockLen uint32
-		var curDigestBytes []byte
-		curBlockLen, digestsBytes = pop32(digestsBytes)
-		if int(curBlockLen) > len(digestsBytes) {
-			return nil, errors.New("malformed digests block - long count")
-		}
-		algID, digestsBytes = pop32(digestsBytes)
-		digestLen, digestsBytes = pop32(digestsBytes)
-		curDigestBytes, digestsBytes = popN(digestsBytes, int(digestLen))
-
-		digests = append(digests, &Digest{algID, curDigestBytes})
+	at := r.buf
+	ds := r.section("digests")
+	for ds.len() > 0 {
+		d := ds.section("digest")
+		algID := d.uint32("digest")
+		digest := d.prefixed("digest")
+		d.end("digest")
+		digests = append(digests, &Digest{algID, digest})
+	}
+	if len(digests) == 0 {
+		r.fail("digests", at, "no digests")
 	}
 
 	// certificates section
-	var certsLen, curCert uint32
-	var certsBytes, curCertBytes []byte
 	var certs []*x509.Certificate
-	certsLen, sd = pop32(sd)
-	if certsLen > uint32(len(sd)) {
-		return nil, errors.New("malformed certificates block - long length")
-	}
-	certsBytes, sd = popN(sd, int(certsLen))
-	for len(certsBytes) > 0 {
-		if len(certsBytes) < 5 {
-			return nil, errors.New("malformed certificates block - not enough bytes for a cert")
+	cs := r.section("certificates")
+	for cs.len() > 0 {
+		b := cs.prefixed("certificate")
+		if cs.Err() != nil {
+			break
 		}
-		curCert, certsBytes = pop32(certsBytes)
-		curCertBytes, certsBytes = popN(certsBytes, int(curCert))
-		parsedCerts, err := x509.ParseCertificates(curCertBytes)
-		if err != nil {
-			return nil, err
+		parsedCerts, err := x509.ParseCertificates(b)
+		if err == nil && len(parsedCerts) == 0 {
+			err = errors.New("malformed signed data block - missing cert")
 		}
-		if parsedCerts == nil || len(parsedCerts) < 1 {
-			return nil, errors.New("malformed signed data block - missing cert")
+		if err != nil {
+			r.failErr("certificate", b, err)
+			break
 		}
 		certs = append(certs, parsedCerts[0])
+		if len(certs) > MaxCertificates {
+			return nil, fmt.Errorf("%w: more than %d certificates", ErrLimitExceeded, MaxCertificates)
+		}
 	}
 
-	// additional attributes section
-	var attrsLen, attrID uint32
-	var attrsBytes []byte
-	var attrs []*Attribute
-	attrsLen, sd = pop32(sd)
-	if attrsLen > uint32(len(sd)) {
-		return nil, errors.New("malformed attributes block - long length")
-	}
-	attrsBytes, sd = popN(sd, int(attrsLen))
-	for len(attrsBytes) > 0 {
-		if len(attrsBytes) < 5 {
-			return nil, errors.New("malformed attributes block - not enough bytes for key and value")
-		}
-		attrID, attrsBytes = pop32(attrsBytes)
-		attrs = append(attrs, &Attribute{attrID, attrsBytes}) // TODO: probably need to copy these bytes
+	// v3 only: SDK range the signer applies to, also repeated outside the signed data
+	var minSDK, maxSDK uint32
+	if v3 {
+		minSDK = r.uint32("signed data")
+		maxSDK = r.uint32("signed data")
 	}
 
-	if len(sd) != 0 {
-		return nil, errors.New("malformed signed data block - extra bytes")
+	// additional attributes section: length-prefixed (ID, value)
+	var attrs []*Attribute
+	as := r.section("attributes")
+	for as.len() > 0 {
+		a := as.section("attribute")
+		attrID := a.uint32("attribute")
+		attrs = append(attrs, &Attribute{attrID, a.rest()})
 	}
+	r.end("signed data")
 
-	return &SignedData{digests, certs, attrs, raw}, nil
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
+	return &SignedData{digests, certs, attrs, minSDK, maxSDK, raw}, nil
 }
 
-func ParseSignature(sigs []byte) ([]*Signature, error) {
+// ParseSignature parses the sequence of length-prefixed signatures of a signer.
+func ParseSignature(sigs []byte) (_ []*Signature, err error) {
+	defer catch(&err, "ParseSignature", "signatures")
 	var ret []*Signature
-	var size, algID, sigSize uint32
-	var sig []byte
-
-	for len(sigs) > 0 {
-		if len(sigs) < 5 {
-			return nil, errors.New("malformed signatures block - short sig block")
-		}
-
-		size, sigs = pop32(sigs) // size of current signature
-		algID, sigs = pop32(sigs)
-		sigSize, sigs = pop32(sigs)
-		sig, sigs = popN(sigs, int(sigSize))
-
-		if sigSize+4+4 != size {
-			return nil, errors.New("malformed signatures block - mismatched sizes")
-		}
-
+	r := newReader(sigs, ErrBadSigBlock)
+	for r.len() > 0 {
+		s := r.section("signature")
+		algID := s.uint32("signature")
+		sig := s.prefixed("signature")
+		s.end("signature")
 		ret = append(ret, &Signature{algID, sig})
 	}
-
+	if err = r.Err(); err != nil {
+		return nil, err
+	}
 	return ret, nil
 }
 
-func ParseSigner(signer []byte) (*Signer, error) {
-	if len(signer) < 12 {
-		return nil, errors.New("malformed signer block - not even enough for size prefixes")
-	}
+// ParseSigner parses a v2 signer, as Signer.Marshal encodes it.
+func ParseSigner(signer []byte) (_ *Signer, err error) {
+	defer catch(&err, "ParseSigner", "signer")
+	return parseSigner(signer, false)
+}
 
-	var size32 uint32
+func parseSigner(signer []byte, v3 bool) (*Signer, error) {
+	r := newReader(signer, ErrBadSigBlock)
 
 	// handle signed data sub block
-	size32, signer = pop32(signer) // length of signed data section
-	if size32 < 12 {
-		return nil, errors.New("malformed signed data block - not even enough for size prefixes")
-	}
-	if size32 > uint32(len(signer)) {
-		return nil, errors.New("malformed signed data block - longer than available bytes")
+	signedData := r.prefixed("signed data")
+	if err := r.Err(); err != nil {
+		return nil, err
 	}
-	var signedData []byte
-	signedData, signer = popN(signer, int(size32))
-
-	sds, err := ParseSignedData(signedData)
+	sds, err := parseSignedData(signedData, v3)
 	if err != nil {
-		return nil, err
+		return nil, locate(err, "signed data", signer, signedData)
 	}
-	if sds == nil {
-		return nil, errors.New("malformed signed data block - block is empty")
+
+	var minSDK, maxSDK uint32
+	if v3 {
+		minSDK = r.uint32("signer")
+		maxSDK = r.uint32("signer")
 	}
 
 	// handle signatures sub block
-	if len(signer) < 8 {
-		return nil, errors.New("malformed or missing signature block")
-	}
-	size32, signer = pop32(signer) // size of signature block
-	if size32 < 4 {
-		return nil, errors.New("malformed signature block - not even enough for size prefixes")
-	}
-	if size32 > uint32(len(signer))-4 {
-		return nil, errors.New("malformed signature block - longer than available bytes")
+	at := r.buf
+	signatures := r.prefixed("signatures")
+	if err := r.Err(); err != nil {
+		return nil, err
 	}
-	var signatures []byte
-	signatures, signer = popN(signer, int(size32))
 	ss, err := ParseSignature(signatures)
 	if err != nil {
-		return nil, err
+		return nil, locate(err, "signatures", signer, signatures)
 	}
-	if ss == nil {
-		return nil, errors.New("malformed signatures block - block is empty")
+	if len(ss) == 0 {
+		r.fail("signatures", at, "no signatures")
 	}
 
 	// handle public key sub block
-	if len(signer) < 4 {
-		return nil, errors.New("malformed or missing public key block")
-	}
-	size32, signer = pop32(signer)
-	if size32 != uint32(len(signer)) {
-		return nil, errors.New("malformed signed data block - erroneous public key length")
-	}
-	publicKey := signer[:]
+	publicKey := r.prefixed("public key")
+	r.end("signer")
 
-	return &Signer{sds, ss, publicKey}, nil
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
+	return &Signer{sds, ss, publicKey, minSDK, maxSDK}, nil
 }
 
 func (v2 *V2Block) Verify(z *ApkSign) error {
+	return v2.verify(context.Background(), z)
+}
+
+func (v2 *V2Block) verify(ctx context.Context, z *ApkSign) error {
 	// ApkSign constructor handles these 3 requirements from the Spec:
 	// "Two size fields of APK Signing Block contain the same value."
 	// "ZIP Central Directory is immediately followed by ZIP End of Central Directory record."
@@ -284,258 +294,328 @@ func (v2 *V2Block) Verify(z *ApkSign) error {
 	if len(v2.Signers) < 1 {
 		return errors.New("no signers in signing block")
 	}
-	for _, signer := range v2.Signers {
-		var sig *Signature
-		var dig *Digest
-		var algoID uint32
-
-		// Spec: "Choose the strongest supported signature algorithm ID from signatures. The strength
-		// ordering is up to each implementation/platform version."
-		// TODO: Currently we only support RSA, as our primary purpose is signing. Expanding this is fairly
-		// straightforward, though low priority
-		for i, s := range signer.Signatures {
-			if s.AlgorithmID == 0x0103 || s.AlgorithmID == 0x0104 && s.AlgorithmID > algoID {
-				// ignore non-RSA algorithms for now, and favor SHA512 if it's present
-				algoID = s.AlgorithmID
-				sig = s
-				dig = signer.SignedData.Digests[i]
-			}
-		}
-		if algoID == 0 {
-			return errors.New("unknown algorithm ID in Signature") // we don't know how to verify
-		}
 
-		// Spec: "Verify the corresponding signature from signatures against signed data using public key."
-		pubkey, err := x509.ParsePKIXPublicKey(signer.PublicKey)
-		if err != nil {
+	// content digests are expensive (a full pass over the file), so compute each at most once even
+	// when several signers use the same algorithm
+	contentDigests := make(map[crypto.Hash][]byte)
+
+	for i, signer := range v2.Signers {
+		if err := signer.verify(ctx, z, contentDigests); err != nil {
+			if len(v2.Signers) > 1 {
+				err = fmt.Errorf("signer #%d: %w", i+1, err)
+			}
 			return err
 		}
-		switch pubkey.(type) {
-		case *rsa.PublicKey:
-		default:
-			return errors.New("unsupported signature algorithm (only RSA currently supported)")
-		}
-		switch algoID {
-		case 0x0103:
-			hashed := sha256.Sum256(signer.SignedData.Raw)
-			err = rsa.VerifyPKCS1v15(pubkey.(*rsa.PublicKey), crypto.SHA256, hashed[:], sig.Signature)
-			if err != nil {
-				return err
-			}
-		case 0x0104:
-			hashed := sha512.Sum512(signer.SignedData.Raw)
-			err = rsa.VerifyPKCS1v15(pubkey.(*rsa.PublicKey), crypto.SHA512, hashed[:], sig.Signature)
-			if err != nil {
-				return err
-			}
-		default:
-			return errors.New("unsupported signature/hash combination")
+	}
+
+	return nil
+}
+
+// Certificates returns the certificate of each signer, in block order, for files signed by several
+// parties; nil entries stand for signers without certificates, which do not verify.
+func (v2 *V2Block) Certificates() []*x509.Certificate {
+	certs := make([]*x509.Certificate, len(v2.Signers))
+	for i, s := range v2.Signers {
+		if len(s.SignedData.Certs) > 0 {
+			certs[i] = s.SignedData.Certs[0]
 		}
+	}
+	return certs
+}
+
+// verify performs the per-signer steps of the spec, shared by the v2 and v3 schemes. Content digests
+// are looked up in, and added to, contentDigests.
+func (signer *Signer) verify(ctx context.Context, z *ApkSign, contentDigests map[crypto.Hash][]byte) error {
+	// Spec: "Choose the strongest supported signature algorithm ID from signatures. The strength
+	// ordering is up to each implementation/platform version."
+	sig := strongestSignature(signer.Signatures, z.options.genericZip)
+	if sig == nil {
+		return ErrUnknownAlgorithm // we don't know how to verify
+	}
+	hash, _ := AlgorithmID(sig.AlgorithmID).hash()
+
+	// Spec: "Verify the corresponding signature from signatures against signed data using public key."
+	if err := AlgorithmID(sig.AlgorithmID).verify(signer.PublicKey, signer.SignedData.Raw, sig.Signature); err != nil {
+		return err
+	}
 
-		// Spec: "Verify that the ordered list of signature algorithm IDs in digests and signatures is identical."
-		if len(signer.Signatures) != len(signer.SignedData.Digests) {
-			return errors.New("signature/digest length mismatch")
+	// Spec: "Verify that the ordered list of signature algorithm IDs in digests and signatures is identical."
+	if len(signer.Signatures) != len(signer.SignedData.Digests) {
+		return errors.New("signature/digest length mismatch")
+	}
+	var dig *Digest
+	for i := range signer.Signatures {
+		if signer.Signatures[i].AlgorithmID != signer.SignedData.Digests[i].AlgorithmID {
+			return errors.New("signature/digest algorithm mismatch")
 		}
-		for i := range signer.Signatures {
-			if signer.Signatures[i].AlgorithmID != signer.SignedData.Digests[i].AlgorithmID {
-				return errors.New("signature/digest algorithm mismatch")
-			}
+		if signer.SignedData.Digests[i].AlgorithmID == sig.AlgorithmID {
+			dig = signer.SignedData.Digests[i]
 		}
+	}
 
-		// Spec: "Compute the digest of APK contents using the same digest algorithm as the digest
-		// algorithm used by the signature algorithm. Verify that the computed digest is identical to the
-		// corresponding digest from digests."
-		var newHash crypto.Hash
-		switch algoID {
-		case 0x0103:
-			newHash = crypto.SHA256
-		case 0x0104:
-			newHash = crypto.SHA512
-		default:
-			// this should not be possible due to similar switch above, though
-			return errors.New("unsupported signature/hash combination")
+	// Spec: "Compute the digest of APK contents using the same digest algorithm as the digest
+	// algorithm used by the signature algorithm. Verify that the computed digest is identical to the
+	// corresponding digest from digests."
+	ourDigest, ok := contentDigests[hash]
+	if !ok {
+		var err error
+		if ourDigest, err = z.contentDigest(ctx, hash); err != nil {
+			return err
 		}
+		contentDigests[hash] = ourDigest
+	}
+	if !bytes.Equal(ourDigest, dig.Digest) {
+		return ErrDigestMismatch
+	}
 
-		endOfFileSection := z.asv2Offset
-		if endOfFileSection == 0 {
-			endOfFileSection = z.cdOffset
-		}
+	// Spec: "Verify that SubjectPublicKeyInfo of the first certificate of certificates is identical
+	// to public key."
+	if len(signer.SignedData.Certs) == 0 {
+		return errors.New("no certificates in signed data")
+	}
+	if !bytes.Equal(signer.SignedData.Certs[0].RawSubjectPublicKeyInfo, signer.PublicKey) {
+		return errors.New("SubjectPublicKeyInfo mismatch")
+	}
+	return nil
+}
 
-		d := NewDigester(newHash)
-		d.Write(z.raw[:endOfFileSection])       // send files section to be hashed
-		d.Write(z.raw[z.cdOffset:z.eocdOffset]) // send CD to be hashed as separate block per spec
+// strongestSignature returns the signature with the strongest algorithm this package can verify, or nil.
+// Algorithms restricted to WithGenericZip are only considered if generic is set.
+func strongestSignature(sigs []*Signature, generic bool) *Signature {
+	var best *Signature
+	var bestAlg *Algorithm
+	for _, s := range sigs {
+		a := LookupAlgorithm(AlgorithmID(s.AlgorithmID))
+		if a == nil || a.GenericZipOnly && !generic {
+			continue
+		}
+		if best == nil || a.Strength > bestAlg.Strength || a.Strength == bestAlg.Strength && s.AlgorithmID > best.AlgorithmID {
+			best, bestAlg = s, a
+		}
+	}
+	return best
+}
 
-		// Per spec, we have to... "revise"... the EOCD block so that its pointer to the CD actually
-		// points to the offset of the ASv2 block. This is because as the ASv2 block changes in length,
-		// it changes the CD offset. Since the ASv2 block is added after the fact and a changing EOCD
-		// would alter the hash, the CD is pointed to the ASv2 before being sent to be hashed.
-		// Essentially, this hashes the "pristine" ApkSign, as it would be if the ASv2 block didn't exist.
-		//
-		// Note that this is a RAM-only operation for signing purposes; on disk, this would be an invalid
-		// ApkSign file.
-		revisedEOCD := make([]byte, z.size-int64(z.eocdOffset))
-		copy(revisedEOCD, z.raw[z.eocdOffset:])
-		binary.LittleEndian.PutUint32(revisedEOCD[16:20], uint32(endOfFileSection))
+func (v2 *V2Block) Sign(z *ApkSign, keys []*SigningCert) ([]byte, error) {
+	block, err := v2.signingBlock(context.Background(), z, keys, z.options)
+	if err != nil {
+		return nil, err
+	}
+	// now we have the final bytes, tell the ApkSign to inject them into its .zip file at the appropriate location
+	return z.injected(z.padFiles(block))
+}
 
-		d.Write(revisedEOCD) // send revised EOCD to be hashed as separate block per spec
+// signingBlock signs z with keys and returns the complete APK Signing Block to insert before the CD.
+func (v2 *V2Block) signingBlock(ctx context.Context, z *ApkSign, keys []*SigningCert, o options) ([]byte, error) {
+	ps := o.blockProcessors()
+	preserved, err := z.preservedPairs(ps, func(hash crypto.Hash) ([]byte, error) {
+		return z.optionsContentDigest(ctx, hash, o, 0)
+	})
+	if err != nil {
+		return nil, err
+	}
+	processed, err := z.processedPairs(ps)
+	if err != nil {
+		return nil, err
+	}
+	preserved = append(preserved, processed...)
+	digest := func(hash crypto.Hash) ([]byte, error) { return z.signingDigest(ctx, hash, o) }
+	return v2.buildSigningBlock(keys, digest, preserved, o)
+}
 
-		ourDigest := d.Sum(nil)
+// buildSigningBlock returns the APK Signing Block for content whose v2 content digests are returned
+// by digest, followed by the already encoded pairs in preserved. With WithDeterministic, the signers
+// are in the order their keys first appear in keys; Ed25519 keys need WithGenericZip.
+func (v2 *V2Block) buildSigningBlock(keys []*SigningCert, digest func(crypto.Hash) ([]byte, error), preserved []byte, o options) ([]byte, error) {
+	signers, err := buildSigners(keys, digest, o, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	v2.Signers = signers
+
+	// at this point we have a fully populated ASv2 tree representation, so now we just marshal it,
+	// assembling the ID/value pair in a pooled scratch buffer to spare the intermediate copies
+	buf := getBuffer()
+	defer putBuffer(buf)
+	var u32 [4]byte
+	var u64 [8]byte
+	buf.Write(u64[:]) // length prefix of the ID/value pair, filled in below
+	binary.LittleEndian.PutUint32(u32[:], 0x7109871a)
+	buf.Write(u32[:]) // the magic ID of the signing block
+	buf.Write(u32[:]) // length prefix of the 'signers' sequence, filled in below
+	for _, signer := range v2.Signers {
+		m := signer.Marshal()
+		binary.LittleEndian.PutUint32(u32[:], uint32(len(m)))
+		buf.Write(u32[:])
+		buf.Write(m)
+	}
+	pair := buf.Bytes()
+	binary.LittleEndian.PutUint64(pair, uint64(len(pair)-8))
+	binary.LittleEndian.PutUint32(pair[12:], uint32(len(pair)-16))
+	buf.Write(preserved)
+	asv2 := buf.Bytes()
 
-		ok := bytes.Equal(ourDigest, dig.Digest)
-		if !ok {
-			return errors.New("hash mismatch")
-		}
+	final := paddedSigningBlock(asv2)
 
-		// Spec: "Verify that SubjectPublicKeyInfo of the first certificate of certificates is identical
-		// to public key."
-		signer := v2.Signers[0]
-		cpk := signer.SignedData.Certs[0].RawSubjectPublicKeyInfo
-		ok = bytes.Equal(cpk, signer.PublicKey)
-		if !ok {
-			return errors.New("SubjectPublicKeyInfo mismatch")
-		}
+	// just a quick sanity check to make sure we generated a block that parses
+	_, er := ParseV2Block(asv2)
+	if er != nil {
+		return nil, er
 	}
+	return final, nil
+}
 
-	return nil
+// sdkRange is the range of platform levels a v3 signer applies to.
+type sdkRange struct {
+	min, max uint32
 }
 
-func (v2 *V2Block) Sign(z *ApkSign, keys []*SigningCert) ([]byte, error) {
-	v2.Signers = make([]*Signer, 0)
+// buildSigners signs the content digests returned by digest with keys, one signer per certificate.
+// With WithDeterministic, the signers are in the order their keys first appear in keys. attrs are
+// added to the signed data of every signer; sdk is nil for v2 signers and the SDK range of v3 ones.
+func buildSigners(keys []*SigningCert, digest func(crypto.Hash) ([]byte, error), o options, attrs []*Attribute, sdk *sdkRange) ([]*Signer, error) {
+	signers := make([]*Signer, 0)
 
 	// the ASv2 scheme spec does not actually forbid having multiple 'signer' blocks with the same
 	// public keymatter, but the clear intention is that these be grouped; so first, batch up
 	// SigningCerts that share the same hash
 	keyMap := make(map[string][]*SigningCert)
+	var certOrder []string // cert hashes in order of first appearance
 	for _, sk := range keys {
 		cfgs, ok := keyMap[sk.CertHash]
 		if !ok {
 			cfgs = make([]*SigningCert, 0)
+			certOrder = append(certOrder, sk.CertHash)
 		}
 		keyMap[sk.CertHash] = append(cfgs, sk)
 	}
+	if !o.deterministic {
+		certOrder = slices.Collect(maps.Keys(keyMap))
+	}
 
-	for _, sks := range keyMap {
+	for _, certHash := range certOrder {
+		sks := keyMap[certHash]
 		s := &Signer{}
-		s.SignedData = &SignedData{}
+		s.SignedData = &SignedData{Attributes: attrs}
 		s.Signatures = make([]*Signature, 0)
 		s.PublicKey = make([]byte, len(sks[0].Certificate.RawSubjectPublicKeyInfo))
 		copy(s.PublicKey, sks[0].Certificate.RawSubjectPublicKeyInfo)
 		s.SignedData.Certs = []*x509.Certificate{sks[0].Certificate} // certHash guarantees these are all the same
 		s.SignedData.Digests = make([]*Digest, 0)
+		if sdk != nil {
+			s.MinSDK, s.MaxSDK = sdk.min, sdk.max
+			s.SignedData.MinSDK, s.SignedData.MaxSDK = sdk.min, sdk.max
+		}
 
 		// each entry under the same cert will differ as a tuple of (KeyType, HashType), which is "algorithm ID" per ASv2
 		for _, sk := range sks {
-			d := &Digest{}
-			sig := &Signature{}
-
-			var algoID uint32
-			var hasher crypto.Hash
-			switch sk.Type {
-			case RSA:
-				switch sk.Hash {
-				case SHA256:
-					algoID = 0x0103
-					hasher = crypto.SHA256
-				case SHA512:
-					algoID = 0x0104
-					hasher = crypto.SHA512
-				default:
-					return nil, errors.New("unsupported hash algorithm specified")
-				}
-			default:
-				return nil, errors.New("unsupported key type specified")
+			algoID, alg, err := sk.algorithm(o)
+			if err != nil {
+				return nil, err
 			}
-
-			d.AlgorithmID = algoID
-			sig.AlgorithmID = algoID
-
-			endOfFileSection := z.asv2Offset
-			if endOfFileSection == 0 {
-				endOfFileSection = z.cdOffset
+			d := &Digest{AlgorithmID: uint32(algoID)}
+			if d.Digest, err = digest(alg.Hash); err != nil {
+				return nil, err
 			}
-
-			dg := NewDigester(hasher)
-			dg.Write(z.raw[:endOfFileSection])       // send files section to be hashed
-			dg.Write(z.raw[z.cdOffset:z.eocdOffset]) // send CD to be hashed as separate block per spec
-
-			revisedEOCD := make([]byte, z.size-int64(z.eocdOffset))
-			copy(revisedEOCD, z.raw[z.eocdOffset:])
-			binary.LittleEndian.PutUint32(revisedEOCD[16:20], uint32(endOfFileSection))
-			dg.Write(revisedEOCD) // send revised EOCD to be hashed as separate block per spec
-
-			d.Digest = dg.Sum(nil)
-
 			s.SignedData.Digests = append(s.SignedData.Digests, d)
-			s.Signatures = append(s.Signatures, sig)
+			s.Signatures = append(s.Signatures, &Signature{AlgorithmID: uint32(algoID)})
 		}
 
-		sd := s.SignedData.Marshal()
+		sd := s.SignedData.marshal(sdk != nil)
 		for i, sk := range sks {
-			var hasher crypto.Hash
-			var err error
-			switch sk.Type {
-			case RSA:
-				switch sk.Hash {
-				case SHA256:
-					hasher = crypto.SHA256
-				case SHA512:
-					hasher = crypto.SHA512
-				}
-			}
-
-			sig := s.Signatures[i]
-
-			sig.Signature, err = sk.Sign(sd, hasher)
+			_, alg, err := sk.algorithm(o)
 			if err != nil {
 				return nil, err
 			}
+			if s.Signatures[i].Signature, err = sk.sign(alg, sd); err != nil {
+				return nil, err
+			}
 		}
 
-		v2.Signers = append(v2.Signers, s)
+		signers = append(signers, s)
 	}
+	return signers, nil
+}
 
-	// at this point we have a fully populated ASv2 tree representation, so now we just marshal it to []byte
-	blocks := make([][]byte, 0)
-	for _, signer := range v2.Signers {
-		blocks = append(blocks, push32(signer.Marshal()))
+// algorithm returns the signature algorithm of the key: its Algorithm if set, otherwise the one
+// its type and hash select.
+func (sk *SigningKey) algorithm(o options) (AlgorithmID, *Algorithm, error) {
+	id := sk.Algorithm
+	if id == 0 {
+		switch {
+		case sk.Type == RSA && sk.Hash == SHA256:
+			id = RSAPKCS1v15WithSHA256
+		case sk.Type == RSA && sk.Hash == SHA512:
+			id = RSAPKCS1v15WithSHA512
+		case sk.Type == RSA:
+			return 0, nil, errors.New("unsupported hash algorithm specified")
+		case sk.Type == EC && sk.Hash == SHA256:
+			id = ECDSAWithSHA256
+		case sk.Type == EC && sk.Hash == SHA512:
+			id = ECDSAWithSHA512
+		case sk.Type == EC:
+			return 0, nil, errors.New("unsupported hash algorithm specified")
+		case sk.Type == Ed25519:
+			id = Ed25519WithSHA512
+		default:
+			return 0, nil, errors.New("unsupported key type specified")
+		}
 	}
-	signers := concat(blocks...)
-	asv2 := push32(signers)
-
-	// asv2Value now contains the concatenation of all 'signers' blocks; this will be the value of the
-	// signing-block attr in the outermost ASv2 block wrapper
-
-	// add the key for the signing block
-	asv2 = push32(asv2) // add 4 bytes for the magic ID
-	binary.LittleEndian.PutUint32(asv2[:4], 0x7109871a)
-
-	// add the length prefix for the ID/value pair
-	asv2 = push64(asv2)
+	a := LookupAlgorithm(id)
+	if a == nil || a.Sign == nil {
+		return 0, nil, fmt.Errorf("%w 0x%04x", ErrUnknownAlgorithm, uint32(id))
+	}
+	if a.GenericZipOnly && !o.genericZip {
+		return 0, nil, fmt.Errorf("%w: algorithm 0x%04x", ErrGenericZipOnly, uint32(id))
+	}
+	return id, a, nil
+}
 
-	// create & write the final block
-	finalSize := len(asv2) + 8 + 16    // size is key/value portion + uint64 footer size + 16-byte footer magic string
-	final := make([]byte, finalSize+8) // need another uint64 to prepend another copy of size
-	binary.LittleEndian.PutUint64(final[:8], uint64(finalSize))
-	copy(final[8:], asv2)
-	binary.LittleEndian.PutUint64(final[8+len(asv2):], uint64(finalSize))
-	copy(final[len(final)-16:], []byte("APK Sig Block 42"))
+// sign signs data with the key using alg.
+func (sk *SigningKey) sign(alg *Algorithm, data []byte) ([]byte, error) {
+	return alg.Sign(sk.signer(), data)
+}
 
-	// just a quick sanity check to make sure we generated a block that parses
-	_, er := ParseV2Block(asv2)
-	if er != nil {
-		return nil, er
+// Sign signs the v2 encoding of the signed data of s again with key, after its fields were changed:
+// each signature gets a new value for its algorithm, which must suit the key, and Raw and PublicKey
+// are updated. The digests and certificates are left as they are, so that they may be made not to
+// match on purpose; Sign only produces valid signatures over them.
+func (s *Signer) Sign(key *SigningCert) error {
+	if err := key.Resolve(); err != nil {
+		return err
+	}
+	sd := s.SignedData.Marshal()
+	for _, sig := range s.Signatures {
+		a := LookupAlgorithm(AlgorithmID(sig.AlgorithmID))
+		if a == nil || a.Sign == nil {
+			return fmt.Errorf("%w 0x%04x", ErrUnknownAlgorithm, sig.AlgorithmID)
+		}
+		var err error
+		if sig.Signature, err = key.sign(a, sd); err != nil {
+			return err
+		}
 	}
-
-	// now we have the final bytes, tell the ApkSign to inject them into its .zip file at the appropriate location
-	return z.InjectBeforeCD(final), nil
+	s.SignedData.Raw = sd
+	s.PublicKey = bytes.Clone(key.Certificate.RawSubjectPublicKeyInfo)
+	return nil
 }
 
+// Marshal encodes s as a v2 signer.
 func (s *Signer) Marshal() []byte {
+	return s.marshal(false)
+}
+
+// marshal encodes a v2 signer, or with v3 a v3 signer, which has its SDK range after the signed data.
+func (s *Signer) marshal(v3 bool) []byte {
 	if s == nil {
 		return nil
 	}
 
-	sd := push32(s.SignedData.Marshal())
+	sd := push32(s.SignedData.marshal(v3))
+	var sdk []byte
+	if v3 {
+		sdk = binary.LittleEndian.AppendUint32(binary.LittleEndian.AppendUint32(nil, s.MinSDK), s.MaxSDK)
+	}
 
 	ses := make([][]byte, 0)
 	for _, s := range s.Signatures {
@@ -543,10 +623,17 @@ func (s *Signer) Marshal() []byte {
 	}
 	sigs := push32(concat(ses...))
 	pk := push32(s.PublicKey)
-	return concat(sd, sigs, pk)
+	return concat(sd, sdk, sigs, pk)
 }
 
+// Marshal encodes sd as v2 signed data from its fields, ignoring Raw.
 func (sd *SignedData) Marshal() []byte {
+	return sd.marshal(false)
+}
+
+// marshal encodes v2 signed data, or with v3 v3 signed data, which has the SDK range between the
+// certificates and the attributes.
+func (sd *SignedData) marshal(v3 bool) []byte {
 	if sd == nil {
 		return nil
 	}
@@ -572,9 +659,14 @@ func (sd *SignedData) Marshal() []byte {
 	}
 	attrs := push32(concat(blocks...))
 
-	return concat(digests, certs, attrs)
+	var sdk []byte
+	if v3 {
+		sdk = binary.LittleEndian.AppendUint32(binary.LittleEndian.AppendUint32(nil, sd.MinSDK), sd.MaxSDK)
+	}
+	return concat(digests, certs, sdk, attrs)
 }
 
+// Marshal encodes s as an element of the signatures sequence, without its length prefix.
 func (s *Signature) Marshal() []byte {
 	if s == nil {
 		return nil
@@ -585,6 +677,7 @@ func (s *Signature) Marshal() []byte {
 	return sig
 }
 
+// Marshal encodes a as an element of the attributes sequence, without its length prefix.
 func (a *Attribute) Marshal() []byte {
 	if a == nil {
 		return nil
@@ -595,6 +688,7 @@ func (a *Attribute) Marshal() []byte {
 	return attr
 }
 
+// Marshal encodes d as an element of the digests sequence, without its length prefix.
 func (d *Digest) Marshal() []byte {
 	if d == nil {
 		return nil
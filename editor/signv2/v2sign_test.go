@@ -0,0 +1,141 @@
+package signv2
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"strings"
+	"testing"
+)
+
+func TestVerifyV2(t *testing.T) {
+	signed := testSignedApk(t)
+	z, err := NewApkSign(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !z.IsAPK || !z.IsV2Signed {
+		t.Fatalf("IsAPK=%v IsV2Signed=%v", z.IsAPK, z.IsV2Signed)
+	}
+	if err := z.VerifyV2(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyV2DetectsTampering(t *testing.T) {
+	signed := testSignedApk(t)
+	z, err := NewApkSign(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sections := map[string]uint64{
+		"files section":     10,
+		"central directory": z.cdOffset + 20,  // CRC of the first entry
+		"eocd":              z.eocdOffset + 8, // entries on this disk
+	}
+	for name, off := range sections {
+		t.Run(name, func(t *testing.T) {
+			b := append([]byte(nil), signed...)
+			b[off] ^= 0xff
+			z, err := NewApkSign(b)
+			if err != nil {
+				return // the change broke the zip itself, which is also a rejection
+			}
+			if err := z.VerifyV2(); err == nil {
+				t.Fatalf("tampered %s still verifies", name)
+			}
+		})
+	}
+}
+
+func TestVerifyV2MultipleSigners(t *testing.T) {
+	z, err := NewApkSign(testZip(t, "AndroidManifest.xml", "manifest"), WithDeterministic())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ec := generateECCert(t, elliptic.P256())
+	keys := []*SigningCert{generateSigningCert(t, "first"), ec}
+	signed, err := z.SignV2(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if z, err = NewApkSign(signed); err != nil {
+		t.Fatal(err)
+	}
+	certs, err := z.VerifyV2Signers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 2 || certs[0].Subject.CommonName != "first" || !certs[1].Equal(ec.Certificate) {
+		t.Fatalf("certificates = %v", certs)
+	}
+	if r := z.VerifyAll(VerifyOptions{}); !r.Verified || len(r.Signers) != 2 {
+		t.Errorf("VerifyAll: verified %t, %d signers", r.Verified, len(r.Signers))
+	}
+
+	// every signer must verify, not just one of them
+	v2, err := z.V2Block()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2.Signers[1].Signatures[0].Signature[0] ^= 1
+	value := push32(concat(push32(v2.Signers[0].Marshal()), push32(v2.Signers[1].Marshal())))
+	bad := testWithSigningBlock(t, z, &Pair{BlockIDV2, value})
+	if err = bad.VerifyV2(); err == nil || !strings.Contains(err.Error(), "signer #2") {
+		t.Errorf("VerifyV2 with a bad second signer = %v", err)
+	}
+}
+
+func TestV2BlockRoundTrip(t *testing.T) {
+	z, err := NewApkSign(testSignedApk(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := z.GetBlock(BlockIDV2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, err := UnmarshalV2Block(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v2.Marshal(), value) {
+		t.Fatal("Marshal does not give back the parsed value")
+	}
+
+	// a custom attribute only verifies once the signer is signed again
+	unsigned, err := z.StripSignatures(SchemeV2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := NewApkSign(unsigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	custom := &Attribute{0x12345678, []byte("custom")}
+	signer := v2.Signers[0]
+	signer.SignedData.Attributes = append(signer.SignedData.Attributes, custom)
+	crafted, err := u.InjectBeforeCDApk(EncodeSigningBlock(v2.Pair()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = crafted.VerifyV2(); err == nil {
+		t.Error("verified a changed signer that was not signed again")
+	}
+	if err = signer.Sign(testSigningCerts(t)[0]); err != nil {
+		t.Fatal(err)
+	}
+	if crafted, err = u.InjectBeforeCDApk(EncodeSigningBlock(v2.Pair())); err != nil {
+		t.Fatal(err)
+	}
+	if err = crafted.VerifyV2(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := crafted.V2Block()
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs := got.Signers[0].SignedData.Attributes
+	if len(attrs) == 0 || attrs[len(attrs)-1].ID != custom.ID || string(attrs[len(attrs)-1].Value) != "custom" {
+		t.Errorf("attributes %+v", attrs)
+	}
+}
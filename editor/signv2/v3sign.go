@@ -0,0 +1,550 @@
+package signv2
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Attribute IDs found in the signed data of v2/v3 signers.
+const (
+	// AttrProofOfRotation holds the signing certificate lineage of a v3 signer.
+	AttrProofOfRotation uint32 = 0x3ba06f8c
+	// AttrRotationMinSDK is set on the v3 signer when a v3.1 block carries the rotated key; its value
+	// is the first platform level the v3.1 block targets.
+	AttrRotationMinSDK uint32 = 0x559f8b02
+	// AttrStrippingProtection is set on v2 signers when the APK is also signed with a newer scheme.
+	AttrStrippingProtection uint32 = 0xbeeff00d
+)
+
+// Platform levels at which each scheme was introduced.
+const (
+	minSDKV2  = 24
+	minSDKV3  = 28
+	minSDKV31 = 33
+)
+
+// Scheme names a signature scheme, as reported by SchemeAt.
+type Scheme string
+
+const (
+	SchemeV1  Scheme = "v1"
+	SchemeV2  Scheme = "v2"
+	SchemeV3  Scheme = "v3"
+	SchemeV31 Scheme = "v3.1"
+	SchemeV4  Scheme = "v4"
+)
+
+// V3Block is the value of a v3 (ID 0xf05368c0) or v3.1 (ID 0x1b93ad61) pair of the APK Signing Block.
+// Its layout is that of a v2 block, except that every signer is limited to a range of platform
+// levels.
+//
+// See https://source.android.com/security/apksigning/v3
+type V3Block struct {
+	ID      uint32
+	Signers []*Signer
+}
+
+// ParseV3Block extracts the pair with the given ID (BlockIDV3 or BlockIDV31) from the contents of an
+// APK Signing Block. It returns nil and no error if there is no such pair.
+func ParseV3Block(block []byte, id uint32) (_ *V3Block, err error) {
+	defer catch(&err, "ParseV3Block", BlockName(id))
+	pairs, err := ParseSigningBlock(block)
+	if err != nil {
+		return nil, err
+	}
+	value, err := findPair(pairs, id)
+	if err != nil || value == nil {
+		return nil, locateAt(err, "signing block", 0)
+	}
+	signers, err := parseSigners(value, true)
+	if err != nil {
+		return nil, locate(err, BlockName(id), block, value)
+	}
+	return &V3Block{id, signers}, nil
+}
+
+// signerFor returns the signer whose SDK range contains sdk, or nil.
+func (v3 *V3Block) signerFor(sdk int) *Signer {
+	if v3 == nil {
+		return nil
+	}
+	for _, s := range v3.Signers {
+		if uint32(sdk) >= s.MinSDK && uint32(sdk) <= s.MaxSDK {
+			return s
+		}
+	}
+	return nil
+}
+
+// Verify checks every signer of the block the way v2 signers are checked, plus the v3 specific
+// requirements: the SDK range must be the same inside and outside the signed data, ranges of
+// different signers must not overlap, and a proof-of-rotation lineage, if present, must verify and
+// end in the signer's certificate. Lineages of different signers must agree with each other.
+func (v3 *V3Block) Verify(z *ApkSign) error {
+	return v3.verify(context.Background(), z)
+}
+
+func (v3 *V3Block) verify(ctx context.Context, z *ApkSign) error {
+	if len(v3.Signers) < 1 {
+		return errors.New("no signers in v3 signing block")
+	}
+
+	contentDigests := make(map[crypto.Hash][]byte)
+	var lineages []Lineage
+	for _, signer := range v3.Signers {
+		if signer.MinSDK != signer.SignedData.MinSDK || signer.MaxSDK != signer.SignedData.MaxSDK {
+			return errors.New("v3 signer SDK versions do not match signed data")
+		}
+		if signer.MinSDK > signer.MaxSDK {
+			return fmt.Errorf("v3 signer min SDK %d greater than max SDK %d", signer.MinSDK, signer.MaxSDK)
+		}
+		if err := signer.verify(ctx, z, contentDigests); err != nil {
+			return err
+		}
+		lineage, err := signer.Lineage()
+		if err != nil {
+			return err
+		}
+		if lineage == nil {
+			continue
+		}
+		if err = lineage.Verify(); err != nil {
+			return err
+		}
+		if !bytes.Equal(lineage[len(lineage)-1].Cert.Raw, signer.SignedData.Certs[0].Raw) {
+			return errors.New("signing certificate lineage does not end in the signer's certificate")
+		}
+		lineages = append(lineages, lineage)
+	}
+
+	signers := append([]*Signer(nil), v3.Signers...)
+	sort.Slice(signers, func(i, j int) bool { return signers[i].MinSDK < signers[j].MinSDK })
+	for i := 1; i < len(signers); i++ {
+		if signers[i].MinSDK <= signers[i-1].MaxSDK {
+			return errors.New("v3 signers have overlapping SDK ranges")
+		}
+	}
+
+	// the lineages of all signers describe the same rotation history, so each must be a prefix of the
+	// longest one
+	sort.Slice(lineages, func(i, j int) bool { return len(lineages[i]) < len(lineages[j]) })
+	for i := 1; i < len(lineages); i++ {
+		if !lineages[i-1].isPrefixOf(lineages[i]) {
+			return errors.New("inconsistent signing certificate lineages")
+		}
+	}
+	return nil
+}
+
+// attribute returns the value of the signed attribute with the given ID, or nil.
+func (signer *Signer) attribute(id uint32) []byte {
+	for _, a := range signer.SignedData.Attributes {
+		if a.ID == id {
+			return a.Value
+		}
+	}
+	return nil
+}
+
+// Lineage returns the proof-of-rotation lineage of a v3 signer, or nil if the signer has none.
+func (signer *Signer) Lineage() (_ Lineage, err error) {
+	defer catch(&err, "Lineage", "lineage")
+	attr := signer.attribute(AttrProofOfRotation)
+	if attr == nil {
+		return nil, nil
+	}
+	return ParseLineage(attr)
+}
+
+// LineageNode is one certificate of a signing certificate lineage. Each node is signed by the key
+// of the node before it.
+type LineageNode struct {
+	Cert *x509.Certificate
+	// ParentSigAlgorithm is the algorithm the previous node's key signs this node with, as recorded
+	// in the signed data.
+	ParentSigAlgorithm uint32
+	Flags              uint32
+	// SigAlgorithm is the algorithm this node's key uses to sign the next node.
+	SigAlgorithm uint32
+	Signature    []byte
+
+	signedData []byte
+}
+
+// Lineage is a signing certificate history, oldest certificate first.
+type Lineage []*LineageNode
+
+// ParseLineage parses the value of a proof-of-rotation attribute.
+func ParseLineage(b []byte) (_ Lineage, err error) {
+	defer catch(&err, "ParseLineage", "lineage")
+	r := newReader(b, ErrBadSigBlock)
+	if version := r.uint32("lineage"); r.Err() == nil && version != 1 {
+		return nil, fmt.Errorf("unsupported lineage version %d", version)
+	}
+
+	var lineage Lineage
+	for r.len() > 0 {
+		node := r.section("lineage node")
+		signedData := node.prefixed("lineage node")
+		n := &LineageNode{signedData: signedData}
+		n.Flags = node.uint32("lineage node")
+		n.SigAlgorithm = node.uint32("lineage node")
+		n.Signature = node.prefixed("lineage node")
+		node.end("lineage node")
+
+		// signed data: length-prefixed certificate, then the algorithm the parent signed it with
+		sd := node.sub(signedData)
+		certBytes := sd.prefixed("lineage certificate")
+		n.ParentSigAlgorithm = sd.uint32("lineage node")
+		sd.end("lineage node")
+		if err = r.Err(); err != nil {
+			return nil, err
+		}
+		if n.Cert, err = x509.ParseCertificate(certBytes); err != nil {
+			return nil, locate(err, "lineage certificate", b, certBytes)
+		}
+
+		lineage = append(lineage, n)
+		if len(lineage) > MaxCertificates {
+			return nil, fmt.Errorf("%w: lineage longer than %d", ErrLimitExceeded, MaxCertificates)
+		}
+	}
+	if err = r.Err(); err != nil {
+		return nil, err
+	}
+	if len(lineage) == 0 {
+		return nil, errors.New("empty signing certificate lineage")
+	}
+	return lineage, nil
+}
+
+// Verify checks that every node is signed by the key of the node before it, with the algorithm that
+// node declared.
+func (l Lineage) Verify() error {
+	for i := 1; i < len(l); i++ {
+		parent, n := l[i-1], l[i]
+		if n.ParentSigAlgorithm != parent.SigAlgorithm {
+			return fmt.Errorf("lineage node %d: signing algorithm mismatch", i)
+		}
+		err := AlgorithmID(parent.SigAlgorithm).verify(parent.Cert.RawSubjectPublicKeyInfo, n.signedData, n.Signature)
+		if err != nil {
+			return fmt.Errorf("lineage node %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// Capabilities a lineage node grants its certificate, the flags of a LineageNode. They say what an
+// app signed with a later key still allows an app signed with this older one to do.
+const (
+	LineageInstalledData uint32 = 1 << iota // may update an install signed with this certificate
+	LineageSharedUID                        // may share a user ID with apps signed with it
+	LineagePermission                       // is granted signature permissions it defines
+	LineageRollback                         // may replace the later certificates: a rollback
+	LineageAuth                             // is trusted by authenticators that check the signer
+)
+
+// DefaultLineageFlags are the capabilities apksigner grants a rotated-away certificate: all but
+// LineageRollback.
+const DefaultLineageFlags = LineageInstalledData | LineageSharedUID | LineagePermission | LineageAuth
+
+// NewLineage starts a signing certificate lineage with the certificate of first, the key the app was
+// signed with originally.
+func NewLineage(first *SigningCert, flags uint32) (Lineage, error) {
+	if err := first.Resolve(); err != nil {
+		return nil, err
+	}
+	signed := binary.LittleEndian.AppendUint32(push32(first.Certificate.Raw), 0) // no parent algorithm
+	return Lineage{{Cert: first.Certificate, Flags: flags, signedData: signed}}, nil
+}
+
+// Rotate returns the lineage extended with the certificate of next, signed by parent, the key of
+// the last certificate of l. l itself is left as is.
+func (l Lineage) Rotate(parent, next *SigningCert, flags uint32) (Lineage, error) {
+	if len(l) == 0 {
+		return nil, errors.New("empty signing certificate lineage")
+	}
+	for _, sc := range []*SigningCert{parent, next} {
+		if err := sc.Resolve(); err != nil {
+			return nil, err
+		}
+	}
+	last := l[len(l)-1]
+	if !bytes.Equal(last.Cert.Raw, parent.Certificate.Raw) {
+		return nil, errors.New("parent key is not the last certificate of the lineage")
+	}
+	alg, impl, err := parent.algorithm(options{})
+	if err != nil {
+		return nil, err
+	}
+	signed := binary.LittleEndian.AppendUint32(push32(next.Certificate.Raw), uint32(alg))
+	sig, err := parent.sign(impl, signed)
+	if err != nil {
+		return nil, err
+	}
+	out := append(Lineage(nil), l[:len(l)-1]...)
+	updated := *last
+	updated.SigAlgorithm = uint32(alg)
+	return append(out, &updated, &LineageNode{
+		Cert:               next.Certificate,
+		ParentSigAlgorithm: uint32(alg),
+		Flags:              flags,
+		Signature:          sig,
+		signedData:         signed,
+	}), nil
+}
+
+// Marshal encodes the lineage as the value of a proof-of-rotation attribute, the format ParseLineage
+// reads.
+func (l Lineage) Marshal() []byte {
+	nodes := [][]byte{binary.LittleEndian.AppendUint32(nil, 1)} // version
+	for _, n := range l {
+		node := push32(n.signedData)
+		node = binary.LittleEndian.AppendUint32(node, n.Flags)
+		node = binary.LittleEndian.AppendUint32(node, n.SigAlgorithm)
+		nodes = append(nodes, push32(concat(node, push32(n.Signature))))
+	}
+	return concat(nodes...)
+}
+
+func (l Lineage) isPrefixOf(other Lineage) bool {
+	if len(l) > len(other) {
+		return false
+	}
+	for i := range l {
+		if !bytes.Equal(l[i].Cert.Raw, other[i].Cert.Raw) {
+			return false
+		}
+	}
+	return true
+}
+
+// V3Blocks parses the v3 and v3.1 blocks without verifying them. Either is nil if absent.
+func (apkSign *ApkSign) V3Blocks() (v3, v31 *V3Block, err error) {
+	defer catch(&err, "V3Blocks", "signing block")
+	if apkSign.rawASv2 == nil {
+		return nil, nil, errors.New("file has no APK Signing Block")
+	}
+	if v3, err = ParseV3Block(apkSign.rawASv2, BlockIDV3); err != nil {
+		return nil, nil, apkSign.inBlock(err)
+	}
+	if v31, err = ParseV3Block(apkSign.rawASv2, BlockIDV31); err != nil {
+		return nil, nil, apkSign.inBlock(err)
+	}
+	return v3, v31, nil
+}
+
+// VerifyV3 returns a non-nil error if the file has no v3 signature, or if its v3 or v3.1 signature
+// does not verify. When a v3.1 block is present the v3 signers must announce, through the rotation
+// min SDK attribute, the platform level from which the v3.1 block takes over.
+func (apkSign *ApkSign) VerifyV3() error {
+	return apkSign.VerifyV3Context(context.Background())
+}
+
+// VerifyV3Context is like VerifyV3 but stops computing digests and returns ctx.Err() once ctx is
+// done.
+func (apkSign *ApkSign) VerifyV3Context(ctx context.Context) (err error) {
+	defer catch(&err, "VerifyV3", BlockName(BlockIDV3))
+	v3, v31, err := apkSign.V3Blocks()
+	if err != nil {
+		return err
+	}
+	if v3 == nil {
+		return errors.New("v3 verification attempted on non-v3-signed file")
+	}
+	if err = v3.verify(ctx, apkSign); err != nil {
+		return err
+	}
+	if v31 == nil {
+		return nil
+	}
+	if err = v31.verify(ctx, apkSign); err != nil {
+		return fmt.Errorf("v3.1: %v", err)
+	}
+
+	minSDK := v31.Signers[0].MinSDK
+	for _, s := range v31.Signers {
+		if s.MinSDK < minSDK {
+			minSDK = s.MinSDK
+		}
+	}
+	for _, s := range v3.Signers {
+		attr := s.attribute(AttrRotationMinSDK)
+		if len(attr) != 4 {
+			return errors.New("v3.1 block present but v3 signer has no rotation min SDK")
+		}
+		if rotationMinSDK := binary.LittleEndian.Uint32(attr); rotationMinSDK != minSDK {
+			return fmt.Errorf("rotation min SDK %d does not match v3.1 signers (%d)", rotationMinSDK, minSDK)
+		}
+	}
+	return nil
+}
+
+// SchemeAt reports which signature scheme a device running the given platform level uses to verify
+// the file. Blocks without a signer targeting that level are skipped, the same way the platform
+// does. It does not verify the signatures themselves.
+func (apkSign *ApkSign) SchemeAt(sdk int) (_ Scheme, err error) {
+	defer catch(&err, "SchemeAt", "signing block")
+	if apkSign.rawASv2 != nil {
+		v3, v31, err := apkSign.V3Blocks()
+		if err != nil {
+			return "", err
+		}
+		if sdk >= minSDKV31 && v31.signerFor(sdk) != nil {
+			return SchemeV31, nil
+		}
+		if sdk >= minSDKV3 && v3.signerFor(sdk) != nil {
+			return SchemeV3, nil
+		}
+		pairs, err := apkSign.signingBlockPairs()
+		if err != nil {
+			return "", err
+		}
+		v2, err := findPair(pairs, BlockIDV2)
+		if err != nil {
+			return "", err
+		}
+		if sdk >= minSDKV2 && v2 != nil {
+			return SchemeV2, nil
+		}
+	}
+	if apkSign.IsV1Signed {
+		return SchemeV1, nil
+	}
+	return "", fmt.Errorf("no signature applies to SDK %d", sdk)
+}
+
+// SignV3 signs the file with APK Signature Scheme v2 and v3. Android 9 and later verify the v3
+// signature, which carries lineage, if not nil, as proof that the key was rotated from the first
+// certificate of the lineage to the last. keys sign the v3 block and must share a single
+// certificate, the last of lineage, since a v3 block has one signer per range of platform levels.
+// The v2 signers get the stripping protection attribute, so that removing the v3 block is detected.
+// As with SignV2, a file with a misaligned stored entry is first aligned unless WithoutAlign is
+// given.
+//
+// Devices before Android 9 only see the v2 signature. Without a rotation keys sign it as well; with
+// one it is made, as apksigner does, with the keys of the first certificate of lineage, given with
+// WithOldestSigner, so that those devices accept the file as an update of an app installed with the
+// original key. SignV3 fails if they are missing.
+func (apkSign *ApkSign) SignV3(keys []*SigningCert, lineage Lineage, opts ...Option) ([]byte, error) {
+	return apkSign.SignV3Context(context.Background(), keys, lineage, opts...)
+}
+
+// SignV3Context is like SignV3 but stops computing digests and returns ctx.Err() once ctx is done.
+func (apkSign *ApkSign) SignV3Context(ctx context.Context, keys []*SigningCert, lineage Lineage, opts ...Option) (_ []byte, err error) {
+	o := apkSign.options.with(opts)
+	defer o.record(OpSign, apkSign.size, time.Now(), &err)
+	defer catch(&err, "SignV3", "file")
+	if len(keys) == 0 {
+		return nil, errors.New("no signing keys")
+	}
+	if err = apkSign.prepareSigning(keys, o); err != nil {
+		return nil, err
+	}
+	for _, sk := range keys[1:] {
+		if sk.CertHash != keys[0].CertHash {
+			return nil, errors.New("v3 signing keys must share one certificate")
+		}
+	}
+	var attrs []*Attribute
+	v2Keys := keys
+	if lineage != nil {
+		if err = lineage.Verify(); err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(lineage[len(lineage)-1].Cert.Raw, keys[0].Certificate.Raw) {
+			return nil, errors.New("signing certificate lineage does not end in the signer's certificate")
+		}
+		attrs = []*Attribute{{AttrProofOfRotation, lineage.Marshal()}}
+		if len(lineage) > 1 {
+			if v2Keys, err = apkSign.oldestSigner(lineage, o); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	z, err := apkSign.aligned(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+	ps := o.blockProcessors()
+	preserved, err := z.preservedPairs(ps, func(hash crypto.Hash) ([]byte, error) {
+		return z.optionsContentDigest(ctx, hash, o, 0)
+	})
+	if err != nil {
+		return nil, err
+	}
+	processed, err := z.processedPairs(ps)
+	if err != nil {
+		return nil, err
+	}
+	digest := func(hash crypto.Hash) ([]byte, error) { return z.signingDigest(ctx, hash, o) }
+	v2, err := buildSigners(v2Keys, digest, o, []*Attribute{{AttrStrippingProtection, binary.LittleEndian.AppendUint32(nil, 3)}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	minSDK := uint32(max(o.minSdk, minSDKV3))
+	v3, err := buildSigners(keys, digest, o, attrs, &sdkRange{minSDK, 0x7fffffff})
+	if err != nil {
+		return nil, err
+	}
+	pairs := concat(
+		(&Pair{BlockIDV2, encodeSigners(v2, false)}).encode(),
+		(&Pair{BlockIDV3, encodeSigners(v3, true)}).encode(),
+		preserved, processed)
+	if _, err = ParseV3Block(pairs, BlockIDV3); err != nil {
+		return nil, err
+	}
+
+	signed, err := z.injected(z.padFiles(paddedSigningBlock(pairs)))
+	if err != nil {
+		return nil, err
+	}
+	if apkSign.SelfCheck || SelfCheckEnabled() {
+		z, err := NewApkSignNoCopy(signed)
+		if err == nil {
+			err = z.VerifyV3()
+		}
+		if err == nil {
+			err = checkSigned(signed, false)
+		}
+		if err != nil {
+			return nil, &SelfCheckError{"sign", err}
+		}
+	}
+	return signed, nil
+}
+
+// oldestSigner returns the keys of WithOldestSigner, checked against the first certificate of
+// lineage.
+func (apkSign *ApkSign) oldestSigner(lineage Lineage, o options) ([]*SigningCert, error) {
+	if len(o.oldestSigner) == 0 {
+		return nil, errors.New("signing with a rotated lineage needs the keys of its first certificate, see WithOldestSigner")
+	}
+	if err := apkSign.prepareSigning(o.oldestSigner, o); err != nil {
+		return nil, err
+	}
+	for _, sk := range o.oldestSigner {
+		if !bytes.Equal(sk.Certificate.Raw, lineage[0].Cert.Raw) {
+			return nil, errors.New("oldest signer does not match the first certificate of the lineage")
+		}
+	}
+	return o.oldestSigner, nil
+}
+
+// encodeSigners encodes the value of a v2, or with v3 a v3, pair: the length-prefixed sequence of
+// length-prefixed signers.
+func encodeSigners(signers []*Signer, v3 bool) []byte {
+	var out [][]byte
+	for _, s := range signers {
+		out = append(out, push32(s.marshal(v3)))
+	}
+	return push32(concat(out...))
+}
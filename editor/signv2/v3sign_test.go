@@ -0,0 +1,258 @@
+package signv2
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/binary"
+	"testing"
+)
+
+func u32(v uint32) []byte {
+	return binary.LittleEndian.AppendUint32(nil, v)
+}
+
+// testV3Signer encodes a v3 signer signing z's content with sc.
+func testV3Signer(t *testing.T, z *ApkSign, sc *SigningCert, minSDK, maxSDK uint32, attrs ...*Attribute) []byte {
+	sdk := concat(u32(minSDK), u32(maxSDK))
+	return testSigner(t, z, sc, sdk, attrs)
+}
+
+// testV2Signer encodes a v2 signer signing z's content with sc.
+func testV2Signer(t *testing.T, z *ApkSign, sc *SigningCert, attrs ...*Attribute) []byte {
+	return testSigner(t, z, sc, nil, attrs)
+}
+
+// testSigner encodes a signer; sdk holds the v3 min/max SDK versions, nil for v2. The signed data
+// is laid out by hand in the order of the spec, rather than by SignedData.Marshal, so that the
+// tests catch a parser and encoder that agree with each other but not with the platform.
+func testSigner(t *testing.T, z *ApkSign, sc *SigningCert, sdk []byte, attrs []*Attribute) []byte {
+	signed := testSignedData(uint32(RSAPKCS1v15WithSHA256), testContentDigest(t, z), sc.Certificate.Raw, sdk, attrs)
+	sig, err := sc.Sign(signed, crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigs := push32(push32((&Signature{uint32(RSAPKCS1v15WithSHA256), sig}).Marshal()))
+	return concat(push32(signed), sdk, sigs, push32(sc.Certificate.RawSubjectPublicKeyInfo))
+}
+
+// testSignedData encodes signed data with one digest and one certificate: digests, certificates,
+// then for v3 the SDK range, then the additional attributes.
+func testSignedData(alg uint32, digest, cert, sdk []byte, attrs []*Attribute) []byte {
+	var encoded [][]byte
+	for _, a := range attrs {
+		encoded = append(encoded, push32(concat(u32(a.ID), a.Value)))
+	}
+	return concat(
+		push32(push32(concat(u32(alg), push32(digest)))),
+		push32(push32(cert)),
+		sdk,
+		push32(concat(encoded...)))
+}
+
+// testLineage encodes a proof-of-rotation attribute for the given certificates, oldest first.
+func testLineage(t *testing.T, certs ...*SigningCert) *Attribute {
+	nodes := [][]byte{}
+	var parentAlg uint32
+	for i, sc := range certs {
+		signed := concat(push32(sc.Certificate.Raw), u32(parentAlg))
+		var sig []byte
+		if i > 0 {
+			var err error
+			if sig, err = certs[i-1].Sign(signed, crypto.SHA256); err != nil {
+				t.Fatal(err)
+			}
+		}
+		parentAlg = uint32(RSAPKCS1v15WithSHA256)
+		nodes = append(nodes, push32(concat(push32(signed), u32(0), u32(parentAlg), push32(sig))))
+	}
+	return &Attribute{AttrProofOfRotation, concat(u32(1), concat(nodes...))}
+}
+
+// testWithSigningBlock replaces the signing block of z with the given pairs.
+func testWithSigningBlock(t *testing.T, z *ApkSign, pairs ...*Pair) *ApkSign {
+	var kv [][]byte
+	for _, p := range pairs {
+		kv = append(kv, push64(concat(u32(p.ID), p.Value)))
+	}
+	body := concat(kv...)
+	size := uint64(len(body) + 8 + 16)
+	block := concat(binary.LittleEndian.AppendUint64(nil, size), body,
+		binary.LittleEndian.AppendUint64(nil, size), []byte("APK Sig Block 42"))
+	out, err := z.InjectBeforeCDApk(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestParseV3SignedData(t *testing.T) {
+	sc := testSigningCerts(t)[0]
+	if err := sc.Resolve(); err != nil {
+		t.Fatal(err)
+	}
+	digest := bytes.Repeat([]byte{0xd1}, 32)
+	attr := &Attribute{AttrRotationMinSDK, u32(33)}
+	fixture := testSignedData(uint32(RSAPKCS1v15WithSHA256), digest, sc.Certificate.Raw, concat(u32(28), u32(32)), []*Attribute{attr})
+	sd, err := parseSignedData(fixture, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sd.MinSDK != 28 || sd.MaxSDK != 32 {
+		t.Errorf("SDK range %d-%d, want 28-32", sd.MinSDK, sd.MaxSDK)
+	}
+	if len(sd.Attributes) != 1 || sd.Attributes[0].ID != attr.ID || !bytes.Equal(sd.Attributes[0].Value, attr.Value) {
+		t.Errorf("attributes %+v", sd.Attributes)
+	}
+	if len(sd.Digests) != 1 || !bytes.Equal(sd.Digests[0].Digest, digest) || len(sd.Certs) != 1 {
+		t.Errorf("digests %+v, %d certificates", sd.Digests, len(sd.Certs))
+	}
+	if got := sd.marshal(true); !bytes.Equal(got, fixture) {
+		t.Errorf("marshal does not reproduce the spec layout:\n got %x\nwant %x", got, fixture)
+	}
+}
+
+func TestVerifyV3Rotation(t *testing.T) {
+	z, err := NewApkSign(testSignedApk(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, _ := findPair(mustPairs(t, z), BlockIDV2)
+	oldKey := testSigningCerts(t)[0]
+	if err = oldKey.Resolve(); err != nil {
+		t.Fatal(err)
+	}
+	newKey := generateSigningCert(t, "rotated")
+	lineage := testLineage(t, oldKey, newKey)
+
+	v3 := push32(testV3Signer(t, z, oldKey, 28, 32, &Attribute{AttrRotationMinSDK, u32(33)}))
+	v31 := push32(testV3Signer(t, z, newKey, 33, 0x7fffffff, lineage))
+	signed := testWithSigningBlock(t, z,
+		&Pair{BlockIDV2, v2}, &Pair{BlockIDV3, push32(v3)}, &Pair{BlockIDV31, push32(v31)})
+
+	if err = signed.VerifyV2(); err != nil {
+		t.Fatalf("VerifyV2: %v", err)
+	}
+	if err = signed.VerifyV3(); err != nil {
+		t.Fatalf("VerifyV3: %v", err)
+	}
+	for sdk, want := range map[int]Scheme{23: "", 24: SchemeV2, 28: SchemeV3, 32: SchemeV3, 33: SchemeV31, 35: SchemeV31} {
+		got, err := signed.SchemeAt(sdk)
+		if got != want || (want == "") != (err != nil) {
+			t.Errorf("SchemeAt(%d) = %q, %v; want %q", sdk, got, err, want)
+		}
+	}
+
+	// the rotated key must be vouched for by the old one
+	bogus := testLineage(t, oldKey, newKey)
+	bogus.Value[len(bogus.Value)-1] ^= 1 // last byte of the signature over the new certificate
+	v31 = push32(testV3Signer(t, z, newKey, 33, 0x7fffffff, bogus))
+	signed = testWithSigningBlock(t, z,
+		&Pair{BlockIDV2, v2}, &Pair{BlockIDV3, push32(v3)}, &Pair{BlockIDV31, push32(v31)})
+	if err = signed.VerifyV3(); err == nil {
+		t.Error("VerifyV3 accepted a lineage not signed by the previous key")
+	}
+
+	// v3.1 without the rotation min SDK attribute on the v3 signer
+	v3 = push32(testV3Signer(t, z, oldKey, 28, 32))
+	v31 = push32(testV3Signer(t, z, newKey, 33, 0x7fffffff, lineage))
+	signed = testWithSigningBlock(t, z,
+		&Pair{BlockIDV2, v2}, &Pair{BlockIDV3, push32(v3)}, &Pair{BlockIDV31, push32(v31)})
+	if err = signed.VerifyV3(); err == nil {
+		t.Error("VerifyV3 accepted v3.1 block without rotation min SDK")
+	}
+}
+
+func mustPairs(t *testing.T, z *ApkSign) []*Pair {
+	pairs, err := ParseSigningBlock(z.rawASv2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pairs
+}
+
+func TestSignV3(t *testing.T) {
+	z, err := NewApkSign(testSignedApk(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldKey := testSigningCerts(t)[0]
+	newKey := generateSigningCert(t, "rotated")
+	lineage, err := NewLineage(oldKey, DefaultLineageFlags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lineage, err = lineage.Rotate(oldKey, newKey, 0); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseLineage(lineage.Marshal())
+	if err != nil || len(parsed) != 2 || parsed.Verify() != nil || parsed[0].Flags != DefaultLineageFlags {
+		t.Fatalf("lineage round trip = %v, %v", parsed, err)
+	}
+
+	if _, err = z.SignV3([]*SigningCert{newKey}, lineage); err == nil {
+		t.Error("SignV3 with a rotation but no oldest signer succeeded")
+	}
+	if _, err = z.SignV3([]*SigningCert{newKey}, lineage, WithOldestSigner(newKey)); err == nil {
+		t.Error("SignV3 accepted an oldest signer that is not the first certificate of the lineage")
+	}
+	signed, err := z.SignV3([]*SigningCert{newKey}, lineage, WithOldestSigner(oldKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sz, err := NewApkSign(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sz.VerifyV2(); err != nil {
+		t.Fatalf("VerifyV2: %v", err)
+	}
+	if err = sz.VerifyV3(); err != nil {
+		t.Fatalf("VerifyV3: %v", err)
+	}
+	if err = sz.CheckStripping(); err != nil {
+		t.Errorf("CheckStripping: %v", err)
+	}
+	v3, _, err := sz.V3Blocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := v3.Signers[0]; s.MinSDK != minSDKV3 || s.MaxSDK != 0x7fffffff {
+		t.Errorf("v3 signer SDK range %d-%d", s.MinSDK, s.MaxSDK)
+	}
+	// devices before Android 9 see the v2 signature, made with the original key
+	v2, err := sz.V2Block()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := v2.Signers[0].SignedData.Certs[0]; !bytes.Equal(c.Raw, lineage[0].Cert.Raw) {
+		t.Errorf("v2 signer certificate %s, want the first of the lineage", c.Subject)
+	}
+	got, err := v3.Signers[0].Lineage()
+	if err != nil || len(got) != 2 || got[0].Cert.Subject.CommonName != oldKey.Certificate.Subject.CommonName {
+		t.Errorf("signed lineage = %v, %v", got, err)
+	}
+	if scheme, _ := sz.SchemeAt(30); scheme != SchemeV3 {
+		t.Errorf("SchemeAt(30) = %q", scheme)
+	}
+
+	// without a lineage
+	if signed, err = z.SignV3([]*SigningCert{newKey}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if sz, err = NewApkSign(signed); err != nil {
+		t.Fatal(err)
+	}
+	if err = sz.VerifyV3(); err != nil {
+		t.Errorf("VerifyV3 without lineage: %v", err)
+	}
+
+	if _, err = z.SignV3([]*SigningCert{oldKey}, lineage); err == nil {
+		t.Error("SignV3 accepted a lineage ending in another certificate")
+	}
+	if _, err = z.SignV3([]*SigningCert{oldKey, newKey}, nil); err == nil {
+		t.Error("SignV3 accepted keys with different certificates")
+	}
+	if _, err = lineage.Rotate(oldKey, generateSigningCert(t, "third"), 0); err == nil {
+		t.Error("Rotate accepted a parent that is not the last certificate")
+	}
+}
@@ -0,0 +1,395 @@
+package signv2
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// v4 constants: the only hash algorithm defined is SHA-256 over 4 KiB blocks.
+const (
+	v4Version         = 2
+	v4HashSHA256      = 1
+	v4Log2BlockSize   = 12
+	v4BlockSize       = 1 << v4Log2BlockSize
+	v4MaxIDSigSection = 64 << 20
+)
+
+// V4SigningInfo is the signature of a .idsig file over the Merkle root and the v2/v3 digest of the
+// APK.
+type V4SigningInfo struct {
+	ApkDigest          []byte
+	Certificate        []byte
+	AdditionalData     []byte
+	PublicKey          []byte
+	SignatureAlgorithm uint32
+	Signature          []byte
+}
+
+// V4Signature is the content of a .idsig file, the APK Signature Scheme v4 used for incremental
+// installs. Unlike the other schemes it is not stored in the APK.
+//
+// See https://source.android.com/security/apksigning/v4
+type V4Signature struct {
+	Version       uint32
+	HashAlgorithm uint32
+	Log2BlockSize uint8
+	Salt          []byte
+	RootHash      []byte
+	SigningInfo   *V4SigningInfo
+	// Blocks holds additional signing infos keyed by signing block ID, used for the v3.1 signer.
+	Blocks []*Pair
+	// Tree is the Merkle tree stored after the signature; it may be empty.
+	Tree []byte
+
+	hashingInfo []byte
+}
+
+// ParseV4Signature parses the content of a .idsig file.
+func ParseV4Signature(idsig []byte) (_ *V4Signature, err error) {
+	defer catch(&err, "ParseV4Signature", "v4 signature")
+	r := newReader(idsig, nil)
+	v4 := &V4Signature{}
+	if v4.Version = r.uint32("idsig"); r.Err() == nil && v4.Version != v4Version {
+		return nil, fmt.Errorf("unsupported idsig version %d", v4.Version)
+	}
+	hashing := r.section("hashing info")
+	signing := r.section("signing info")
+	if r.len() > 0 {
+		v4.Tree = r.prefixed("verity tree")
+	}
+	r.end("idsig")
+	for _, b := range [][]byte{hashing.buf, signing.buf, v4.Tree} {
+		if len(b) > v4MaxIDSigSection {
+			return nil, fmt.Errorf("%w: idsig section of %d bytes", ErrLimitExceeded, len(b))
+		}
+	}
+
+	v4.hashingInfo = hashing.buf
+	v4.HashAlgorithm = hashing.uint32("hashing info")
+	v4.Log2BlockSize = hashing.uint8("hashing info")
+	v4.Salt = hashing.prefixed("salt")
+	v4.RootHash = hashing.prefixed("root hash")
+	hashing.end("hashing info")
+
+	v4.SigningInfo = parseV4SigningInfo(signing)
+	for signing.len() > 0 {
+		id := signing.uint32("signing info block")
+		v4.Blocks = append(v4.Blocks, &Pair{id, signing.prefixed("signing info block")})
+	}
+	if err = r.Err(); err != nil {
+		return nil, err
+	}
+	return v4, nil
+}
+
+// parseV4SigningInfo reads a signing info from r, which may hold more data after it.
+func parseV4SigningInfo(r *reader) *V4SigningInfo {
+	return &V4SigningInfo{
+		ApkDigest:          r.prefixed("apk digest"),
+		Certificate:        r.prefixed("certificate"),
+		AdditionalData:     r.prefixed("additional data"),
+		PublicKey:          r.prefixed("public key"),
+		SignatureAlgorithm: r.uint32("signing info"),
+		Signature:          r.prefixed("signature"),
+	}
+}
+
+// signedData returns the bytes covered by the signature of si: the file size, the hashing info and
+// the first three fields of the signing info, preceded by their total size.
+func (v4 *V4Signature) signedData(fileSize int64, si *V4SigningInfo) []byte {
+	b := binary.LittleEndian.AppendUint64(nil, uint64(fileSize))
+	b = append(b, v4.hashingInfo[:5]...) // hash algorithm and log2 block size
+	for _, f := range [][]byte{v4.Salt, v4.RootHash, si.ApkDigest, si.Certificate, si.AdditionalData} {
+		b = append(b, push32(f)...)
+	}
+	return append(binary.LittleEndian.AppendUint32(nil, uint32(len(b)+4)), b...)
+}
+
+// verify checks the signature of si and that it is made by the certificate it carries.
+func (si *V4SigningInfo) verify(signedData []byte) error {
+	cert, err := x509.ParseCertificate(si.Certificate)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(cert.RawSubjectPublicKeyInfo, si.PublicKey) {
+		return errors.New("v4 public key does not match certificate")
+	}
+	return AlgorithmID(si.SignatureAlgorithm).verify(si.PublicKey, signedData, si.Signature)
+}
+
+// linksTo checks that si was issued for signer: same certificate, and an APK digest that is one of
+// the signer's content digests.
+func (si *V4SigningInfo) linksTo(signer *Signer) error {
+	if len(signer.SignedData.Certs) == 0 || !bytes.Equal(signer.SignedData.Certs[0].Raw, si.Certificate) {
+		return errors.New("v4 certificate does not match the APK signer")
+	}
+	for _, d := range signer.SignedData.Digests {
+		if bytes.Equal(d.Digest, si.ApkDigest) {
+			return nil
+		}
+	}
+	return errors.New("v4 APK digest does not match any digest of the APK signer")
+}
+
+// verityTree builds the fs-verity style Merkle tree of data: SHA-256 over 4 KiB blocks, the last one
+// zero padded, repeated over the concatenated hashes until they fit in one block. The tree is
+// returned top level first, as stored in .idsig files, with the root hash being the hash of its first
+// block.
+func verityTree(data, salt []byte) (root, tree []byte) {
+	h := getHash(crypto.SHA256)
+	defer putHash(crypto.SHA256, h)
+	hashBlocks := func(in []byte) []byte {
+		var out []byte
+		var block [v4BlockSize]byte
+		for off := 0; off < len(in); off += v4BlockSize {
+			n := copy(block[:], in[off:])
+			clear(block[n:])
+			h.Reset()
+			h.Write(salt)
+			h.Write(block[:])
+			out = h.Sum(out)
+		}
+		return out
+	}
+	pad := func(b []byte) []byte {
+		if r := len(b) % v4BlockSize; r != 0 {
+			b = append(b, make([]byte, v4BlockSize-r)...)
+		}
+		return b
+	}
+
+	var levels [][]byte
+	level := hashBlocks(data)
+	for {
+		levels = append(levels, pad(level))
+		if len(level) <= v4BlockSize {
+			break
+		}
+		level = hashBlocks(level)
+	}
+	for i := len(levels) - 1; i >= 0; i-- {
+		tree = append(tree, levels[i]...)
+	}
+	h.Reset()
+	h.Write(salt)
+	h.Write(tree[:v4BlockSize])
+	return h.Sum(nil), tree
+}
+
+// MerkleTree returns the Merkle tree of apk a v4 signature covers, top level first, and its root
+// hash. Incremental installs send the tree along with the data blocks so that the device can check
+// each block as it arrives.
+func MerkleTree(apk []byte) (root, tree []byte) {
+	return verityTree(apk, nil)
+}
+
+// VerifyV4 verifies the .idsig file of an incremental install against the APK: the Merkle root of
+// the whole file must match the one signed in the idsig, and the idsig must be signed by the APK's
+// v3 signer (v2 if there is no v3 block) over that signer's content digest. The APK's own v3 or v2
+// signature is verified as well, since the linkage means nothing without it.
+func VerifyV4(apk, idsig []byte) error {
+	return VerifyV4Context(context.Background(), apk, idsig)
+}
+
+// VerifyV4Context is like VerifyV4 but returns ctx.Err() once ctx is done.
+func VerifyV4Context(ctx context.Context, apk, idsig []byte) (err error) {
+	defer catch(&err, "VerifyV4", "file")
+	v4, err := ParseV4Signature(idsig)
+	if err != nil {
+		return err
+	}
+	if v4.HashAlgorithm != v4HashSHA256 || v4.Log2BlockSize != v4Log2BlockSize {
+		return fmt.Errorf("unsupported v4 hashing: algorithm %d, log2 block size %d", v4.HashAlgorithm, v4.Log2BlockSize)
+	}
+
+	z, err := NewApkSignNoCopy(apk) // only read
+	if err != nil {
+		return err
+	}
+
+	root, tree := verityTree(apk, v4.Salt)
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	if !bytes.Equal(root, v4.RootHash) {
+		return fmt.Errorf("%w: v4 Merkle root", ErrDigestMismatch)
+	}
+	if len(v4.Tree) > 0 && !bytes.Equal(tree, v4.Tree) {
+		return fmt.Errorf("%w: v4 Merkle tree", ErrDigestMismatch)
+	}
+
+	if !z.IsV2Signed {
+		return errors.New("v4 signature requires a v2 or v3 signed APK")
+	}
+	v3, v31, err := z.V3Blocks()
+	if err != nil {
+		return err
+	}
+	var signers []*Signer
+	if v3 != nil {
+		if err = z.VerifyV3Context(ctx); err != nil {
+			return err
+		}
+		signers = v3.Signers
+	} else {
+		v2, err := z.V2Block()
+		if err != nil {
+			return err
+		}
+		if err = v2.verify(ctx, z); err != nil {
+			return err
+		}
+		signers = v2.Signers
+	}
+	if len(signers) != 1 {
+		return errors.New("v4 signature requires exactly one APK signer")
+	}
+
+	if err = v4.SigningInfo.verify(v4.signedData(int64(len(apk)), v4.SigningInfo)); err != nil {
+		return err
+	}
+	if err = v4.SigningInfo.linksTo(signers[0]); err != nil {
+		return err
+	}
+
+	// the rotated signer of a v3.1 block signs the idsig separately
+	for _, b := range v4.Blocks {
+		if b.ID != BlockIDV31 {
+			continue
+		}
+		if v31 == nil || len(v31.Signers) != 1 {
+			return errors.New("v4 signing info for a v3.1 signer the APK does not have")
+		}
+		r := newReader(b.Value, nil)
+		si := parseV4SigningInfo(r)
+		r.end("v3.1 signing info")
+		if err = r.Err(); err != nil {
+			return err
+		}
+		if err = si.verify(v4.signedData(int64(len(apk)), si)); err != nil {
+			return fmt.Errorf("v3.1: %v", err)
+		}
+		if err = si.linksTo(v31.Signers[0]); err != nil {
+			return fmt.Errorf("v3.1: %v", err)
+		}
+	}
+	return nil
+}
+
+// SignV4 returns the .idsig file for an incremental install of apk, which must already be v2 or v3
+// signed by exactly one signer. The idsig is signed by the key among keys whose certificate is that
+// of the APK signer (the v3 signer if there is a v3 block), over the Merkle root of the whole file
+// and the strongest content digest of the signer. A v3.1 signer gets its own signing info, so keys
+// must then hold its key as well.
+func SignV4(apk []byte, keys []*SigningCert) ([]byte, error) {
+	return SignV4Context(context.Background(), apk, keys)
+}
+
+// SignV4Context is like SignV4 but returns ctx.Err() once ctx is done.
+func SignV4Context(ctx context.Context, apk []byte, keys []*SigningCert) (_ []byte, err error) {
+	defer catch(&err, "SignV4", "file")
+	for _, sk := range keys {
+		if err = sk.Resolve(); err != nil {
+			return nil, err
+		}
+	}
+	z, err := NewApkSignNoCopy(apk) // only read
+	if err != nil {
+		return nil, err
+	}
+	if !z.IsV2Signed {
+		return nil, errors.New("v4 signature requires a v2 or v3 signed APK")
+	}
+	v3, v31, err := z.V3Blocks()
+	if err != nil {
+		return nil, err
+	}
+	var signers []*Signer
+	if v3 != nil {
+		signers = v3.Signers
+	} else {
+		v2, err := z.V2Block()
+		if err != nil {
+			return nil, err
+		}
+		signers = v2.Signers
+	}
+	if len(signers) != 1 {
+		return nil, errors.New("v4 signature requires exactly one APK signer")
+	}
+
+	root, tree := verityTree(apk, nil)
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	v4 := &V4Signature{
+		RootHash:    root,
+		hashingInfo: concat(binary.LittleEndian.AppendUint32(nil, v4HashSHA256), []byte{v4Log2BlockSize}, push32(nil), push32(root)),
+	}
+	signing, err := v4.sign(int64(len(apk)), signers[0], keys)
+	if err != nil {
+		return nil, err
+	}
+	if v31 != nil {
+		if len(v31.Signers) != 1 {
+			return nil, errors.New("v4 signature requires exactly one v3.1 signer")
+		}
+		si, err := v4.sign(int64(len(apk)), v31.Signers[0], keys)
+		if err != nil {
+			return nil, fmt.Errorf("v3.1: %v", err)
+		}
+		signing = concat(signing, binary.LittleEndian.AppendUint32(nil, BlockIDV31), push32(si))
+	}
+	return concat(binary.LittleEndian.AppendUint32(nil, v4Version), push32(v4.hashingInfo), push32(signing), push32(tree)), nil
+}
+
+// sign returns the encoded signing info of signer, signed with the key of keys holding its
+// certificate.
+func (v4 *V4Signature) sign(fileSize int64, signer *Signer, keys []*SigningCert) ([]byte, error) {
+	if len(signer.SignedData.Certs) == 0 {
+		return nil, errors.New("APK signer has no certificate")
+	}
+	cert := signer.SignedData.Certs[0]
+	var key *SigningCert
+	for _, sk := range keys {
+		if bytes.Equal(sk.Certificate.Raw, cert.Raw) {
+			key = sk
+			break
+		}
+	}
+	if key == nil {
+		return nil, fmt.Errorf("no key for the APK signer %s", cert.Subject)
+	}
+	// the strongest digest, so that the idsig stays valid when a weaker one is dropped
+	var digest *Digest
+	var best crypto.Hash
+	for _, d := range signer.SignedData.Digests {
+		if h, ok := AlgorithmID(d.AlgorithmID).hash(); ok && (digest == nil || h.Size() > best.Size()) {
+			digest, best = d, h
+		}
+	}
+	if digest == nil {
+		return nil, fmt.Errorf("%w: no supported content digest in the APK signer", ErrUnknownAlgorithm)
+	}
+	id, alg, err := key.algorithm(options{})
+	if err != nil {
+		return nil, err
+	}
+	si := &V4SigningInfo{
+		ApkDigest:          digest.Digest,
+		Certificate:        cert.Raw,
+		PublicKey:          cert.RawSubjectPublicKeyInfo,
+		SignatureAlgorithm: uint32(id),
+	}
+	sig, err := key.sign(alg, v4.signedData(fileSize, si))
+	if err != nil {
+		return nil, err
+	}
+	return concat(push32(si.ApkDigest), push32(si.Certificate), push32(si.AdditionalData),
+		push32(si.PublicKey), binary.LittleEndian.AppendUint32(nil, si.SignatureAlgorithm), push32(sig)), nil
+}
@@ -0,0 +1,480 @@
+package signv2
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"time"
+)
+
+// VerifyOptions configures VerifyAll.
+type VerifyOptions struct {
+	// IDSig is the content of the .idsig file; v4 is only checked when it is set.
+	IDSig []byte
+	// ExpiryWindow, if set, warns about certificates expiring within that duration.
+	ExpiryWindow time.Duration
+	// Time is the point in time certificates are checked against; zero means now.
+	Time time.Time
+	// MinSDK and MaxSDK, if set, are the platform levels the file must install on; a level without
+	// a verified signature, such as one before Android 7.0 (API 24) when the file has no v1
+	// signature, fails the verification. A zero MaxSDK means no upper bound.
+	MinSDK, MaxSDK int
+}
+
+// SchemeResult is the outcome of one signature scheme. Err is nil when the scheme is absent or
+// verified; for an absent scheme that another signature claims, it is a *StripError.
+type SchemeResult struct {
+	Scheme   Scheme
+	Present  bool
+	Verified bool
+	Err      error
+}
+
+// SignerResult describes one signer of a verified or failed scheme, or, as returned by Signers, of
+// a scheme present in the file.
+type SignerResult struct {
+	Scheme Scheme
+	Certs  []*x509.Certificate
+	MinSDK uint32 // v3 only
+	MaxSDK uint32 // v3 only
+	// SHA256 and SHA1 are the hex fingerprints of the signer certificate, Certs[0], as printed by
+	// apksigner verify --print-certs.
+	SHA256, SHA1 string
+	// Algorithms are the signature algorithms of the signer; each implies the content digest it signs.
+	Algorithms []AlgorithmID
+	// Digests are the content digests the signer signed. They are empty for v1 signers.
+	Digests []*Digest
+}
+
+// BlockEntry describes one ID-value pair of the APK Signing Block.
+type BlockEntry struct {
+	ID   uint32
+	Size int
+	// Name is empty for IDs BlockName does not know.
+	Name string
+}
+
+// SourceStampResult is the outcome of the SourceStamp check.
+type SourceStampResult struct {
+	Cert *x509.Certificate
+	Err  error
+}
+
+// VerificationResult is the full report of VerifyAll.
+type VerificationResult struct {
+	// Verified is true if at least one scheme verified and no present scheme failed.
+	Verified bool
+	// Schemes lists v1, v2, v3, v3.1 and v4, in that order.
+	Schemes []*SchemeResult
+	Signers []*SignerResult
+	// Blocks lists every pair of the APK Signing Block, in file order, so that data embedded by
+	// vendors is visible.
+	Blocks []*BlockEntry
+	// BlockErrors holds the *BlockError of each failed BlockProcessor, ordered by ID. Any of them
+	// fails the verification.
+	BlockErrors []error
+	// SourceStamp is nil when the file has no SourceStamp. A failed stamp is reported here but does
+	// not affect Verified, as the platform does not require it.
+	SourceStamp *SourceStampResult
+	// DebugSigned is set if a signer of any scheme, v1 included, uses a debug certificate, see IsDebugCert.
+	DebugSigned bool
+	// SDKErr is set when VerifyOptions.MinSDK or MaxSDK name a platform level no verified signature
+	// applies to. It fails the verification.
+	SDKErr   error
+	Warnings []string
+}
+
+// Scheme returns the result for the given scheme.
+func (r *VerificationResult) Scheme(s Scheme) *SchemeResult {
+	for _, sr := range r.Schemes {
+		if sr.Scheme == s {
+			return sr
+		}
+	}
+	return nil
+}
+
+// HasBlock reports whether the signing block contains a pair with the given ID, e.g.
+// BlockIDFrosting for APKs distributed through Play.
+func (r *VerificationResult) HasBlock(id uint32) bool {
+	for _, b := range r.Blocks {
+		if b.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Errors returns the errors of all failed schemes, followed by BlockErrors and SDKErr.
+func (r *VerificationResult) Errors() []error {
+	var errs []error
+	for _, sr := range r.Schemes {
+		if sr.Err != nil {
+			errs = append(errs, sr.Err)
+		}
+	}
+	errs = append(errs, r.BlockErrors...)
+	if r.SDKErr != nil {
+		errs = append(errs, r.SDKErr)
+	}
+	return errs
+}
+
+// Err returns nil if the file verified, and otherwise the errors of all failed schemes joined, or an
+// error saying that there is no verifiable signature.
+func (r *VerificationResult) Err() error {
+	if r.Verified {
+		return nil
+	}
+	if errs := r.Errors(); len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	return errors.New("no verifiable signature")
+}
+
+// Verify checks the file as VerifyAll does, for platform levels minSDK to maxSDK, as apksigner verify
+// with --min-sdk-version and --max-sdk-version; zero leaves a bound open.
+func (apkSign *ApkSign) Verify(minSDK, maxSDK int) *VerificationResult {
+	return apkSign.VerifyAll(VerifyOptions{MinSDK: minSDK, MaxSDK: maxSDK})
+}
+
+// VerifyAll checks every signature scheme present in the file, instead of stopping at the first
+// error like the VerifyVn methods, so callers can report on all of them. If checking panics, the
+// schemes not checked by then fail with a *PanicError.
+func (apkSign *ApkSign) VerifyAll(opts VerifyOptions) *VerificationResult {
+	return apkSign.VerifyAllContext(context.Background(), opts)
+}
+
+// VerifyAllContext is like VerifyAll but stops computing digests once ctx is done. The schemes not
+// checked by then fail with ctx.Err().
+func (apkSign *ApkSign) VerifyAllContext(ctx context.Context, opts VerifyOptions) *VerificationResult {
+	start := time.Now()
+	r := apkSign.verifyAll(ctx, opts)
+	err := r.Err()
+	apkSign.options.record(OpVerify, apkSign.size, start, &err)
+	return r
+}
+
+// verifyAll implements VerifyAllContext, which records its metrics.
+func (apkSign *ApkSign) verifyAll(ctx context.Context, opts VerifyOptions) (r *VerificationResult) {
+	r = &VerificationResult{}
+	defer r.recoverPanic()
+	add := func(s Scheme, present bool, err error) {
+		r.Schemes = append(r.Schemes, &SchemeResult{s, present, present && err == nil, err})
+	}
+	signers := func(s Scheme, list []*Signer) {
+		for _, signer := range list {
+			r.Signers = append(r.Signers, newSignerResult(s, signer))
+		}
+	}
+
+	var err error
+	if apkSign.IsV1Signed {
+		var v1 []*SignerResult
+		v1, err = apkSign.verifyV1(ctx)
+		r.Signers = append(r.Signers, v1...)
+	}
+	add(SchemeV1, apkSign.IsV1Signed, err)
+
+	var v2 *V2Block
+	err = nil
+	if apkSign.IsV2Signed {
+		v2, err = apkSign.V2Block()
+		if err == nil {
+			signers(SchemeV2, v2.Signers)
+			err = v2.verify(ctx, apkSign)
+		}
+		add(SchemeV2, v2 != nil, err)
+	} else {
+		add(SchemeV2, false, nil)
+	}
+
+	var v3, v31 *V3Block
+	if apkSign.IsV2Signed {
+		v3, v31, err = apkSign.V3Blocks()
+	}
+	if err != nil {
+		add(SchemeV3, true, err)
+		add(SchemeV31, false, nil)
+	} else {
+		if v3 != nil {
+			signers(SchemeV3, v3.Signers)
+			err = v3.verify(ctx, apkSign)
+		}
+		add(SchemeV3, v3 != nil, err)
+		err = nil
+		if v31 != nil {
+			signers(SchemeV31, v31.Signers)
+			// VerifyV3 covers the v3.1 block and its link to the v3 signers
+			if err = apkSign.VerifyV3Context(ctx); err != nil && r.Scheme(SchemeV3).Err != nil {
+				err = errors.New("v3.1 not checked, v3 failed")
+			}
+		}
+		add(SchemeV31, v31 != nil, err)
+	}
+
+	err = nil
+	if opts.IDSig != nil {
+		err = VerifyV4Context(ctx, apkSign.raw, opts.IDSig)
+	}
+	add(SchemeV4, opts.IDSig != nil, err)
+
+	ps := apkSign.options.blockProcessors()
+	if apkSign.rawASv2 != nil {
+		pairs, err := apkSign.signingBlockPairs()
+		if err != nil {
+			r.Warnings = append(r.Warnings, err.Error())
+		}
+		for _, p := range pairs {
+			r.Blocks = append(r.Blocks, &BlockEntry{p.ID, len(p.Value), BlockName(p.ID)})
+			processed := slices.ContainsFunc(ps, func(bp BlockProcessor) bool { return bp.BlockID() == p.ID })
+			if BlockName(p.ID) == "" && !processed {
+				r.Warnings = append(r.Warnings, fmt.Sprintf("unknown signing block entry %#08x (%d bytes)", p.ID, len(p.Value)))
+			}
+		}
+	}
+	if errs, err := apkSign.verifyBlocks(ps); err != nil {
+		r.BlockErrors = append(r.BlockErrors, err)
+	} else {
+		for _, p := range ps {
+			if err := errs[p.BlockID()]; err != nil {
+				r.BlockErrors = append(r.BlockErrors, err)
+			}
+		}
+	}
+
+	r.Warnings = append(r.Warnings, certWarnings(r.Signers, opts)...)
+	// a v1 signature that failed may not have listed all its signers, which are checked too
+	debug := slices.ContainsFunc(r.Signers, isDebugSigner)
+	if r.Scheme(SchemeV1).Err != nil && !debug {
+		v1, err := apkSign.v1Signers()
+		debug = err == nil && slices.ContainsFunc(v1, isDebugSigner)
+	}
+	if debug {
+		r.DebugSigned = true
+		r.Warnings = append(r.Warnings, "APK is signed with a debug certificate")
+	}
+
+	if stamp, err := apkSign.SourceStamp(); stamp != nil || err != nil {
+		r.SourceStamp = &SourceStampResult{Err: err}
+		if err == nil {
+			r.SourceStamp.Cert, r.SourceStamp.Err = apkSign.VerifySourceStamp()
+		}
+	}
+
+	stripped, err := apkSign.strippedSchemes()
+	if err != nil {
+		r.Warnings = append(r.Warnings, "stripping check failed: "+err.Error())
+	}
+	for _, e := range stripped {
+		if sr := r.Scheme(e.Missing); sr.Err == nil {
+			sr.Err = e
+		}
+	}
+	// errors reading the signatures were reported by strippedSchemes
+	if unprotected, err := apkSign.v1Unprotected(); err == nil && unprotected {
+		r.Warnings = append(r.Warnings, v1UnprotectedWarning)
+	}
+
+	if opts.MinSDK > 0 || opts.MaxSDK > 0 {
+		r.SDKErr = apkSign.checkSDKRange(r, opts.MinSDK, opts.MaxSDK)
+	}
+
+	if len(r.Errors()) > 0 {
+		return r
+	}
+	for _, sr := range r.Schemes {
+		r.Verified = r.Verified || sr.Verified
+	}
+	return r
+}
+
+// newSignerResult describes signer of scheme s.
+func newSignerResult(s Scheme, signer *Signer) *SignerResult {
+	sr := &SignerResult{Scheme: s, Certs: signer.SignedData.Certs, MinSDK: signer.MinSDK, MaxSDK: signer.MaxSDK}
+	if len(sr.Certs) > 0 {
+		sum256, sum1 := sha256.Sum256(sr.Certs[0].Raw), sha1.Sum(sr.Certs[0].Raw)
+		sr.SHA256, sr.SHA1 = hex.EncodeToString(sum256[:]), hex.EncodeToString(sum1[:])
+	}
+	for _, sig := range signer.Signatures {
+		sr.Algorithms = append(sr.Algorithms, AlgorithmID(sig.AlgorithmID))
+	}
+	sr.Digests = signer.SignedData.Digests
+	return sr
+}
+
+// Signers returns the signers of each scheme in the file, v1 first, as the signatures record them:
+// nothing is verified, which spares the content digests VerifyAll computes and answers who signed a
+// file quickly. Only trust the certificates once the file verifies.
+func (apkSign *ApkSign) Signers() (_ []*SignerResult, err error) {
+	defer catch(&err, "Signers", "file")
+	var signers []*SignerResult
+	if apkSign.IsV1Signed {
+		if signers, err = apkSign.v1Signers(); err != nil {
+			return nil, err
+		}
+	}
+	if apkSign.rawASv2 == nil {
+		return signers, nil
+	}
+	add := func(s Scheme, list []*Signer) {
+		for _, signer := range list {
+			signers = append(signers, newSignerResult(s, signer))
+		}
+	}
+	v2, err := apkSign.V2Block()
+	if err != nil && !errors.Is(err, ErrNotV2Signed) {
+		return nil, err
+	}
+	if v2 != nil {
+		add(SchemeV2, v2.Signers)
+	}
+	v3, v31, err := apkSign.V3Blocks()
+	if err != nil {
+		return nil, err
+	}
+	if v3 != nil {
+		add(SchemeV3, v3.Signers)
+	}
+	if v31 != nil {
+		add(SchemeV31, v31.Signers)
+	}
+	return signers, nil
+}
+
+// DebugSigned reports whether a signer of any scheme in the file, v1 included, uses a debug
+// certificate, see IsDebugCert. Like Signers it verifies nothing.
+func (apkSign *ApkSign) DebugSigned() (bool, error) {
+	signers, err := apkSign.Signers()
+	if err != nil {
+		return false, err
+	}
+	return slices.ContainsFunc(signers, isDebugSigner), nil
+}
+
+func isDebugSigner(s *SignerResult) bool {
+	return len(s.Certs) > 0 && IsDebugCert(s.Certs[0])
+}
+
+// v1Signers returns the signers of the PKCS #7 signature block files in META-INF.
+func (apkSign *ApkSign) v1Signers() ([]*SignerResult, error) {
+	r, err := apkSign.zipReader()
+	if err != nil {
+		return nil, err
+	}
+	var signers []*SignerResult
+	for _, f := range r.File {
+		// the signature block files, not the manifest and the signature files they sign
+		if ext := strings.ToUpper(path.Ext(f.Name)); !isV1SignatureFile(f.Name) || ext == ".MF" || ext == ".SF" {
+			continue
+		}
+		b, err := readZipEntry(r, f.Name)
+		if err != nil {
+			return nil, err
+		}
+		sd, certs, err := parsePKCS7(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		for _, si := range sd.SignerInfos {
+			cert := pkcs7SignerCert(certs, si)
+			if cert == nil {
+				return nil, fmt.Errorf("%s: %w: no certificate for signer", f.Name, ErrPKCS7)
+			}
+			signers = append(signers, newSignerResult(SchemeV1, &Signer{SignedData: &SignedData{Certs: []*x509.Certificate{cert}}}))
+		}
+	}
+	return signers, nil
+}
+
+// checkSDKRange returns an error for the first platform level from minSDK to maxSDK whose scheme,
+// per SchemeAt, did not verify. Only the levels where the applicable scheme can change are checked.
+func (apkSign *ApkSign) checkSDKRange(r *VerificationResult, minSDK, maxSDK int) error {
+	if maxSDK == 0 {
+		maxSDK = math.MaxInt32
+	}
+	if minSDK > maxSDK {
+		return fmt.Errorf("min SDK %d greater than max SDK %d", minSDK, maxSDK)
+	}
+	levels := []int{minSDK, minSDKV2, minSDKV3, minSDKV31}
+	for _, s := range r.Signers {
+		if s.Scheme == SchemeV3 || s.Scheme == SchemeV31 {
+			levels = append(levels, int(s.MinSDK), int(min(s.MaxSDK, math.MaxInt32-1))+1)
+		}
+	}
+	slices.Sort(levels)
+	for _, sdk := range slices.Compact(levels) {
+		if sdk < minSDK || sdk > maxSDK {
+			continue
+		}
+		scheme, err := apkSign.SchemeAt(sdk)
+		if err != nil {
+			return err
+		}
+		if sr := r.Scheme(scheme); !sr.Verified {
+			return fmt.Errorf("SDK %d verifies the %s signature, which is not verified", sdk, scheme)
+		}
+	}
+	return nil
+}
+
+// recoverPanic is deferred by verifyAll: it turns a panic into a *PanicError, which fails the
+// schemes not checked by then and those verified so far, since the check did not complete.
+func (r *VerificationResult) recoverPanic() {
+	v := recover()
+	if v == nil {
+		return
+	}
+	err := &ParseError{Location{-1, "file", ""}, &PanicError{"VerifyAll", v, debug.Stack()}}
+	r.Verified = false
+	for _, s := range []Scheme{SchemeV1, SchemeV2, SchemeV3, SchemeV31, SchemeV4} {
+		if sr := r.Scheme(s); sr == nil {
+			r.Schemes = append(r.Schemes, &SchemeResult{Scheme: s, Err: err})
+		} else if sr.Verified {
+			sr.Verified, sr.Err = false, err
+		}
+	}
+	r.Warnings = append(r.Warnings, err.Error())
+}
+
+// certWarnings reports expired, not yet valid, soon expiring and weakly signed signer certificates.
+// Android itself ignores validity periods, so like apksigner these are warnings, not failures.
+func certWarnings(signers []*SignerResult, opts VerifyOptions) []string {
+	now := opts.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+	var warnings []string
+	seen := map[string]bool{}
+	for _, s := range signers {
+		for _, c := range s.Certs {
+			if seen[string(c.Raw)] {
+				continue
+			}
+			seen[string(c.Raw)] = true
+			name := c.Subject.String()
+			switch {
+			case now.After(c.NotAfter):
+				warnings = append(warnings, fmt.Sprintf("certificate %q expired on %s", name, c.NotAfter.Format(time.DateOnly)))
+			case now.Before(c.NotBefore):
+				warnings = append(warnings, fmt.Sprintf("certificate %q is not valid before %s", name, c.NotBefore.Format(time.DateOnly)))
+			case opts.ExpiryWindow > 0 && now.Add(opts.ExpiryWindow).After(c.NotAfter):
+				warnings = append(warnings, fmt.Sprintf("certificate %q expires on %s", name, c.NotAfter.Format(time.DateOnly)))
+			}
+			switch c.SignatureAlgorithm {
+			case x509.MD2WithRSA, x509.MD5WithRSA, x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1:
+				warnings = append(warnings, fmt.Sprintf("certificate %q is signed with weak algorithm %s", name, c.SignatureAlgorithm))
+			}
+		}
+	}
+	return warnings
+}
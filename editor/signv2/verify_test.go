@@ -0,0 +1,167 @@
+package signv2
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyAll(t *testing.T) {
+	z, err := NewApkSign(testSignedApk(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := z.VerifyAll(VerifyOptions{})
+	if !r.Verified {
+		t.Fatalf("not verified: %v", r.Errors())
+	}
+	if s := r.Scheme(SchemeV2); !s.Present || !s.Verified {
+		t.Errorf("v2 result = %+v", s)
+	}
+	if s := r.Scheme(SchemeV3); s.Present {
+		t.Errorf("v3 reported present")
+	}
+	if len(r.Signers) != 1 || r.Signers[0].Scheme != SchemeV2 || len(r.Signers[0].Certs) != 1 {
+		t.Errorf("signers = %+v", r.Signers)
+	}
+
+	raw := z.Bytes()
+	raw[10] ^= 1
+	z, err = NewApkSign(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = z.VerifyAll(VerifyOptions{})
+	if r.Verified || r.Scheme(SchemeV2).Err == nil {
+		t.Errorf("tampered file: %+v", r.Scheme(SchemeV2))
+	}
+}
+
+func TestVerifyAllBlocks(t *testing.T) {
+	z, err := NewApkSign(testSignedApk(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, _ := findPair(mustPairs(t, z), BlockIDV2)
+	z = testWithSigningBlock(t, z, &Pair{BlockIDV2, v2}, &Pair{0x12345678, []byte("vendor")}, &Pair{BlockIDPadding, make([]byte, 10)})
+	r := z.VerifyAll(VerifyOptions{})
+	if !r.Verified {
+		t.Fatalf("not verified: %v", r.Errors())
+	}
+	want := []BlockEntry{{BlockIDV2, len(v2), "APK Signature Scheme v2"}, {0x12345678, 6, ""}, {BlockIDPadding, 10, "verity padding"}}
+	if len(r.Blocks) != len(want) {
+		t.Fatalf("blocks = %+v", r.Blocks)
+	}
+	for i, b := range r.Blocks {
+		if *b != want[i] {
+			t.Errorf("block %d = %+v, want %+v", i, b, want[i])
+		}
+	}
+	if len(r.Warnings) != 1 {
+		t.Errorf("warnings = %q, want one for the unknown entry", r.Warnings)
+	}
+}
+
+func TestVerifyAllCertWarnings(t *testing.T) {
+	z, err := NewApkSign(testSignedApk(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the test certificate is valid for 24 hours from now
+	for _, c := range []struct {
+		opts VerifyOptions
+		want string
+	}{
+		{VerifyOptions{}, ""},
+		{VerifyOptions{ExpiryWindow: 48 * time.Hour}, "expires on"},
+		{VerifyOptions{Time: time.Now().Add(72 * time.Hour)}, "expired on"},
+		{VerifyOptions{Time: time.Now().Add(-72 * time.Hour)}, "not valid before"},
+	} {
+		r := z.VerifyAll(c.opts)
+		if !r.Verified {
+			t.Fatalf("certificate warnings must not fail verification: %v", r.Errors())
+		}
+		got := strings.Join(r.Warnings, "\n")
+		if c.want == "" && got != "" || !strings.Contains(got, c.want) {
+			t.Errorf("%+v: warnings = %q, want %q", c.opts, got, c.want)
+		}
+	}
+}
+
+func TestVerifySDKRange(t *testing.T) {
+	z, err := NewApkSign(testSignedApk(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := z.Verify(24, 0)
+	if !r.Verified {
+		t.Fatalf("v2 signed file does not verify on API 24+: %v", r.Errors())
+	}
+	s := r.Signers[0]
+	sum := sha256.Sum256(s.Certs[0].Raw)
+	if s.SHA256 != hex.EncodeToString(sum[:]) || len(s.SHA1) != 40 {
+		t.Errorf("fingerprints = %s, %s", s.SHA256, s.SHA1)
+	}
+	if len(s.Algorithms) == 0 || s.Algorithms[0] != RSAPKCS1v15WithSHA256 {
+		t.Errorf("algorithms = %v", s.Algorithms)
+	}
+	if r = z.Verify(21, 0); r.Verified || r.SDKErr == nil {
+		t.Errorf("v2 only file verified on API 21: %v", r.SDKErr)
+	}
+	if r = z.Verify(30, 24); r.Verified {
+		t.Error("verified with min SDK above max SDK")
+	}
+}
+
+func TestSigners(t *testing.T) {
+	in, err := NewApkSign(testZip(t, "AndroidManifest.xml", "manifest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1, err := in.SignV1(testSigningCerts(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := NewApkSign(v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := z.SignV3(testSigningCerts(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if z, err = NewApkSign(signed); err != nil {
+		t.Fatal(err)
+	}
+	signers, err := z.Signers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schemes []Scheme
+	for _, s := range signers {
+		schemes = append(schemes, s.Scheme)
+		if s.SHA256 != signers[0].SHA256 || s.Certs[0].PublicKeyAlgorithm != x509.RSA {
+			t.Errorf("%s signer: %s, %s", s.Scheme, s.SHA256, s.Certs[0].PublicKeyAlgorithm)
+		}
+		if s.Scheme != SchemeV1 && (len(s.Digests) != 1 || len(s.Algorithms) != 1) {
+			t.Errorf("%s signer: digests %v, algorithms %v", s.Scheme, s.Digests, s.Algorithms)
+		}
+	}
+	if !slices.Equal(schemes, []Scheme{SchemeV1, SchemeV2, SchemeV3}) {
+		t.Errorf("schemes = %v", schemes)
+	}
+
+	// signers are reported even when the content no longer matches
+	raw := z.Bytes()
+	raw[40] ^= 1
+	if z, err = NewApkSign(raw); err != nil {
+		t.Fatal(err)
+	}
+	if signers, err = z.Signers(); err != nil || len(signers) != 3 {
+		t.Errorf("tampered file: %d signers, %v", len(signers), err)
+	}
+}
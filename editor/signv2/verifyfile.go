@@ -0,0 +1,160 @@
+package signv2
+
+import (
+	"context"
+	"crypto"
+	"encoding/binary"
+	"io"
+	"os"
+	"time"
+)
+
+// DefaultVerifyWindow is the window VerifyFile uses when VerifyFileOptions.Window is zero.
+const DefaultVerifyWindow = 16 << 20
+
+// VerifyFileOptions configures VerifyFile.
+type VerifyFileOptions struct {
+	// Window bounds the file content held in memory: the signing block, the Central Directory and
+	// the EOCD must fit in it, and the files section is hashed one window at a time, so at most
+	// twice Window is held at once. It is rounded up to a whole number of 1MB digest chunks; zero
+	// means DefaultVerifyWindow.
+	Window int
+	// ExpiryWindow and Time have the same meaning as in VerifyOptions.
+	ExpiryWindow time.Duration
+	Time         time.Time
+}
+
+// VerifyFile verifies the file at path like VerifyAll, but reads it through a window of fixed size
+// instead of loading or mapping it, so that memory use depends neither on the file size nor on how
+// the kernel pages in a mapping. Each call opens the file itself and shares no state with other
+// calls, which makes it safe to use concurrently from HTTP handlers; it is the recommended way to
+// verify uploads on a server. v4 is not checked.
+//
+// The error is non-nil only if the file cannot be read or parsed, e.g. ErrLimitExceeded when the
+// Central Directory does not fit in the window; signature failures are reported in the result.
+func VerifyFile(path string, opts VerifyFileOptions) (*VerificationResult, error) {
+	return VerifyFileContext(context.Background(), path, opts)
+}
+
+// VerifyFileContext is like VerifyFile but stops computing digests once ctx is done. The schemes not
+// checked by then fail with ctx.Err().
+func VerifyFileContext(ctx context.Context, path string, opts VerifyFileOptions) (_ *VerificationResult, err error) {
+	defer catch(&err, "VerifyFile", "file")
+	window := opts.Window
+	if window <= 0 {
+		window = DefaultVerifyWindow
+	}
+	window = (window + 1048575) &^ 1048575
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	z, err := openWindowed(f, window)
+	if err != nil {
+		return nil, err
+	}
+	return z.VerifyAllContext(ctx, VerifyOptions{ExpiryWindow: opts.ExpiryWindow, Time: opts.Time}), nil
+}
+
+// openWindowed parses f holding only its last window bytes in memory. The result is only fit for
+// verification, and must not be used after f is closed.
+func openWindowed(f *os.File, window int) (*ApkSign, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return newWindowed(f, fi.Size(), window, newOptions(nil))
+}
+
+// newWindowed parses the size bytes of r holding only its last window bytes in memory; the files
+// section is read from r when needed.
+func newWindowed(r io.ReaderAt, size int64, window int, o options) (*ApkSign, error) {
+	base := max(0, size-int64(window))
+	tail := make([]byte, size-base)
+	if n, err := r.ReadAt(tail, base); err != nil && (err != io.EOF || n < len(tail)) {
+		return nil, err
+	}
+	t, err := parseTail(tail, base)
+	if err != nil {
+		return nil, err
+	}
+	z := &ApkSign{
+		size:       size,
+		eocdOffset: uint64(base) + uint64(t.eocd),
+		cdOffset:   uint64(base) + uint64(t.cd),
+		rawASv2:    t.block,
+		ra:         r,
+		options:    o,
+		window:     window,
+		tail:       tail,
+		tailBase:   uint64(base),
+	}
+	if t.block != nil {
+		z.asv2Offset = uint64(base) + uint64(t.filesEnd)
+	}
+	z.IsV2Signed = z.asv2Offset > 0
+	names, err := z.scanEntries()
+	if err != nil {
+		return nil, err
+	}
+	z.setParsed(names)
+	return z, nil
+}
+
+// windowedContentDigest is contentDigest in windowed mode: the files section is read
+// into a buffer of window bytes, whose chunks are hashed in parallel before the next read. pad zero
+// bytes are added to the files section, as for optionsContentDigest.
+func (apkSign *ApkSign) windowedContentDigest(ctx context.Context, hash crypto.Hash, workers int, pad uint64) ([]byte, error) {
+	filesEnd := apkSign.filesEnd()
+	cd := apkSign.tail[apkSign.cdOffset-apkSign.tailBase : apkSign.eocdOffset-apkSign.tailBase]
+	revisedEOCD := make([]byte, uint64(apkSign.size)-apkSign.eocdOffset)
+	copy(revisedEOCD, apkSign.tail[apkSign.eocdOffset-apkSign.tailBase:])
+	setCDOffset(revisedEOCD, filesEnd+pad, uint64(len(cd)))
+
+	total := int64(filesEnd+pad) + int64(len(cd)+len(revisedEOCD))
+	var sums []byte
+	var count, done int64
+	digest := func(b []byte) {
+		for _, c := range parallelBufferHash(ctx, b, hash, workers) {
+			sums = append(sums, <-c...)
+			count++
+		}
+		done += int64(len(b))
+		if apkSign.Progress != nil {
+			apkSign.Progress(StageDigest, done, total)
+		}
+	}
+
+	buf := make([]byte, min(uint64(apkSign.window), filesEnd))
+	for off := uint64(0); off < filesEnd; {
+		n := min(uint64(len(buf)), filesEnd-off)
+		if m, err := apkSign.ra.ReadAt(buf[:n], int64(off)); err != nil && (err != io.EOF || uint64(m) < n) {
+			return nil, err
+		}
+		if off+n == filesEnd && pad > 0 {
+			// windows are whole 1MB chunks, so the padding continues the last one
+			digest(append(buf[:n:n], make([]byte, pad)...))
+		} else {
+			digest(buf[:n])
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		off += n
+	}
+	digest(cd)
+	digest(revisedEOCD)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var prefix [5]byte
+	prefix[0] = 0x5a
+	binary.LittleEndian.PutUint32(prefix[1:], uint32(count))
+	h := hash.New()
+	h.Write(prefix[:])
+	h.Write(sums)
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,87 @@
+package signv2
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestVerifyFile(t *testing.T) {
+	dir := t.TempDir()
+	signed := testSignedApk(t)
+	good := filepath.Join(dir, "good.apk")
+	if err := os.WriteFile(good, signed, 0644); err != nil {
+		t.Fatal(err)
+	}
+	tampered := bytes.Clone(signed)
+	tampered[len(tampered)/3] ^= 1
+	bad := filepath.Join(dir, "bad.apk")
+	if err := os.WriteFile(bad, tampered, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	z, err := NewApkSign(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := z.VerifyAll(VerifyOptions{})
+
+	// a 1MB window hashes the 3MB files section in several reads; run concurrently, as a server would
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := VerifyFile(good, VerifyFileOptions{Window: 1})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !got.Verified || !reflect.DeepEqual(got.Schemes, want.Schemes) || len(got.Signers) != len(want.Signers) {
+				t.Errorf("VerifyFile = %+v, want %+v", got, want)
+			}
+			got, err = VerifyFile(bad, VerifyFileOptions{Window: 1})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if got.Verified || !errors.Is(got.Scheme(SchemeV2).Err, ErrDigestMismatch) {
+				t.Errorf("tampered: v2 error %v", got.Scheme(SchemeV2).Err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// the digest must match the in-memory one for every window size
+	f, err := os.Open(good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, window := range []int{1 << 20, 2 << 20, DefaultVerifyWindow} {
+		w, err := openWindowed(f, window)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p := w.ParseResult(); !reflect.DeepEqual(p, z.ParseResult()) {
+			t.Errorf("window %d: ParseResult %+v, want %+v", window, p, z.ParseResult())
+		}
+		digest, err := w.contentDigest(context.Background(), crypto.SHA256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(digest, testContentDigest(t, z)) {
+			t.Errorf("window %d: content digest differs", window)
+		}
+	}
+
+	if _, err = VerifyFile(filepath.Join(dir, "missing.apk"), VerifyFileOptions{}); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing file: got %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package signv2
+
+import "encoding/binary"
+
+// Zip64 end of central directory record and locator, which precede the EOCD when the offset or size
+// of the Central Directory, or the number of entries, do not fit in it, e.g. for archives over 4GB.
+const (
+	zip64LocatorMagic = 0x07064b50
+	zip64RecordMagic  = 0x06064b50
+	zip64LocatorLen   = 20
+	zip64RecordLen    = 56 // without the extensible data
+)
+
+// centralDirectory returns the offset and length of the Central Directory of the zip whose EOCD is
+// at buf[eocd], base being the file offset of buf[0], and the index in buf of the records ending the
+// Central Directory: the Zip64 end of central directory record if there is one, else the EOCD. These
+// records are treated as a whole, as the EOCD of the v2 spec. ok is false if a Zip64 locator points
+// outside buf or to something that is not the record right before it.
+func centralDirectory(buf []byte, base int64, eocd int) (cd, cdLen uint64, end int, ok bool) {
+	loc := eocd - zip64LocatorLen
+	if loc < 0 || binary.LittleEndian.Uint32(buf[loc:]) != zip64LocatorMagic {
+		cd = uint64(binary.LittleEndian.Uint32(buf[eocd+16:]))
+		cdLen = uint64(binary.LittleEndian.Uint32(buf[eocd+12:]))
+		return cd, cdLen, eocd, true
+	}
+	rec := int64(binary.LittleEndian.Uint64(buf[loc+8:])) - base
+	if rec < 0 || rec > int64(loc-zip64RecordLen) || binary.LittleEndian.Uint32(buf[rec:]) != zip64RecordMagic ||
+		binary.LittleEndian.Uint64(buf[rec+4:]) != uint64(int64(loc)-rec-12) {
+		return 0, 0, 0, false
+	}
+	return binary.LittleEndian.Uint64(buf[rec+48:]), binary.LittleEndian.Uint64(buf[rec+40:]), int(rec), true
+}
+
+// setCDOffset updates end, a copy of the records ending a Central Directory of cdLen bytes, for the
+// Central Directory to start at cd. The Zip64 locator moves along with the record it points to; an
+// EOCD deferring to the Zip64 record with 0xffffffff is left as is.
+func setCDOffset(end []byte, cd, cdLen uint64) {
+	if binary.LittleEndian.Uint32(end) == zip64RecordMagic {
+		loc := 12 + binary.LittleEndian.Uint64(end[4:])
+		binary.LittleEndian.PutUint64(end[48:], cd)
+		binary.LittleEndian.PutUint64(end[loc+8:], cd+cdLen)
+		end = end[loc+zip64LocatorLen:]
+		if binary.LittleEndian.Uint32(end[16:]) == 0xffffffff {
+			return
+		}
+		cd = min(cd, 0xffffffff)
+	}
+	binary.LittleEndian.PutUint32(end[16:], uint32(cd))
+}
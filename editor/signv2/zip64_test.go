@@ -0,0 +1,117 @@
+package signv2
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+// testZip64 rewrites the EOCD of a zip without comment as a Zip64 archive over 4GB would have it:
+// Zip64 records holding the Central Directory location, and an EOCD deferring to them.
+func testZip64(t testing.TB, z []byte) []byte {
+	eocd := len(z) - 22
+	if binary.LittleEndian.Uint32(z[eocd:]) != 0x06054b50 {
+		t.Fatal("zip has a comment")
+	}
+	entries := uint64(binary.LittleEndian.Uint16(z[eocd+10:]))
+	cdLen := uint64(binary.LittleEndian.Uint32(z[eocd+12:]))
+	cd := uint64(binary.LittleEndian.Uint32(z[eocd+16:]))
+
+	out := bytes.Clone(z[:eocd])
+	out = binary.LittleEndian.AppendUint32(out, zip64RecordMagic)
+	out = binary.LittleEndian.AppendUint64(out, zip64RecordLen-12)
+	out = binary.LittleEndian.AppendUint16(out, 45)
+	out = binary.LittleEndian.AppendUint16(out, 45)
+	out = binary.LittleEndian.AppendUint64(out, 0) // this disk and the CD disk
+	out = binary.LittleEndian.AppendUint64(out, entries)
+	out = binary.LittleEndian.AppendUint64(out, entries)
+	out = binary.LittleEndian.AppendUint64(out, cdLen)
+	out = binary.LittleEndian.AppendUint64(out, cd)
+	out = binary.LittleEndian.AppendUint32(out, zip64LocatorMagic)
+	out = binary.LittleEndian.AppendUint32(out, 0)
+	out = binary.LittleEndian.AppendUint64(out, uint64(eocd))
+	out = binary.LittleEndian.AppendUint32(out, 1)
+	out = append(out, z[eocd:eocd+8]...)
+	out = binary.LittleEndian.AppendUint16(out, 0xffff)
+	out = binary.LittleEndian.AppendUint16(out, 0xffff)
+	out = binary.LittleEndian.AppendUint32(out, 0xffffffff)
+	out = binary.LittleEndian.AppendUint32(out, 0xffffffff)
+	return binary.LittleEndian.AppendUint16(out, 0)
+}
+
+func TestZip64(t *testing.T) {
+	plain := testZip(t, "AndroidManifest.xml", "manifest", "assets/big.bin", string(bytes.Repeat([]byte{7}, 2<<20)))
+	unsigned := testZip64(t, plain)
+	z, err := NewApkSign(unsigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := z.ParseResult()
+	if !p.Zip64 || p.CDOffset != int64(binary.LittleEndian.Uint32(plain[len(plain)-6:])) || p.EOCDOffset != int64(len(plain)-22) {
+		t.Errorf("ParseResult = %+v", p)
+	}
+	signed, err := z.SignV2(testSigningCerts(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the Zip64 records must follow the CD to its new offset
+	s, err := NewApkSign(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.VerifyV2(); err != nil {
+		t.Error(err)
+	}
+	if p := s.ParseResult(); !p.Zip64 || p.CDOffset != p.SigningBlockOffset+p.SigningBlockSize {
+		t.Errorf("signed: ParseResult = %+v", p)
+	}
+	r, err := zip.NewReader(bytes.NewReader(signed), int64(len(signed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.File) != 2 {
+		t.Errorf("signed: %d entries", len(r.File))
+	} else if rc, err := r.File[1].Open(); err != nil {
+		t.Error(err)
+	} else if b, _ := io.ReadAll(rc); len(b) != 2<<20 {
+		t.Errorf("signed: read %d bytes", len(b))
+	}
+
+	// re-signing replaces the block, and the other signing paths agree
+	again, err := s.SignV2(testSigningCerts(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again, signed) {
+		t.Error("re-signed output differs")
+	}
+	streamed := new(bytes.Buffer)
+	if err = SignStream(bytes.NewReader(unsigned), streamed, StreamConfig{Keys: testSigningCerts(t), TailSize: 1 << 20}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(streamed.Bytes(), signed) {
+		t.Error("SignStream output differs")
+	}
+	w, err := NewApkSignFromReaderAt(bytes.NewReader(unsigned), int64(len(unsigned)), WithWindow(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	windowed := new(bytes.Buffer)
+	if err = w.SignV2To(windowed, testSigningCerts(t)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(windowed.Bytes(), signed) {
+		t.Error("SignV2To output differs")
+	}
+
+	// a locator pointing elsewhere is not taken for a Zip64 archive
+	bad := bytes.Clone(unsigned)
+	binary.LittleEndian.PutUint64(bad[len(bad)-22-zip64LocatorLen+8:], 0)
+	if _, err = NewApkSign(bad); !errors.Is(err, ErrNotZip) {
+		t.Errorf("bad locator: got %v", err)
+	}
+}
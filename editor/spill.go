@@ -0,0 +1,52 @@
+package editor
+
+import (
+	"bytes"
+	"os"
+
+	"github.com/pzx521521/apk-editor/editor/signv2"
+)
+
+// spillBuffer 在内存中缓冲写入的数据, 超过 limit 字节后整体转存到临时文件. limit <= 0 时始终在内存中.
+type spillBuffer struct {
+	limit int64
+	buf   bytes.Buffer
+	file  *os.File
+}
+
+func (s *spillBuffer) Write(p []byte) (int, error) {
+	if s.file == nil && s.limit > 0 && int64(s.buf.Len()+len(p)) > s.limit {
+		f, err := os.CreateTemp("", "apkeditor-*.apk")
+		if err != nil {
+			return 0, err
+		}
+		s.file = f
+		if _, err = f.Write(s.buf.Bytes()); err != nil {
+			return 0, err
+		}
+		s.buf = bytes.Buffer{}
+	}
+	if s.file != nil {
+		return s.file.Write(p)
+	}
+	return s.buf.Write(p)
+}
+
+// apkSign 解析已写入的内容: 在内存中时不复制, 已转存时映射临时文件
+func (s *spillBuffer) apkSign() (*signv2.ApkSign, error) {
+	if s.file == nil {
+		return signv2.NewApkSignNoCopy(s.buf.Bytes())
+	}
+	return signv2.OpenApkSignFile(s.file.Name())
+}
+
+// Close 删除临时文件
+func (s *spillBuffer) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	s.file.Close()
+	err := os.Remove(s.file.Name())
+	s.file = nil
+	return err
+}
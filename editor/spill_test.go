@@ -0,0 +1,98 @@
+package editor
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pzx521521/apk-editor/editor/signv2"
+)
+
+func TestSpillBuffer(t *testing.T) {
+	s := &spillBuffer{limit: 10}
+	s.Write([]byte("hello"))
+	if s.file != nil {
+		t.Fatal("spilled below the limit")
+	}
+	s.Write([]byte(" world"))
+	if s.file == nil {
+		t.Fatal("did not spill above the limit")
+	}
+	name := s.file.Name()
+	b, _ := os.ReadFile(name)
+	if string(b) != "hello world" {
+		t.Errorf("temp file = %q", b)
+	}
+	s.Close()
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Error("temp file not removed")
+	}
+}
+
+func TestEditMemoryLimit(t *testing.T) {
+	apk, err := os.ReadFile("../release/app-release.apk")
+	if err != nil {
+		t.Skip(err)
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "editor test"},
+		NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := NewApkEditor(apk,
+		pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	a.Url = "https://example.com"
+	var ops []string
+	a.Metrics = metricsFunc(func(op string, n int64, _ time.Duration, err error) {
+		if (op == signv2.OpEdit && n != int64(len(apk))) || err != nil {
+			t.Errorf("%s: %d bytes, %v", op, n, err)
+		}
+		ops = append(ops, op)
+	})
+	inMemory, err := a.Edit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 2 || ops[0] != signv2.OpSign || ops[1] != signv2.OpEdit {
+		t.Errorf("recorded %v, want [sign edit]", ops)
+	}
+	a.MemoryLimit = 1 << 10
+	var copied, total int64
+	a.Progress = func(stage string, done, n int64) {
+		if stage == signv2.StageCopy {
+			copied, total = done, n
+		}
+	}
+	spilled, err := a.Edit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied == 0 || copied != total {
+		t.Errorf("copy progress ended at %d of %d", copied, total)
+	}
+	if !bytes.Equal(inMemory, spilled) {
+		t.Error("output differs when spilling to a temp file")
+	}
+	if err = signv2.CheckSigned(spilled); err != nil {
+		t.Error(err)
+	}
+}
+
+type metricsFunc func(op string, bytes int64, d time.Duration, err error)
+
+func (f metricsFunc) RecordOperation(op string, bytes int64, d time.Duration, err error) {
+	f(op, bytes, d, err)
+}
@@ -0,0 +1,323 @@
+package splits
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/pzx521521/apk-editor/editor/internal/restable"
+	"github.com/pzx521521/apk-editor/editor/internal/stringpool"
+)
+
+// Chunk types of resources.arsc.
+const (
+	chunkStringPool = 0x0001
+	chunkTable      = 0x0002
+	chunkPackage    = 0x0200
+	chunkType       = 0x0201
+	chunkTypeSpec   = 0x0202
+)
+
+const (
+	typeString     = 0x03
+	entryComplex   = 0x0001
+	entryCompact   = 0x0008
+	typeSpecHeader = 16
+
+	// offsets in the ResTable_package header
+	pkgTypeStrings    = 268
+	pkgLastPublicType = 272
+	pkgKeyStrings     = 276
+	pkgLastPublicKey  = 280
+)
+
+var errTruncatedTable = restable.ErrTruncated
+
+// mergedPackage gathers the chunks of one package from the tables of several splits.
+type mergedPackage struct {
+	header    []byte
+	typeNames []string // 按类型 ID - 1 索引
+	keys      stringpool.Pool
+	specs     map[uint8][]uint32
+	types     map[uint8][][]byte
+	other     [][]byte
+}
+
+// mergeTables merges the resources.arsc of a base APK and of its splits. The type chunks of every
+// split are added to the package of the base with the same ID, with their key and value string
+// indices moved to the merged pools; the chunks themselves are copied, so that entry layouts of any
+// platform version survive.
+func mergeTables(tables [][]byte) ([]byte, error) {
+	type parsed struct {
+		strs  []string
+		spans [][]stringpool.Span
+		pkgs  [][]byte
+	}
+	var ps []parsed
+	for _, t := range tables {
+		if len(t) < 12 || binary.LittleEndian.Uint16(t) != chunkTable {
+			return nil, errors.New("resources.arsc is not a resource table")
+		}
+		var p parsed
+		err := restable.Chunks(t, int(binary.LittleEndian.Uint16(t[2:])), func(_ int, typ uint16, c []byte) (err error) {
+			switch typ {
+			case chunkStringPool:
+				p.strs, p.spans, err = stringpool.Decode(c)
+			case chunkPackage:
+				p.pkgs = append(p.pkgs, c)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		ps = append(ps, p)
+	}
+
+	// the format keeps styled strings ahead of the others
+	var pool stringpool.Pool
+	remaps := make([][]uint32, len(ps))
+	for i, p := range ps {
+		remaps[i] = make([]uint32, len(p.strs))
+		for j, spans := range p.spans {
+			remaps[i][j] = pool.AddStyled(p.strs[j], spans)
+		}
+	}
+	for i, p := range ps {
+		for j := len(p.spans); j < len(p.strs); j++ {
+			remaps[i][j] = pool.Add(p.strs[j])
+		}
+	}
+
+	var ids []uint32
+	pkgs := map[uint32]*mergedPackage{}
+	for i, p := range ps {
+		for _, c := range p.pkgs {
+			if len(c) < pkgLastPublicKey+4 {
+				return nil, errTruncatedTable
+			}
+			id := binary.LittleEndian.Uint32(c[8:])
+			mp := pkgs[id]
+			first := mp == nil
+			if first {
+				hdr := int(binary.LittleEndian.Uint16(c[2:]))
+				if hdr < pkgLastPublicKey+4 || hdr > len(c) {
+					return nil, errTruncatedTable
+				}
+				mp = &mergedPackage{header: c[:hdr], specs: map[uint8][]uint32{}, types: map[uint8][][]byte{}}
+				pkgs[id] = mp
+				ids = append(ids, id)
+			}
+			if err := mp.add(c, remaps[i], first); err != nil {
+				return nil, fmt.Errorf("package 0x%02x: %w", id, err)
+			}
+		}
+	}
+
+	body := pool.Encode(true)
+	for _, id := range ids {
+		body = append(body, pkgs[id].encode()...)
+	}
+	b := binary.LittleEndian.AppendUint16(nil, chunkTable)
+	b = binary.LittleEndian.AppendUint16(b, 12)
+	b = binary.LittleEndian.AppendUint32(b, uint32(12+len(body)))
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(ids)))
+	return append(b, body...), nil
+}
+
+// add merges the package chunk c, whose value strings are moved by strs. The chunks the merge does
+// not know of, such as the shared library table, are taken from the first package only.
+func (mp *mergedPackage) add(c []byte, strs []uint32, first bool) error {
+	typeStrings := int(binary.LittleEndian.Uint32(c[pkgTypeStrings:]))
+	keyStrings := int(binary.LittleEndian.Uint32(c[pkgKeyStrings:]))
+	var keys []uint32
+	var specs, types [][]byte
+	err := restable.Chunks(c, int(binary.LittleEndian.Uint16(c[2:])), func(off int, typ uint16, chunk []byte) error {
+		switch {
+		case typ == chunkStringPool && off == typeStrings:
+			names, _, err := stringpool.Decode(chunk)
+			for i, name := range names {
+				if i == len(mp.typeNames) {
+					mp.typeNames = append(mp.typeNames, name)
+				} else if mp.typeNames[i] == "" {
+					mp.typeNames[i] = name
+				}
+			}
+			return err
+		case typ == chunkStringPool && off == keyStrings:
+			names, _, err := stringpool.Decode(chunk)
+			for _, name := range names {
+				keys = append(keys, mp.keys.Add(name))
+			}
+			return err
+		case typ == chunkTypeSpec:
+			specs = append(specs, chunk)
+		case typ == chunkType:
+			types = append(types, chunk)
+		case first:
+			mp.other = append(mp.other, chunk)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, c := range specs {
+		if len(c) < typeSpecHeader {
+			return errTruncatedTable
+		}
+		id, hdr := c[8], int(binary.LittleEndian.Uint16(c[2:]))
+		count := int(binary.LittleEndian.Uint32(c[12:]))
+		if hdr < typeSpecHeader || count > (len(c)-hdr)/4 {
+			return errTruncatedTable
+		}
+		flags := mp.specs[id]
+		for i := range count {
+			f := binary.LittleEndian.Uint32(c[hdr+4*i:])
+			if i == len(flags) {
+				flags = append(flags, f)
+			} else {
+				flags[i] |= f
+			}
+		}
+		mp.specs[id] = flags
+	}
+	for _, c := range types {
+		patched := slices.Clone(c)
+		if err = patchType(patched, keys, strs); err != nil {
+			return err
+		}
+		mp.types[c[8]] = append(mp.types[c[8]], patched)
+	}
+	return nil
+}
+
+// patchType moves the key and string value indices of the entries of a type chunk.
+func patchType(c []byte, keys, strs []uint32) error {
+	return restable.TypeEntries(c, func(_ int, e []byte) error {
+		return patchEntry(e, keys, strs)
+	})
+}
+
+// patchEntry moves the indices of a ResTable_entry, compact or not, and of its values.
+func patchEntry(e []byte, keys, strs []uint32) error {
+	if len(e) < 8 {
+		return errTruncatedTable
+	}
+	size := int(binary.LittleEndian.Uint16(e))
+	flags := binary.LittleEndian.Uint16(e[2:])
+	if flags&entryCompact != 0 {
+		// the key takes the place of the size, the type that of the high byte of the flags
+		key, err := remap(keys, uint32(size))
+		if err != nil {
+			return err
+		}
+		if key > 0xffff {
+			return fmt.Errorf("key %d of a compact entry does not fit in 16 bits", key)
+		}
+		binary.LittleEndian.PutUint16(e, uint16(key))
+		if flags>>8 == typeString {
+			return patchValue(e[4:], strs)
+		}
+		return nil
+	}
+	key, err := remap(keys, binary.LittleEndian.Uint32(e[4:]))
+	if err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(e[4:], key)
+	if flags&entryComplex == 0 {
+		if size+8 > len(e) {
+			return errTruncatedTable
+		}
+		if e[size+3] == typeString {
+			return patchValue(e[size+4:], strs)
+		}
+		return nil
+	}
+	if len(e) < 16 {
+		return errTruncatedTable
+	}
+	count := int(binary.LittleEndian.Uint32(e[12:]))
+	if size > len(e) || count > (len(e)-size)/12 {
+		return errTruncatedTable
+	}
+	for i := range count {
+		m := e[size+12*i:]
+		if m[7] == typeString {
+			if err = patchValue(m[8:], strs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func patchValue(data []byte, strs []uint32) error {
+	i, err := remap(strs, binary.LittleEndian.Uint32(data))
+	binary.LittleEndian.PutUint32(data, i)
+	return err
+}
+
+func remap(indices []uint32, i uint32) (uint32, error) {
+	if int64(i) >= int64(len(indices)) {
+		return i, fmt.Errorf("string index %d out of range", i)
+	}
+	return indices[i], nil
+}
+
+// encode returns the merged package chunk, with a type spec chunk per type followed by the type
+// chunks of all tables.
+func (mp *mergedPackage) encode() []byte {
+	var typePool stringpool.Pool
+	for _, name := range mp.typeNames {
+		typePool.Append(name)
+	}
+	typeStrings := typePool.Encode(true)
+	keyStrings := mp.keys.Encode(true)
+	hdr := len(mp.header)
+
+	var body []byte
+	for _, c := range mp.other {
+		body = append(body, c...)
+	}
+	ids := make([]uint8, 0, len(mp.specs))
+	for id := range mp.specs {
+		ids = append(ids, id)
+	}
+	for id := range mp.types {
+		if _, ok := mp.specs[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	for _, id := range ids {
+		flags := mp.specs[id]
+		body = binary.LittleEndian.AppendUint16(body, chunkTypeSpec)
+		body = binary.LittleEndian.AppendUint16(body, typeSpecHeader)
+		body = binary.LittleEndian.AppendUint32(body, uint32(typeSpecHeader+4*len(flags)))
+		body = append(body, id, 0)
+		body = binary.LittleEndian.AppendUint16(body, uint16(len(mp.types[id])))
+		body = binary.LittleEndian.AppendUint32(body, uint32(len(flags)))
+		for _, f := range flags {
+			body = binary.LittleEndian.AppendUint32(body, f)
+		}
+		for _, c := range mp.types[id] {
+			body = append(body, c...)
+		}
+	}
+
+	size := hdr + len(typeStrings) + len(keyStrings) + len(body)
+	b := make([]byte, 0, size)
+	b = append(b, mp.header...)
+	binary.LittleEndian.PutUint32(b[4:], uint32(size))
+	binary.LittleEndian.PutUint32(b[pkgTypeStrings:], uint32(hdr))
+	binary.LittleEndian.PutUint32(b[pkgLastPublicType:], uint32(len(mp.typeNames)))
+	binary.LittleEndian.PutUint32(b[pkgKeyStrings:], uint32(hdr+len(typeStrings)))
+	binary.LittleEndian.PutUint32(b[pkgLastPublicKey:], uint32(mp.keys.Len()))
+	b = append(b, typeStrings...)
+	b = append(b, keyStrings...)
+	return append(b, body...)
+}
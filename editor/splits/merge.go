@@ -0,0 +1,198 @@
+package splits
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pzx521521/apk-editor/editor/axml"
+	"github.com/pzx521521/apk-editor/editor/bundle"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+// Alignment of uncompressed entries in a merged APK, as zipalign -p applies it.
+const (
+	alignDefault = 4
+	alignLibs    = 16 << 10
+)
+
+// ErrNoBase is returned by Merge when no base APK is among the splits.
+var ErrNoBase = errors.New("no base apk among the splits")
+
+// Standalone merges the splits Select picks for spec into one APK, see Merge.
+func (s *Set) Standalone(spec DeviceSpec) ([]byte, error) {
+	return Merge(s.Select(spec))
+}
+
+// Merge combines a base APK and splits of it into one unsigned APK that can be installed alone,
+// e.g. by sideloading: the resource tables are merged, the dex files of feature splits numbered
+// after those of the base and the other files copied, the base winning over the splits. The
+// manifest is the base one, changed so as not to require splits.
+func Merge(splits []*Split) ([]byte, error) {
+	var base *Split
+	for _, s := range splits {
+		if s.IsBase() {
+			base = s
+		}
+	}
+	if base == nil {
+		return nil, ErrNoBase
+	}
+	ordered := []*Split{base}
+	for _, s := range splits {
+		if s != base {
+			ordered = append(ordered, s)
+		}
+	}
+
+	var readers []*zip.Reader
+	var tables [][]byte
+	var manifest *axml.Element
+	for _, s := range ordered {
+		r, err := zip.NewReader(bytes.NewReader(s.Data), int64(len(s.Data)))
+		if err != nil {
+			return nil, err
+		}
+		readers = append(readers, r)
+		for _, f := range r.File {
+			switch {
+			case f.Name == "resources.arsc":
+				data, err := readEntry(f)
+				if err != nil {
+					return nil, err
+				}
+				tables = append(tables, data)
+			case f.Name == zip.ANDROIDMANIFEST && s == base:
+				data, err := readEntry(f)
+				if err == nil {
+					manifest, err = axml.Parse(data)
+				}
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	if manifest == nil {
+		return nil, errors.New("base apk has no " + zip.ANDROIDMANIFEST)
+	}
+	manifest = bundle.UniversalManifest(manifest)
+
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	if len(tables) > 0 {
+		arsc, err := mergeTables(tables)
+		if err != nil {
+			return nil, err
+		}
+		// resources.arsc comes first, where aligning it needs no padding
+		if err = writeEntry(w, "resources.arsc", zip.Store, arsc); err != nil {
+			return nil, err
+		}
+	}
+	if err := writeEntry(w, zip.ANDROIDMANIFEST, zip.Deflate, axml.Encode(manifest)); err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{"resources.arsc": true, zip.ANDROIDMANIFEST: true}
+	dex := 0
+	for i, r := range readers {
+		if i == 0 {
+			for _, f := range r.File {
+				if isDex(f.Name) {
+					dex++
+				}
+			}
+		}
+		for _, f := range r.File {
+			name := f.Name
+			if i > 0 && isDex(name) {
+				if dex++; dex == 1 {
+					name = "classes.dex"
+				} else {
+					name = "classes" + strconv.Itoa(dex) + ".dex"
+				}
+			}
+			if seen[name] || strings.HasSuffix(name, "/") || isSignatureFile(name) {
+				continue
+			}
+			seen[name] = true
+			if name == f.Name && f.Method != zip.Store {
+				if err := w.Copy(f); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			data, err := readEntry(f)
+			if err == nil {
+				err = writeEntry(w, name, f.Method, data)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// isDex reports whether name is classes.dex or classesN.dex.
+func isDex(name string) bool {
+	n, ok := strings.CutPrefix(name, "classes")
+	if n, ok = strings.CutSuffix(n, ".dex"); !ok {
+		return false
+	}
+	_, err := strconv.Atoi(n)
+	return n == "" || err == nil
+}
+
+// isSignatureFile reports whether name belongs to a signature of the split it comes from, which
+// the merged APK no longer matches: the v1 signature files, and the source stamp certificate
+// digest.
+func isSignatureFile(name string) bool {
+	if name == "stamp-cert-sha256" {
+		return true
+	}
+	dir, file := path.Split(name)
+	if dir != "META-INF/" {
+		return false
+	}
+	switch strings.ToUpper(path.Ext(file)) {
+	case ".MF", ".SF", ".RSA", ".DSA", ".EC":
+		return true
+	}
+	return false
+}
+
+func readEntry(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// writeEntry adds an entry, aligning it if stored.
+func writeEntry(w *zip.Writer, name string, method uint16, data []byte) error {
+	fh := &zip.FileHeader{Name: name, Method: method}
+	var fw io.Writer
+	var err error
+	switch {
+	case method != zip.Store:
+		fw, err = w.CreateHeader(fh)
+	case strings.HasPrefix(name, "lib/") && strings.HasSuffix(name, ".so"):
+		fw, err = w.CreateAlignedHeader(fh, alignLibs)
+	default:
+		fw, err = w.CreateAlignedHeader(fh, alignDefault)
+	}
+	if err == nil {
+		_, err = fw.Write(data)
+	}
+	return err
+}
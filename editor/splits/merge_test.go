@@ -0,0 +1,179 @@
+package splits
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/pzx521521/apk-editor/editor/axml"
+	"github.com/pzx521521/apk-editor/editor/internal/restable"
+	"github.com/pzx521521/apk-editor/editor/internal/stringpool"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+// testTable returns a resources.arsc holding the string resource 0x7f010000 named key, with value
+// for the screen density density (0 for the default configuration).
+func testTable(key, value string, density uint16) []byte {
+	// the unused strings put the key and value at index 1, so that merging moves them
+	var pool, typePool, keyPool stringpool.Pool
+	pool.Add("unused " + value)
+	valueIndex := pool.Add(value)
+	strs := pool.Encode(true)
+	typePool.Add("string")
+	types := typePool.Encode(true)
+	keyPool.Add("unused " + key)
+	keyIndex := keyPool.Add(key)
+	keys := keyPool.Encode(true)
+
+	le := binary.LittleEndian
+	spec := le.AppendUint16(nil, chunkTypeSpec)
+	spec = le.AppendUint16(spec, typeSpecHeader)
+	spec = le.AppendUint32(spec, typeSpecHeader+4)
+	spec = append(spec, 1, 0, 0, 0)
+	spec = le.AppendUint32(spec, 1)
+	spec = le.AppendUint32(spec, 0)
+
+	const typeHeader = 20 + 64
+	typ := le.AppendUint16(nil, chunkType)
+	typ = le.AppendUint16(typ, typeHeader)
+	typ = le.AppendUint32(typ, typeHeader+4+16)
+	typ = append(typ, 1, 0, 0, 0)
+	typ = le.AppendUint32(typ, 1)
+	typ = le.AppendUint32(typ, typeHeader+4)
+	config := make([]byte, 64)
+	le.PutUint32(config, 64)
+	le.PutUint16(config[14:], density)
+	typ = append(typ, config...)
+	typ = le.AppendUint32(typ, 0)
+	typ = le.AppendUint16(typ, 8)
+	typ = le.AppendUint16(typ, 0)
+	typ = le.AppendUint32(typ, keyIndex)
+	typ = append(typ, 8, 0, 0, typeString)
+	typ = le.AppendUint32(typ, valueIndex)
+
+	const pkgHeader = 288
+	pkg := le.AppendUint16(nil, chunkPackage)
+	pkg = le.AppendUint16(pkg, pkgHeader)
+	pkg = le.AppendUint32(pkg, uint32(pkgHeader+len(types)+len(keys)+len(spec)+len(typ)))
+	pkg = le.AppendUint32(pkg, 0x7f)
+	pkg = append(pkg, make([]byte, 256)...)
+	pkg = le.AppendUint32(pkg, pkgHeader)
+	pkg = le.AppendUint32(pkg, 1)
+	pkg = le.AppendUint32(pkg, uint32(pkgHeader+len(types)))
+	pkg = le.AppendUint32(pkg, 2)
+	pkg = le.AppendUint32(pkg, 0)
+	pkg = append(pkg, types...)
+	pkg = append(pkg, keys...)
+	pkg = append(pkg, spec...)
+	pkg = append(pkg, typ...)
+
+	t := le.AppendUint16(nil, chunkTable)
+	t = le.AppendUint16(t, 12)
+	t = le.AppendUint32(t, uint32(12+len(strs)+len(pkg)))
+	t = le.AppendUint32(t, 1)
+	return append(append(t, strs...), pkg...)
+}
+
+// tableValues returns the value of entry 0 of type 1 in each configuration of a resources.arsc,
+// by density.
+func tableValues(t *testing.T, arsc []byte) map[uint16]string {
+	t.Helper()
+	values := map[uint16]string{}
+	var strs []string
+	err := restable.Chunks(arsc, 12, func(_ int, typ uint16, c []byte) (err error) {
+		switch typ {
+		case chunkStringPool:
+			strs, _, err = stringpool.Decode(c)
+		case chunkPackage:
+			var keys []string
+			keyStrings := int(binary.LittleEndian.Uint32(c[pkgKeyStrings:]))
+			return restable.Chunks(c, 288, func(off int, typ uint16, c []byte) (err error) {
+				switch {
+				case off == keyStrings:
+					keys, _, err = stringpool.Decode(c)
+				case typ == chunkType:
+					e := c[binary.LittleEndian.Uint32(c[16:])+binary.LittleEndian.Uint32(c[84:]):]
+					key := keys[binary.LittleEndian.Uint32(e[4:])]
+					values[binary.LittleEndian.Uint16(c[20+14:])] = key + "=" + strs[binary.LittleEndian.Uint32(e[12:])]
+				}
+				return err
+			})
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return values
+}
+
+func TestMerge(t *testing.T) {
+	set, err := Open(testZip(t,
+		entry{"base.apk", testAPK(t, "", "",
+			entry{"resources.arsc", testTable("app_name", "Hello", 0), zip.Store},
+			entry{"classes.dex", []byte("base dex"), zip.Deflate},
+			entry{"META-INF/CERT.RSA", []byte("signature"), zip.Deflate},
+			entry{"res/drawable/icon.png", []byte("mdpi"), zip.Store},
+		), zip.Store},
+		entry{"split_config.xxhdpi.apk", testAPK(t, "config.xxhdpi", "",
+			entry{"resources.arsc", testTable("app_name", "Hi", 480), zip.Store},
+			entry{"res/drawable-xxhdpi/icon.png", []byte("xxhdpi"), zip.Store},
+		), zip.Store},
+		entry{"split_config.arm64_v8a.apk", testAPK(t, "config.arm64_v8a", "",
+			entry{"lib/arm64-v8a/libfoo.so", []byte("elf"), zip.Store},
+		), zip.Store},
+		entry{"split_camera.apk", testAPK(t, "camera", "",
+			entry{"classes.dex", []byte("camera dex"), zip.Deflate},
+		), zip.Store},
+		entry{"manifest.json", []byte("{}"), zip.Deflate},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apk, err := set.Standalone(DeviceSpec{ABIs: []string{"arm64-v8a"}, Density: 480})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := zip.NewReader(bytes.NewReader(apk), int64(len(apk)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{}
+	for _, f := range r.File {
+		if files[f.Name], err = readEntry(f); err != nil {
+			t.Fatal(err)
+		}
+		if f.Method == zip.Store {
+			off, _ := f.DataOffset()
+			if f.Name == "lib/arm64-v8a/libfoo.so" && off%alignLibs != 0 || off%alignDefault != 0 {
+				t.Errorf("%s: data at %d is not aligned", f.Name, off)
+			}
+		}
+	}
+	for name, want := range map[string]string{
+		"classes.dex":                  "base dex",
+		"classes2.dex":                 "camera dex",
+		"res/drawable/icon.png":        "mdpi",
+		"res/drawable-xxhdpi/icon.png": "xxhdpi",
+		"lib/arm64-v8a/libfoo.so":      "elf",
+	} {
+		if string(files[name]) != want {
+			t.Errorf("%s = %q, want %q", name, files[name], want)
+		}
+	}
+	if _, ok := files["META-INF/CERT.RSA"]; ok {
+		t.Error("signature file of the base copied")
+	}
+
+	manifest, err := axml.Parse(files[zip.ANDROIDMANIFEST])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.AttrValue("", "package") != "com.example" || manifest.Attr("", "split") != nil {
+		t.Errorf("manifest attributes %+v", manifest.Attrs)
+	}
+	values := tableValues(t, files["resources.arsc"])
+	if len(values) != 2 || values[0] != "app_name=Hello" || values[480] != "app_name=Hi" {
+		t.Errorf("merged resources %v", values)
+	}
+}
@@ -0,0 +1,160 @@
+package splits
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// DeviceSpec describes the device splits are selected for. An empty field matches every split
+// of its dimension.
+type DeviceSpec struct {
+	ABIs    []string // 按偏好排序的 ABI, 如 arm64-v8a, armeabi-v7a
+	Density int      // 屏幕密度 dpi
+	Locales []string // 语言区域, 如 en-US, zh-CN
+	SDK     int      // API level
+}
+
+// abis are the ABIs bundletool names configuration splits after, with '-' written '_'.
+var abis = map[string]bool{
+	"armeabi": true, "armeabi_v7a": true, "arm64_v8a": true, "x86": true, "x86_64": true,
+	"mips": true, "mips64": true, "riscv64": true,
+}
+
+// densities maps the density qualifiers of configuration splits to dpi.
+var densities = map[string]int{
+	"ldpi": 120, "mdpi": 160, "tvdpi": 213, "hdpi": 240, "xhdpi": 320, "xxhdpi": 480, "xxxhdpi": 640,
+}
+
+// Select returns the APKs to install on a device: every base and feature split, with, for each
+// module, the configuration split of the first ABI in spec.ABIs the module has, the one of the
+// density that best matches spec.Density and those of the languages of spec.Locales. Splits of
+// other dimensions, such as texture compression formats, are all kept. A set without base, such
+// as a universal APK set, yields its first standalone APK the device can install.
+func (s *Set) Select(spec DeviceSpec) []*Split {
+	var modules []*Split
+	configs := map[string][]*Split{}
+	for _, split := range s.Splits {
+		if spec.SDK > 0 && split.MinSDK > spec.SDK || split.Standalone {
+			continue
+		}
+		if split.Config() == "" {
+			modules = append(modules, split)
+		} else {
+			configs[split.Module()] = append(configs[split.Module()], split)
+		}
+	}
+	if len(modules) == 0 {
+		for _, split := range s.Splits {
+			if split.Standalone && (spec.SDK == 0 || split.MinSDK <= spec.SDK) {
+				return []*Split{split}
+			}
+		}
+		return nil
+	}
+	out := append([]*Split(nil), modules...)
+	for _, m := range modules {
+		out = append(out, selectConfigs(configs[m.Module()], spec)...)
+	}
+	return out
+}
+
+// selectConfigs selects among the configuration splits of one module.
+func selectConfigs(splits []*Split, spec DeviceSpec) []*Split {
+	var abi, density *Split
+	abiRank := len(spec.ABIs)
+	var out []*Split
+	for _, split := range splits {
+		config := split.Config()
+		switch {
+		case abis[config]:
+			if len(spec.ABIs) == 0 {
+				out = append(out, split)
+				continue
+			}
+			for i, a := range spec.ABIs {
+				if strings.ReplaceAll(a, "-", "_") == config && i < abiRank {
+					abi, abiRank = split, i
+				}
+			}
+		case densities[config] > 0:
+			if spec.Density == 0 {
+				out = append(out, split)
+			} else if density == nil || betterDensity(densities[config], densities[density.Config()], spec.Density) {
+				density = split
+			}
+		case isLanguage(config):
+			if len(spec.Locales) == 0 || hasLanguage(spec.Locales, config) {
+				out = append(out, split)
+			}
+		default:
+			out = append(out, split)
+		}
+	}
+	if abi != nil {
+		out = append(out, abi)
+	}
+	if density != nil {
+		out = append(out, density)
+	}
+	return out
+}
+
+// betterDensity reports whether resources for dpi a suit a device of dpi want better than those
+// for dpi b: the platform scales down the nearest larger density rather than scale up a smaller
+// one.
+func betterDensity(a, b, want int) bool {
+	switch {
+	case a >= want && b >= want:
+		return a < b
+	case a >= want:
+		return true
+	case b >= want:
+		return false
+	}
+	return a > b
+}
+
+// isLanguage reports whether a split qualifier is a language code, which bundletool writes as the
+// bare two or three letter code.
+func isLanguage(config string) bool {
+	if len(config) < 2 || len(config) > 3 {
+		return false
+	}
+	for _, c := range config {
+		if c < 'a' || c > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+func hasLanguage(locales []string, language string) bool {
+	for _, l := range locales {
+		l, _, _ = strings.Cut(strings.ReplaceAll(l, "_", "-"), "-")
+		if strings.EqualFold(l, language) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseDeviceSpec parses a device spec in the JSON format of `bundletool get-device-spec`, e.g.
+// {"supportedAbis": ["arm64-v8a"], "supportedLocales": ["en-US"], "screenDensity": 420,
+// "sdkVersion": 34}.
+func ParseDeviceSpec(b []byte) (*DeviceSpec, error) {
+	var spec struct {
+		SupportedAbis    []string
+		SupportedLocales []string
+		ScreenDensity    int
+		SdkVersion       int
+	}
+	if err := json.Unmarshal(b, &spec); err != nil {
+		return nil, err
+	}
+	return &DeviceSpec{
+		ABIs:    spec.SupportedAbis,
+		Density: spec.ScreenDensity,
+		Locales: spec.SupportedLocales,
+		SDK:     spec.SdkVersion,
+	}, nil
+}
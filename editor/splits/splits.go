@@ -0,0 +1,294 @@
+// Package splits opens split APK containers: the APK sets written by `bundletool build-apks`
+// (.apks) and the .xapk and .apkm archives of APKPure and APKMirror. It selects the splits a
+// device needs, extracts them for `adb install-multiple`, re-signs every APK of a set and writes
+// the set back in its original format.
+package splits
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pzx521521/apk-editor/editor/axml"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+// Format is the kind of container a Set was read from.
+type Format int
+
+const (
+	APKS Format = iota // bundletool build-apks 的输出, 含 toc.pb
+	XAPK               // APKPure, 含 manifest.json
+	APKM               // APKMirror, 含 info.json
+)
+
+func (f Format) String() string {
+	switch f {
+	case APKS:
+		return "apks"
+	case XAPK:
+		return "xapk"
+	case APKM:
+		return "apkm"
+	}
+	return "Format(" + strconv.Itoa(int(f)) + ")"
+}
+
+// ErrNoAPK is returned by Open for a zip that holds no APK.
+var ErrNoAPK = errors.New("no apk in split set")
+
+// Split is one APK of a set.
+type Split struct {
+	Path      string // 在容器中的路径
+	Name      string // manifest 的 split 属性, base 为空
+	Package   string
+	ConfigFor string // 配置 split 所属的模块 (configForSplit), base 的配置 split 为空
+	Feature   bool   // android:isFeatureSplit
+	MinSDK    int
+	// VersionCode is the android:versionCode of the manifest, which every APK of a set shares.
+	VersionCode uint32
+	// Standalone is set for the standalone and universal APKs of an APK set, which hold every
+	// module and are installed alone on devices that do not support splits.
+	Standalone bool
+	Data       []byte
+}
+
+// IsBase reports whether s is the base APK of the split set.
+func (s *Split) IsBase() bool {
+	return s.Name == "" && !s.Standalone
+}
+
+// Config returns the qualifier of a configuration split, such as "arm64_v8a", "xxhdpi" or "en",
+// and "" for other splits.
+func (s *Split) Config() string {
+	if config, ok := strings.CutPrefix(s.Name, "config."); ok {
+		return config
+	}
+	_, config, _ := strings.Cut(s.Name, ".config.")
+	return config
+}
+
+// Module returns the name of the module the split belongs to: "base", or the name of a feature
+// module.
+func (s *Split) Module() string {
+	if s.ConfigFor != "" {
+		return s.ConfigFor
+	}
+	if s.Name == "" || strings.HasPrefix(s.Name, "config.") {
+		return "base"
+	}
+	module, _, _ := strings.Cut(s.Name, ".config.")
+	return module
+}
+
+// Set is an opened split APK container.
+type Set struct {
+	Format  Format
+	Package string
+	Splits  []*Split
+	r       *zip.Reader
+	// metadata holds the container metadata files changed since Open, by name.
+	metadata map[string][]byte
+}
+
+// Open parses a split APK container. The format is detected from the metadata file of the
+// container; a zip of APKs without any is read as an APK set.
+func Open(b []byte) (*Set, error) {
+	r, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return nil, err
+	}
+	s := &Set{r: r}
+	for _, f := range r.File {
+		switch f.Name {
+		case "manifest.json":
+			s.Format = XAPK
+		case "info.json":
+			s.Format = APKM
+		}
+	}
+	for _, f := range r.File {
+		if !strings.HasSuffix(f.Name, ".apk") || strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		split, err := readSplit(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		split.Standalone = s.Format == APKS && split.Name == "" &&
+			(f.Name == "universal.apk" || strings.HasPrefix(f.Name, "standalones/"))
+		if s.Package == "" {
+			s.Package = split.Package
+		}
+		s.Splits = append(s.Splits, split)
+	}
+	if len(s.Splits) == 0 {
+		return nil, ErrNoAPK
+	}
+	return s, nil
+}
+
+// OpenFile reads and parses the split APK container name.
+func OpenFile(name string) (*Set, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return Open(b)
+}
+
+func readSplit(f *zip.File) (*Split, error) {
+	data, err := readEntry(f)
+	if err != nil {
+		return nil, err
+	}
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	var manifest *axml.Element
+	for _, af := range r.File {
+		if af.Name != zip.ANDROIDMANIFEST {
+			continue
+		}
+		b, err := readEntry(af)
+		if err == nil {
+			manifest, err = axml.Parse(b)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if manifest == nil {
+		return nil, errors.New("missing " + zip.ANDROIDMANIFEST)
+	}
+	s := &Split{
+		Path:      f.Name,
+		Name:      manifest.AttrValue("", "split"),
+		Package:   manifest.AttrValue("", "package"),
+		ConfigFor: manifest.AttrValue("", "configForSplit"),
+		Feature:   manifest.AttrValue(axml.NSAndroid, "isFeatureSplit") == "true",
+		Data:      data,
+	}
+	if a := manifest.Attr(axml.NSAndroid, "versionCode"); a != nil && a.Type != axml.TypeString {
+		s.VersionCode = a.Data
+	}
+	manifest.Walk(func(e *axml.Element) {
+		if e.Name == "uses-sdk" {
+			s.MinSDK, _ = strconv.Atoi(e.AttrValue(axml.NSAndroid, "minSdkVersion"))
+		}
+	})
+	return s, nil
+}
+
+// Base returns the base APK of the set, or nil if it only holds standalone APKs.
+func (s *Set) Base() *Split {
+	for _, split := range s.Splits {
+		if split.IsBase() {
+			return split
+		}
+	}
+	return nil
+}
+
+// Sign re-signs every APK of the set with keys, which all APKs of an app must share.
+func (s *Set) Sign(keys []*signv2.SigningCert, opts ...signv2.Option) error {
+	return s.SignContext(context.Background(), keys, opts...)
+}
+
+// SignContext is Sign with a context that can cancel the signing.
+func (s *Set) SignContext(ctx context.Context, keys []*signv2.SigningCert, opts ...signv2.Option) error {
+	for _, split := range s.Splits {
+		z, err := signv2.NewApkSignNoCopy(split.Data, opts...)
+		if err != nil {
+			return fmt.Errorf("%s: %w", split.Path, err)
+		}
+		signed, err := z.SignV2Context(ctx, keys, opts...)
+		if err != nil {
+			return fmt.Errorf("%s: %w", split.Path, err)
+		}
+		split.Data = signed
+	}
+	return nil
+}
+
+// WriteTo writes the set in its original format: the APKs, changed or not, in place of the
+// original ones, and every other entry, such as toc.pb, manifest.json or the OBB files of an
+// .xapk, copied as is unless BumpVersion updated it.
+func (s *Set) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: w}
+	zw := zip.NewWriter(cw)
+	splits := map[string]*Split{}
+	for _, split := range s.Splits {
+		splits[split.Path] = split
+	}
+	for _, f := range s.r.File {
+		split := splits[f.Name]
+		if b, ok := s.metadata[f.Name]; ok {
+			fh := f.FileHeader
+			fw, err := zw.CreateHeader(&fh)
+			if err == nil {
+				_, err = fw.Write(b)
+			}
+			if err != nil {
+				return cw.n, err
+			}
+			continue
+		}
+		if split == nil {
+			if err := zw.Copy(f); err != nil {
+				return cw.n, err
+			}
+			continue
+		}
+		fh := f.FileHeader
+		fw, err := zw.CreateHeader(&fh)
+		if err == nil {
+			_, err = fw.Write(split.Data)
+		}
+		if err != nil {
+			return cw.n, err
+		}
+	}
+	err := zw.Close()
+	return cw.n, err
+}
+
+// Extract writes splits, or every APK of the set if none are given, to dir and returns the paths
+// of the files, ready for `adb install-multiple`.
+func (s *Set) Extract(dir string, splits ...*Split) ([]string, error) {
+	if len(splits) == 0 {
+		splits = s.Splits
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, split := range splits {
+		name := filepath.Join(dir, path.Base(split.Path))
+		if err := os.WriteFile(name, split.Data, 0644); err != nil {
+			return nil, err
+		}
+		paths = append(paths, name)
+	}
+	return paths, nil
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,216 @@
+package splits
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/pzx521521/apk-editor/editor/axml"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+// testAPK returns an unsigned APK whose manifest declares split, and configForSplit if set, with
+// files.
+func testAPK(t *testing.T, split, configFor string, files ...entry) []byte {
+	manifest := &axml.Element{
+		Name:       "manifest",
+		Namespaces: []axml.Namespace{{Prefix: "android", URI: axml.NSAndroid}},
+		Attrs:      []*axml.Attr{{Name: "package", Raw: "com.example", Type: axml.TypeString}},
+	}
+	if split != "" {
+		manifest.Attrs = append(manifest.Attrs, &axml.Attr{Name: "split", Raw: split, Type: axml.TypeString})
+	}
+	if configFor != "" {
+		manifest.Attrs = append(manifest.Attrs, &axml.Attr{Name: "configForSplit", Raw: configFor, Type: axml.TypeString})
+	}
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	fw, err := w.Create(zip.ANDROIDMANIFEST)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(axml.Encode(manifest))
+	for _, f := range files {
+		fw, err := w.CreateHeader(&zip.FileHeader{Name: f.name, Method: f.method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(f.data)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+type entry struct {
+	name   string
+	data   []byte
+	method uint16
+}
+
+func testZip(t *testing.T, entries ...entry) []byte {
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	for _, e := range entries {
+		fw, err := w.CreateHeader(&zip.FileHeader{Name: e.name, Method: e.method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(e.data)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testAPKS(t *testing.T) []byte {
+	return testZip(t,
+		entry{"toc.pb", []byte{0x0a, 0x00}, zip.Deflate},
+		entry{"splits/base-master.apk", testAPK(t, "", ""), zip.Store},
+		entry{"splits/base-arm64_v8a.apk", testAPK(t, "config.arm64_v8a", ""), zip.Store},
+		entry{"splits/base-armeabi_v7a.apk", testAPK(t, "config.armeabi_v7a", ""), zip.Store},
+		entry{"splits/base-xhdpi.apk", testAPK(t, "config.xhdpi", ""), zip.Store},
+		entry{"splits/base-xxhdpi.apk", testAPK(t, "config.xxhdpi", ""), zip.Store},
+		entry{"splits/base-xxxhdpi.apk", testAPK(t, "config.xxxhdpi", ""), zip.Store},
+		entry{"splits/base-en.apk", testAPK(t, "config.en", ""), zip.Store},
+		entry{"splits/base-fr.apk", testAPK(t, "config.fr", ""), zip.Store},
+		entry{"splits/camera-master.apk", testAPK(t, "camera", ""), zip.Store},
+		entry{"splits/camera-fr.apk", testAPK(t, "camera.config.fr", "camera"), zip.Store},
+		entry{"standalones/standalone-arm64_v8a_xxhdpi.apk", testAPK(t, "", ""), zip.Store},
+	)
+}
+
+func testKeys(t *testing.T) []*signv2.SigningCert {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []*signv2.SigningCert{{
+		SigningKey: signv2.SigningKey{
+			KeyBytes: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+			Type:     signv2.RSA,
+			Hash:     signv2.SHA256,
+		},
+		CertBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}}
+}
+
+func paths(splits []*Split) []string {
+	var p []string
+	for _, s := range splits {
+		p = append(p, s.Path)
+	}
+	slices.Sort(p)
+	return p
+}
+
+func TestOpenFormats(t *testing.T) {
+	for _, tt := range []struct {
+		meta   string
+		format Format
+	}{
+		{"toc.pb", APKS},
+		{"manifest.json", XAPK},
+		{"info.json", APKM},
+	} {
+		s, err := Open(testZip(t, entry{tt.meta, []byte("{}"), zip.Deflate}, entry{"base.apk", testAPK(t, "", ""), zip.Store}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Format != tt.format || s.Package != "com.example" || s.Base() == nil {
+			t.Errorf("%s: format %v, package %q, base %v", tt.meta, s.Format, s.Package, s.Base())
+		}
+	}
+	if _, err := Open(testZip(t, entry{"manifest.json", []byte("{}"), zip.Deflate})); !errors.Is(err, ErrNoAPK) {
+		t.Errorf("Open without apk = %v, want %v", err, ErrNoAPK)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	s, err := Open(testAPKS(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		spec DeviceSpec
+		want []string
+	}{
+		{
+			DeviceSpec{ABIs: []string{"arm64-v8a", "armeabi-v7a"}, Density: 420, Locales: []string{"fr-FR"}, SDK: 33},
+			[]string{"splits/base-arm64_v8a.apk", "splits/base-fr.apk", "splits/base-master.apk",
+				"splits/base-xxhdpi.apk", "splits/camera-fr.apk", "splits/camera-master.apk"},
+		},
+		{
+			DeviceSpec{ABIs: []string{"x86", "armeabi-v7a"}, Density: 800, Locales: []string{"de"}},
+			[]string{"splits/base-armeabi_v7a.apk", "splits/base-master.apk", "splits/base-xxxhdpi.apk",
+				"splits/camera-master.apk"},
+		},
+	} {
+		if got := paths(s.Select(tt.spec)); !slices.Equal(got, tt.want) {
+			t.Errorf("Select(%+v) = %q, want %q", tt.spec, got, tt.want)
+		}
+	}
+	if got := s.Select(DeviceSpec{}); len(got) != 10 {
+		t.Errorf("Select with an empty spec returned %d splits, want every split but the standalone", len(got))
+	}
+}
+
+func TestSignWriteTo(t *testing.T) {
+	s, err := Open(testAPKS(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Sign(testKeys(t)); err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if _, err = s.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	repacked, err := Open(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repacked.Format != APKS || len(repacked.Splits) != len(s.Splits) {
+		t.Fatalf("repacked %v set with %d splits", repacked.Format, len(repacked.Splits))
+	}
+	for _, split := range repacked.Splits {
+		z, err := signv2.NewApkSign(split.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = z.VerifyV2(); err != nil {
+			t.Errorf("%s: %v", split.Path, err)
+		}
+	}
+
+	files, err := repacked.Extract(t.TempDir(), repacked.Base())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(files[0]); err != nil || !bytes.Equal(b, repacked.Base().Data) {
+		t.Errorf("extracted %s: %v", files[0], err)
+	}
+}
@@ -0,0 +1,138 @@
+package splits
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pzx521521/apk-editor/editor/axml"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+// Resource IDs of the manifest attributes BumpVersion sets.
+const (
+	attrVersionCode = 0x0101021b
+	attrVersionName = 0x0101021c
+)
+
+// BumpVersion sets the versionCode, and the versionName unless name is empty, of every APK of
+// the set, since Android refuses to install splits whose versionCode differs from the base's, and
+// re-signs them with keys. The versionName is added to the base and to APKs that already declare
+// one. The version fields of the container metadata, manifest.json of an .xapk or info.json of
+// an .apkm, are updated to match.
+func (s *Set) BumpVersion(code uint32, name string, keys []*signv2.SigningCert, opts ...signv2.Option) error {
+	return s.BumpVersionContext(context.Background(), code, name, keys, opts...)
+}
+
+// BumpVersionContext is BumpVersion with a context that can cancel the signing.
+func (s *Set) BumpVersionContext(ctx context.Context, code uint32, name string, keys []*signv2.SigningCert, opts ...signv2.Option) error {
+	for _, split := range s.Splits {
+		data, err := setVersion(split.Data, code, name, split.IsBase() || split.Standalone)
+		if err != nil {
+			return fmt.Errorf("%s: %w", split.Path, err)
+		}
+		split.Data = data
+		split.VersionCode = code
+	}
+	for _, f := range s.r.File {
+		if f.Name != "manifest.json" && f.Name != "info.json" {
+			continue
+		}
+		b, err := readEntry(f)
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+		if b, err = setMetadataVersion(b, code, name); err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+		if s.metadata == nil {
+			s.metadata = map[string][]byte{}
+		}
+		s.metadata[f.Name] = b
+	}
+	return s.SignContext(ctx, keys, opts...)
+}
+
+// setVersion rewrites the manifest of apk with versionCode code and, if name is set, versionName
+// name; addName adds the versionName if the manifest has none. The result is unsigned.
+func setVersion(apk []byte, code uint32, name string, addName bool) ([]byte, error) {
+	r, err := zip.NewReader(bytes.NewReader(apk), int64(len(apk)))
+	if err != nil {
+		return nil, err
+	}
+	var manifest *axml.Element
+	for _, f := range r.File {
+		if f.Name != zip.ANDROIDMANIFEST {
+			continue
+		}
+		b, err := readEntry(f)
+		if err == nil {
+			manifest, err = axml.Parse(b)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if manifest == nil {
+		return nil, errors.New("missing " + zip.ANDROIDMANIFEST)
+	}
+	if a := manifest.Attr(axml.NSAndroid, "versionCode"); a != nil {
+		a.Type, a.Data, a.Raw = axml.TypeIntDec, code, ""
+	} else {
+		manifest.Attrs = append(manifest.Attrs, &axml.Attr{
+			NS: axml.NSAndroid, Name: "versionCode", ResID: attrVersionCode, Type: axml.TypeIntDec, Data: code})
+	}
+	if name != "" {
+		if a := manifest.Attr(axml.NSAndroid, "versionName"); a != nil {
+			a.Type, a.Raw = axml.TypeString, name
+		} else if addName {
+			manifest.Attrs = append(manifest.Attrs, &axml.Attr{
+				NS: axml.NSAndroid, Name: "versionName", ResID: attrVersionName, Type: axml.TypeString, Raw: name})
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	buf.Write(apk[:r.AppendOffset()])
+	w := r.Append(buf, true)
+	fw, err := w.CreateHeader(&zip.FileHeader{Name: zip.ANDROIDMANIFEST, Method: zip.Deflate})
+	if err != nil {
+		return nil, err
+	}
+	if _, err = fw.Write(axml.Encode(manifest)); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// setMetadataVersion updates the version fields of a JSON metadata file: version_code and
+// version_name in manifest.json, versionCode and versionName in info.json. Fields keep their JSON
+// type, since the formats store the code as a string or a number.
+func setMetadataVersion(b []byte, code uint32, name string) ([]byte, error) {
+	var m map[string]any
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	for k, v := range m {
+		switch strings.ToLower(strings.ReplaceAll(k, "_", "")) {
+		case "versioncode":
+			if _, ok := v.(string); ok {
+				m[k] = strconv.FormatUint(uint64(code), 10)
+			} else {
+				m[k] = code
+			}
+		case "versionname":
+			if name != "" {
+				m[k] = name
+			}
+		}
+	}
+	return json.MarshalIndent(m, "", "  ")
+}
@@ -0,0 +1,76 @@
+package splits
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/pzx521521/apk-editor/editor/axml"
+	"github.com/pzx521521/apk-editor/editor/signv2"
+	"github.com/pzx521521/apk-editor/editor/zip"
+)
+
+func TestBumpVersion(t *testing.T) {
+	s, err := Open(testZip(t,
+		entry{"manifest.json", []byte(`{"package_name":"com.example","version_code":"1","version_name":"1.0","min_sdk_version":"21"}`), zip.Deflate},
+		entry{"com.example.apk", testAPK(t, "", ""), zip.Store},
+		entry{"config.arm64_v8a.apk", testAPK(t, "config.arm64_v8a", ""), zip.Store},
+		entry{"config.en.apk", testAPK(t, "config.en", ""), zip.Store},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.BumpVersion(42, "4.2", testKeys(t)); err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if _, err = s.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	repacked, err := Open(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, split := range repacked.Splits {
+		if split.VersionCode != 42 {
+			t.Errorf("%s: versionCode %d", split.Path, split.VersionCode)
+		}
+		z, err := signv2.NewApkSign(split.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = z.VerifyV2(); err != nil {
+			t.Errorf("%s: %v", split.Path, err)
+		}
+		r, _ := zip.NewReader(bytes.NewReader(split.Data), int64(len(split.Data)))
+		for _, f := range r.File {
+			if f.Name != zip.ANDROIDMANIFEST {
+				continue
+			}
+			b, _ := readEntry(f)
+			m, err := axml.Parse(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// 只有 base 添加 versionName, split 属性保持不变
+			if got, want := m.AttrValue(axml.NSAndroid, "versionName"), map[bool]string{true: "4.2"}[split.IsBase()]; got != want {
+				t.Errorf("%s: versionName %q, want %q", split.Path, got, want)
+			}
+			if m.AttrValue("", "split") != split.Name {
+				t.Errorf("%s: split %q", split.Path, m.AttrValue("", "split"))
+			}
+		}
+	}
+	for _, f := range repacked.r.File {
+		if f.Name == "manifest.json" {
+			b, _ := readEntry(f)
+			var meta map[string]any
+			if err = json.Unmarshal(b, &meta); err != nil {
+				t.Fatal(err)
+			}
+			if meta["version_code"] != "42" || meta["version_name"] != "4.2" || meta["min_sdk_version"] != "21" {
+				t.Errorf("manifest.json %s", b)
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// GCS is a BlobStore of the objects of a Google Cloud Storage bucket, accessed through the JSON API
+// with an OAuth 2.0 access token, e.g. the output of "gcloud auth print-access-token".
+type GCS struct {
+	Bucket   string
+	Token    string       // OAuth 2.0 access token
+	Endpoint string       // API 地址, 为空时使用 https://storage.googleapis.com, 可指向模拟器
+	Client   *http.Client // 为 nil 时使用 http.DefaultClient
+}
+
+// GCSFromEnv returns the store of bucket with the access token in GOOGLE_OAUTH_ACCESS_TOKEN, and the
+// emulator in STORAGE_EMULATOR_HOST if set.
+func GCSFromEnv(bucket string) (*GCS, error) {
+	g := &GCS{Bucket: bucket, Token: os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN")}
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		if !strings.Contains(host, "://") {
+			host = "http://" + host
+		}
+		g.Endpoint = host
+	}
+	if g.Token == "" && g.Endpoint == "" {
+		return nil, errors.New("gcs: GOOGLE_OAUTH_ACCESS_TOKEN must be set")
+	}
+	return g, nil
+}
+
+func (g *GCS) endpoint() string {
+	if g.Endpoint == "" {
+		return "https://storage.googleapis.com"
+	}
+	return strings.TrimSuffix(g.Endpoint, "/")
+}
+
+func (g *GCS) do(req *http.Request) (*http.Response, error) {
+	if g.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+g.Token)
+	}
+	c := g.Client
+	if c == nil {
+		c = http.DefaultClient
+	}
+	return c.Do(req)
+}
+
+func (g *GCS) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	u := g.endpoint() + "/storage/v1/b/" + url.PathEscape(g.Bucket) + "/o/" + url.PathEscape(key) + "?alt=media"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	return openResponse(g.do(req))
+}
+
+// Put uploads r in a single request (uploadType=media).
+func (g *GCS) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	u := g.endpoint() + "/upload/storage/v1/b/" + url.PathEscape(g.Bucket) + "/o?uploadType=media&name=" + url.QueryEscape(key)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/vnd.android.package-archive")
+	return putResponse(g.do(req))
+}
@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// HTTP is a BlobStore whose keys are http(s) URLs, read with GET and written with PUT, e.g. S3 or
+// GCS pre-signed URLs.
+type HTTP struct {
+	Client *http.Client // 为 nil 时使用 http.DefaultClient
+	Header http.Header  // 每个请求附加的头, 如 Authorization
+}
+
+func (h *HTTP) do(req *http.Request) (*http.Response, error) {
+	for k, v := range h.Header {
+		req.Header[k] = v
+	}
+	c := h.Client
+	if c == nil {
+		c = http.DefaultClient
+	}
+	return c.Do(req)
+}
+
+func (h *HTTP) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	return openResponse(h.do(req))
+}
+
+func (h *HTTP) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, key, r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/vnd.android.package-archive")
+	return putResponse(h.do(req))
+}
+
+// openResponse returns the body of a successful GET.
+func openResponse(resp *http.Response, err error) (io.ReadCloser, error) {
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, errStatus("GET", resp.Request.URL.Redacted(), resp.StatusCode, resp.Body)
+	}
+	return resp.Body, nil
+}
+
+// putResponse checks the response of an upload.
+func putResponse(resp *http.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errStatus(resp.Request.Method, resp.Request.URL.Redacted(), resp.StatusCode, resp.Body)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+// Package storage reads and writes APKs in local directories and remote object stores (S3, Google
+// Cloud Storage, plain HTTP), so that the CLI and the service can sign an object in place without
+// staging it on local disk. Objects are named by URLs: s3://bucket/key, gs://bucket/object,
+// http(s)://host/path, file:///path or a plain path.
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BlobStore reads and writes whole objects by key. Implementations must be safe for concurrent use.
+type BlobStore interface {
+	// Open returns the content of the object key; it fails with an error matching os.ErrNotExist
+	// if there is no such object.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Put stores the content read from r as the object key, replacing any previous one. size is the
+	// length of the content, or -1 if unknown.
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+}
+
+// IsURL reports whether name is an object URL rather than a local path.
+func IsURL(name string) bool {
+	return strings.Contains(name, "://")
+}
+
+// IsRemote reports whether name is the URL of an object outside the local file system.
+func IsRemote(name string) bool {
+	return IsURL(name) && !strings.HasPrefix(name, "file://")
+}
+
+// ForURL returns the store holding the object rawURL and its key in that store. S3 and GCS
+// credentials are taken from the environment, see S3FromEnv and GCSFromEnv.
+func ForURL(rawURL string) (BlobStore, string, error) {
+	if !IsURL(rawURL) {
+		return Dir(""), rawURL, nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, "", err
+	}
+	switch u.Scheme {
+	case "file":
+		return Dir(""), filepath.FromSlash(u.Path), nil
+	case "http", "https":
+		return &HTTP{}, rawURL, nil
+	case "s3":
+		s, err := S3FromEnv(u.Host)
+		return s, strings.TrimPrefix(u.Path, "/"), err
+	case "gs":
+		s, err := GCSFromEnv(u.Host)
+		return s, strings.TrimPrefix(u.Path, "/"), err
+	}
+	return nil, "", fmt.Errorf("unsupported storage scheme %q", u.Scheme)
+}
+
+// ReadURL returns the content of the object rawURL.
+func ReadURL(ctx context.Context, rawURL string) ([]byte, error) {
+	s, key, err := ForURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := s.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// WriteURL stores data as the object rawURL.
+func WriteURL(ctx context.Context, rawURL string, data []byte) error {
+	s, key, err := ForURL(rawURL)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, key, bytes.NewReader(data), int64(len(data)))
+}
+
+// Dir is a BlobStore of the files under a local directory; keys are slash separated paths relative
+// to it. The empty Dir resolves keys against the working directory, and also accepts absolute paths.
+type Dir string
+
+func (d Dir) path(key string) (string, error) {
+	if d == "" {
+		return key, nil
+	}
+	if !filepath.IsLocal(filepath.FromSlash(key)) {
+		return "", fmt.Errorf("key %q is outside the store", key)
+	}
+	return filepath.Join(string(d), filepath.FromSlash(key)), nil
+}
+
+func (d Dir) Open(_ context.Context, key string) (io.ReadCloser, error) {
+	p, err := d.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+// Put writes a temporary file next to the target and renames it, so readers never see a partial
+// object.
+func (d Dir) Put(_ context.Context, key string, r io.Reader, size int64) error {
+	p, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), filepath.Base(p)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	n, err := io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && size >= 0 && n != size {
+		err = fmt.Errorf("wrote %d bytes, want %d", n, size)
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+// errStatus turns an unexpected HTTP response of a remote store into an error.
+func errStatus(op, key string, code int, body io.Reader) error {
+	if code == 404 {
+		return fmt.Errorf("%s %s: %w", op, key, os.ErrNotExist)
+	}
+	msg, _ := io.ReadAll(io.LimitReader(body, 512))
+	return fmt.Errorf("%s %s: HTTP %d: %s", op, key, code, strings.TrimSpace(string(msg)))
+}
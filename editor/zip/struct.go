@@ -91,6 +91,8 @@ type FileHeader struct {
 	Extra              []byte
 	ExternalAttrs      uint32 // Meaning depends on CreatorVersion
 	Comment            string
+
+	internalAttrs uint16 // kept from the central directory of a Reader, so that Copy preserves it
 }
 
 // FileInfo returns an os.FileInfo for the FileHeader.
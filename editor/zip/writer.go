@@ -108,6 +108,7 @@ func (w *Writer) Close() error {
 		b.uint16(h.ModifiedTime)
 		b.uint16(h.ModifiedDate)
 		b.uint32(h.CRC32)
+		extra := h.Extra
 		if h.isZip64() || h.offset >= uint32max {
 			// the file needs a zip64 header. store maxint in both
 			// 32 bit size fields (and offset later) to signal that the
@@ -123,15 +124,17 @@ func (w *Writer) Close() error {
 			eb.uint64(h.UncompressedSize64)
 			eb.uint64(h.CompressedSize64)
 			eb.uint64(h.offset)
-			h.Extra = append(h.Extra, buf[:]...)
+			// a copied entry has the zip64 record of its old offset, which this one replaces
+			extra = append(withoutZip64(h.Extra), buf[:]...)
 		} else {
 			b.uint32(h.CompressedSize)
 			b.uint32(h.UncompressedSize)
 		}
 		b.uint16(uint16(len(h.Name)))
-		b.uint16(uint16(len(h.Extra)))
+		b.uint16(uint16(len(extra)))
 		b.uint16(uint16(len(h.Comment)))
-		b = b[4:] // skip disk number start and internal file attr (2x uint16)
+		b = b[2:] // skip disk number start
+		b.uint16(h.internalAttrs)
 		b.uint32(h.ExternalAttrs)
 		if h.offset > uint32max {
 			b.uint32(uint32max)
@@ -144,7 +147,7 @@ func (w *Writer) Close() error {
 		if _, err := io.WriteString(w.cw, h.Name); err != nil {
 			return err
 		}
-		if _, err := w.cw.Write(h.Extra); err != nil {
+		if _, err := w.cw.Write(extra); err != nil {
 			return err
 		}
 		if _, err := io.WriteString(w.cw, h.Comment); err != nil {
@@ -289,6 +292,20 @@ func (w *Writer) CreateHeader(fh *FileHeader) (io.Writer, error) {
 	return fw, nil
 }
 
+// CreateAlignedHeader is like CreateHeader but pads the extra field of fh so
+// that the file data starts at a multiple of align bytes, as zipalign does for
+// uncompressed entries.
+func (w *Writer) CreateAlignedHeader(fh *FileHeader, align int) (io.Writer, error) {
+	if err := w.closeLastWriter(); err != nil {
+		return nil, err
+	}
+	off := w.cw.count + fileHeaderLen + int64(len(fh.Name)+len(fh.Extra))
+	if pad := (int64(align) - off%int64(align)) % int64(align); pad > 0 {
+		fh.Extra = append(fh.Extra, make([]byte, pad)...)
+	}
+	return w.CreateHeader(fh)
+}
+
 func (w *Writer) PaddingHeader(fh *FileHeader) {
 	var alignment = 4
 	var padlen int
@@ -312,35 +329,134 @@ func (w *Writer) PaddingHeader(fh *FileHeader) {
 	}
 }
 
-// Copy copies the file f (obtained from a Reader) into w.
-// It copies the compressed form directly.
+// Copy copies the file f (obtained from a Reader) into w as it is stored: its local header,
+// compressed data and data descriptor are copied byte for byte, so that its timestamps, extra
+// fields, versions, flags and compression are kept. Its central directory record is that of f,
+// with the new offset.
 func (w *Writer) Copy(f *File) error {
-	dataOffset, err := f.DataOffset()
-	if err != nil {
-		return err
+	return w.copyRaw(f, 0)
+}
+
+// CopyAligned is like Copy but, for an uncompressed entry whose data would not start at a multiple
+// of align bytes in w, replaces the padding at the end of the extra field of the local header so
+// that it does. Compressed entries, and aligned ones, are copied as is.
+func (w *Writer) CopyAligned(f *File, align int) error {
+	if f.Method != Store {
+		align = 0
 	}
+	return w.copyRaw(f, align)
+}
+
+// copyRaw implements Copy and CopyAligned.
+func (w *Writer) copyRaw(f *File, align int) error {
 	if err := w.closeLastWriter(); err != nil {
 		return err
 	}
-
-	fh := f.FileHeader
-	h := &header{
-		FileHeader: &fh,
-		offset:     uint64(w.cw.count),
+	var buf [fileHeaderLen]byte
+	if _, err := f.zipr.ReadAt(buf[:], f.headerOffset); err != nil {
+		return err
 	}
-	fh.Flags |= 0x8 // we will write a data descriptor
-	w.dir = append(w.dir, h)
-	//w.PaddingHeader(&fh)
-	if err := writeHeader(w.cw, &fh); err != nil {
+	if binary.LittleEndian.Uint32(buf[:]) != fileHeaderSignature {
+		return ErrFormat
+	}
+	nameLen, extraLen := int(binary.LittleEndian.Uint16(buf[26:])), int(binary.LittleEndian.Uint16(buf[28:]))
+	name := make([]byte, nameLen+extraLen)
+	if _, err := f.zipr.ReadAt(name, f.headerOffset+fileHeaderLen); err != nil {
 		return err
 	}
+	name, extra := name[:nameLen], name[nameLen:]
+	dataOffset := f.headerOffset + fileHeaderLen + int64(nameLen+extraLen)
+
+	offset := w.cw.count
+	if off := offset + fileHeaderLen + int64(nameLen+extraLen); align > 1 && off%int64(align) != 0 {
+		extra = trimPadding(extra)
+		off = offset + fileHeaderLen + int64(nameLen+len(extra))
+		extra = append(extra, make([]byte, (int64(align)-off%int64(align))%int64(align))...)
+		if len(extra) > uint16max {
+			return errors.New("zip: extra field too long to align " + f.Name)
+		}
+		binary.LittleEndian.PutUint16(buf[28:], uint16(len(extra)))
+	}
+	for _, b := range [][]byte{buf[:], name, extra} {
+		if _, err := w.cw.Write(b); err != nil {
+			return err
+		}
+	}
 
-	r := io.NewSectionReader(f.zipr, dataOffset, int64(f.CompressedSize64))
-	if _, err := io.Copy(w.cw, r); err != nil {
+	size := int64(f.CompressedSize64)
+	if f.hasDataDescriptor() {
+		n, err := f.dataDescriptorSize(dataOffset+size, extra)
+		if err != nil {
+			return err
+		}
+		size += n
+	}
+	if _, err := io.Copy(w.cw, io.NewSectionReader(f.zipr, dataOffset, size)); err != nil {
 		return err
 	}
+	fh := f.FileHeader
+	w.dir = append(w.dir, &header{FileHeader: &fh, offset: uint64(offset)})
+	return nil
+}
 
-	return writeDesc(w.cw, &fh)
+// dataDescriptorSize returns the size of the data descriptor of f at offset: with or without its
+// optional signature, and with 64-bit sizes for a zip64 entry, whose local extra field has a zip64
+// record.
+func (f *File) dataDescriptorSize(offset int64, extra []byte) (int64, error) {
+	var sig [4]byte
+	if _, err := f.zipr.ReadAt(sig[:], offset); err != nil {
+		return 0, err
+	}
+	n := int64(12)
+	if binary.LittleEndian.Uint32(sig[:]) == dataDescriptorSignature {
+		n += 4
+	}
+	zip64 := f.CompressedSize64 >= uint32max || f.UncompressedSize64 >= uint32max
+	for b := extra; !zip64 && len(b) >= 4; {
+		id, size := binary.LittleEndian.Uint16(b), 4+int(binary.LittleEndian.Uint16(b[2:]))
+		if size > len(b) {
+			break
+		}
+		zip64 = id == zip64ExtraId
+		b = b[size:]
+	}
+	if zip64 {
+		n += 8
+	}
+	return n, nil
+}
+
+// withoutZip64 returns a copy of the extra records of extra other than the zip64 one.
+func withoutZip64(extra []byte) []byte {
+	var out []byte
+	for b := extra; len(b) >= 4; {
+		id, size := binary.LittleEndian.Uint16(b), 4+int(binary.LittleEndian.Uint16(b[2:]))
+		if size > len(b) {
+			return append(out, b...)
+		}
+		if id != zip64ExtraId {
+			out = append(out, b[:size]...)
+		}
+		b = b[size:]
+	}
+	return out
+}
+
+// trimPadding returns the well-formed extra records of extra, without the padding added by
+// zipalign: trailing zeros or bytes that are not a record, and the 0xd935 alignment record.
+func trimPadding(extra []byte) []byte {
+	var out []byte
+	for b := extra; len(b) >= 4; {
+		id, size := binary.LittleEndian.Uint16(b), 4+int(binary.LittleEndian.Uint16(b[2:]))
+		if id == 0 || size > len(b) {
+			break
+		}
+		if id != 0xd935 {
+			out = append(out, b[:size]...)
+		}
+		b = b[size:]
+	}
+	return out
 }
 
 func writeHeader(w io.Writer, h *FileHeader) error {
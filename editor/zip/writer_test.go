@@ -12,6 +12,7 @@ import (
 	"log"
 	"math/rand"
 	"os"
+	"reflect"
 	"testing"
 )
 
@@ -299,3 +300,119 @@ func BenchmarkCompressedZipGarbage(b *testing.B) {
 		zw.Close()
 	}
 }
+
+func TestCopyAligned(t *testing.T) {
+	src := new(bytes.Buffer)
+	w := NewWriter(src)
+	for _, name := range []string{"a", "stored.png"} {
+		fh := &FileHeader{Name: name, Method: Store}
+		fw, err := w.CreateAlignedHeader(fh, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte(name))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(bytes.NewReader(src.Bytes()), int64(src.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// dropping the first entry moves the second, which keeps its data but is aligned anew
+	dst := new(bytes.Buffer)
+	w = NewWriter(dst)
+	if err = w.CopyAligned(r.File[1], 16384); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err = NewReader(bytes.NewReader(dst.Bytes()), int64(dst.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	off, err := r.File[0].DataOffset()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if off%16384 != 0 {
+		t.Errorf("data offset %d is not aligned", off)
+	}
+	rc, err := r.File[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(rc)
+	if err != nil || string(b) != "stored.png" {
+		t.Errorf("read %q, %v", b, err)
+	}
+
+	extra := []byte{1, 0, 2, 0, 'x', 'y', 0x35, 0xd9, 2, 0, 4, 0, 0, 0, 0, 0}
+	if got := trimPadding(extra); !bytes.Equal(got, extra[:6]) {
+		t.Errorf("trimPadding = %v", got)
+	}
+}
+
+func TestCopyPreservesHeaders(t *testing.T) {
+	src := new(bytes.Buffer)
+	w := NewWriter(src)
+	for _, fh := range []*FileHeader{
+		{Name: "a.txt", Method: Deflate, ModifiedTime: 0x6c21, ModifiedDate: 0x4a5e, Extra: []byte{0xfe, 0xca, 2, 0, 'h', 'i'}, Comment: "note"},
+		{Name: "b.png", Method: Store, ModifiedTime: 0x1234, ModifiedDate: 0x3a21},
+	} {
+		fw, err := w.CreateHeader(fh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(bytes.Repeat([]byte(fh.Name), 100))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(bytes.NewReader(src.Bytes()), int64(src.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a new first entry moves the copies
+	dst := new(bytes.Buffer)
+	w = NewWriter(dst)
+	fw, err := w.Create("new.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write([]byte("new"))
+	w.Copy(r.File[0])
+	w.Copy(r.File[1])
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	out, err := NewReader(bytes.NewReader(dst.Bytes()), int64(dst.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// raw returns the local header, data and data descriptor of each entry of z
+	raw := func(z *Reader, b []byte) [][]byte {
+		var entries [][]byte
+		for i, f := range z.File {
+			end := z.AppendOffset()
+			if i+1 < len(z.File) {
+				end = z.File[i+1].headerOffset
+			}
+			entries = append(entries, b[f.headerOffset:end])
+		}
+		return entries
+	}
+	want, got := raw(r, src.Bytes()), raw(out, dst.Bytes())[1:]
+	for i, f := range r.File {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Errorf("%s: local entry changed", f.Name)
+		}
+		g := out.File[i+1]
+		if g.headerOffset == f.headerOffset || !reflect.DeepEqual(g.FileHeader, f.FileHeader) {
+			t.Errorf("%s: central directory record %+v, want %+v at another offset", f.Name, g.FileHeader, f.FileHeader)
+		}
+	}
+}
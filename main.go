@@ -24,6 +24,7 @@ func main() {
 	label := flag.String("label", "WebViewDemo", "应用的标签 (WebViewDemo)")
 	packageName := flag.String("package", "com.parap.webview", "应用的包名 (com.parap.webview)")
 	output := flag.String("o", "webview.apk", "输出文件路径")
+	dryRun := flag.Bool("dry-run", false, "只打印将要进行的修改, 不写出文件")
 	// 解析命令行参数
 	flag.Parse()
 	args := flag.Args()
@@ -81,6 +82,12 @@ func main() {
 			Package:     *packageName,
 		}
 	}
+	if *dryRun {
+		plan, err := apkEditor.Plan()
+		checkErr(err)
+		checkErr(plan.Print(os.Stdout))
+		return
+	}
 	edit, err := apkEditor.Edit()
 	checkErr(err)
 	err = os.WriteFile(abs, edit, 0644)